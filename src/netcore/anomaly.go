@@ -0,0 +1,153 @@
+package netcore
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	anomalyTXTRate   = Flags.Int("anomalytxtrate", 120, "TXT and NULL queries per minute from one client that raise a tunneling alert (0 to disable).")
+	anomalyLabelRate = Flags.Int("anomalylabelrate", 30, "Queries per minute with long random-looking labels from one client that raise a tunneling alert (0 to disable).")
+	anomalyNXRate    = Flags.Int("anomalynxrate", 300, "NXDOMAIN answers per minute to one client that raise an alert (0 to disable).")
+)
+
+// anomalyAlertInterval is how long we stay quiet about a client after
+// alerting on it, so that one incident doesn't become a flood of alerts
+const anomalyAlertInterval = 10 * time.Minute
+
+// anomalyDetector flags clients whose traffic looks like DNS tunneling or
+// that are receiving bursts of NXDOMAIN answers. Counts are kept per client
+// for the current minute only.
+type anomalyDetector struct {
+	sync.Mutex
+	minute  int64
+	clients map[string]*anomalyCounts
+	alerted map[string]time.Time // client and alert type
+}
+
+type anomalyCounts struct {
+	txt, labels, nxdomain int
+}
+
+var anomalies = &anomalyDetector{
+	clients: make(map[string]*anomalyCounts),
+	alerted: make(map[string]time.Time),
+}
+
+// Observe accounts for a query from client and publishes an alert if it
+// pushes the client over a threshold
+func (d *anomalyDetector) Observe(client net.Addr, questions []dns.Question, nxdomain bool) {
+	ip := client.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	now := time.Now()
+
+	d.Lock()
+	defer d.Unlock()
+	if minute := now.Unix() / 60; minute != d.minute {
+		d.minute = minute
+		d.clients = make(map[string]*anomalyCounts)
+		for key, at := range d.alerted {
+			if now.Sub(at) > anomalyAlertInterval {
+				delete(d.alerted, key)
+			}
+		}
+	}
+	counts, ok := d.clients[ip]
+	if !ok {
+		if len(d.clients) >= maxClientsPerBucket {
+			return
+		}
+		counts = &anomalyCounts{}
+		d.clients[ip] = counts
+	}
+
+	for _, q := range questions {
+		if q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeNULL {
+			counts.txt++
+		}
+		if hasRandomLabel(q.Name) {
+			counts.labels++
+		}
+	}
+	if nxdomain {
+		counts.nxdomain++
+	}
+
+	if *anomalyTXTRate > 0 && counts.txt >= *anomalyTXTRate {
+		d.alert(ip, "dns.anomaly.tunneling", fmt.Sprintf("%d TXT/NULL queries in a minute", counts.txt), counts)
+	}
+	if *anomalyLabelRate > 0 && counts.labels >= *anomalyLabelRate {
+		d.alert(ip, "dns.anomaly.tunneling", fmt.Sprintf("%d queries with long random-looking labels in a minute", counts.labels), counts)
+	}
+	if *anomalyNXRate > 0 && counts.nxdomain >= *anomalyNXRate {
+		d.alert(ip, "dns.anomaly.nxdomain", fmt.Sprintf("%d NXDOMAIN answers in a minute", counts.nxdomain), counts)
+	}
+}
+
+// alert publishes an event about client unless we did so recently; the
+// caller must hold the lock
+func (d *anomalyDetector) alert(client, eventType, reason string, counts *anomalyCounts) {
+	key := client + " " + eventType
+	if _, recent := d.alerted[key]; recent {
+		return
+	}
+	d.alerted[key] = time.Now()
+	events.Publish(Event{
+		Type:     eventType,
+		Severity: "warning",
+		Message:  fmt.Sprintf("client %s: %s", client, reason),
+		Data: map[string]string{
+			"client":   client,
+			"txt":      strconv.Itoa(counts.txt),
+			"labels":   strconv.Itoa(counts.labels),
+			"nxdomain": strconv.Itoa(counts.nxdomain),
+		},
+	})
+}
+
+// hasRandomLabel reports whether name has a label that is long and has the
+// character distribution of encoded data rather than of a hostname: varied,
+// and either short of vowels or heavy on digits
+func hasRandomLabel(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		if len(label) < 32 || labelEntropy(label) < 3.5 {
+			continue
+		}
+		vowels, digits := 0, 0
+		for _, c := range strings.ToLower(label) {
+			switch {
+			case strings.ContainsRune("aeiou", c):
+				vowels++
+			case c >= '0' && c <= '9':
+				digits++
+			}
+		}
+		if float64(vowels) < 0.2*float64(len(label)) || float64(digits) > 0.3*float64(len(label)) {
+			return true
+		}
+	}
+	return false
+}
+
+// labelEntropy returns the Shannon entropy of the label in bits per character
+func labelEntropy(label string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range strings.ToLower(label) {
+		counts[c]++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(len(label))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,53 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestAnomalyThresholdCrossed(t *testing.T) {
+	d := &anomalyDetector{clients: make(map[string]*anomalyCounts), alerted: make(map[string]time.Time)}
+	received := events.Subscribe(16)
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.77"), Port: 5353}
+	txt := dns.Question{Name: "example.com.", Qtype: dns.TypeTXT, Qclass: dns.ClassINET}
+
+	// Multi-question queries can take the count past the threshold
+	// without ever landing on it
+	batch := make([]dns.Question, 7)
+	for i := range batch {
+		batch[i] = txt
+	}
+	for sent := 0; sent < *anomalyTXTRate+len(batch); sent += len(batch) {
+		d.Observe(client, batch, false)
+	}
+	for {
+		select {
+		case e := <-received:
+			if e.Type == "dns.anomaly.tunneling" && e.Data["client"] == "192.0.2.77" {
+				return
+			}
+		default:
+			t.Fatalf("no tunneling alert after more than %d TXT queries", *anomalyTXTRate)
+		}
+	}
+}
+
+func TestHasRandomLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		random bool
+	}{
+		{"www.example.com.", false},
+		{"printer-3rd-floor-east-wing-colour.example.com.", false},
+		{"a3f9c2e17b0d4e8f9a6c5b2d1e0f7a8b9c4d.t.example.com.", true},
+		{"MZXW6YTBOI2GKZLTMVZWC3TUN5ZGKY3PNVQWY.t.example.com.", true},
+	}
+	for _, test := range tests {
+		if random := hasRandomLabel(test.name); random != test.random {
+			t.Errorf("hasRandomLabel(%q) = %v, want %v", test.name, random, test.random)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package netcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	announceCommand = Flags.String("announce", "", "Shell command run when this instance starts serving, such as one that makes gobgp or exabgp announce an anycast address.")
+	withdrawCommand = Flags.String("withdraw", "", "Shell command run when this instance stops serving or exits, such as one that withdraws an anycast announcement.")
+	announceURL     = Flags.String("announceurl", "", "URL POSTed to, as JSON, when this instance starts serving.")
+	withdrawURL     = Flags.String("withdrawurl", "", "URL POSTed to, as JSON, when this instance stops serving or exits.")
+	anycastInterval = Flags.Duration("anycastinterval", 5*time.Second, "How often the DNS listener and the backend are probed when -announce, -withdraw or their URLs are set.")
+	anycastHold     = Flags.Duration("anycasthold", 15*time.Second, "How long a change between serving and not serving must last before the hooks run, so that a brief failure does not flap the route.")
+)
+
+// anycastHook is what the hooks are told of a change
+type anycastHook struct {
+	Action   string            `json:"action"` // announce or withdraw
+	Hostname string            `json:"hostname"`
+	State    string            `json:"state"`
+	Reasons  map[string]string `json:"reasons,omitempty"`
+}
+
+// anycastHooksSet reports whether any hook is configured
+func anycastHooksSet() bool {
+	return *announceCommand != "" || *withdrawCommand != "" || *announceURL != "" || *withdrawURL != ""
+}
+
+// anycastSetup probes this instance and runs the announce hooks while it is
+// serving and the withdraw hooks once it is not, or when it is told to exit.
+// Degraded instances are still serving, so they stay announced.
+func anycastSetup(cfg *Config) {
+	if !anycastHooksSet() {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Printf("Exiting on %s\n", sig)
+		anycastWithdraw(cfg)
+		requestStop(nil)
+	}()
+
+	go func() {
+		announced, first := false, true
+		var since time.Time // when the serving state started to differ from announced
+		for {
+			probeServing(cfg)
+			state, reasons := health.State()
+			serving := state != Unhealthy
+			if serving == announced && !first {
+				since = time.Time{}
+			} else {
+				if since.IsZero() {
+					since = time.Now()
+				}
+				// Announce as soon as we first serve; any other change must
+				// last before the hooks run
+				if first && serving || time.Since(since) >= *anycastHold {
+					action := "withdraw"
+					if serving {
+						action = "announce"
+					}
+					if runAnycastHooks(cfg, action, state, reasons) {
+						announced, first, since = serving, false, time.Time{}
+					}
+				}
+			}
+			time.Sleep(*anycastInterval)
+		}
+	}()
+}
+
+// anycastWithdraw runs the withdraw hooks, if there are any, before exiting
+func anycastWithdraw(cfg *Config) {
+	if !anycastHooksSet() {
+		return
+	}
+	state, reasons := health.State()
+	runAnycastHooks(cfg, "withdraw", state, reasons)
+}
+
+// probeServing checks that the DNS listener answers and that the backend can
+// be read, and records the results in the health registry
+func probeServing(cfg *Config) {
+	if err := probeDNS(cfg, *dnslisten, *anycastInterval); err != nil {
+		health.Set("dns listener", Unhealthy, err.Error())
+	} else {
+		health.Set("dns listener", Healthy, "")
+	}
+
+	if _, err := cfg.db.HasDNS(cfg.Domain(), "SOA"); err != nil && ErrorKind(err) != ErrNotFound {
+		health.Set("backend", Unhealthy, err.Error())
+	} else {
+		health.Set("backend", Healthy, "")
+	}
+}
+
+// probeDNS checks that the DNS listener on addr answers a query, whatever
+// the answer
+func probeDNS(cfg *Config, addr string, timeout time.Duration) error {
+	if host, port, err := net.SplitHostPort(addr); err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		addr = "127.0.0.1:" + port
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(cfg.Domain()), dns.TypeSOA)
+	client := dns.Client{Net: "udp", DialTimeout: timeout, ReadTimeout: timeout}
+	_, _, err := client.Exchange(query, addr)
+	return err
+}
+
+// runAnycastHooks runs the command and calls the URL for action, and reports
+// whether they all succeeded; failed hooks are tried again at the next probe
+func runAnycastHooks(cfg *Config, action string, state HealthState, reasons map[string]string) bool {
+	command, url := *announceCommand, *announceURL
+	if action == "withdraw" {
+		command, url = *withdrawCommand, *withdrawURL
+	}
+	logger.Printf("Anycast %s (%s)\n", action, state)
+	severity := "info"
+	if action == "withdraw" {
+		severity = "critical"
+	}
+	events.Publish(Event{
+		Type:     "anycast." + action,
+		Severity: severity,
+		Message:  fmt.Sprintf("%s is %s: %s", cfg.Hostname(), state, describeReasons(reasons)),
+		Data:     map[string]string{"hostname": cfg.Hostname(), "state": state.String()},
+	})
+
+	ok := true
+	if command != "" {
+		cmd := shellCommand(command)
+		cmd.Env = append(os.Environ(), "NETCORE_ACTION="+action, "NETCORE_STATE="+state.String(), "NETCORE_REASONS="+describeReasons(reasons))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logger.Printf("Anycast %s command failed: %s: %s\n", action, err, strings.TrimSpace(string(output)))
+			ok = false
+		}
+	}
+	if url != "" {
+		body, _ := json.Marshal(anycastHook{Action: action, Hostname: cfg.Hostname(), State: state.String(), Reasons: reasons})
+		client := http.Client{Timeout: 10 * time.Second}
+		if err := postWebhook(&client, url, body); err != nil {
+			logger.Printf("Anycast %s URL %s failed: %s\n", action, url, err)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// describeReasons lists the reasons of the health registry on one line
+func describeReasons(reasons map[string]string) string {
+	if len(reasons) == 0 {
+		return "all components healthy"
+	}
+	names := make([]string, 0, len(reasons))
+	for name := range reasons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+" "+reasons[name])
+	}
+	return strings.Join(parts, "; ")
+}
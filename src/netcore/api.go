@@ -0,0 +1,265 @@
+package netcore
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var apilisten = Flags.String("apilisten", "127.0.0.1:8053", "Listen address for the HTTP admin API and health checks (empty to disable).")
+var apitoken = Flags.String("apitoken", "", "Bearer token granting full access to the HTTP admin API; defaults to $NETCORE_APITOKEN.")
+
+// apiIdentity is the caller of an API request, as established by its token
+type apiIdentity struct {
+	Admin  bool
+	Tenant string
+}
+
+// apiHandler is an API endpoint that requires an authenticated caller
+type apiHandler func(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request)
+
+// apiService serves the HTTP admin API, health checks and metrics
+type apiService struct {
+	serviceStatus
+	cfg  *Config
+	addr string
+	mux  *http.ServeMux
+}
+
+// NewAPIService serves the HTTP admin API on addr. Expvar metrics are
+// published at /debug/vars by passing /debug/ on to the default mux.
+func NewAPIService(cfg *Config, addr string) Service {
+	return &apiService{cfg: cfg, addr: addr, mux: apiMux(cfg)}
+}
+
+func (s *apiService) Name() string { return "api" }
+
+func (s *apiService) Start() (<-chan error, error) {
+	l, err := listeners.Listen("api", "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	exit := make(chan error, 1)
+	go func() {
+		exit <- http.Serve(l, s.mux)
+	}()
+	return s.serving(exit, s.close), nil
+}
+
+// Stop stops accepting connections; requests in flight are not waited for,
+// since Go 1.5 cannot shut an HTTP server down gracefully
+func (s *apiService) Stop(deadline time.Time) error {
+	s.stop()
+	s.close()
+	return nil
+}
+
+func (s *apiService) close() {
+	listeners.release("api")
+}
+
+// apiMux routes the requests of the admin API
+func apiMux(cfg *Config) *http.ServeMux {
+	if *apitoken == "" {
+		*apitoken = os.Getenv("NETCORE_APITOKEN")
+	}
+	if *apitoken == "" {
+		logger.Println("HTTP API has no admin token; only tenant tokens will be accepted.")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", http.DefaultServeMux)
+	mux.HandleFunc("/debug/pprof/", apiAuth(cfg, apiPprof))
+	mux.HandleFunc("/healthz", apiHealthz)
+	mux.HandleFunc("/readyz", apiReadyz)
+	mux.HandleFunc("/api/openapi.json", apiOpenAPI)
+	mux.HandleFunc("/ui/", webUI)
+	mux.HandleFunc("/api/dns/soa/", apiAuth(cfg, apiDNSSOA))
+	mux.HandleFunc("/api/dns/zones/", apiAuth(cfg, apiDNSZones))
+	mux.HandleFunc("/api/tenants/", apiAuth(cfg, apiTenants))
+	mux.HandleFunc("/api/audit", apiAuth(cfg, apiAudit))
+	mux.HandleFunc("/api/clients/top", apiAuth(cfg, apiClientsTop))
+	mux.HandleFunc("/api/dns/querylog", apiAuth(cfg, apiQueryLog))
+	mux.HandleFunc("/api/dns/trace", apiAuth(cfg, apiDNSTrace))
+	mux.HandleFunc("/api/dns/resolverconf", apiAuth(cfg, apiResolverConf))
+	mux.HandleFunc("/api/dns/zonestats", apiAuth(cfg, apiZoneStats))
+	mux.HandleFunc("/api/dns/services", apiAuth(cfg, apiServices))
+	mux.HandleFunc("/api/dns/srv", apiAuth(cfg, apiSRVWeights))
+	mux.HandleFunc("/api/dns/profiles/", apiAuth(cfg, apiDNSProfiles))
+	mux.HandleFunc("/api/dns/incidents", apiAuth(cfg, apiIncidents))
+	mux.HandleFunc("/api/dnssec/anchors/", apiAuth(cfg, apiTrustAnchors))
+	mux.HandleFunc("/api/dnssec/keys/", apiAuth(cfg, apiDNSSECKeys))
+	mux.HandleFunc("/api/dnssec/zones/", apiAuth(cfg, apiDNSSECZones))
+	mux.HandleFunc("/api/dhcp/leases", apiAuth(cfg, apiDHCPLeases))
+	mux.HandleFunc("/api/dhcp/bindings", apiAuth(cfg, apiDHCPBindings))
+	mux.HandleFunc("/api/dhcp/pools", apiAuth(cfg, apiDHCPPools))
+	mux.HandleFunc("/api/dhcp/authorize", apiAuth(cfg, apiDHCPAuthorize))
+	mux.HandleFunc("/api/dhcp/subnets/", apiAuth(cfg, apiDHCPSubnets))
+	mux.HandleFunc("/api/dhcp/reservations/", apiAuth(cfg, apiDHCPReservations))
+	mux.HandleFunc("/api/config", apiAuth(cfg, apiConfig))
+	mux.HandleFunc("/api/fleet", apiAuth(cfg, apiFleet))
+	mux.HandleFunc("/api/inventory", apiAuth(cfg, apiInventory))
+	mux.HandleFunc("/api/drain", apiAuth(cfg, apiDrain))
+	mux.HandleFunc("/api/backups/", apiAuth(cfg, apiBackups))
+	mux.HandleFunc("/api/schema", apiAuth(cfg, apiSchema))
+	return mux
+}
+
+// apiHealthz reports the overall health of this instance. Degraded instances
+// are still serving and report 200 OK; unhealthy instances report 503.
+func apiHealthz(w http.ResponseWriter, r *http.Request) {
+	state, reasons := health.State()
+	status := http.StatusOK
+	if state == Unhealthy {
+		status = http.StatusServiceUnavailable
+	}
+	apiWriteJSON(w, status, map[string]interface{}{
+		"status":  state.String(),
+		"reasons": reasons,
+	})
+}
+
+// apiReadyz reports whether this instance should take traffic, as /healthz
+// does, along with the state of the circuit breaker in front of etcd: while
+// it is open, answers come from stale data
+func apiReadyz(w http.ResponseWriter, r *http.Request) {
+	state, reasons := health.State()
+	status := http.StatusOK
+	if state == Unhealthy {
+		status = http.StatusServiceUnavailable
+	}
+	body := map[string]interface{}{
+		"ready":   state != Unhealthy,
+		"status":  state.String(),
+		"reasons": reasons,
+	}
+	if etcdBreaker != nil {
+		body["etcd_breaker"] = etcdBreaker.State()
+	}
+	apiWriteJSON(w, status, body)
+}
+
+func apiWriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Printf("HTTP API response encoding failed: %s\n", err)
+	}
+}
+
+func apiWriteError(w http.ResponseWriter, status int, err error) {
+	body := map[string]string{"error": err.Error()}
+	if v, ok := err.(*ValidationError); ok && v.Field != "" {
+		body["field"] = v.Field
+	}
+	apiWriteJSON(w, status, body)
+}
+
+// apiStatus is the HTTP status for an error from the database, by its kind
+func apiStatus(err error) int {
+	if _, ok := err.(*ValidationError); ok {
+		return http.StatusBadRequest
+	}
+	switch ErrorKind(err) {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrConflict:
+		return http.StatusConflict
+	case ErrBackendUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// apiAuth identifies the caller by the bearer token in the request, refusing
+// requests without a valid token, before passing them to h
+func apiAuth(cfg *Config, h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			publishAuthFailure(r, "missing bearer token")
+			apiWriteError(w, http.StatusUnauthorized, errors.New("missing bearer token"))
+			return
+		}
+		if *apitoken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(*apitoken)) == 1 {
+			h(cfg, apiIdentity{Admin: true}, w, r)
+			return
+		}
+		tenant, err := cfg.db.TenantForToken(token)
+		if err != nil {
+			publishAuthFailure(r, "invalid bearer token")
+			apiWriteError(w, http.StatusUnauthorized, errors.New("invalid bearer token"))
+			return
+		}
+		h(cfg, apiIdentity{Tenant: tenant}, w, r)
+	}
+}
+
+// apiAuthFailures throttles api.auth.failure events to one per source
+// address a minute
+var apiAuthFailures = newEventThrottle(time.Minute)
+
+// publishAuthFailure publishes an api.auth.failure event about r, which was
+// refused for reason
+func publishAuthFailure(r *http.Request, reason string) {
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+	if !apiAuthFailures.Allow(source) {
+		return
+	}
+	events.Publish(Event{
+		Type:     "api.auth.failure",
+		Severity: "warning",
+		Message:  fmt.Sprintf("%s %s from %s: %s", r.Method, r.URL.Path, source, reason),
+		Data:     map[string]string{"source": source, "method": r.Method, "path": r.URL.Path, "reason": reason},
+	})
+}
+
+// canManageZone reports whether the caller may view and change the zone
+func (id apiIdentity) canManageZone(cfg *Config, zone string) bool {
+	if id.Admin {
+		return true
+	}
+	tenant, err := cfg.db.TenantForZone(zone)
+	return err == nil && tenant == id.Tenant
+}
+
+// canManageName reports whether the caller may change name, within zone.
+// Rights on zone are not enough: a zone below it with an owner of its own
+// may belong to another tenant, and a name belongs to the closest enclosing
+// zone that has an owner, as TenantForZone finds it.
+func (id apiIdentity) canManageName(cfg *Config, zone, name string) bool {
+	return id.canManageZone(cfg, zone) && id.canManageZone(cfg, name)
+}
+
+// manageableRecords returns those of records, read from zone, that the
+// caller may manage. The database lists the zones below zone with it,
+// and those that belong to another tenant are none of the caller's
+// business.
+func (id apiIdentity) manageableRecords(cfg *Config, zone string, records []DNSRecord) []DNSRecord {
+	if id.Admin {
+		return records
+	}
+	may := make(map[string]bool) // by name, so that each is looked up once
+	var kept []DNSRecord
+	for _, record := range records {
+		allowed, ok := may[record.Name]
+		if !ok {
+			allowed = id.canManageName(cfg, zone, record.Name)
+			may[record.Name] = allowed
+		}
+		if allowed {
+			kept = append(kept, record)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,115 @@
+package netcore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCanManageName(t *testing.T) {
+	db := NewMemoryDB()
+	cfg := &Config{db: db}
+	if err := db.AssignZone("parent", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AssignZone("team", "team.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	parent, team := apiIdentity{Tenant: "parent"}, apiIdentity{Tenant: "team"}
+
+	tests := []struct {
+		id         apiIdentity
+		zone, name string
+		may        bool
+	}{
+		{parent, "example.com", "www.example.com", true},
+		{parent, "example.com", "www.team.example.com", false},
+		{parent, "example.com", "team.example.com", false},
+		{team, "team.example.com", "www.team.example.com", true},
+		{team, "example.com", "www.example.com", false},
+		{apiIdentity{Admin: true}, "example.com", "www.team.example.com", true},
+	}
+	for _, test := range tests {
+		if may := test.id.canManageName(cfg, test.zone, test.name); may != test.may {
+			t.Errorf("%+v canManageName(%s, %s) = %v, want %v", test.id, test.zone, test.name, may, test.may)
+		}
+	}
+}
+
+func TestTenantZoneReads(t *testing.T) {
+	db := NewMemoryDB()
+	cfg := &Config{db: db}
+	if err := db.AssignZone("parent", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AssignZone("team", "team.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	err := db.ApplyDNSChanges("test", []DNSChange{
+		{Op: "add", Record: DNSRecord{Name: "www.example.com", Type: "A", Value: "192.0.2.1"}},
+		{Op: "add", Record: DNSRecord{Name: "www.team.example.com", Type: "A", Value: "192.0.2.2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := apiIdentity{Tenant: "parent"}
+
+	serve := func(id apiIdentity, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		apiDNSZones(cfg, id, w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	for _, path := range []string{"/api/dns/zones/example.com/records", "/api/dns/zones/example.com/check", "/api/dns/zones/example.com/history"} {
+		w := serve(parent, "GET", path, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: %d %s", path, w.Code, w.Body)
+		}
+		if strings.Contains(w.Body.String(), "team.example.com") {
+			t.Errorf("GET %s shows the records of another tenant: %s", path, w.Body)
+		}
+	}
+	if w := serve(apiIdentity{Admin: true}, "GET", "/api/dns/zones/example.com/records", ""); !strings.Contains(w.Body.String(), "www.team.example.com") {
+		t.Errorf("GET records as an admin: %s, want every record", w.Body)
+	}
+
+	if err := db.AssignZone("parent", "example.net"); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(parent, "POST", "/api/dns/zones/example.com/clone", `{"to": "example.net"}`); w.Code != http.StatusOK {
+		t.Fatalf("clone: %d %s", w.Code, w.Body)
+	}
+	records, err := db.ListDNSZone("example.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Name != "www.example.net" {
+		t.Errorf("clone copied %v, want www.example.net only", records)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	cfg := &Config{}
+	serve := func(id apiIdentity, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		apiPprof(cfg, id, w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	if w := serve(apiIdentity{Tenant: "team"}, "/debug/pprof/heap"); w.Code != http.StatusForbidden {
+		t.Errorf("a tenant profiling: %d, want 403", w.Code)
+	}
+	if w := serve(apiIdentity{Admin: true}, "/debug/pprof/goroutine?debug=1"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine profile") {
+		t.Errorf("the admin profiling goroutines: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(apiIdentity{Admin: true}, "/debug/pprof/profile?seconds=1"); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("the admin profiling the CPU: %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if w := serve(apiIdentity{Admin: true}, "/debug/pprof/nothing"); w.Code != http.StatusNotFound {
+		t.Errorf("an unknown profile: %d, want 404", w.Code)
+	}
+	// Nothing is served to anyone on the default mux of programs embedding
+	// netcore
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", "/debug/pprof/", nil)); pattern != "" {
+		t.Errorf("http.DefaultServeMux serves %s", pattern)
+	}
+}
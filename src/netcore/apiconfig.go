@@ -0,0 +1,28 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// apiConfig describes every recognized setting and its value for this
+// instance, and requires the admin token.
+//
+//	GET /api/config
+func apiConfig(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may read the configuration"))
+		return
+	}
+	if r.Method != "GET" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	explained, err := cfg.db.ExplainConfig(cfg)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, explained)
+}
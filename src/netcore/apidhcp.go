@@ -0,0 +1,202 @@
+package netcore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// apiDHCPLeases manages DHCP leases, and requires the admin token.
+//
+//	GET  /api/dhcp/leases?format=json|csv|isc  export leases and reservations
+//	GET  /api/dhcp/leases?selector=env=prod    only the reservations with matching labels
+//	POST /api/dhcp/leases                      import a list of leases and reservations
+func apiDHCPLeases(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may manage DHCP leases"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		selector, err := ParseLabelSelector(r.URL.Query().Get("selector"))
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		leases, err := cfg.db.ListLeases()
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		leases = selectLeases(leases, selector)
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			if leases == nil {
+				leases = []DHCPLease{}
+			}
+			apiWriteJSON(w, http.StatusOK, leases)
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			writeLeasesCSV(w, leases)
+		case "isc":
+			w.Header().Set("Content-Type", "text/plain")
+			writeLeasesISC(w, leases)
+		default:
+			apiWriteError(w, http.StatusBadRequest, fmt.Errorf("format must be json, csv or isc, not %q", format))
+		}
+
+	case "POST":
+		var leases []DHCPLease
+		if err := json.NewDecoder(r.Body).Decode(&leases); err != nil {
+			apiWriteError(w, http.StatusBadRequest, fmt.Errorf("bad lease list: %s", err))
+			return
+		}
+		imported, expired := 0, 0
+		failures := []string{}
+		for _, lease := range leases {
+			if lease.Expires != nil && !lease.Expires.After(time.Now()) {
+				expired++
+				continue
+			}
+			if err := cfg.db.ImportLease(id.String(), lease); err != nil {
+				failures = append(failures, err.Error())
+				continue
+			}
+			imported++
+		}
+		apiWriteJSON(w, http.StatusOK, map[string]interface{}{"imported": imported, "expired": expired, "errors": failures})
+
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// apiDHCPBindings lists the leases of clients behind relay agents that
+// report their switch port, for switch ACL automation. It requires the
+// admin token.
+//
+//	GET /api/dhcp/bindings?format=json|csv
+func apiDHCPBindings(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may list DHCP bindings"))
+		return
+	}
+	if r.Method != "GET" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	bindings, err := snoopingBindings(cfg.db)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		apiWriteJSON(w, http.StatusOK, bindings)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		writeLeasesCSV(w, bindings)
+	default:
+		apiWriteError(w, http.StatusBadRequest, fmt.Errorf("format must be json or csv, not %q", format))
+	}
+}
+
+// apiDHCPPools shows how much of the pool of each zone is leased or
+// reserved, and requires the admin token.
+//
+//	GET /api/dhcp/pools
+func apiDHCPPools(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may view DHCP pools"))
+		return
+	}
+	if r.Method != "GET" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	usage, err := dhcpPoolUsage(cfg.db)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, usage)
+}
+
+// selectLeases keeps the leases whose labels match selector. As only
+// reservations have labels, a selector with an equality or existence term
+// leaves out every dynamic lease.
+func selectLeases(leases []DHCPLease, selector LabelSelector) []DHCPLease {
+	if len(selector) == 0 {
+		return leases
+	}
+	selected := []DHCPLease{}
+	for _, lease := range leases {
+		if selector.Matches(lease.Labels) {
+			selected = append(selected, lease)
+		}
+	}
+	return selected
+}
+
+// apiDHCPAuthorize lets a client through the captive portal, or sends it
+// back there. The client is named by MAC, or by the address it leased, which
+// is what a portal usually knows. It requires the admin token.
+//
+//	POST   /api/dhcp/authorize  {"mac": "..."} or {"ip": "..."}
+//	DELETE /api/dhcp/authorize  {"mac": "..."} or {"ip": "..."}
+func apiDHCPAuthorize(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may authorize DHCP clients"))
+		return
+	}
+	if r.Method != "POST" && r.Method != "DELETE" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	var client struct {
+		MAC string `json:"mac"`
+		IP  string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		apiWriteError(w, http.StatusBadRequest, fmt.Errorf("bad client: %s", err))
+		return
+	}
+	var mac net.HardwareAddr
+	switch {
+	case client.MAC != "":
+		var err error
+		if mac, err = net.ParseMAC(client.MAC); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	case client.IP != "":
+		ip := net.ParseIP(client.IP)
+		if ip == nil {
+			apiWriteError(w, http.StatusBadRequest, fmt.Errorf("bad IP address %q", client.IP))
+			return
+		}
+		entry, err := cfg.db.GetIP(ip)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		mac = entry.MAC
+	default:
+		apiWriteError(w, http.StatusBadRequest, errors.New("expected a mac or an ip"))
+		return
+	}
+
+	authorized := r.Method == "POST"
+	if err := cfg.db.AuthorizeClient(id.String(), mac, authorized); err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if authorized {
+		captivePortal.ReleaseMAC(mac)
+	}
+	apiWriteJSON(w, http.StatusOK, map[string]interface{}{"mac": mac.String(), "authorized": authorized})
+}
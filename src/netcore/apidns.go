@@ -0,0 +1,314 @@
+package netcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// apiDNSSOA shows (GET) or updates (PUT) the SOA settings of the zone named
+// in the path, /api/dns/soa/<zone>. Updates take a JSON object of settings
+// to change, such as {"refresh": 7200, "mbox": "hostmaster.example.com"} or
+// {"secondaries": "192.0.2.10, 192.0.2.11"}.
+func apiDNSSOA(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	zone := cleanFQDN(strings.TrimPrefix(r.URL.Path, "/api/dns/soa/"))
+	if !validDomainName(zone) {
+		apiWriteError(w, http.StatusBadRequest, fmt.Errorf("invalid zone name %q", zone))
+		return
+	}
+	if !id.canManageZone(cfg, zone) {
+		apiWriteError(w, http.StatusForbidden, fmt.Errorf("zone %s does not belong to tenant %q", zone, id.Tenant))
+		return
+	}
+
+	entry, err := cfg.db.GetDNS(zone, "SOA")
+	found := err == nil
+	if err != nil && ErrorKind(err) != ErrNotFound {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		if !found {
+			apiWriteError(w, http.StatusNotFound, fmt.Errorf("no SOA for zone %s", zone))
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, soaSettings(entry))
+
+	case "PUT":
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		var body map[string]interface{}
+		if err := decoder.Decode(&body); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		meta := make(map[string]string, len(body))
+		for k, v := range body {
+			meta[k] = fmt.Sprint(v)
+		}
+		entry, err = setZoneSOA(cfg, id, zone, entry, meta)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, soaSettings(entry))
+
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		apiWriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// setZoneSOA changes the SOA settings of zone, whose SOA entry is current or
+// nil if the zone does not exist yet, and returns the updated entry
+func setZoneSOA(cfg *Config, id apiIdentity, zone string, current *DNSEntry, meta map[string]string) (*DNSEntry, error) {
+	if current == nil && (meta["ns"] == "" || meta["mbox"] == "") {
+		return nil, invalid("", "ns and mbox are required to create a zone")
+	}
+	asciiSOAMeta(meta)
+	// Validate the result of the change, not just the change itself
+	merged := make(map[string]string)
+	if current != nil {
+		for k, v := range current.Meta {
+			if _, ok := soaTimerDefaults[k]; ok {
+				merged[k] = v
+			}
+		}
+	}
+	for k, v := range meta {
+		merged[k] = v
+	}
+	if err := validateSOAMeta(merged); err != nil {
+		return nil, invalid("", "%s", err)
+	}
+	if err := cfg.db.SetDNSMeta(zone, "SOA", meta); err != nil {
+		return nil, err
+	}
+	for k, v := range meta {
+		old := ""
+		if current != nil {
+			old = current.Meta[k]
+		}
+		auditChange(cfg.db, id.String(), "dns", zone+" SOA "+k, "set", old, v)
+	}
+	return cfg.db.GetDNS(zone, "SOA")
+}
+
+// soaSettings returns the effective SOA settings of a zone, with defaults
+// filled in for any timers the zone does not set
+func soaSettings(entry *DNSEntry) map[string]string {
+	settings := map[string]string{
+		"ns":     entry.Meta["ns"],
+		"mbox":   entry.Meta["mbox"],
+		"serial": entry.Meta["serial"],
+	}
+	if secondaries := entry.Meta["secondaries"]; secondaries != "" {
+		settings["secondaries"] = secondaries
+	}
+	for name := range soaTimerDefaults {
+		settings[name] = strconv.FormatUint(uint64(soaTimer(entry, name)), 10)
+	}
+	return settings
+}
+
+// apiDNSZones manages the records of a zone as a whole.
+//
+//	GET            /api/dns/zones/                             list the zones the caller may manage
+//	GET|PUT|DELETE /api/dns/zones/<zone>                       the zone, as in apiDNSZone
+//	GET|PUT|DELETE /api/dns/zones/<zone>/rrsets/<name>/<type>  a record set, as in apiDNSRRSet
+//	GET  /api/dns/zones/<zone>/records  list the zone's static records, or with
+//	                                    ?selector=env=prod,team=net those whose
+//	                                    entry has matching labels
+//	POST /api/dns/zones/<zone>/records  apply a list of changes, all or none
+//	POST /api/dns/zones/<zone>/clone    copy the zone's records to another
+//
+// Changes are given as [{"op": "add", "record": {...}}, ...]. Cloning takes
+// {"to": "<zone>"}, which must not already have an SOA. Names may be given in
+// their Unicode form, and are listed that way with ?unicode=true.
+func apiDNSZones(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dns/zones/"), "/"), "/")
+	zone := cleanFQDN(parts[0])
+	if zone == "" && len(parts) == 1 && r.Method == "GET" {
+		apiListDNSZones(cfg, id, w)
+		return
+	}
+	isRRSet := len(parts) == 4 && parts[1] == "rrsets"
+	if len(parts) > 2 && !isRRSet || !validDomainName(zone) {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	if !id.canManageZone(cfg, zone) {
+		apiWriteError(w, http.StatusForbidden, fmt.Errorf("zone %s does not belong to tenant %q", zone, id.Tenant))
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		apiDNSZone(cfg, id, w, r, zone)
+
+	case isRRSet:
+		apiDNSRRSet(cfg, id, w, r, zone, parts[2], parts[3])
+
+	case parts[1] == "records" && r.Method == "GET":
+		selector, err := ParseLabelSelector(r.URL.Query().Get("selector"))
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		records, err := cfg.db.ListDNSZone(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		records = id.manageableRecords(cfg, zone, records)
+		selected := []DNSRecord{}
+		for _, record := range records {
+			if !selector.Matches(record.Labels) {
+				continue
+			}
+			if r.URL.Query().Get("unicode") == "true" {
+				record = unicodeRecord(record)
+			}
+			selected = append(selected, record)
+		}
+		apiWriteJSON(w, http.StatusOK, selected)
+
+	case parts[1] == "records" && r.Method == "POST":
+		var changes []DNSChange
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		apiApplyDNSChanges(cfg, id, w, zone, changes, r.URL.Query().Get("force") == "true")
+
+	case parts[1] == "check" && r.Method == "GET":
+		records, err := cfg.db.ListDNSZone(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		records = id.manageableRecords(cfg, zone, records)
+		problems := checkZone(zone, records)
+		if problems == nil {
+			problems = []ZoneProblem{}
+		}
+		if r.URL.Query().Get("unicode") == "true" {
+			for i := range problems {
+				problems[i].Name = idnaToUnicode(problems[i].Name)
+			}
+		}
+		apiWriteJSON(w, http.StatusOK, problems)
+
+	case parts[1] == "history" && r.Method == "GET":
+		apiDNSHistory(cfg, id, w, r, zone)
+
+	case parts[1] == "restore" && r.Method == "POST":
+		apiDNSRestore(cfg, id, w, r, zone)
+
+	case parts[1] == "clone" && r.Method == "POST":
+		var body struct {
+			To string `json:"to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		to := cleanFQDN(body.To)
+		if !validDomainName(to) || to == zone {
+			apiWriteError(w, http.StatusBadRequest, fmt.Errorf("invalid destination zone %q", body.To))
+			return
+		}
+		if !id.canManageZone(cfg, to) {
+			apiWriteError(w, http.StatusForbidden, fmt.Errorf("zone %s does not belong to tenant %q", to, id.Tenant))
+			return
+		}
+		if found, err := cfg.db.HasDNS(to, "SOA"); err == nil && found {
+			apiWriteError(w, http.StatusConflict, fmt.Errorf("zone %s already exists", to))
+			return
+		}
+		records, err := cfg.db.ListDNSZone(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		records = id.manageableRecords(cfg, zone, records)
+		changes := make([]DNSChange, 0, len(records))
+		for _, record := range records {
+			record.Name = strings.TrimSuffix(record.Name, zone) + to
+			changes = append(changes, DNSChange{Op: "add", Record: record})
+		}
+		apiApplyDNSChanges(cfg, id, w, to, changes, r.URL.Query().Get("force") == "true")
+
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// apiListDNSZones lists the zones that have an SOA, or those of the
+// caller's tenant
+func apiListDNSZones(cfg *Config, id apiIdentity, w http.ResponseWriter) {
+	zones := []string{}
+	if id.Admin {
+		records, err := cfg.db.ListDNSZone("")
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		for _, record := range records {
+			if record.Type == "SOA" {
+				zones = append(zones, record.Name)
+			}
+		}
+	} else {
+		tenantZones, err := cfg.db.ListTenantZones(id.Tenant)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		zones = append(zones, tenantZones...)
+	}
+	sort.Strings(zones)
+	apiWriteJSON(w, http.StatusOK, zones)
+}
+
+// apiApplyDNSChanges validates every change before applying any of them.
+// Unless forced, changes that would add errors to the zone, as checkZone
+// finds them, are refused with the list of those errors.
+func apiApplyDNSChanges(cfg *Config, id apiIdentity, w http.ResponseWriter, zone string, changes []DNSChange, force bool) {
+	for i := range changes {
+		if err := changes[i].validate(zone); err != nil {
+			v := err.(*ValidationError)
+			apiWriteError(w, http.StatusBadRequest, &ValidationError{Field: fmt.Sprintf("changes[%d].record.%s", i, v.Field), Reason: v.Reason})
+			return
+		}
+		if name := changes[i].Record.Name; !id.canManageName(cfg, zone, name) {
+			apiWriteError(w, http.StatusForbidden, fmt.Errorf("%s belongs to a zone below %s that is not of tenant %q", name, zone, id.Tenant))
+			return
+		}
+	}
+	if !force {
+		records, err := cfg.db.ListDNSZone(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		records = id.manageableRecords(cfg, zone, records)
+		if problems := newZoneErrors(zone, records, changes); len(problems) > 0 {
+			apiWriteJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error":    fmt.Sprintf("the changes would break zone %s: %s; add force=true to apply them anyway", zone, problems[0]),
+				"problems": problems,
+			})
+			return
+		}
+	}
+	if err := cfg.db.ApplyDNSChanges(id.String(), changes); err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, map[string]interface{}{"zone": zone, "applied": len(changes)})
+}
@@ -0,0 +1,311 @@
+package netcore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// apiTrustAnchors manages the DNSSEC trust anchors, whose states the leader
+// follows through key rollovers. It requires the admin token.
+//
+//	GET    /api/dnssec/anchors/                  list the anchors
+//	POST   /api/dnssec/anchors/                  add {"record": "<DNSKEY or DS record>"}
+//	DELETE /api/dnssec/anchors/<zone>/<key tag>  remove an anchor; the root zone is "root"
+func apiTrustAnchors(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may manage trust anchors"))
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/api/dnssec/anchors/")
+
+	switch {
+	case rest == "" && r.Method == "GET":
+		anchors, err := cfg.db.ListTrustAnchors()
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, anchors)
+
+	case rest == "" && r.Method == "POST":
+		var body struct {
+			Record string `json:"record"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		anchor, err := ParseTrustAnchor(body.Record)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if err := cfg.db.SaveTrustAnchor(id.String(), *anchor); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusCreated, anchor)
+
+	case r.Method == "DELETE":
+		i := strings.LastIndex(rest, "/")
+		keyTag, err := strconv.ParseUint(rest[i+1:], 10, 16)
+		if i <= 0 || err != nil {
+			apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+			return
+		}
+		zone := dns.Fqdn(rest[:i])
+		if zone == "root." {
+			zone = "."
+		}
+		if err := cfg.db.DeleteTrustAnchor(id.String(), zone, uint16(keyTag)); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// apiDNSSECKey is a key as the API shows it: without its private half, and
+// with the DS record the parent zone should have for key signing keys
+type apiDNSSECKey struct {
+	DNSSECKey
+	DS string `json:"ds,omitempty"`
+}
+
+func showDNSSECKey(key DNSSECKey) apiDNSSECKey {
+	shown := apiDNSSECKey{DNSSECKey: key}
+	shown.Private = ""
+	if ds := key.dsDigest(); ds != nil && key.Role == KeyKSK {
+		shown.DS = ds.String()
+	}
+	return shown
+}
+
+// apiDNSSECKeys signs zones and rolls their keys over. It requires the
+// admin token.
+//
+//	GET    /api/dnssec/keys/                          list the keys of every zone
+//	GET    /api/dnssec/keys/<zone>                    list the keys of a zone
+//	POST   /api/dnssec/keys/<zone>                    sign a zone {"algorithm": "ECDSAP256SHA256"}
+//	POST   /api/dnssec/keys/<zone>/rollover           start a rollover {"role": "ksk" or "zsk"}
+//	POST   /api/dnssec/keys/<zone>/import             add a key held elsewhere {"dnskey": "<DNSKEY record>", "state": "active"}
+//	POST   /api/dnssec/keys/<zone>/<key tag>/activate sign with a published key now
+//	DELETE /api/dnssec/keys/<zone>/<key tag>          remove a key
+//	DELETE /api/dnssec/keys/<zone>                    stop signing, once the parent has no DS left
+//
+// Keys held elsewhere, such as those of another provider serving the zone
+// (RFC 8901) or in an HSM, are published; they sign through -dnssecsigner,
+// or with signatures imported at /api/dnssec/zones/.
+func apiDNSSECKeys(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may manage DNSSEC keys"))
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dnssec/keys/"), "/"), "/")
+	zone := dns.Fqdn(strings.ToLower(parts[0]))
+	now := time.Now()
+
+	var keys []DNSSECKey
+	var err error
+	if parts[0] == "" {
+		keys, err = cfg.db.ListDNSSECKeys("")
+	} else {
+		keys, err = cfg.db.ListDNSSECKeys(zone)
+	}
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == "GET":
+		list := []apiDNSSECKey{}
+		for _, key := range keys {
+			list = append(list, showDNSSECKey(key))
+		}
+		apiWriteJSON(w, http.StatusOK, list)
+
+	case len(parts) == 1 && parts[0] != "" && r.Method == "POST":
+		var body struct {
+			Algorithm string `json:"algorithm"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if body.Algorithm == "" {
+			body.Algorithm = "ECDSAP256SHA256"
+		}
+		if len(keys) > 0 {
+			apiWriteError(w, http.StatusConflict, fmt.Errorf("%s is already signed", zone))
+			return
+		}
+		if _, err := cfg.db.GetDNS(zone, "SOA"); err != nil {
+			apiWriteError(w, apiStatus(err), fmt.Errorf("%s is not one of our zones: %s", zone, err))
+			return
+		}
+		taken := make(map[uint16]bool)
+		var created []DNSSECKey
+		for _, role := range []string{KeyKSK, KeyZSK} {
+			key, err := generateDNSSECKey(zone, role, body.Algorithm, KeyActive, taken, now)
+			if err != nil {
+				apiWriteError(w, apiStatus(err), err)
+				return
+			}
+			taken[key.KeyTag] = true
+			created = append(created, *key)
+		}
+		apiSaveDNSSECKeys(cfg, id, w, created, http.StatusCreated)
+
+	case len(parts) == 2 && parts[1] == "rollover" && r.Method == "POST":
+		var body struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if body.Role != KeyKSK && body.Role != KeyZSK {
+			apiWriteError(w, http.StatusBadRequest, errors.New("role must be ksk or zsk"))
+			return
+		}
+		if len(keys) == 0 {
+			apiWriteError(w, http.StatusNotFound, fmt.Errorf("%s is not signed", zone))
+			return
+		}
+		taken := make(map[uint16]bool)
+		for _, key := range keys {
+			if key.Role == body.Role && key.State == KeyPublished {
+				apiWriteError(w, http.StatusConflict, fmt.Errorf("key %d of %s is already being rolled over to", key.KeyTag, zone))
+				return
+			}
+			taken[key.KeyTag] = true
+		}
+		key, err := generateDNSSECKey(zone, body.Role, keyAlgorithm(keys), KeyPublished, taken, now)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiSaveDNSSECKeys(cfg, id, w, []DNSSECKey{*key}, http.StatusCreated)
+
+	case len(parts) == 2 && parts[1] == "import" && r.Method == "POST":
+		var body struct {
+			DNSKEY string `json:"dnskey"`
+			State  string `json:"state"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		rr, err := dns.NewRR(body.DNSKEY)
+		public, ok := rr.(*dns.DNSKEY)
+		if err != nil || !ok {
+			apiWriteError(w, http.StatusBadRequest, invalid("dnskey", "must be a DNSKEY record in zone file format"))
+			return
+		}
+		if !strings.EqualFold(dns.Fqdn(public.Hdr.Name), zone) {
+			apiWriteError(w, http.StatusBadRequest, invalid("dnskey", "the key is for %s, not %s", public.Hdr.Name, zone))
+			return
+		}
+		if body.State == "" {
+			body.State = KeyActive
+		}
+		if body.State != KeyActive && body.State != KeyPublished {
+			apiWriteError(w, http.StatusBadRequest, invalid("state", "must be active or published"))
+			return
+		}
+		for _, key := range keys {
+			if key.KeyTag == public.KeyTag() {
+				apiWriteError(w, http.StatusConflict, fmt.Errorf("%s already has key %d", zone, key.KeyTag))
+				return
+			}
+		}
+		role := KeyZSK
+		if public.Flags&dns.SEP != 0 {
+			role = KeyKSK
+		}
+		public.Hdr.Ttl = dnssecKeyTTL
+		key := DNSSECKey{
+			Zone:      zone,
+			Role:      role,
+			KeyTag:    public.KeyTag(),
+			Algorithm: public.Algorithm,
+			State:     body.State,
+			DNSKEY:    public.String(),
+			Created:   now.UTC(),
+			Changed:   now.UTC(),
+		}
+		apiSaveDNSSECKeys(cfg, id, w, []DNSSECKey{key}, http.StatusCreated)
+
+	case len(parts) == 2 && r.Method == "DELETE":
+		keyTag, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+			return
+		}
+		if err := cfg.db.DeleteDNSSECKey(id.String(), zone, uint16(keyTag)); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 3 && parts[2] == "activate" && r.Method == "POST":
+		keyTag, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+			return
+		}
+		for _, key := range keys {
+			if key.KeyTag != uint16(keyTag) {
+				continue
+			}
+			if key.State != KeyPublished {
+				apiWriteError(w, http.StatusConflict, fmt.Errorf("key %d of %s is %s, not published", key.KeyTag, zone, key.State))
+				return
+			}
+			apiSaveDNSSECKeys(cfg, id, w, activateDNSSECKey(keys, key, now), http.StatusOK)
+			return
+		}
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no DNSSEC key %d for %s", keyTag, zone))
+
+	case len(parts) == 1 && parts[0] != "" && r.Method == "DELETE":
+		if len(keys) == 0 {
+			apiWriteError(w, http.StatusNotFound, fmt.Errorf("%s is not signed", zone))
+			return
+		}
+		for _, key := range keys {
+			if err := cfg.db.DeleteDNSSECKey(id.String(), key.Zone, key.KeyTag); err != nil {
+				apiWriteError(w, apiStatus(err), err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// apiSaveDNSSECKeys saves keys and responds with them, as GET shows them
+func apiSaveDNSSECKeys(cfg *Config, id apiIdentity, w http.ResponseWriter, keys []DNSSECKey, status int) {
+	list := []apiDNSSECKey{}
+	for _, key := range keys {
+		if err := cfg.db.SaveDNSSECKey(id.String(), key); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		list = append(list, showDNSSECKey(key))
+	}
+	apiWriteJSON(w, status, list)
+}
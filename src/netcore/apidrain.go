@@ -0,0 +1,44 @@
+package netcore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// apiDrain starts or stops draining this instance for maintenance, and
+// requires the admin token. With {"exit": true}, the instance exits once it
+// is drained.
+//
+//	GET /api/drain
+//	POST /api/drain
+//	DELETE /api/drain
+func apiDrain(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may drain this instance"))
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var body struct {
+			Exit bool `json:"exit"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		drain.Start(cfg, body.Exit)
+	case "DELETE":
+		if err := drain.Stop(); err != nil {
+			apiWriteError(w, http.StatusConflict, err)
+			return
+		}
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, drain.Status())
+}
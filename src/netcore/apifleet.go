@@ -0,0 +1,28 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// apiFleet lists the instances that have registered themselves, with
+// whether each is alive, and requires the admin token.
+//
+//	GET /api/fleet
+func apiFleet(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may read the fleet inventory"))
+		return
+	}
+	if r.Method != "GET" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	members, err := cfg.db.GetFleet()
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, members)
+}
@@ -0,0 +1,40 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// apiInventory lists the hosts on the network, for configuration management
+// tools, and requires the admin token.
+//
+//	GET /api/inventory?format=ansible|json
+func apiInventory(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may read the host inventory"))
+		return
+	}
+	if r.Method != "GET" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "ansible" && format != "json" {
+		apiWriteError(w, http.StatusBadRequest, fmt.Errorf("format must be ansible or json, not %q", format))
+		return
+	}
+	hosts, err := Inventory(cfg.db)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if format == "json" {
+		if hosts == nil {
+			hosts = []InventoryHost{}
+		}
+		apiWriteJSON(w, http.StatusOK, hosts)
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, AnsibleInventory(hosts))
+}
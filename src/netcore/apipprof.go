@@ -0,0 +1,82 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPprof serves the profiles of runtime/pprof, such as a CPU profile over
+// ?seconds=30, the heap or the goroutines, and execution traces, to
+// diagnose this instance as it runs. They reveal its internals and cost it
+// time, so they require the admin token. net/http/pprof is not imported: it
+// would serve them to anyone on http.DefaultServeMux of every program that
+// embeds netcore.
+//
+//	GET /debug/pprof/
+//	GET /debug/pprof/profile?seconds=30
+//	GET /debug/pprof/{heap,goroutine,block,threadcreate}?debug=1
+//	GET /debug/pprof/trace?seconds=5
+func apiPprof(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may profile this instance"))
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+	switch name {
+	case "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "profile\ntrace")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%s\t%d\n", p.Name(), p.Count())
+		}
+	case "cmdline":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, strings.Join(os.Args, "\x00"))
+	case "profile", "trace":
+		seconds := 30
+		if name == "trace" {
+			seconds = 1
+		}
+		if s := r.URL.Query().Get("seconds"); s != "" {
+			var err error
+			if seconds, err = strconv.Atoi(s); err != nil || seconds <= 0 {
+				apiWriteError(w, http.StatusBadRequest, fmt.Errorf("invalid seconds %q", s))
+				return
+			}
+		}
+		start, stop := pprof.StartCPUProfile, pprof.StopCPUProfile
+		if name == "trace" {
+			start, stop = trace.Start, trace.Stop
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := start(w); err != nil {
+			// Only one CPU profile or trace runs at a time
+			apiWriteError(w, http.StatusConflict, err)
+			return
+		}
+		time.Sleep(time.Duration(seconds) * time.Second)
+		stop()
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			apiWriteError(w, http.StatusNotFound, fmt.Errorf("no profile %s", name))
+			return
+		}
+		debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		if err := p.WriteTo(w, debug); err != nil {
+			logger.Printf("HTTP API unable to write the %s profile: %s\n", name, err)
+		}
+	}
+}
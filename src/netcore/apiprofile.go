@@ -0,0 +1,73 @@
+package netcore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// apiDNSProfiles manages the scheduled filtering profiles, which the policy
+// handler of every instance reads within half a minute. It requires the
+// admin token.
+//
+//	GET            /api/dns/profiles/        list the profiles
+//	GET|PUT|DELETE /api/dns/profiles/<name>  a profile
+func apiDNSProfiles(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may manage DNS profiles"))
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dns/profiles/"), "/")
+
+	switch {
+	case name == "" && r.Method == "GET":
+		profiles, err := cfg.db.ListDNSProfiles()
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if profiles == nil {
+			profiles = []DNSProfile{}
+		}
+		sort.Sort(byProfileName(profiles))
+		apiWriteJSON(w, http.StatusOK, profiles)
+
+	case name == "":
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+
+	case r.Method == "GET":
+		profile, err := cfg.db.GetDNSProfile(name)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, profile)
+
+	case r.Method == "PUT":
+		var profile DNSProfile
+		if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		profile.Name = name
+		if err := cfg.db.SaveDNSProfile(id.String(), profile); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, profile)
+
+	case r.Method == "DELETE":
+		if err := cfg.db.DeleteDNSProfile(id.String(), name); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		apiWriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
@@ -0,0 +1,407 @@
+package netcore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Zones, record sets, subnets and reservations can each be managed as a
+// whole at a URL of their own, for infrastructure-as-code tools such as
+// Terraform's generic REST provider:
+//
+//	GET     the object, with its "id", or 404 if it does not exist
+//	PUT     create the object or replace it, and return it
+//	DELETE  remove the object: 204, or 404 if it does not exist
+//
+// The id of an object is its URL below the collection, which is what
+// "terraform import" takes:
+//
+//	/api/dns/zones/<zone>                        id <zone>
+//	/api/dns/zones/<zone>/rrsets/<name>/<type>   id <zone>/rrsets/<name>/<type>
+//	/api/dhcp/subnets/<zone>                     id <zone>
+//	/api/dhcp/reservations/<mac>                 id <mac>
+//
+// Errors are {"error": "...", "field": "..."} with 400 for invalid objects,
+// 403, 404, 409 for conflicts and 503 when the database is unavailable.
+
+// apiDNSZone manages a zone as a whole: its SOA settings, as for
+// /api/dns/soa/<zone>. A zone can only be deleted once its other records
+// are gone.
+func apiDNSZone(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request, zone string) {
+	entry, err := cfg.db.GetDNS(zone, "SOA")
+	if err != nil && ErrorKind(err) != ErrNotFound {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if entry == nil && r.Method != "PUT" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no SOA for zone %s", zone))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		apiWriteJSON(w, http.StatusOK, zoneResource(zone, entry))
+
+	case "PUT":
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		var body map[string]interface{}
+		if err := decoder.Decode(&body); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		meta := make(map[string]string, len(body))
+		for k, v := range body {
+			if k != "id" && k != "zone" && k != "serial" { // as GET returns them
+				meta[k] = fmt.Sprint(v)
+			}
+		}
+		if entry, err = setZoneSOA(cfg, id, zone, entry, meta); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, zoneResource(zone, entry))
+
+	case "DELETE":
+		records, err := cfg.db.ListDNSZone(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		records = id.manageableRecords(cfg, zone, records)
+		for _, record := range records {
+			if record.Type != "SOA" {
+				apiWriteError(w, http.StatusConflict, fmt.Errorf("zone %s still has records, such as %s", zone, record.String()))
+				return
+			}
+		}
+		changes := []DNSChange{{Op: "delete", Record: DNSRecord{Name: zone, Type: "SOA"}}}
+		if err := cfg.db.ApplyDNSChanges(id.String(), changes); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		apiWriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// zoneResource is a zone as apiDNSZone returns it
+func zoneResource(zone string, entry *DNSEntry) map[string]string {
+	settings := soaSettings(entry)
+	settings["id"], settings["zone"] = zone, zone
+	return settings
+}
+
+// dnsRRSet is every static value of a name and type, as apiDNSRRSet
+// manages them
+type dnsRRSet struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	TTL    uint32            `json:"ttl,omitempty"`
+	Values []dnsRRSetValue   `json:"values"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type dnsRRSetValue struct {
+	Value string            `json:"value,omitempty"`
+	Attr  map[string]string `json:"attr,omitempty"`
+}
+
+type byRRSetValue []dnsRRSetValue
+
+func (v byRRSetValue) Len() int           { return len(v) }
+func (v byRRSetValue) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byRRSetValue) Less(i, j int) bool { return v[i].Value < v[j].Value }
+
+// apiDNSRRSet manages the static values of a name and type as a whole. A PUT
+// adds the values it lists and deletes the others, and replaces the labels;
+// the TTL is left as it is unless one is given. Values with an expiration,
+// such as those registered for DHCP leases, are not part of the set, but a
+// DELETE removes them too.
+func apiDNSRRSet(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request, zone, name, rrType string) {
+	name, rrType = cleanFQDN(name), strings.ToUpper(rrType)
+	if rrType == "SOA" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("the SOA of %s is managed at /api/dns/zones/%s", zone, zone))
+		return
+	}
+	if !id.canManageName(cfg, zone, name) {
+		apiWriteError(w, http.StatusForbidden, fmt.Errorf("%s belongs to a zone below %s that is not of tenant %q", name, zone, id.Tenant))
+		return
+	}
+	current, err := getDNSRRSet(cfg, zone, name, rrType)
+	if err != nil && ErrorKind(err) != ErrNotFound {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if current == nil && r.Method != "PUT" {
+		apiWriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		apiWriteJSON(w, http.StatusOK, current)
+
+	case "PUT":
+		var body dnsRRSet
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(body.Values) == 0 {
+			apiWriteError(w, http.StatusBadRequest, invalid("values", "at least one value is required; DELETE removes the set"))
+			return
+		}
+		if err := validateLabels(body.Labels); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		labels := make(map[string]string)
+		if current != nil {
+			for k := range current.Labels {
+				labels[k] = "" // removed unless given again
+			}
+		}
+		for k, v := range body.Labels {
+			labels[k] = v
+		}
+		var changes []DNSChange
+		kept := make(map[string]bool)
+		for i, value := range body.Values {
+			change := DNSChange{Op: "add", Record: DNSRecord{Name: name, Type: rrType, TTL: body.TTL, Value: value.Value, Attr: value.Attr, Labels: labels}}
+			if err := change.validate(zone); err != nil {
+				v := err.(*ValidationError)
+				apiWriteError(w, http.StatusBadRequest, &ValidationError{Field: fmt.Sprintf("values[%d].%s", i, v.Field), Reason: v.Reason})
+				return
+			}
+			kept[change.Record.valueID()] = true
+			changes = append(changes, change)
+		}
+		if current != nil {
+			for _, value := range current.Values {
+				record := DNSRecord{Name: name, Type: rrType, Value: value.Value, Attr: value.Attr}
+				if !kept[record.valueID()] {
+					changes = append(changes, DNSChange{Op: "delete", Record: record})
+				}
+			}
+		}
+		if err := cfg.db.ApplyDNSChanges(id.String(), changes); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if current, err = getDNSRRSet(cfg, zone, name, rrType); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, current)
+
+	case "DELETE":
+		changes := []DNSChange{{Op: "delete", Record: DNSRecord{Name: name, Type: rrType}}}
+		if err := cfg.db.ApplyDNSChanges(id.String(), changes); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		apiWriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// getDNSRRSet reads the static values of name and rrType, which must be in
+// zone, sorted by value
+func getDNSRRSet(cfg *Config, zone, name, rrType string) (*dnsRRSet, error) {
+	if !validDomainName(name) || !strings.HasSuffix("."+name, "."+zone) {
+		return nil, invalid("name", "%s is not in zone %s", name, zone)
+	}
+	entry, err := cfg.db.GetDNS(name, rrType)
+	if err != nil {
+		if ErrorKind(err) == ErrNotFound {
+			return nil, notFoundError("no %s records for %s", rrType, name)
+		}
+		return nil, err
+	}
+	set := &dnsRRSet{
+		ID:     zone + "/rrsets/" + name + "/" + rrType,
+		Name:   name,
+		Type:   rrType,
+		TTL:    entry.TTL,
+		Values: []dnsRRSetValue{},
+		Labels: entry.Labels,
+	}
+	for _, value := range entry.Values {
+		if value.Expiration == nil {
+			set.Values = append(set.Values, dnsRRSetValue{Value: value.Value, Attr: value.Attr})
+		}
+	}
+	if len(set.Values) == 0 {
+		return nil, notFoundError("no static %s records for %s", rrType, name)
+	}
+	sort.Sort(byRRSetValue(set.Values))
+	return set, nil
+}
+
+// subnetResource is a subnet as apiDHCPSubnets returns it
+type subnetResource struct {
+	ID string `json:"id"`
+	*Subnet
+}
+
+// apiDHCPSubnets manages the network settings of a zone as a whole. It
+// requires the admin token. Instances read the settings when they start.
+//
+//	GET|PUT|DELETE /api/dhcp/subnets/<zone>
+func apiDHCPSubnets(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may manage subnets"))
+		return
+	}
+	zone := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dhcp/subnets/"), "/")
+	if !configZoneMatcher.MatchString(zone) {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		subnet, err := cfg.db.GetSubnet(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, subnetResource{zone, subnet})
+
+	case "PUT":
+		var subnet Subnet
+		if err := json.NewDecoder(r.Body).Decode(&subnet); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		subnet.Zone = zone
+		if err := cfg.db.SaveSubnet(id.String(), subnet); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		saved, err := cfg.db.GetSubnet(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, subnetResource{zone, saved})
+
+	case "DELETE":
+		if err := cfg.db.DeleteSubnet(id.String(), zone); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		apiWriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// reservationResource is a reservation as apiDHCPReservations returns it
+type reservationResource struct {
+	ID string `json:"id"`
+	DHCPLease
+}
+
+// apiDHCPReservations manages the reservation of a MAC as a whole: its
+// address, host name and labels. It requires the admin token.
+//
+//	GET|PUT|DELETE /api/dhcp/reservations/<mac>
+func apiDHCPReservations(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may manage DHCP reservations"))
+		return
+	}
+	hw, err := net.ParseMAC(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dhcp/reservations/"), "/"))
+	if err != nil {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	mac := hw.String()
+	current, err := getReservation(cfg, mac)
+	if err != nil && ErrorKind(err) != ErrNotFound {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if current == nil && r.Method != "PUT" {
+		apiWriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		apiWriteJSON(w, http.StatusOK, reservationResource{mac, *current})
+
+	case "PUT":
+		var lease DHCPLease
+		if err := json.NewDecoder(r.Body).Decode(&lease); err != nil {
+			apiWriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		lease.MAC, lease.Expires = mac, nil
+		if err := lease.validate(); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if current != nil {
+			if err := cfg.db.DeleteReservation(id.String(), mac); err != nil {
+				apiWriteError(w, apiStatus(err), err)
+				return
+			}
+		}
+		if err := cfg.db.ImportLease(id.String(), lease); err != nil {
+			if current != nil {
+				if err := cfg.db.ImportLease(id.String(), *current); err != nil {
+					logger.Printf("Reservation of %s lost: %s\n", mac, err)
+				}
+			}
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if current, err = getReservation(cfg, mac); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, reservationResource{mac, *current})
+
+	case "DELETE":
+		if err := cfg.db.DeleteReservation(id.String(), mac); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		apiWriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// getReservation returns the reservation of mac
+func getReservation(cfg *Config, mac string) (*DHCPLease, error) {
+	leases, err := cfg.db.ListLeases()
+	if err != nil {
+		return nil, err
+	}
+	for _, lease := range leases {
+		if lease.MAC == mac && lease.Expires == nil {
+			return &lease, nil
+		}
+	}
+	return nil, notFoundError("%s has no reservation", mac)
+}
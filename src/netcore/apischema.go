@@ -0,0 +1,40 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// apiSchema shows the schema version of the data and migrates it to the
+// version of this release, and requires the admin token
+//
+//	GET /api/schema
+//	POST /api/schema
+func apiSchema(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may migrate the data"))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		status, err := cfg.db.SchemaStatus()
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, status)
+	case "POST":
+		made, err := cfg.db.MigrateSchema(id.String())
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if made == nil {
+			made = []SchemaMigration{}
+		}
+		apiWriteJSON(w, http.StatusOK, made)
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
@@ -0,0 +1,69 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiTenants manages tenants. Tenants may list their own zones; everything
+// else requires the admin token.
+//
+//	GET  /api/tenants/<tenant>/zones         list the tenant's zones
+//	PUT  /api/tenants/<tenant>/zones/<zone>  assign a zone to the tenant
+//	POST /api/tenants/<tenant>/tokens        issue a new token for the tenant
+func apiTenants(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/tenants/"), "/"), "/")
+	tenant := parts[0]
+	if !validTenantName(tenant) {
+		apiWriteError(w, http.StatusBadRequest, ErrBadTenantName)
+		return
+	}
+	if !id.Admin && id.Tenant != tenant {
+		apiWriteError(w, http.StatusForbidden, fmt.Errorf("not permitted to manage tenant %q", tenant))
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && parts[1] == "zones" && r.Method == "GET":
+		zones, err := cfg.db.ListTenantZones(tenant)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, map[string]interface{}{"tenant": tenant, "zones": zones})
+
+	case !id.Admin:
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may change tenants"))
+
+	case len(parts) == 3 && parts[1] == "zones" && r.Method == "PUT":
+		zone := cleanFQDN(parts[2])
+		if !validDomainName(zone) {
+			apiWriteError(w, http.StatusBadRequest, fmt.Errorf("invalid zone name %q", zone))
+			return
+		}
+		if err := cfg.db.AssignZone(tenant, zone); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		auditChange(cfg.db, id.String(), "tenant", zone, "assign", "", tenant)
+		apiWriteJSON(w, http.StatusOK, map[string]string{"tenant": tenant, "zone": zone})
+
+	case len(parts) == 2 && parts[1] == "tokens" && r.Method == "POST":
+		token, err := newToken()
+		if err == nil {
+			err = cfg.db.AddTenantToken(tenant, token)
+		}
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		auditChange(cfg.db, id.String(), "tenant", tenant, "issue token", "", "")
+		// The token is only ever shown here; we store a hash of it
+		apiWriteJSON(w, http.StatusCreated, map[string]string{"tenant": tenant, "token": token})
+
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
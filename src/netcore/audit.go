@@ -0,0 +1,125 @@
+package netcore
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var auditRetention = Flags.Duration("auditretention", 90*24*time.Hour, "How long entries are kept in the change audit log.")
+
+// AuditDB stores the log of changes made to DNS records, DHCP data,
+// configuration and tenants
+type AuditDB interface {
+	Audit(entry AuditEntry) error
+	GetAuditLog(filter AuditFilter) ([]AuditEntry, error)
+}
+
+// AuditEntry records a single change
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"` // who made the change, such as admin, tenant:name or dhcp
+	Kind   string    `json:"kind"`  // dns, dhcp, config or tenant
+	Key    string    `json:"key"`   // what was changed
+	Action string    `json:"action"`
+	Old    string    `json:"old,omitempty"`
+	New    string    `json:"new,omitempty"`
+}
+
+// AuditFilter selects entries from the audit log. Empty fields match
+// everything.
+type AuditFilter struct {
+	Actor     string
+	Kind      string
+	KeyPrefix string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// Match reports whether the entry is selected by the filter
+func (f AuditFilter) Match(entry AuditEntry) bool {
+	switch {
+	case f.Actor != "" && entry.Actor != f.Actor:
+		return false
+	case f.Kind != "" && entry.Kind != f.Kind:
+		return false
+	case f.KeyPrefix != "" && !strings.HasPrefix(entry.Key, f.KeyPrefix):
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && entry.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// auditChange records a change in the audit log if the value actually
+// changed. Failure to record is logged rather than failing the change.
+func auditChange(db AuditDB, actor, kind, key, action, old, new string) {
+	if old == new && action == "set" {
+		return
+	}
+	err := db.Audit(AuditEntry{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Kind:   kind,
+		Key:    key,
+		Action: action,
+		Old:    old,
+		New:    new,
+	})
+	if err != nil {
+		logger.Printf("[AUDIT] Unable to record %s %s of %s by %s: %s\n", action, kind, key, actor, err)
+	}
+}
+
+// String returns the name the caller is recorded under in the audit log
+func (id apiIdentity) String() string {
+	if id.Admin {
+		return "admin"
+	}
+	return "tenant:" + id.Tenant
+}
+
+// apiAudit returns audit log entries, newest last, filtered by the actor,
+// kind, key (prefix), since and until (RFC 3339) and limit query
+// parameters. Tenants only see their own changes.
+func apiAudit(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Actor:     query.Get("actor"),
+		Kind:      query.Get("kind"),
+		KeyPrefix: query.Get("key"),
+	}
+	if !id.Admin {
+		filter.Actor = id.String()
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				apiWriteError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %s", name, err))
+				return
+			}
+			*t = parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			apiWriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", value))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries, err := cfg.db.GetAuditLog(filter)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,82 @@
+package netcore
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Audit entries are stored in order of creation under /audit, and expire
+// once they are older than the retention period
+
+func (db EtcdDB) Audit(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.CreateInOrder("audit", string(data), uint64(auditRetention.Seconds()))
+	return err
+}
+
+func (db EtcdDB) GetAuditLog(filter AuditFilter) ([]AuditEntry, error) {
+	response, err := db.client.Get("audit", true, false)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []AuditEntry{}
+	for _, node := range response.Node.Nodes {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(node.Value), &entry); err != nil {
+			logger.Printf("[AUDIT] Skipping unreadable entry %s: %s\n", node.Key, err)
+			continue
+		}
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:] // keep the most recent
+	}
+	return entries, nil
+}
+
+// auditedSet sets key to value and records the change to what in the audit
+// log on behalf of actor
+func (db EtcdDB) auditedSet(actor, kind, what, key, value string, ttl uint64) error {
+	response, err := db.client.Set(key, value, ttl)
+	if err != nil {
+		return err
+	}
+	old := ""
+	if response != nil && response.PrevNode != nil {
+		old = response.PrevNode.Value
+	}
+	auditChange(db, actor, kind, what, "set", old, value)
+	return nil
+}
+
+// auditedKV records every Set made through it in the audit log on behalf of
+// actor, with the key (less its leading slash) as what was changed
+type auditedKV struct {
+	etcdKV
+	audit AuditDB
+	actor string
+	kind  string
+}
+
+func (kv auditedKV) Set(key, value string, ttl uint64) (*etcd.Response, error) {
+	response, err := kv.etcdKV.Set(key, value, ttl)
+	if err != nil {
+		return response, err
+	}
+	old := ""
+	if response != nil && response.PrevNode != nil {
+		old = response.PrevNode.Value
+	}
+	auditChange(kv.audit, kv.actor, kv.kind, strings.TrimPrefix(key, "/"), "set", old, value)
+	return response, nil
+}
@@ -0,0 +1,235 @@
+package netcore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+var backupDest = Flags.String("backupdest", "", "Directory, or s3://bucket/prefix, to write scheduled backups of all data to (empty to disable).")
+var backupInterval = Flags.Duration("backupinterval", 24*time.Hour, "How often to write a backup to -backupdest.")
+var backupKeep = Flags.Int("backupkeep", 14, "How many backups to keep at -backupdest; older ones are deleted.")
+
+// backupStats counts the backups written and failed, and holds the Unix
+// time of the last one written under "last"
+var backupStats = expvar.NewMap("backups")
+
+// backupSkipped are the trees that only describe running instances, which
+// are not worth restoring, and the promotion marker of a DR copy
+var backupSkipped = map[string]bool{"locks": true, "leader": true, "fleet": true, "dr": true}
+
+const (
+	backupVersion = 1
+	backupPrefix  = "netcore-"
+	backupSuffix  = ".json.gz"
+)
+
+// BackupDB copies all of the data for a backup
+type BackupDB interface {
+	Backup() (*Backup, error)
+}
+
+// Backup is a copy of every tree of the database, less those of running
+// instances. It is written as gzipped JSON, which netcorectl restore writes
+// back to etcd.
+type Backup struct {
+	Version  int          `json:"version"`
+	Created  time.Time    `json:"created"`
+	Hostname string       `json:"hostname"`
+	Trees    []*etcd.Node `json:"trees"`
+}
+
+// backupName is the name of a backup made at t; names sort by time
+func backupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format("20060102T150405Z") + backupSuffix
+}
+
+// encodeBackup returns the backup as gzipped JSON
+func encodeBackup(b *Backup) ([]byte, error) {
+	var buf bytes.Buffer
+	z := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(z).Encode(b); err != nil {
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// backupStore is where backups are written
+type backupStore interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+	List() ([]string, error) // names of the backups, oldest first
+	Delete(name string) error
+}
+
+// newBackupStore returns the store for dest, a directory or an s3:// URL
+func newBackupStore(dest string) (backupStore, error) {
+	if strings.HasPrefix(dest, "s3://") {
+		return newS3BackupStore(dest)
+	}
+	if dest == "" {
+		return nil, errors.New("no backup destination is set; see -backupdest")
+	}
+	return dirBackupStore(dest), nil
+}
+
+// dirBackupStore keeps backups as files in a directory
+type dirBackupStore string
+
+func (d dirBackupStore) Put(name string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(string(d), name)
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (d dirBackupStore) Get(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(string(d), name))
+	if os.IsNotExist(err) {
+		return nil, notFoundError("no backup %s", name)
+	}
+	return data, err
+}
+
+func (d dirBackupStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(string(d))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if isBackupName(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d dirBackupStore) Delete(name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+// isBackupName returns true for the names that backupName makes, which are
+// also the only ones that may be fetched through the API
+func isBackupName(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) && !strings.ContainsAny(name, "/\\")
+}
+
+// writeBackup copies the data from cfg's database to the backup
+// destination, then deletes the oldest backups beyond those to keep. It
+// returns the name of the new backup.
+func writeBackup(cfg *Config) (string, error) {
+	store, err := newBackupStore(*backupDest)
+	if err != nil {
+		return "", err
+	}
+	backup, err := cfg.db.Backup()
+	if err != nil {
+		return "", err
+	}
+	backup.Hostname = cfg.Hostname()
+	data, err := encodeBackup(backup)
+	if err != nil {
+		return "", err
+	}
+	name := backupName(backup.Created)
+	if err := store.Put(name, data); err != nil {
+		return "", err
+	}
+	last := new(expvar.Int)
+	last.Set(backup.Created.Unix())
+	backupStats.Set("last", last)
+	backupStats.Add("written", 1)
+	logger.Printf("[BACKUP] Wrote %s (%d bytes) to %s\n", name, len(data), *backupDest)
+
+	names, err := store.List()
+	if err != nil {
+		return name, err
+	}
+	for i := 0; i < len(names)-*backupKeep && *backupKeep > 0; i++ {
+		if err := store.Delete(names[i]); err != nil {
+			logger.Printf("[BACKUP] Unable to delete old backup %s: %s\n", names[i], err)
+		}
+	}
+	return name, nil
+}
+
+// runBackup is the leader duty of writing scheduled backups
+func runBackup(cfg *Config) error {
+	if _, err := writeBackup(cfg); err != nil {
+		backupStats.Add("failed", 1)
+		return err
+	}
+	return nil
+}
+
+// apiBackups lists the backups at the destination (GET /api/backups/),
+// writes one now (POST), or downloads one (GET /api/backups/<name>)
+func apiBackups(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may manage backups"))
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/backups"), "/")
+	store, err := newBackupStore(*backupDest)
+	if err != nil {
+		apiWriteError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	switch {
+	case name == "" && r.Method == "GET":
+		names, err := store.List()
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if names == nil {
+			names = []string{}
+		}
+		apiWriteJSON(w, http.StatusOK, names)
+
+	case name == "" && r.Method == "POST":
+		name, err := writeBackup(cfg)
+		if name == "" {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusCreated, map[string]string{"name": name})
+
+	case isBackupName(name) && r.Method == "GET":
+		data, err := store.Get(name)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.Write(data)
+
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
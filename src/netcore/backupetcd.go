@@ -0,0 +1,20 @@
+package netcore
+
+import (
+	"path"
+	"time"
+)
+
+func (db EtcdDB) Backup() (*Backup, error) {
+	response, err := db.client.Get("/", true, true)
+	if err != nil {
+		return nil, err
+	}
+	backup := &Backup{Version: backupVersion, Created: time.Now().UTC()}
+	for _, tree := range response.Node.Nodes {
+		if !backupSkipped[path.Base(tree.Key)] {
+			backup.Trees = append(backup.Trees, tree)
+		}
+	}
+	return backup, nil
+}
@@ -0,0 +1,210 @@
+package netcore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var backupS3Endpoint = Flags.String("backups3endpoint", "https://s3.amazonaws.com", "S3-compatible service for s3:// backup destinations; credentials come from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY.")
+var backupS3Region = Flags.String("backups3region", "us-east-1", "Region of the S3-compatible service, for request signing.")
+
+// s3BackupStore keeps backups as objects in a bucket of an S3-compatible
+// service, addressed path-style so that self-hosted services work without
+// DNS for each bucket
+type s3BackupStore struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string // object names start with it, and end in a slash unless empty
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func newS3BackupStore(dest string) (*s3BackupStore, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(*backupS3Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -backups3endpoint: %s", err)
+	}
+	s := &s3BackupStore{
+		endpoint:  endpoint,
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    *backupS3Region,
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	if s.bucket == "" {
+		return nil, fmt.Errorf("no bucket in %s", dest)
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("s3 backups need $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	}
+	if s.prefix != "" {
+		s.prefix += "/"
+	}
+	return s, nil
+}
+
+func (s *s3BackupStore) Put(name string, data []byte) error {
+	_, err := s.do("PUT", s.prefix+name, nil, data)
+	return err
+}
+
+func (s *s3BackupStore) Get(name string) ([]byte, error) {
+	return s.do("GET", s.prefix+name, nil, nil)
+}
+
+func (s *s3BackupStore) Delete(name string) error {
+	_, err := s.do("DELETE", s.prefix+name, nil, nil)
+	return err
+}
+
+// List pages through the objects under the prefix with ListObjectsV2
+func (s *s3BackupStore) List() ([]string, error) {
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+	for {
+		data, err := s.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			if name := strings.TrimPrefix(object.Key, s.prefix); isBackupName(name) {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// do sends a request signed with AWS Signature Version 4 for key in the
+// bucket, or for the bucket itself if key is empty, and returns the body of
+// a successful response
+func (s *s3BackupStore) do(method, key string, query url.Values, body []byte) ([]byte, error) {
+	path := s.endpoint.Path + "/" + s3Escape(s.bucket, false)
+	if key != "" {
+		path += "/" + s3Escape(key, false)
+	}
+	// url.Values.Encode sorts by key but escapes spaces as +, which the
+	// canonical query string must not
+	var params []string
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	sort.Strings(params)
+	rawQuery := strings.Join(params, "&")
+	u := s.endpoint.Scheme + "://" + s.endpoint.Host + path
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		method,
+		path,
+		rawQuery,
+		"host:" + s.endpoint.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	canonicalSum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalSum[:])
+	signingKey := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{day, s.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(signingKey, toSign))))
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case response.StatusCode == http.StatusNotFound && key != "":
+		return nil, notFoundError("no backup %s", strings.TrimPrefix(key, s.prefix))
+	case response.StatusCode/100 != 2:
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("s3 %s %s: %s: %s", method, u, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("s3 %s %s: %s", method, u, response.Status)
+	}
+	return data, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape escapes s as signature version 4 requires: every byte but
+// unreserved characters, and slashes too unless in a path
+func s3Escape(s string, escapeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
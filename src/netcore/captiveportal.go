@@ -0,0 +1,181 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// captivePortalTTL is the TTL of portal answers, so that clients forget them
+// as soon as they are authorized
+const captivePortalTTL = 1
+
+// captivePortalTracker remembers which addresses were leased to clients that
+// have not been authorized yet, so that DNS can send them to the portal.
+// Only this instance's DHCP clients are known; with short leases, clients
+// authorized through another instance are released when they renew here.
+type captivePortalTracker struct {
+	sync.Mutex
+	clients map[string]captiveClient // by IP
+}
+
+type captiveClient struct {
+	mac    string
+	portal net.IP
+	until  time.Time
+}
+
+var captivePortal = &captivePortalTracker{clients: make(map[string]captiveClient)}
+
+// Restrict sends DNS questions from ip to portal until the lease ends
+func (t *captivePortalTracker) Restrict(ip net.IP, mac net.HardwareAddr, portal net.IP, until time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	for key, c := range t.clients {
+		if now.After(c.until) {
+			delete(t.clients, key)
+		}
+	}
+	t.clients[ip.String()] = captiveClient{mac: mac.String(), portal: portal, until: until}
+}
+
+// Release gives ip normal DNS service
+func (t *captivePortalTracker) Release(ip net.IP) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.clients, ip.String())
+}
+
+// ReleaseMAC gives every address leased to mac normal DNS service
+func (t *captivePortalTracker) ReleaseMAC(mac net.HardwareAddr) {
+	t.Lock()
+	defer t.Unlock()
+	for key, c := range t.clients {
+		if c.mac == mac.String() {
+			delete(t.clients, key)
+		}
+	}
+}
+
+// PortalFor returns the portal that ip is restricted to, or nil
+func (t *captivePortalTracker) PortalFor(ip net.IP) net.IP {
+	if ip == nil {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	c, ok := t.clients[ip.String()]
+	if !ok || time.Now().After(c.until) {
+		return nil
+	}
+	return c.portal
+}
+
+// defaultDNSPortalRules decide which questions of clients waiting in the
+// captive portal go to the portal when the dnsportal directory is empty:
+// time servers resolve normally so that clients can check the portal's
+// certificate, and the addresses of every other name lead to the portal.
+// Questions no rule matches, such as SRV or TXT, are answered normally.
+var defaultDNSPortalRules = []string{
+	"pass name ntp.org,time.apple.com,time.windows.com,time.google.com,time.cloudflare.com",
+	"portal type A,AAAA,HTTPS,SVCB,ANY",
+}
+
+// dnsPortalRule decides whether a question of a client waiting in the
+// captive portal is answered with the portal or normally. Rules are stored
+// as text in the dnsportal config directory, one rule per key, with the
+// conditions of policy rules, and the first to match decides:
+//
+//	pass name pool.ntp.org,remediation.example.com
+//	pass class printer
+//	portal type A,AAAA,HTTPS
+type dnsPortalRule struct {
+	text       string
+	pass       bool
+	conditions dnsPolicyRule
+}
+
+func parseDNSPortalRule(rule string) (*dnsPortalRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 || len(fields)%2 != 1 {
+		return nil, fmt.Errorf("malformed portal rule %q", rule)
+	}
+	r := &dnsPortalRule{text: strings.Join(fields, " ")}
+	switch fields[0] {
+	case "pass":
+		r.pass = true
+	case "portal":
+	default:
+		return nil, fmt.Errorf("portal rule %q must start with pass or portal", rule)
+	}
+	if err := r.conditions.parseConditions(fmt.Sprintf("portal rule %q", rule), fields[1:]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func checkDNSPortalRule(value string) error {
+	_, err := parseDNSPortalRule(value)
+	return err
+}
+
+// portalAnswer answers q with portal: its address for address questions of
+// its family, and no records for the others, so that clients fall back to
+// the address of the portal
+func portalAnswer(q *dns.Question, portal net.IP) []dns.RR {
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: captivePortalTTL}
+	ip4 := portal.To4()
+	switch {
+	case ip4 != nil && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY):
+		hdr.Rrtype = dns.TypeA
+		return []dns.RR{&dns.A{Hdr: hdr, A: ip4}}
+	case ip4 == nil && (q.Qtype == dns.TypeAAAA || q.Qtype == dns.TypeANY):
+		hdr.Rrtype = dns.TypeAAAA
+		return []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: portal}}
+	}
+	return nil
+}
+
+// newDNSPortalHandler answers the questions of clients waiting in the
+// captive portal as the zone's dnsportal rules, or defaultDNSPortalRules,
+// say: with the portal's address, or normally, so that they can still set
+// their clocks and reach remediation servers. It must come before the
+// cache, which answers for everyone.
+func newDNSPortalHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	texts := cfg.DNSPortalRules()
+	if len(texts) == 0 {
+		texts = defaultDNSPortalRules
+	}
+	var rules []*dnsPortalRule
+	for _, text := range texts {
+		rule, err := parseDNSPortalRule(text)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		portal := captivePortal.PortalFor(r.Client)
+		if portal == nil {
+			return next.ServeDNSQuestion(r)
+		}
+		r.Trace.Note("the client has not yet been let through the captive portal at %s", portal)
+		device := clientDevices.Lookup(r.Client)
+		for _, rule := range rules {
+			if !rule.conditions.matches(r.Client, device, r.Question) {
+				continue
+			}
+			r.Trace.Note("portal rule %q matches", rule.text)
+			if !rule.pass {
+				return portalAnswer(r.Question, portal)
+			}
+			break
+		}
+		return next.ServeDNSQuestion(r)
+	}), nil
+}
@@ -0,0 +1,91 @@
+package netcore
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+var clientIdentInterval = Flags.Duration("clientidentinterval", 30*time.Second, "How often the addresses of DNS clients are matched again with DHCP leases, for policies, query logs and reports that name devices (0 to disable).")
+
+// ClientDevice is what DHCP knows of the device holding an address
+type ClientDevice struct {
+	Hostname string            `json:"hostname,omitempty"`
+	MAC      string            `json:"mac,omitempty"`
+	Class    string            `json:"class,omitempty"` // the class label of its reservation, or the class of its vendor class
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// clientDirectory maps the addresses of DNS clients to the devices DHCP
+// leased them to. It is rebuilt from the leases every -clientidentinterval,
+// which keeps lookups on the query path to a map read.
+type clientDirectory struct {
+	mu   sync.RWMutex
+	byIP map[string]*ClientDevice
+}
+
+var clientDevices = &clientDirectory{}
+
+// Lookup returns the device holding ip, or nil if none does
+func (d *clientDirectory) Lookup(ip net.IP) *ClientDevice {
+	if ip == nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.byIP[ip.String()]
+}
+
+// refresh rebuilds the directory from the current leases and reservations
+func (d *clientDirectory) refresh(db DHCPDB) error {
+	leases, err := db.ListLeases()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	byIP := make(map[string]*ClientDevice, len(leases))
+	for _, lease := range leases {
+		if lease.Expires != nil && lease.Expires.Before(now) {
+			continue
+		}
+		device := &ClientDevice{Hostname: lease.Hostname, MAC: lease.MAC, Class: lease.Labels["class"], Labels: lease.Labels}
+		if device.Class == "" {
+			device.Class = deviceClass(lease.VendorClass)
+		}
+		if ip := net.ParseIP(lease.IP); ip != nil {
+			byIP[ip.String()] = device
+		}
+	}
+	d.mu.Lock()
+	d.byIP = byIP
+	d.mu.Unlock()
+	return nil
+}
+
+// clientIdentSetup starts matching DNS clients with DHCP leases
+func clientIdentSetup(cfg *Config) {
+	if *clientIdentInterval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if err := clientDevices.refresh(cfg.db); err != nil {
+				logger.Printf("DNS clients not matched with DHCP leases: %s\n", err)
+			}
+			time.Sleep(*clientIdentInterval)
+		}
+	}()
+}
+
+// describeDevice names a device for people, by its host name, MAC and class
+func describeDevice(d *ClientDevice) string {
+	s := d.Hostname
+	if s == "" {
+		s = "a device"
+	}
+	s += " (" + d.MAC
+	if d.Class != "" {
+		s += ", class " + d.Class
+	}
+	return s + ")"
+}
@@ -0,0 +1,186 @@
+package netcore
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var clientStatsWindow = Flags.Duration("clientstatswindow", 10*time.Minute, "Window over which per-client DNS statistics are kept.")
+
+// maxClientsPerBucket bounds the memory used by per-client statistics when
+// faced with many (possibly spoofed) sources; the excess is counted under a
+// single "other" client
+const maxClientsPerBucket = 100000
+
+// ClientStats are the DNS queries made by one client over the window
+type ClientStats struct {
+	Client        string           `json:"client"`
+	Queries       int64            `json:"queries"`
+	NXDomain      int64            `json:"nxdomain"`
+	NXDomainRatio float64          `json:"nxdomain_ratio"`
+	Types         map[string]int64 `json:"types"`
+	Hostname      string           `json:"hostname,omitempty"` // of the device DHCP leased the address to
+	MAC           string           `json:"mac,omitempty"`
+	Class         string           `json:"class,omitempty"`
+}
+
+// clientStatsTracker counts queries per client in one-minute buckets, so
+// that old traffic falls out of the window a minute at a time
+type clientStatsTracker struct {
+	sync.Mutex
+	buckets []clientStatsBucket
+}
+
+type clientStatsBucket struct {
+	minute  int64
+	clients map[string]*ClientStats
+}
+
+var clientStats = &clientStatsTracker{}
+
+// Record counts a query from client for the given question types, and
+// whether it was answered with NXDOMAIN
+func (t *clientStatsTracker) Record(client net.Addr, qtypes []string, nxdomain bool) {
+	ip := client.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	minute := time.Now().Unix() / 60
+
+	t.Lock()
+	defer t.Unlock()
+	if n := len(t.buckets); n == 0 || t.buckets[n-1].minute != minute {
+		t.buckets = append(t.buckets, clientStatsBucket{minute: minute, clients: make(map[string]*ClientStats)})
+		t.expire(minute)
+	}
+	bucket := t.buckets[len(t.buckets)-1]
+	stats, ok := bucket.clients[ip]
+	if !ok {
+		if len(bucket.clients) >= maxClientsPerBucket {
+			ip = "other"
+			stats = bucket.clients[ip]
+		}
+		if stats == nil {
+			stats = &ClientStats{Client: ip, Types: make(map[string]int64)}
+			bucket.clients[ip] = stats
+		}
+	}
+	stats.Queries++
+	if nxdomain {
+		stats.NXDomain++
+	}
+	for _, qtype := range qtypes {
+		stats.Types[qtype]++
+	}
+}
+
+// expire drops buckets that have left the window; the caller must hold the
+// lock
+func (t *clientStatsTracker) expire(minute int64) {
+	window := int64(clientStatsWindow.Minutes())
+	if window < 1 {
+		window = 1
+	}
+	oldest := minute - window + 1
+	i := 0
+	for i < len(t.buckets) && t.buckets[i].minute < oldest {
+		i++
+	}
+	t.buckets = t.buckets[i:]
+}
+
+// Top returns the n clients with the highest value of the given measure,
+// which is queries, nxdomain or nxdomain_ratio
+func (t *clientStatsTracker) Top(n int, by string) ([]ClientStats, error) {
+	t.Lock()
+	t.expire(time.Now().Unix() / 60)
+	totals := make(map[string]*ClientStats)
+	for _, bucket := range t.buckets {
+		for ip, stats := range bucket.clients {
+			total, ok := totals[ip]
+			if !ok {
+				total = &ClientStats{Client: ip, Types: make(map[string]int64)}
+				totals[ip] = total
+			}
+			total.Queries += stats.Queries
+			total.NXDomain += stats.NXDomain
+			for qtype, count := range stats.Types {
+				total.Types[qtype] += count
+			}
+		}
+	}
+	t.Unlock()
+
+	top := make([]ClientStats, 0, len(totals))
+	for _, total := range totals {
+		total.NXDomainRatio = float64(total.NXDomain) / float64(total.Queries)
+		if device := clientDevices.Lookup(net.ParseIP(total.Client)); device != nil {
+			total.Hostname, total.MAC, total.Class = device.Hostname, device.MAC, device.Class
+		}
+		top = append(top, *total)
+	}
+	var measure func(s ClientStats) float64
+	switch by {
+	case "", "queries":
+		measure = func(s ClientStats) float64 { return float64(s.Queries) }
+	case "nxdomain":
+		measure = func(s ClientStats) float64 { return float64(s.NXDomain) }
+	case "nxdomain_ratio":
+		measure = func(s ClientStats) float64 { return s.NXDomainRatio }
+	default:
+		return nil, errors.New("sort must be one of queries, nxdomain or nxdomain_ratio")
+	}
+	sort.Sort(clientStatsByMeasure{top, measure})
+	if n > 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top, nil
+}
+
+// clientStatsByMeasure sorts clients from highest to lowest measure
+type clientStatsByMeasure struct {
+	stats   []ClientStats
+	measure func(s ClientStats) float64
+}
+
+func (s clientStatsByMeasure) Len() int      { return len(s.stats) }
+func (s clientStatsByMeasure) Swap(i, j int) { s.stats[i], s.stats[j] = s.stats[j], s.stats[i] }
+func (s clientStatsByMeasure) Less(i, j int) bool {
+	a, b := s.measure(s.stats[i]), s.measure(s.stats[j])
+	if a != b {
+		return a > b
+	}
+	return s.stats[i].Client < s.stats[j].Client
+}
+
+// apiClientsTop lists the busiest DNS clients over the statistics window,
+// /api/clients/top?n=20&sort=queries. Only the admin may see other people's
+// traffic.
+func apiClientsTop(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may view client statistics"))
+		return
+	}
+	n := 20
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n < 0 {
+			apiWriteError(w, http.StatusBadRequest, errors.New("n must be a non-negative number"))
+			return
+		}
+	}
+	top, err := clientStats.Top(n, r.URL.Query().Get("sort"))
+	if err != nil {
+		apiWriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, map[string]interface{}{
+		"window":  clientStatsWindow.String(),
+		"clients": top,
+	})
+}
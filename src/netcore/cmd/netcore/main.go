@@ -0,0 +1,25 @@
+// Command netcore serves DHCP and DNS with all config and data stored in
+// etcd.
+package main
+
+import (
+	"log"
+	"os"
+
+	"netcore"
+)
+
+func main() {
+	if err := netcore.ParseFlags(os.Args[1:]); err != nil {
+		log.Fatalln(err)
+	}
+	if netcore.Flags.Arg(0) == "dhcpbench" {
+		if err := netcore.DHCPBench(netcore.Flags.Args()[1:]); err != nil {
+			log.Fatalf("dhcpbench: %s\n", err)
+		}
+		return
+	}
+	if err := netcore.Run(); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -1,8 +1,7 @@
-package main
+package netcore
 
 import (
 	"errors"
-	"flag"
 	"net"
 	"sync"
 	"time"
@@ -13,6 +12,7 @@ type Config struct {
 	sync.Mutex
 	db                 DB
 	hostname           string
+	identity           HostIdentity
 	zone               string
 	domain             string
 	subnet             *net.IPNet
@@ -21,23 +21,95 @@ type Config struct {
 	dhcpNIC            string
 	dhcpSubnet         *net.IPNet
 	dhcpLeaseDuration  time.Duration
+	dhcpRetention      time.Duration
 	dhcpTFTP           string
+	dhcpOptions        DHCPZoneOptions
+	dhcpInterfaces     []*DHCPInstance
+	dhcpFilter         DHCPMACFilter
 	dnsForwarders      []string
+	dnsForwarderPools  map[string]string
+	dnsForwardRules    []string
+	dnsForwardOverride map[string]string
+	dnsSpecialUse      map[string]string
 	dnsCacheMaxTTL     time.Duration
 	dnsCacheMissingTTL time.Duration
+	dnsCacheMemory     int
+	dnsCacheMaxRRset   int
+	dnsChain           []string
+	dnsRewriteRules    []string
+	dnsPolicyRules     []string
+	dnsPortalRules     []string
+	dnsStaticRecords   []string
+	dnsSecondaryZones  map[string][]string
+	dnsCatalogZones    map[string][]string
+	dns64Prefix        *net.IPNet
+	dnsSinkhole        []net.IP
+	dnsResolver        string
+	dnsMinTTL          uint32
+	dnsMaxTTL          uint32
+	dnsNegMinTTL       uint32
+	dnsNegMaxTTL       uint32
+	dnsMinimal         bool
+	dnsPadding         int
+	dnsPatterns        []string
+	dnsHandler         DNSHandler // the chain answering queries, once built
+}
+
+// DHCPInstance is the DHCP service on one interface, serving the subnet of
+// one zone
+type DHCPInstance struct {
+	NIC           string
+	IP            net.IP // nil to use the interface's address in Subnet
+	Zone          string
+	Domain        string
+	Subnet        *net.IPNet
+	Gateway       net.IP
+	Pool          *net.IPNet
+	LeaseDuration time.Duration
+	Retention     time.Duration // how long an expired lease's address is kept for its client
+	TFTP          string
+	Filter        DHCPMACFilter
+	DHCPZoneOptions
+}
+
+// DHCPZoneOptions are the options that a zone, or all zones, may set
+type DHCPZoneOptions struct {
+	DomainSearch     []byte   // encoded option 119
+	Routes           []byte   // encoded option 121
+	VendorOptions    []string // see parseDHCPVendorOption
+	V6OnlyWait       uint32   // seconds for option 108, or 0 to keep IPv4 for everyone
+	CaptivePortal    string   // URL for option 114
+	Over6            bool     // also serve DHCPv4 over DHCPv6 (RFC 7341)
+	PortalIP         net.IP   // DNS answer for clients not yet authorized, if any
+	PortalLease      time.Duration
+	Hostname         string // template of the names clients are registered under in DNS
+	HostnameConflict string // reject, suffix or steal, when the name is another device's
+	GenericNames     string // template naming every address of the pool, instead of registering leases
+}
+
+// DHCPMACFilter decides which clients are served. Patterns are MACs, or MAC
+// prefixes such as an OUI followed by *. The deny list wins over the allow
+// list. When the allow list is not empty, other clients are served from the
+// Quarantine zone if there is one, and ignored otherwise.
+type DHCPMACFilter struct {
+	Allow      []string
+	Deny       []string
+	Quarantine *DHCPInstance
 }
 
 type ConfigProvider interface {
 	//Get(key string) string
 	GetConfig() (*Config, error)
+	ExplainConfig(cfg *Config) ([]ConfigValue, error)
 }
 
-var setZone = flag.String("setZone", "", "Overwrite (permanently) the zone that this machine is in.")
-var setDHCPIP = flag.String("setDHCPIP", "", "Overwrite (permanently) the DHCP hosting IP for this machine (or set it to empty to disable DHCP).")
-var setDHCPNIC = flag.String("setDHCPNIC", "", "Overwrite (permanently) the DHCP hosting NIC name for this machine (or set it to empty to disable DHCP).")
-var setDHCPSubnet = flag.String("setDHCPSubnet", "", "Overwrite (permanently) the DHCP subnet for this zone (requires setZone flag or it'll no-op).")
-var setDHCPLeaseDuration = flag.String("setDHCPLeaseDuration", "", "Overwrite (permanently) the default DHCP lease duration for this zone (requires setZone flag or it'll no-op).")
-var setDHCPTFTP = flag.String("setDHCPTFTP", "", "Overwrite (permanently) the DHCP TFTP Server Name for this machine (or set it to empty to disable DHCP).")
+var setZone = Flags.String("setZone", "", "Overwrite (permanently) the zone that this machine is in.")
+var setDHCPIP = Flags.String("setDHCPIP", "", "Overwrite (permanently) the DHCP hosting IP for this machine (or set it to empty to disable DHCP).")
+var setDHCPNIC = Flags.String("setDHCPNIC", "", "Overwrite (permanently) the DHCP hosting NIC name for this machine (or set it to empty to disable DHCP).")
+var setDHCPSubnet = Flags.String("setDHCPSubnet", "", "Overwrite (permanently) the DHCP subnet for this zone (requires setZone flag or it'll no-op).")
+var setDHCPLeaseDuration = Flags.String("setDHCPLeaseDuration", "", "Overwrite (permanently) the default DHCP lease duration for this zone (requires setZone flag or it'll no-op).")
+var setDHCPTFTP = Flags.String("setDHCPTFTP", "", "Overwrite (permanently) the DHCP TFTP Server Name for this machine (or set it to empty to disable DHCP).")
+var dnsStatic = Flags.String("dnsstatic", "", "Comma-separated list of static DNS records (name=TYPE:value), which take precedence over etcd and may have commas in their values; defaults to $NETCORE_DNSSTATIC.")
 
 // ErrNoZone is an error returned during config init to indicate that the host has not been assigned to a zone in etcd keyed off of its hostname
 var ErrNoZone = errors.New("This host has not been assigned to a zone.")
@@ -55,6 +127,13 @@ func (cfg *Config) Hostname() string {
 	return cfg.hostname
 }
 
+// Identity returns what tells this host apart from the others
+func (cfg *Config) Identity() HostIdentity {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.identity
+}
+
 // Zone returns the zone name
 func (cfg *Config) Zone() string {
 	cfg.Lock()
@@ -104,6 +183,14 @@ func (cfg *Config) DHCPSubnet() *net.IPNet {
 	return cfg.dhcpSubnet
 }
 
+// DHCPGenericNames returns the template that names every address of this
+// zone's DHCP pool in DNS, or "" to register leases under their own names
+func (cfg *Config) DHCPGenericNames() string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dhcpOptions.GenericNames
+}
+
 // DHCPLeaseDuration returns the default DHCP lease duration for this zone
 func (cfg *Config) DHCPLeaseDuration() time.Duration {
 	cfg.Lock()
@@ -118,6 +205,32 @@ func (cfg *Config) DHCPTFTP() string {
 	return cfg.dhcpTFTP
 }
 
+// DHCPInstances returns the interfaces to serve DHCP on: DHCPNIC for this
+// zone when it is fully configured, and any others assigned to this host
+func (cfg *Config) DHCPInstances() []*DHCPInstance {
+	cfg.Lock()
+	defer cfg.Unlock()
+	var instances []*DHCPInstance
+	if cfg.dhcpIP != nil && cfg.dhcpSubnet != nil && cfg.dhcpNIC != "" {
+		instances = append(instances, &DHCPInstance{
+			NIC:           cfg.dhcpNIC,
+			IP:            cfg.dhcpIP,
+			Zone:          cfg.zone,
+			Domain:        cfg.domain,
+			Subnet:        cfg.subnet,
+			Gateway:       cfg.gateway,
+			Pool:          cfg.dhcpSubnet,
+			LeaseDuration: cfg.dhcpLeaseDuration,
+			Retention:     cfg.dhcpRetention,
+			TFTP:          cfg.dhcpTFTP,
+			Filter:        cfg.dhcpFilter,
+
+			DHCPZoneOptions: cfg.dhcpOptions,
+		})
+	}
+	return append(instances, cfg.dhcpInterfaces...)
+}
+
 // DNSForwarders returns the list of DNS resolvers we use for recursive lookups
 func (cfg *Config) DNSForwarders() []string {
 	cfg.Lock()
@@ -125,6 +238,37 @@ func (cfg *Config) DNSForwarders() []string {
 	return cfg.dnsForwarders
 }
 
+// DNSForwarderPools returns the text of the named forwarder pools, by name
+func (cfg *Config) DNSForwarderPools() map[string]string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsForwarderPools
+}
+
+// DNSForwardRules returns the ordered list of rules picking the forwarder
+// pool of questions, for this zone
+func (cfg *Config) DNSForwardRules() []string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsForwardRules
+}
+
+// DNSForwardOverrides returns the settings changing forwarded answers, by
+// domain
+func (cfg *Config) DNSForwardOverrides() map[string]string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsForwardOverride
+}
+
+// DNSSpecialUse returns the policies that change how special-use domains
+// are answered, by domain
+func (cfg *Config) DNSSpecialUse() map[string]string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsSpecialUse
+}
+
 // DNSCacheMaxTTL returns the maximum duration for which answers will be stored
 // in the cache
 func (cfg *Config) DNSCacheMaxTTL() time.Duration {
@@ -139,3 +283,160 @@ func (cfg *Config) DNSCacheMissingTTL() time.Duration {
 	defer cfg.Unlock()
 	return cfg.dnsCacheMissingTTL
 }
+
+// DNSCacheMemory returns the bytes that cached answers may take, or 0 if
+// the cache is unbounded
+func (cfg *Config) DNSCacheMemory() int {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsCacheMemory
+}
+
+// DNSCacheMaxRRset returns the size in bytes of the largest answer that a
+// cache with a memory budget keeps
+func (cfg *Config) DNSCacheMaxRRset() int {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsCacheMaxRRset
+}
+
+// DNSChain returns the ordered list of middlewares that process DNS questions
+func (cfg *Config) DNSChain() []string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsChain
+}
+
+// DNSHandler returns the DNS chain answering this instance's queries, or nil
+// if it does not serve DNS
+func (cfg *Config) DNSHandler() DNSHandler {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsHandler
+}
+
+// DNSRewriteRules returns the ordered list of DNS rewrite rules for this zone
+func (cfg *Config) DNSRewriteRules() []string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsRewriteRules
+}
+
+// DNSPolicyRules returns the ordered list of DNS policy rules for this zone
+func (cfg *Config) DNSPolicyRules() []string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsPolicyRules
+}
+
+// DNSPortalRules returns the ordered list of rules for clients waiting in
+// the captive portal, for this zone
+func (cfg *Config) DNSPortalRules() []string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsPortalRules
+}
+
+// DNSStaticRecords returns the static DNS record declarations from the
+// instance configuration
+func (cfg *Config) DNSStaticRecords() []string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsStaticRecords
+}
+
+// DNSSecondaryZones returns the zones this zone serves as a secondary, keyed
+// by zone name, with the primaries to transfer each zone from
+func (cfg *Config) DNSSecondaryZones() map[string][]string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsSecondaryZones
+}
+
+// DNSCatalogZones returns the catalog zones this zone consumes, keyed by
+// catalog name, with the primaries to transfer each catalog and its members
+// from
+func (cfg *Config) DNSCatalogZones() map[string][]string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsCatalogZones
+}
+
+// DNS64Prefix returns the prefix used to synthesize AAAA records from A
+// records, or nil if DNS64 is disabled
+func (cfg *Config) DNS64Prefix() *net.IPNet {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dns64Prefix
+}
+
+// DNSSinkhole returns the addresses that sinkhole policy rules answer with
+func (cfg *Config) DNSSinkhole() []net.IP {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsSinkhole
+}
+
+// DNSResolver returns how questions outside of our authority are resolved:
+// "forward" to the configured forwarders, or "iterate" from the root servers
+func (cfg *Config) DNSResolver() string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsResolver
+}
+
+// DNSMinimalResponses returns whether answers leave out the authority and
+// additional records they do not need
+func (cfg *Config) DNSMinimalResponses() bool {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsMinimal
+}
+
+// DNSPadding returns the block size that responses to clients asking for
+// padding are padded to, or 0 not to pad them
+func (cfg *Config) DNSPadding() int {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsPadding
+}
+
+// DNSMinTTL returns the lowest TTL given to answers from forwarders and
+// iterative resolution, or 0 for no minimum
+func (cfg *Config) DNSMinTTL() uint32 {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsMinTTL
+}
+
+// DNSMaxTTL returns the highest TTL given to answers from forwarders and
+// iterative resolution, or 0 for no maximum
+func (cfg *Config) DNSMaxTTL() uint32 {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsMaxTTL
+}
+
+// DNSNegativeMinTTL returns the lowest negative TTL, that of the SOA record
+// of negative answers from forwarders, or 0 for no minimum
+func (cfg *Config) DNSNegativeMinTTL() uint32 {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsNegMinTTL
+}
+
+// DNSNegativeMaxTTL returns the highest negative TTL of answers from
+// forwarders, or 0 for no maximum
+func (cfg *Config) DNSNegativeMaxTTL() uint32 {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsNegMaxTTL
+}
+
+// DNSPatterns returns the templates of names that encode their addresses, for
+// which records are synthesized
+func (cfg *Config) DNSPatterns() []string {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return cfg.dnsPatterns
+}
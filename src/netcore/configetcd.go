@@ -1,10 +1,10 @@
-package main
+package netcore
 
 import (
 	"fmt"
 	"net"
 	"os"
-	"regexp"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -13,13 +13,29 @@ import (
 )
 
 func (db EtcdDB) GetConfig() (*Config, error) {
-	fmt.Println("Getting CONFIG")
+	return loadConfig(db, db.client)
+}
+
+// LoadConfig is the configuration in settings, keyed as in the config tree
+// of etcd (such as "myhost/zone" or "lan/subnet"), for databases that keep
+// their configuration elsewhere
+func LoadConfig(db DB, settings map[string]string) (*Config, error) {
+	kv := newMemKV()
+	for key, value := range settings {
+		if _, err := kv.Set("config/"+key, value, 0); err != nil {
+			return nil, err
+		}
+	}
+	return loadConfig(db, kv)
+}
 
-	etc := db.client
+// loadConfig reads the host and zone configuration from etc, which is usually
+// the live etcd cluster but may be a snapshot of it
+func loadConfig(db DB, etc etcdKV) (*Config, error) {
+	debugf("Getting CONFIG\n")
 
-	fmt.Println("precreate")
-	etc.CreateDir("config", 0)
-	fmt.Println("postcreate")
+	// Settings are only ever written here by the -set* flags
+	etc = auditedKV{etcdKV: etc, audit: db, actor: "flags", kind: "config"}
 
 	cfg := &Config{
 		db: db,
@@ -27,23 +43,20 @@ func (db EtcdDB) GetConfig() (*Config, error) {
 
 	// Hostname
 	{
-		var hostname string
-		if len(os.Getenv("NETCORE_NAME")) > 0 {
-			hostname = os.Getenv("NETCORE_NAME")
-		} else if len(os.Getenv("ETCD_NAME")) > 0 {
-			re := regexp.MustCompile(`^/([^/]+)/`)
-			hostnameParts := re.FindStringSubmatch(os.Getenv("ETCD_NAME"))
-			if len(hostnameParts) > 1 && len(hostnameParts[1]) > 0 {
-				hostname = hostnameParts[1]
-			}
-		} else {
-			var err error
-			hostname, err = getHostname()
-			if err != nil {
-				return nil, err
-			}
+		hostname, err := InstanceName()
+		if err != nil {
+			return nil, err
 		}
 		cfg.hostname = hostname
+		cfg.identity = loadIdentity(db, hostname)
+	}
+
+	// Settings from flags and the environment hide those stored in etcd
+	{
+		var err error
+		if etc, err = withConfigOverrides(etc, cfg.hostname); err != nil {
+			return nil, err
+		}
 	}
 
 	// Zone
@@ -55,7 +68,7 @@ func (db EtcdDB) GetConfig() (*Config, error) {
 		} else {
 			response, err = etc.Get("config/"+cfg.hostname+"/zone", false, false)
 		}
-		if err != nil {
+		if err != nil && !etcdKeyNotFound(err) {
 			return nil, err
 		}
 		if response == nil || response.Node == nil || response.Node.Value == "" {
@@ -182,6 +195,22 @@ func (db EtcdDB) GetConfig() (*Config, error) {
 		}
 	}
 
+	// DHCPRetention
+	{
+		cfg.dhcpRetention = defaultDHCPRetention
+		response, err := etc.Get("config/"+cfg.zone+"/dhcpretention", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil && response.Node.Value != "" {
+			value, err := strconv.Atoi(response.Node.Value)
+			if err != nil {
+				return nil, fmt.Errorf("dhcpretention: %s", err)
+			}
+			cfg.dhcpRetention = time.Duration(value) * time.Minute
+		}
+	}
+
 	// DHCPTFTP
 	{
 		var response *etcd.Response
@@ -199,6 +228,50 @@ func (db EtcdDB) GetConfig() (*Config, error) {
 		}
 	}
 
+	// Every other setting is checked before it is parsed, so that all the
+	// mistakes are reported at once
+	if err := validateConfig(etc, cfg.hostname, cfg.zone); err != nil {
+		return nil, err
+	}
+
+	// DHCPZoneOptions
+	{
+		var err error
+		cfg.dhcpOptions, err = loadDHCPZoneOptions(etc, cfg.zone, cfg.gateway)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// DHCPMACFilter
+	{
+		var err error
+		if cfg.dhcpFilter, err = loadDHCPMACFilter(etc, cfg.dhcpNIC, cfg.zone); err != nil {
+			return nil, err
+		}
+	}
+
+	// DHCPInterfaces, served in addition to DHCPNIC
+	{
+		response, err := etc.Get("config/"+cfg.hostname+"/dhcpinterfaces", true, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if node.Dir || node.Value == "" {
+					continue
+				}
+				instance, err := loadDHCPInterface(etc, path.Base(node.Key), node.Value)
+				if err != nil {
+					return nil, err
+				}
+				instance.TFTP = cfg.dhcpTFTP
+				cfg.dhcpInterfaces = append(cfg.dhcpInterfaces, instance)
+			}
+		}
+	}
+
 	// DNSForwarders
 	{
 		cfg.dnsForwarders = []string{"8.8.8.8:53", "8.8.4.4:53"} // default uses Google's Public DNS servers
@@ -207,7 +280,70 @@ func (db EtcdDB) GetConfig() (*Config, error) {
 			return nil, err
 		}
 		if response != nil && response.Node != nil && response.Node.Value != "" {
-			cfg.dnsForwarders = strings.Split(",", response.Node.Value)
+			cfg.dnsForwarders = splitDHCPList(response.Node.Value)
+		}
+	}
+
+	// DNSForwarderPools
+	{
+		cfg.dnsForwarderPools = make(map[string]string)
+		response, err := etc.Get("config/"+cfg.zone+"/dnsforwarderpool", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsForwarderPools[path.Base(node.Key)] = node.Value
+				}
+			}
+		}
+	}
+
+	// DNSForwardRules
+	{
+		response, err := etc.Get("config/"+cfg.zone+"/dnsforward", true, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsForwardRules = append(cfg.dnsForwardRules, node.Value)
+				}
+			}
+		}
+	}
+
+	// DNSForwardOverrides
+	{
+		cfg.dnsForwardOverride = make(map[string]string)
+		response, err := etc.Get("config/"+cfg.zone+"/dnsforwardoverride", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsForwardOverride[path.Base(node.Key)] = node.Value
+				}
+			}
+		}
+	}
+
+	// DNSSpecialUse
+	{
+		cfg.dnsSpecialUse = make(map[string]string)
+		response, err := etc.Get("config/"+cfg.zone+"/dnsspecialuse", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsSpecialUse[path.Base(node.Key)] = node.Value
+				}
+			}
 		}
 	}
 
@@ -227,7 +363,7 @@ func (db EtcdDB) GetConfig() (*Config, error) {
 		}
 	}
 
-	// dnsCacheMissingTTL
+	// dnsCacheMissingTTL (how long negative answers are cached)
 	{
 		cfg.dnsCacheMissingTTL = 30 * time.Second // default setting is 30 seconds
 		response, err := etc.Get("config/"+cfg.zone+"/dnscachemissingttl", false, false)
@@ -243,7 +379,482 @@ func (db EtcdDB) GetConfig() (*Config, error) {
 		}
 	}
 
-	fmt.Printf("CONFIG: [%+v]\n", cfg)
+	// dnsCacheMemory and dnsCacheMaxRRset (the memory budget of the cache)
+	{
+		cfg.dnsCacheMemory, cfg.dnsCacheMaxRRset = 0, defaultDNSCacheMaxRRset
+		for key, setting := range map[string]*int{"dnscachememory": &cfg.dnsCacheMemory, "dnscachemaxrrset": &cfg.dnsCacheMaxRRset} {
+			response, err := etc.Get("config/"+cfg.zone+"/"+key, false, false)
+			if err != nil && !etcdKeyNotFound(err) {
+				return nil, err
+			}
+			if response != nil && response.Node != nil && response.Node.Value != "" {
+				value, err := strconv.Atoi(response.Node.Value)
+				if err != nil || value < 0 {
+					return nil, fmt.Errorf("%s must be a size, not %q", key, response.Node.Value)
+				}
+				*setting = value
+			}
+		}
+		cfg.dnsCacheMemory <<= 20 // megabytes
+	}
+
+	// DNSMinTTL and DNSMaxTTL, and their negative counterparts
+	for key, ttl := range map[string]*uint32{"dnsminttl": &cfg.dnsMinTTL, "dnsmaxttl": &cfg.dnsMaxTTL, "dnsnegminttl": &cfg.dnsNegMinTTL, "dnsnegmaxttl": &cfg.dnsNegMaxTTL} {
+		response, err := etc.Get("config/"+cfg.zone+"/"+key, false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil && response.Node.Value != "" {
+			value, err := strconv.ParseUint(response.Node.Value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			*ttl = uint32(value)
+		}
+	}
+	if cfg.dnsMaxTTL > 0 && cfg.dnsMinTTL > cfg.dnsMaxTTL {
+		return nil, fmt.Errorf("dnsminttl %d is greater than dnsmaxttl %d", cfg.dnsMinTTL, cfg.dnsMaxTTL)
+	}
+	if cfg.dnsNegMaxTTL > 0 && cfg.dnsNegMinTTL > cfg.dnsNegMaxTTL {
+		return nil, fmt.Errorf("dnsnegminttl %d is greater than dnsnegmaxttl %d", cfg.dnsNegMinTTL, cfg.dnsNegMaxTTL)
+	}
+
+	// DNSChain
+	{
+		cfg.dnsChain = defaultDNSChain
+		response, err := etc.Get("config/"+cfg.zone+"/dnschain", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil && response.Node.Value != "" {
+			cfg.dnsChain = strings.Split(response.Node.Value, ",")
+		}
+	}
+
+	// DNSRewriteRules
+	{
+		response, err := etc.Get("config/"+cfg.zone+"/dnsrewrite", true, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsRewriteRules = append(cfg.dnsRewriteRules, node.Value)
+				}
+			}
+		}
+	}
+
+	// DNSPolicyRules
+	{
+		response, err := etc.Get("config/"+cfg.zone+"/dnspolicy", true, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsPolicyRules = append(cfg.dnsPolicyRules, node.Value)
+				}
+			}
+		}
+	}
+
+	// DNSPortalRules
+	{
+		response, err := etc.Get("config/"+cfg.zone+"/dnsportal", true, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsPortalRules = append(cfg.dnsPortalRules, node.Value)
+				}
+			}
+		}
+	}
+
+	// DNSPatterns
+	{
+		response, err := etc.Get("config/"+cfg.zone+"/dnspattern", true, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsPatterns = append(cfg.dnsPatterns, node.Value)
+				}
+			}
+		}
+	}
+
+	// DNSSecondaryZones
+	{
+		cfg.dnsSecondaryZones = make(map[string][]string)
+		response, err := etc.Get("config/"+cfg.zone+"/dnssecondary", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsSecondaryZones[path.Base(node.Key)] = strings.Split(node.Value, ",")
+				}
+			}
+		}
+	}
+
+	// DNSCatalogZones
+	{
+		cfg.dnsCatalogZones = make(map[string][]string)
+		response, err := etc.Get("config/"+cfg.zone+"/dnscatalog", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					cfg.dnsCatalogZones[path.Base(node.Key)] = strings.Split(node.Value, ",")
+				}
+			}
+		}
+	}
+
+	// DNS64Prefix
+	{
+		response, err := etc.Get("config/"+cfg.zone+"/dns64", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil && response.Node.Value != "" {
+			value := response.Node.Value
+			if value == "on" {
+				value = dns64WellKnownPrefix
+			}
+			prefix, err := parseDNS64Prefix(value)
+			if err != nil {
+				return nil, err
+			}
+			cfg.dns64Prefix = prefix
+		}
+	}
+
+	// DNSSinkhole
+	{
+		response, err := etc.Get("config/"+cfg.zone+"/dnssinkhole", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			for _, value := range splitDHCPList(response.Node.Value) {
+				ip := net.ParseIP(value)
+				if ip == nil {
+					return nil, fmt.Errorf("dnssinkhole must be a list of addresses, not %q", response.Node.Value)
+				}
+				cfg.dnsSinkhole = append(cfg.dnsSinkhole, ip)
+			}
+		}
+	}
+
+	// DNSResolver
+	{
+		cfg.dnsResolver = "forward"
+		response, err := etc.Get("config/"+cfg.zone+"/dnsresolver", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil && response.Node.Value != "" {
+			switch response.Node.Value {
+			case "forward", "iterate":
+				cfg.dnsResolver = response.Node.Value
+			default:
+				return nil, fmt.Errorf("dnsresolver must be forward or iterate, not %q", response.Node.Value)
+			}
+		}
+	}
+
+	// DNSMinimalResponses
+	{
+		cfg.dnsMinimal = false
+		response, err := etc.Get("config/"+cfg.zone+"/dnsminimal", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil && response.Node.Value != "" {
+			switch response.Node.Value {
+			case "on", "off":
+				cfg.dnsMinimal = response.Node.Value == "on"
+			default:
+				return nil, fmt.Errorf("dnsminimal must be on or off, not %q", response.Node.Value)
+			}
+		}
+	}
+
+	// DNSPadding
+	{
+		cfg.dnsPadding = defaultDNSPadding
+		response, err := etc.Get("config/"+cfg.zone+"/dnspadding", false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil && response.Node.Value != "" {
+			value, err := strconv.Atoi(response.Node.Value)
+			if err != nil || value < 0 || value > 65535 {
+				return nil, fmt.Errorf("dnspadding must be a block size in bytes, not %q", response.Node.Value)
+			}
+			cfg.dnsPadding = value
+		}
+	}
+
+	// DNSStaticRecords (instance configuration, never stored in etcd)
+	{
+		static := *dnsStatic
+		if static == "" {
+			static = os.Getenv("NETCORE_DNSSTATIC")
+		}
+		if static != "" {
+			cfg.dnsStaticRecords = splitDNSStaticRecords(static)
+		}
+	}
+
+	debugf("CONFIG: [%+v]\n", cfg)
 
 	return cfg, nil
 }
+
+// loadDHCPZoneOptions reads the DHCP options that are set for zone, or else
+// for all zones
+func loadDHCPZoneOptions(etc etcdKV, zone string, gateway net.IP) (DHCPZoneOptions, error) {
+	var options DHCPZoneOptions
+	value := func(key string) (string, error) {
+		for _, k := range []string{"config/" + zone + "/" + key, "config/" + key} {
+			response, err := etc.Get(k, false, false)
+			if err != nil && !etcdKeyNotFound(err) {
+				return "", err
+			}
+			if response != nil && response.Node != nil && !response.Node.Dir {
+				return response.Node.Value, nil
+			}
+		}
+		return "", nil
+	}
+
+	v, err := value("dhcpsearch")
+	if err != nil {
+		return options, err
+	}
+	if options.DomainSearch, err = encodeDomainSearch(v); err != nil {
+		return options, fmt.Errorf("dhcpsearch: %s", err)
+	}
+	if v, err = value("dhcproutes"); err != nil {
+		return options, err
+	}
+	if options.Routes, err = encodeClasslessRoutes(v, gateway); err != nil {
+		return options, fmt.Errorf("dhcproutes: %s", err)
+	}
+	if v, err = value("dhcpv6only"); err != nil {
+		return options, err
+	}
+	if v != "" {
+		seconds, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return options, fmt.Errorf("dhcpv6only: %s", err)
+		}
+		if seconds < minV6OnlyWait {
+			seconds = minV6OnlyWait
+		}
+		options.V6OnlyWait = uint32(seconds)
+	}
+	if options.CaptivePortal, err = value("dhcpcaptiveportal"); err != nil {
+		return options, err
+	}
+	if options.CaptivePortal != "" && !strings.HasPrefix(options.CaptivePortal, "https://") {
+		return options, fmt.Errorf("dhcpcaptiveportal must be an https URL, not %q", options.CaptivePortal)
+	}
+	if v, err = value("dhcp4o6"); err != nil {
+		return options, err
+	}
+	switch v {
+	case "", "off":
+	case "on":
+		options.Over6 = true
+	default:
+		return options, fmt.Errorf("dhcp4o6 must be on or off, not %q", v)
+	}
+
+	if v, err = value("dhcpportal"); err != nil {
+		return options, err
+	}
+	if fields := strings.Fields(v); len(fields) > 0 {
+		if options.PortalIP = net.ParseIP(fields[0]).To4(); options.PortalIP == nil || len(fields) > 2 {
+			return options, fmt.Errorf("dhcpportal must be \"<portal IP> [lease minutes]\", not %q", v)
+		}
+		options.PortalLease = 5 * time.Minute
+		if len(fields) == 2 {
+			minutes, err := strconv.Atoi(fields[1])
+			if err != nil || minutes < 1 {
+				return options, fmt.Errorf("dhcpportal: bad lease minutes %q", fields[1])
+			}
+			options.PortalLease = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	if options.Hostname, err = value("dhcphostname"); err != nil {
+		return options, err
+	}
+	if options.Hostname == "" {
+		options.Hostname = defaultDHCPHostname
+	} else if _, err := parseDHCPHostnameTemplate(options.Hostname); err != nil {
+		return options, fmt.Errorf("dhcphostname: %s", err)
+	}
+
+	if options.HostnameConflict, err = value("dhcphostnameconflict"); err != nil {
+		return options, err
+	}
+	switch options.HostnameConflict {
+	case "":
+		options.HostnameConflict = hostnameConflictSuffix
+	case hostnameConflictReject, hostnameConflictSuffix, hostnameConflictSteal:
+	default:
+		return options, fmt.Errorf("dhcphostnameconflict must be reject, suffix or steal, not %q", options.HostnameConflict)
+	}
+
+	if options.GenericNames, err = value("dhcpgenericnames"); err != nil {
+		return options, err
+	}
+	if options.GenericNames != "" {
+		if _, err := parseDHCPGenericNames(options.GenericNames, nil); err != nil {
+			return options, fmt.Errorf("dhcpgenericnames: %s", err)
+		}
+	}
+
+	// Vendor options for the zone come first, so that they win
+	for _, key := range []string{"config/" + zone + "/dhcpvendor", "config/dhcpvendor"} {
+		response, err := etc.Get(key, true, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return options, err
+		}
+		if response != nil && response.Node != nil {
+			for _, node := range response.Node.Nodes {
+				if !node.Dir && node.Value != "" {
+					options.VendorOptions = append(options.VendorOptions, node.Value)
+				}
+			}
+		}
+	}
+	return options, nil
+}
+
+// loadDHCPInterface reads the settings for serving DHCP on nic, declared as
+// "zone [server IP]". The zone must have a subnet, gateway and DHCP subnet.
+// Without a server IP, the interface's own address in the subnet is used.
+func loadDHCPInterface(etc etcdKV, nic, decl string) (*DHCPInstance, error) {
+	instance, err := loadDHCPZone(etc, nic, decl)
+	if err != nil {
+		return nil, err
+	}
+	if instance.Filter, err = loadDHCPMACFilter(etc, nic, instance.Zone); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// loadDHCPZone reads the settings of loadDHCPInterface other than the MAC
+// filter
+func loadDHCPZone(etc etcdKV, nic, decl string) (*DHCPInstance, error) {
+	fields := strings.Fields(decl)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("dhcp interface %s: expected \"zone [server IP]\", not %q", nic, decl)
+	}
+	instance := &DHCPInstance{NIC: nic, Zone: fields[0], LeaseDuration: 12 * time.Hour, Retention: defaultDHCPRetention}
+	if len(fields) == 2 {
+		if instance.IP = net.ParseIP(fields[1]).To4(); instance.IP == nil {
+			return nil, fmt.Errorf("dhcp interface %s: %q is not an IPv4 address", nic, fields[1])
+		}
+	}
+
+	settings := make(map[string]string)
+	for _, key := range []string{"domain", "subnet", "gateway", "dhcpsubnet", "dhcpleaseduration", "dhcpretention"} {
+		response, err := etc.Get("config/"+instance.Zone+"/"+key, false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		if response != nil && response.Node != nil {
+			settings[key] = response.Node.Value
+		}
+	}
+	instance.Domain = settings["domain"]
+	var err error
+	if _, instance.Subnet, err = net.ParseCIDR(settings["subnet"]); err != nil {
+		return nil, fmt.Errorf("dhcp interface %s: zone %s: bad subnet: %s", nic, instance.Zone, err)
+	}
+	if instance.Gateway = net.ParseIP(settings["gateway"]).To4(); instance.Gateway == nil {
+		return nil, fmt.Errorf("dhcp interface %s: zone %s: %s", nic, instance.Zone, ErrNoGateway)
+	}
+	if _, instance.Pool, err = net.ParseCIDR(settings["dhcpsubnet"]); err != nil {
+		return nil, fmt.Errorf("dhcp interface %s: zone %s: bad DHCP subnet: %s", nic, instance.Zone, err)
+	}
+	if value := settings["dhcpleaseduration"]; value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("dhcp interface %s: zone %s: bad DHCP lease duration: %s", nic, instance.Zone, err)
+		}
+		instance.LeaseDuration = time.Duration(minutes) * time.Minute
+	}
+	if value := settings["dhcpretention"]; value != "" {
+		minutes, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("dhcp interface %s: zone %s: bad DHCP retention: %s", nic, instance.Zone, err)
+		}
+		instance.Retention = time.Duration(minutes) * time.Minute
+	}
+	instance.DHCPZoneOptions, err = loadDHCPZoneOptions(etc, instance.Zone, instance.Gateway)
+	if err != nil {
+		return nil, fmt.Errorf("dhcp interface %s: zone %s: %s", nic, instance.Zone, err)
+	}
+	return instance, nil
+}
+
+// loadDHCPMACFilter reads the MAC allow and deny lists of zone, and what to
+// do with clients that are not on the allow list: "deny" them, which is the
+// default, or "quarantine <zone> [server IP]" to serve them from another
+// zone on the same interface
+func loadDHCPMACFilter(etc etcdKV, nic, zone string) (DHCPMACFilter, error) {
+	var filter DHCPMACFilter
+	settings := make(map[string]string)
+	for _, key := range []string{"dhcpallow", "dhcpdeny", "dhcpunknown"} {
+		response, err := etc.Get("config/"+zone+"/"+key, false, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return filter, err
+		}
+		if response != nil && response.Node != nil {
+			settings[key] = response.Node.Value
+		}
+	}
+	for key, list := range map[string]*[]string{"dhcpallow": &filter.Allow, "dhcpdeny": &filter.Deny} {
+		for _, pattern := range strings.Split(settings[key], ",") {
+			if pattern = strings.TrimSpace(pattern); pattern == "" {
+				continue
+			}
+			if _, err := parseMACPattern(pattern); err != nil {
+				return filter, fmt.Errorf("%s for zone %s: %s", key, zone, err)
+			}
+			*list = append(*list, pattern)
+		}
+	}
+	switch unknown := strings.TrimSpace(settings["dhcpunknown"]); {
+	case unknown == "" || unknown == "deny":
+	case strings.HasPrefix(unknown, "quarantine "):
+		quarantine, err := loadDHCPZone(etc, nic, strings.TrimPrefix(unknown, "quarantine "))
+		if err != nil {
+			return filter, fmt.Errorf("dhcpunknown for zone %s: %s", zone, err)
+		}
+		filter.Quarantine = quarantine
+	default:
+		return filter, fmt.Errorf("dhcpunknown for zone %s must be deny or quarantine <zone>, not %q", zone, unknown)
+	}
+	return filter, nil
+}
@@ -0,0 +1,331 @@
+package netcore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var configFile = Flags.String("config", "", "Read the configuration from this YAML file instead of etcd; with no -backend, everything else is kept in memory too.")
+var dbBackend = Flags.String("backend", "", "Where leases, records and the audit log are kept: etcd or memory; defaults to memory with -config and etcd otherwise.")
+
+// FileConfigDB is a database whose configuration comes from a local file
+// rather than from the database itself
+type FileConfigDB struct {
+	DB
+	kv etcdKV
+}
+
+func (f FileConfigDB) GetConfig() (*Config, error) {
+	return loadConfig(f, f.kv)
+}
+
+func (f FileConfigDB) ExplainConfig(cfg *Config) ([]ConfigValue, error) {
+	return explainConfig(f.kv, cfg.Hostname(), cfg.Zone())
+}
+
+// loadConfigFile reads a configuration file. Its config section mirrors the
+// config tree in etcd, host and zone names first:
+//
+//	config:
+//	  myhost:
+//	    zone: lan
+//	    dhcpip: 10.0.0.2
+//	  lan:
+//	    subnet: 10.0.0.0/24
+//	    dnsforwarders:
+//	      - 9.9.9.9:53
+//
+// Its zones section holds records for each zone, inline as a list of
+// "name TYPE value [attr=value ...]" lines, or in a file of such lines named
+// relative to the configuration file. A ttl attribute sets the TTL, and @ is
+// the zone itself.
+func loadConfigFile(path string) (etcdKV, map[string][]DNSChange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	doc, err := parseYAML(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	kv := newMemKV()
+	zones := make(map[string][]DNSChange)
+	for section, value := range doc {
+		switch section {
+		case "config":
+			if err := loadConfigTree(kv, "config", value); err != nil {
+				return nil, nil, fmt.Errorf("%s: %s", path, err)
+			}
+		case "zones":
+			declared, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: zones must map each zone to its records", path)
+			}
+			for zone, records := range declared {
+				lines, err := zoneRecordLines(filepath.Dir(path), records)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: zone %s: %s", path, zone, err)
+				}
+				for _, line := range lines {
+					change, err := parseZoneRecordLine(zone, line)
+					if err != nil {
+						return nil, nil, fmt.Errorf("%s: zone %s: %s", path, zone, err)
+					}
+					zones[zone] = append(zones[zone], change)
+				}
+			}
+		default:
+			return nil, nil, fmt.Errorf("%s: unknown section %q; expected config or zones", path, section)
+		}
+	}
+	return kv, zones, nil
+}
+
+// loadConfigTree writes value to kv under key. Lists become comma-separated
+// values, as in etcd.
+func loadConfigTree(kv etcdKV, key string, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, child := range v {
+			if err := loadConfigTree(kv, key+"/"+name, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []string:
+		value = strings.Join(v, ",")
+	}
+	_, err := kv.Set(key, value.(string), 0)
+	return err
+}
+
+// zoneRecordLines returns the record lines of a zone, which are either
+// inline or in the named file
+func zoneRecordLines(dir string, records interface{}) ([]string, error) {
+	switch r := records.(type) {
+	case []string:
+		return r, nil
+	case string:
+		if !filepath.IsAbs(r) {
+			r = filepath.Join(dir, r)
+		}
+		f, err := os.Open(r)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		return lines, scanner.Err()
+	}
+	return nil, fmt.Errorf("records must be a list or a file name")
+}
+
+// parseZoneRecordLine parses "name TYPE value [attr=value ...]" into a change
+// that adds the record to zone
+func parseZoneRecordLine(zone, line string) (DNSChange, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return DNSChange{}, fmt.Errorf("%q: expected name, type and value", line)
+	}
+	name := fields[0]
+	switch {
+	case name == "@":
+		name = zone
+	case !strings.HasSuffix(name, "."):
+		name = name + "." + zone
+	}
+	r := DNSRecord{Name: name, Type: fields[1], Value: fields[2]}
+	for _, attr := range fields[3:] {
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return DNSChange{}, fmt.Errorf("%q: %q is not attr=value", line, attr)
+		}
+		if parts[0] == "ttl" {
+			ttl, err := strconv.ParseUint(parts[1], 10, 32)
+			if err != nil {
+				return DNSChange{}, fmt.Errorf("%q: bad ttl", line)
+			}
+			r.TTL = uint32(ttl)
+			continue
+		}
+		if r.Attr == nil {
+			r.Attr = make(map[string]string)
+		}
+		r.Attr[parts[0]] = parts[1]
+	}
+	change := DNSChange{Op: "add", Record: r}
+	if err := change.validate(zone); err != nil {
+		return DNSChange{}, err
+	}
+	return change, nil
+}
+
+// applyConfigFileZones adds the records of a configuration file to db.
+// Records are stored under keys derived from their values, so adding them
+// again at every start is harmless.
+func applyConfigFileZones(db DB, zones map[string][]DNSChange) error {
+	for zone, changes := range zones {
+		if err := db.ApplyDNSChanges("config file", changes); err != nil {
+			return fmt.Errorf("zone %s: %s", zone, err)
+		}
+	}
+	return nil
+}
+
+// yamlLine is a line of a YAML document without its indentation
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// parseYAML reads the part of YAML that configuration needs: mappings,
+// lists of scalars, scalars that may be quoted, and comments. Anything else,
+// such as flow style, anchors or multi-line strings, is an error rather than
+// a surprise.
+func parseYAML(r io.Reader) (map[string]interface{}, error) {
+	var lines []yamlLine
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", n)
+		}
+		trimmed = strings.TrimSpace(stripYAMLComment(trimmed))
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{number: n, indent: len(text) - len(strings.TrimLeft(text, " ")), text: trimmed})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	value, rest, err := parseYAMLBlock(lines)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("line %d: unexpected indentation", rest[0].number)
+	}
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("line %d: expected a mapping", lines[0].number)
+	}
+	return doc, nil
+}
+
+// parseYAMLBlock parses the mapping or list that starts with lines[0],
+// returning the lines that follow it
+func parseYAMLBlock(lines []yamlLine) (interface{}, []yamlLine, error) {
+	indent := lines[0].indent
+	if lines[0].text == "-" || strings.HasPrefix(lines[0].text, "- ") {
+		var list []string
+		for len(lines) > 0 && lines[0].indent == indent {
+			line := lines[0]
+			if line.text != "-" && !strings.HasPrefix(line.text, "- ") {
+				break // the next key, when the list is indented as far as its key
+			}
+			item, err := yamlScalar(strings.TrimSpace(strings.TrimPrefix(line.text, "-")))
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %s", line.number, err)
+			}
+			list = append(list, item)
+			lines = lines[1:]
+		}
+		if len(lines) > 0 && lines[0].indent > indent {
+			return nil, nil, fmt.Errorf("line %d: lists may only hold plain values", lines[0].number)
+		}
+		return list, lines, nil
+	}
+
+	mapping := make(map[string]interface{})
+	for len(lines) > 0 && lines[0].indent == indent {
+		line := lines[0]
+		lines = lines[1:]
+		key, rest := line.text, ""
+		if i := strings.Index(line.text, ": "); i >= 0 {
+			key, rest = line.text[:i], strings.TrimSpace(line.text[i+2:])
+		} else if strings.HasSuffix(line.text, ":") {
+			key = strings.TrimSuffix(line.text, ":")
+		} else {
+			return nil, nil, fmt.Errorf("line %d: expected \"key: value\"", line.number)
+		}
+		key, err := yamlScalar(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %s", line.number, err)
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, nil, fmt.Errorf("line %d: %s is given twice", line.number, key)
+		}
+		switch {
+		case rest != "":
+			if mapping[key], err = yamlScalar(rest); err != nil {
+				return nil, nil, fmt.Errorf("line %d: %s", line.number, err)
+			}
+		case len(lines) > 0 && (lines[0].indent > indent || lines[0].indent == indent && strings.HasPrefix(lines[0].text, "-")):
+			// Lists may be indented as far as their key
+			if mapping[key], lines, err = parseYAMLBlock(lines); err != nil {
+				return nil, nil, err
+			}
+		default:
+			mapping[key] = ""
+		}
+	}
+	if len(lines) > 0 && lines[0].indent > indent {
+		return nil, nil, fmt.Errorf("line %d: unexpected indentation", lines[0].number)
+	}
+	return mapping, lines, nil
+}
+
+// yamlScalar unquotes a scalar, refusing the YAML syntax we don't support
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, "\""):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case s != "" && strings.ContainsAny(s[:1], "[{&*!|>%"):
+		return "", fmt.Errorf("%s: quote values that start with %s", s, s[:1])
+	}
+	return s, nil
+}
+
+// stripYAMLComment removes a comment, which starts with # at the beginning
+// of the line or after a space, outside quotes
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return s[:i]
+		}
+	}
+	return s
+}
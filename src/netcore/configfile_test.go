@@ -0,0 +1,108 @@
+package netcore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	doc, err := parseYAML(strings.NewReader(`---
+# comment
+config:
+  myhost:
+    zone: lan   # trailing comment
+    dhcpip: "10.0.0.2"
+  lan:
+    subnet: 10.0.0.0/24
+    dnsforwarders:
+    - 9.9.9.9:53
+    - '149.112.112.112:53'
+    dhcpsearch: "a.example, b#example"
+    empty:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"config": map[string]interface{}{
+			"myhost": map[string]interface{}{"zone": "lan", "dhcpip": "10.0.0.2"},
+			"lan": map[string]interface{}{
+				"subnet":        "10.0.0.0/24",
+				"dnsforwarders": []string{"9.9.9.9:53", "149.112.112.112:53"},
+				"dhcpsearch":    "a.example, b#example",
+				"empty":         "",
+			},
+		},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("parseYAML = %#v, want %#v", doc, want)
+	}
+
+	for _, invalid := range []string{
+		"a: 1\na: 2",               // key given twice
+		"a:\n\tb: 1",               // tab indent
+		"a: [1, 2]",                // flow style
+		"a: &anchor 1",             // anchor
+		"a: |",                     // multi-line string
+		"a:\n  - b\n    - c",       // nested list
+		"a: 1\n  b: 2",             // stray indentation
+		"- a\n- b",                 // not a mapping
+		"a: 'unterminated",         // unterminated quote
+		"just text",                // not key: value
+		"a:\n  - b\n  - c:\n    d", // list of mappings
+	} {
+		if _, err := parseYAML(strings.NewReader(invalid)); err == nil {
+			t.Errorf("parseYAML(%q) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lan.zone"), []byte("# records\nprinter A 10.0.0.9\n\n@ MX mail.lan. priority=10 ttl=300\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "netcore.yaml")
+	if err := os.WriteFile(path, []byte(`config:
+  lan:
+    dnsforwarders:
+      - 9.9.9.9:53
+      - 1.1.1.1:53
+zones:
+  lan.: lan.zone
+  example.:
+    - www A 192.0.2.1
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kv, zones, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := kv.Get("config/lan/dnsforwarders", false, false)
+	if err != nil || response.Node.Value != "9.9.9.9:53,1.1.1.1:53" {
+		t.Errorf("dnsforwarders = %v, %v, want the list joined by commas", response, err)
+	}
+	if len(zones["lan."]) != 2 || len(zones["example."]) != 1 {
+		t.Fatalf("zones = %v", zones)
+	}
+	if r := zones["lan."][0].Record; r.Name != "printer.lan" || r.Type != "A" || r.Value != "10.0.0.9" {
+		t.Errorf("record from the zone file = %+v", r)
+	}
+	if r := zones["lan."][1].Record; r.Name != "lan" || r.TTL != 300 || r.Attr["priority"] != "10" {
+		t.Errorf("record with attributes = %+v", r)
+	}
+	if r := zones["example."][0].Record; r.Name != "www.example" || r.Value != "192.0.2.1" {
+		t.Errorf("inline record = %+v", r)
+	}
+
+	if err := os.WriteFile(path, []byte("other:\n  a: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadConfigFile(path); err == nil {
+		t.Errorf("an unknown section was accepted")
+	}
+}
@@ -0,0 +1,133 @@
+package netcore
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// settingFlags are the -setting flags, each key=value
+type settingFlags []string
+
+func (s *settingFlags) String() string { return strings.Join(*s, " ") }
+
+func (s *settingFlags) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected key=value, not %q", value)
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+var settingOverrides = newSettingFlags("setting", "Override a config setting for this run without writing it, as key=value; may be repeated. Takes precedence over $NETCORE_<KEY>, which takes precedence over the stored config.")
+
+func newSettingFlags(name, usage string) *settingFlags {
+	s := &settingFlags{}
+	Flags.Var(s, name, usage)
+	return s
+}
+
+// envName is the environment variable for a flag or setting
+func envName(name string) string {
+	return "NETCORE_" + strings.ToUpper(name)
+}
+
+// applyEnvFlags sets each flag that was not given on the command line from
+// its environment variable, if there is one, so that flags win over the
+// environment, which wins over defaults
+func applyEnvFlags() error {
+	given := make(map[string]bool)
+	Flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	var err error
+	Flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		if e := f.Value.Set(value); e != nil {
+			err = fmt.Errorf("%s: %s", envName(f.Name), e)
+		}
+	})
+	return err
+}
+
+// configOverride is a setting's value from a flag or the environment, and
+// which one it came from
+type configOverride struct {
+	value  string
+	origin string
+}
+
+// configOverrides returns the settings overridden by -setting flags or
+// environment variables, by key
+func configOverrides() (map[string]configOverride, error) {
+	overrides := make(map[string]configOverride)
+	for _, s := range configSchema {
+		if value, ok := os.LookupEnv(envName(s.key)); ok && !s.dir {
+			overrides[s.key] = configOverride{value, "$" + envName(s.key)}
+		}
+	}
+	for _, setting := range *settingOverrides {
+		parts := strings.SplitN(setting, "=", 2)
+		s, ok := findConfigSetting(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("-setting %s: unknown setting %q", setting, parts[0])
+		}
+		if s.dir {
+			return nil, fmt.Errorf("-setting %s: %s holds several entries and cannot be overridden", setting, s.key)
+		}
+		overrides[s.key] = configOverride{parts[1], "-setting " + s.key}
+	}
+	return overrides, nil
+}
+
+func findConfigSetting(key string) (configSetting, bool) {
+	for _, s := range configSchema {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return configSetting{}, false
+}
+
+// overrideKV answers for the config keys that are overridden, and passes
+// everything else through
+type overrideKV struct {
+	etcdKV
+	values map[string]string // by etcd key
+}
+
+// withConfigOverrides returns etc with the overridden settings of hostname
+// and its zone in place
+func withConfigOverrides(etc etcdKV, hostname string) (etcdKV, error) {
+	overrides, err := configOverrides()
+	if err != nil || len(overrides) == 0 {
+		return etc, err
+	}
+	zone := ""
+	if o, ok := overrides["zone"]; ok {
+		zone = o.value
+	} else if response, err := etc.Get("config/"+hostname+"/zone", false, false); err == nil && response != nil && response.Node != nil {
+		zone = response.Node.Value
+	}
+
+	o := overrideKV{etcdKV: etc, values: make(map[string]string)}
+	for key, override := range overrides {
+		s, _ := findConfigSetting(key)
+		// The first path is the one that takes precedence
+		o.values[s.paths(hostname, zone)[0]] = override.value
+		logger.Printf("Config setting %s is %q from %s\n", key, override.value, override.origin)
+	}
+	return o, nil
+}
+
+func (o overrideKV) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	key = strings.Trim(key, "/")
+	if value, ok := o.values[key]; ok {
+		return &etcd.Response{Action: "get", Node: &etcd.Node{Key: "/" + key, Value: value}}, nil
+	}
+	return o.etcdKV.Get(key, sort, recursive)
+}
@@ -0,0 +1,382 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Where a setting is read from in etcd
+const (
+	scopeHost   = "host"   // config/<hostname>/<key>
+	scopeZone   = "zone"   // config/<zone>/<key>
+	scopeGlobal = "global" // config/<zone>/<key>, or else config/<key> for every zone
+)
+
+// configSetting describes one configuration key that netcore recognizes.
+// Directories, such as dnsrewrite, hold one entry per key below them, each
+// checked on its own.
+type configSetting struct {
+	key         string
+	scope       string
+	dir         bool
+	defaultText string
+	description string
+	check       func(value string) error
+}
+
+// ConfigValue is a setting as it applies to this instance, for
+// netcorectl config explain
+type ConfigValue struct {
+	Key         string `json:"key"`
+	Scope       string `json:"scope"`
+	Value       string `json:"value"`
+	Source      string `json:"source"` // the etcd key the value is from, or "default"
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ConfigError is a setting whose value netcore cannot use
+type ConfigError struct {
+	Path    string
+	Value   string
+	Problem string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s = %q: %s", e.Path, e.Value, e.Problem)
+}
+
+// ConfigErrors are all the unusable settings found at once, so that they can
+// be fixed in one go
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+	return fmt.Sprintf("%d bad configuration setting(s):\n\t%s", len(errs), strings.Join(lines, "\n\t"))
+}
+
+var configSchema = []configSetting{
+	{"zone", scopeHost, false, "", "Zone this machine is in; required.", checkNotEmpty},
+	{"dhcpip", scopeHost, false, "", "Address DHCP is served from; DHCP is disabled without it.", checkIPv4},
+	{"dhcpnic", scopeHost, false, "", "Interface DHCP is served on; DHCP is disabled without it.", nil},
+	{"dhcptftp", scopeHost, false, "", "TFTP server name handed to DHCP clients.", nil},
+	{"dhcpinterfaces", scopeHost, true, "", "More interfaces to serve DHCP on, each <nic> = \"<zone> [server IP]\".", checkDHCPInterface},
+	{"domain", scopeZone, false, "", "Domain of the zone, for DHCP clients' names.", checkDomain},
+	{"subnet", scopeZone, false, "", "Subnet of the zone, such as 10.0.0.0/24; required.", checkCIDR},
+	{"gateway", scopeZone, false, "", "Default gateway of the zone; required.", checkIPv4},
+	{"dhcpsubnet", scopeZone, false, "", "Pool that DHCP leases addresses from; DHCP is disabled without it.", checkCIDR},
+	{"dhcpleaseduration", scopeZone, false, "720", "DHCP lease duration in minutes.", checkRange(1, 525600)},
+	{"dhcpretention", scopeZone, false, strconv.Itoa(int(defaultDHCPRetention.Minutes())), "Minutes an address stays with its last client after the lease ends.", checkRange(0, 525600)},
+	{"dhcpallow", scopeZone, false, "", "Comma-separated MACs or OUIs (b8:27:eb:*) that may get leases; anyone if empty.", checkMACPatterns},
+	{"dhcpdeny", scopeZone, false, "", "Comma-separated MACs or OUIs that never get leases.", checkMACPatterns},
+	{"dhcpunknown", scopeZone, false, "deny", "What to do with clients not on dhcpallow: deny, or quarantine <zone> [server IP].", checkDHCPUnknown},
+	{"dhcpsearch", scopeGlobal, false, "", "Comma-separated domain search list (option 119).", checkDomainSearch},
+	{"dhcproutes", scopeGlobal, false, "", "Comma-separated classless routes, each \"destination/prefix gateway\" (option 121).", checkClasslessRoutes},
+	{"dhcpv6only", scopeGlobal, false, "", "Seconds IPv6-only capable clients go without IPv4 (option 108); raised to 300 if lower.", checkRange(0, 1<<32-1)},
+	{"dhcpcaptiveportal", scopeGlobal, false, "", "Captive portal API URL (option 114); must be https.", checkHTTPS},
+	{"dhcp4o6", scopeGlobal, false, "off", "Whether DHCPv4 is also served over DHCPv6 (RFC 7341), on UDP port 547: on or off.", checkOneOf("on", "off")},
+	{"dhcpportal", scopeGlobal, false, "", "Send clients not yet authorized to \"<portal IP> [lease minutes]\".", checkDHCPPortal},
+	{"dhcphostname", scopeGlobal, false, defaultDHCPHostname, "Names clients are registered under, such as \"{client-hostname},{vendor}-{mac}\"; the first alternative whose variables are known is used.", checkDHCPHostname},
+	{"dhcphostnameconflict", scopeGlobal, false, hostnameConflictSuffix, "When a client's name is another device's: reject leaves it unregistered, suffix numbers it, steal takes the name over and publishes an event.", checkOneOf(hostnameConflictReject, hostnameConflictSuffix, hostnameConflictSteal)},
+	{"dhcpgenericnames", scopeZone, false, "", "Template such as \"{ip}.dhcp.example\" giving every address of the pool a generic A and PTR record; leases are then not registered under their own names.", checkDHCPGenericNames},
+	{"dhcpvendor", scopeGlobal, true, "", "Vendor options, each \"<class> 43|125/<enterprise> <hex>\".", checkDHCPVendor},
+	{"dnsforwarders", scopeZone, false, "8.8.8.8:53,8.8.4.4:53", "Comma-separated host:port DNS servers to forward to.", checkHostPorts},
+	{"dnsforwarderpool", scopeZone, true, "", "Named forwarder pools, each <name> = comma-separated host:port servers, then optional transport udp|tcp, timeout, maxfails and downtime.", checkDNSForwarderPool},
+	{"dnsforward", scopeZone, true, "", "Forward rules, one per key, such as \"corp-ad name corp.example\": a pool, then policy rule conditions; the first to match picks the pool, and dnsforwarders is the default. The pool iterate resolves the questions from the root servers instead.", checkDNSForwardRule},
+	{"dnsforwardoverride", scopeZone, true, "", "Changes to forwarded answers, each <domain> = minttl <s>, maxttl <s>, noaaaa or nocache, for the domain and its subdomains.", checkDNSForwardOverride},
+	{"dnsresolver", scopeZone, false, "forward", "How names outside our zones are resolved: forward or iterate.", checkOneOf("forward", "iterate")},
+	{"dnsspecialuse", scopeZone, true, "", "How special-use domains, such as localhost, invalid, onion, local and the reverse zones of private addresses, are answered instead of asked upstream: each <domain> = localhost, nxdomain, refuse, local (from our own records only) or forward.", checkDNSSpecialUse},
+	{"dnschain", scopeZone, false, strings.Join(defaultDNSChain, ","), "Comma-separated DNS handlers, in order.", checkDNSChain},
+	{"dnscachemaxttl", scopeZone, false, "0", "Longest that answers are cached, in seconds; 0 disables the cache.", checkRange(0, 1<<31-1)},
+	{"dnscachemissingttl", scopeZone, false, "30", "How long negative answers are cached, in seconds.", checkRange(0, 1<<31-1)},
+	{"dnscachememory", scopeZone, false, "0", "Most memory, in megabytes, that cached answers may take, counted by their wire size; the least recently used are evicted past it. 0 leaves the cache unbounded.", checkRange(0, 1<<20)},
+	{"dnscachemaxrrset", scopeZone, false, strconv.Itoa(defaultDNSCacheMaxRRset), "Largest answer, in bytes, that is cached when dnscachememory is set; larger ones are answered but not kept.", checkRange(0, 65535)},
+	{"dnsminttl", scopeZone, false, "0", "Lowest TTL handed to clients, in seconds.", checkRange(0, 1<<31-1)},
+	{"dnsmaxttl", scopeZone, false, "0", "Highest TTL handed to clients, in seconds; 0 for no limit.", checkRange(0, 1<<31-1)},
+	{"dnsnegminttl", scopeZone, false, "0", "Lowest TTL of forwarded negative answers, those with no records, in seconds.", checkRange(0, 1<<31-1)},
+	{"dnsnegmaxttl", scopeZone, false, "0", "Highest TTL of forwarded negative answers, in seconds; 0 for no limit.", checkRange(0, 1<<31-1)},
+	{"dnsminimal", scopeZone, false, "off", "Whether answers leave out the authority and additional records they do not need: on or off.", checkOneOf("on", "off")},
+	{"dnspadding", scopeZone, false, strconv.Itoa(defaultDNSPadding), "Block size in bytes that responses are padded to when the query asks for padding (RFC 7830), as clients behind encrypted transports do; 0 disables it.", checkRange(0, 65535)},
+	{"dns64", scopeZone, false, "", "DNS64 prefix, or \"on\" for 64:ff9b::/96.", checkDNS64},
+	{"dnsrewrite", scopeZone, true, "", "Rewrite rules, one per key.", checkNotEmpty},
+	{"dnspolicy", scopeZone, true, "", "Policy rules, one per key, such as \"refuse client 10.9.0.0/16 name *.social.example\"; the first to match decides.", checkDNSPolicyRule},
+	{"dnsportal", scopeZone, true, "", "Rules for clients waiting in the captive portal, one per key, such as \"pass name pool.ntp.org\"; without them, only address questions go to the portal, except for time servers.", checkDNSPortalRule},
+	{"dnssinkhole", scopeZone, false, "", "Comma-separated addresses that sinkhole policy rules answer with, which should lead to a server that logs who connects.", checkIPs},
+	{"dnspattern", scopeZone, true, "", "Pattern records, one per key.", checkNotEmpty},
+	{"dnssecondary", scopeZone, true, "", "Secondary zones, each <zone> = comma-separated primaries.", checkServers},
+	{"dnscatalog", scopeZone, true, "", "Catalog zones, each <zone> = comma-separated primaries.", checkServers},
+}
+
+// paths returns the etcd keys a setting may be read from, in order of
+// precedence
+func (s configSetting) paths(hostname, zone string) []string {
+	switch s.scope {
+	case scopeHost:
+		return []string{"config/" + hostname + "/" + s.key}
+	case scopeGlobal:
+		return []string{"config/" + zone + "/" + s.key, "config/" + s.key}
+	}
+	return []string{"config/" + zone + "/" + s.key}
+}
+
+// lookup returns the values of a setting and the key each is from: a single
+// value, or the entries of a directory. A value from a -setting flag or the
+// environment comes from there instead.
+func (s configSetting) lookup(etc etcdKV, hostname, zone string) (values, sources []string, err error) {
+	if overrides, _ := configOverrides(); !s.dir {
+		if o, ok := overrides[s.key]; ok {
+			return []string{o.value}, []string{o.origin}, nil
+		}
+	}
+	for _, p := range s.paths(hostname, zone) {
+		response, err := etc.Get(p, true, false)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, nil, err
+		}
+		if response == nil || response.Node == nil {
+			continue
+		}
+		if !s.dir {
+			if !response.Node.Dir && response.Node.Value != "" {
+				return []string{response.Node.Value}, []string{p}, nil
+			}
+			continue
+		}
+		for _, node := range response.Node.Nodes {
+			if !node.Dir && node.Value != "" {
+				values = append(values, node.Value)
+				sources = append(sources, node.Key)
+			}
+		}
+	}
+	return values, sources, nil
+}
+
+// validateConfig checks every recognized setting of this host and its zone,
+// reporting all the bad ones together
+func validateConfig(etc etcdKV, hostname, zone string) error {
+	var errs ConfigErrors
+	for _, s := range configSchema {
+		if s.check == nil {
+			continue
+		}
+		values, sources, err := s.lookup(etc, hostname, zone)
+		if err != nil {
+			return err
+		}
+		for i, value := range values {
+			if err := s.check(value); err != nil {
+				errs = append(errs, ConfigError{Path: sources[i], Value: value, Problem: err.Error()})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// explainConfig describes every recognized setting and the value it has for
+// this host and zone
+func explainConfig(etc etcdKV, hostname, zone string) ([]ConfigValue, error) {
+	var explained []ConfigValue
+	for _, s := range configSchema {
+		values, sources, err := s.lookup(etc, hostname, zone)
+		if err != nil {
+			return nil, err
+		}
+		v := ConfigValue{Key: s.key, Scope: s.scope, Default: s.defaultText, Description: s.description}
+		if len(values) == 0 {
+			v.Value, v.Source = s.defaultText, "default"
+			explained = append(explained, v)
+			continue
+		}
+		for i, value := range values {
+			v.Value, v.Source, v.Error = value, sources[i], ""
+			if s.check != nil {
+				if err := s.check(value); err != nil {
+					v.Error = err.Error()
+				}
+			}
+			explained = append(explained, v)
+		}
+	}
+	return explained, nil
+}
+
+// ExplainConfig describes the settings that apply to cfg's host and zone
+func (db EtcdDB) ExplainConfig(cfg *Config) ([]ConfigValue, error) {
+	return explainConfig(db.client, cfg.Hostname(), cfg.Zone())
+}
+
+func checkNotEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+func checkIPv4(value string) error {
+	if net.ParseIP(value).To4() == nil {
+		return fmt.Errorf("must be an IPv4 address, such as 10.0.0.1")
+	}
+	return nil
+}
+
+func checkIPs(value string) error {
+	for _, item := range splitDHCPList(value) {
+		if net.ParseIP(item) == nil {
+			return fmt.Errorf("%q must be an address, such as 10.0.0.1 or fd00::1", item)
+		}
+	}
+	return nil
+}
+
+func checkCIDR(value string) error {
+	if _, _, err := net.ParseCIDR(value); err != nil {
+		return fmt.Errorf("must be a subnet, such as 10.0.0.0/24")
+	}
+	return nil
+}
+
+func checkDomain(value string) error {
+	if !validDomainName(value) {
+		return fmt.Errorf("must be a domain name, such as example.com")
+	}
+	return nil
+}
+
+// checkRange returns a check for whole numbers from min to max
+func checkRange(min, max int64) func(string) error {
+	return func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("must be a whole number from %d to %d", min, max)
+		}
+		return nil
+	}
+}
+
+func checkOneOf(choices ...string) func(string) error {
+	return func(value string) error {
+		for _, choice := range choices {
+			if value == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(choices, ", "))
+	}
+}
+
+func checkHostPorts(value string) error {
+	for _, hostPort := range splitDHCPList(value) {
+		if _, port, err := net.SplitHostPort(hostPort); err != nil || port == "" {
+			return fmt.Errorf("%q must be host:port, such as 192.0.2.53:53", hostPort)
+		}
+	}
+	return nil
+}
+
+// checkServers allows the port to be left out, for port 53
+func checkServers(value string) error {
+	for _, server := range splitDHCPList(value) {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			host = server
+		}
+		if net.ParseIP(host) == nil && !validDomainName(host) {
+			return fmt.Errorf("%q must be a host or host:port", server)
+		}
+	}
+	return nil
+}
+
+func checkMACPatterns(value string) error {
+	for _, pattern := range splitDHCPList(value) {
+		if _, err := parseMACPattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDHCPUnknown(value string) error {
+	fields := strings.Fields(value)
+	switch {
+	case len(fields) == 1 && fields[0] == "deny":
+	case len(fields) >= 2 && len(fields) <= 3 && fields[0] == "quarantine":
+		if len(fields) == 3 {
+			return checkIPv4(fields[2])
+		}
+	default:
+		return fmt.Errorf("must be deny, or quarantine <zone> [server IP]")
+	}
+	return nil
+}
+
+func checkDHCPInterface(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("must be \"<zone> [server IP]\"")
+	}
+	if len(fields) == 2 {
+		return checkIPv4(fields[1])
+	}
+	return nil
+}
+
+func checkDomainSearch(value string) error {
+	_, err := encodeDomainSearch(value)
+	return err
+}
+
+func checkClasslessRoutes(value string) error {
+	_, err := encodeClasslessRoutes(value, nil)
+	return err
+}
+
+func checkHTTPS(value string) error {
+	if !strings.HasPrefix(value, "https://") {
+		return fmt.Errorf("must be an https URL")
+	}
+	return nil
+}
+
+func checkDHCPPortal(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 || net.ParseIP(fields[0]).To4() == nil {
+		return fmt.Errorf("must be \"<portal IP> [lease minutes]\"")
+	}
+	if len(fields) == 2 {
+		return checkRange(1, 1440)(fields[1])
+	}
+	return nil
+}
+
+func checkDHCPVendor(value string) error {
+	_, err := parseDHCPVendorOption(value)
+	return err
+}
+
+func checkDNSChain(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if _, ok := dnsMiddlewares[name]; !ok {
+			return fmt.Errorf("unknown DNS handler %q", name)
+		}
+	}
+	return nil
+}
+
+func checkDNS64(value string) error {
+	if value == "on" {
+		return nil
+	}
+	_, err := parseDNS64Prefix(value)
+	return err
+}
@@ -1,7 +1,20 @@
-package main
+package netcore
 
 type DB interface {
 	ConfigProvider
 	DHCPDB
 	DNSDB
+	TenantDB
+	AuditDB
+	FleetDB
+	LeaderDB
+	IdentityDB
+	SubnetDB
+	DNSProfileDB
+	TrustAnchorDB
+	DNSSECKeyDB
+	DNSSECSignatureDB
+	DNSHistoryDB
+	BackupDB
+	SchemaDB
 }
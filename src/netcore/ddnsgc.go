@@ -0,0 +1,54 @@
+package netcore
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	ddnsGCInterval = Flags.Duration("ddnsgcinterval", 15*time.Minute, "How often the leader removes the DNS records that DHCP registered for addresses no lease or reservation holds anymore (0 to disable).")
+	ddnsGCGrace    = Flags.Duration("ddnsgcgrace", time.Hour, "How long after its lease ended an address keeps the DNS records DHCP registered for it.")
+)
+
+// ddnsGCStats counts the runs of the "ddnsgc" duty and the records it
+// removed, published with expvar
+var ddnsGCStats = expvar.NewMap("ddns_gc")
+
+// collectOrphanedDDNS is the "ddnsgc" duty: it removes the A and PTR records
+// that DHCP registered for addresses that no reservation holds, and no lease
+// has held for -ddnsgcgrace. Such records expire with the lease they were
+// registered for, but a client that moved to another address, or a lease or
+// reservation that was deleted, leaves them answering until then. What it
+// removes is published as a dhcp.ddns.orphans event.
+func collectOrphanedDDNS(cfg *Config) error {
+	leases, err := cfg.db.ListLeases()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-*ddnsGCGrace)
+	held := make(map[string]bool)
+	for _, lease := range leases {
+		if ip := net.ParseIP(lease.IP); ip != nil && (lease.Expires == nil || lease.Expires.After(cutoff)) {
+			held[ip.String()] = true
+		}
+	}
+	removed, err := cfg.db.SweepOrphanedDDNS(func(ip net.IP) bool { return held[ip.String()] })
+	ddnsGCStats.Add("runs", 1)
+	ddnsGCStats.Add("removed", int64(len(removed)))
+	if len(removed) > 0 {
+		records := make([]string, len(removed))
+		for i, record := range removed {
+			records[i] = record.String()
+		}
+		events.Publish(Event{
+			Type:     "dhcp.ddns.orphans",
+			Severity: "info",
+			Message:  fmt.Sprintf("removed %d DNS record(s) that DHCP registered for addresses no longer leased", len(removed)),
+			Data:     map[string]string{"records": strings.Join(records, "; ")},
+		})
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package netcore
+
+import (
+	"net"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// SweepOrphanedDDNS removes the A and PTR values that DHCP registered, which
+// are those with an expiration other than service and address
+// registrations, for the addresses that held reports as free, and returns
+// them. RRsets are only written back if they have not changed since they
+// were read, so that a registration made meanwhile stays.
+func (db EtcdDB) SweepOrphanedDDNS(held func(ip net.IP) bool) ([]DNSRecord, error) {
+	response, err := db.client.Get("dns", false, true)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	orphaned := func(rrType, name string, value *DNSValue) bool {
+		if value.Expiration == nil || strings.HasPrefix(value.ID, serviceValuePrefix) || strings.HasPrefix(value.ID, addressValuePrefix) {
+			return false
+		}
+		ip := ipFromArpaName(name)
+		if rrType == "A" {
+			ip = net.ParseIP(value.Value)
+		}
+		return ip != nil && !held(ip)
+	}
+	var removed []DNSRecord
+	walkDNSRRSets(response.Node, func(name, rrType string, node *etcd.Node) {
+		if rrType != "A" && rrType != "PTR" {
+			return
+		}
+		found := false
+		for _, value := range etcdNodeToDNSEntry(node).Values {
+			found = found || orphaned(rrType, name, &value)
+		}
+		if !found {
+			return
+		}
+		var records []DNSRecord
+		_, err := updateDNSRRSet(db.client, name, rrType, func(entry *DNSEntry) error {
+			records = nil
+			kept := entry.Values[:0]
+			for i := range entry.Values {
+				if orphaned(rrType, name, &entry.Values[i]) {
+					records = append(records, DNSRecord{Name: name, Type: rrType, Value: entry.Values[i].Value})
+					continue
+				}
+				kept = append(kept, entry.Values[i])
+			}
+			entry.Values = kept
+			return nil
+		})
+		if err != nil {
+			logger.Printf("[DDNS GC] Unable to sweep %s %s: %s\n", name, rrType, err)
+			return
+		}
+		for _, record := range records {
+			auditChange(db, "dhcp", "dns", name+" "+record.Type, "delete", record.String(), "")
+		}
+		removed = append(removed, records...)
+	})
+
+	// Let secondaries know that the zones have changed
+	for _, record := range removed {
+		if err := db.bumpDNSSerial(record.Name); err != nil {
+			logger.Printf("[DDNS GC] Unable to bump the SOA serial for %s: %s\n", record.Name, err)
+		}
+	}
+	return removed, nil
+}
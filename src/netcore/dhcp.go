@@ -1,9 +1,10 @@
-package main
+package netcore
 
 import (
 	"encoding/binary"
-	"log"
+	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"time"
 
@@ -11,24 +12,46 @@ import (
 )
 
 type DHCPDB interface {
-	InitDHCP()
 	GetIP(net.IP) (IPEntry, error)
 	HasIP(net.IP) bool
 	GetMAC(mac net.HardwareAddr, cascade bool) (entry *MACEntry, found bool, err error)
 	RenewLease(lease *MACEntry) error
 	CreateLease(lease *MACEntry) error
 	WriteLease(lease *MACEntry) error
+	RememberLease(lease *MACEntry, retention time.Duration) error
+	RememberedIPs() (map[string]time.Time, error)
+	AbandonIP(ip net.IP, mac net.HardwareAddr, quarantine time.Duration) error
+	ImportLease(actor string, lease DHCPLease) error
+	ListLeases() ([]DHCPLease, error)
+	DeleteReservation(actor string, mac string) error
+	AuthorizeClient(actor string, mac net.HardwareAddr, authorized bool) error
+	ReserveIP(ip net.IP, mac net.HardwareAddr, hold time.Duration) (bool, error)
 }
 
+// dhcpOfferHold is how long an offered address is kept for the client's
+// Request
+const dhcpOfferHold = time.Minute
+
 // DHCPService is the DHCP server instance
 type DHCPService struct {
-	ip             net.IP
-	domain         string
-	subnet         *net.IPNet
-	guestPool      *net.IPNet
-	leaseDuration  time.Duration
-	defaultOptions dhcp4.Options // FIXME: make different options per pool?
-	db             DB
+	ip               net.IP
+	domain           string
+	subnet           *net.IPNet
+	guestPool        *net.IPNet
+	leaseDuration    time.Duration
+	retention        time.Duration
+	defaultOptions   dhcp4.Options // FIXME: make different options per pool?
+	vendorOptions    []*dhcpVendorOption
+	filter           *macFilter
+	v6OnlyWait       []byte // option 108 for clients that can do without IPv4
+	portalIP         net.IP // where unauthorized clients are sent, if anywhere
+	portalLease      time.Duration
+	quarantine       *DHCPService // serves clients that are not on the allow list
+	zone             string
+	hostnames        dhcpHostnameTemplate
+	hostnameConflict string
+	genericNames     bool // the pool's addresses are named by dhcpgenericnames
+	db               DB
 }
 
 type IPEntry struct {
@@ -38,40 +61,207 @@ type IPEntry struct {
 type MACEntry struct {
 	MAC      net.HardwareAddr
 	IP       net.IP
+	LastIP   net.IP // remembered for the retention period after the lease expires
 	Duration time.Duration
 	Attr     map[string]string
+
+	// Where the client is attached, from relay agent information (option 82)
+	CircuitID string
+	RemoteID  string
+
+	// What the client says it is (option 60), such as "MSFT 5.0"
+	VendorClass string
 }
 
 const minimumLeaseDuration = 60 * time.Second // FIXME: put this in a config
 
-func dhcpSetup(cfg *Config) chan error {
-	cfg.db.InitDHCP()
-	exit := make(chan error, 1)
-	go func() {
-		d := &DHCPService{
-			ip:            cfg.DHCPIP(),
-			leaseDuration: cfg.DHCPLeaseDuration(),
-			db:            cfg.db,
-			subnet:        cfg.Subnet(),
-			guestPool:     cfg.DHCPSubnet(),
-			domain:        cfg.Domain(),
-			defaultOptions: dhcp4.Options{
-				dhcp4.OptionSubnetMask:       net.IP(cfg.Subnet().Mask),
-				dhcp4.OptionRouter:           cfg.Gateway(),
-				dhcp4.OptionDomainNameServer: cfg.DHCPIP(),
-			},
-		}
-		dhcpTFTP := cfg.DHCPTFTP()
-		if dhcpTFTP != "" {
-			d.defaultOptions[dhcp4.OptionTFTPServerName] = []byte(dhcpTFTP)
-		}
-		exit <- dhcp4.ListenAndServeIf(cfg.DHCPNIC(), d)
-	}()
-	return exit
+// defaultDHCPRetention is how long an address is kept for its last client
+// once their lease expires, unless the zone sets dhcpretention
+const defaultDHCPRetention = 24 * time.Hour
+
+// dhcpServers serves DHCP on every interface that the configuration
+// assigns a DHCP instance
+type dhcpServers struct {
+	serviceStatus
+	cfg   *Config
+	nics  []string
+	over6 *dhcp4o6Server // serves the instances that do DHCPv4 over DHCPv6, if any
+}
+
+// NewDHCPServers serves DHCP for each of cfg's DHCP instances, on its
+// interface
+func NewDHCPServers(cfg *Config) Service {
+	return &dhcpServers{cfg: cfg}
+}
+
+func (s *dhcpServers) Name() string { return "dhcp" }
+
+func (s *dhcpServers) Start() (<-chan error, error) {
+	instances := s.cfg.DHCPInstances()
+	exit := make(chan error, len(instances)+1)
+	s.nics = nil
+	over6 := make(map[string]dhcp4.Handler)
+	for _, instance := range instances {
+		d, err := newDHCPService(s.cfg.db, instance)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("%s: %s", instance.NIC, err)
+		}
+		conn, err := listenDHCPIf(instance.NIC)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("%s: %s", instance.NIC, err)
+		}
+		s.nics = append(s.nics, instance.NIC)
+		logger.Printf("DHCP serving %s on %s as %s\n", d.subnet.String(), instance.NIC, d.ip.String())
+		go func(nic string) {
+			exit <- fmt.Errorf("%s: %s", nic, dhcp4.Serve(conn, d))
+		}(instance.NIC)
+		if instance.Over6 {
+			over6[instance.NIC] = d
+		}
+	}
+	if len(over6) > 0 {
+		var err error
+		if s.over6, err = listenDHCP4o6(over6); err != nil {
+			s.close()
+			return nil, fmt.Errorf("DHCPv4 over DHCPv6: %s", err)
+		}
+		logger.Printf("DHCP serving DHCPv4 over DHCPv6 on %d interfaces\n", len(over6))
+		go func(over6 *dhcp4o6Server) {
+			exit <- fmt.Errorf("DHCPv4 over DHCPv6: %s", over6.serve())
+		}(s.over6)
+	}
+	return s.serving(exit, s.close), nil
+}
+
+func (s *dhcpServers) Stop(deadline time.Time) error {
+	s.stop()
+	s.close()
+	waitInFlight(deadline)
+	return nil
+}
+
+// close lets go of the sockets, which stops their servers
+func (s *dhcpServers) close() {
+	for _, nic := range s.nics {
+		releaseDHCPIf(nic)
+	}
+	if s.over6 != nil {
+		listeners.release("dhcp4o6")
+		s.over6 = nil
+	}
+}
+
+// NewDHCPHandler serves DHCP for one of cfg's DHCP instances
+func NewDHCPHandler(cfg *Config, instance *DHCPInstance) (dhcp4.Handler, error) {
+	return newDHCPService(cfg.db, instance)
+}
+
+// newDHCPService prepares to serve DHCP for instance
+func newDHCPService(db DB, instance *DHCPInstance) (*DHCPService, error) {
+	ip := instance.IP
+	if ip == nil {
+		var err error
+		if ip, err = interfaceIPIn(instance.NIC, instance.Subnet); err != nil {
+			return nil, err
+		}
+	}
+	d := &DHCPService{
+		ip:            ip,
+		leaseDuration: instance.LeaseDuration,
+		retention:     instance.Retention,
+		db:            db,
+		subnet:        instance.Subnet,
+		guestPool:     instance.Pool,
+		domain:        instance.Domain,
+		defaultOptions: dhcp4.Options{
+			dhcp4.OptionSubnetMask:       net.IP(instance.Subnet.Mask),
+			dhcp4.OptionRouter:           instance.Gateway,
+			dhcp4.OptionDomainNameServer: ip,
+		},
+	}
+	if instance.TFTP != "" {
+		d.defaultOptions[dhcp4.OptionTFTPServerName] = []byte(instance.TFTP)
+	}
+	if len(instance.DomainSearch) > 0 {
+		d.defaultOptions[dhcpOptionDomainSearch] = instance.DomainSearch
+	}
+	if len(instance.Routes) > 0 {
+		d.defaultOptions[dhcp4.OptionClasslessRouteFormat] = instance.Routes
+	}
+	if instance.CaptivePortal != "" {
+		d.defaultOptions[dhcpOptionCaptivePortal] = []byte(instance.CaptivePortal)
+	}
+	d.portalIP, d.portalLease = instance.PortalIP, instance.PortalLease
+	d.zone = instance.Zone
+	hostname := instance.Hostname
+	if hostname == "" {
+		hostname = defaultDHCPHostname
+	}
+	var err error
+	if d.hostnames, err = parseDHCPHostnameTemplate(hostname); err != nil {
+		return nil, fmt.Errorf("dhcphostname: %s", err)
+	}
+	if d.hostnameConflict = instance.HostnameConflict; d.hostnameConflict == "" {
+		d.hostnameConflict = hostnameConflictSuffix
+	}
+	d.genericNames = instance.GenericNames != ""
+	if instance.V6OnlyWait > 0 {
+		d.v6OnlyWait = make([]byte, 4)
+		binary.BigEndian.PutUint32(d.v6OnlyWait, instance.V6OnlyWait)
+	}
+	for _, decl := range instance.VendorOptions {
+		v, err := parseDHCPVendorOption(decl)
+		if err != nil {
+			return nil, err
+		}
+		d.vendorOptions = append(d.vendorOptions, v)
+	}
+	if d.filter, err = newMACFilter(instance.Filter); err != nil {
+		return nil, err
+	}
+	if q := instance.Filter.Quarantine; q != nil {
+		if q.IP == nil {
+			// Without an address in the quarantine subnet, we are still the
+			// server that clients must talk to
+			if q.IP, err = interfaceIPIn(q.NIC, q.Subnet); err != nil {
+				q.IP = ip
+			}
+		}
+		if d.quarantine, err = newDHCPService(db, q); err != nil {
+			return nil, fmt.Errorf("quarantine zone %s: %s", q.Zone, err)
+		}
+	}
+	return d, nil
+}
+
+// interfaceIPIn returns the IPv4 address that nic has in subnet
+func interfaceIPIn(nic string, subnet *net.IPNet) (net.IP, error) {
+	iface, err := net.InterfaceByName(nic)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && subnet.Contains(ipNet.IP) {
+			return ipNet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("no address in %s; set the server IP for this interface", subnet.String())
 }
 
 // ServeDHCP is called by dhcp4.ListenAndServe when the service is started
 func (d *DHCPService) ServeDHCP(packet dhcp4.Packet, msgType dhcp4.MessageType, reqOptions dhcp4.Options) (response dhcp4.Packet) {
+	if denied, known := d.filter.check(packet.CHAddr()); !denied && !known && d.quarantine != nil {
+		return d.quarantine.ServeDHCP(packet, msgType, reqOptions)
+	}
+	defer func() { dhcpStats.Replied(packet.CHAddr(), response) }()
+	defer drain.Begin()()
+
 	switch msgType {
 	case dhcp4.Discover:
 		// RFC 2131 4.3.1
@@ -80,10 +270,19 @@ func (d *DHCPService) ServeDHCP(packet dhcp4.Packet, msgType dhcp4.MessageType,
 
 		// Check MAC blacklist
 		if !d.isMACPermitted(mac) {
-			log.Printf("DHCP Discover from %s\n is not permitted", mac.String())
+			logger.Printf("DHCP Discover from %s\n is not permitted", mac.String())
 			return nil
 		}
-		log.Printf("DHCP Discover from %s\n", mac.String())
+		logger.Printf("DHCP Discover from %s\n", mac.String())
+
+		// IPv6-only clients get no address, only the time to wait before
+		// asking again (RFC 8925)
+		if d.v6OnlyWait != nil && wantsIPv6Only(reqOptions) {
+			logger.Printf("DHCP Discover from %s (IPv6-only preferred, so we offer no address)\n", mac.String())
+			dhcpStats.Count("v6only")
+			options := dhcp4.Options{dhcpOptionV6OnlyPreferred: d.v6OnlyWait}
+			return dhcp4.ReplyPacket(packet, dhcp4.Offer, d.ip.To4(), net.IPv4zero.To4(), 0, options.SelectOrderOrAll(reqOptions[dhcp4.OptionParameterRequestList]))
+		}
 
 		// Look up the MAC entry with cascaded attributes
 		lease, found, err := d.db.GetMAC(mac, true)
@@ -91,34 +290,48 @@ func (d *DHCPService) ServeDHCP(packet dhcp4.Packet, msgType dhcp4.MessageType,
 			return nil
 		}
 
-		// Existing Lease
-		if found {
-			options := d.getOptionsFromMAC(lease)
-			log.Printf("DHCP Discover from %s (we offer %s from current lease)\n", lease.MAC.String(), lease.IP.String())
+		// Existing Lease, unless it is for another interface's subnet
+		if found && d.subnet.Contains(lease.IP) {
+			options := d.getOptionsFromMAC(lease, reqOptions)
+			logger.Printf("DHCP Discover from %s (we offer %s from current lease)\n", lease.MAC.String(), lease.IP.String())
 			// for x, y := range reqOptions {
-			// 	log.Printf("\tR[%v] %v %s\n", x, y, y)
+			// 	logger.Printf("\tR[%v] %v %s\n", x, y, y)
 			// }
 			// for x, y := range options {
-			// 	log.Printf("\tO[%v] %v %s\n", x, y, y)
+			// 	logger.Printf("\tO[%v] %v %s\n", x, y, y)
 			// }
-			return dhcp4.ReplyPacket(packet, dhcp4.Offer, d.ip.To4(), lease.IP.To4(), d.getLeaseDurationForRequest(reqOptions, lease.Duration), options.SelectOrderOrAll(reqOptions[dhcp4.OptionParameterRequestList]))
+			return dhcp4.ReplyPacket(packet, dhcp4.Offer, d.ip.To4(), lease.IP.To4(), d.leaseDurationFor(lease, reqOptions, lease.Duration), options.SelectOrderOrAll(reqOptions[dhcp4.OptionParameterRequestList]))
 		}
 
-		// New Lease
-		ip := d.getIPFromPool()
+		// New Lease, unless we are draining and leave it to another server
+		if drain.Active() {
+			logger.Printf("DHCP Discover from %s (no offer while draining)\n", mac.String())
+			dhcpStats.Count("drained")
+			return nil
+		}
+
+		// Checking that nobody is using the address without one
+		ip := d.allocateIP(lease, mac)
+		for tries := 0; ip != nil && *dhcpPingTimeout > 0 && tries < 3 && pingAddress(ip, *dhcpPingTimeout); tries++ {
+			logger.Printf("DHCP Discover from %s (%s answers pings without a lease, so we quarantine it)\n", mac.String(), ip.String())
+			dhcpStats.Count("conflicts")
+			d.abandon(ip, mac) // held for mac while we offer it
+			ip = d.allocateIP(lease, mac)
+		}
 		if ip != nil {
-			options := d.getOptionsFromMAC(lease)
-			log.Printf("DHCP Discover from %s (we offer %s from pool)\n", mac.String(), ip.String())
+			options := d.getOptionsFromMAC(lease, reqOptions)
+			logger.Printf("DHCP Discover from %s (we offer %s from pool)\n", mac.String(), ip.String())
 			// for x, y := range reqOptions {
-			// 	log.Printf("\tR[%v] %v %s\n", x, y, y)
+			// 	logger.Printf("\tR[%v] %v %s\n", x, y, y)
 			// }
 			// for x, y := range options {
-			// 	log.Printf("\tO[%v] %v %s\n", x, y, y)
+			// 	logger.Printf("\tO[%v] %v %s\n", x, y, y)
 			// }
-			return dhcp4.ReplyPacket(packet, dhcp4.Offer, d.ip.To4(), ip.To4(), d.getLeaseDurationForRequest(reqOptions, d.leaseDuration), options.SelectOrderOrAll(reqOptions[dhcp4.OptionParameterRequestList]))
+			return dhcp4.ReplyPacket(packet, dhcp4.Offer, d.ip.To4(), ip.To4(), d.leaseDurationFor(lease, reqOptions, d.leaseDuration), options.SelectOrderOrAll(reqOptions[dhcp4.OptionParameterRequestList]))
 		}
 
-		log.Printf("DHCP Discover from %s (no offer due to no addresses available in pool)\n", mac.String())
+		logger.Printf("DHCP Discover from %s (no offer due to no addresses available in pool)\n", mac.String())
+		dhcpStats.Count("pool_exhausted")
 		// FIXME: Send to StatHat and/or increment a counter
 		// TODO: Send an email?
 
@@ -131,59 +344,66 @@ func (d *DHCPService) ServeDHCP(packet dhcp4.Packet, msgType dhcp4.MessageType,
 
 		// Check MAC blacklist
 		if !d.isMACPermitted(mac) {
-			log.Printf("DHCP Request from %s\n is not permitted", mac.String())
+			logger.Printf("DHCP Request from %s\n is not permitted", mac.String())
 			return nil
 		}
 
+		if server := net.IP(reqOptions[dhcp4.OptionServerIdentifier]); len(server) == net.IPv4len && !server.Equal(d.ip) {
+			rogueDHCP.Check(d.db, server, mac)
+		}
+
 		// Check IP presence
 		state, requestedIP := d.getRequestState(packet, reqOptions)
-		log.Printf("DHCP Request (%s) from %s...\n", state, mac.String())
+		logger.Printf("DHCP Request (%s) from %s...\n", state, mac.String())
 		if len(requestedIP) == 0 || requestedIP.IsUnspecified() { // no IP provided at all... why? FIXME
-			log.Printf("DHCP Request (%s) from %s (empty IP, so we're just ignoring this request)\n", state, mac.String())
+			logger.Printf("DHCP Request (%s) from %s (empty IP, so we're just ignoring this request)\n", state, mac.String())
 			return nil
 		}
 
 		// Check IPv4
 		if len(requestedIP) != net.IPv4len {
-			log.Printf("DHCP Request (%s) from %s wanting %s (IPv6 address requested, so we're just ignoring this request)\n", state, mac.String(), requestedIP.String())
+			logger.Printf("DHCP Request (%s) from %s wanting %s (IPv6 address requested, so we're just ignoring this request)\n", state, mac.String(), requestedIP.String())
 			return nil
 		}
 
 		// Check IP subnet
 		if !d.subnet.Contains(requestedIP) {
-			log.Printf("DHCP Request (%s) from %s wanting %s (we reject due to wrong subnet)\n", state, mac.String(), requestedIP.String())
+			logger.Printf("DHCP Request (%s) from %s wanting %s (we reject due to wrong subnet)\n", state, mac.String(), requestedIP.String())
 			return dhcp4.ReplyPacket(packet, dhcp4.NAK, d.ip.To4(), nil, 0, nil)
 		}
 
 		// Check Target Server
 		targetServerIP := packet.SIAddr()
 		if len(targetServerIP) > 0 && !targetServerIP.IsUnspecified() {
-			log.Printf("DHCP Request (%s) from %s wanting %s is in response to a DHCP offer from %s\n", state, mac.String(), requestedIP.String(), targetServerIP.String())
+			logger.Printf("DHCP Request (%s) from %s wanting %s is in response to a DHCP offer from %s\n", state, mac.String(), requestedIP.String(), targetServerIP.String())
 			if d.ip.Equal(targetServerIP) {
 				return nil
 			}
 		}
 
 		// Process Request
-		log.Printf("DHCP Request (%s) from %s wanting %s...\n", state, mac.String(), requestedIP.String())
+		logger.Printf("DHCP Request (%s) from %s wanting %s...\n", state, mac.String(), requestedIP.String())
 		lease, found, err := d.db.GetMAC(mac, true)
 		if err != nil {
 			return nil
 		}
 
-		if found {
+		circuitID, remoteID := parseRelayAgentInfo(reqOptions[dhcp4.OptionRelayAgentInformation])
+		if found && d.subnet.Contains(lease.IP) {
 			// Existing Lease
-			lease.Duration = d.getLeaseDurationForRequest(reqOptions, d.leaseDuration)
+			lease.CircuitID, lease.RemoteID = circuitID, remoteID
+			lease.VendorClass = string(reqOptions[dhcp4.OptionVendorClassIdentifier])
+			lease.Duration = d.leaseDurationFor(lease, reqOptions, d.leaseDuration)
 			if lease.IP.Equal(requestedIP) {
 				err = d.db.RenewLease(lease)
 			} else {
-				log.Printf("DHCP Request (%s) from %s wanting %s (we reject due to lease mismatch, should be %s)\n", state, lease.MAC.String(), requestedIP.String(), lease.IP.String())
+				logger.Printf("DHCP Request (%s) from %s wanting %s (we reject due to lease mismatch, should be %s)\n", state, lease.MAC.String(), requestedIP.String(), lease.IP.String())
 				return dhcp4.ReplyPacket(packet, dhcp4.NAK, d.ip.To4(), nil, 0, nil)
 			}
 		} else {
 			// Check IP subnet is within the guestPool (we don't want users requesting non-pool addresses unless we assigned it to their MAC, administratively)
 			if !d.guestPool.Contains(requestedIP) {
-				log.Printf("DHCP Request (%s) from %s wanting %s (we reject due to not being within the guestPool)\n", state, mac.String(), requestedIP.String())
+				logger.Printf("DHCP Request (%s) from %s wanting %s (we reject due to not being within the guestPool)\n", state, mac.String(), requestedIP.String())
 				return dhcp4.ReplyPacket(packet, dhcp4.NAK, d.ip.To4(), nil, 0, nil)
 			}
 
@@ -191,32 +411,54 @@ func (d *DHCPService) ServeDHCP(packet dhcp4.Packet, msgType dhcp4.MessageType,
 			lease = &MACEntry{
 				MAC:      mac,
 				IP:       requestedIP,
-				Duration: d.getLeaseDurationForRequest(reqOptions, d.leaseDuration),
+				Duration: d.leaseDurationFor(lease, reqOptions, d.leaseDuration),
+				Attr:     lease.Attr,
+
+				CircuitID: circuitID,
+				RemoteID:  remoteID,
+
+				VendorClass: string(reqOptions[dhcp4.OptionVendorClassIdentifier]),
 			}
 			err = d.db.CreateLease(lease)
 		}
 
 		if err == nil {
+			if err := d.db.RememberLease(lease, d.retention); err != nil {
+				logger.Printf("DHCP Request (%s) from %s: unable to remember the lease: %s\n", state, mac.String(), err)
+			}
+			if d.captive(lease) {
+				captivePortal.Restrict(lease.IP, lease.MAC, d.portalIP, time.Now().Add(lease.Duration))
+			} else {
+				captivePortal.Release(lease.IP)
+			}
 			d.maintainDNSRecords(lease, packet, reqOptions) // TODO: Move this?
-			options := d.getOptionsFromMAC(lease)
-			log.Printf("DHCP Request (%s) from %s wanting %s (we agree)\n", state, mac.String(), requestedIP.String())
+			options := d.getOptionsFromMAC(lease, reqOptions)
+			logger.Printf("DHCP Request (%s) from %s wanting %s (we agree)\n", state, mac.String(), requestedIP.String())
 			return dhcp4.ReplyPacket(packet, dhcp4.ACK, d.ip.To4(), requestedIP.To4(), lease.Duration, options.SelectOrderOrAll(reqOptions[dhcp4.OptionParameterRequestList]))
 		}
 
-		log.Printf("DHCP Request (%s) from %s wanting %s (we reject due to address collision)\n", state, mac.String(), requestedIP.String())
+		logger.Printf("DHCP Request (%s) from %s wanting %s (we reject: %s)\n", state, mac.String(), requestedIP.String(), err)
 		return dhcp4.ReplyPacket(packet, dhcp4.NAK, d.ip.To4(), nil, 0, nil)
 
 	case dhcp4.Decline:
 		// RFC 2131 4.3.3
-		// FIXME: release from DB?  tick a flag?  increment a counter?  send to StatHat?
+		// The client found the address in use, so we keep it out of the pool
+		// for a while; the client will come back for another
 		mac := packet.CHAddr()
-		log.Printf("DHCP Decline from %s\n", mac.String())
+		ip := net.IP(reqOptions[dhcp4.OptionRequestedIPAddress]).To4()
+		logger.Printf("DHCP Decline from %s of %s\n", mac.String(), ip.String())
+		dhcpStats.Count("declines")
+		if ip != nil && d.subnet.Contains(ip) {
+			dhcpStats.Count("conflicts")
+			d.abandon(ip, mac)
+		}
 
 	case dhcp4.Release:
 		// RFC 2131 4.3.4
-		// FIXME: release from DB?  tick a flag?  increment a counter?  send to StatHat?
+		// FIXME: release from DB?  tick a flag?  send to StatHat?
 		mac := packet.CHAddr()
-		log.Printf("DHCP Release from %s\n", mac.String())
+		logger.Printf("DHCP Release from %s\n", mac.String())
+		dhcpStats.Count("releases")
 
 	case dhcp4.Inform:
 		// RFC 2131 4.3.5
@@ -227,11 +469,11 @@ func (d *DHCPService) ServeDHCP(packet dhcp4.Packet, msgType dhcp4.MessageType,
 		mac := packet.CHAddr()
 		ip := packet.CIAddr()
 		if len(ip) > 0 && !ip.IsUnspecified() {
-			log.Printf("DHCP Inform from %s for %s \n", mac.String(), ip.String())
+			logger.Printf("DHCP Inform from %s for %s \n", mac.String(), ip.String())
 			if len(ip) == net.IPv4len && d.guestPool.Contains(ip) {
 				entry, found, _ := d.db.GetMAC(mac, true)
 				if found {
-					options := d.getOptionsFromMAC(entry)
+					options := d.getOptionsFromMAC(entry, reqOptions)
 					return informReplyPacket(packet, dhcp4.ACK, d.ip.To4(), options.SelectOrderOrAll(reqOptions[dhcp4.OptionParameterRequestList]))
 				}
 			}
@@ -241,9 +483,23 @@ func (d *DHCPService) ServeDHCP(packet dhcp4.Packet, msgType dhcp4.MessageType,
 	return nil
 }
 
+// abandon quarantines an address that is in use without our knowledge,
+// taking it from mac if mac was given it; addresses that mac does not hold
+// are left alone
+func (d *DHCPService) abandon(ip net.IP, mac net.HardwareAddr) {
+	if err := d.db.AbandonIP(ip, mac, *dhcpQuarantine); err != nil {
+		logger.Printf("DHCP unable to quarantine %s: %s\n", ip.String(), err)
+		return
+	}
+	dhcpStats.Count("abandoned")
+}
+
+// isMACPermitted reports whether we serve mac ourselves. Denied clients are
+// ignored, and unknown clients are left to the quarantine zone if there is
+// one.
 func (d *DHCPService) isMACPermitted(mac net.HardwareAddr) bool {
-	// TODO: determine whether or not this MAC should be permitted to get an IP at all (blacklist? whitelist?)
-	return true
+	denied, known := d.filter.check(mac)
+	return !denied && known
 }
 
 func (d *DHCPService) getRequestState(packet dhcp4.Packet, reqOptions dhcp4.Options) (string, net.IP) {
@@ -256,6 +512,26 @@ func (d *DHCPService) getRequestState(packet dhcp4.Packet, reqOptions dhcp4.Opti
 	return state, requestedIP
 }
 
+// leaseDurationFor is getLeaseDurationForRequest, except that clients
+// waiting in the captive portal get short leases so that they are promoted
+// soon after being authorized, and that leases are short while draining so
+// that clients move to another server
+func (d *DHCPService) leaseDurationFor(entry *MACEntry, reqOptions dhcp4.Options, defaultDuration time.Duration) time.Duration {
+	duration := d.getLeaseDurationForRequest(reqOptions, defaultDuration)
+	if d.captive(entry) && duration > d.portalLease {
+		duration = d.portalLease
+	}
+	if drain.Active() && duration > *drainLease {
+		duration = *drainLease
+	}
+	return duration
+}
+
+// captive reports whether entry's client must go through the captive portal
+func (d *DHCPService) captive(entry *MACEntry) bool {
+	return d.portalIP != nil && (entry == nil || entry.Attr["authorized"] == "")
+}
+
 func (d *DHCPService) getLeaseDurationForRequest(reqOptions dhcp4.Options, defaultDuration time.Duration) time.Duration {
 	// If a requested lease duration is accepted by policy we hand it back to them
 	// If a requested lease duration is not accepted by policy we constrain it to the policy's minimum and maximum
@@ -281,46 +557,115 @@ func (d *DHCPService) getLeaseDurationForRequest(reqOptions dhcp4.Options, defau
 	return leaseDuration
 }
 
-func (d *DHCPService) getIPFromPool() net.IP {
-	// locate an unused IP address (can this be more efficient?  yes!  FIXME)
+// allocateIP picks an address for a client without a lease and holds it for
+// the client's Request, so that concurrent Discovers (here or on another
+// netcore) never get the same address. Clients get their last address back
+// if it is still free. Otherwise we prefer addresses that nobody has had
+// recently, and then the least recently used, so that each address stays
+// with its client for as long as possible. Holding an address is what tells
+// whether it is free: one held for another client is skipped for the next
+// candidate, and one already held for this client, whose Discover was
+// retransmitted, is held again and offered again.
+func (d *DHCPService) allocateIP(entry *MACEntry, mac net.HardwareAddr) net.IP {
+	if entry != nil && entry.LastIP != nil && d.guestPool.Contains(entry.LastIP) && d.reserve(entry.LastIP, mac) {
+		return entry.LastIP
+	}
+	remembered, err := d.db.RememberedIPs()
+	if err != nil {
+		logger.Printf("DHCP unable to read remembered addresses: %s\n", err)
+	}
+	var used dhcpIPsByExpiry
 	// TODO: Create a channel and spawn a goproc with something like this function to feed it; then have the server pull addresses from that channel
 	for ip := dhcp4.IPAdd(d.guestPool.IP, 1); d.guestPool.Contains(ip); ip = dhcp4.IPAdd(ip, 1) {
-		//log.Println(ip.String())
-		if !d.db.HasIP(ip) { // this means that the IP is not already occupied
-			return ip
+		expiry, ok := remembered[ip.String()]
+		if !ok {
+			if d.reserve(ip, mac) {
+				return ip
+			}
+			continue
+		}
+		used = append(used, dhcpRememberedIP{ip, expiry})
+	}
+	sort.Sort(used)
+	for _, candidate := range used {
+		if d.reserve(candidate.ip, mac) {
+			return candidate.ip
 		}
 	}
 	return nil
 }
 
+// reserve holds ip for mac while our offer stands, reporting whether it is
+// ours to offer
+func (d *DHCPService) reserve(ip net.IP, mac net.HardwareAddr) bool {
+	ok, err := d.db.ReserveIP(ip, mac, dhcpOfferHold)
+	if err != nil {
+		logger.Printf("DHCP unable to hold %s for %s: %s\n", ip.String(), mac.String(), err)
+		return false
+	}
+	if !ok {
+		dhcpStats.Count("allocation_held") // by another client
+	}
+	return ok
+}
+
+type dhcpRememberedIP struct {
+	ip     net.IP
+	expiry time.Time
+}
+
+// dhcpIPsByExpiry sorts free addresses from the least recently used
+type dhcpIPsByExpiry []dhcpRememberedIP
+
+func (l dhcpIPsByExpiry) Len() int           { return len(l) }
+func (l dhcpIPsByExpiry) Less(i, j int) bool { return l[i].expiry.Before(l[j].expiry) }
+func (l dhcpIPsByExpiry) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
 func (d *DHCPService) maintainDNSRecords(entry *MACEntry, packet dhcp4.Packet, reqOptions dhcp4.Options) {
-	options := d.getOptionsFromMAC(entry)
+	options := d.getOptionsFromMAC(entry, reqOptions)
 	if domain, ok := options[dhcp4.OptionDomainName]; ok {
 		// FIXME:  danger!  we're mixing systems here...  if we keep this up, we will have spaghetti!
-		name := ""
-		if val, ok := options[dhcp4.OptionHostName]; ok {
-			name = string(val)
-		} else if val, ok := reqOptions[dhcp4.OptionHostName]; ok {
-			name = string(val)
+		name, exclusive, err := d.registeredName(entry, string(domain), options, reqOptions)
+		if err != nil {
+			logger.Printf("DHCP unable to name %s: %s\n", entry.MAC.String(), err)
+			return
 		}
 		if name != "" {
 			host := strings.ToLower(strings.Join([]string{name, string(domain)}, "."))
 			// TODO: Pick a TTL for the record and use it
-			d.db.RegisterA(host, entry.IP, false, 0, uint64(d.leaseDuration.Seconds()+0.5))
+			d.db.RegisterA(host, entry.IP, exclusive, 0, uint64(d.leaseDuration.Seconds()+0.5))
 		} else {
-			log.Println(">> No host name")
+			logger.Println(">> No host name")
 		}
 	} else {
-		log.Println(">> No domain name")
+		logger.Println(">> No domain name")
 	}
 }
 
-func (d *DHCPService) getOptionsFromMAC(entry *MACEntry) dhcp4.Options {
+func (d *DHCPService) getOptionsFromMAC(entry *MACEntry, reqOptions dhcp4.Options) dhcp4.Options {
 	options := dhcp4.Options{}
 
 	for i := range d.defaultOptions {
 		options[i] = d.defaultOptions[i]
-		log.Printf("OPTION:[%d][%+v]\n", i, d.defaultOptions[i])
+		debugf("OPTION:[%d][%+v]\n", i, d.defaultOptions[i])
+	}
+
+	{ // IPv6-Only Preferred, for clients that renew a lease they got before
+		if d.v6OnlyWait != nil && wantsIPv6Only(reqOptions) {
+			options[dhcpOptionV6OnlyPreferred] = d.v6OnlyWait
+		}
+	}
+
+	{ // Captive Portal, only for clients that still have to go through it
+		if d.portalIP != nil && !d.captive(entry) {
+			delete(options, dhcpOptionCaptivePortal)
+		}
+	}
+
+	{ // Vendor-Specific Information, by the client's vendor class
+		for i, value := range vendorOptionsFor(d.vendorOptions, reqOptions) {
+			options[i] = value
+		}
 	}
 
 	{ // Subnet Mask
@@ -408,9 +753,51 @@ func (d *DHCPService) getOptionsFromMAC(entry *MACEntry) dhcp4.Options {
 		}
 	}
 
+	{ // Domain Search (RFC 3397)
+		if value, ok := entry.Attr["search"]; ok {
+			if data, err := encodeDomainSearch(value); err != nil {
+				logger.Printf("DHCP domain search for %s: %s\n", entry.MAC.String(), err)
+			} else if len(data) == 0 {
+				delete(options, dhcpOptionDomainSearch)
+			} else {
+				options[dhcpOptionDomainSearch] = data
+			}
+		}
+	}
+
+	{ // Classless Static Routes (RFC 3442)
+		if value, ok := entry.Attr["routes"]; ok {
+			if data, err := encodeClasslessRoutes(value, net.IP(options[dhcp4.OptionRouter])); err != nil {
+				logger.Printf("DHCP classless routes for %s: %s\n", entry.MAC.String(), err)
+			} else if len(data) == 0 {
+				delete(options, dhcp4.OptionClasslessRouteFormat)
+			} else {
+				options[dhcp4.OptionClasslessRouteFormat] = data
+			}
+		}
+	}
+
 	return options
 }
 
+// validateLease returns an error if the lease is missing the MAC address,
+// IPv4 address or duration required to store it
+func validateLease(lease *MACEntry) error {
+	if lease == nil {
+		return invalid("lease", "invalid lease: nil")
+	}
+	if len(lease.MAC) == 0 {
+		return invalid("mac", "invalid lease: missing MAC address")
+	}
+	if lease.IP.To4() == nil {
+		return invalid("ip", "invalid lease for %s: %q is not an IPv4 address", lease.MAC.String(), lease.IP.String())
+	}
+	if lease.Duration <= 0 {
+		return invalid("duration", "invalid lease for %s: non-positive duration %s", lease.MAC.String(), lease.Duration)
+	}
+	return nil
+}
+
 // ReplyPacket creates a reply packet that a Server would send to a client.
 // It uses the req Packet param to copy across common/necessary fields to
 // associate the reply with the request.
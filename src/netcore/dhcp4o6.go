@@ -0,0 +1,151 @@
+package netcore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/krolaw/dhcp4"
+	"golang.org/x/net/ipv6"
+)
+
+// DHCPv4 over DHCPv6 (RFC 7341): clients on links where IPv4 is turned off
+// wrap their DHCPv4 messages in DHCPv6 ones sent to port 547, and the
+// DHCPv4 service of the interface they come in on answers them the same way.
+// Relayed queries are answered through their relays.
+
+const (
+	dhcp6RelayForw  = 12
+	dhcp6RelayRepl  = 13
+	dhcp6V4Query    = 20
+	dhcp6V4Response = 21
+
+	dhcp6OptionRelayMsg    = 9
+	dhcp6OptionInterfaceID = 18
+	dhcp6OptionV4Msg       = 87
+
+	dhcp6RelayHeaderLen = 34 // msg-type, hop-count, link-address and peer-address
+)
+
+// allDHCP6Servers is All_DHCP_Relay_Agents_and_Servers, where clients that
+// were given no server address send their queries
+var allDHCP6Servers = net.ParseIP("ff02::1:2")
+
+// dhcp4o6Server is the one udp6 :547 socket of the interfaces that serve
+// DHCPv4 over DHCPv6, handed over under the name "dhcp4o6"
+type dhcp4o6Server struct {
+	conn     *ipv6.PacketConn
+	handlers map[int]dhcp4.Handler // by interface index
+}
+
+// listenDHCP4o6 opens the socket for handlers, by interface name, and joins
+// the servers' multicast group on each of their interfaces
+func listenDHCP4o6(handlers map[string]dhcp4.Handler) (*dhcp4o6Server, error) {
+	l, err := listeners.ListenPacket("dhcp4o6", "udp6", ":547")
+	if err != nil {
+		return nil, err
+	}
+	s := &dhcp4o6Server{conn: ipv6.NewPacketConn(l), handlers: make(map[int]dhcp4.Handler)}
+	if err := s.conn.SetControlMessage(ipv6.FlagInterface, true); err != nil {
+		listeners.release("dhcp4o6")
+		return nil, fmt.Errorf("DHCPv4 over DHCPv6 needs to know which interface queries come in on: %s", err)
+	}
+	for nic, handler := range handlers {
+		iface, err := net.InterfaceByName(nic)
+		if err == nil {
+			err = s.conn.JoinGroup(iface, &net.UDPAddr{IP: allDHCP6Servers})
+		}
+		if err != nil {
+			listeners.release("dhcp4o6")
+			return nil, fmt.Errorf("%s: %s", nic, err)
+		}
+		s.handlers[iface.Index] = handler
+	}
+	return s, nil
+}
+
+// serve answers queries until the socket is released
+func (s *dhcp4o6Server) serve() error {
+	b := make([]byte, 1500)
+	for {
+		n, cm, addr, err := s.conn.ReadFrom(b)
+		if err != nil {
+			return err
+		}
+		if cm == nil || s.handlers[cm.IfIndex] == nil {
+			continue
+		}
+		reply := dhcp4o6Reply(s.handlers[cm.IfIndex], b[:n])
+		if reply == nil {
+			continue
+		}
+		if _, err := s.conn.WriteTo(reply, &ipv6.ControlMessage{IfIndex: cm.IfIndex}, addr); err != nil {
+			logger.Printf("DHCPv4 over DHCPv6: unable to reply to %s: %s\n", addr, err)
+		}
+	}
+}
+
+// dhcp4o6Reply has handler answer the DHCPv4 message in msg, a
+// DHCPv4-query or a relayed one, and wraps the answer in a DHCPv4-response,
+// relayed back the way the query came. It returns nil when there is nothing
+// to send.
+func dhcp4o6Reply(handler dhcp4.Handler, msg []byte) []byte {
+	if len(msg) < 4 {
+		return nil
+	}
+	switch msg[0] {
+	case dhcp6RelayForw:
+		if len(msg) < dhcp6RelayHeaderLen {
+			return nil
+		}
+		options := parseDHCP6Options(msg[dhcp6RelayHeaderLen:])
+		inner := dhcp4o6Reply(handler, options[dhcp6OptionRelayMsg])
+		if inner == nil {
+			return nil
+		}
+		reply := append([]byte{dhcp6RelayRepl}, msg[1:dhcp6RelayHeaderLen]...)
+		if id, ok := options[dhcp6OptionInterfaceID]; ok {
+			reply = appendDHCP6Option(reply, dhcp6OptionInterfaceID, id)
+		}
+		return appendDHCP6Option(reply, dhcp6OptionRelayMsg, inner)
+
+	case dhcp6V4Query:
+		// Like dhcp4.Serve, ignore what cannot be a client's message
+		packet := dhcp4.Packet(parseDHCP6Options(msg[4:])[dhcp6OptionV4Msg])
+		if len(packet) < 240 || packet.OpCode() != dhcp4.BootRequest || packet.HLen() > 16 {
+			return nil
+		}
+		reqOptions := packet.ParseOptions()
+		t := reqOptions[dhcp4.OptionDHCPMessageType]
+		if len(t) != 1 || dhcp4.MessageType(t[0]) < dhcp4.Discover || dhcp4.MessageType(t[0]) > dhcp4.Inform {
+			return nil
+		}
+		response := handler.ServeDHCP(packet, dhcp4.MessageType(t[0]), reqOptions)
+		if response == nil {
+			return nil
+		}
+		// The flags of a response are all reserved
+		return appendDHCP6Option([]byte{dhcp6V4Response, 0, 0, 0}, dhcp6OptionV4Msg, response)
+	}
+	return nil
+}
+
+// parseDHCP6Options returns the options of a DHCPv6 message by code; a
+// truncated option ends them
+func parseDHCP6Options(b []byte) map[uint16][]byte {
+	options := make(map[uint16][]byte)
+	for len(b) >= 4 {
+		code, length := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+length {
+			break
+		}
+		options[code] = b[4 : 4+length]
+		b = b[4+length:]
+	}
+	return options
+}
+
+func appendDHCP6Option(b []byte, code uint16, value []byte) []byte {
+	b = append(b, byte(code>>8), byte(code), byte(len(value)>>8), byte(len(value)))
+	return append(b, value...)
+}
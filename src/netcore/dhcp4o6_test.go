@@ -0,0 +1,39 @@
+package netcore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestDHCP6Options(t *testing.T) {
+	var b []byte
+	b = appendDHCP6Option(b, dhcp6OptionInterfaceID, []byte("eth0"))
+	b = appendDHCP6Option(b, dhcp6OptionV4Msg, []byte{1, 2, 3})
+	options := parseDHCP6Options(append(b, 0, 9, 0, 10, 1)) // truncated
+	if len(options) != 2 || string(options[dhcp6OptionInterfaceID]) != "eth0" || !bytes.Equal(options[dhcp6OptionV4Msg], []byte{1, 2, 3}) {
+		t.Errorf("options parsed as %v", options)
+	}
+}
+
+type dhcpHandlerFunc func(dhcp4.Packet, dhcp4.MessageType, dhcp4.Options) dhcp4.Packet
+
+func (f dhcpHandlerFunc) ServeDHCP(p dhcp4.Packet, t dhcp4.MessageType, o dhcp4.Options) dhcp4.Packet {
+	return f(p, t, o)
+}
+
+func TestDHCP4o6ReplyIgnoresOtherMessages(t *testing.T) {
+	handler := dhcpHandlerFunc(func(dhcp4.Packet, dhcp4.MessageType, dhcp4.Options) dhcp4.Packet {
+		t.Errorf("handler called")
+		return nil
+	})
+	solicit := []byte{1, 0, 0, 1}
+	relayed := appendDHCP6Option(append([]byte{dhcp6RelayForw}, make([]byte, dhcp6RelayHeaderLen-1)...), dhcp6OptionRelayMsg, solicit)
+	query := appendDHCP6Option([]byte{dhcp6V4Query, 0, 0, 0}, dhcp6OptionV4Msg, []byte{1, 2, 3})
+	for _, msg := range [][]byte{solicit, relayed, query, relayed[:20]} {
+		if reply := dhcp4o6Reply(handler, msg); reply != nil {
+			t.Errorf("reply to %v", msg)
+		}
+	}
+}
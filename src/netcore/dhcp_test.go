@@ -0,0 +1,65 @@
+package netcore
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDHCPConcurrentAllocation(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("10.0.0.0/26")
+	d := &DHCPService{guestPool: pool, db: NewMemoryDB()}
+
+	const clients = 63 // every address in the pool but the network's
+	ips := make(chan net.IP, clients+1)
+	var wg sync.WaitGroup
+	for i := 0; i <= clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ips <- d.allocateIP(nil, net.HardwareAddr{2, 0, 0, 0, 0, byte(i)})
+		}(i)
+	}
+	wg.Wait()
+	close(ips)
+
+	seen := make(map[string]bool)
+	exhausted := 0
+	for ip := range ips {
+		if ip == nil {
+			exhausted++
+			continue
+		}
+		if seen[ip.String()] {
+			t.Errorf("%s was allocated twice", ip)
+		}
+		seen[ip.String()] = true
+	}
+	if len(seen) != clients || exhausted != 1 {
+		t.Errorf("allocated %d addresses with %d clients left over, want %d and 1", len(seen), exhausted, clients)
+	}
+}
+
+func TestDHCPAllocationRetransmitted(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("10.0.0.0/29")
+	db := NewMemoryDB()
+	d := &DHCPService{guestPool: pool, db: db}
+	// An address leased to another client is not offered
+	if ok, err := db.ReserveIP(net.ParseIP("10.0.0.1"), net.HardwareAddr{2, 0, 0, 0, 0, 1}, time.Hour); !ok || err != nil {
+		t.Fatalf("ReserveIP() = %t, %v", ok, err)
+	}
+
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	first := d.allocateIP(nil, mac)
+	if !first.Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("allocateIP() = %s, want 10.0.0.2", first)
+	}
+	// The client retransmits its Discover and gets the same offer
+	if again := d.allocateIP(nil, mac); !again.Equal(first) {
+		t.Errorf("allocateIP() again = %s, want %s", again, first)
+	}
+	if other := d.allocateIP(nil, net.HardwareAddr{2, 0, 0, 0, 0, 3}); !other.Equal(net.ParseIP("10.0.0.3")) {
+		t.Errorf("allocateIP() for another client = %s, want 10.0.0.3", other)
+	}
+}
@@ -0,0 +1,257 @@
+package netcore
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// dhcpBench drives DORA exchanges against a DHCP server, posing as a relay
+// agent so that replies come back to us by unicast however many clients we
+// pretend to be. The server's pool must be able to hold the clients, and
+// they release their leases afterwards unless told otherwise.
+type dhcpBench struct {
+	conn    *net.UDPConn
+	server  *net.UDPAddr
+	relay   net.IP
+	timeout time.Duration
+
+	sync.Mutex
+	pending map[uint32]chan dhcp4.Packet // by transaction ID
+}
+
+// dhcpBenchResult is what happened to one simulated client
+type dhcpBenchResult struct {
+	latency time.Duration // from Discover to ACK
+	err     error
+}
+
+// DHCPBench is "netcore dhcpbench": it runs the benchmark and prints a
+// report, without touching etcd
+func DHCPBench(args []string) error {
+	flags := flag.NewFlagSet("dhcpbench", flag.ExitOnError)
+	server := flags.String("server", "127.0.0.1:67", "DHCP server to test.")
+	relay := flags.String("relay", "", "Address to use as the relay agent (giaddr), where the server sends its replies; defaults to the address we reach the server from.")
+	clients := flags.Int("clients", 1000, "Number of simulated clients, each with a random MAC.")
+	concurrency := flags.Int("concurrency", 50, "Number of exchanges in flight at once.")
+	timeout := flags.Duration("timeout", 2*time.Second, "How long to wait for each reply.")
+	release := flags.Bool("release", true, "Release each lease after it is acknowledged, so that the pool is left as it was.")
+	flags.Parse(args)
+	if *clients < 1 || *concurrency < 1 {
+		return errors.New("clients and concurrency must be at least 1")
+	}
+
+	serverAddr, err := net.ResolveUDPAddr("udp4", *server)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp4", nil, serverAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	b := &dhcpBench{
+		conn:    conn,
+		server:  serverAddr,
+		relay:   conn.LocalAddr().(*net.UDPAddr).IP.To4(),
+		timeout: *timeout,
+		pending: make(map[uint32]chan dhcp4.Packet),
+	}
+	if *relay != "" {
+		if b.relay = net.ParseIP(*relay).To4(); b.relay == nil {
+			return fmt.Errorf("bad relay address %q", *relay)
+		}
+	}
+	go b.receive()
+
+	rand.Seed(time.Now().UnixNano())
+	work := make(chan struct{})
+	results := make(chan dhcpBenchResult, *clients)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				results <- b.dora(randomMAC(), *release)
+			}
+		}()
+	}
+	start := time.Now()
+	for i := 0; i < *clients; i++ {
+		work <- struct{}{}
+	}
+	close(work)
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(results)
+
+	var latencies dhcpBenchLatencies
+	failures := make(map[string]int)
+	for result := range results {
+		if result.err != nil {
+			failures[result.err.Error()]++
+			continue
+		}
+		latencies = append(latencies, result.latency)
+	}
+	sort.Sort(latencies)
+
+	fmt.Printf("%d clients against %s in %s: %.1f transactions/s\n", *clients, serverAddr, elapsed, float64(*clients)/elapsed.Seconds())
+	fmt.Printf("succeeded: %d, failed: %d (%.2f%%)\n", len(latencies), *clients-len(latencies), 100*float64(*clients-len(latencies))/float64(*clients))
+	for reason, n := range failures {
+		fmt.Printf("  %6d %s\n", n, reason)
+	}
+	if len(latencies) > 0 {
+		var sum time.Duration
+		for _, l := range latencies {
+			sum += l
+		}
+		fmt.Printf("latency: min %s, avg %s, p50 %s, p90 %s, p99 %s, max %s\n",
+			latencies[0], sum/time.Duration(len(latencies)),
+			latencies.percentile(50), latencies.percentile(90), latencies.percentile(99),
+			latencies[len(latencies)-1])
+	}
+	return nil
+}
+
+// dora takes one client through Discover, Offer, Request and ACK, then
+// releases its lease if asked to
+func (b *dhcpBench) dora(mac net.HardwareAddr, release bool) dhcpBenchResult {
+	start := time.Now()
+	offer, err := b.exchange(dhcp4.RequestPacket(dhcp4.Discover, mac, nil, randomXId(), false, nil))
+	if err != nil {
+		return dhcpBenchResult{err: fmt.Errorf("discover: %s", err)}
+	}
+	if msgType := benchMessageType(offer); msgType != dhcp4.Offer {
+		return dhcpBenchResult{err: fmt.Errorf("discover: got message type %d instead of an offer", msgType)}
+	}
+	serverID := offer.ParseOptions()[dhcp4.OptionServerIdentifier]
+	ack, err := b.exchange(dhcp4.RequestPacket(dhcp4.Request, mac, nil, offer.XId(), false, []dhcp4.Option{
+		{Code: dhcp4.OptionRequestedIPAddress, Value: offer.YIAddr().To4()},
+		{Code: dhcp4.OptionServerIdentifier, Value: serverID},
+	}))
+	if err != nil {
+		return dhcpBenchResult{err: fmt.Errorf("request: %s", err)}
+	}
+	switch msgType := benchMessageType(ack); msgType {
+	case dhcp4.ACK:
+	case dhcp4.NAK:
+		return dhcpBenchResult{err: errors.New("request: NAK")}
+	default:
+		return dhcpBenchResult{err: fmt.Errorf("request: got message type %d instead of an ACK", msgType)}
+	}
+	latency := time.Since(start)
+
+	if release {
+		packet := dhcp4.RequestPacket(dhcp4.Release, mac, ack.YIAddr(), randomXId(), false, []dhcp4.Option{
+			{Code: dhcp4.OptionServerIdentifier, Value: serverID},
+		})
+		packet.SetGIAddr(b.relay)
+		b.conn.Write(packet) // no reply is expected
+	}
+	return dhcpBenchResult{latency: latency}
+}
+
+// exchange sends packet through our relay address and waits for the reply
+// with the same transaction ID
+func (b *dhcpBench) exchange(packet dhcp4.Packet) (dhcp4.Packet, error) {
+	packet.SetGIAddr(b.relay)
+	xid := binary.BigEndian.Uint32(packet.XId())
+	reply := make(chan dhcp4.Packet, 1)
+	b.Lock()
+	b.pending[xid] = reply
+	b.Unlock()
+	defer func() {
+		b.Lock()
+		delete(b.pending, xid)
+		b.Unlock()
+	}()
+
+	if _, err := b.conn.Write(packet); err != nil {
+		return nil, err
+	}
+	select {
+	case p := <-reply:
+		return p, nil
+	case <-time.After(b.timeout):
+		return nil, errors.New("timed out")
+	}
+}
+
+// receive hands replies to the exchanges waiting for them, until the
+// connection is closed
+func (b *dhcpBench) receive() {
+	buffer := make([]byte, 1500)
+	for {
+		n, err := b.conn.Read(buffer)
+		if err != nil {
+			return
+		}
+		if n < 240 {
+			continue // too short for a DHCP packet
+		}
+		packet := dhcp4.Packet(append([]byte(nil), buffer[:n]...))
+		if packet.OpCode() != dhcp4.BootReply {
+			continue
+		}
+		b.Lock()
+		reply, ok := b.pending[binary.BigEndian.Uint32(packet.XId())]
+		b.Unlock()
+		if ok {
+			select {
+			case reply <- packet:
+			default: // a duplicate
+			}
+		}
+	}
+}
+
+func benchMessageType(p dhcp4.Packet) dhcp4.MessageType {
+	msgType := p.ParseOptions()[dhcp4.OptionDHCPMessageType]
+	if len(msgType) != 1 {
+		return 0
+	}
+	return dhcp4.MessageType(msgType[0])
+}
+
+// randomMAC returns a unicast, locally administered MAC
+func randomMAC() net.HardwareAddr {
+	mac := make(net.HardwareAddr, 6)
+	for i := range mac {
+		mac[i] = byte(rand.Intn(256))
+	}
+	mac[0] = mac[0]&^0x01 | 0x02
+	return mac
+}
+
+func randomXId() []byte {
+	xid := make([]byte, 4)
+	binary.BigEndian.PutUint32(xid, rand.Uint32())
+	return xid
+}
+
+// dhcpBenchLatencies sorts latencies for percentiles
+type dhcpBenchLatencies []time.Duration
+
+func (l dhcpBenchLatencies) Len() int           { return len(l) }
+func (l dhcpBenchLatencies) Less(i, j int) bool { return l[i] < l[j] }
+func (l dhcpBenchLatencies) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// percentile returns the latency below which p percent of the sorted
+// latencies fall
+func (l dhcpBenchLatencies) percentile(p int) time.Duration {
+	i := len(l) * p / 100
+	if i >= len(l) {
+		i = len(l) - 1
+	}
+	return l[i]
+}
@@ -0,0 +1,182 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/krolaw/dhcp4"
+	"golang.org/x/net/ipv4"
+)
+
+// errDHCPClosed is returned by reads from a DHCP interface no longer served
+var errDHCPClosed = errors.New("DHCP socket closed")
+
+// dhcpSocket is the one udp4 :67 socket that every DHCP interface shares,
+// since a second socket bound to the port fails. Packets go to the
+// connection of the interface they came in on.
+type dhcpSocket struct {
+	sync.Mutex
+	conn   *ipv4.PacketConn
+	ifaces map[int]*dhcpIfConn // by interface index, 0 where the platform cannot tell interfaces apart
+}
+
+var dhcpShared = &dhcpSocket{ifaces: make(map[int]*dhcpIfConn)}
+
+// dhcpIfConn is a DHCP connection that only sees and sends packets on one
+// interface, like the one dhcp4.ListenAndServeIf opens, except that it
+// shares its socket with the other interfaces and can be handed over to a
+// new process
+type dhcpIfConn struct {
+	socket  *dhcpSocket
+	nic     string
+	ifIndex int
+	packets chan dhcpPacket
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type dhcpPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// ServeDHCPIf serves DHCP on the named interface, with a socket that can be
+// handed over to a new process
+func ServeDHCPIf(nic string, handler dhcp4.Handler) error {
+	conn, err := listenDHCPIf(nic)
+	if err != nil {
+		return err
+	}
+	defer releaseDHCPIf(nic)
+	return dhcp4.Serve(conn, handler)
+}
+
+// listenDHCPIf opens the DHCP connection of the named interface, opening
+// the shared socket if it is the first; release it with releaseDHCPIf
+func listenDHCPIf(nic string) (*dhcpIfConn, error) {
+	iface, err := net.InterfaceByName(nic)
+	if err != nil {
+		return nil, err
+	}
+	s := dhcpShared
+	s.Lock()
+	defer s.Unlock()
+	perInterface := true
+	if s.conn == nil {
+		l, err := listeners.ListenPacket("dhcp", "udp4", ":67")
+		if err != nil {
+			return nil, err
+		}
+		s.conn = ipv4.NewPacketConn(l)
+		if err := s.conn.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+			// Windows cannot tell which interface a packet came in on;
+			// the socket then serves whichever interface packets come
+			// from, and there can only be one
+			logger.Printf("DHCP on %s cannot be limited to that interface (%s); it must be the only DHCP interface\n", nic, err)
+			perInterface = false
+		}
+		go s.read(s.conn)
+	} else if _, shared := s.ifaces[0]; shared {
+		perInterface = false
+	}
+	c := &dhcpIfConn{socket: s, nic: nic, packets: make(chan dhcpPacket, 64), closed: make(chan struct{})}
+	if perInterface {
+		c.ifIndex = iface.Index
+	}
+	if !perInterface && len(s.ifaces) > 0 {
+		return nil, fmt.Errorf("DHCP on %s: this platform can only serve DHCP on one interface", nic)
+	}
+	if _, taken := s.ifaces[c.ifIndex]; taken {
+		return nil, fmt.Errorf("DHCP is already served on %s", nic)
+	}
+	s.ifaces[c.ifIndex] = c
+	return c, nil
+}
+
+// releaseDHCPIf stops serving the named interface, and closes the shared
+// socket once no interface is left
+func releaseDHCPIf(nic string) {
+	s := dhcpShared
+	s.Lock()
+	for index, c := range s.ifaces {
+		if c.nic == nic {
+			delete(s.ifaces, index)
+			c.close()
+		}
+	}
+	last := len(s.ifaces) == 0 && s.conn != nil
+	if last {
+		s.conn = nil
+	}
+	s.Unlock()
+	if last {
+		listeners.release("dhcp")
+	}
+}
+
+// read hands each packet of conn to the connection of its interface until
+// the socket is closed
+func (s *dhcpSocket) read(conn *ipv4.PacketConn) {
+	for {
+		b := make([]byte, 1500)
+		n, cm, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			s.Lock()
+			if s.conn == conn { // not released, so every interface fails
+				for index, c := range s.ifaces {
+					delete(s.ifaces, index)
+					c.close()
+				}
+				s.conn = nil
+			}
+			s.Unlock()
+			return
+		}
+		index := 0
+		if cm != nil {
+			index = cm.IfIndex
+		}
+		s.Lock()
+		c := s.ifaces[index]
+		if c == nil {
+			c = s.ifaces[0]
+		}
+		s.Unlock()
+		if c == nil {
+			continue // packets from other interfaces are someone else's
+		}
+		select {
+		case c.packets <- dhcpPacket{data: b[:n], addr: addr}:
+		default:
+			dhcpStats.Count("dropped")
+		}
+	}
+}
+
+func (c *dhcpIfConn) close() {
+	c.once.Do(func() { close(c.closed) })
+}
+
+func (c *dhcpIfConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	select {
+	case p := <-c.packets:
+		return copy(b, p.data), p.addr, nil
+	case <-c.closed:
+		return 0, nil, errDHCPClosed
+	}
+}
+
+func (c *dhcpIfConn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	c.socket.Lock()
+	conn := c.socket.conn
+	c.socket.Unlock()
+	if conn == nil {
+		return 0, errDHCPClosed
+	}
+	if c.ifIndex == 0 {
+		return conn.WriteTo(b, nil, addr)
+	}
+	return conn.WriteTo(b, &ipv4.ControlMessage{IfIndex: c.ifIndex}, addr)
+}
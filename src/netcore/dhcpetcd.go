@@ -1,23 +1,23 @@
-package main
+package netcore
 
 import (
-	"errors"
+	"fmt"
 	"net"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 )
 
-func (db EtcdDB) InitDHCP() {
-	db.client.CreateDir("dhcp", 0)
-}
-
 func (db EtcdDB) GetIP(ip net.IP) (IPEntry, error) {
 	key := etcdKeyFromIP(ip)
 	response, err := db.client.Get(key, false, false)
+	if err != nil && !etcdKeyNotFound(err) {
+		return IPEntry{}, err
+	}
 	if response == nil || response.Node == nil {
-		return IPEntry{}, errors.New("Not Found")
+		return IPEntry{}, notFoundError("no lease for %s", ip.String())
 	}
 	mac, err := net.ParseMAC(response.Node.Value)
 	if err != nil {
@@ -68,8 +68,15 @@ func (db EtcdDB) GetMAC(mac net.HardwareAddr, cascade bool) (*MACEntry, bool, er
 	return &entry, true, nil
 }
 
+// RenewLease extends the lease of a client that holds its address. A
+// reserved address is the client's for good, so there is nothing to extend.
 func (db EtcdDB) RenewLease(lease *MACEntry) error {
-	// FIXME: Validate lease
+	if err := validateLease(lease); err != nil {
+		return err
+	}
+	if reserved, err := db.hasReservation(lease.MAC); err != nil || reserved {
+		return err
+	}
 	duration := uint64(lease.Duration.Seconds() + 0.5) // Half second jitter to hide network delay
 	_, err := db.client.CompareAndSwap("dhcp/"+lease.IP.String(), lease.MAC.String(), duration, lease.MAC.String(), 0)
 	if err == nil {
@@ -78,26 +85,170 @@ func (db EtcdDB) RenewLease(lease *MACEntry) error {
 	return err
 }
 
+// CreateLease leases an address to a client, unless it is held by another
+// or the client has a reservation, which a lease would overwrite
 func (db EtcdDB) CreateLease(lease *MACEntry) error {
-	// FIXME: Validate lease
+	if err := validateLease(lease); err != nil {
+		return err
+	}
+	if err := db.checkNoReservation(lease.MAC); err != nil {
+		return err
+	}
 	duration := uint64(lease.Duration.Seconds() + 0.5)
 	_, err := db.client.Create("dhcp/"+lease.IP.String(), lease.MAC.String(), duration)
+	if err != nil && etcdKeyExists(err) {
+		// Only the client we offered the address to may take it
+		_, err = db.client.CompareAndSwap("dhcp/"+lease.IP.String(), lease.MAC.String(), duration, lease.MAC.String(), 0)
+	}
 	if err == nil {
 		return db.WriteLease(lease)
 	}
 	return err
 }
 
+// ReserveIP holds ip for mac for a while, reporting false if the address is
+// taken. Holding it again for the same MAC extends the hold. Both are atomic
+// in etcd, so two servers cannot hold the same address.
+func (db EtcdDB) ReserveIP(ip net.IP, mac net.HardwareAddr, hold time.Duration) (bool, error) {
+	key, ttl := "dhcp/"+ip.String(), uint64(hold.Seconds()+0.5)
+	_, err := db.client.Create(key, mac.String(), ttl)
+	if err != nil && etcdKeyExists(err) {
+		_, err = db.client.CompareAndSwap(key, mac.String(), ttl, mac.String(), 0)
+		if etcdCompareFailed(err) || etcdKeyNotFound(err) {
+			return false, nil
+		}
+	}
+	return err == nil, err
+}
+
 func (db EtcdDB) WriteLease(lease *MACEntry) error {
-	// FIXME: Validate lease
+	if err := validateLease(lease); err != nil {
+		return err
+	}
+	if err := db.checkNoReservation(lease.MAC); err != nil {
+		return err
+	}
 	// NOTE: This does not save attributes. That should probably happen in a different function.
 	duration := uint64(lease.Duration.Seconds() + 0.5) // Half second jitter to hide network delay
 	// FIXME: Decide what to do if either of these calls returns an error
 	db.client.CreateDir("dhcp/"+lease.MAC.String(), 0)
 	db.client.Set("dhcp/"+lease.MAC.String()+"/ip", lease.IP.String(), duration)
+	for key, value := range map[string]string{"circuitid": lease.CircuitID, "remoteid": lease.RemoteID, "vendorclass": lease.VendorClass} {
+		if value != "" {
+			db.client.Set("dhcp/"+lease.MAC.String()+"/"+key, value, duration)
+		} else {
+			db.client.Delete("dhcp/"+lease.MAC.String()+"/"+key, false)
+		}
+	}
 	return nil
 }
 
+// RememberLease keeps the lease's address for its client until retention
+// after the lease expires, both with the MAC and in dhcplast/<ip>, which is
+// how the pool knows the address was used recently
+func (db EtcdDB) RememberLease(lease *MACEntry, retention time.Duration) error {
+	if retention <= 0 {
+		return nil
+	}
+	ttl := uint64((lease.Duration + retention).Seconds() + 0.5)
+	if _, err := db.client.Set("dhcp/"+lease.MAC.String()+"/lastip", lease.IP.String(), ttl); err != nil {
+		return err
+	}
+	_, err := db.client.Set("dhcplast/"+lease.IP.String(), lease.MAC.String(), ttl)
+	return err
+}
+
+// RememberedIPs returns the addresses being kept for their last clients,
+// with the time each will be forgotten
+func (db EtcdDB) RememberedIPs() (map[string]time.Time, error) {
+	response, err := db.client.Get("dhcplast", false, false)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	remembered := make(map[string]time.Time)
+	for _, node := range response.Node.Nodes {
+		if !node.Dir && node.Expiration != nil {
+			remembered[path.Base(node.Key)] = *node.Expiration
+		}
+	}
+	return remembered, nil
+}
+
+// AbandonIP marks ip as in use for the quarantine period, so the pool skips
+// it, and forgets it as the lease and last address of mac. Only an address
+// that nobody holds, or that mac leases or was offered, is abandoned: a
+// reservation or another client's lease is left alone and reported as a
+// conflict, since any client may decline any address.
+func (db EtcdDB) AbandonIP(ip net.IP, mac net.HardwareAddr, quarantine time.Duration) error {
+	key, ttl := "dhcp/"+ip.String(), uint64(quarantine.Seconds()+0.5)
+	response, err := db.client.Get(key, false, false)
+	switch {
+	case etcdKeyNotFound(err):
+		_, err = db.client.Create(key, "abandoned", ttl)
+	case err != nil:
+	case response.Node.Expiration == nil:
+		return conflictError(fmt.Sprintf("%s is reserved for %s", ip, response.Node.Value))
+	case response.Node.Value == "abandoned":
+	case mac == nil || response.Node.Value != mac.String():
+		return conflictError(fmt.Sprintf("%s is held by %s", ip, response.Node.Value))
+	default:
+		_, err = db.client.CompareAndSwap(key, "abandoned", ttl, mac.String(), response.Node.ModifiedIndex)
+	}
+	if err != nil {
+		return err
+	}
+	db.client.Delete("dhcplast/"+ip.String(), false)
+	if mac != nil {
+		for _, key := range []string{"ip", "lastip"} {
+			db.client.CompareAndDelete("dhcp/"+mac.String()+"/"+key, ip.String(), 0)
+		}
+	}
+	return nil
+}
+
+// AuthorizeClient lets mac past the captive portal, or sends it back there.
+// The client notices when it next renews its short portal lease.
+func (db EtcdDB) AuthorizeClient(actor string, mac net.HardwareAddr, authorized bool) error {
+	key := "dhcp/" + mac.String() + "/authorized"
+	if authorized {
+		db.client.CreateDir("dhcp/"+mac.String(), 0)
+		if _, err := db.client.Set(key, "yes", 0); err != nil {
+			return err
+		}
+		auditChange(db, actor, "dhcp", mac.String(), "authorize", "", "yes")
+		return nil
+	}
+	if _, err := db.client.Delete(key, false); err != nil && !etcdKeyNotFound(err) {
+		return err
+	}
+	auditChange(db, actor, "dhcp", mac.String(), "unauthorize", "yes", "")
+	return nil
+}
+
+// hasReservation reports whether mac has a reservation: an address that,
+// unlike a lease's, does not expire
+func (db EtcdDB) hasReservation(mac net.HardwareAddr) (bool, error) {
+	response, err := db.client.Get("dhcp/"+mac.String()+"/ip", false, false)
+	if etcdKeyNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return response.Node.Expiration == nil, nil
+}
+
+func (db EtcdDB) checkNoReservation(mac net.HardwareAddr) error {
+	reserved, err := db.hasReservation(mac)
+	if err == nil && reserved {
+		err = conflictError(fmt.Sprintf("%s has a reservation, which a lease would overwrite", mac))
+	}
+	return err
+}
+
 // TODO: Write function for saving attributes to etcd?
 
 func etcdNodeToMACEntry(root *etcd.Node, entry *MACEntry) {
@@ -110,6 +261,14 @@ func etcdNodeToMACEntry(root *etcd.Node, entry *MACEntry) {
 		case "ip":
 			entry.IP = net.ParseIP(node.Value)
 			entry.Duration = time.Duration(node.TTL)
+		case "lastip":
+			entry.LastIP = net.ParseIP(node.Value)
+		case "circuitid":
+			entry.CircuitID = node.Value
+		case "remoteid":
+			entry.RemoteID = node.Value
+		case "vendorclass":
+			entry.VendorClass = node.Value
 		default:
 			if entry.Attr == nil {
 				entry.Attr = make(map[string]string)
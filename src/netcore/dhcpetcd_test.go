@@ -0,0 +1,67 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDHCPLeasesLeaveReservationsAlone(t *testing.T) {
+	db := NewMemoryDB()
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	ip := net.ParseIP("192.0.2.10")
+	db.client.Set("dhcp/"+ip.String(), mac.String(), 0)
+	db.client.CreateDir("dhcp/"+mac.String(), 0)
+	db.client.Set("dhcp/"+mac.String()+"/ip", ip.String(), 0)
+
+	if err := db.RenewLease(&MACEntry{MAC: mac, IP: ip, Duration: time.Hour}); err != nil {
+		t.Errorf("renewing a reserved address: %s", err)
+	}
+	if err := db.CreateLease(&MACEntry{MAC: mac, IP: net.ParseIP("192.0.2.100"), Duration: time.Hour}); ErrorKind(err) != ErrConflict {
+		t.Errorf("a lease was created over a reservation: %v", err)
+	}
+	for _, key := range []string{"dhcp/" + ip.String(), "dhcp/" + mac.String() + "/ip"} {
+		response, err := db.client.Get(key, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Node.Expiration != nil {
+			t.Errorf("%s of the reservation now expires", key)
+		}
+	}
+	if reserved, _ := db.hasReservation(mac); !reserved {
+		t.Errorf("the reservation is gone")
+	}
+}
+
+func TestDHCPAbandonIP(t *testing.T) {
+	db := NewMemoryDB()
+	client, _ := net.ParseMAC("02:00:00:00:00:01")
+	other, _ := net.ParseMAC("02:00:00:00:00:02")
+	leased, reserved := net.ParseIP("192.0.2.10"), net.ParseIP("192.0.2.20")
+	db.client.Set("dhcp/"+leased.String(), client.String(), 3600)
+	db.client.Set("dhcp/"+reserved.String(), client.String(), 0)
+
+	for _, ip := range []net.IP{leased, reserved} {
+		if err := db.AbandonIP(ip, other, time.Hour); ErrorKind(err) != ErrConflict {
+			t.Errorf("another client's decline of %s: %v", ip, err)
+		}
+	}
+	if err := db.AbandonIP(reserved, client, time.Hour); ErrorKind(err) != ErrConflict {
+		t.Errorf("declining a reservation: %v", err)
+	}
+	if err := db.AbandonIP(leased, client, time.Hour); err != nil {
+		t.Errorf("declining a lease: %s", err)
+	}
+
+	want := map[string]string{leased.String(): "abandoned", reserved.String(): client.String()}
+	for ip, value := range want {
+		response, err := db.client.Get("dhcp/"+ip, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Node.Value != value {
+			t.Errorf("%s is held by %s, want %s", ip, response.Node.Value, value)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package netcore
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// macPattern matches a MAC exactly, or every MAC that starts with a prefix
+// such as the OUI in b8:27:eb:*
+type macPattern struct {
+	prefix   []byte
+	wildcard bool
+}
+
+func parseMACPattern(s string) (*macPattern, error) {
+	p := &macPattern{}
+	if strings.HasSuffix(s, ":*") {
+		p.wildcard = true
+		s = strings.TrimSuffix(s, ":*")
+	}
+	for _, octet := range strings.Split(s, ":") {
+		var b byte
+		if len(octet) != 2 {
+			return nil, fmt.Errorf("bad MAC pattern %q", s)
+		}
+		if _, err := fmt.Sscanf(octet, "%02x", &b); err != nil {
+			return nil, fmt.Errorf("bad MAC pattern %q", s)
+		}
+		p.prefix = append(p.prefix, b)
+	}
+	if !p.wildcard && len(p.prefix) != 6 {
+		return nil, fmt.Errorf("bad MAC pattern %q: use a full MAC, or end a prefix with :*", s)
+	}
+	return p, nil
+}
+
+func (p *macPattern) matches(mac net.HardwareAddr) bool {
+	if p.wildcard {
+		return bytes.HasPrefix(mac, p.prefix)
+	}
+	return bytes.Equal(mac, p.prefix)
+}
+
+// macFilter is a DHCPMACFilter ready to use
+type macFilter struct {
+	allow, deny []*macPattern
+}
+
+func newMACFilter(filter DHCPMACFilter) (*macFilter, error) {
+	f := &macFilter{}
+	for _, list := range []struct {
+		patterns []string
+		into     *[]*macPattern
+	}{{filter.Allow, &f.allow}, {filter.Deny, &f.deny}} {
+		for _, s := range list.patterns {
+			p, err := parseMACPattern(s)
+			if err != nil {
+				return nil, err
+			}
+			*list.into = append(*list.into, p)
+		}
+	}
+	return f, nil
+}
+
+// check reports whether mac is denied, and whether it is known; clients are
+// known when there is no allow list
+func (f *macFilter) check(mac net.HardwareAddr) (denied, known bool) {
+	for _, p := range f.deny {
+		if p.matches(mac) {
+			return true, false
+		}
+	}
+	if len(f.allow) == 0 {
+		return false, true
+	}
+	for _, p := range f.allow {
+		if p.matches(mac) {
+			return false, true
+		}
+	}
+	return false, false
+}
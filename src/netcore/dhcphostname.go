@@ -0,0 +1,223 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// defaultDHCPHostname registers clients under the name they ask for, once
+// sanitized
+const defaultDHCPHostname = "{client-hostname}"
+
+// dhcpHostnameSuffixes is how many numbered names, name-2 and on, are tried
+// when a name is held by another address
+const dhcpHostnameSuffixes = 99
+
+// dhcpHostnameVars are the variables of hostname templates
+var dhcpHostnameVars = []string{"client-hostname", "mac", "vendor", "subnet", "ip"}
+
+// dhcpHostnameTemplate names the clients whose leases are registered in DNS.
+// It is a list of alternatives separated by commas, such as
+//
+//	{client-hostname},{vendor}-{mac},dhcp-{ip}
+//
+// and the first whose variables all have a value is used: client-hostname
+// is option 12 of the client, mac its MAC without separators, vendor its
+// device class or the first word of its vendor class, subnet the zone of
+// the subnet and ip its address with dashes. The name is then sanitized.
+type dhcpHostnameTemplate []string
+
+func parseDHCPHostnameTemplate(text string) (dhcpHostnameTemplate, error) {
+	var t dhcpHostnameTemplate
+	for _, alternative := range strings.Split(text, ",") {
+		alternative = strings.TrimSpace(alternative)
+		rest := alternative
+		for {
+			start := strings.Index(rest, "{")
+			end := strings.Index(rest, "}")
+			if start < 0 && end < 0 {
+				break
+			}
+			if start < 0 || end < start {
+				return nil, fmt.Errorf("unbalanced braces in %q", alternative)
+			}
+			if !containsString(dhcpHostnameVars, rest[start+1:end]) {
+				return nil, fmt.Errorf("unknown variable {%s}; use {%s}", rest[start+1:end], strings.Join(dhcpHostnameVars, "}, {"))
+			}
+			rest = rest[end+1:]
+		}
+		if alternative != "" {
+			t = append(t, alternative)
+		}
+	}
+	if len(t) == 0 {
+		return nil, fmt.Errorf("no name to register")
+	}
+	return t, nil
+}
+
+func checkDHCPHostname(value string) error {
+	_, err := parseDHCPHostnameTemplate(value)
+	return err
+}
+
+// name returns the first alternative that vars fill in, sanitized, or the
+// empty string if none does
+func (t dhcpHostnameTemplate) name(vars map[string]string) string {
+	for _, alternative := range t {
+		filled := true
+		for _, v := range dhcpHostnameVars {
+			if strings.Contains(alternative, "{"+v+"}") && vars[v] == "" {
+				filled = false
+				break
+			}
+		}
+		if !filled {
+			continue
+		}
+		name := alternative
+		for _, v := range dhcpHostnameVars {
+			name = strings.Replace(name, "{"+v+"}", vars[v], -1)
+		}
+		if name = sanitizeHostname(name); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// sanitizeHostname turns s into a single DNS label: a name such as
+// "host.local" keeps its first label, letters are lowercased, other
+// characters become dashes, and the label is cut to 63 characters
+func sanitizeHostname(s string) string {
+	if i := strings.Index(s, "."); i >= 0 {
+		s = s[:i]
+	}
+	label := make([]byte, 0, len(s))
+	for _, c := range []byte(strings.ToLower(s)) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			label = append(label, c)
+		} else if len(label) > 0 && label[len(label)-1] != '-' {
+			label = append(label, '-')
+		}
+	}
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return strings.Trim(string(label), "-")
+}
+
+// hostnameVars returns the template variables of a client
+func hostnameVars(entry *MACEntry, clientHostname, vendorClass, zone string) map[string]string {
+	vendor := deviceClass(vendorClass)
+	if fields := strings.Fields(vendorClass); vendor == "" && len(fields) > 0 {
+		vendor = fields[0]
+	}
+	return map[string]string{
+		"client-hostname": clientHostname,
+		"mac":             strings.Replace(entry.MAC.String(), ":", "", -1),
+		"vendor":          vendor,
+		"subnet":          zone,
+		"ip":              strings.Replace(entry.IP.String(), ".", "-", -1),
+	}
+}
+
+// What to do when the name of a client is held by another device
+const (
+	hostnameConflictReject = "reject" // leave the client unregistered
+	hostnameConflictSuffix = "suffix" // register the client as name-2, name-3...
+	hostnameConflictSteal  = "steal"  // take the name over, with an event
+)
+
+// registeredName returns the name a client is registered under in domain:
+// its reservation's name, which is the administrator's and used as is, or
+// else the name from the template, as the conflict policy settles a
+// collision with another device. exclusive is set when the name is taken
+// over; an empty name means the client is not registered, as in pools
+// named generically.
+func (d *DHCPService) registeredName(entry *MACEntry, domain string, options, reqOptions dhcp4.Options) (name string, exclusive bool, err error) {
+	if val, ok := options[dhcp4.OptionHostName]; ok {
+		return string(val), false, nil
+	}
+	if d.genericNames {
+		// The pool's addresses all have generic names already
+		return "", false, nil
+	}
+	vars := hostnameVars(entry, string(reqOptions[dhcp4.OptionHostName]), string(reqOptions[dhcp4.OptionVendorClassIdentifier]), d.zone)
+	if name = d.hostnames.name(vars); name == "" {
+		return "", false, nil
+	}
+	if d.hostnameConflict == hostnameConflictSuffix {
+		name, err = uniqueHostname(d.db, name, domain, entry)
+		return name, false, err
+	}
+	holders, err := hostnameHolders(d.db, name+"."+domain, entry)
+	if err != nil || len(holders) == 0 {
+		return name, false, err
+	}
+	data := map[string]string{"name": name + "." + domain, "mac": entry.MAC.String(), "ip": entry.IP.String(), "held_by": strings.Join(holders, ",")}
+	if d.hostnameConflict == hostnameConflictReject {
+		events.Publish(Event{Type: "dhcp.hostname.conflict", Severity: "warning", Message: fmt.Sprintf("%s asked for %s.%s, which %s holds; not registered", entry.MAC.String(), name, domain, strings.Join(holders, ", ")), Data: data})
+		return "", false, nil
+	}
+	events.Publish(Event{Type: "dhcp.hostname.stolen", Severity: "warning", Message: fmt.Sprintf("%s took %s.%s over from %s", entry.MAC.String(), name, domain, strings.Join(holders, ", ")), Data: data})
+	return name, true, nil
+}
+
+// uniqueHostname returns name, or the first of name-2, name-3... that no
+// other device holds an A record for in domain, so that a client never
+// takes over the name of another
+func uniqueHostname(db DB, name, domain string, entry *MACEntry) (string, error) {
+	for i := 1; i <= dhcpHostnameSuffixes; i++ {
+		candidate := name
+		if i > 1 {
+			suffix := "-" + strconv.Itoa(i)
+			if len(candidate)+len(suffix) > 63 {
+				candidate = candidate[:63-len(suffix)]
+			}
+			candidate += suffix
+		}
+		holders, err := hostnameHolders(db, candidate+"."+domain, entry)
+		if err != nil {
+			return "", err
+		}
+		if len(holders) == 0 {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s.%s and its %d numbered names are all taken", name, domain, dhcpHostnameSuffixes)
+}
+
+// hostnameHolders returns the live addresses of fqdn that belong to devices
+// other than entry's client: addresses leased to another MAC, and those
+// leased to no one, such as static records
+func hostnameHolders(db DB, fqdn string, entry *MACEntry) ([]string, error) {
+	record, err := db.GetDNS(fqdn, "A")
+	if err == ErrNotFound || etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var holders []string
+	for _, value := range record.Values {
+		if value.Expiration != nil && value.Expiration.Before(now) {
+			continue
+		}
+		ip := net.ParseIP(value.Value)
+		if ip.Equal(entry.IP) {
+			continue
+		}
+		if owner, err := db.GetIP(ip); err == nil && owner.MAC.String() == entry.MAC.String() {
+			continue
+		}
+		holders = append(holders, value.Value)
+	}
+	return holders, nil
+}
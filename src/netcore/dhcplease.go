@@ -0,0 +1,148 @@
+package netcore
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// DHCPLease is a lease or reservation in the form used by the admin API for
+// importing and exporting leases. Reservations have no expiration.
+type DHCPLease struct {
+	MAC         string            `json:"mac"`
+	IP          string            `json:"ip"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Expires     *time.Time        `json:"expires,omitempty"`
+	CircuitID   string            `json:"circuit_id,omitempty"`   // switch port, from the relay agent
+	RemoteID    string            `json:"remote_id,omitempty"`    // switch, from the relay agent
+	VendorClass string            `json:"vendor_class,omitempty"` // what the client says it is
+	Labels      map[string]string `json:"labels,omitempty"`       // of the reservation, for selectors
+}
+
+// validate checks the addresses of the lease, putting them in canonical form
+func (l *DHCPLease) validate() error {
+	mac, err := net.ParseMAC(l.MAC)
+	if err != nil {
+		return invalid("mac", "lease %s: %s", l.MAC, err)
+	}
+	ip := net.ParseIP(l.IP).To4()
+	if ip == nil {
+		return invalid("ip", "lease for %s: %q is not an IPv4 address", mac.String(), l.IP)
+	}
+	l.MAC, l.IP = mac.String(), ip.String()
+	if len(l.Labels) > 0 && l.Expires != nil {
+		return invalid("labels", "lease for %s: only reservations have labels", l.MAC)
+	}
+	return validateLabels(l.Labels)
+}
+
+// String describes the lease for the audit log
+func (l *DHCPLease) String() string {
+	s := l.MAC + " " + l.IP
+	if l.Hostname != "" {
+		s += " " + l.Hostname
+	}
+	if l.Expires == nil {
+		if len(l.Labels) > 0 {
+			s += " labels:" + formatLabels(l.Labels)
+		}
+		return s + " reserved"
+	}
+	return s + " until " + l.Expires.UTC().Format(time.RFC3339)
+}
+
+// writeLeasesCSV writes leases with a header row; reservations have no
+// expiration
+func writeLeasesCSV(w io.Writer, leases []DHCPLease) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"mac", "ip", "hostname", "expires", "circuit_id", "remote_id"})
+	for _, l := range leases {
+		expires := ""
+		if l.Expires != nil {
+			expires = l.Expires.UTC().Format(time.RFC3339)
+		}
+		out.Write([]string{l.MAC, l.IP, l.Hostname, expires, l.CircuitID, l.RemoteID})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeLeasesISC writes leases in the format of ISC dhcpd's dhcpd.leases,
+// which scripts written for dhcpd know how to read. Reservations are written
+// as leases that never end.
+func writeLeasesISC(w io.Writer, leases []DHCPLease) error {
+	if _, err := fmt.Fprintf(w, "# netcore leases, exported %s\n", time.Now().UTC().Format(iscLeaseTime)); err != nil {
+		return err
+	}
+	for _, l := range leases {
+		ends := "never"
+		if l.Expires != nil {
+			ends = fmt.Sprintf("%d %s", l.Expires.UTC().Weekday(), l.Expires.UTC().Format(iscLeaseTime))
+		}
+		fmt.Fprintf(w, "lease %s {\n  ends %s;\n  binding state active;\n  hardware ethernet %s;\n", l.IP, ends, l.MAC)
+		if l.Hostname != "" {
+			fmt.Fprintf(w, "  client-hostname %q;\n", l.Hostname)
+		}
+		if _, err := fmt.Fprintf(w, "}\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iscLeaseTime is the layout of times in dhcpd.leases, after the weekday
+const iscLeaseTime = "2006/01/02 15:04:05"
+
+// DHCPPoolUsage is how much of the DHCP pool of a zone is taken
+type DHCPPoolUsage struct {
+	Zone     string  `json:"zone"`
+	Pool     string  `json:"pool"`
+	Size     int     `json:"size"` // usable addresses
+	Leased   int     `json:"leased"`
+	Reserved int     `json:"reserved"`
+	Free     int     `json:"free"`
+	Used     float64 `json:"used"` // the fraction leased or reserved
+}
+
+// dhcpPoolUsage counts the active leases and the reservations in the pool of
+// every zone that has one
+func dhcpPoolUsage(db DB) ([]DHCPPoolUsage, error) {
+	subnets, err := db.ListSubnets()
+	if err != nil {
+		return nil, err
+	}
+	leases, err := db.ListLeases()
+	if err != nil {
+		return nil, err
+	}
+	usage := []DHCPPoolUsage{}
+	for _, subnet := range subnets {
+		_, pool, err := net.ParseCIDR(subnet.DHCPSubnet)
+		if err != nil {
+			continue
+		}
+		ones, bits := pool.Mask.Size()
+		u := DHCPPoolUsage{Zone: subnet.Zone, Pool: pool.String(), Size: 1 << uint(bits-ones)}
+		if u.Size > 2 {
+			u.Size -= 2 // the network and broadcast addresses
+		}
+		for _, lease := range leases {
+			if !pool.Contains(net.ParseIP(lease.IP)) {
+				continue
+			}
+			if lease.Expires == nil {
+				u.Reserved++
+			} else {
+				u.Leased++
+			}
+		}
+		if u.Free = u.Size - u.Leased - u.Reserved; u.Free < 0 {
+			u.Free = 0
+		}
+		u.Used = float64(u.Leased+u.Reserved) / float64(u.Size)
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
@@ -0,0 +1,132 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"time"
+)
+
+// ImportLease stores a lease or reservation brought over from another DHCP
+// server. Leases that have already expired are ignored. An address already
+// held by another MAC is left alone and reported as an error. The hostname
+// of a reservation becomes the host's name attribute; the hostname of a
+// lease is whatever the client asked for, so it will ask again.
+func (db EtcdDB) ImportLease(actor string, lease DHCPLease) error {
+	if err := lease.validate(); err != nil {
+		return err
+	}
+	var ttl uint64
+	if lease.Expires != nil {
+		remaining := lease.Expires.Sub(time.Now())
+		if remaining <= 0 {
+			return nil
+		}
+		ttl = uint64(remaining.Seconds() + 0.5)
+	}
+
+	response, err := db.client.Get("dhcp/"+lease.IP, false, false)
+	if err != nil && !etcdKeyNotFound(err) {
+		return err
+	}
+	if response != nil && response.Node != nil && response.Node.Value != lease.MAC {
+		return conflictError(fmt.Sprintf("lease %s: %s is already held by %s", lease.MAC, lease.IP, response.Node.Value))
+	}
+
+	if _, err := db.client.Set("dhcp/"+lease.IP, lease.MAC, ttl); err != nil {
+		return err
+	}
+	db.client.CreateDir("dhcp/"+lease.MAC, 0)
+	if _, err := db.client.Set("dhcp/"+lease.MAC+"/ip", lease.IP, ttl); err != nil {
+		return err
+	}
+	if lease.Expires == nil && lease.Hostname != "" {
+		if _, err := db.client.Set("dhcp/"+lease.MAC+"/name", lease.Hostname, 0); err != nil {
+			return err
+		}
+	}
+	for k, v := range lease.Labels {
+		key := "dhcp/" + lease.MAC + "/labels/" + k
+		if v == "" {
+			if _, err := db.client.Delete(key, false); err != nil && !etcdKeyNotFound(err) {
+				return err
+			}
+		} else if _, err := db.client.Set(key, v, 0); err != nil {
+			return err
+		}
+	}
+	auditChange(db, actor, "dhcp", lease.MAC, "import", "", lease.String())
+	return nil
+}
+
+// DeleteReservation removes the reservation of mac: its address, host name
+// and labels. Whatever else is known of the MAC, such as whether the
+// captive portal let it through, is kept.
+func (db EtcdDB) DeleteReservation(actor string, mac string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return invalid("mac", "%s", err)
+	}
+	mac = hw.String()
+	response, err := db.client.Get("dhcp/"+mac+"/ip", false, false)
+	if etcdKeyNotFound(err) || err == nil && response.Node.Expiration != nil {
+		return notFoundError("%s has no reservation", mac)
+	}
+	if err != nil {
+		return err
+	}
+	previous := DHCPLease{MAC: mac, IP: response.Node.Value}
+	if _, err := db.client.CompareAndDelete("dhcp/"+previous.IP, mac, 0); err != nil && !etcdKeyNotFound(err) && !etcdCompareFailed(err) {
+		return err
+	}
+	for _, key := range []string{"ip", "name", "labels"} {
+		if _, err := db.client.Delete("dhcp/"+mac+"/"+key, true); err != nil && !etcdKeyNotFound(err) {
+			return err
+		}
+	}
+	auditChange(db, actor, "dhcp", mac, "delete", previous.String(), "")
+	return nil
+}
+
+// ListLeases returns the current leases and reservations, with the host name
+// attribute of each MAC
+func (db EtcdDB) ListLeases() ([]DHCPLease, error) {
+	response, err := db.client.Get("dhcp", true, true)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var leases []DHCPLease
+	for _, node := range response.Node.Nodes {
+		if !node.Dir {
+			continue
+		}
+		lease := DHCPLease{MAC: path.Base(node.Key)}
+		for _, child := range node.Nodes {
+			switch path.Base(child.Key) {
+			case "ip":
+				lease.IP = child.Value
+				lease.Expires = child.Expiration
+			case "name":
+				lease.Hostname = child.Value
+			case "circuitid":
+				lease.CircuitID = child.Value
+			case "remoteid":
+				lease.RemoteID = child.Value
+			case "vendorclass":
+				lease.VendorClass = child.Value
+			case "labels":
+				lease.Labels = make(map[string]string)
+				for _, label := range child.Nodes {
+					lease.Labels[path.Base(label.Key)] = label.Value
+				}
+			}
+		}
+		if lease.IP != "" {
+			leases = append(leases, lease)
+		}
+	}
+	return leases, nil
+}
@@ -0,0 +1,156 @@
+package netcore
+
+import (
+	"encoding/binary"
+	"expvar"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+var (
+	dhcpPingTimeout = Flags.Duration("dhcpping", 0, "Ping pool addresses before offering them, waiting this long for a reply; an address that answers is in use without a lease and is quarantined (0 to disable; needs raw sockets).")
+	dhcpQuarantine  = Flags.Duration("dhcpquarantine", time.Hour, "How long an address is kept out of the pool after a client declines it or it answers a ping.")
+)
+
+// dhcpAckBuckets are the upper bounds of the time-to-ACK histogram
+var dhcpAckBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// maxPendingOffers bounds the offers we remember to time the ACKs that
+// follow; clients that never come back would otherwise pile up
+const maxPendingOffers = 10000
+
+// dhcpPoolStats counts what happens to the pool, published with expvar so
+// that exhaustion, conflicts and rogue servers show up before users notice.
+// Counters are in "dhcp", and the time from offer to ACK is a cumulative
+// histogram in "dhcp_ack_seconds".
+type dhcpPoolStats struct {
+	sync.Mutex
+	counts  *expvar.Map
+	latency *expvar.Map
+	offers  map[string]time.Time // by MAC
+}
+
+var dhcpStats = &dhcpPoolStats{
+	counts:  expvar.NewMap("dhcp"),
+	latency: expvar.NewMap("dhcp_ack_seconds"),
+	offers:  make(map[string]time.Time),
+}
+
+// Count adds one to a counter, such as "offers" or "conflicts"
+func (s *dhcpPoolStats) Count(name string) {
+	s.counts.Add(name, 1)
+}
+
+// Replied counts the reply we sent to mac, if any
+func (s *dhcpPoolStats) Replied(mac net.HardwareAddr, response dhcp4.Packet) {
+	if response == nil {
+		return
+	}
+	msgType := response.ParseOptions()[dhcp4.OptionDHCPMessageType]
+	if len(msgType) != 1 {
+		return
+	}
+	switch dhcp4.MessageType(msgType[0]) {
+	case dhcp4.Offer:
+		s.Offered(mac)
+	case dhcp4.ACK:
+		s.Acked(mac)
+	case dhcp4.NAK:
+		s.Count("naks")
+	}
+}
+
+// Offered notes when mac was offered an address
+func (s *dhcpPoolStats) Offered(mac net.HardwareAddr) {
+	s.Count("offers")
+	s.Lock()
+	defer s.Unlock()
+	if len(s.offers) >= maxPendingOffers {
+		s.offers = make(map[string]time.Time)
+	}
+	s.offers[mac.String()] = time.Now()
+}
+
+// Acked records the time since mac was offered an address, if it was.
+// Renewals have no offer and are only counted.
+func (s *dhcpPoolStats) Acked(mac net.HardwareAddr) {
+	s.Count("acks")
+	s.Lock()
+	offered, ok := s.offers[mac.String()]
+	delete(s.offers, mac.String())
+	s.Unlock()
+	if !ok {
+		return
+	}
+	elapsed := time.Since(offered)
+	for _, bound := range dhcpAckBuckets {
+		if elapsed <= bound {
+			s.latency.Add(fmt.Sprintf("le_%g", bound.Seconds()), 1)
+		}
+	}
+	s.latency.Add("le_inf", 1)
+	s.latency.Add("count", 1)
+	s.latency.AddFloat("sum", elapsed.Seconds())
+}
+
+// pingAddress reports whether ip answers an ICMP echo within timeout. Errors,
+// such as lacking the privilege for raw sockets, are logged and treated as
+// no answer so that they never stop us offering addresses.
+func pingAddress(ip net.IP, timeout time.Duration) bool {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		logger.Printf("DHCP ping of %s failed: %s\n", ip.String(), err)
+		return false
+	}
+	defer conn.Close()
+
+	id := uint16(time.Now().UnixNano())
+	echo := []byte{8, 0, 0, 0, 0, 0, 0, 1, 'n', 'e', 't', 'c', 'o', 'r', 'e'}
+	binary.BigEndian.PutUint16(echo[4:], id)
+	binary.BigEndian.PutUint16(echo[2:], icmpChecksum(echo))
+	if _, err := conn.WriteTo(echo, &net.IPAddr{IP: ip}); err != nil {
+		logger.Printf("DHCP ping of %s failed: %s\n", ip.String(), err)
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	reply := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(reply)
+		if err != nil {
+			return false // timed out
+		}
+		from, ok := addr.(*net.IPAddr)
+		if ok && from.IP.Equal(ip) && n >= 8 && reply[0] == 0 && binary.BigEndian.Uint16(reply[4:]) == id {
+			return true
+		}
+	}
+}
+
+// icmpChecksum is the Internet checksum of RFC 1071
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
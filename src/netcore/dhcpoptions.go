@@ -0,0 +1,123 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/miekg/dns"
+)
+
+// maxDHCPOptionLen is the most that fits in a single option. Longer values
+// would need RFC 3396 long option encoding, which our DHCP library does not
+// do.
+const maxDHCPOptionLen = 255
+
+// Options that our DHCP library has no names for
+const (
+	dhcpOptionDomainSearch    dhcp4.OptionCode = 119 // RFC 3397
+	dhcpOptionCaptivePortal   dhcp4.OptionCode = 114 // RFC 8910
+	dhcpOptionV6OnlyPreferred dhcp4.OptionCode = 108 // RFC 8925
+)
+
+// minV6OnlyWait is MIN_V6ONLY_WAIT from RFC 8925
+const minV6OnlyWait = 300
+
+// splitDHCPList splits a comma-separated setting into its trimmed, non-empty
+// items
+func splitDHCPList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// encodeDomainSearch encodes a comma-separated list of domains as the
+// domain search option (119), using DNS name compression as RFC 3397
+// requires
+func encodeDomainSearch(value string) ([]byte, error) {
+	var data []byte
+	offsets := make(map[string]int) // suffixes already written, for compression
+	for _, domain := range splitDHCPList(value) {
+		domain = strings.ToLower(dns.Fqdn(domain))
+		if !validDomainName(domain) {
+			return nil, fmt.Errorf("domain search: %q is not a domain name", domain)
+		}
+		labels := strings.Split(strings.TrimSuffix(domain, "."), ".")
+		terminator := []byte{0}
+		for i := range labels {
+			suffix := strings.Join(labels[i:], ".")
+			if offset, ok := offsets[suffix]; ok {
+				terminator = []byte{0xC0 | byte(offset>>8), byte(offset)}
+				break
+			}
+			if len(data) < 0x3FFF {
+				offsets[suffix] = len(data)
+			}
+			data = append(data, byte(len(labels[i])))
+			data = append(data, labels[i]...)
+		}
+		data = append(data, terminator...)
+	}
+	if len(data) > maxDHCPOptionLen {
+		return nil, fmt.Errorf("domain search: %d bytes encoded, more than fits in one option", len(data))
+	}
+	return data, nil
+}
+
+// encodeClasslessRoutes encodes a comma-separated list of routes, each
+// "destination/prefix gateway", as the classless static route option (121)
+// described in RFC 3442. Clients that accept this option ignore the router
+// option, so a default route through defaultGateway is added if the list
+// doesn't have one.
+func encodeClasslessRoutes(value string, defaultGateway net.IP) ([]byte, error) {
+	var data []byte
+	hasDefault := false
+	for _, route := range splitDHCPList(value) {
+		fields := strings.Fields(route)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("classless route %q: expected \"destination/prefix gateway\"", route)
+		}
+		_, destination, err := net.ParseCIDR(fields[0])
+		if err != nil || destination.IP.To4() == nil {
+			return nil, fmt.Errorf("classless route %q: bad IPv4 destination", route)
+		}
+		gateway := net.ParseIP(fields[1]).To4()
+		if gateway == nil {
+			return nil, fmt.Errorf("classless route %q: bad IPv4 gateway", route)
+		}
+		width, _ := destination.Mask.Size()
+		hasDefault = hasDefault || width == 0
+		data = appendClasslessRoute(data, width, destination.IP.To4(), gateway)
+	}
+	if !hasDefault && defaultGateway.To4() != nil && len(data) > 0 {
+		data = appendClasslessRoute(data, 0, net.IPv4zero.To4(), defaultGateway.To4())
+	}
+	if len(data) > maxDHCPOptionLen {
+		return nil, fmt.Errorf("classless routes: %d bytes encoded, more than fits in one option", len(data))
+	}
+	return data, nil
+}
+
+// appendClasslessRoute appends a route with only the significant octets of
+// its destination
+func appendClasslessRoute(data []byte, width int, destination, gateway net.IP) []byte {
+	data = append(data, byte(width))
+	data = append(data, destination[:(width+7)/8]...)
+	return append(data, gateway...)
+}
+
+// wantsIPv6Only reports whether the client asked for option 108, which means
+// it can do without an IPv4 address (RFC 8925)
+func wantsIPv6Only(reqOptions dhcp4.Options) bool {
+	for _, code := range reqOptions[dhcp4.OptionParameterRequestList] {
+		if dhcp4.OptionCode(code) == dhcpOptionV6OnlyPreferred {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package netcore
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestDHCPOptionEncoding(t *testing.T) {
+	// The example from RFC 3397 section 2
+	search, err := encodeDomainSearch("eng.apple.com, marketing.apple.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte("\x03eng\x05apple\x03com\x00"), append([]byte("\x09marketing"), 0xC0, 0x04)...)
+	if !bytes.Equal(search, want) {
+		t.Errorf("encodeDomainSearch = %x, want %x", search, want)
+	}
+
+	routes, err := encodeClasslessRoutes("10.17.0.0/16 10.0.0.1, 192.168.1.128/25 10.0.0.2", net.ParseIP("10.0.0.254"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []byte{16, 10, 17, 10, 0, 0, 1, 25, 192, 168, 1, 128, 10, 0, 0, 2, 0, 10, 0, 0, 254}
+	if !bytes.Equal(routes, want) {
+		t.Errorf("encodeClasslessRoutes = %v, want %v", routes, want)
+	}
+	if _, err := encodeClasslessRoutes("10.17.0.0/16", nil); err == nil {
+		t.Error("encodeClasslessRoutes accepted a route without a gateway")
+	}
+}
@@ -0,0 +1,70 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var dhcpKnownServers = Flags.String("dhcpknownservers", "", "Comma-separated addresses of DHCP servers besides the fleet's, such as a failover peer, that clients may take leases from without a dhcp.rogue event.")
+
+// rogueDHCPWatch spots DHCP servers that are neither ours nor known. A
+// client that picks an offer broadcasts its Request with the address of the
+// server it picked (option 54), so every server on the segment sees which
+// one that was, even when the offer came from elsewhere. The fleet's
+// servers are refreshed every minute at most.
+type rogueDHCPWatch struct {
+	sync.Mutex
+	fleet     map[string]bool
+	refreshed time.Time
+	reported  *eventThrottle
+}
+
+var rogueDHCP = &rogueDHCPWatch{reported: newEventThrottle(time.Hour)}
+
+// Check publishes a dhcp.rogue event, once an hour per server, when server,
+// which mac picked, is not one of ours or known
+func (w *rogueDHCPWatch) Check(db FleetDB, server net.IP, mac net.HardwareAddr) {
+	if w.known(db, server) {
+		return
+	}
+	dhcpStats.Count("rogue_requests")
+	if !w.reported.Allow(server.String()) {
+		return
+	}
+	events.Publish(Event{
+		Type:     "dhcp.rogue",
+		Severity: "critical",
+		Message:  fmt.Sprintf("%s took an offer from %s, which is not a known DHCP server", mac.String(), server.String()),
+		Data:     map[string]string{"server": server.String(), "mac": mac.String()},
+	})
+}
+
+// known reports whether server is a member of the fleet serving DHCP, or in
+// -dhcpknownservers
+func (w *rogueDHCPWatch) known(db FleetDB, server net.IP) bool {
+	for _, s := range strings.Split(*dhcpKnownServers, ",") {
+		if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil && ip.Equal(server) {
+			return true
+		}
+	}
+	w.Lock()
+	defer w.Unlock()
+	if time.Since(w.refreshed) > time.Minute {
+		if members, err := db.GetFleet(); err == nil {
+			w.fleet = make(map[string]bool)
+			for _, member := range members {
+				// Listen["dhcp"] is "<nic> [IP]" for each interface
+				for _, listen := range strings.Split(member.Listen["dhcp"], ",") {
+					if fields := strings.Fields(listen); len(fields) == 2 {
+						w.fleet[fields[1]] = true
+					}
+				}
+			}
+			w.refreshed = time.Now()
+		}
+	}
+	return w.fleet[server.String()]
+}
@@ -0,0 +1,119 @@
+package netcore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	snoopingFile     = Flags.String("snoopingfile", "", "File that the IP, MAC and switch port bindings of relayed DHCP clients are written to, as JSON or, if the name ends in .csv, CSV (empty to disable).")
+	snoopingInterval = Flags.Duration("snoopinginterval", time.Minute, "How often -snoopingfile is rewritten.")
+)
+
+// Sub-options of relay agent information, RFC 3046
+const (
+	relayAgentCircuitID = 1
+	relayAgentRemoteID  = 2
+)
+
+// parseRelayAgentInfo returns the circuit and remote IDs that a relay agent
+// added to a request. IDs that are not printable are written in hex.
+func parseRelayAgentInfo(data []byte) (circuitID, remoteID string) {
+	for len(data) >= 2 {
+		code, n := data[0], int(data[1])
+		if len(data) < 2+n {
+			break
+		}
+		switch code {
+		case relayAgentCircuitID:
+			circuitID = relayAgentID(data[2 : 2+n])
+		case relayAgentRemoteID:
+			remoteID = relayAgentID(data[2 : 2+n])
+		}
+		data = data[2+n:]
+	}
+	return circuitID, remoteID
+}
+
+func relayAgentID(id []byte) string {
+	for _, c := range id {
+		if c > unicode.MaxASCII || !unicode.IsPrint(rune(c)) {
+			hex := make([]string, len(id))
+			for i, c := range id {
+				hex[i] = fmt.Sprintf("%02x", c)
+			}
+			return strings.Join(hex, ":")
+		}
+	}
+	return string(id)
+}
+
+// snoopingBindings returns the leases of clients whose switch port we know,
+// which is what switches need for DHCP snooping and IP source guard ACLs
+func snoopingBindings(db DHCPDB) ([]DHCPLease, error) {
+	leases, err := db.ListLeases()
+	if err != nil {
+		return nil, err
+	}
+	bindings := []DHCPLease{}
+	for _, lease := range leases {
+		if lease.CircuitID != "" && lease.Expires != nil {
+			bindings = append(bindings, lease)
+		}
+	}
+	return bindings, nil
+}
+
+// snoopingSetup starts rewriting -snoopingfile
+func snoopingSetup(cfg *Config) {
+	if *snoopingFile == "" {
+		return
+	}
+	go func() {
+		for {
+			if err := writeSnoopingFile(cfg.db, *snoopingFile); err != nil {
+				logger.Printf("DHCP snooping file %s not written: %s\n", *snoopingFile, err)
+			}
+			time.Sleep(*snoopingInterval)
+		}
+	}()
+}
+
+// writeSnoopingFile replaces the file at name with the current bindings, by
+// renaming so that readers never see half of it
+func writeSnoopingFile(db DHCPDB, name string) error {
+	bindings, err := snoopingBindings(db)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if strings.HasSuffix(name, ".csv") {
+		err = writeLeasesCSV(&buf, bindings)
+	} else {
+		err = json.NewEncoder(&buf).Encode(bindings)
+	}
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,122 @@
+package netcore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Options from RFC 3925 that our DHCP library has no names for
+const (
+	dhcpOptionVIVendorClass dhcp4.OptionCode = 124
+	dhcpOptionVIVendorInfo  dhcp4.OptionCode = 125
+)
+
+// dhcpVendorOption is vendor-specific information handed to clients of a
+// vendor class: option 43 when enterprise is 0, otherwise that enterprise's
+// part of option 125
+type dhcpVendorOption struct {
+	class      string // prefix of the client's vendor class, or "*" for any
+	enterprise uint32 // IANA enterprise number
+	data       []byte
+}
+
+// parseDHCPVendorOption parses a declaration of the form "class 43 data" or
+// "class 125/enterprise data", where data is hex with optional colons
+func parseDHCPVendorOption(decl string) (*dhcpVendorOption, error) {
+	fields := strings.Fields(decl)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("dhcp vendor option %q: expected a class, an option and data", decl)
+	}
+	v := &dhcpVendorOption{class: fields[0]}
+	switch option := fields[1]; {
+	case option == "43":
+	case strings.HasPrefix(option, "125/"):
+		enterprise, err := strconv.ParseUint(strings.TrimPrefix(option, "125/"), 10, 32)
+		if err != nil || enterprise == 0 {
+			return nil, fmt.Errorf("dhcp vendor option %q: bad enterprise number", decl)
+		}
+		v.enterprise = uint32(enterprise)
+	default:
+		return nil, fmt.Errorf("dhcp vendor option %q: option must be 43 or 125/<enterprise>", decl)
+	}
+	data, err := hex.DecodeString(strings.Replace(fields[2], ":", "", -1))
+	if err != nil {
+		return nil, fmt.Errorf("dhcp vendor option %q: bad data: %s", decl, err)
+	}
+	if len(data) > maxDHCPOptionLen-5 {
+		return nil, fmt.Errorf("dhcp vendor option %q: %d bytes of data, more than fits in one option", decl, len(data))
+	}
+	v.data = data
+	return v, nil
+}
+
+// matches reports whether a client that sent reqOptions belongs to the
+// option's vendor class. Option 125 data also goes to clients that name
+// the same enterprise in option 124.
+func (v *dhcpVendorOption) matches(reqOptions dhcp4.Options) bool {
+	if v.class == "*" {
+		return true
+	}
+	if strings.HasPrefix(string(reqOptions[dhcp4.OptionVendorClassIdentifier]), v.class) {
+		return true
+	}
+	if v.enterprise != 0 {
+		for _, class := range parseVIVendorData(reqOptions[dhcpOptionVIVendorClass])[v.enterprise] {
+			if bytes.HasPrefix(class, []byte(v.class)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseVIVendorData splits the enterprise-number, length, data records of
+// options 124 and 125, ignoring anything truncated
+func parseVIVendorData(data []byte) map[uint32][][]byte {
+	records := make(map[uint32][][]byte)
+	for len(data) >= 5 {
+		enterprise := binary.BigEndian.Uint32(data)
+		n := int(data[4])
+		if len(data) < 5+n {
+			break
+		}
+		records[enterprise] = append(records[enterprise], data[5:5+n])
+		data = data[5+n:]
+	}
+	return records
+}
+
+// vendorOptionsFor returns options 43 and 125 for a client. The first
+// matching declaration wins for option 43 and for each enterprise in 125.
+func vendorOptionsFor(vendorOptions []*dhcpVendorOption, reqOptions dhcp4.Options) dhcp4.Options {
+	options := dhcp4.Options{}
+	var viInfo []byte
+	seen := make(map[uint32]bool)
+	for _, v := range vendorOptions {
+		if seen[v.enterprise] || !v.matches(reqOptions) {
+			continue
+		}
+		seen[v.enterprise] = true
+		if v.enterprise == 0 {
+			options[dhcp4.OptionVendorSpecificInformation] = v.data
+			continue
+		}
+		record := make([]byte, 5, 5+len(v.data))
+		binary.BigEndian.PutUint32(record, v.enterprise)
+		record[4] = byte(len(v.data))
+		if len(viInfo)+len(record)+len(v.data) > maxDHCPOptionLen {
+			continue
+		}
+		viInfo = append(viInfo, append(record, v.data...)...)
+	}
+	if len(viInfo) > 0 {
+		options[dhcpOptionVIVendorInfo] = viInfo
+	}
+	return options
+}
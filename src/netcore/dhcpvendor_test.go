@@ -0,0 +1,33 @@
+package netcore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestDHCPVendorOptions(t *testing.T) {
+	var vendorOptions []*dhcpVendorOption
+	for _, decl := range []string{"Cisco 43 f1:04:0a:00:00:05", "Cisco 125/9 0104", "* 125/9 ffff", "* 125/4491 02"} {
+		v, err := parseDHCPVendorOption(decl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vendorOptions = append(vendorOptions, v)
+	}
+	options := vendorOptionsFor(vendorOptions, dhcp4.Options{dhcp4.OptionVendorClassIdentifier: []byte("Cisco AP c2960")})
+	if want := []byte{0xf1, 4, 10, 0, 0, 5}; !bytes.Equal(options[dhcp4.OptionVendorSpecificInformation], want) {
+		t.Errorf("option 43 = %x, want %x", options[dhcp4.OptionVendorSpecificInformation], want)
+	}
+	if want := []byte{0, 0, 0, 9, 2, 1, 4, 0, 0, 0x11, 0x8b, 1, 2}; !bytes.Equal(options[dhcpOptionVIVendorInfo], want) {
+		t.Errorf("option 125 = %x, want %x", options[dhcpOptionVIVendorInfo], want)
+	}
+	options = vendorOptionsFor(vendorOptions, dhcp4.Options{})
+	if _, ok := options[dhcp4.OptionVendorSpecificInformation]; ok {
+		t.Error("option 43 was sent to a client of another vendor class")
+	}
+	if want := []byte{0, 0, 0, 9, 2, 0xff, 0xff, 0, 0, 0x11, 0x8b, 1, 2}; !bytes.Equal(options[dhcpOptionVIVendorInfo], want) {
+		t.Errorf("option 125 = %x, want %x", options[dhcpOptionVIVendorInfo], want)
+	}
+}
@@ -1,9 +1,7 @@
-package main
+package netcore
 
 import (
-	"errors"
-	"flag"
-	"log"
+	"fmt"
 	"net"
 	"regexp"
 	"strconv"
@@ -15,27 +13,56 @@ import (
 )
 
 var (
-	dnslisten = flag.String("dnslisten", "0.0.0.0:53", "Listen address for DNS")
+	dnslisten          = Flags.String("dnslisten", "0.0.0.0:53", "Listen address for DNS")
+	dnsCacheBufferSize = Flags.Int("dnscachebuffer", 512, "Number of DNS lookups the cache queues before callers wait.")
 )
 
 type DNSDB interface {
-	InitDNS()
 	GetDNS(name string, rtype string) (*DNSEntry, error)
 	HasDNS(name string, rtype string) (bool, error)
+	// HasDNSName reports whether name has records of any type, or names
+	// below it that do, as an empty non-terminal
+	HasDNSName(name string) (bool, error)
 	RegisterA(fqdn string, ip net.IP, exclusive bool, ttl uint32, expiration uint64) error
+	// RegisterAddress points fqdn at ip, IPv4 or IPv6, and ip back at fqdn
+	// if ptr is set, until expiration, leaving static records alone
+	RegisterAddress(actor, fqdn string, ip net.IP, ptr bool, expiration uint64) error
+	SetDNSMeta(name string, rrType string, meta map[string]string) error
+	ListDNSZone(zone string) ([]DNSRecord, error)
+	ApplyDNSChanges(actor string, changes []DNSChange) error
+	// SweepExpiredDNS removes the values that expired before now and the
+	// entries they leave empty, and returns how many of each it removed
+	SweepExpiredDNS(now time.Time) (values, entries int, err error)
+	// SweepOrphanedDDNS removes the A and PTR values that DHCP registered
+	// for addresses that held reports as free, and returns them
+	SweepOrphanedDDNS(held func(ip net.IP) bool) ([]DNSRecord, error)
+	// RegisterService stores the records of a service registration, or
+	// renews them, and reports whether they are new
+	RegisterService(actor string, s ServiceRegistration) (bool, error)
+	DeregisterService(actor string, s ServiceRegistration) error
+	// ListDNSServices returns the SRV records under domain, static and
+	// registered, grouped by name
+	ListDNSServices(domain string) ([]ServiceGroup, error)
 }
 
+// DNSEntry is an RRset: the values of a name and type, in order, with the
+// TTL, metadata and labels they share. It is stored as is, see dnsrrset.go.
 type DNSEntry struct {
-	TTL    uint32
-	Values []DNSValue
-	Meta   map[string]string
+	TTL    uint32            `json:"ttl,omitempty"`
+	Values []DNSValue        `json:"values,omitempty"`
+	Meta   map[string]string `json:"meta,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
+// DNSValue is a value of an RRset, with its own attributes and expiration.
+// ID tells it apart from the other values of its RRset, and TTL is what is
+// left of its lifetime when it was read.
 type DNSValue struct {
-	Expiration *time.Time
-	TTL        uint32
-	Value      string
-	Attr       map[string]string
+	ID         string            `json:"id"`
+	Expiration *time.Time        `json:"expires,omitempty"`
+	TTL        uint32            `json:"-"`
+	Value      string            `json:"value,omitempty"`
+	Attr       map[string]string `json:"attr,omitempty"`
 }
 
 type dnsEntryResult struct {
@@ -44,127 +71,301 @@ type dnsEntryResult struct {
 	RType uint16
 }
 
-var (
-	ErrNotFound = errors.New("not found")
-)
+// NewDNSHandler answers DNS queries as cfg's DNS chain configures it, from
+// cfg's database
+func NewDNSHandler(cfg *Config) (dns.Handler, error) {
+	chain, err := buildDNSChain(cfg, cfg.DNSChain())
+	if err != nil {
+		return nil, err
+	}
+	cfg.Lock()
+	cfg.dnsHandler = chain
+	cfg.Unlock()
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(w dns.ResponseWriter, req *dns.Msg) { dnsQueryServe(cfg, chain, w, req) })
+	return mux, nil
+}
 
-const (
-	dnsCacheBufferSize = 512
-)
+// dnsService answers DNS queries over UDP and TCP
+type dnsService struct {
+	serviceStatus
+	cfg     *Config
+	addr    string
+	handler dns.Handler
+	servers []*dns.Server
+	batch   *dnsBatchServer // serving UDP instead of a dns.Server, where it can
+}
 
-func dnsSetup(cfg *Config) chan error {
-	log.Println("DNSSETUP")
+// NewDNSService serves DNS on addr, over UDP and TCP, as cfg configures it
+func NewDNSService(cfg *Config, addr string) Service {
+	return &dnsService{cfg: cfg, addr: addr}
+}
 
-	// FIXME: Make the default TTL into a configuration parameter
-	// FIXME: Check whether this default is being applied to unanswered queries
-	defaultTTL := uint32(10800) // this is the default TTL = 3 hours
+func (s *dnsService) Name() string { return "dns" }
 
-	cache := dnscache.New(dnsCacheBufferSize, cfg.DNSCacheMaxTTL(), cfg.DNSCacheMissingTTL(), func(c dnscache.Context, q dns.Question) []dns.RR {
-		return answerQuestion(cfg, c, &q, defaultTTL, 0)
-	})
+func (s *dnsService) Start() (<-chan error, error) {
+	logger.Println("DNSSETUP")
+	if s.handler == nil {
+		handler, err := NewDNSHandler(s.cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.handler = handler
+	}
 
-	dns.HandleFunc(".", func(w dns.ResponseWriter, req *dns.Msg) { dnsQueryServe(cfg, cache, w, req) })
-	cfg.db.InitDNS()
-	exit := make(chan error, 1)
+	// The sockets may be taken over from a previous process, see handover.go
+	l, err := listeners.Listen("dns/tcp", "tcp", s.addr) // TODO: should use cfg to define the listening ip/port
+	if err != nil {
+		return nil, err
+	}
+	conn, err := listeners.ListenPacket("dns/udp", "udp", s.addr)
+	if err != nil {
+		listeners.release("dns/tcp")
+		return nil, err
+	}
+	s.servers = []*dns.Server{{Listener: l, Handler: s.handler}}
+	if s.batch, err = newDNSBatchServer(conn, s.handler, *dnsBatch); err != nil {
+		if *dnsBatch > 1 {
+			logger.Printf("DNS reads UDP messages one at a time: %s\n", err)
+		}
+		s.servers = append(s.servers, &dns.Server{PacketConn: conn, Handler: s.handler})
+	}
+	exit := make(chan error, len(s.servers)+1)
+	for _, server := range s.servers {
+		go func(server *dns.Server) {
+			exit <- server.ActivateAndServe()
+		}(server)
+	}
+	if s.batch != nil {
+		go func() {
+			exit <- s.batch.Serve()
+		}()
+	}
+	return s.serving(exit, s.close), nil
+}
 
-	go func() {
-		exit <- dns.ListenAndServe(*dnslisten, "tcp", nil) // TODO: should use cfg to define the listening ip/port
-	}()
-	go func() {
-		exit <- dns.ListenAndServe(*dnslisten, "udp", nil) // TODO: should use cfg to define the listening ip/port
-	}()
+// Ready is nil once the UDP listener answers queries, so that DHCP does not
+// register leases before names can be looked up
+func (s *dnsService) Ready() error {
+	return probeDNS(s.cfg, s.addr, time.Second)
+}
 
-	return exit
+func (s *dnsService) Stop(deadline time.Time) error {
+	s.stop()
+	s.close()
+	waitInFlight(deadline)
+	return nil
 }
 
-func dnsQueryServe(cfg *Config, cache *dnscache.Cache, w dns.ResponseWriter, req *dns.Msg) {
+// close stops both servers and lets go of their sockets
+func (s *dnsService) close() {
+	for _, server := range s.servers {
+		server.Shutdown()
+	}
+	if s.batch != nil {
+		s.batch.Shutdown()
+	}
+	listeners.release("dns/tcp")
+	listeners.release("dns/udp")
+}
+
+func dnsQueryServe(cfg *Config, chain DNSHandler, w dns.ResponseWriter, req *dns.Msg) {
 	start := time.Now()
 
 	if req.MsgHdr.Response == true { // supposed responses sent to us are bogus
-		q := req.Question[0]
-		log.Printf("DNS Query IS BOGUS %s %s from %s.\n", q.Name, dns.Type(q.Qtype).String(), w.RemoteAddr())
+		if len(req.Question) > 0 {
+			q := req.Question[0]
+			logger.Printf("DNS Query IS BOGUS %s %s from %s.\n", q.Name, dns.Type(q.Qtype).String(), queryLogClient(w.RemoteAddr()))
+		}
 		return
 	}
 
-	// TODO: handle AXFR/IXFR (full and incremental) *someday* for use by non-netcore slaves
-	//       ... also if we do that, also handle sending NOTIFY to listed slaves attached to the SOA record
+	if req.Opcode == dns.OpcodeNotify {
+		dnsNotifyServe(w, req)
+		return
+	}
+	if len(req.Question) == 1 && (req.Question[0].Qtype == dns.TypeAXFR || req.Question[0].Qtype == dns.TypeIXFR) {
+		dnsTransferServe(cfg, w, req)
+		return
+	}
+
+	defer drain.Begin()()
+	if drainRefused(w, req) {
+		return
+	}
+	if req = dnsQuestionPolicy(w, req); req == nil {
+		return
+	}
+
+	span := startTrace("dns.query", spanServer)
+	span.SetAttr("net.peer", w.RemoteAddr().String())
+	defer span.End()
 
-	// Process questions in parallel
-	pending := make([]chan []dns.RR, 0, len(req.Question)) // Slice of answer channels
+	logged := queryLogged(req)
+	dnssecOK := false
+	if opt := req.IsEdns0(); opt != nil {
+		dnssecOK = opt.Do()
+	}
+	subnet := clientSubnet(req)
+
+	// Answer the questions in turn, on this goroutine: with UDP batches,
+	// it is a worker of the shard of the first question's name, which
+	// keeps that question with the cache shard it uses
+	results := make([][]dns.RR, 0, len(req.Question))
+	requests := make([]*DNSRequest, 0, len(req.Question))
+	qtypes := make([]string, 0, len(req.Question))
 	for i := range req.Question {
 		q := &req.Question[i]
-		log.Printf("DNS Query [%d/%d] %s %s from %s\n", i+1, len(req.Question), q.Name, dns.Type(q.Qtype).String(), w.RemoteAddr())
-		pending = append(pending, serveQuestion(cfg, cache, q, start))
+		q.Name = dnsQuestionName(q.Name)
+		qtypes = append(qtypes, dns.Type(q.Qtype).String())
+		if logged {
+			logger.Printf("DNS Query [%d/%d] %s %s from %s\n", i+1, len(req.Question), q.Name, dns.Type(q.Qtype).String(), queryLogClient(w.RemoteAddr()))
+		}
+		r := &DNSRequest{
+			Config:   cfg,
+			Question: q,
+			Start:    start,
+			Event:    dnscache.Lookup,
+			Client:   addrIP(w.RemoteAddr()),
+			DNSSEC:   dnssecOK,
+			Subnet:   subnet,
+		}
+		requests = append(requests, r)
+		results = append(results, serveQuestion(chain, r, span))
 	}
 
-	// Assemble answers according to the order of the questions
-	var answers []dns.RR
-	for _, ch := range pending {
-		answers = append(answers, <-ch...)
+	// Assemble answers according to the order of the questions, each with
+	// its own outcome, see combinedRcode
+	var answers, ns, extra []dns.RR
+	rcodes := make([]int, 0, len(results))
+	for i, result := range results {
+		a, n, e := splitReferral(&req.Question[i], result)
+		answers = append(answers, a...)
+		ns = append(ns, n...)
+		extra = append(extra, e...)
+		rcodes = append(rcodes, questionRcode(requests[i], a, n))
+		if logged && len(results) > 1 {
+			logger.Printf("  [%9.04fms] RCODE   [%d/%d] %s\n", msElapsed(start, time.Now()), i+1, len(results), dns.RcodeToString[rcodes[i]])
+		}
 	}
 
-	for _, answer := range answers {
-		log.Printf("  [%9.04fms] ANSWER  %s\n", msElapsed(start, time.Now()), answer.String())
+	if logged {
+		for _, answer := range answers {
+			logger.Printf("  [%9.04fms] ANSWER  %s\n", msElapsed(start, time.Now()), answer.String())
+		}
+		for _, referral := range ns {
+			logger.Printf("  [%9.04fms] REFER   %s\n", msElapsed(start, time.Now()), referral.String())
+		}
 	}
 
-	if len(answers) > 0 {
-		//log.Printf("OUR DATA: [%+v]\n", answerMsg)
+	if len(answers) > 0 || len(ns) > 0 {
+		//logger.Printf("OUR DATA: [%+v]\n", answerMsg)
 		answerMsg := prepareAnswerMsg(req, answers)
+		if len(ns) > 0 {
+			// Referrals are not authoritative for the delegated names,
+			// unlike the records proving that there are no answers
+			answerMsg.Authoritative = len(answers) > 0 || !hasDelegation(ns)
+			answerMsg.Ns = ns
+			answerMsg.Extra = extra
+		}
+		// Proofs that there are no answers may come with NXDOMAIN
+		answerMsg.Rcode = combinedRcode(rcodes)
+		if cfg.DNSMinimalResponses() {
+			minimizeResponse(answerMsg, dnssecOK)
+		}
+		if dnssecOK {
+			answerMsg.SetEdns0(4096, true)
+		}
+		if block := cfg.DNSPadding(); block > 0 && wantsPadding(req) {
+			padResponse(answerMsg, block, dnssecOK)
+		}
+		clientStats.Record(w.RemoteAddr(), qtypes, false)
+		zoneStats.Record(req.Question, answerMsg.Rcode, time.Since(start))
+		anomalies.Observe(w.RemoteAddr(), req.Question, false)
+		if logged {
+			queryLog.Record(w.RemoteAddr(), req, answerMsg.Rcode, len(answers), start)
+		}
+		span.SetAttr("dns.rcode", dns.RcodeToString[answerMsg.Rcode])
 		w.WriteMsg(answerMsg)
 		return
 	}
 
-	//log.Printf("NO DATA: [%+v]\n", answerMsg)
+	//logger.Printf("NO DATA: [%+v]\n", answerMsg)
 
 	failMsg := prepareFailureMsg(req)
+	failMsg.Rcode = combinedRcode(rcodes)
+	for _, r := range requests {
+		if r.Rcode != dns.RcodeSuccess {
+			failMsg.Authoritative = false
+			break
+		}
+	}
+	if dnssecOK {
+		failMsg.SetEdns0(4096, true)
+	}
+	if block := cfg.DNSPadding(); block > 0 && wantsPadding(req) {
+		padResponse(failMsg, block, dnssecOK)
+	}
+	clientStats.Record(w.RemoteAddr(), qtypes, failMsg.Rcode == dns.RcodeNameError)
+	zoneStats.Record(req.Question, failMsg.Rcode, time.Since(start))
+	anomalies.Observe(w.RemoteAddr(), req.Question, failMsg.Rcode == dns.RcodeNameError)
+	if logged {
+		queryLog.Record(w.RemoteAddr(), req, failMsg.Rcode, 0, start)
+	}
+	span.SetAttr("dns.rcode", dns.RcodeToString[failMsg.Rcode])
 	w.WriteMsg(failMsg)
 }
 
-func serveQuestion(cfg *Config, cache *dnscache.Cache, q *dns.Question, start time.Time) chan []dns.RR {
-	output := make(chan []dns.RR)
-	var answers []dns.RR
-
-	// is this a WOL query?
-	if isWOLTrigger(q) {
-		answer := processWOL(cfg, q)
-		answers = append(answers, answer)
-	}
-
-	rc := make(chan []dns.RR)
-
-	cache.Lookup(dnscache.Request{
-		Question:     *q,
-		Start:        start,
-		ResponseChan: rc,
-	})
-
-	go func() {
-		answers = append(answers, <-rc...)
-		output <- answers
-	}()
+// serveQuestion passes r through the chain, traced as a child of span
+func serveQuestion(chain DNSHandler, r *DNSRequest, span *Span) []dns.RR {
+	span = span.Child("dns.question", spanInternal)
+	span.SetAttr("dns.question.name", r.Question.Name)
+	span.SetAttr("dns.question.type", dns.Type(r.Question.Qtype).String())
+	r.Span = span
+	answers := chain.ServeDNSQuestion(r)
+	span.SetAttr("dns.answers", strconv.Itoa(len(answers)))
+	span.End()
+	return answers
+}
 
-	return output
+// dnsAuthoritativeHandler answers questions from the DNS database
+type dnsAuthoritativeHandler struct {
+	next       DNSHandler
+	defaultTTL uint32
 }
 
-func answerQuestion(cfg *Config, c dnscache.Context, q *dns.Question, defaultTTL, qDepth uint32) []dns.RR {
-	if c.Event == dnscache.Renewal && qDepth == 0 {
-		log.Printf("DNS Renewal     %s %s\n", q.Name, dns.Type(q.Qtype).String())
+// ServeDNSQuestion answers the question from the DNS database, following
+// aliases, and defers to the next handler for names we are not
+// authoritative for
+func (h *dnsAuthoritativeHandler) ServeDNSQuestion(r *DNSRequest) []dns.RR {
+	cfg, q := r.Config, r.Question
+	if r.Event == dnscache.Renewal && r.Depth == 0 {
+		logger.Printf("DNS Renewal     %s %s\n", q.Name, dns.Type(q.Qtype).String())
 	} else {
-		log.Printf("  [%9.04fms] %-7s %s %s\n", msElapsed(c.Start, time.Now()), strings.ToUpper(c.Event.String()), q.Name, dns.Type(q.Qtype).String())
+		logger.Printf("  [%9.04fms] %-7s %s %s\n", msElapsed(r.Start, time.Now()), strings.ToUpper(r.Event.String()), q.Name, dns.Type(q.Qtype).String())
 	}
-	answerTTL := defaultTTL
+	answerTTL := h.defaultTTL
 	var answers []dns.RR
 	var secondaryAnswers []dns.RR
+	var aliasAnswers []dns.RR
 	var wouldLikeForwarder = true
 
-	entry, rrType, err := fetchBestEntry(cfg, q)
+	entry, rrType, err := fetchBestEntry(cfg, r.Span, q)
+	if err != nil && ErrorKind(err) != ErrNotFound {
+		// The name may well be ours, so it must not be forwarded
+		logger.Printf("  [%9.04fms] FAILED  %s %s: %s\n", msElapsed(r.Start, time.Now()), q.Name, dns.Type(q.Qtype).String(), err)
+		r.Span.SetError(err)
+		r.Trace.Note("looking up %s failed, so it goes unanswered: %s", q.Name, err)
+		return nil
+	}
 
 	if err == nil {
 		wouldLikeForwarder = false
 		if entry.TTL > 0 {
 			answerTTL = entry.TTL
 		}
-		log.Printf("  [%9.04fms] FOUND   %s %s\n", msElapsed(c.Start, time.Now()), q.Name, dns.Type(rrType).String())
+		logger.Printf("  [%9.04fms] FOUND   %s %s\n", msElapsed(r.Start, time.Now()), q.Name, dnsTypeString(rrType))
+		r.Trace.Note("found %s %s in the database", q.Name, dnsTypeString(rrType))
 
 		switch q.Qtype {
 		case dns.TypeSOA:
@@ -178,61 +379,48 @@ func answerQuestion(cfg *Config, c dnscache.Context, q *dns.Question, defaultTTL
 					expiration := value.Expiration.Unix()
 					now := time.Now().Unix()
 					if expiration < now {
-						//log.Printf("[Lookup [%s] [%s] (is expired)]\n", q.Name, qType)
+						//logger.Printf("[Lookup [%s] [%s] (is expired)]\n", q.Name, qType)
 						continue
 					}
 					remaining := uint32(expiration - now)
 					if remaining < answerTTL {
 						answerTTL = remaining
-						log.Printf("  [%9.04fms] EXPIRES %d\n", msElapsed(c.Start, time.Now()), remaining)
+						logger.Printf("  [%9.04fms] EXPIRES %d\n", msElapsed(r.Start, time.Now()), remaining)
 					}
 				}
 				if value.TTL > 0 && value.TTL < answerTTL {
 					answerTTL = value.TTL
 				}
+				if !dnsValueActive(value, time.Now(), &answerTTL) {
+					r.Trace.Note("skipped %s, outside its schedule", value.Value)
+					continue
+				}
+				if err := validateDNSValue(rrType, value); err != nil {
+					logger.Printf("  [%9.04fms] INVALID %s %s %s\n", msElapsed(r.Start, time.Now()), q.Name, dnsTypeString(rrType), err)
+					continue
+				}
+				var answer dns.RR
 				switch rrType {
-				// FIXME: Add more RR types!
-				//        http://godoc.org/github.com/miekg/dns has info as well as
-				//        http://en.wikipedia.org/wiki/List_of_DNS_record_types
-				case dns.TypeTXT:
-					answer := answerTXT(q, value)
-					answers = append(answers, answer)
-				case dns.TypeA:
-					answer := answerA(q, value)
-					answers = append(answers, answer)
-				case dns.TypeAAAA:
-					answer := answerAAAA(q, value)
-					answers = append(answers, answer)
-				case dns.TypeNS:
-					answer := answerNS(q, value)
-					answers = append(answers, answer)
 				case dns.TypeCNAME:
-					answer, target := answerCNAME(q, value)
-					answers = append(answers, answer)
-					q2 := q
+					var target string
+					answer, target = answerCNAME(q, value)
+					r.Trace.Note("following the CNAME to %s", target)
+					q2 := *q
 					q2.Name = target // replace question's name with new name
-					secondaryAnswers = append(secondaryAnswers, answerQuestion(cfg, c, q2, defaultTTL, qDepth+1)...)
+					r2 := *r
+					r2.Question = &q2
+					r2.Depth++
+					secondaryAnswers = append(secondaryAnswers, h.ServeDNSQuestion(&r2)...)
 				case dns.TypeDNAME:
-					answer := answerDNAME(q, value)
-					answers = append(answers, answer)
+					answer = answerDNAME(q, value)
 					wouldLikeForwarder = true
-				case dns.TypePTR:
-					answer := answerPTR(q, value)
-					answers = append(answers, answer)
-				case dns.TypeMX:
-					answer := answerMX(q, value)
-					// FIXME: are we supposed to be returning these in prio ordering?
-					//        ... or maybe it does that for us?  or maybe it's the enduser's problem?
-					answers = append(answers, answer)
-				case dns.TypeSRV:
-					answer := answerSRV(q, value)
-					// FIXME: are we supposed to be returning these rando-weighted and in priority ordering?
-					//        ... or maybe it does that for us?  or maybe it's the enduser's problem?
+				case dnsTypeALIAS:
+					aliasAnswers = append(aliasAnswers, h.aliasAnswers(r, dns.Fqdn(value.Value))...)
+				default:
+					answer = answerValue(q, rrType, value)
+				}
+				if answer != nil {
 					answers = append(answers, answer)
-				case dns.TypeSSHFP:
-					// TODO: implement SSHFP
-					//       http://godoc.org/github.com/miekg/dns#SSHFP
-					//       NOTE: we must implement DNSSEC before using this RR type
 				}
 			}
 		}
@@ -240,18 +428,53 @@ func answerQuestion(cfg *Config, c dnscache.Context, q *dns.Question, defaultTTL
 
 	for _, answer := range answers {
 		answer.Header().Ttl = answerTTL // FIXME: I think this might be inappropriate
-		//log.Printf("[APPLIED TTL [%s] [%s] %d]\n", q.Name, dns.Type(q.Qtype).String(), answerTTL)
+		//logger.Printf("[APPLIED TTL [%s] [%s] %d]\n", q.Name, dns.Type(q.Qtype).String(), answerTTL)
 	}
 
 	// Append the results of secondary queries, such as the results of CNAME and DNAME records
 	answers = append(answers, secondaryAnswers...)
 
+	// Addresses of ALIAS targets are the alias's own, cached no longer than either
+	for _, answer := range aliasAnswers {
+		if answer.Header().Ttl > answerTTL {
+			answer.Header().Ttl = answerTTL
+		}
+	}
+	answers = append(answers, aliasAnswers...)
+
+	// Names at or below a delegation point get a referral to the child zone's name servers
+	if len(answers) == 0 {
+		span := r.Span.Child("db.delegation", spanInternal)
+		referral := h.referral(r)
+		span.End()
+		if len(referral) > 0 {
+			logger.Printf("  [%9.04fms] REFER   %s %s\n", msElapsed(r.Start, time.Now()), q.Name, referral[0].Header().Name)
+			r.Trace.Note("%s is delegated: referral to the name servers of %s", q.Name, referral[0].Header().Name)
+			return referral
+		}
+	}
+
 	// check to see if we host this zone; if yes, don't allow use of ext forwarders
 	// ... also, check to see if we hit a DNAME so we can handle that aliasing
-	// FIXME: Only forward if we are configured as a forwarder
-	if wouldLikeForwarder && !haveAuthority(cfg, q) {
-		log.Printf("  [%9.04fms] FORWARD %s %s\n", msElapsed(c.Start, time.Now()), q.Name, dns.Type(q.Qtype).String())
-		answers = append(answers, forwardQuestion(q, cfg.DNSForwarders())...)
+	// ... forwarding only happens if the forwarder middleware follows us in the chain
+	if wouldLikeForwarder {
+		span := r.Span.Child("db.authority", spanInternal)
+		authority := haveAuthority(cfg, q)
+		span.End()
+		if !authority {
+			r.Trace.Note("not authoritative for %s, so it is passed on", q.Name)
+			answers = append(answers, h.next.ServeDNSQuestion(r)...)
+		} else if len(answers) == 0 {
+			span := r.Span.Child("db.nodata", spanInternal)
+			soa := noDataSOA(cfg, q)
+			span.End()
+			if soa != nil {
+				r.Trace.Note("authoritative for %s, which exists but has no such record", q.Name)
+				answers = append(answers, soa)
+			} else {
+				r.Trace.Note("authoritative for %s, which has no such record", q.Name)
+			}
+		}
 	}
 
 	return answers
@@ -266,29 +489,39 @@ func msElapsed(start, now time.Time) float64 {
 }
 
 // fetchBestEntry will return the most suitable entry from the DNS database for
-// the given query. If no suitable entry is found it will return ErrNotFound.
-func fetchBestEntry(cfg *Config, q *dns.Question) (entry *DNSEntry, rrType uint16, err error) {
-	err = ErrNotFound
-	for _, result := range fetchRelatedEntries(cfg, q) {
+// the given query, passing over entries whose values are all outside their
+// scheduled windows. If no suitable entry is found it will return ErrNotFound,
+// unless a lookup failed for another reason, whose error it returns instead.
+func fetchBestEntry(cfg *Config, span *Span, q *dns.Question) (entry *DNSEntry, rrType uint16, err error) {
+	var failed error
+	for _, result := range fetchRelatedEntries(cfg, span, q) {
 		data := <-result
-		entry, rrType, err = data.Entry, data.RType, data.Err
-		if err == nil {
-			return
+		if data.Err == nil && dnsEntryActive(data.Entry, time.Now()) {
+			return data.Entry, data.RType, nil
+		}
+		if ErrorKind(data.Err) != ErrNotFound && failed == nil {
+			failed = data.Err
 		}
-		// FIXME: Test for missing entries specifically, not just any error
 	}
-	return
+	if failed != nil {
+		return nil, 0, failed
+	}
+	return nil, 0, ErrNotFound
 }
 
 // fetchRelatedEntries issues parallel queries to the DNS database for all
 // records possibly needed to answer the given question, and returns a slice of
 // channels from which to retrieve answers in prioritized order.
-func fetchRelatedEntries(cfg *Config, q *dns.Question) []chan dnsEntryResult {
+func fetchRelatedEntries(cfg *Config, span *Span, q *dns.Question) []chan dnsEntryResult {
 	// Issue the CNAME and RR queries simultaneously
 	entries := make([]chan dnsEntryResult, 0, 2)
-	entries = append(entries, fetchEntry(cfg, q, dns.TypeCNAME))
+	entries = append(entries, fetchEntry(cfg, span, q, dns.TypeCNAME))
 	if q.Qtype != dns.TypeCNAME {
-		entries = append(entries, fetchEntry(cfg, q, q.Qtype))
+		entries = append(entries, fetchEntry(cfg, span, q, q.Qtype))
+	}
+	if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
+		// An ALIAS answers for the addresses a name has no records of
+		entries = append(entries, fetchEntry(cfg, span, q, dnsTypeALIAS))
 	}
 	if q.Qtype != dns.TypeDNAME {
 		// TODO: Check for DNAME entries for the given name and for each parent for
@@ -298,10 +531,16 @@ func fetchRelatedEntries(cfg *Config, q *dns.Question) []chan dnsEntryResult {
 	return entries
 }
 
-func fetchEntry(cfg *Config, q *dns.Question, rrType uint16) chan dnsEntryResult {
+func fetchEntry(cfg *Config, span *Span, q *dns.Question, rrType uint16) chan dnsEntryResult {
 	out := make(chan dnsEntryResult)
 	go func() {
-		entry, err := cfg.db.GetDNS(q.Name, dns.Type(rrType).String())
+		span := span.Child("db.GetDNS", spanClient)
+		span.SetAttr("dns.record.type", dnsTypeString(rrType))
+		entry, err := cfg.db.GetDNS(q.Name, dnsTypeString(rrType))
+		if ErrorKind(err) != ErrNotFound {
+			span.SetError(err)
+		}
+		span.End()
 		out <- dnsEntryResult{
 			Entry: entry,
 			RType: rrType,
@@ -323,6 +562,17 @@ func prepareAnswerMsg(req *dns.Msg, answers []dns.RR) *dns.Msg {
 	return answerMsg
 }
 
+// addrIP returns the IP address of a DNS client, or nil
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
 func prepareFailureMsg(req *dns.Msg) *dns.Msg {
 	failMsg := new(dns.Msg)
 	failMsg.Id = req.Id
@@ -345,7 +595,7 @@ func getWOLHostname(q *dns.Question) string {
 
 func processWOL(cfg *Config, q *dns.Question) dns.RR {
 	hostname := getWOLHostname(q)
-	log.Printf("WoL requested for %s", hostname)
+	logger.Printf("WoL requested for %s", hostname)
 	err := wakeByHostname(cfg, hostname)
 	status := "OKAY"
 	if err != nil {
@@ -359,6 +609,96 @@ func processWOL(cfg *Config, q *dns.Question) dns.RR {
 	return answer
 }
 
+// nextSOASerial returns the serial that should follow old, in the
+// conventional YYYYMMDDnn format. Serials never go backwards, so once more
+// than 99 changes are made in a day the serial simply keeps counting.
+func nextSOASerial(old uint32, now time.Time) uint32 {
+	year, month, day := now.UTC().Date()
+	today := uint32(year*1000000 + int(month)*10000 + day*100)
+	if old < today {
+		return today
+	}
+	return old + 1
+}
+
+// validateDNSValue returns an error if the given value cannot be used to
+// build a well-formed resource record of the given type
+func validateDNSValue(rrType uint16, v *DNSValue) error {
+	switch rrType {
+	case dns.TypeA:
+		if ip := net.ParseIP(v.Value); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid A value %q: not an IPv4 address", v.Value)
+		}
+	case dns.TypeAAAA:
+		if ip := net.ParseIP(v.Value); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid AAAA value %q: not an IPv6 address", v.Value)
+		}
+	case dns.TypeNS, dns.TypeCNAME, dns.TypeDNAME, dns.TypePTR, dnsTypeALIAS:
+		if !validDomainName(v.Value) {
+			return fmt.Errorf("invalid %s value %q: not a domain name", dnsTypeString(rrType), v.Value)
+		}
+	case dns.TypeTXT:
+		if len(v.Value) > 255 {
+			return fmt.Errorf("invalid TXT value: %d bytes exceeds the 255 byte limit", len(v.Value))
+		}
+	case dns.TypeMX:
+		target, ok := v.Attr["target"]
+		if !ok {
+			target = v.Value
+		}
+		if !validDomainName(target) {
+			return fmt.Errorf("invalid MX target %q: not a domain name", target)
+		}
+		if err := validateUint16Attr(v, "priority"); err != nil {
+			return err
+		}
+	case dns.TypeSRV:
+		target, ok := v.Attr["target"]
+		if !ok {
+			targetParts := strings.Split(v.Value, ":")
+			target = targetParts[0]
+			if len(targetParts) > 1 {
+				if _, err := strconv.ParseUint(targetParts[1], 10, 16); err != nil {
+					return fmt.Errorf("invalid SRV port %q", targetParts[1])
+				}
+			}
+		}
+		if !validDomainName(target) {
+			return fmt.Errorf("invalid SRV target %q: not a domain name", target)
+		}
+		for _, attr := range []string{"priority", "weight", "port"} {
+			if err := validateUint16Attr(v, attr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateUint16Attr returns an error if the named attribute is present but
+// is not a valid 16-bit unsigned integer
+func validateUint16Attr(v *DNSValue, attr string) error {
+	value, ok := v.Attr[attr]
+	if !ok {
+		return nil
+	}
+	if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+		return fmt.Errorf("invalid %s %q: must be between 0 and 65535", attr, value)
+	}
+	return nil
+}
+
+// validDomainName returns true if name is a syntactically valid domain name,
+// in ASCII: internationalized names must be converted with idnaToASCII first
+func validDomainName(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || !isASCII(name) {
+		return false
+	}
+	_, ok := dns.IsDomainName(name)
+	return ok
+}
+
 func answerSOA(q *dns.Question, e *DNSEntry) dns.RR {
 	answer := new(dns.SOA)
 	answer.Header().Name = q.Name
@@ -366,14 +706,112 @@ func answerSOA(q *dns.Question, e *DNSEntry) dns.RR {
 	answer.Header().Class = dns.ClassINET
 	answer.Ns = strings.TrimSuffix(e.Meta["ns"], ".") + "."
 	answer.Mbox = strings.TrimSuffix(e.Meta["mbox"], ".") + "."
-	answer.Serial = uint32(time.Now().Unix())
-	answer.Refresh = uint32(60) // only used for master->slave timing
-	answer.Retry = uint32(60)   // only used for master->slave timing
-	answer.Expire = uint32(60)  // only used for master->slave timing
-	answer.Minttl = uint32(60)  // how long caching resolvers should cache a miss (NXDOMAIN status)
+	answer.Serial = 1 // zones that have never changed
+	if serial, err := strconv.ParseUint(e.Meta["serial"], 10, 32); err == nil {
+		answer.Serial = uint32(serial)
+	}
+	answer.Refresh = soaTimer(e, "refresh") // only used for master->slave timing
+	answer.Retry = soaTimer(e, "retry")     // only used for master->slave timing
+	answer.Expire = soaTimer(e, "expire")   // only used for master->slave timing
+	answer.Minttl = soaTimer(e, "minttl")   // how long caching resolvers should cache a miss (NXDOMAIN status)
 	return answer
 }
 
+// negativeSOA is the SOA of zone that comes with answers denying a name or
+// a type, whose TTL tells resolvers how long to remember it (RFC 2308)
+func negativeSOA(zone string, e *DNSEntry) *dns.SOA {
+	soa := answerSOA(&dns.Question{Name: dns.Fqdn(zone), Qtype: dns.TypeSOA, Qclass: dns.ClassINET}, e).(*dns.SOA)
+	soa.Hdr.Ttl = soa.Minttl
+	if e.TTL > 0 && e.TTL < soa.Minttl {
+		soa.Hdr.Ttl = e.TTL
+	}
+	return soa
+}
+
+// soaTimerDefaults are used for any SOA timer that a zone does not set in its
+// metadata
+var soaTimerDefaults = map[string]uint32{
+	"refresh": 3600,    // 1 hour
+	"retry":   900,     // 15 minutes
+	"expire":  1209600, // 2 weeks
+	"minttl":  300,     // 5 minutes
+}
+
+// soaTimer returns the named SOA timer from the zone's metadata, or its
+// default value if the zone does not set it
+func soaTimer(e *DNSEntry, name string) uint32 {
+	if value, err := strconv.ParseUint(e.Meta[name], 10, 32); err == nil && value > 0 {
+		return uint32(value)
+	}
+	return soaTimerDefaults[name]
+}
+
+// validateSOAMeta returns an error if meta contains anything other than valid
+// SOA name server, mailbox and timer settings
+func validateSOAMeta(meta map[string]string) error {
+	timers := make(map[string]uint32)
+	for name := range soaTimerDefaults {
+		timers[name] = soaTimerDefaults[name]
+	}
+	for key, value := range meta {
+		switch key {
+		case "ns", "mbox":
+			if !validDomainName(value) {
+				return fmt.Errorf("invalid SOA %s %q: not a domain name", key, value)
+			}
+		case "secondaries":
+			if _, err := parseSOASecondaries(value); err != nil {
+				return fmt.Errorf("invalid SOA secondaries %q: %s", value, err)
+			}
+		case "refresh", "retry", "expire", "minttl":
+			timer, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || timer == 0 {
+				return fmt.Errorf("invalid SOA %s %q: must be a positive number of seconds", key, value)
+			}
+			timers[key] = uint32(timer)
+		default:
+			return fmt.Errorf("unknown SOA setting %q", key)
+		}
+	}
+	if timers["expire"] < timers["refresh"]+timers["retry"] {
+		return fmt.Errorf("SOA expire (%d) must be at least refresh (%d) plus retry (%d)", timers["expire"], timers["refresh"], timers["retry"])
+	}
+	return nil
+}
+
+// answerValue answers q with a value of the other types, or returns nil for
+// types not supported
+func answerValue(q *dns.Question, rrType uint16, value *DNSValue) dns.RR {
+	switch rrType {
+	// FIXME: Add more RR types!
+	//        http://godoc.org/github.com/miekg/dns has info as well as
+	//        http://en.wikipedia.org/wiki/List_of_DNS_record_types
+	case dns.TypeTXT:
+		return answerTXT(q, value)
+	case dns.TypeA:
+		return answerA(q, value)
+	case dns.TypeAAAA:
+		return answerAAAA(q, value)
+	case dns.TypeNS:
+		return answerNS(q, value)
+	case dns.TypePTR:
+		return answerPTR(q, value)
+	case dns.TypeMX:
+		// FIXME: are we supposed to be returning these in prio ordering?
+		//        ... or maybe it does that for us?  or maybe it's the enduser's problem?
+		return answerMX(q, value)
+	case dns.TypeSRV:
+		// FIXME: are we supposed to be returning these rando-weighted and in priority ordering?
+		//        ... or maybe it does that for us?  or maybe it's the enduser's problem?
+		return answerSRV(q, value)
+	case dns.TypeSSHFP:
+		// TODO: implement SSHFP
+		//       http://godoc.org/github.com/miekg/dns#SSHFP
+		//       NOTE: we must implement DNSSEC before using this RR type
+	}
+	return nil
+}
+
 func answerTXT(q *dns.Question, v *DNSValue) dns.RR {
 	answer := new(dns.TXT)
 	answer.Header().Name = q.Name
@@ -388,7 +826,10 @@ func answerA(q *dns.Question, v *DNSValue) dns.RR {
 	answer.Header().Name = q.Name
 	answer.Header().Rrtype = dns.TypeA
 	answer.Header().Class = dns.ClassINET
-	answer.A = net.ParseIP(v.Value)
+	answer.A = net.ParseIP(v.Value).To4()
+	if answer.A == nil {
+		return nil
+	}
 	return answer
 }
 
@@ -398,6 +839,9 @@ func answerAAAA(q *dns.Question, v *DNSValue) dns.RR {
 	answer.Header().Rrtype = dns.TypeAAAA
 	answer.Header().Class = dns.ClassINET
 	answer.AAAA = net.ParseIP(v.Value)
+	if answer.AAAA == nil {
+		return nil
+	}
 	return answer
 }
 
@@ -514,44 +958,97 @@ func haveAuthority(cfg *Config, q *dns.Question) bool {
 		found, err = cfg.db.HasDNS(name, "DNAME")
 		if err == nil && found {
 			// FIXME!  THIS NEEDS TO HANDLE DNAME ALIASING CORRECTLY INSTEAD OF IGNORING IT...
-			log.Printf("DNAME EXISTS!  WE NEED TO HANDLE THIS CORRECTLY... FIXME\n")
+			logger.Printf("DNAME EXISTS!  WE NEED TO HANDLE THIS CORRECTLY... FIXME\n")
 			return true
 		}
 	}
 	return false
 }
 
-func forwardQuestion(q *dns.Question, forwarders []string) []dns.RR {
+// noDataSOA returns the SOA of the zone of q's name if the name exists,
+// with records of other types or as an empty non-terminal with names below
+// it, so that the answer is NODATA rather than NXDOMAIN (RFC 8020), or nil
+// if the name does not exist
+func noDataSOA(cfg *Config, q *dns.Question) dns.RR {
+	if exists, err := cfg.db.HasDNSName(q.Name); err != nil || !exists {
+		return nil
+	}
+	parts := strings.Split(cleanFQDN(q.Name), ".")
+	for i := range parts {
+		zone := strings.Join(parts[i:], ".")
+		if entry, err := cfg.db.GetDNS(zone, "SOA"); err == nil {
+			return negativeSOA(zone, entry)
+		}
+	}
+	return nil
+}
+
+// forwardQuestion asks the servers of pool, in turn, until one answers,
+// and fails the question with SERVFAIL if none does
+func forwardQuestion(r *DNSRequest, pool *dnsForwarderPool) []dns.RR {
+	span, q := r.Span, r.Question
 	//qType := dns.Type(q.Qtype).String() // query type
-	//log.Printf("[Forwarder Lookup [%s] [%s]]\n", q.Name, qType)
+	//logger.Printf("[Forwarder Lookup [%s] [%s]]\n", q.Name, qType)
 
 	myReq := new(dns.Msg)
 	myReq.SetQuestion(q.Name, q.Qtype)
+	myReq.Question[0].Qclass = q.Qclass
+	setForwardedEDNS(myReq, r.DNSSEC, r.Subnet)
 
-	if len(forwarders) == 0 {
-		// we have no upstreams, so we'll just not use any
-	} else if strings.TrimSpace(forwarders[0]) == "!" {
-		// we've been told explicitly to not pass anything along to any upsteams
-	} else {
-		c := new(dns.Client)
-		for _, server := range forwarders {
-			c.Net = "udp"
-			m, _, err := c.Exchange(myReq, strings.TrimSpace(server))
-
-			if m != nil && m.MsgHdr.Truncated {
-				c.Net = "tcp"
-				m, _, err = c.Exchange(myReq, strings.TrimSpace(server))
-			}
-
-			// FIXME: Cache misses.  And cache hits, too.
+	if len(pool.servers) == 0 {
+		// we have no upstreams, or we've been told explicitly to not pass anything along to any upstreams
+		return nil
+	}
+	dnsForwarderPoolStats.Add(pool.name, 1)
+	c := &dns.Client{DialTimeout: pool.timeout, ReadTimeout: pool.timeout, WriteTimeout: pool.timeout}
+	for _, server := range pool.candidates(time.Now()) {
+		span := span.Child("dns.forward", spanClient)
+		span.SetAttr("net.peer", server)
+		span.SetAttr("dns.forwarder_pool", pool.name)
+		c.Net = pool.transport
+		m, _, err := c.Exchange(myReq, server)
+
+		if m != nil && m.MsgHdr.Truncated && c.Net == "udp" {
+			c.Net = "tcp"
+			m, _, err = c.Exchange(myReq, server)
+		}
+		span.SetAttr("net.transport", c.Net)
+		span.SetError(err)
+		span.End()
+		pool.report(server, err)
+
+		// FIXME: Cache misses.  And cache hits, too.
+
+		if err != nil {
+			//logger.Printf("[Forwarder Lookup [%s] [%s] failed: [%s]]\n", q.Name, qType, err)
+			logger.Println(err)
+		} else if m.Rcode == dns.RcodeServerFailure {
+			logger.Printf("%s answered SERVFAIL for %s\n", server, q.Name)
+		} else {
+			//logger.Printf("[Forwarder Lookup [%s] [%s] success]\n", q.Name, qType)
+			return append(m.Answer, upstreamNegativeSOA(m)...)
+		}
+	}
+	// no server answered: this is no proof that the name does not exist
+	r.Rcode = dns.RcodeServerFailure
+	return nil
+}
 
-			if err != nil {
-				//log.Printf("[Forwarder Lookup [%s] [%s] failed: [%s]]\n", q.Name, qType, err)
-				log.Println(err)
-			} else {
-				//log.Printf("[Forwarder Lookup [%s] [%s] success]\n", q.Name, qType)
-				return m.Answer
+// upstreamNegativeSOA returns the SOA record of a response that has no records for
+// a name that exists (NODATA), with the negative TTL of RFC 2308 section 5,
+// so that the chain can cache the answer that long. NXDOMAIN responses are
+// not kept, since the chain after the cache cannot carry their rcode: they
+// are cached for dnscachemissingttl.
+func upstreamNegativeSOA(m *dns.Msg) []dns.RR {
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) > 0 {
+		return nil
+	}
+	for _, rr := range m.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if soa.Minttl < soa.Hdr.Ttl {
+				soa.Hdr.Ttl = soa.Minttl
 			}
+			return []dns.RR{soa}
 		}
 	}
 	return nil
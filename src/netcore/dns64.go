@@ -0,0 +1,99 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// dns64WellKnownPrefix is the prefix reserved for DNS64 by RFC 6052
+const dns64WellKnownPrefix = "64:ff9b::/96"
+
+// parseDNS64Prefix checks that prefix can be used to synthesize addresses:
+// an IPv6 prefix of one of the lengths permitted by RFC 6052
+func parseDNS64Prefix(prefix string) (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if network.IP.To4() != nil {
+		return nil, fmt.Errorf("dns64 prefix %s is not IPv6", prefix)
+	}
+	switch ones, _ := network.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("dns64 prefix %s must be a /32, /40, /48, /56, /64 or /96", prefix)
+	}
+	if network.IP[8] != 0 {
+		return nil, fmt.Errorf("dns64 prefix %s must have bits 64 to 71 set to zero", prefix)
+	}
+	return network, nil
+}
+
+// synthesizeDNS64 embeds an IPv4 address in the prefix as described in RFC
+// 6052 section 2.2, skipping over the reserved octet at bits 64 to 71
+func synthesizeDNS64(prefix *net.IPNet, v4 net.IP) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16())
+	ones, _ := prefix.Mask.Size()
+	i := ones / 8
+	for _, b := range v4.To4() {
+		if i == 8 {
+			i++
+		}
+		ip[i] = b
+		i++
+	}
+	return ip
+}
+
+// newDNS64Handler answers AAAA questions for names that have no AAAA
+// records with addresses synthesized from their A records, for clients
+// that can only reach IPv4 hosts through NAT64
+func newDNS64Handler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	prefix := cfg.DNS64Prefix()
+	if prefix == nil {
+		return next, nil
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		answers := next.ServeDNSQuestion(r)
+		if r.Question.Qtype != dns.TypeAAAA || hasNativeAAAA(answers) {
+			return answers
+		}
+
+		q := *r.Question
+		q.Qtype = dns.TypeA
+		r2 := *r
+		r2.Question = &q
+		var synthesized []dns.RR
+		found := false
+		for _, rr := range next.ServeDNSQuestion(&r2) {
+			switch rr := rr.(type) {
+			case *dns.A:
+				hdr := rr.Hdr
+				hdr.Rrtype = dns.TypeAAAA
+				synthesized = append(synthesized, &dns.AAAA{Hdr: hdr, AAAA: synthesizeDNS64(prefix, rr.A)})
+				found = true
+			case *dns.CNAME:
+				synthesized = append(synthesized, rr)
+			}
+		}
+		if !found {
+			return answers
+		}
+		r.Trace.Note("no native AAAA record: synthesized from the A records with prefix %s", prefix)
+		return synthesized
+	}), nil
+}
+
+// hasNativeAAAA reports whether answers contain a usable AAAA record.
+// IPv4-mapped addresses don't count, per RFC 6147 section 5.1.4.
+func hasNativeAAAA(answers []dns.RR) bool {
+	for _, rr := range answers {
+		if aaaa, ok := rr.(*dns.AAAA); ok && aaaa.AAAA.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSynthesizeDNS64(t *testing.T) {
+	// Examples from RFC 6052 section 2.4
+	tests := []struct {
+		prefix, ip string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+		{"64:ff9b::/96", "64:ff9b::192.0.2.33"},
+	}
+	for _, test := range tests {
+		prefix, err := parseDNS64Prefix(test.prefix)
+		if err != nil {
+			t.Errorf("parseDNS64Prefix(%q): %s", test.prefix, err)
+			continue
+		}
+		ip := synthesizeDNS64(prefix, net.ParseIP("192.0.2.33"))
+		if !ip.Equal(net.ParseIP(test.ip)) {
+			t.Errorf("synthesizeDNS64(%s, 192.0.2.33) = %s, want %s", test.prefix, ip, test.ip)
+		}
+	}
+}
@@ -1,7 +1,8 @@
-package main
+package netcore
 
 import (
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -11,3 +12,51 @@ func TestWOL(t *testing.T) {
 	m := new(dns.Msg)
 	m.SetQuestion("_wol.test", dns.TypeTXT)
 }
+
+func TestValidateDNSValue(t *testing.T) {
+	tests := []struct {
+		rrType uint16
+		value  DNSValue
+		valid  bool
+	}{
+		{dns.TypeA, DNSValue{Value: "10.0.0.1"}, true},
+		{dns.TypeA, DNSValue{Value: "fe80::1"}, false},
+		{dns.TypeA, DNSValue{Value: "not-an-ip"}, false},
+		{dns.TypeAAAA, DNSValue{Value: "fe80::1"}, true},
+		{dns.TypeAAAA, DNSValue{Value: "10.0.0.1"}, false},
+		{dns.TypeCNAME, DNSValue{Value: "www.example.com."}, true},
+		{dns.TypeCNAME, DNSValue{Value: ""}, false},
+		{dns.TypeMX, DNSValue{Value: "mail.example.com", Attr: map[string]string{"priority": "10"}}, true},
+		{dns.TypeMX, DNSValue{Value: "mail.example.com", Attr: map[string]string{"priority": "-1"}}, false},
+		{dns.TypeSRV, DNSValue{Value: "sip.example.com:5060"}, true},
+		{dns.TypeSRV, DNSValue{Value: "sip.example.com:99999"}, false},
+	}
+	for _, test := range tests {
+		err := validateDNSValue(test.rrType, &test.value)
+		if test.valid && err != nil {
+			t.Errorf("%s %q: unexpected error: %s", dns.Type(test.rrType).String(), test.value.Value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s %q: expected an error", dns.Type(test.rrType).String(), test.value.Value)
+		}
+	}
+}
+
+func TestNextSOASerial(t *testing.T) {
+	now := time.Date(2015, time.June, 7, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		old, next uint32
+	}{
+		{0, 2015060700},
+		{1, 2015060700},
+		{2015060600, 2015060700},
+		{2015060700, 2015060701},
+		{2015060799, 2015060800},
+		{2015080100, 2015080101}, // never go backwards
+	}
+	for _, test := range tests {
+		if next := nextSOASerial(test.old, now); next != test.next {
+			t.Errorf("nextSOASerial(%d) = %d, want %d", test.old, next, test.next)
+		}
+	}
+}
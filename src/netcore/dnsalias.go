@@ -0,0 +1,66 @@
+package netcore
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dnsTypeALIAS is the type of ALIAS records, a pseudo-type of the private
+// range that never goes on the wire, numbered as PowerDNS numbers its own.
+// An ALIAS names a host, such as a load balancer's, whose A and AAAA
+// records are looked up when a question comes and answered as the alias's
+// own: unlike a CNAME, it may be at the apex of a zone, next to its SOA and
+// NS records. Answers are cached as any other, for no longer than the
+// target's records or the alias's TTL.
+const dnsTypeALIAS uint16 = 65401
+
+// maxAliasDepth bounds the aliases followed to answer one question, so that
+// aliases pointing at each other do not loop
+const maxAliasDepth = 8
+
+// parseRecordType reads the type of a record by name, including ALIAS and
+// its other name, ANAME, and returns its canonical name
+func parseRecordType(name string) (uint16, string, bool) {
+	name = strings.ToUpper(name)
+	if name == "ALIAS" || name == "ANAME" {
+		return dnsTypeALIAS, "ALIAS", true
+	}
+	rrType, ok := dns.StringToType[name]
+	return rrType, name, ok
+}
+
+// dnsTypeString names the type rrType, as records are stored under it
+func dnsTypeString(rrType uint16) string {
+	if rrType == dnsTypeALIAS {
+		return "ALIAS"
+	}
+	return dns.Type(rrType).String()
+}
+
+// aliasAnswers resolves target, the name an ALIAS of r's question points
+// to, and returns its records of the question's type under the question's
+// name. The target is resolved as any question, from our zones or through
+// the rest of the chain.
+func (h *dnsAuthoritativeHandler) aliasAnswers(r *DNSRequest, target string) []dns.RR {
+	if r.Depth >= maxAliasDepth {
+		r.Trace.Note("not following the ALIAS to %s: %d aliases were already followed", target, r.Depth)
+		return nil
+	}
+	r.Trace.Note("resolving the ALIAS target %s", target)
+	q2 := *r.Question
+	q2.Name = target
+	r2 := *r
+	r2.Question = &q2
+	r2.Depth++
+	var answers []dns.RR
+	for _, rr := range h.ServeDNSQuestion(&r2) {
+		if rr.Header().Rrtype != r.Question.Qtype {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = r.Question.Name
+		answers = append(answers, rr)
+	}
+	return answers
+}
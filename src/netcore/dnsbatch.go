@@ -0,0 +1,188 @@
+package netcore
+
+import (
+	"errors"
+	"expvar"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var dnsBatch = Flags.Int("dnsbatch", 32, "UDP messages that DNS reads or writes in one system call where the platform can (recvmmsg and sendmmsg on Linux); 1 reads them one at a time.")
+
+const (
+	// dnsBatchBufferSize is the largest query read, as large as the
+	// payloads EDNS clients usually announce
+	dnsBatchBufferSize = 4096
+	// dnsBatchPoll is how long a read waits for messages before checking
+	// whether the server is shutting down
+	dnsBatchPoll = 500 * time.Millisecond
+)
+
+// dnsBatchStats counts the batches read and written and the messages in
+// them, published with expvar
+var dnsBatchStats = expvar.NewMap("dns_udp_batches")
+
+// errBatchUnsupported is returned where messages cannot be batched
+var errBatchUnsupported = errors.New("batched UDP is not supported on this platform")
+
+// batchMessage is a UDP message read or to write in a batch, with the
+// address of its peer
+type batchMessage struct {
+	Buf  []byte
+	N    int
+	Addr *net.UDPAddr
+}
+
+// batchConn reads and writes many UDP messages in one system call. Reads
+// return with no messages and a timeout after a while, and writes may
+// write only the first messages.
+type batchConn interface {
+	ReadBatch(ms []batchMessage) (int, error)
+	WriteBatch(ms []batchMessage) (int, error)
+	Close() error
+}
+
+// dnsBatchServer serves DNS over UDP as dns.Server does, reading queries
+// and writing answers in batches to save system calls at high rates
+type dnsBatchServer struct {
+	conn    net.PacketConn
+	batch   batchConn
+	workers *dnsShardPool
+	size    int
+	replies chan batchMessage
+	done    chan struct{}
+	stop    sync.Once
+	stopped int32
+}
+
+// newDNSBatchServer serves conn with handler, or fails if its messages
+// cannot be batched here
+func newDNSBatchServer(conn net.PacketConn, handler dns.Handler, size int) (*dnsBatchServer, error) {
+	if size <= 1 {
+		return nil, errBatchUnsupported
+	}
+	batch, err := newBatchConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	return &dnsBatchServer{
+		conn:    conn,
+		batch:   batch,
+		workers: newDNSShardPool(handler, *dnsShards, *dnsShardWorkers, done),
+		size:    size,
+		replies: make(chan batchMessage, 4*size),
+		done:    done,
+	}, nil
+}
+
+// Serve reads queries and hands each to the workers of the shard of its
+// name, until Shutdown
+func (s *dnsBatchServer) Serve() error {
+	go s.write()
+	ms := make([]batchMessage, s.size)
+	for i := range ms {
+		ms[i].Buf = make([]byte, dnsBatchBufferSize)
+	}
+	for {
+		n, err := s.batch.ReadBatch(ms)
+		if atomic.LoadInt32(&s.stopped) != 0 {
+			return nil
+		}
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				continue
+			}
+			return err
+		}
+		dnsBatchStats.Add("reads", 1)
+		dnsBatchStats.Add("read_messages", int64(n))
+		for _, m := range ms[:n] {
+			req := new(dns.Msg)
+			if err := req.Unpack(m.Buf[:m.N]); err != nil {
+				continue
+			}
+			s.workers.Serve(&dnsBatchWriter{server: s, addr: m.Addr}, req)
+		}
+	}
+}
+
+// write sends the answers queued by the handlers, as many at a time as
+// are waiting
+func (s *dnsBatchServer) write() {
+	ms := make([]batchMessage, 0, s.size)
+	for {
+		select {
+		case m := <-s.replies:
+			ms = append(ms[:0], m)
+		case <-s.done:
+			return
+		}
+	queued:
+		for len(ms) < s.size {
+			select {
+			case m := <-s.replies:
+				ms = append(ms, m)
+			default:
+				break queued
+			}
+		}
+		dnsBatchStats.Add("writes", 1)
+		dnsBatchStats.Add("written_messages", int64(len(ms)))
+		for pending := ms; len(pending) > 0; {
+			n, err := s.batch.WriteBatch(pending)
+			if err != nil {
+				// The first message failed, say for an unreachable
+				// peer: it is dropped as dns.Server would
+				dnsBatchStats.Add("write_errors", 1)
+				n = 1
+			}
+			pending = pending[n:]
+		}
+	}
+}
+
+// Shutdown stops reading queries and writing answers
+func (s *dnsBatchServer) Shutdown() error {
+	s.stop.Do(func() {
+		atomic.StoreInt32(&s.stopped, 1)
+		close(s.done)
+	})
+	return s.batch.Close()
+}
+
+// dnsBatchWriter queues the answer to a query for the batch server to write
+type dnsBatchWriter struct {
+	server *dnsBatchServer
+	addr   *net.UDPAddr
+}
+
+func (w *dnsBatchWriter) LocalAddr() net.Addr  { return w.server.conn.LocalAddr() }
+func (w *dnsBatchWriter) RemoteAddr() net.Addr { return w.addr }
+
+func (w *dnsBatchWriter) WriteMsg(m *dns.Msg) error {
+	data, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (w *dnsBatchWriter) Write(data []byte) (int, error) {
+	select {
+	case w.server.replies <- batchMessage{Buf: data, N: len(data), Addr: w.addr}:
+		return len(data), nil
+	case <-w.server.done:
+		return 0, errors.New("the DNS server is shutting down")
+	}
+}
+
+func (w *dnsBatchWriter) Close() error        { return nil }
+func (w *dnsBatchWriter) TsigStatus() error   { return nil }
+func (w *dnsBatchWriter) TsigTimersOnly(bool) {}
+func (w *dnsBatchWriter) Hijack()             {}
@@ -0,0 +1,112 @@
+//go:build linux && go1.9
+// +build linux,go1.9
+
+package netcore
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// xnetBatchConn reads and writes a UDP socket in batches through the
+// PacketConn of golang.org/x/net/ipv4 or ipv6, with recvmmsg and sendmmsg
+// on Linux. Go's poller keeps the socket, so reads wait for up to
+// dnsBatchPoll with a deadline.
+type xnetBatchConn struct {
+	conn  *net.UDPConn
+	read  func([]ipv4.Message, int) (int, error)
+	write func([]ipv4.Message, int) (int, error)
+	msgs  []ipv4.Message
+	bufs  [][]byte
+}
+
+// newBatchConn returns conn, a UDP socket, read and written in batches
+func newBatchConn(conn net.PacketConn) (batchConn, error) {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil, errBatchUnsupported
+	}
+	c := &xnetBatchConn{conn: udp}
+	if addr, ok := udp.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		p := ipv4.NewPacketConn(udp)
+		c.read, c.write = p.ReadBatch, p.WriteBatch
+	} else {
+		// Also reads IPv4 queries on a socket bound to both families
+		p := ipv6.NewPacketConn(udp)
+		c.read, c.write = p.ReadBatch, p.WriteBatch
+	}
+	return c, nil
+}
+
+// prepare points the messages at ms, growing them as needed
+func (c *xnetBatchConn) prepare(ms []batchMessage) []ipv4.Message {
+	if len(c.msgs) < len(ms) {
+		c.msgs = make([]ipv4.Message, len(ms))
+		c.bufs = make([][]byte, len(ms))
+	}
+	for i := range ms {
+		c.msgs[i] = ipv4.Message{Buffers: c.bufs[i : i+1]}
+	}
+	return c.msgs[:len(ms)]
+}
+
+func (c *xnetBatchConn) ReadBatch(ms []batchMessage) (int, error) {
+	msgs := c.prepare(ms)
+	for i := range ms {
+		c.bufs[i] = ms[i].Buf
+	}
+	if err := c.conn.SetReadDeadline(time.Now().Add(dnsBatchPoll)); err != nil {
+		return 0, err
+	}
+	n, err := c.read(msgs, 0)
+	for interrupted(err) {
+		n, err = c.read(msgs, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < n; i++ {
+		ms[i].N = msgs[i].N
+		ms[i].Addr, _ = msgs[i].Addr.(*net.UDPAddr)
+	}
+	return n, nil
+}
+
+func (c *xnetBatchConn) WriteBatch(ms []batchMessage) (int, error) {
+	msgs := c.prepare(ms)
+	for i := range ms {
+		if ms[i].Addr == nil || ms[i].N == 0 {
+			return 0, errors.New("a message without a peer or data cannot be sent")
+		}
+		c.bufs[i] = ms[i].Buf[:ms[i].N]
+		msgs[i].Addr = ms[i].Addr
+	}
+	n, err := c.write(msgs, 0)
+	for interrupted(err) {
+		n, err = c.write(msgs, 0)
+	}
+	return n, err
+}
+
+// Close wakes a read in progress. The socket itself belongs to the
+// listeners, which may hand it over to the next process.
+func (c *xnetBatchConn) Close() error {
+	return c.conn.SetReadDeadline(time.Now())
+}
+
+// interrupted tells whether err is a system call that a signal interrupted
+func interrupted(err error) bool {
+	if e, ok := err.(*net.OpError); ok {
+		err = e.Err
+	}
+	if e, ok := err.(*os.SyscallError); ok {
+		err = e.Err
+	}
+	return err == syscall.EINTR
+}
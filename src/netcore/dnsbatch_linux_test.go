@@ -0,0 +1,118 @@
+//go:build linux && go1.9
+// +build linux,go1.9
+
+package netcore
+
+import (
+	"net"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBatchReadInterrupted(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	batch, err := newBatchConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Close()
+
+	type result struct {
+		n   int
+		err error
+	}
+	tids := make(chan int, 1)
+	results := make(chan result, 1)
+	go func() {
+		// The read blocks on this thread, which the signals are sent to
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		tids <- syscall.Gettid()
+		ms := []batchMessage{{Buf: make([]byte, 512)}}
+		n, err := batch.ReadBatch(ms)
+		results <- result{n, err}
+	}()
+	tid := <-tids
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		// Go's handler for SIGURG does nothing, but the signal still
+		// interrupts the system calls of the thread
+		if err := syscall.Tgkill(syscall.Getpid(), tid, syscall.SIGURG); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client, err := net.Dial("udp4", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("query")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-results:
+		if r.err != nil || r.n != 1 {
+			t.Errorf("ReadBatch() = %d, %v; want 1, nil", r.n, r.err)
+		}
+	case <-time.After(2 * dnsBatchPoll):
+		t.Fatal("ReadBatch did not return")
+	}
+}
+
+func TestBatchEcho(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	defer conn.Close()
+	batch, err := newBatchConn(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for _, query := range []string{"one", "two"} {
+		if _, err := client.Write([]byte(query)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ms := []batchMessage{{Buf: make([]byte, 512)}, {Buf: make([]byte, 512)}, {Buf: make([]byte, 512)}}
+	n, err := batch.ReadBatch(ms)
+	if err != nil || n != 2 || string(ms[1].Buf[:ms[1].N]) != "two" || ms[1].Addr.String() != client.LocalAddr().String() {
+		t.Fatalf("ReadBatch() = %d, %v; messages %+v", n, err, ms[:n])
+	}
+	if n, err := batch.WriteBatch(ms[:2]); err != nil || n != 2 {
+		t.Fatalf("WriteBatch() = %d, %v; want 2, nil", n, err)
+	}
+	for _, want := range []string{"one", "two"} {
+		buf := make([]byte, 512)
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		if n, err := client.Read(buf); err != nil || string(buf[:n]) != want {
+			t.Errorf("the client read %q, %v; want %q", buf[:n], err, want)
+		}
+	}
+
+	// Closing wakes a read, and leaves the socket to its listener
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		batch.Close()
+	}()
+	start := time.Now()
+	if _, err := batch.ReadBatch(ms); err == nil || time.Since(start) >= dnsBatchPoll {
+		t.Errorf("ReadBatch() after Close = %v after %s; want a timeout at once", err, time.Since(start))
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		t.Errorf("the listener was closed: %v", err)
+	}
+}
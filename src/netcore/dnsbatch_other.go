@@ -0,0 +1,12 @@
+//go:build !linux || !go1.9
+// +build !linux !go1.9
+
+package netcore
+
+import "net"
+
+// newBatchConn fails here, where DNS reads and writes UDP messages one at a
+// time through dns.Server
+func newBatchConn(conn net.PacketConn) (batchConn, error) {
+	return nil, errBatchUnsupported
+}
@@ -0,0 +1,185 @@
+package netcore
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustywilson/dnscache"
+	"github.com/miekg/dns"
+)
+
+// defaultDNSCacheMaxRRset is the largest answer, in bytes on the wire, that
+// a cache with a memory budget keeps
+const defaultDNSCacheMaxRRset = 8192
+
+// dnsCacheEntryOverhead approximates what an entry takes besides the wire
+// size of its answers: its key, its place in the LRU list and the map, and
+// the structures of the records
+const dnsCacheEntryOverhead = 256
+
+// dnsQuestionCache answers questions from a cache, filling it on a miss,
+// as dnscache does
+type dnsQuestionCache interface {
+	Lookup(r dnscache.Request)
+}
+
+// dnsBudgetCache is a cache whose answers may take no more than budget
+// bytes, counted by their wire size, for the variants of questions
+// together. Past the budget the least recently used answers are evicted,
+// and answers larger than maxRRset are never kept, so that a burst of
+// unique names or a few huge answers cannot exhaust the memory of a small
+// box. Like dnscache, it coalesces the lookups of a question in progress.
+type dnsBudgetCache struct {
+	sync.Mutex
+	budget     int
+	maxRRset   int
+	maxTTL     time.Duration
+	missingTTL time.Duration
+	used       int
+	lru        *list.List // of *dnsCacheEntry, most recently used first
+	entries    map[string]*list.Element
+	inflight   map[string]*dnsCacheFill
+}
+
+type dnsCacheEntry struct {
+	key     string
+	answers []dns.RR
+	size    int
+	expires time.Time
+}
+
+// dnsCacheFill is a lookup in progress, which others asking the same
+// question wait for
+type dnsCacheFill struct {
+	done    chan struct{}
+	answers []dns.RR
+}
+
+// newDNSBudgetCache returns an empty cache of budget bytes
+func newDNSBudgetCache(budget, maxRRset int, maxTTL, missingTTL time.Duration) *dnsBudgetCache {
+	return &dnsBudgetCache{
+		budget:     budget,
+		maxRRset:   maxRRset,
+		maxTTL:     maxTTL,
+		missingTTL: missingTTL,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		inflight:   make(map[string]*dnsCacheFill),
+	}
+}
+
+// variant returns the cache of a variant of questions, which shares the
+// budget with the others and is filled by fill
+func (c *dnsBudgetCache) variant(key dnsCacheKey, fill func(dnscache.Context, dns.Question) []dns.RR) dnsQuestionCache {
+	prefix := strconv.Itoa(int(key.qclass)) + "|" + key.subnet + "|"
+	if key.dnssec {
+		prefix += "do|"
+	}
+	return dnsBudgetVariant{c, prefix, fill}
+}
+
+type dnsBudgetVariant struct {
+	cache  *dnsBudgetCache
+	prefix string
+	fill   func(dnscache.Context, dns.Question) []dns.RR
+}
+
+// Lookup sends the answers to r's question on its channel, from the cache
+// or from fill
+func (v dnsBudgetVariant) Lookup(r dnscache.Request) {
+	go func() {
+		r.ResponseChan <- v.cache.lookup(v.prefix+strings.ToLower(r.Question.Name)+"|"+dns.Type(r.Question.Qtype).String(), r, v.fill)
+	}()
+}
+
+func (c *dnsBudgetCache) lookup(key string, r dnscache.Request, fill func(dnscache.Context, dns.Question) []dns.RR) []dns.RR {
+	c.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*dnsCacheEntry)
+		if remaining := entry.expires.Sub(r.Start); remaining > 0 {
+			c.lru.MoveToFront(element)
+			c.Unlock()
+			return withRemainingTTL(entry.answers, remaining)
+		}
+		c.remove(element)
+	}
+	if f, ok := c.inflight[key]; ok {
+		c.Unlock()
+		<-f.done
+		return f.answers
+	}
+	f := &dnsCacheFill{done: make(chan struct{})}
+	c.inflight[key] = f
+	c.Unlock()
+
+	f.answers = fill(dnscache.Context{Event: dnscache.Lookup, Start: r.Start}, r.Question)
+	c.Lock()
+	delete(c.inflight, key)
+	c.store(key, f.answers, r.Start)
+	c.Unlock()
+	close(f.done)
+	return f.answers
+}
+
+// store keeps answers under key, evicting the least recently used entries
+// to stay within the budget, unless they are too large to be worth it;
+// callers hold the lock
+func (c *dnsBudgetCache) store(key string, answers []dns.RR, now time.Time) {
+	ttl := c.missingTTL
+	if len(answers) > 0 {
+		ttl = c.maxTTL
+		for _, rr := range answers {
+			if d := time.Duration(rr.Header().Ttl) * time.Second; d < ttl {
+				ttl = d
+			}
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	m := new(dns.Msg)
+	m.Answer = answers
+	size := m.Len()
+	if size > c.maxRRset {
+		dnsCacheCounts.Add("refused", 1)
+		return
+	}
+	size += len(key) + dnsCacheEntryOverhead
+	for c.used+size > c.budget && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		dnsCacheCounts.Add("evicted", 1)
+	}
+	if c.used+size > c.budget {
+		dnsCacheCounts.Add("refused", 1)
+		return
+	}
+	entry := &dnsCacheEntry{key: key, answers: answers, size: size, expires: now.Add(ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.used += size
+	dnsCacheCounts.Add("bytes", int64(size))
+}
+
+// remove drops an entry; callers hold the lock
+func (c *dnsBudgetCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*dnsCacheEntry)
+	delete(c.entries, entry.key)
+	c.used -= entry.size
+	dnsCacheCounts.Add("bytes", -int64(entry.size))
+}
+
+// withRemainingTTL returns copies of answers whose TTLs count down to the
+// expiration of their cache entry
+func withRemainingTTL(answers []dns.RR, remaining time.Duration) []dns.RR {
+	ttl := uint32((remaining + time.Second - 1) / time.Second)
+	copies := make([]dns.RR, len(answers))
+	for i, rr := range answers {
+		copies[i] = dns.Copy(rr)
+		if copies[i].Header().Ttl > ttl {
+			copies[i].Header().Ttl = ttl
+		}
+	}
+	return copies
+}
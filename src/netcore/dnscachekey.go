@@ -0,0 +1,125 @@
+package netcore
+
+import (
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// dnsCacheMaxVariants bounds the caches kept for the variants of questions,
+// so that clients sending many subnets cannot grow them without end.
+// Questions of a new variant beyond it bypass the cache.
+const dnsCacheMaxVariants = 1024
+
+// dnsCacheKey is what, besides the name and type of a question, tells its
+// answers apart in the cache: a client setting the DO bit must get the
+// signatures of a forwarded answer, which a cached answer to another
+// client would lack, and an answer for one EDNS Client Subnet may be wrong
+// for another. The subnet is the one the client sent, masked to its source
+// prefix, which is never wider than the scope of the answer.
+type dnsCacheKey struct {
+	qclass uint16
+	dnssec bool
+	subnet string
+}
+
+// cacheKeyOf returns the variant of r's question
+func cacheKeyOf(r *DNSRequest) dnsCacheKey {
+	key := dnsCacheKey{qclass: r.Question.Qclass, dnssec: r.DNSSEC}
+	if r.Subnet != nil {
+		key.subnet = r.Subnet.String()
+	}
+	return key
+}
+
+// dnsCaches is a cache for each variant of questions, made on first use,
+// in shards by the hash of the question's name, so that lookups of
+// different names do not wait on one lock
+type dnsCaches struct {
+	shards []*dnsCacheShard
+	make   func(shard int, key dnsCacheKey) dnsQuestionCache
+}
+
+type dnsCacheShard struct {
+	sync.Mutex
+	caches map[dnsCacheKey]dnsQuestionCache
+}
+
+// newDNSCaches returns shards empty shards, whose caches newCache makes
+func newDNSCaches(shards int, newCache func(shard int, key dnsCacheKey) dnsQuestionCache) *dnsCaches {
+	if shards < 1 {
+		shards = 1
+	}
+	c := &dnsCaches{shards: make([]*dnsCacheShard, shards), make: newCache}
+	for i := range c.shards {
+		c.shards[i] = &dnsCacheShard{caches: make(map[dnsCacheKey]dnsQuestionCache)}
+	}
+	return c
+}
+
+// get returns the cache of key in the shard of name, or nil if there are
+// too many to make it
+func (c *dnsCaches) get(name string, key dnsCacheKey) dnsQuestionCache {
+	i := dnsShardOf(name, len(c.shards))
+	shard := c.shards[i]
+	shard.Lock()
+	defer shard.Unlock()
+	if cache, ok := shard.caches[key]; ok {
+		return cache
+	}
+	if len(shard.caches) >= dnsCacheMaxVariants {
+		return nil
+	}
+	cache := c.make(i, key)
+	shard.caches[key] = cache
+	dnsCacheCounts.Add("variants", 1)
+	return cache
+}
+
+// clientSubnet returns the subnet of the EDNS Client Subnet option of req,
+// masked to its source prefix, or nil if it has none or its prefix is 0,
+// which asks that the client's address not be used
+func clientSubnet(req *dns.Msg) *net.IPNet {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, option := range opt.Option {
+		ecs, ok := option.(*dns.EDNS0_SUBNET)
+		if !ok || ecs.SourceNetmask == 0 {
+			continue
+		}
+		bits := 32
+		ip := ecs.Address.To4()
+		if ecs.Family == 2 {
+			bits, ip = 128, ecs.Address.To16()
+		}
+		if ip == nil || int(ecs.SourceNetmask) > bits {
+			return nil
+		}
+		mask := net.CIDRMask(int(ecs.SourceNetmask), bits)
+		return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+	return nil
+}
+
+// setForwardedEDNS asks upstream servers for the signatures a client
+// setting the DO bit expects, and passes on its client subnet
+func setForwardedEDNS(m *dns.Msg, dnssec bool, subnet *net.IPNet) {
+	if !dnssec && subnet == nil {
+		return
+	}
+	m.SetEdns0(4096, dnssec)
+	if subnet == nil {
+		return
+	}
+	ones, bits := subnet.Mask.Size()
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: uint8(ones), Address: subnet.IP}
+	if bits == 128 {
+		ecs.Family = 2
+	}
+	if opt := m.IsEdns0(); opt != nil {
+		opt.Option = append(opt.Option, ecs)
+	}
+}
@@ -0,0 +1,81 @@
+package netcore
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// catalogMembers returns the member zones listed in a catalog zone, per
+// RFC 9432. Each member is a PTR record at <unique-id>.zones.<catalog>
+// pointing at the member zone's name. Catalogs of an unsupported schema
+// version list no members.
+func (z *secondaryZone) catalogMembers() (map[string]bool, bool) {
+	z.RLock()
+	defer z.RUnlock()
+
+	supported := false
+	for _, rr := range z.records["version."+z.name] {
+		if txt, ok := rr.(*dns.TXT); ok && len(txt.Txt) == 1 && txt.Txt[0] == "2" {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, false
+	}
+
+	members := make(map[string]bool)
+	suffix := ".zones." + z.name
+	for owner, rrs := range z.records {
+		if !strings.HasSuffix(owner, suffix) || strings.Count(strings.TrimSuffix(owner, suffix), ".") != 0 {
+			continue // not a member node; properties live deeper in the tree
+		}
+		for _, rr := range rrs {
+			if ptr, ok := rr.(*dns.PTR); ok {
+				members[strings.ToLower(dns.Fqdn(ptr.Ptr))] = true
+			}
+		}
+	}
+	return members, true
+}
+
+// syncCatalog starts serving the member zones of the catalog as secondaries,
+// transferred from the catalog's own primaries, and stops serving members
+// that have been removed from it. Zones configured explicitly are left alone.
+func (s *dnsSecondaryZones) syncCatalog(catalog *secondaryZone) {
+	members, ok := catalog.catalogMembers()
+	if !ok {
+		logger.Printf("DNS catalog %s: missing or unsupported schema version; ignoring members\n", catalog.name)
+		return
+	}
+
+	var added, removed []*secondaryZone
+	s.Lock()
+	for name := range members {
+		if _, exists := s.zones[name]; exists {
+			continue
+		}
+		zone, err := newSecondaryZone(name, catalog.primaries)
+		if err != nil {
+			continue
+		}
+		zone.catalog = catalog.name
+		s.zones[name] = zone
+		added = append(added, zone)
+	}
+	for name, zone := range s.zones {
+		if zone.catalog == catalog.name && !members[name] {
+			removed = append(removed, zone)
+		}
+	}
+	s.Unlock()
+
+	for _, zone := range added {
+		logger.Printf("DNS catalog %s: adding member zone %s\n", catalog.name, zone.name)
+		go zone.maintain()
+	}
+	for _, zone := range removed {
+		logger.Printf("DNS catalog %s: removing member zone %s\n", catalog.name, zone.name)
+		s.remove(zone)
+	}
+}
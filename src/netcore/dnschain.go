@@ -0,0 +1,410 @@
+package netcore
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustywilson/dnscache"
+	"github.com/miekg/dns"
+)
+
+// DNSRequest is a single question travelling through the DNS handler chain
+type DNSRequest struct {
+	Config   *Config
+	Question *dns.Question
+	Start    time.Time
+	Event    dnscache.Event
+	Depth    uint32      // number of aliases followed to arrive at this question
+	Span     *Span       // nil unless this question is being traced
+	Client   net.IP      // who asked, which only handlers before the cache may use
+	Rcode    int         // set with no answers to fail with other than NXDOMAIN, or with denial records, only before the cache; SERVFAIL crosses it
+	DNSSEC   bool        // the client set the DO bit, part of the cache key
+	Subnet   *net.IPNet  // the EDNS Client Subnet the client sent, if any, part of the cache key
+	Trace    *QueryTrace // nil unless this question is a dry run
+}
+
+// DNSHandler is a stage in the DNS handler chain. A handler may answer the
+// question itself, pass it to the next handler, or both.
+type DNSHandler interface {
+	ServeDNSQuestion(r *DNSRequest) []dns.RR
+}
+
+// DNSHandlerFunc is an adapter to allow the use of ordinary functions as
+// DNS handlers
+type DNSHandlerFunc func(r *DNSRequest) []dns.RR
+
+// ServeDNSQuestion calls f(r)
+func (f DNSHandlerFunc) ServeDNSQuestion(r *DNSRequest) []dns.RR {
+	return f(r)
+}
+
+// DNSMiddleware creates a handler that wraps the next handler in the chain
+type DNSMiddleware func(cfg *Config, next DNSHandler) (DNSHandler, error)
+
+var dnsMiddlewares = map[string]DNSMiddleware{}
+
+// defaultDNSChain is the handler order used when a zone does not configure
+// its own
+var defaultDNSChain = []string{"metrics", "policy", "portal", "static", "specialuse", "pattern", "wol", "rewrite", "dns64", "dnssec", "cache", "secondary", "authoritative", "ttl", "forwarder", "iterate"}
+
+// RegisterDNSMiddleware makes a middleware available by name for use in the
+// dnschain configuration setting
+func RegisterDNSMiddleware(name string, mw DNSMiddleware) {
+	if _, exists := dnsMiddlewares[name]; exists {
+		panic("dns middleware registered twice: " + name)
+	}
+	dnsMiddlewares[name] = mw
+}
+
+func init() {
+	RegisterDNSMiddleware("metrics", newDNSMetricsHandler)
+	RegisterDNSMiddleware("policy", newDNSPolicyHandler)
+	RegisterDNSMiddleware("portal", newDNSPortalHandler)
+	RegisterDNSMiddleware("static", newDNSStaticHandler)
+	RegisterDNSMiddleware("specialuse", newDNSSpecialUseHandler)
+	RegisterDNSMiddleware("pattern", newDNSPatternHandler)
+	RegisterDNSMiddleware("wol", newDNSWOLHandler)
+	RegisterDNSMiddleware("rewrite", newDNSRewriteHandler)
+	RegisterDNSMiddleware("dns64", newDNS64Handler)
+	RegisterDNSMiddleware("dnssec", newDNSSECHandler)
+	RegisterDNSMiddleware("cache", newDNSCacheHandler)
+	RegisterDNSMiddleware("secondary", newDNSSecondaryHandler)
+	RegisterDNSMiddleware("authoritative", newDNSAuthoritativeHandler)
+	RegisterDNSMiddleware("ttl", newDNSTTLHandler)
+	RegisterDNSMiddleware("forwarder", newDNSForwarderHandler)
+	RegisterDNSMiddleware("iterate", newDNSIterativeHandler)
+}
+
+// buildDNSChain assembles the named middlewares into a single handler. The
+// first name in the list sees each question first.
+func buildDNSChain(cfg *Config, names []string) (DNSHandler, error) {
+	var handler DNSHandler = DNSHandlerFunc(func(r *DNSRequest) []dns.RR { return nil })
+	for i := len(names) - 1; i >= 0; i-- {
+		name := strings.TrimSpace(names[i])
+		mw, ok := dnsMiddlewares[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown dns middleware %q", name)
+		}
+		built, err := mw(cfg, handler)
+		if err != nil {
+			return nil, fmt.Errorf("dns middleware %q: %s", name, err)
+		}
+		if traced, ok := built.(*tracedDNSHandler); ok && traced == handler {
+			continue // not configured, so left out of dry runs too
+		}
+		handler = &tracedDNSHandler{name: name, next: built}
+	}
+	return handler, nil
+}
+
+var (
+	dnsQueryCounts  = expvar.NewMap("dns_queries")
+	dnsAnswerCounts = expvar.NewMap("dns_answers")
+	dnsCacheCounts  = expvar.NewMap("dns_cache")
+)
+
+// newDNSMetricsHandler counts questions by type and whether they were
+// answered
+func newDNSMetricsHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		if r.Trace != nil {
+			return next.ServeDNSQuestion(r)
+		}
+		dnsQueryCounts.Add(dns.Type(r.Question.Qtype).String(), 1)
+		dnsQueryRate.Add(r.Start)
+		answers := next.ServeDNSQuestion(r)
+		if len(answers) > 0 {
+			dnsAnswerCounts.Add("answered", 1)
+		} else {
+			dnsAnswerCounts.Add("unanswered", 1)
+		}
+		return answers
+	}), nil
+}
+
+// newDNSWOLHandler intercepts wake-on-lan trigger questions
+func newDNSWOLHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		if !isWOLTrigger(r.Question) {
+			return next.ServeDNSQuestion(r)
+		}
+		if r.Trace != nil {
+			r.Trace.Note("would wake %s, which a dry run does not do", getWOLHostname(r.Question))
+			return next.ServeDNSQuestion(r)
+		}
+		answers := []dns.RR{processWOL(r.Config, r.Question)}
+		return append(answers, next.ServeDNSQuestion(r)...)
+	}), nil
+}
+
+// newDNSCacheHandler answers questions from the cache, consulting the rest
+// of the chain on a miss or renewal. Handlers after the cache answer on
+// behalf of every client asking the same variant of a question, so they
+// may depend on its class, DO bit and client subnet (see dnsCacheKey), but
+// not on who is asking.
+func newDNSCacheHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	// Each shard has its share of the memory budget, if there is one
+	shards := *dnsShards
+	if shards < 1 {
+		shards = 1
+	}
+	var budgets []*dnsBudgetCache
+	if memory := cfg.DNSCacheMemory(); memory > 0 {
+		budgets = make([]*dnsBudgetCache, shards)
+		for i := range budgets {
+			budgets[i] = newDNSBudgetCache(memory/shards, cfg.DNSCacheMaxRRset(), cfg.DNSCacheMaxTTL(), cfg.DNSCacheMissingTTL())
+		}
+	}
+	failed := newDNSFailedFills(cfg.DNSCacheMissingTTL())
+	caches := newDNSCaches(shards, func(shard int, key dnsCacheKey) dnsQuestionCache {
+		var subnet *net.IPNet
+		if key.subnet != "" {
+			_, subnet, _ = net.ParseCIDR(key.subnet)
+		}
+		// The cache coalesces lookups from many clients, so filling it is
+		// traced separately from the queries waiting on it
+		fill := func(c dnscache.Context, q dns.Question) []dns.RR {
+			span := startTrace("dns.cache.fill", spanInternal)
+			span.SetAttr("dns.question.name", q.Name)
+			span.SetAttr("dns.question.type", dns.Type(q.Qtype).String())
+			span.SetAttr("dns.cache.event", c.Event.String())
+			defer span.End()
+			if c.Event == dnscache.Lookup {
+				dnsCacheCounts.Add("misses", 1)
+			}
+			q.Qclass = key.qclass
+			fill := &DNSRequest{
+				Config:   cfg,
+				Question: &q,
+				Start:    c.Start,
+				Event:    c.Event,
+				Span:     span,
+				DNSSEC:   key.dnssec,
+				Subnet:   subnet,
+			}
+			answers := next.ServeDNSQuestion(fill)
+			if fill.Rcode == dns.RcodeServerFailure {
+				failed.mark(q.Name, q.Qtype, key)
+			}
+			return answers
+		}
+		if budgets != nil {
+			return budgets[shard].variant(key, fill)
+		}
+		return dnscache.New(*dnsCacheBufferSize, cfg.DNSCacheMaxTTL(), cfg.DNSCacheMissingTTL(), fill)
+	})
+	overrides, err := loadDNSForwardOverrides(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// uncached asks the rest of the chain as a cache miss would, passing a
+	// SERVFAIL back
+	uncached := func(r *DNSRequest, span *Span, trace *QueryTrace) []dns.RR {
+		miss := &DNSRequest{
+			Config:   cfg,
+			Question: r.Question,
+			Start:    r.Start,
+			Event:    dnscache.Lookup,
+			Span:     span,
+			DNSSEC:   r.DNSSEC,
+			Subnet:   r.Subnet,
+			Trace:    trace,
+		}
+		answers := next.ServeDNSQuestion(miss)
+		if miss.Rcode == dns.RcodeServerFailure {
+			r.Rcode = miss.Rcode
+		}
+		return answers
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		if o := overrides.find(r.Question.Name); o != nil && o.noCache {
+			r.Trace.Note("the forward override of %s bypasses the cache", o.domain)
+			dnsCacheCounts.Add("bypassed", 1)
+			return uncached(r, r.Span, r.Trace)
+		}
+		if r.Trace != nil {
+			// Dry runs neither read the cache, which cannot be consulted
+			// without being filled, nor fill it
+			r.Trace.Note("a dry run skips the cache: what follows is a cache miss, answered for every client asking the same variant of the question")
+			return uncached(r, nil, r.Trace)
+		}
+		cache := caches.get(r.Question.Name, cacheKeyOf(r))
+		if cache == nil || failed.has(r.Question.Name, r.Question.Qtype, cacheKeyOf(r)) {
+			dnsCacheCounts.Add("bypassed", 1)
+			return uncached(r, r.Span, nil)
+		}
+		span := r.Span.Child("dns.cache", spanInternal)
+		defer span.End()
+		dnsCacheCounts.Add("lookups", 1)
+		rc := make(chan []dns.RR)
+		cache.Lookup(dnscache.Request{
+			Question:     *r.Question,
+			Start:        r.Start,
+			ResponseChan: rc,
+		})
+		answers := <-rc
+		if len(answers) == 0 && failed.has(r.Question.Name, r.Question.Qtype, cacheKeyOf(r)) {
+			r.Rcode = dns.RcodeServerFailure // this lookup filled the cache, and failed
+		}
+		return answers
+	}), nil
+}
+
+// dnsFailedFills remembers the questions whose cache fill failed with
+// SERVFAIL, for as long as the cache keeps the empty answer it got. That
+// answer would read as NXDOMAIN, so those questions bypass the cache until
+// it expires: the failure is not cached, and a backend that is back answers
+// right away. Entries expire when they are looked up, and those never asked
+// again are swept once the map has doubled since the last sweep, so that a
+// failing backend costs no more than constant time per question.
+type dnsFailedFills struct {
+	sync.Mutex
+	ttl   time.Duration
+	until map[dnsFailedFill]time.Time
+	sweep int // the size of until at which mark sweeps it
+}
+
+type dnsFailedFill struct {
+	name  string
+	qtype uint16
+	key   dnsCacheKey
+}
+
+// dnsFailedFillsSweep is the least size of a map of failed fills that is
+// swept
+const dnsFailedFillsSweep = 1024
+
+func newDNSFailedFills(ttl time.Duration) *dnsFailedFills {
+	return &dnsFailedFills{ttl: ttl, until: make(map[dnsFailedFill]time.Time), sweep: dnsFailedFillsSweep}
+}
+
+func (f *dnsFailedFills) mark(name string, qtype uint16, key dnsCacheKey) {
+	f.Lock()
+	defer f.Unlock()
+	now := time.Now()
+	f.until[dnsFailedFill{strings.ToLower(name), qtype, key}] = now.Add(f.ttl)
+	if len(f.until) < f.sweep {
+		return
+	}
+	for fill, until := range f.until {
+		if !now.Before(until) {
+			delete(f.until, fill)
+		}
+	}
+	f.sweep = 2 * len(f.until)
+	if f.sweep < dnsFailedFillsSweep {
+		f.sweep = dnsFailedFillsSweep
+	}
+}
+
+func (f *dnsFailedFills) has(name string, qtype uint16, key dnsCacheKey) bool {
+	f.Lock()
+	defer f.Unlock()
+	fill := dnsFailedFill{strings.ToLower(name), qtype, key}
+	until, ok := f.until[fill]
+	if ok && !time.Now().Before(until) {
+		delete(f.until, fill)
+		return false
+	}
+	return ok
+}
+
+// newDNSAuthoritativeHandler answers questions from the DNS database. The
+// next handler is only consulted for names outside of our authority.
+func newDNSAuthoritativeHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	// FIXME: Make the default TTL into a configuration parameter
+	// FIXME: Check whether this default is being applied to unanswered queries
+	h := &dnsAuthoritativeHandler{
+		next:       next,
+		defaultTTL: uint32(10800), // this is the default TTL = 3 hours
+	}
+	return h, nil
+}
+
+// newDNSTTLHandler keeps the TTLs of answers from later handlers within the
+// zone's minimum and maximum, and those of negative answers, which only
+// carry the SOA record their TTL comes from, within the negative ones. In
+// the default chain it sits after the authoritative handler, so it only
+// applies to answers from elsewhere.
+func newDNSTTLHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	if cfg.DNSMinTTL() == 0 && cfg.DNSMaxTTL() == 0 && cfg.DNSNegativeMinTTL() == 0 && cfg.DNSNegativeMaxTTL() == 0 {
+		return next, nil
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		answers := next.ServeDNSQuestion(r)
+		min, max := cfg.DNSMinTTL(), cfg.DNSMaxTTL()
+		if len(answers) > 0 && unanswered(r.Question, answers) {
+			min, max = cfg.DNSNegativeMinTTL(), cfg.DNSNegativeMaxTTL()
+		}
+		for _, answer := range answers {
+			hdr := answer.Header()
+			if hdr.Ttl < min {
+				hdr.Ttl = min
+			}
+			if max > 0 && hdr.Ttl > max {
+				hdr.Ttl = max
+			}
+		}
+		return answers
+	}), nil
+}
+
+// newDNSForwarderHandler sends questions to the upstream resolvers of the
+// forwarder pool that the zone's forward rules pick, unless the zone
+// resolves questions itself
+func newDNSForwarderHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	if cfg.DNSResolver() == "iterate" {
+		return next, nil
+	}
+	forwarding, err := newDNSForwarding(cfg)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := loadDNSForwardOverrides(cfg)
+	if err != nil {
+		return nil, err
+	}
+	special, err := loadDNSSpecialUse(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		if !special.upstream(r) {
+			return next.ServeDNSQuestion(r)
+		}
+		override := overrides.find(r.Question.Name)
+		if override != nil && override.noAAAA && r.Question.Qtype == dns.TypeAAAA {
+			r.Trace.Note("the forward override of %s answers AAAA questions with no records", override.domain)
+			return override.noData()
+		}
+		pool, rule := forwarding.pool(r)
+		if rule != nil && rule.pool == iteratePool {
+			r.Trace.Note("forward rule %q has the question resolved iteratively", rule.text)
+			return next.ServeDNSQuestion(r)
+		}
+		if r.Trace != nil {
+			if rule != nil {
+				r.Trace.Note("forward rule %q picks pool %s", rule.text, pool.name)
+			}
+			if len(pool.servers) == 0 {
+				r.Trace.Note("no forwarders are configured in pool %s", pool.name)
+				return next.ServeDNSQuestion(r)
+			}
+			r.Trace.Note("would forward to %s of pool %s; a dry run sends no packets, so their answer is unknown", strings.Join(pool.candidates(r.Start), ", "), pool.name)
+			return nil
+		}
+		logger.Printf("  [%9.04fms] FORWARD %s %s to %s\n", msElapsed(r.Start, time.Now()), r.Question.Name, dns.Type(r.Question.Qtype).String(), pool.name)
+		answers := forwardQuestion(r, pool)
+		if override != nil {
+			answers = override.apply(answers)
+		}
+		if len(answers) > 0 {
+			return answers
+		}
+		return next.ServeDNSQuestion(r)
+	}), nil
+}
@@ -0,0 +1,80 @@
+package netcore
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSTTLHandlerNegative(t *testing.T) {
+	cfg := &Config{dnsMinTTL: 30, dnsNegMinTTL: 60, dnsNegMaxTTL: 600}
+	var upstream []dns.RR
+	h, err := newDNSTTLHandler(cfg, DNSHandlerFunc(func(r *DNSRequest) []dns.RR { return upstream }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &dns.Question{Name: "www.example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}
+
+	soa := &dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 5}, Minttl: 5}
+	upstream = []dns.RR{soa}
+	h.ServeDNSQuestion(&DNSRequest{Config: cfg, Question: q})
+	if soa.Hdr.Ttl != 60 {
+		t.Errorf("negative TTL clamped to %d, want 60", soa.Hdr.Ttl)
+	}
+
+	aaaa := &dns.AAAA{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 5}, AAAA: net.ParseIP("2001:db8::1")}
+	upstream = []dns.RR{aaaa}
+	h.ServeDNSQuestion(&DNSRequest{Config: cfg, Question: q})
+	if aaaa.Hdr.Ttl != 30 {
+		t.Errorf("TTL clamped to %d, want 30", aaaa.Hdr.Ttl)
+	}
+}
+
+func TestDNSCacheHandlerServFail(t *testing.T) {
+	cfg := &Config{dnsCacheMemory: 1 << 20, dnsCacheMaxRRset: defaultDNSCacheMaxRRset, dnsCacheMaxTTL: time.Hour, dnsCacheMissingTTL: time.Hour}
+	a := &dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.ParseIP("192.0.2.1")}
+	failing, asked := true, 0
+	h, err := newDNSCacheHandler(cfg, DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		asked++
+		if failing {
+			r.Rcode = dns.RcodeServerFailure
+			return nil
+		}
+		return []dns.RR{a}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ask := func() (*DNSRequest, []dns.RR) {
+		r := &DNSRequest{Config: cfg, Question: &dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, Start: time.Now()}
+		return r, h.ServeDNSQuestion(r)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if r, answers := ask(); r.Rcode != dns.RcodeServerFailure || len(answers) != 0 || asked != i {
+			t.Errorf("question %d while the backend fails: rcode %d, %d answers, backend asked %d times; want SERVFAIL, asked again", i, r.Rcode, len(answers), asked)
+		}
+	}
+	failing = false
+	if r, answers := ask(); r.Rcode != dns.RcodeSuccess || len(answers) != 1 || asked != 3 {
+		t.Errorf("once the backend is back: rcode %d, %d answers, backend asked %d times; want its answer", r.Rcode, len(answers), asked)
+	}
+}
+
+func TestDNSFailedFillsExpire(t *testing.T) {
+	f := newDNSFailedFills(-time.Second) // expired as soon as marked
+	key := dnsCacheKey{qclass: dns.ClassINET}
+	f.mark("www.example.com.", dns.TypeA, key)
+	if f.has("WWW.example.com.", dns.TypeA, key) || len(f.until) != 0 {
+		t.Errorf("an expired failure is kept: %v", f.until)
+	}
+	for i := 0; i < 3*dnsFailedFillsSweep; i++ {
+		f.mark("host"+strconv.Itoa(i)+".example.com.", dns.TypeA, key)
+	}
+	if len(f.until) >= dnsFailedFillsSweep {
+		t.Errorf("%d expired failures are kept, want them swept", len(f.until))
+	}
+}
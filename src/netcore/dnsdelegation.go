@@ -0,0 +1,141 @@
+package netcore
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// findDelegation returns the name of the closest delegation point at or above
+// name: a name with NS records but no SOA of its own. The search stops at the
+// first zone apex we are authoritative for, since nothing above it can
+// delegate name away from us.
+func findDelegation(cfg *Config, name string) (string, bool) {
+	parts := strings.Split(cleanFQDN(name), ".")
+	for i := 0; i < len(parts)-1; i++ {
+		cut := strings.Join(parts[i:], ".")
+		if found, err := cfg.db.HasDNS(cut, "SOA"); err == nil && found {
+			return "", false
+		}
+		if found, err := cfg.db.HasDNS(cut, "NS"); err == nil && found {
+			return cut, true
+		}
+	}
+	return "", false
+}
+
+// referral returns the NS records of the delegation covering the question,
+// followed by glue address records for any of those name servers that live
+// inside the delegated zone. It returns nil if the name is not delegated.
+func (h *dnsAuthoritativeHandler) referral(r *DNSRequest) []dns.RR {
+	cut, found := findDelegation(r.Config, r.Question.Name)
+	if !found {
+		return nil
+	}
+	entry, err := r.Config.db.GetDNS(cut, "NS")
+	if err != nil {
+		return nil
+	}
+	ttl := h.defaultTTL
+	if entry.TTL > 0 {
+		ttl = entry.TTL
+	}
+
+	var referral, glue []dns.RR
+	cutQuestion := &dns.Question{Name: dns.Fqdn(cut), Qtype: dns.TypeNS, Qclass: dns.ClassINET}
+	for i := range entry.Values {
+		value := &entry.Values[i]
+		if validateDNSValue(dns.TypeNS, value) != nil {
+			continue
+		}
+		ns := answerNS(cutQuestion, value)
+		ns.Header().Ttl = ttl
+		referral = append(referral, ns)
+
+		target := ns.(*dns.NS).Ns
+		if !dns.IsSubDomain(cutQuestion.Name, target) {
+			continue // glue is only needed for in-bailiwick name servers
+		}
+		glue = append(glue, fetchGlue(r.Config, target, ttl)...)
+	}
+	return append(referral, glue...)
+}
+
+// fetchGlue returns the address records for the name server target
+func fetchGlue(cfg *Config, target string, ttl uint32) []dns.RR {
+	var glue []dns.RR
+	for _, rrType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		entry, err := cfg.db.GetDNS(target, dns.Type(rrType).String())
+		if err != nil {
+			continue
+		}
+		q := &dns.Question{Name: target, Qtype: rrType, Qclass: dns.ClassINET}
+		for i := range entry.Values {
+			value := &entry.Values[i]
+			if validateDNSValue(rrType, value) != nil {
+				continue
+			}
+			var rr dns.RR
+			if rrType == dns.TypeA {
+				rr = answerA(q, value)
+			} else {
+				rr = answerAAAA(q, value)
+			}
+			rr.Header().Ttl = ttl
+			glue = append(glue, rr)
+		}
+	}
+	return glue
+}
+
+// splitReferral separates the delegation NS records and glue returned by the
+// authoritative handler, and the SOA and NSEC records proving that q has no
+// answers, from the answers to q, so that they can be placed in the
+// authority and additional sections of the response
+func splitReferral(q *dns.Question, rrs []dns.RR) (answers, ns, extra []dns.RR) {
+	name := strings.ToLower(q.Name)
+	isReferral := func(rr dns.RR) bool {
+		owner := strings.ToLower(rr.Header().Name)
+		_, isNS := rr.(*dns.NS)
+		return isNS && owner != name && dns.IsSubDomain(owner, name)
+	}
+
+	targets := make(map[string]bool)
+	for _, rr := range rrs {
+		if isReferral(rr) {
+			targets[strings.ToLower(rr.(*dns.NS).Ns)] = true
+		}
+	}
+
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		rrType := rr.Header().Rrtype
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			rrType = sig.TypeCovered
+		}
+		switch {
+		case isReferral(rr):
+			ns = append(ns, rr)
+		case (rrType == dns.TypeSOA || rrType == dns.TypeNSEC) && (rrType != q.Qtype || owner != name):
+			ns = append(ns, rr)
+		case targets[owner] && (rrType == dns.TypeA || rrType == dns.TypeAAAA):
+			// Glue stays glue even when it is what was asked for: the
+			// name belongs to the delegated zone, not to us
+			extra = append(extra, rr)
+		default:
+			answers = append(answers, rr)
+		}
+	}
+	return answers, ns, extra
+}
+
+// hasDelegation reports whether the authority records split from answers
+// are a referral
+func hasDelegation(ns []dns.RR) bool {
+	for _, rr := range ns {
+		if _, isNS := rr.(*dns.NS); isNS {
+			return true
+		}
+	}
+	return false
+}
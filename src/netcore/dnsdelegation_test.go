@@ -0,0 +1,20 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSplitReferralGlue(t *testing.T) {
+	ns := &dns.NS{Hdr: dns.RR_Header{Name: "child.example.com.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 300}, Ns: "ns1.child.example.com."}
+	glue := &dns.A{Hdr: dns.RR_Header{Name: "ns1.child.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.ParseIP("192.0.2.53")}
+	for _, name := range []string{"www.child.example.com.", "ns1.child.example.com."} {
+		q := &dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET}
+		answers, authority, extra := splitReferral(q, []dns.RR{ns, glue})
+		if len(answers) != 0 || len(authority) != 1 || len(extra) != 1 {
+			t.Errorf("%s: got %d answers, %d authority, %d additional; want a referral with glue", name, len(answers), len(authority), len(extra))
+		}
+	}
+}
@@ -1,32 +1,30 @@
-package main
+package netcore
 
 import (
 	"crypto/sha1"
 	"fmt"
-	"log"
 	"net"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-etcd/etcd"
 )
 
-func (db EtcdDB) InitDNS() {
-	db.client.CreateDir("dns", 0)
-}
-
 func (db EtcdDB) GetDNS(name string, rrType string) (*DNSEntry, error) {
-	//log.Printf("[Lookup [%s] [%s]]\n", q.Name, qType)
-	rrType = strings.ToLower(rrType)
-	key := etcdDNSKeyFromFQDN(name) + "/@" + rrType // structure the lookup key
+	return getDNS(db.client, name, rrType)
+}
 
-	response, err := db.client.Get(key, true, true) // do the lookup
+func getDNS(etc etcdKV, name string, rrType string) (*DNSEntry, error) {
+	//logger.Printf("[Lookup [%s] [%s]]\n", q.Name, qType)
+	response, err := etc.Get(dnsRRSetKey(name, rrType), true, true) // do the lookup
 	if err != nil {
 		return nil, err
 	}
 
-	if response != nil && response.Node != nil && len(response.Node.Nodes) > 0 {
-		if rrType == "cname" {
+	if response != nil && response.Node != nil && (!response.Node.Dir || len(response.Node.Nodes) > 0) {
+		if strings.ToLower(rrType) == "cname" {
 			// FIXME: Check for infinite recursion?
 		}
 		return etcdNodeToDNSEntry(response.Node), nil
@@ -36,69 +34,273 @@ func (db EtcdDB) GetDNS(name string, rrType string) (*DNSEntry, error) {
 }
 
 func (db EtcdDB) HasDNS(name string, rrType string) (bool, error) {
-	rrType = strings.ToLower(rrType)
-	key := etcdDNSKeyFromFQDN(name) + "/@" + rrType // structure the lookup key
+	return hasDNS(db.client, name, rrType)
+}
 
-	response, err := db.client.Get(key, false, false) // do the lookup
+func hasDNS(etc etcdKV, name string, rrType string) (bool, error) {
+	response, err := etc.Get(dnsRRSetKey(name, rrType), false, false) // do the lookup
 	if err != nil {
 		return false, err
 	}
 
-	if response != nil && response.Node != nil && response.Node.Dir {
+	// An RRset is a directory in the key per value layout, and a value in
+	// the current one
+	if response != nil && response.Node != nil {
 		return true, nil
 	}
 
 	return false, nil
 }
 
+func (db EtcdDB) HasDNSName(name string) (bool, error) {
+	return hasDNSName(db.client, strings.TrimPrefix(etcdDNSKeyFromFQDN(name), "/"))
+}
+
+// hasDNSName reports whether there is an RRset at key, the directory of a
+// name, or under the names below it. Directories that expired values left
+// empty do not count, so it looks down the tree, a level at a time, until
+// it finds an RRset.
+func hasDNSName(etc etcdKV, key string) (bool, error) {
+	response, err := etc.Get(key, true, false)
+	if etcdKeyNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if response == nil || response.Node == nil || !response.Node.Dir {
+		return false, nil
+	}
+	for _, child := range response.Node.Nodes {
+		if strings.HasPrefix(path.Base(child.Key), "@") {
+			return true, nil
+		}
+	}
+	for _, child := range response.Node.Nodes {
+		if !child.Dir {
+			continue
+		}
+		if found, err := hasDNSName(etc, strings.TrimPrefix(child.Key, "/")); err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// RegisterA points fqdn at ip, and ip back at fqdn, until expiration. The
+// name keeps its other addresses, unless exclusive takes it over: they are
+// removed, with their PTR records back to the name, and the removal is
+// audited.
 func (db EtcdDB) RegisterA(fqdn string, ip net.IP, exclusive bool, ttl uint32, expiration uint64) error {
 	fqdn = cleanFQDN(fqdn)
+	if !validDomainName(fqdn) {
+		return fmt.Errorf("cannot register %q: not a domain name", fqdn)
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("cannot register %s: %q is not an IPv4 address", fqdn, ip.String())
+	}
 	ipString := ip.String()
-	ttlString := fmt.Sprintf("%d", ttl)
 	ipHash := fmt.Sprintf("%x", sha1.Sum([]byte(ipString))) // hash the IP address so we can have a unique key name (no other reason for this, honestly)
 	fqdnHash := fmt.Sprintf("%x", sha1.Sum([]byte(fqdn)))   // hash the hostname so we can have a unique key name (no other reason for this, honestly)
+	expires := dnsExpiration(expiration, time.Now())
 
 	// Register the A record
-	aKey := etcdDNSKeyFromFQDN(fqdn) + "/@a"
-	log.Printf("[REGISTER] [%s %d] %s. %d IN A %s\n", aKey, expiration, fqdn, ttl, ipString)
-	_, err := db.client.Set(aKey+"/val/"+ipHash, ipString, expiration)
+	var taken []string
+	logger.Printf("[REGISTER] [%d] %s. %d IN A %s\n", expiration, fqdn, ttl, ipString)
+	before, err := updateDNSRRSet(db.client, fqdn, "a", func(entry *DNSEntry) error {
+		taken = nil
+		if exclusive {
+			kept := entry.Values[:0]
+			for _, value := range entry.Values {
+				if value.Value != ipString {
+					taken = append(taken, value.Value)
+					continue
+				}
+				kept = append(kept, value)
+			}
+			entry.Values = kept
+		}
+		entry.setValue(DNSValue{ID: ipHash, Value: ipString, Expiration: expires})
+		if ttl != 0 {
+			entry.TTL = ttl
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if ttl != 0 {
-		_, err := db.client.Set(aKey+"/ttl", ttlString, expiration)
-		if err != nil {
-			return err
+	old := ""
+	if before != nil && before.value(ipHash) != nil {
+		old = ipString
+	}
+	auditChange(db, "dhcp", "dns", fqdn+" A", "set", old, ipString)
+	for _, value := range taken {
+		auditChange(db, "dhcp", "dns", fqdn+" A", "delete", value, "")
+		if other := net.ParseIP(value); other != nil && other.To4() != nil {
+			_, err := updateDNSRRSet(db.client, arpaNameFromIP(other), "ptr", func(entry *DNSEntry) error {
+				entry.removeValue(fqdnHash)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	// Register the PTR record
-	ptrKey := etcdDNSArpaKeyFromIP(ip) + "/@ptr"
-	log.Printf("[REGISTER] [%s %d] %s. %d IN A %s\n", ptrKey, expiration, fqdn, ttl, ipString)
-	_, err = db.client.Set(ptrKey+"/val/"+fqdnHash, fqdn, expiration)
+	logger.Printf("[REGISTER] [%d] %s. %d IN PTR %s\n", expiration, arpaNameFromIP(ip), ttl, fqdn)
+	before, err = updateDNSRRSet(db.client, arpaNameFromIP(ip), "ptr", func(entry *DNSEntry) error {
+		entry.setValue(DNSValue{ID: fqdnHash, Value: fqdn, Expiration: expires})
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if ttl != 0 {
-		_, err := db.client.Set(aKey+"/ttl", ttlString, expiration)
-		if err != nil {
+	old = ""
+	if before != nil && before.value(fqdnHash) != nil {
+		old = fqdn
+	}
+	auditChange(db, "dhcp", "dns", arpaNameFromIP(ip)+" PTR", "set", old, fqdn)
+
+	// Let secondaries know that the zones have changed
+	for _, name := range []string{fqdn, arpaNameFromIP(ip)} {
+		if err := db.bumpDNSSerial(name); err != nil {
+			logger.Printf("[REGISTER] Unable to bump the SOA serial for %s: %s\n", name, err)
+		}
+	}
+
+	return nil
+}
+
+// addressValuePrefix marks the values of RegisterAddress, which the DDNS
+// GC leaves alone as they are not DHCP's
+const addressValuePrefix = "addr-"
+
+// RegisterAddress points fqdn at ip, with an A or AAAA record, until
+// expiration, and ip back at fqdn with a PTR record if ptr is set. Values
+// that a static record already gives are left as they are, and only new
+// values are logged and audited, so that registrations can be renewed often.
+func (db EtcdDB) RegisterAddress(actor, fqdn string, ip net.IP, ptr bool, expiration uint64) error {
+	fqdn = cleanFQDN(fqdn)
+	if !validDomainName(fqdn) {
+		return fmt.Errorf("cannot register %q: not a domain name", fqdn)
+	}
+	if ip.To16() == nil {
+		return fmt.Errorf("cannot register %s: %q is not an IP address", fqdn, ip.String())
+	}
+	rrType := "aaaa"
+	if ip.To4() != nil {
+		rrType = "a"
+	}
+	expires := dnsExpiration(expiration, time.Now())
+	register := func(name, rrType, value string) error {
+		staticID := fmt.Sprintf("%x", sha1.Sum([]byte(value))) // as a static record of the value has it
+		id := addressValuePrefix + staticID
+		before, err := updateDNSRRSet(db.client, name, rrType, func(entry *DNSEntry) error {
+			if static := entry.value(staticID); static == nil || static.Expiration != nil {
+				entry.setValue(DNSValue{ID: id, Value: value, Expiration: expires})
+			}
+			return nil
+		})
+		if err != nil || before != nil && (before.value(id) != nil || before.value(staticID) != nil) {
 			return err
 		}
+		logger.Printf("[REGISTER] [%d] %s. IN %s %s\n", expiration, name, strings.ToUpper(rrType), value)
+		auditChange(db, actor, "dns", name+" "+strings.ToUpper(rrType), "set", "", value)
+		if err := db.bumpDNSSerial(name); err != nil {
+			logger.Printf("[REGISTER] Unable to bump the SOA serial for %s: %s\n", name, err)
+		}
+		return nil
 	}
+	if err := register(fqdn, rrType, ip.String()); err != nil || !ptr {
+		return err
+	}
+	return register(arpaNameFromIP(ip), "ptr", fqdn)
+}
+
+// findDNSZone returns the name of the closest enclosing zone that we have an
+// SOA for, or false if there isn't one
+func findDNSZone(etc etcdKV, name string) (string, bool) {
+	parts := strings.Split(cleanFQDN(name), ".")
+	for i := range parts {
+		zone := strings.Join(parts[i:], ".")
+		if found, err := hasDNS(etc, zone, "soa"); err == nil && found {
+			return zone, true
+		}
+	}
+	return "", false
+}
 
+// bumpDNSSerial advances the SOA serial of the zone containing name, if we
+// are authoritative for one, and has its secondaries notified. Concurrent
+// bumps are resolved with compare-and-swap so that no change goes unnoticed.
+func (db EtcdDB) bumpDNSSerial(name string) error {
+	zone, found := findDNSZone(db.client, name)
+	if !found {
+		return nil
+	}
+	var secondaries string
+	_, err := updateDNSRRSet(db.client, zone, "soa", func(entry *DNSEntry) error {
+		old, _ := strconv.ParseUint(entry.Meta["serial"], 10, 32)
+		if entry.Meta == nil {
+			entry.Meta = make(map[string]string)
+		}
+		entry.Meta["serial"] = strconv.FormatUint(uint64(nextSOASerial(uint32(old), time.Now())), 10)
+		secondaries = entry.Meta["secondaries"]
+		return nil
+	})
+	if ErrorKind(err) == ErrConflict {
+		return fmt.Errorf("too much contention for the SOA serial of %s", zone)
+	}
+	if err == nil {
+		targets, _ := parseSOASecondaries(secondaries)
+		notifier.schedule(zone, targets)
+	}
 	return err
 }
 
+// SetDNSMeta stores the given metadata on the entry for name and rrType,
+// leaving any other metadata and values in place
+func (db EtcdDB) SetDNSMeta(name string, rrType string, meta map[string]string) error {
+	_, err := updateDNSRRSet(db.client, name, rrType, func(entry *DNSEntry) error {
+		if entry.Meta == nil {
+			entry.Meta = make(map[string]string)
+		}
+		for k, v := range meta {
+			entry.Meta[k] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return db.bumpDNSSerial(name)
+}
+
+// etcdNodeToDNSEntry reads the RRset stored at root, in either layout
 func etcdNodeToDNSEntry(root *etcd.Node) *DNSEntry {
+	if !root.Dir {
+		return decodeDNSRRSet(root)
+	}
 	entry := &DNSEntry{}
+	var migrated *etcd.Node
 	for _, node := range root.Nodes {
 		key := strings.Replace(node.Key, root.Key+"/", "", 1)
+		if key == dnsRRSetMigrated {
+			migrated = node
+			continue
+		}
 		if node.Dir {
 			if key == "val" {
 				entry.Values = make([]DNSValue, len(node.Nodes))
 				for i, child := range node.Nodes {
 					etcdNodeToDNSValue(child, &entry.Values[i])
 				}
+			} else if key == "labels" {
+				entry.Labels = make(map[string]string)
+				for _, child := range node.Nodes {
+					entry.Labels[strings.Replace(child.Key, node.Key+"/", "", 1)] = child.Value
+				}
 			}
 		} else {
 			switch key {
@@ -115,10 +317,16 @@ func etcdNodeToDNSEntry(root *etcd.Node) *DNSEntry {
 			}
 		}
 	}
+	if migrated != nil {
+		rewritten := decodeDNSRRSet(migrated)
+		rewritten.overlay(entry)
+		return rewritten
+	}
 	return entry
 }
 
 func etcdNodeToDNSValue(node *etcd.Node, value *DNSValue) {
+	value.ID = path.Base(node.Key)
 	value.Expiration = node.Expiration
 
 	if node.TTL > 0 {
@@ -136,8 +344,15 @@ func etcdNodeToDNSValue(node *etcd.Node, value *DNSValue) {
 	}
 }
 
+// cleanFQDN returns the canonical form of a name: lower case, without the
+// trailing dot, and ASCII for internationalized names. Names that have no
+// ASCII form are left for validDomainName to refuse.
 func cleanFQDN(fqdn string) string {
-	return strings.ToLower(strings.TrimSuffix(fqdn, "."))
+	fqdn = strings.ToLower(strings.TrimSuffix(fqdn, "."))
+	if ascii, err := idnaToASCII(fqdn); err == nil {
+		return ascii
+	}
+	return fqdn
 }
 
 func etcdDNSKeyFromFQDN(fqdn string) string {
@@ -146,8 +361,15 @@ func etcdDNSKeyFromFQDN(fqdn string) string {
 	return "/dns/" + path
 }
 
-func etcdDNSArpaKeyFromIP(ip net.IP) string {
-	// FIXME: Support IPv6 addresses
-	slashedIP := strings.Replace(ip.To4().String(), ".", "/", -1)
-	return "dns/arpa/in-addr/" + slashedIP
+func arpaNameFromIP(ip net.IP) string {
+	if ip.To4() != nil {
+		return strings.Join(reverseSlice(strings.Split(ip.To4().String(), ".")), ".") + ".in-addr.arpa"
+	}
+	const hex = "0123456789abcdef"
+	ip = ip.To16()
+	nibbles := make([]byte, 0, 4*len(ip))
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, hex[ip[i]&0xf], '.', hex[ip[i]>>4], '.')
+	}
+	return string(nibbles) + "ip6.arpa"
 }
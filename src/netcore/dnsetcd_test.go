@@ -0,0 +1,48 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+)
+
+func TestArpaNameFromIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		arpa string
+	}{
+		{"192.0.2.1", "1.2.0.192.in-addr.arpa"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+	}
+	for _, test := range tests {
+		if arpa := arpaNameFromIP(net.ParseIP(test.ip)); arpa != test.arpa {
+			t.Errorf("arpaNameFromIP(%s) = %s, want %s", test.ip, arpa, test.arpa)
+		}
+	}
+}
+
+func TestHasDNSName(t *testing.T) {
+	db := NewMemoryDB()
+	db.client.Set(dnsRRSetKey("www.a.b.example.com", "A"), `{"values":[{"value":"192.0.2.1"}]}`, 0)
+	db.client.CreateDir("dns/com/example/gone", 0) // left empty by values that expired
+	tests := []struct {
+		name   string
+		exists bool
+	}{
+		{"www.a.b.example.com.", true},
+		{"a.b.example.com.", true}, // empty non-terminals
+		{"B.example.com.", true},
+		{"example.com.", true},
+		{"c.example.com.", false},
+		{"gone.example.com.", false},
+		{"x.www.a.b.example.com.", false},
+	}
+	for _, test := range tests {
+		exists, err := db.HasDNSName(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != test.exists {
+			t.Errorf("HasDNSName(%s) = %v, want %v", test.name, exists, test.exists)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package netcore
+
+import (
+	"expvar"
+	"time"
+)
+
+var expireInterval = Flags.Duration("expireinterval", time.Minute, "How often the leader removes expired DNS values, and the entries they leave without values (0 to disable).")
+
+// dnsExpired counts what the sweeper reclaims, published with expvar
+var dnsExpired = expvar.NewMap("dns_expired")
+
+// sweepExpiredDNS is the "expire" duty: it removes expired DNS values, which
+// are skipped when answering but may outlive their expiration in the
+// database, values whose window ended (see dnsschedule.go), and the entries
+// left with no values, which would otherwise be found and answer nothing
+func sweepExpiredDNS(cfg *Config) error {
+	values, entries, err := cfg.db.SweepExpiredDNS(time.Now())
+	dnsExpired.Add("sweeps", 1)
+	dnsExpired.Add("values", int64(values))
+	dnsExpired.Add("entries", int64(entries))
+	if values > 0 || entries > 0 {
+		debugf("Expired %d DNS values and %d empty entries\n", values, entries)
+	}
+	return err
+}
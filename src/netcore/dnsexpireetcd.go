@@ -0,0 +1,75 @@
+package netcore
+
+import (
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// SweepExpiredDNS removes the values under /dns that expired before now or
+// whose scheduled window ended, and the entries other than SOA that are left
+// with nothing but a TTL. RRsets are only written back if they have not
+// changed since they were read, so values registered meanwhile are never
+// lost.
+func (db EtcdDB) SweepExpiredDNS(now time.Time) (values, entries int, err error) {
+	response, err := db.client.Get("dns", false, true)
+	if etcdKeyNotFound(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	walkDNSRRSets(response.Node, func(name, rrType string, node *etcd.Node) {
+		if rrType == "SOA" || !dnsEntryStale(etcdNodeToDNSEntry(node), now) {
+			return
+		}
+		removed, emptied := 0, false
+		_, err := updateDNSRRSet(db.client, name, rrType, func(entry *DNSEntry) error {
+			removed = 0
+			kept := entry.Values[:0]
+			for i := range entry.Values {
+				if dnsValueExpired(&entry.Values[i], now) {
+					removed++
+					continue
+				}
+				kept = append(kept, entry.Values[i])
+			}
+			entry.Values = kept
+			emptied = entry.empty()
+			return nil
+		})
+		if err != nil {
+			logger.Printf("[DNS EXPIRE] Unable to sweep %s %s: %s\n", name, rrType, err)
+			return
+		}
+		values += removed
+		if emptied {
+			entries++
+		}
+	})
+	return values, entries, nil
+}
+
+// dnsValueExpired reports whether value expired before now, or its scheduled
+// window ended
+func dnsValueExpired(value *DNSValue, now time.Time) bool {
+	if value.Expiration != nil && !value.Expiration.After(now) {
+		return true
+	}
+	s, err := parseDNSSchedule(value.Attr)
+	return err == nil && s != nil && s.ended(now)
+}
+
+// dnsEntryStale reports whether entry has values to sweep, or holds nothing
+// but its TTL
+func dnsEntryStale(entry *DNSEntry, now time.Time) bool {
+	if entry.empty() {
+		return true
+	}
+	for i := range entry.Values {
+		if dnsValueExpired(&entry.Values[i], now) {
+			return true
+		}
+	}
+	return false
+}
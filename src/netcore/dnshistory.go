@@ -0,0 +1,217 @@
+package netcore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var dnsHistoryRetention = Flags.Duration("dnshistoryretention", 30*24*time.Hour, "How long earlier versions of DNS records are kept for restoring.")
+
+// DNSHistoryDB keeps the earlier versions of static DNS records. Every
+// transaction of ApplyDNSChanges records a version of each name and type it
+// touched; versions expire after the retention period.
+type DNSHistoryDB interface {
+	// DNSHistory returns the versions of the records of zone and the names
+	// below it, oldest first, only those of name and rrType if given
+	DNSHistory(zone, name, rrType string) ([]DNSRecordVersion, error)
+}
+
+// DNSRecordVersion is a change to the records of a name and type: what they
+// were before and after it. A name and type without records, before it was
+// added or after it was deleted, has none.
+type DNSRecordVersion struct {
+	Time  time.Time   `json:"time"`
+	Actor string      `json:"actor"`
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Old   []DNSRecord `json:"old"`
+	New   []DNSRecord `json:"new"`
+}
+
+// unicode puts the names of the version and its records in their Unicode
+// form, for display
+func (v *DNSRecordVersion) unicode() {
+	v.Name = idnaToUnicode(v.Name)
+	for i := range v.Old {
+		v.Old[i] = unicodeRecord(v.Old[i])
+	}
+	for i := range v.New {
+		v.New[i] = unicodeRecord(v.New[i])
+	}
+}
+
+// byVersionTime orders versions from the oldest
+type byVersionTime []DNSRecordVersion
+
+func (v byVersionTime) Len() int           { return len(v) }
+func (v byVersionTime) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byVersionTime) Less(i, j int) bool { return v[i].Time.Before(v[j].Time) }
+
+// dnsRecordsAt returns what the records of each name and type in versions
+// were at t, keyed by name and type. Versions must be oldest first. Names and
+// types without versions did not change since, and are left out.
+func dnsRecordsAt(versions []DNSRecordVersion, t time.Time) map[string][]DNSRecord {
+	at := make(map[string][]DNSRecord)
+	for _, v := range versions {
+		key := v.Name + " " + v.Type
+		if _, seen := at[key]; !seen {
+			at[key] = v.Old // as they were before the first change we know of
+		}
+		if !v.Time.After(t) {
+			at[key] = v.New
+		}
+	}
+	return at
+}
+
+// dnsRestoreChanges returns the changes that put the records of each name
+// and type in versions back from current to as they were at t. SOA records,
+// which hold the zone's settings rather than data, are not restored.
+func dnsRestoreChanges(current []DNSRecord, versions []DNSRecordVersion, t time.Time) []DNSChange {
+	now := make(map[string][]DNSRecord)
+	for _, record := range current {
+		key := record.Name + " " + record.Type
+		now[key] = append(now[key], record)
+	}
+	at := dnsRecordsAt(versions, t)
+	keys := make([]string, 0, len(at))
+	for key := range at {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var changes []DNSChange
+	for _, key := range keys {
+		fields := strings.SplitN(key, " ", 2)
+		name, rrType := fields[0], fields[1]
+		if rrType == "SOA" || sameDNSRecords(now[key], at[key]) {
+			continue
+		}
+		if len(now[key]) > 0 {
+			changes = append(changes, DNSChange{Op: "delete", Record: DNSRecord{Name: name, Type: rrType}})
+		}
+		for _, record := range at[key] {
+			changes = append(changes, DNSChange{Op: "add", Record: record})
+		}
+	}
+	return changes
+}
+
+// sameDNSRecords returns true if a and b hold the same values, TTL and
+// labels, in any order
+func sameDNSRecords(a, b []DNSRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	describe := func(records []DNSRecord) []string {
+		s := make([]string, 0, len(records))
+		for _, r := range records {
+			s = append(s, fmt.Sprintf("%s ttl=%d", r.String(), r.TTL))
+		}
+		sort.Strings(s)
+		return s
+	}
+	left, right := describe(a), describe(b)
+	for i := range left {
+		if left[i] != right[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// apiDNSHistory lists the versions of a zone's records, or of one name and
+// type, with the name and type query parameters
+func apiDNSHistory(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request, zone string) {
+	name, rrType := cleanFQDN(r.URL.Query().Get("name")), strings.ToUpper(r.URL.Query().Get("type"))
+	versions, err := cfg.db.DNSHistory(zone, name, rrType)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	versions = id.manageableVersions(cfg, zone, versions)
+	if versions == nil {
+		versions = []DNSRecordVersion{}
+	}
+	if r.URL.Query().Get("unicode") == "true" {
+		for i := range versions {
+			versions[i].unicode()
+		}
+	}
+	apiWriteJSON(w, http.StatusOK, versions)
+}
+
+// manageableVersions returns those of versions, read from zone, of names
+// that the caller may manage, as manageableRecords does for records
+func (id apiIdentity) manageableVersions(cfg *Config, zone string, versions []DNSRecordVersion) []DNSRecordVersion {
+	if id.Admin {
+		return versions
+	}
+	may := make(map[string]bool)
+	var kept []DNSRecordVersion
+	for _, version := range versions {
+		allowed, ok := may[version.Name]
+		if !ok {
+			allowed = id.canManageName(cfg, zone, version.Name)
+			may[version.Name] = allowed
+		}
+		if allowed {
+			kept = append(kept, version)
+		}
+	}
+	return kept
+}
+
+// apiDNSRestore puts the records of a zone, or of one name and type, back
+// as they were at a time, as a single transaction
+func apiDNSRestore(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request, zone string) {
+	var body struct {
+		Time time.Time `json:"time"`
+		Name string    `json:"name"`
+		Type string    `json:"type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		apiWriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Time.IsZero() {
+		apiWriteError(w, http.StatusBadRequest, invalid("time", "the time to restore to is required"))
+		return
+	}
+	if body.Time.After(time.Now()) {
+		apiWriteError(w, http.StatusBadRequest, invalid("time", "%s is in the future", body.Time.Format(time.RFC3339)))
+		return
+	}
+	if time.Since(body.Time) > *dnsHistoryRetention {
+		apiWriteError(w, http.StatusBadRequest, invalid("time", "history is only kept for %s", *dnsHistoryRetention))
+		return
+	}
+	name, rrType := cleanFQDN(body.Name), strings.ToUpper(body.Type)
+	if rrType != "" && name == "" {
+		apiWriteError(w, http.StatusBadRequest, errors.New("a type can only be restored with its name"))
+		return
+	}
+	if name != "" && !dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(name)) {
+		apiWriteError(w, http.StatusBadRequest, invalid("name", "%s is not in zone %s", name, zone))
+		return
+	}
+	versions, err := cfg.db.DNSHistory(zone, name, rrType)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	current, err := cfg.db.ListDNSZone(zone)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	versions, current = id.manageableVersions(cfg, zone, versions), id.manageableRecords(cfg, zone, current)
+	apiApplyDNSChanges(cfg, id, w, zone, dnsRestoreChanges(current, versions, body.Time), r.URL.Query().Get("force") == "true")
+}
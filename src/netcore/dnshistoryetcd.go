@@ -0,0 +1,102 @@
+package netcore
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Versions of DNS records are stored in order of creation under
+// dnshistory/<name as in /dns>/@<type>, and expire once they are older than
+// the retention period
+
+// dnsHistoryKey is where the versions of the records of name and rrType are
+// kept
+func dnsHistoryKey(name, rrType string) string {
+	return "dnshistory" + strings.TrimPrefix(etcdDNSKeyFromFQDN(name), "/dns") + "/@" + strings.ToLower(rrType)
+}
+
+func (db EtcdDB) DNSHistory(zone, name, rrType string) ([]DNSRecordVersion, error) {
+	key := "dnshistory" + strings.TrimPrefix(etcdDNSKeyFromFQDN(zone), "/dns")
+	if name != "" {
+		key = "dnshistory" + strings.TrimPrefix(etcdDNSKeyFromFQDN(name), "/dns")
+		if rrType != "" {
+			key = dnsHistoryKey(name, rrType)
+		}
+	}
+	response, err := db.client.Get(key, true, true)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []DNSRecordVersion
+	var walk func(node *etcd.Node)
+	walk = func(node *etcd.Node) {
+		for _, child := range node.Nodes {
+			if child.Dir {
+				walk(child)
+				continue
+			}
+			var version DNSRecordVersion
+			if err := json.Unmarshal([]byte(child.Value), &version); err != nil {
+				logger.Printf("[DNS HISTORY] Skipping unreadable version %s: %s\n", child.Key, err)
+				continue
+			}
+			// Only the name itself, not those below it, unless the whole
+			// zone was asked for
+			if name == "" || version.Name == name {
+				versions = append(versions, version)
+			}
+		}
+	}
+	if response.Node.Dir {
+		walk(response.Node)
+	}
+	sort.Stable(byVersionTime(versions))
+	return versions, nil
+}
+
+// dnsRRSets returns the static records of each name and type that changes
+// touch, keyed by name and type
+func (db EtcdDB) dnsRRSets(changes []DNSChange) (map[string][]DNSRecord, error) {
+	rrsets := make(map[string][]DNSRecord)
+	for _, change := range changes {
+		r := change.Record
+		key := r.Name + " " + r.Type
+		if _, done := rrsets[key]; done {
+			continue
+		}
+		records, err := db.getDNSRRSet(r.Name, r.Type)
+		if err != nil {
+			return nil, err
+		}
+		rrsets[key] = records
+	}
+	return rrsets, nil
+}
+
+// recordDNSHistory records a version of each name and type in before, as
+// they were before a transaction and as it left them, if it changed them. Failure to record is
+// logged rather than failing the transaction, which is already made.
+func (db EtcdDB) recordDNSHistory(actor string, before map[string][]DNSRecord) {
+	now := time.Now().UTC()
+	for key, old := range before {
+		fields := strings.SplitN(key, " ", 2)
+		version := DNSRecordVersion{Time: now, Actor: actor, Name: fields[0], Type: fields[1], Old: old}
+		var err error
+		if version.New, err = db.getDNSRRSet(version.Name, version.Type); err == nil && !sameDNSRecords(old, version.New) {
+			var data []byte
+			if data, err = json.Marshal(version); err == nil {
+				_, err = db.client.CreateInOrder(dnsHistoryKey(version.Name, version.Type), string(data), uint64(dnsHistoryRetention.Seconds()))
+			}
+		}
+		if err != nil {
+			logger.Printf("[DNS HISTORY] Unable to record the change of %s by %s: %s\n", key, actor, err)
+		}
+	}
+}
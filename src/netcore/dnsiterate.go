@@ -0,0 +1,318 @@
+package netcore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var rootHintsFile = Flags.String("roothints", "", "File of root name server records (named.root format) for iterative resolution; the built-in hints are used if empty.")
+
+// builtinRootHints are the IPv4 addresses of the root name servers
+var builtinRootHints = []string{
+	"198.41.0.4",     // a.root-servers.net
+	"170.247.170.2",  // b.root-servers.net
+	"192.33.4.12",    // c.root-servers.net
+	"199.7.91.13",    // d.root-servers.net
+	"192.203.230.10", // e.root-servers.net
+	"192.5.5.241",    // f.root-servers.net
+	"192.112.36.4",   // g.root-servers.net
+	"198.97.190.53",  // h.root-servers.net
+	"192.36.148.17",  // i.root-servers.net
+	"192.58.128.30",  // j.root-servers.net
+	"193.0.14.129",   // k.root-servers.net
+	"199.7.83.42",    // l.root-servers.net
+	"202.12.27.33",   // m.root-servers.net
+}
+
+const (
+	maxReferrals = 24 // delegations followed for one name
+	maxAliases   = 8  // CNAMEs followed for one question
+	maxNSLookups = 3  // nested lookups of glueless name server addresses
+)
+
+var errResolutionLimit = errors.New("resolution limit reached")
+
+// iterativeResolver answers questions by following referrals down from the
+// root name servers, remembering the delegations it learns along the way
+type iterativeResolver struct {
+	sync.Mutex
+	roots       []string // host:port
+	timeout     time.Duration
+	delegations map[string]delegation // zone name to its servers
+}
+
+type delegation struct {
+	servers []string // host:port
+	expires time.Time
+}
+
+// newDNSIterativeHandler resolves questions itself, starting at the root
+// name servers, for zones that select the iterate resolver mode, and for the
+// clients that forward rules send to the iterate pool in the others
+func newDNSIterativeHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	var forwarding *dnsForwarding
+	if cfg.DNSResolver() != "iterate" {
+		var err error
+		if forwarding, err = newDNSForwarding(cfg); err != nil {
+			return nil, err
+		}
+		if !forwarding.iterates() {
+			return next, nil
+		}
+	}
+	roots, err := loadRootHints(*rootHintsFile)
+	if err != nil {
+		return nil, err
+	}
+	resolver := &iterativeResolver{
+		roots:       roots,
+		timeout:     2 * time.Second,
+		delegations: make(map[string]delegation),
+	}
+	special, err := loadDNSSpecialUse(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		if !special.upstream(r) {
+			return next.ServeDNSQuestion(r)
+		}
+		if forwarding != nil {
+			if _, rule := forwarding.pool(r); rule == nil || rule.pool != iteratePool {
+				return next.ServeDNSQuestion(r)
+			}
+		}
+		if r.Trace != nil {
+			r.Trace.Note("would resolve the question from the root name servers; a dry run sends no packets, so the answer is unknown")
+			return nil
+		}
+		logger.Printf("  [%9.04fms] ITERATE %s %s\n", msElapsed(r.Start, time.Now()), r.Question.Name, dns.Type(r.Question.Qtype).String())
+		span := r.Span.Child("dns.iterate", spanClient)
+		answers, err := resolver.resolve(*r.Question, 0)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			logger.Printf("  [%9.04fms] ITERATE %s failed: %s\n", msElapsed(r.Start, time.Now()), r.Question.Name, err)
+			if len(answers) == 0 {
+				r.Rcode = dns.RcodeServerFailure
+			}
+		}
+		if len(answers) > 0 {
+			return answers
+		}
+		return next.ServeDNSQuestion(r)
+	}), nil
+}
+
+// loadRootHints reads the addresses of the root name servers from a hints
+// file, or returns the built-in hints if file is empty
+func loadRootHints(file string) ([]string, error) {
+	addrs := builtinRootHints
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		addrs = nil
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, ";") {
+				continue
+			}
+			rr, err := dns.NewRR(line)
+			if err != nil {
+				return nil, fmt.Errorf("root hints %s: %s", file, err)
+			}
+			if a, ok := rr.(*dns.A); ok {
+				addrs = append(addrs, a.A.String())
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("root hints %s: no IPv4 addresses", file)
+		}
+	}
+	roots := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		roots = append(roots, net.JoinHostPort(addr, "53"))
+	}
+	return roots, nil
+}
+
+// resolve answers q, following CNAMEs to their targets
+func (res *iterativeResolver) resolve(q dns.Question, nsDepth int) ([]dns.RR, error) {
+	var answers []dns.RR
+	name := q.Name
+	for aliases := 0; aliases <= maxAliases; aliases++ {
+		rrs, err := res.lookup(dns.Question{Name: name, Qtype: q.Qtype, Qclass: dns.ClassINET}, nsDepth)
+		if err != nil {
+			return answers, err
+		}
+		var target string
+		for _, rr := range rrs {
+			if !strings.EqualFold(rr.Header().Name, name) {
+				continue // only accept records about what we asked
+			}
+			answers = append(answers, rr)
+			if cname, ok := rr.(*dns.CNAME); ok && q.Qtype != dns.TypeCNAME {
+				target = cname.Target
+			} else if rr.Header().Rrtype == q.Qtype {
+				target = ""
+			}
+		}
+		if target == "" {
+			return answers, nil
+		}
+		name = target
+	}
+	return answers, errResolutionLimit
+}
+
+// lookup follows referrals until a server answers q authoritatively
+func (res *iterativeResolver) lookup(q dns.Question, nsDepth int) ([]dns.RR, error) {
+	zone, servers := res.closestDelegation(q.Name)
+	for referrals := 0; referrals < maxReferrals; referrals++ {
+		msg, err := res.exchange(q, servers)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Rcode == dns.RcodeNameError || len(msg.Answer) > 0 {
+			return msg.Answer, nil
+		}
+		if msg.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("%s answered %s", zone, dns.RcodeToString[msg.Rcode])
+		}
+
+		child, nsNames, ttl := referralFrom(msg, zone, q.Name)
+		if child == "" {
+			return nil, nil // no data
+		}
+		next := glueFor(msg, zone, nsNames)
+		if len(next) == 0 && nsDepth < maxNSLookups {
+			for _, ns := range nsNames {
+				rrs, _ := res.resolve(dns.Question{Name: ns, Qtype: dns.TypeA, Qclass: dns.ClassINET}, nsDepth+1)
+				for _, rr := range rrs {
+					if a, ok := rr.(*dns.A); ok {
+						next = append(next, net.JoinHostPort(a.A.String(), "53"))
+					}
+				}
+				if len(next) > 0 {
+					break
+				}
+			}
+		}
+		if len(next) == 0 {
+			return nil, fmt.Errorf("no usable name servers for %s", child)
+		}
+		res.remember(child, next, ttl)
+		zone, servers = child, next
+	}
+	return nil, errResolutionLimit
+}
+
+// exchange sends q to each of servers in random order until one responds
+func (res *iterativeResolver) exchange(q dns.Question, servers []string) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(q.Name, q.Qtype)
+	m.RecursionDesired = false
+	var lastErr error
+	for _, i := range rand.Perm(len(servers)) {
+		c := &dns.Client{Net: "udp", ReadTimeout: res.timeout, WriteTimeout: res.timeout}
+		msg, _, err := c.Exchange(m, servers[i])
+		if msg != nil && msg.Truncated {
+			c.Net = "tcp"
+			msg, _, err = c.Exchange(m, servers[i])
+		}
+		if err == nil {
+			return msg, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// referralFrom returns the delegated zone and its name servers if msg is a
+// referral from zone towards name. Referrals that don't lead closer to name
+// are ignored, so a server can't claim zones it wasn't asked about.
+func referralFrom(msg *dns.Msg, zone, name string) (child string, nsNames []string, ttl uint32) {
+	for _, rr := range msg.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		owner := strings.ToLower(ns.Hdr.Name)
+		if owner == zone || !dns.IsSubDomain(zone, owner) || !dns.IsSubDomain(owner, strings.ToLower(name)) {
+			continue
+		}
+		if child != "" && owner != child {
+			continue
+		}
+		child = owner
+		nsNames = append(nsNames, ns.Ns)
+		if ttl == 0 || ns.Hdr.Ttl < ttl {
+			ttl = ns.Hdr.Ttl
+		}
+	}
+	return child, nsNames, ttl
+}
+
+// glueFor returns the addresses given for nsNames in the additional section,
+// accepting only glue within the zone of the server that sent it
+func glueFor(msg *dns.Msg, zone string, nsNames []string) []string {
+	var servers []string
+	for _, rr := range msg.Extra {
+		a, ok := rr.(*dns.A)
+		if !ok || !dns.IsSubDomain(zone, strings.ToLower(a.Hdr.Name)) {
+			continue
+		}
+		for _, ns := range nsNames {
+			if strings.EqualFold(a.Hdr.Name, ns) {
+				servers = append(servers, net.JoinHostPort(a.A.String(), "53"))
+			}
+		}
+	}
+	return servers
+}
+
+// closestDelegation returns the deepest zone enclosing name that we know the
+// servers of, falling back to the root
+func (res *iterativeResolver) closestDelegation(name string) (string, []string) {
+	res.Lock()
+	defer res.Unlock()
+	labels := strings.Split(strings.ToLower(dns.Fqdn(name)), ".")
+	for i := range labels {
+		zone := strings.Join(labels[i:], ".")
+		if d, ok := res.delegations[zone]; ok {
+			if time.Now().Before(d.expires) {
+				return zone, d.servers
+			}
+			delete(res.delegations, zone)
+		}
+	}
+	return ".", res.roots
+}
+
+func (res *iterativeResolver) remember(zone string, servers []string, ttl uint32) {
+	res.Lock()
+	defer res.Unlock()
+	if len(res.delegations) >= 10000 {
+		res.delegations = make(map[string]delegation) // start over rather than grow forever
+	}
+	res.delegations[zone] = delegation{
+		servers: servers,
+		expires: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+}
@@ -0,0 +1,195 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// A zone's secondaries are listed in the "secondaries" setting of its SOA,
+// such as "192.0.2.10, 192.0.2.11:5353". They are sent a NOTIFY (RFC 1996)
+// whenever the serial of the zone changes, and are the only ones allowed to
+// transfer it, over TCP. Transfers are always whole (AXFR): IXFR questions
+// are answered with the whole zone, as RFC 1995 allows when no history is
+// kept. Like zone listings, transfers leave out the values that expire, such
+// as those DHCP registers, and the zones below with an SOA of their own.
+
+// dnsNotifyDelay gathers the serial bumps of a batch of changes into one
+// NOTIFY
+const dnsNotifyDelay = time.Second
+
+// dnsNotifyRetries is how many times a NOTIFY is sent to a secondary that
+// does not answer it
+const dnsNotifyRetries = 3
+
+// parseSOASecondaries returns the addresses of the secondaries listed in
+// the secondaries setting of an SOA, as host:port
+func parseSOASecondaries(setting string) ([]string, error) {
+	var secondaries []string
+	for _, text := range strings.Split(setting, ",") {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(text)
+		if err != nil {
+			host, port = text, "53"
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("secondary %q is not an address", text)
+		}
+		secondaries = append(secondaries, net.JoinHostPort(host, port))
+	}
+	return secondaries, nil
+}
+
+// dnsNotifier sends NOTIFY messages for the zones whose serial changed
+type dnsNotifier struct {
+	sync.Mutex
+	pending map[string][]string // zone to its secondaries
+}
+
+var notifier = &dnsNotifier{pending: make(map[string][]string)}
+
+// schedule sends a NOTIFY for zone to its secondaries shortly, along with
+// any other change made to the zone meanwhile
+func (n *dnsNotifier) schedule(zone string, secondaries []string) {
+	if len(secondaries) == 0 {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	_, waiting := n.pending[zone]
+	n.pending[zone] = secondaries
+	if !waiting {
+		time.AfterFunc(dnsNotifyDelay, func() { n.send(zone) })
+	}
+}
+
+func (n *dnsNotifier) send(zone string) {
+	n.Lock()
+	secondaries := n.pending[zone]
+	delete(n.pending, zone)
+	n.Unlock()
+	for _, secondary := range secondaries {
+		go notifySecondary(zone, secondary)
+	}
+}
+
+// notifySecondary tells a secondary that zone has changed, and tries again
+// a few times until it acknowledges
+func notifySecondary(zone, secondary string) {
+	m := new(dns.Msg)
+	m.SetNotify(dns.Fqdn(zone))
+	c := new(dns.Client)
+	for attempt := 1; attempt <= dnsNotifyRetries; attempt++ {
+		response, _, err := c.Exchange(m, secondary)
+		if err == nil && response.Rcode == dns.RcodeSuccess {
+			debugf("DNS NOTIFY for %s sent to %s\n", zone, secondary)
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("rcode %d", response.Rcode)
+		}
+		logger.Printf("DNS NOTIFY for %s to %s failed (attempt %d): %s\n", zone, secondary, attempt, err)
+		time.Sleep(time.Duration(attempt) * 5 * time.Second)
+	}
+}
+
+// dnsTransferServe answers an AXFR or IXFR question with the whole zone, to
+// its secondaries only
+func dnsTransferServe(cfg *Config, w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	zone := strings.ToLower(dns.Fqdn(q.Name))
+	refuse := func(reason string) {
+		logger.Printf("DNS transfer of %s to %s refused: %s\n", zone, w.RemoteAddr(), reason)
+		reply := new(dns.Msg)
+		reply.SetRcode(req, dns.RcodeRefused)
+		w.WriteMsg(reply)
+	}
+	if _, tcp := w.RemoteAddr().(*net.TCPAddr); !tcp {
+		refuse("transfers are only made over TCP")
+		return
+	}
+	soa, err := cfg.db.GetDNS(zone, "SOA")
+	if err != nil {
+		refuse("not a zone of ours")
+		return
+	}
+	secondaries, _ := parseSOASecondaries(soa.Meta["secondaries"])
+	if !isSecondary(secondaries, w.RemoteAddr()) {
+		refuse("not one of its secondaries")
+		return
+	}
+	rrs, err := zoneRecords(cfg, zone)
+	if err != nil {
+		logger.Printf("DNS transfer of %s failed: %s\n", zone, err)
+		reply := new(dns.Msg)
+		reply.SetRcode(req, dns.RcodeServerFailure)
+		w.WriteMsg(reply)
+		return
+	}
+	apex := answerSOA(&dns.Question{Name: zone, Qtype: dns.TypeSOA, Qclass: dns.ClassINET}, soa)
+	apex.Header().Ttl = 10800
+	if soa.TTL > 0 {
+		apex.Header().Ttl = soa.TTL
+	}
+	var children []string
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeSOA && !strings.EqualFold(rr.Header().Name, zone) {
+			children = append(children, rr.Header().Name)
+		}
+	}
+	records := []dns.RR{apex}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeSOA && !inChildZone(children, rr) {
+			records = append(records, rr)
+		}
+	}
+	records = append(records, apex)
+
+	envelopes := make(chan *dns.Envelope, len(records)/100+1)
+	for len(records) > 0 {
+		n := 100
+		if n > len(records) {
+			n = len(records)
+		}
+		envelopes <- &dns.Envelope{RR: records[:n]}
+		records = records[n:]
+	}
+	close(envelopes)
+	t := new(dns.Transfer)
+	if err := t.Out(w, req, envelopes); err != nil {
+		logger.Printf("DNS transfer of %s to %s failed: %s\n", zone, w.RemoteAddr(), err)
+		return
+	}
+	logger.Printf("DNS transfer of %s to %s\n", zone, w.RemoteAddr())
+}
+
+// inChildZone reports whether rr belongs to one of the child zones rather
+// than to their parent: the NS records at their apex are the parent's too
+func inChildZone(children []string, rr dns.RR) bool {
+	for _, child := range children {
+		if dns.IsSubDomain(child, rr.Header().Name) {
+			return rr.Header().Rrtype != dns.TypeNS || !strings.EqualFold(child, rr.Header().Name)
+		}
+	}
+	return false
+}
+
+// isSecondary reports whether addr is the address of one of secondaries
+func isSecondary(secondaries []string, addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, secondary := range secondaries {
+		if secondaryHost, _, err := net.SplitHostPort(secondary); err == nil && net.ParseIP(secondaryHost).Equal(net.ParseIP(host)) {
+			return true
+		}
+	}
+	return false
+}
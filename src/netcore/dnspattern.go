@@ -0,0 +1,230 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dnsPattern synthesizes address records for a range of hosts whose names
+// encode their addresses, such as ip-10-0-*-*.dyn.example.com, so that large
+// dynamic ranges don't need a key per host
+type dnsPattern struct {
+	prefix string    // first label up to the octets, such as "ip-"
+	octets [4]string // each a number, or "*" for any
+	domain string    // the rest of the name, fully qualified
+	ttl    uint32
+	pool   *net.IPNet // if set, the only addresses the pattern covers
+}
+
+// parseDNSPattern parses a pattern declaration of the form "template [ttl]",
+// where the first label of the template ends with four dash-separated
+// octets, any of which may be * to match any value
+func parseDNSPattern(decl string) (*dnsPattern, error) {
+	fields := strings.Fields(decl)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("dns pattern %q: expected a template and an optional ttl", decl)
+	}
+	p := &dnsPattern{ttl: dnsStaticTTL}
+	if len(fields) == 2 {
+		ttl, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("dns pattern %q: bad ttl: %s", decl, err)
+		}
+		p.ttl = uint32(ttl)
+	}
+	labels := strings.SplitN(dns.Fqdn(strings.ToLower(fields[0])), ".", 2)
+	parts := strings.Split(labels[0], "-")
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("dns pattern %q: the first label must end with four octets", decl)
+	}
+	for i, octet := range parts[len(parts)-4:] {
+		if octet != "*" {
+			if _, ok := parseOctet(octet); !ok {
+				return nil, fmt.Errorf("dns pattern %q: %q is not an octet or *", decl, octet)
+			}
+		}
+		p.octets[i] = octet
+	}
+	if len(parts) > 4 {
+		p.prefix = strings.Join(parts[:len(parts)-4], "-") + "-"
+	}
+	p.domain = labels[1]
+	if p.domain == "" {
+		return nil, fmt.Errorf("dns pattern %q: no domain", decl)
+	}
+	return p, nil
+}
+
+// parseOctet parses a decimal octet written without leading zeros, so that
+// each address has exactly one name
+func parseOctet(s string) (byte, bool) {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	return byte(n), err == nil
+}
+
+// addressFor returns the address encoded in name, or nil if name does not
+// match the pattern
+func (p *dnsPattern) addressFor(name string) net.IP {
+	labels := strings.SplitN(strings.ToLower(dns.Fqdn(name)), ".", 2)
+	if len(labels) != 2 || labels[1] != p.domain || !strings.HasPrefix(labels[0], p.prefix) {
+		return nil
+	}
+	parts := strings.Split(strings.TrimPrefix(labels[0], p.prefix), "-")
+	if len(parts) != 4 {
+		return nil
+	}
+	ip := make(net.IP, 4)
+	for i, part := range parts {
+		octet, ok := parseOctet(part)
+		if !ok || (p.octets[i] != "*" && p.octets[i] != part) {
+			return nil
+		}
+		ip[i] = octet
+	}
+	if p.pool != nil && !p.pool.Contains(ip) {
+		return nil
+	}
+	return ip
+}
+
+// nameFor returns the name that encodes ip, or "" if ip is not in the range
+func (p *dnsPattern) nameFor(ip net.IP) string {
+	ip = ip.To4()
+	if ip == nil || (p.pool != nil && !p.pool.Contains(ip)) {
+		return ""
+	}
+	parts := make([]string, 4)
+	for i, octet := range ip {
+		parts[i] = strconv.Itoa(int(octet))
+		if p.octets[i] != "*" && p.octets[i] != parts[i] {
+			return ""
+		}
+	}
+	return p.prefix + strings.Join(parts, "-") + "." + p.domain
+}
+
+// parseDHCPGenericNames makes the pattern of a dhcpgenericnames template,
+// such as {ip}.dhcp.example or host-{ip}.dhcp.example, which names every
+// address of pool after its octets
+func parseDHCPGenericNames(template string, pool *net.IPNet) (*dnsPattern, error) {
+	labels := strings.SplitN(strings.TrimSpace(template), ".", 2)
+	if !strings.HasSuffix(labels[0], "{ip}") || strings.Count(template, "{ip}") != 1 {
+		return nil, fmt.Errorf("generic names %q: the first label must end with {ip}", template)
+	}
+	p, err := parseDNSPattern(strings.Replace(template, "{ip}", "*-*-*-*", 1))
+	if err != nil {
+		return nil, err
+	}
+	p.pool = pool
+	return p, nil
+}
+
+func checkDHCPGenericNames(value string) error {
+	_, err := parseDHCPGenericNames(value, nil)
+	return err
+}
+
+// dhcpGenericPatterns returns the patterns of the DHCP pools that are named
+// generically rather than by lease: this zone's, and those of the other
+// zones this host serves
+func dhcpGenericPatterns(cfg *Config) ([]*dnsPattern, error) {
+	var patterns []*dnsPattern
+	add := func(template string, pool *net.IPNet) error {
+		if template == "" || pool == nil {
+			return nil
+		}
+		p, err := parseDHCPGenericNames(template, pool)
+		if err != nil {
+			return fmt.Errorf("dhcpgenericnames: %s", err)
+		}
+		patterns = append(patterns, p)
+		return nil
+	}
+	if err := add(cfg.DHCPGenericNames(), cfg.DHCPSubnet()); err != nil {
+		return nil, err
+	}
+	for _, instance := range cfg.DHCPInstances() {
+		if instance.Zone == cfg.Zone() {
+			continue
+		}
+		if err := add(instance.GenericNames, instance.Pool); err != nil {
+			return nil, err
+		}
+	}
+	return patterns, nil
+}
+
+// ipFromArpaName returns the IPv4 address for an in-addr.arpa name, or nil
+func ipFromArpaName(name string) net.IP {
+	name = strings.ToLower(dns.Fqdn(name))
+	if !strings.HasSuffix(name, ".in-addr.arpa.") {
+		return nil
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa."), ".")
+	if len(parts) != 4 {
+		return nil
+	}
+	ip := make(net.IP, 4)
+	for i, part := range parts {
+		octet, ok := parseOctet(part)
+		if !ok {
+			return nil
+		}
+		ip[3-i] = octet
+	}
+	return ip
+}
+
+// newDNSPatternHandler answers A questions for names matching the zone's
+// patterns and generic DHCP names, and PTR questions for the addresses they
+// cover. Other questions about those names are passed along.
+func newDNSPatternHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	var patterns []*dnsPattern
+	for _, decl := range cfg.DNSPatterns() {
+		p, err := parseDNSPattern(decl)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	generic, err := dhcpGenericPatterns(cfg)
+	if err != nil {
+		return nil, err
+	}
+	patterns = append(patterns, generic...)
+	if len(patterns) == 0 {
+		return next, nil
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		q := r.Question
+		hdr := func(rrType uint16, ttl uint32) dns.RR_Header {
+			return dns.RR_Header{Name: q.Name, Rrtype: rrType, Class: dns.ClassINET, Ttl: ttl}
+		}
+		switch q.Qtype {
+		case dns.TypeA, dns.TypeANY:
+			for _, p := range patterns {
+				if ip := p.addressFor(q.Name); ip != nil {
+					r.Trace.Note("answered by an address pattern")
+					return []dns.RR{&dns.A{Hdr: hdr(dns.TypeA, p.ttl), A: ip}}
+				}
+			}
+		case dns.TypePTR:
+			if ip := ipFromArpaName(q.Name); ip != nil {
+				for _, p := range patterns {
+					if name := p.nameFor(ip); name != "" {
+						r.Trace.Note("answered by an address pattern")
+						return []dns.RR{&dns.PTR{Hdr: hdr(dns.TypePTR, p.ttl), Ptr: name}}
+					}
+				}
+			}
+		}
+		return next.ServeDNSQuestion(r)
+	}), nil
+}
@@ -0,0 +1,37 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDNSPattern(t *testing.T) {
+	p, err := parseDNSPattern("ip-10-0-*-*.dyn.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ip   string
+	}{
+		{"ip-10-0-3-17.dyn.example.com.", "10.0.3.17"},
+		{"IP-10-0-255-0.DYN.example.com.", "10.0.255.0"},
+		{"ip-10-1-3-17.dyn.example.com.", ""},  // outside the range
+		{"ip-10-0-03-17.dyn.example.com.", ""}, // not canonical
+		{"ip-10-0-3-256.dyn.example.com.", ""},
+		{"ip-10-0-3.dyn.example.com.", ""},
+		{"ip-10-0-3-17.other.example.com.", ""},
+	}
+	for _, test := range tests {
+		ip := p.addressFor(test.name)
+		if (test.ip == "" && ip != nil) || (test.ip != "" && !ip.Equal(net.ParseIP(test.ip))) {
+			t.Errorf("addressFor(%q) = %v, want %q", test.name, ip, test.ip)
+		}
+	}
+	if name := p.nameFor(ipFromArpaName("17.3.0.10.in-addr.arpa.")); name != "ip-10-0-3-17.dyn.example.com." {
+		t.Errorf("nameFor(10.0.3.17) = %q", name)
+	}
+	if name := p.nameFor(net.ParseIP("10.1.3.17")); name != "" {
+		t.Errorf("nameFor(10.1.3.17) = %q, want nothing", name)
+	}
+}
@@ -0,0 +1,365 @@
+package netcore
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsPolicyStats counts the questions each policy rule matched, keyed by
+// the rule's text, and those refused and sinkholed in all under "refused"
+// and "sinkholed"
+var dnsPolicyStats = expvar.NewMap("dns_policy")
+
+// dnsPolicyRule decides whether some clients may ask some questions. Rules
+// are stored as text in the dnspolicy config directory, one rule per key,
+// and the first rule that matches a question, in key order, decides:
+//
+//	refuse client 10.9.0.0/16 name *.social.example
+//	refuse client 10.20.0.0/22 type TXT,ANY
+//	allow client 10.1.0.0/16 name social.example
+//	refuse class student-laptop name social.example
+//	refuse host kids-* label room=den name video.example
+//	refuse name social.example
+//	sinkhole name malware.example,c2.example
+//
+// A rule matches when all of its conditions do; a missing condition matches
+// anything. A name matches itself and its subdomains, while *.name only
+// matches the subdomains. The class, mac, host and label conditions are
+// about the device DHCP leased the client's address to, as clientDevices
+// knows it: its class, MAC, host name pattern and reservation labels. They
+// never match clients without a lease. Refused questions are answered with REFUSED.
+// Sinkholed questions are answered with the zone's dnssinkhole addresses,
+// or REFUSED without them, and the client is recorded in the incident log.
+type dnsPolicyRule struct {
+	text     string
+	refuse   bool
+	sinkhole bool
+	clients  []*net.IPNet
+	names    []string // lowercase and fully qualified, "*." kept for subdomains only
+	types    map[uint16]bool
+	classes  []string
+	macs     []string
+	hosts    []string // patterns, as for path.Match
+	labels   map[string][]string
+}
+
+func parseDNSPolicyRule(rule string) (*dnsPolicyRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 || len(fields)%2 != 1 {
+		return nil, fmt.Errorf("malformed policy rule %q", rule)
+	}
+	r := &dnsPolicyRule{text: strings.Join(fields, " ")}
+	switch fields[0] {
+	case "allow":
+	case "refuse":
+		r.refuse = true
+	case "sinkhole":
+		r.refuse, r.sinkhole = true, true
+	default:
+		return nil, fmt.Errorf("policy rule %q must start with allow, refuse or sinkhole", rule)
+	}
+	if err := r.parseConditions(fmt.Sprintf("policy rule %q", rule), fields[1:]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseConditions reads the conditions of a rule, as pairs of fields. what
+// names the rule in errors.
+func (r *dnsPolicyRule) parseConditions(what string, fields []string) error {
+	for i := 0; i < len(fields); i += 2 {
+		values := strings.Split(fields[i+1], ",")
+		switch fields[i] {
+		case "client":
+			for _, value := range values {
+				cidr := value
+				if !strings.Contains(value, "/") {
+					if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+						cidr += "/32"
+					} else {
+						cidr += "/128"
+					}
+				}
+				_, network, err := net.ParseCIDR(cidr)
+				if err != nil {
+					return fmt.Errorf("%s: %q is not an address or subnet", what, value)
+				}
+				r.clients = append(r.clients, network)
+			}
+		case "name":
+			for _, value := range values {
+				r.names = append(r.names, dns.Fqdn(strings.ToLower(value)))
+			}
+		case "type":
+			r.types = make(map[uint16]bool)
+			for _, value := range values {
+				t, ok := parseQtype(value)
+				if !ok {
+					return fmt.Errorf("%s: unknown type %s", what, value)
+				}
+				r.types[t] = true
+			}
+		case "class":
+			r.classes = append(r.classes, values...)
+		case "mac":
+			for _, value := range values {
+				mac, err := net.ParseMAC(value)
+				if err != nil {
+					return fmt.Errorf("%s: %q is not a MAC", what, value)
+				}
+				r.macs = append(r.macs, mac.String())
+			}
+		case "host":
+			for _, value := range values {
+				if _, err := path.Match(value, ""); err != nil {
+					return fmt.Errorf("%s: invalid host pattern %q", what, value)
+				}
+				r.hosts = append(r.hosts, strings.ToLower(value))
+			}
+		case "label":
+			if r.labels == nil {
+				r.labels = make(map[string][]string)
+			}
+			for _, value := range values {
+				kv := strings.SplitN(value, "=", 2)
+				if len(kv) != 2 || kv[0] == "" {
+					return fmt.Errorf("%s: label %q is not key=value", what, value)
+				}
+				r.labels[kv[0]] = append(r.labels[kv[0]], kv[1])
+			}
+		default:
+			return fmt.Errorf("%s: unknown condition %q; use client, name, type, class, mac, host or label", what, fields[i])
+		}
+	}
+	return nil
+}
+
+// The service binding types of RFC 9460
+const (
+	dnsTypeSVCB  uint16 = 64
+	dnsTypeHTTPS uint16 = 65
+)
+
+// parseQtype reads a question type by name, including the service binding
+// types that miekg/dns does not know yet
+func parseQtype(name string) (uint16, bool) {
+	name = strings.ToUpper(name)
+	switch name {
+	case "SVCB":
+		return dnsTypeSVCB, true
+	case "HTTPS":
+		return dnsTypeHTTPS, true
+	}
+	t, ok := dns.StringToType[name]
+	return t, ok
+}
+
+// matches reports whether client, leased to device, asking q meets all of
+// the rule's conditions
+func (r *dnsPolicyRule) matches(client net.IP, device *ClientDevice, q *dns.Question) bool {
+	if r.clients != nil {
+		found := false
+		for _, network := range r.clients {
+			if client != nil && network.Contains(client) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.names != nil {
+		name := strings.ToLower(q.Name)
+		found := false
+		for _, pattern := range r.names {
+			if strings.HasPrefix(pattern, "*.") {
+				found = strings.HasSuffix(name, pattern[1:])
+			} else {
+				found = dns.IsSubDomain(pattern, name)
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.types != nil && !r.types[q.Qtype] {
+		return false
+	}
+	return r.matchesDevice(device)
+}
+
+// matchesDevice reports whether device meets the rule's conditions on
+// devices
+func (r *dnsPolicyRule) matchesDevice(device *ClientDevice) bool {
+	if r.classes == nil && r.macs == nil && r.hosts == nil && r.labels == nil {
+		return true
+	}
+	if device == nil {
+		return false
+	}
+	if r.classes != nil && !containsString(r.classes, device.Class) {
+		return false
+	}
+	if r.macs != nil && !containsString(r.macs, device.MAC) {
+		return false
+	}
+	if r.hosts != nil {
+		found := false
+		for _, pattern := range r.hosts {
+			if found, _ = path.Match(pattern, strings.ToLower(device.Hostname)); found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for key, values := range r.labels {
+		if value, ok := device.Labels[key]; !ok || !containsString(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// newDNSPolicyHandler refuses the questions that policy rules forbid to the
+// client asking: the rules of the profiles whose schedule is active, then the
+// zone's own. It needs to know the client, so it must come before the cache.
+func newDNSPolicyHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	var rules []*dnsPolicyRule
+	for _, text := range cfg.DNSPolicyRules() {
+		rule, err := parseDNSPolicyRule(text)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	sinkhole := cfg.DNSSinkhole()
+	var profiles *dnsProfileSet
+	if cfg.db != nil {
+		profiles = &dnsProfileSet{db: cfg.db}
+	}
+	if len(rules) == 0 && profiles == nil {
+		return next, nil
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		active := rules
+		if profiles != nil {
+			if scheduled := profiles.rules(r.Start); len(scheduled) > 0 {
+				active = append(scheduled, rules...)
+			}
+		}
+		device := clientDevices.Lookup(r.Client)
+		if device != nil && r.Trace != nil {
+			r.Trace.Note("the client is %s", describeDevice(device))
+		}
+		for _, rule := range active {
+			if !rule.matches(r.Client, device, r.Question) {
+				continue
+			}
+			if r.Trace != nil {
+				return tracePolicyRule(r, rule, sinkhole, next)
+			}
+			dnsPolicyStats.Add(rule.text, 1)
+			if !rule.refuse {
+				break
+			}
+			r.Span.SetAttr("dns.policy", rule.text)
+			if rule.sinkhole {
+				incidents.Record(r.Client, r.Question, rule.text, r.Start)
+				if len(sinkhole) > 0 {
+					dnsPolicyStats.Add("sinkholed", 1)
+					logger.Printf("  [POLICY] %s %s sinkholed by %q\n", r.Question.Name, dns.Type(r.Question.Qtype).String(), rule.text)
+					return sinkholeAnswers(r.Question, sinkhole)
+				}
+			}
+			dnsPolicyStats.Add("refused", 1)
+			logger.Printf("  [POLICY] %s %s refused by %q\n", r.Question.Name, dns.Type(r.Question.Qtype).String(), rule.text)
+			if !rule.sinkhole {
+				publishPolicyHit(r.Client, device, r.Question, rule.text)
+			}
+			r.Rcode = dns.RcodeRefused
+			return nil
+		}
+		r.Trace.Note("no policy rule matches, of %d active", len(active))
+		return next.ServeDNSQuestion(r)
+	}), nil
+}
+
+// dnsPolicyHits throttles dns.policy.refused events to one per client and
+// rule every 10 minutes
+var dnsPolicyHits = newEventThrottle(10 * time.Minute)
+
+// publishPolicyHit publishes a dns.policy.refused event about client, leased
+// to device, being refused q by rule
+func publishPolicyHit(client net.IP, device *ClientDevice, q *dns.Question, rule string) {
+	ip := ""
+	if client != nil {
+		ip = client.String()
+	}
+	if !dnsPolicyHits.Allow(ip + " " + rule) {
+		return
+	}
+	data := map[string]string{"client": ip, "name": dns.Fqdn(q.Name), "type": dns.Type(q.Qtype).String(), "rule": rule}
+	if device != nil {
+		data["hostname"], data["mac"] = device.Hostname, device.MAC
+	}
+	events.Publish(Event{
+		Type:     "dns.policy.refused",
+		Severity: "warning",
+		Message:  fmt.Sprintf("%s was refused %s by %q", ip, dns.Fqdn(q.Name), rule),
+		Data:     data,
+	})
+}
+
+// tracePolicyRule explains what rule, the first matching the question of a
+// dry run, does with it, without counting it or recording an incident
+func tracePolicyRule(r *DNSRequest, rule *dnsPolicyRule, sinkhole []net.IP, next DNSHandler) []dns.RR {
+	switch {
+	case !rule.refuse:
+		r.Trace.Note("allowed by %q", rule.text)
+		return next.ServeDNSQuestion(r)
+	case rule.sinkhole && len(sinkhole) > 0:
+		r.Trace.Note("sinkholed by %q, which would also record an incident", rule.text)
+		return sinkholeAnswers(r.Question, sinkhole)
+	}
+	r.Trace.Note("refused by %q", rule.text)
+	r.Rcode = dns.RcodeRefused
+	return nil
+}
+
+// sinkholeTTL is the TTL of sinkhole answers, short so that clients come
+// back and are seen again
+const sinkholeTTL = 60
+
+// sinkholeAnswers answers q with the sinkhole addresses of its type
+func sinkholeAnswers(q *dns.Question, addresses []net.IP) []dns.RR {
+	var answers []dns.RR
+	for _, ip := range addresses {
+		hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: sinkholeTTL}
+		switch ip4 := ip.To4(); {
+		case ip4 != nil && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY):
+			hdr.Rrtype = dns.TypeA
+			answers = append(answers, &dns.A{Hdr: hdr, A: ip4})
+		case ip4 == nil && (q.Qtype == dns.TypeAAAA || q.Qtype == dns.TypeANY):
+			hdr.Rrtype = dns.TypeAAAA
+			answers = append(answers, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	return answers
+}
+
+func checkDNSPolicyRule(value string) error {
+	_, err := parseDNSPolicyRule(value)
+	return err
+}
@@ -0,0 +1,66 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSPolicyRule(t *testing.T) {
+	laptop := &ClientDevice{Hostname: "kids-laptop", MAC: "02:00:00:00:00:01", Class: "student-laptop", Labels: map[string]string{"room": "den"}}
+	tests := []struct {
+		rule    string
+		client  string
+		device  *ClientDevice
+		name    string
+		qtype   uint16
+		matches bool
+	}{
+		{"refuse name social.example", "10.0.0.1", nil, "social.example.", dns.TypeA, true},
+		{"refuse name social.example", "10.0.0.1", nil, "www.Social.example.", dns.TypeA, true},
+		{"refuse name social.example", "10.0.0.1", nil, "antisocial.example.", dns.TypeA, false},
+		{"refuse name *.social.example", "10.0.0.1", nil, "social.example.", dns.TypeA, false},
+		{"refuse name *.social.example", "10.0.0.1", nil, "www.social.example.", dns.TypeA, true},
+		{"refuse client 10.9.0.0/16 name social.example", "10.8.0.1", nil, "social.example.", dns.TypeA, false},
+		{"refuse client 10.9.0.0/16,10.0.0.1 name social.example", "10.0.0.1", nil, "social.example.", dns.TypeA, true},
+		{"refuse client 10.20.0.0/22 type TXT,ANY", "10.20.1.1", nil, "example.com.", dns.TypeTXT, true},
+		{"refuse client 10.20.0.0/22 type TXT,ANY", "10.20.1.1", nil, "example.com.", dns.TypeA, false},
+		{"refuse type HTTPS", "10.0.0.1", nil, "example.com.", dnsTypeHTTPS, true},
+		{"refuse class student-laptop", "10.0.0.1", laptop, "example.com.", dns.TypeA, true},
+		{"refuse class student-laptop", "10.0.0.1", nil, "example.com.", dns.TypeA, false},
+		{"refuse host kids-* label room=den", "10.0.0.1", laptop, "example.com.", dns.TypeA, true},
+		{"refuse host kids-* label room=lounge", "10.0.0.1", laptop, "example.com.", dns.TypeA, false},
+		{"refuse mac 02:00:00:00:00:01", "10.0.0.1", laptop, "example.com.", dns.TypeA, true},
+	}
+	for _, test := range tests {
+		r, err := parseDNSPolicyRule(test.rule)
+		if err != nil {
+			t.Errorf("parseDNSPolicyRule(%q): %s", test.rule, err)
+			continue
+		}
+		q := &dns.Question{Name: test.name, Qtype: test.qtype, Qclass: dns.ClassINET}
+		if matches := r.matches(net.ParseIP(test.client), test.device, q); matches != test.matches {
+			t.Errorf("%q matches %s asking %s = %v, want %v", test.rule, test.client, test.name, matches, test.matches)
+		}
+	}
+
+	if r, err := parseDNSPolicyRule("sinkhole name malware.example"); err != nil || !r.refuse || !r.sinkhole {
+		t.Errorf("sinkhole rule = %+v, %v", r, err)
+	}
+	for _, invalid := range []string{
+		"",
+		"drop name example.com",
+		"refuse name",
+		"refuse client not-an-ip",
+		"refuse type NOTATYPE",
+		"refuse mac 02:00",
+		"refuse label room",
+		"refuse host [",
+		"refuse colour blue",
+	} {
+		if _, err := parseDNSPolicyRule(invalid); err == nil {
+			t.Errorf("parseDNSPolicyRule(%q) succeeded, want an error", invalid)
+		}
+	}
+}
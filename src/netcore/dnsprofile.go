@@ -0,0 +1,114 @@
+package netcore
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DNSProfileDB stores filtering profiles: policy rules that only apply on a
+// schedule, such as keeping the kids' VLAN off streaming sites at night
+type DNSProfileDB interface {
+	ListDNSProfiles() ([]DNSProfile, error)
+	GetDNSProfile(name string) (*DNSProfile, error)
+	SaveDNSProfile(actor string, profile DNSProfile) error
+	DeleteDNSProfile(actor string, name string) error
+}
+
+// DNSProfile is a set of policy rules, written as in the dnspolicy setting,
+// that apply during a schedule, written as for DNS values
+type DNSProfile struct {
+	Name     string   `json:"name"`
+	Rules    []string `json:"rules"`              // such as "refuse client 10.30.0.0/24 name video.example"
+	Schedule string   `json:"schedule"`           // such as "* 21:00-07:00"
+	Timezone string   `json:"timezone,omitempty"` // such as "Europe/Paris"; UTC by default
+}
+
+var profileNameMatcher = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// compile checks the profile, and returns its rules and schedule
+func (p *DNSProfile) compile() ([]*dnsPolicyRule, *dnsSchedule, error) {
+	if !profileNameMatcher.MatchString(p.Name) {
+		return nil, nil, invalid("name", "profile names may only contain letters, digits, dashes and underscores")
+	}
+	if len(p.Rules) == 0 {
+		return nil, nil, invalid("rules", "a profile needs at least one rule")
+	}
+	var rules []*dnsPolicyRule
+	for _, text := range p.Rules {
+		rule, err := parseDNSPolicyRule(text)
+		if err != nil {
+			return nil, nil, invalid("rules", "%s", err)
+		}
+		rule.text = p.Name + ": " + rule.text // for metrics and logs
+		rules = append(rules, rule)
+	}
+	if p.Schedule == "" {
+		return nil, nil, invalid("schedule", "a profile needs a schedule, such as \"* 21:00-07:00\"")
+	}
+	attr := map[string]string{"schedule": p.Schedule}
+	if p.Timezone != "" {
+		attr["timezone"] = p.Timezone
+	}
+	schedule, err := parseDNSSchedule(attr)
+	if err != nil {
+		return nil, nil, invalid("schedule", "%s", err)
+	}
+	return rules, schedule, nil
+}
+
+type byProfileName []DNSProfile
+
+func (p byProfileName) Len() int           { return len(p) }
+func (p byProfileName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byProfileName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+
+// dnsProfileRefresh is how often the policy handler reads profiles again
+const dnsProfileRefresh = 30 * time.Second
+
+// dnsProfileSet is the profiles the policy handler evaluates, read from the
+// database every dnsProfileRefresh rather than for each question
+type dnsProfileSet struct {
+	sync.Mutex
+	db       DNSProfileDB
+	loaded   time.Time
+	profiles []dnsCompiledProfile
+}
+
+type dnsCompiledProfile struct {
+	name     string
+	rules    []*dnsPolicyRule
+	schedule *dnsSchedule
+}
+
+// rules returns the rules of the profiles active at now, in profile name
+// order
+func (s *dnsProfileSet) rules(now time.Time) []*dnsPolicyRule {
+	s.Lock()
+	defer s.Unlock()
+	if now.Sub(s.loaded) >= dnsProfileRefresh {
+		s.loaded = now
+		if profiles, err := s.db.ListDNSProfiles(); err != nil {
+			logger.Printf("DNS profiles could not be read, keeping those read before: %s\n", err)
+		} else {
+			sort.Sort(byProfileName(profiles))
+			s.profiles = s.profiles[:0]
+			for _, profile := range profiles {
+				rules, schedule, err := profile.compile()
+				if err != nil {
+					logger.Printf("DNS profile %s is ignored: %s\n", profile.Name, err)
+					continue
+				}
+				s.profiles = append(s.profiles, dnsCompiledProfile{profile.Name, rules, schedule})
+			}
+		}
+	}
+	var rules []*dnsPolicyRule
+	for _, profile := range s.profiles {
+		if profile.schedule.active(now) {
+			rules = append(rules, profile.rules...)
+		}
+	}
+	return rules
+}
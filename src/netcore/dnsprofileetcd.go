@@ -0,0 +1,117 @@
+package netcore
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Profiles are stored under their own namespace:
+//
+//	/dnsprofiles/<name>/schedule
+//	/dnsprofiles/<name>/timezone
+//	/dnsprofiles/<name>/rules/<000, 001...>
+
+func (db EtcdDB) ListDNSProfiles() ([]DNSProfile, error) {
+	response, err := db.client.Get("dnsprofiles", true, true)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var profiles []DNSProfile
+	for _, node := range response.Node.Nodes {
+		if node.Dir {
+			profiles = append(profiles, etcdNodeToDNSProfile(node))
+		}
+	}
+	return profiles, nil
+}
+
+func (db EtcdDB) GetDNSProfile(name string) (*DNSProfile, error) {
+	if !profileNameMatcher.MatchString(name) {
+		return nil, notFoundError("no DNS profile %s", name)
+	}
+	response, err := db.client.Get("dnsprofiles/"+name, true, true)
+	if etcdKeyNotFound(err) {
+		return nil, notFoundError("no DNS profile %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	profile := etcdNodeToDNSProfile(response.Node)
+	return &profile, nil
+}
+
+func etcdNodeToDNSProfile(node *etcd.Node) DNSProfile {
+	profile := DNSProfile{Name: path.Base(node.Key), Rules: []string{}}
+	for _, child := range node.Nodes {
+		switch path.Base(child.Key) {
+		case "schedule":
+			profile.Schedule = child.Value
+		case "timezone":
+			profile.Timezone = child.Value
+		case "rules":
+			for _, rule := range child.Nodes {
+				profile.Rules = append(profile.Rules, rule.Value)
+			}
+		}
+	}
+	return profile
+}
+
+// SaveDNSProfile creates the profile or replaces it as a whole
+func (db EtcdDB) SaveDNSProfile(actor string, profile DNSProfile) error {
+	if _, _, err := profile.compile(); err != nil {
+		return err
+	}
+	var old string
+	if current, err := db.GetDNSProfile(profile.Name); err == nil {
+		old = current.String()
+	} else if ErrorKind(err) != ErrNotFound {
+		return err
+	}
+	key := "dnsprofiles/" + profile.Name
+	if _, err := db.client.Delete(key, true); err != nil && !etcdKeyNotFound(err) {
+		return err
+	}
+	if _, err := db.client.Set(key+"/schedule", profile.Schedule, 0); err != nil {
+		return err
+	}
+	if profile.Timezone != "" {
+		if _, err := db.client.Set(key+"/timezone", profile.Timezone, 0); err != nil {
+			return err
+		}
+	}
+	for i, rule := range profile.Rules {
+		if _, err := db.client.Set(fmt.Sprintf("%s/rules/%03d", key, i), rule, 0); err != nil {
+			return err
+		}
+	}
+	auditChange(db, actor, "dns", "profile/"+profile.Name, "set", old, profile.String())
+	return nil
+}
+
+func (db EtcdDB) DeleteDNSProfile(actor string, name string) error {
+	current, err := db.GetDNSProfile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := db.client.Delete("dnsprofiles/"+name, true); err != nil && !etcdKeyNotFound(err) {
+		return err
+	}
+	auditChange(db, actor, "dns", "profile/"+name, "delete", current.String(), "")
+	return nil
+}
+
+// String describes the profile for the audit log
+func (p DNSProfile) String() string {
+	s := p.Schedule
+	if p.Timezone != "" {
+		s += " " + p.Timezone
+	}
+	return s + ": " + strings.Join(p.Rules, "; ")
+}
@@ -0,0 +1,72 @@
+package netcore
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+var dnsMultiQuestion = Flags.String("dnsmultiquestion", "formerr", "How DNS answers queries without exactly one question: formerr refuses them as RFC 9619 has it, first answers only the first question, and all answers every question, with the rcode of the worst outcome.")
+
+// checkDNSMultiQuestion validates -dnsmultiquestion
+func checkDNSMultiQuestion() error {
+	switch *dnsMultiQuestion {
+	case "formerr", "first", "all":
+		return nil
+	}
+	return fmt.Errorf("-dnsmultiquestion must be formerr, first or all, not %q", *dnsMultiQuestion)
+}
+
+// dnsQuestionPolicy returns the query to answer for req, which has only its
+// first question with -dnsmultiquestion first, or answers req with FORMERR
+// and returns nil. A query without a question is always malformed.
+func dnsQuestionPolicy(w dns.ResponseWriter, req *dns.Msg) *dns.Msg {
+	switch {
+	case len(req.Question) == 1:
+		return req
+	case len(req.Question) > 1 && *dnsMultiQuestion == "all":
+		return req
+	case len(req.Question) > 1 && *dnsMultiQuestion == "first":
+		first := *req
+		first.Question = req.Question[:1]
+		return &first
+	}
+	formerr := new(dns.Msg)
+	formerr.SetRcode(req, dns.RcodeFormatError)
+	w.WriteMsg(formerr)
+	return nil
+}
+
+// questionRcode is the outcome of a question: the rcode that the handlers
+// set when they found no answers, NOERROR when there are answers or a
+// referral or proof of their absence, and NXDOMAIN otherwise
+func questionRcode(r *DNSRequest, answers, ns []dns.RR) int {
+	switch {
+	case len(answers) == 0 && r.Rcode != dns.RcodeSuccess:
+		return r.Rcode
+	case len(answers) > 0 || len(ns) > 0:
+		return dns.RcodeSuccess
+	}
+	return dns.RcodeNameError
+}
+
+// combinedRcode is the rcode of a response to several questions, given
+// theirs: a failure such as SERVFAIL or REFUSED for any question is
+// reported, NXDOMAIN only if no question's name exists, and NOERROR
+// otherwise, with the answers of the questions that had some
+func combinedRcode(rcodes []int) int {
+	combined := dns.RcodeNameError
+	for _, rcode := range rcodes {
+		switch rcode {
+		case dns.RcodeNameError:
+		case dns.RcodeSuccess:
+			combined = dns.RcodeSuccess
+		default:
+			return rcode
+		}
+	}
+	if len(rcodes) == 0 {
+		return dns.RcodeSuccess
+	}
+	return combined
+}
@@ -0,0 +1,26 @@
+package netcore
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCombinedRcode(t *testing.T) {
+	tests := []struct {
+		rcodes   []int
+		combined int
+	}{
+		{[]int{dns.RcodeSuccess}, dns.RcodeSuccess},
+		{[]int{dns.RcodeNameError}, dns.RcodeNameError},
+		{[]int{dns.RcodeSuccess, dns.RcodeNameError}, dns.RcodeSuccess},
+		{[]int{dns.RcodeNameError, dns.RcodeNameError}, dns.RcodeNameError},
+		{[]int{dns.RcodeSuccess, dns.RcodeServerFailure}, dns.RcodeServerFailure},
+		{[]int{dns.RcodeNameError, dns.RcodeRefused, dns.RcodeServerFailure}, dns.RcodeRefused},
+	}
+	for _, test := range tests {
+		if combined := combinedRcode(test.rcodes); combined != test.combined {
+			t.Errorf("combinedRcode(%v) = %d, want %d", test.rcodes, combined, test.combined)
+		}
+	}
+}
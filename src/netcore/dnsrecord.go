@@ -0,0 +1,110 @@
+package netcore
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DNSRecord is a single value of a DNS entry, in the form used by the admin
+// API. Records with attributes, such as MX and SRV, carry them in Attr; for
+// SOA records Attr holds the zone's SOA settings. Labels belong to the entry,
+// that is to every value of the name and type; adding a record sets them, and
+// a label set to the empty string is removed.
+type DNSRecord struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	TTL    uint32            `json:"ttl,omitempty"`
+	Value  string            `json:"value,omitempty"`
+	Attr   map[string]string `json:"attr,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// DNSChange adds or deletes a record. Deleting a record without a value or
+// attributes deletes every value of that name and type.
+type DNSChange struct {
+	Op     string    `json:"op"` // add or delete
+	Record DNSRecord `json:"record"`
+}
+
+// validate checks that the change is well formed and that its name is
+// within zone
+func (c *DNSChange) validate(zone string) error {
+	r := &c.Record
+	r.Name = cleanFQDN(r.Name)
+	if _, err := idnaToASCII(r.Name); err != nil {
+		return invalid("name", "%s", err)
+	}
+	if !validDomainName(r.Name) {
+		return invalid("name", "invalid name %q", r.Name)
+	}
+	if !dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(r.Name)) {
+		return invalid("name", "%s is not in zone %s", r.Name, zone)
+	}
+	rrType, name, ok := parseRecordType(r.Type)
+	if !ok {
+		return invalid("type", "%s: unknown type %q", r.Name, r.Type)
+	}
+	r.Type = name
+	r.mapTargets(asciiTarget)
+	switch c.Op {
+	case "add":
+		if err := validateLabels(r.Labels); err != nil {
+			return err
+		}
+		if rrType == dns.TypeSOA {
+			asciiSOAMeta(r.Attr)
+			if err := validateSOAMeta(r.Attr); err != nil {
+				return invalid("attr", "%s", err)
+			}
+			return nil
+		}
+		if err := validateDNSValue(rrType, &DNSValue{Value: r.Value, Attr: r.Attr}); err != nil {
+			return invalid("value", "%s %s: %s", r.Name, r.Type, err)
+		}
+		if _, err := parseDNSSchedule(r.Attr); err != nil {
+			return invalid("attr", "%s %s: %s", r.Name, r.Type, err)
+		}
+	case "delete":
+	default:
+		return invalid("op", "%s %s: op must be add or delete, not %q", r.Name, r.Type, c.Op)
+	}
+	return nil
+}
+
+// valueID returns the key that the record's value is stored under, which is
+// derived from the value so that adding the same value twice is harmless
+func (r *DNSRecord) valueID() string {
+	data := r.Value
+	if len(r.Attr) > 0 {
+		keys := make([]string, 0, len(r.Attr))
+		for k := range r.Attr {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			data += "\x00" + k + "=" + r.Attr[k]
+		}
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(data)))
+}
+
+// String describes the record for logs and the audit log
+func (r *DNSRecord) String() string {
+	s := fmt.Sprintf("%s %s %s", r.Name, r.Type, r.Value)
+	keys := make([]string, 0, len(r.Attr))
+	for k := range r.Attr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s += fmt.Sprintf(" %s=%s", k, r.Attr[k])
+	}
+	if len(r.Labels) > 0 {
+		s += " labels:" + formatLabels(r.Labels)
+	}
+	return strings.TrimSpace(s)
+}
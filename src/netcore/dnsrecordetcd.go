@@ -0,0 +1,198 @@
+package netcore
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// ErrDNSLocked is returned when another transaction holds the DNS lock for
+// too long
+var ErrDNSLocked = conflictError("Another DNS change is in progress; try again.")
+
+const (
+	dnsLockKey = "locks/dns"
+	dnsLockTTL = 30 // seconds, in case the holder dies mid-transaction
+)
+
+// ListDNSZone returns the static records of zone and the names below it.
+// Values with an expiration, such as those registered for DHCP leases, are
+// left out. SOA records carry the zone settings, less the serial.
+func (db EtcdDB) ListDNSZone(zone string) ([]DNSRecord, error) {
+	response, err := db.client.Get(etcdDNSKeyFromFQDN(zone), true, true)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []DNSRecord
+	walkDNSRRSets(response.Node, func(name, rrType string, node *etcd.Node) {
+		records = append(records, staticDNSRecords(name, rrType, etcdNodeToDNSEntry(node))...)
+	})
+	return records, nil
+}
+
+// getDNSRRSet returns the static records of a name and type, as ListDNSZone
+// would
+func (db EtcdDB) getDNSRRSet(name, rrType string) ([]DNSRecord, error) {
+	response, err := db.client.Get(dnsRRSetKey(name, rrType), true, true)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return staticDNSRecords(name, rrType, etcdNodeToDNSEntry(response.Node)), nil
+}
+
+// staticDNSRecords returns the values of entry that do not expire as
+// records. The SOA record carries the zone settings, less the serial.
+func staticDNSRecords(name, rrType string, entry *DNSEntry) []DNSRecord {
+	if rrType == "SOA" {
+		attr := make(map[string]string)
+		for k, v := range entry.Meta {
+			if k != "serial" {
+				attr[k] = v
+			}
+		}
+		return []DNSRecord{{Name: name, Type: rrType, TTL: entry.TTL, Attr: attr, Labels: entry.Labels}}
+	}
+	var records []DNSRecord
+	for _, value := range entry.Values {
+		if value.Expiration != nil {
+			continue
+		}
+		records = append(records, DNSRecord{Name: name, Type: rrType, TTL: entry.TTL, Value: value.Value, Attr: value.Attr, Labels: entry.Labels})
+	}
+	return records
+}
+
+// fqdnFromEtcdDNSKey is the inverse of etcdDNSKeyFromFQDN
+func fqdnFromEtcdDNSKey(key string) string {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(key, "/"), "dns/"), "/")
+	return strings.Join(reverseSlice(parts), ".")
+}
+
+// ApplyDNSChanges makes all of the changes or none of them. Transactions are
+// serialized with a lock in etcd, and a failed transaction is rolled back,
+// as is one that loses the lock partway through.
+// etcd cannot hide a transaction in progress, so readers may briefly see
+// part of one.
+func (db EtcdDB) ApplyDNSChanges(actor string, changes []DNSChange) error {
+	lock, err := db.lockDNS()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	before, err := db.dnsRRSets(changes)
+	if err != nil {
+		return err
+	}
+
+	var undo []func() error
+	rollback := func(cause error) error {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				logger.Printf("[DNS TX] Rollback step failed: %s\n", err)
+			}
+		}
+		return cause
+	}
+
+	for _, change := range changes {
+		if err := lock.Lost(); err != nil {
+			return rollback(err)
+		}
+		r := change.Record
+		previous, err := updateDNSRRSet(db.client, r.Name, r.Type, func(entry *DNSEntry) error {
+			return applyDNSChange(entry, change)
+		})
+		if err != nil {
+			return rollback(err)
+		}
+		undo = append(undo, func() error { return restoreDNSRRSet(db.client, r.Name, r.Type, previous) })
+	}
+
+	serialsBumped := make(map[string]bool)
+	for _, change := range changes {
+		r := change.Record
+		if zone, found := findDNSZone(db.client, r.Name); found && !serialsBumped[zone] {
+			serialsBumped[zone] = true
+			if err := db.bumpDNSSerial(zone); err != nil {
+				logger.Printf("[DNS TX] Unable to bump the SOA serial for %s: %s\n", zone, err)
+			}
+		}
+		old, new := "", r.String()
+		if change.Op == "delete" {
+			old, new = new, ""
+		}
+		auditChange(db, actor, "dns", r.Name+" "+r.Type, change.Op, old, new)
+	}
+	db.recordDNSHistory(actor, before)
+	return nil
+}
+
+// applyDNSChange makes change to entry, the RRset of its record
+func applyDNSChange(entry *DNSEntry, change DNSChange) error {
+	r := change.Record
+	switch {
+	case change.Op == "add":
+		for k, v := range r.Labels {
+			if entry.Labels == nil {
+				entry.Labels = make(map[string]string)
+			}
+			if v == "" {
+				delete(entry.Labels, k)
+			} else {
+				entry.Labels[k] = v
+			}
+		}
+		if r.Type == "SOA" {
+			if entry.Meta == nil {
+				entry.Meta = make(map[string]string)
+			}
+			for k, v := range r.Attr {
+				entry.Meta[k] = v
+			}
+			return nil
+		}
+		if id := r.valueID(); entry.value(id) == nil {
+			entry.Values = append(entry.Values, DNSValue{ID: id, Value: r.Value, Attr: r.Attr})
+		}
+		if r.TTL > 0 {
+			entry.TTL = r.TTL
+		}
+	case change.Op == "delete" && r.Value == "" && len(r.Attr) == 0:
+		if entry.empty() {
+			return notFoundError("cannot delete %s: not found", r.String())
+		}
+		*entry = DNSEntry{}
+	case change.Op == "delete":
+		if !entry.removeValue(r.valueID()) {
+			return notFoundError("cannot delete %s: not found", r.String())
+		}
+	}
+	return nil
+}
+
+// lockDNS takes the lock that serializes DNS transactions, waiting a while
+// for another transaction to finish
+func (db EtcdDB) lockDNS() (*etcdLock, error) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		lock, err := lockEtcd(db.client, dnsLockKey, dnsLockTTL)
+		if err == nil {
+			return lock, nil
+		}
+		if etcdUnreachable(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrDNSLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
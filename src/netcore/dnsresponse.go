@@ -0,0 +1,57 @@
+package netcore
+
+import (
+	"github.com/miekg/dns"
+)
+
+// defaultDNSPadding is the block size that responses are padded to, as
+// RFC 8467 recommends for responders
+const defaultDNSPadding = 468
+
+// ednsPadding is the code of the padding option of RFC 7830, which our DNS
+// library reads and writes as a local option
+const ednsPadding = 12
+
+// minimizeResponse leaves out of m the authority and additional records
+// that its answers do not need, when the zone asks for minimal responses.
+// Referrals and negative answers keep theirs, which are the answer, and so
+// do answers to DNSSEC clients, whose denial proofs may be among them.
+func minimizeResponse(m *dns.Msg, dnssecOK bool) {
+	if len(m.Answer) == 0 || dnssecOK {
+		return
+	}
+	m.Ns, m.Extra = nil, []dns.RR{}
+}
+
+// wantsPadding reports whether req carries the padding option of RFC 7830,
+// which clients behind an encrypted transport, such as a TLS proxy in
+// front of netcore, send to ask for padded responses
+func wantsPadding(req *dns.Msg) bool {
+	opt := req.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, option := range opt.Option {
+		if local, ok := option.(*dns.EDNS0_LOCAL); ok && local.Code == ednsPadding {
+			return true
+		}
+	}
+	return false
+}
+
+// padResponse pads m to a multiple of block bytes with the padding option
+// of RFC 7830, so that its size says little about the name it answers
+func padResponse(m *dns.Msg, block int, dnssecOK bool) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(4096, dnssecOK)
+		opt = m.IsEdns0()
+	}
+	// The option itself takes four bytes, code and length, before its data
+	length := m.Len() + 4
+	padding := 0
+	if length%block != 0 {
+		padding = block - length%block
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: ednsPadding, Data: make([]byte, padding)})
+}
@@ -0,0 +1,141 @@
+package netcore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dnsRewriteRule is a single rewrite rule. Rules are stored as text in the
+// dnsrewrite config directory, one rule per key, and take one of these forms:
+//
+//	name suffix old.corp. new.corp.
+//	name regex ^(.*)\.old\.corp\.$ ${1}.new.corp.
+//	type ANY A
+//	answer regex 10\.1\.(\d+)\.(\d+)$ 10.2.${1}.${2}
+//
+// Name rules change the question before it is looked up, and answers are
+// renamed back so that the client sees the name it asked for. Type rules
+// change the question type. Answer rules are applied to the text form of
+// each answer, which is then parsed back into a record.
+type dnsRewriteRule struct {
+	kind        string // name, type or answer
+	suffix      string
+	replacement string
+	pattern     *regexp.Regexp
+	fromType    uint16
+	toType      uint16
+}
+
+func parseDNSRewriteRule(rule string) (*dnsRewriteRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty rewrite rule")
+	}
+	r := &dnsRewriteRule{kind: fields[0]}
+	switch {
+	case len(fields) == 4 && r.kind == "name" && fields[1] == "suffix":
+		r.suffix = dns.Fqdn(strings.ToLower(fields[2]))
+		r.replacement = dns.Fqdn(strings.ToLower(fields[3]))
+	case len(fields) == 4 && (r.kind == "name" || r.kind == "answer") && fields[1] == "regex":
+		pattern, err := regexp.Compile(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("rewrite rule %q: %s", rule, err)
+		}
+		r.pattern = pattern
+		r.replacement = fields[3]
+	case len(fields) == 3 && r.kind == "type":
+		var ok bool
+		if r.fromType, ok = dns.StringToType[strings.ToUpper(fields[1])]; !ok {
+			return nil, fmt.Errorf("rewrite rule %q: unknown type %s", rule, fields[1])
+		}
+		if r.toType, ok = dns.StringToType[strings.ToUpper(fields[2])]; !ok {
+			return nil, fmt.Errorf("rewrite rule %q: unknown type %s", rule, fields[2])
+		}
+	default:
+		return nil, fmt.Errorf("malformed rewrite rule %q", rule)
+	}
+	return r, nil
+}
+
+// rewriteName returns the rewritten name and true if the rule applies
+func (r *dnsRewriteRule) rewriteName(name string) (string, bool) {
+	if r.pattern != nil {
+		if !r.pattern.MatchString(name) {
+			return name, false
+		}
+		return dns.Fqdn(r.pattern.ReplaceAllString(name, r.replacement)), true
+	}
+	lower := strings.ToLower(name)
+	if lower != r.suffix && !strings.HasSuffix(lower, "."+r.suffix) {
+		return name, false
+	}
+	return lower[:len(lower)-len(r.suffix)] + r.replacement, true
+}
+
+// rewriteAnswer returns the rewritten record, or the original record if the
+// rule does not apply or the rewritten text is not a valid record
+func (r *dnsRewriteRule) rewriteAnswer(rr dns.RR) dns.RR {
+	text := rr.String()
+	if !r.pattern.MatchString(text) {
+		return rr
+	}
+	rewritten, err := dns.NewRR(r.pattern.ReplaceAllString(text, r.replacement))
+	if err != nil || rewritten == nil {
+		logger.Printf("DNS rewrite of %q produced an invalid record: %v\n", text, err)
+		return rr
+	}
+	return rewritten
+}
+
+// newDNSRewriteHandler applies the zone's rewrite rules to questions on the
+// way in and to answers on the way out
+func newDNSRewriteHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	var rules []*dnsRewriteRule
+	for _, text := range cfg.DNSRewriteRules() {
+		rule, err := parseDNSRewriteRule(text)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return next, nil
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		q := *r.Question
+		for _, rule := range rules {
+			switch rule.kind {
+			case "name":
+				q.Name, _ = rule.rewriteName(q.Name)
+			case "type":
+				if q.Qtype == rule.fromType {
+					q.Qtype = rule.toType
+				}
+			}
+		}
+
+		r2 := *r
+		r2.Question = &q
+		if q != *r.Question {
+			r.Trace.Note("rewritten to %s %s", q.Name, dns.Type(q.Qtype).String())
+			logger.Printf("  [REWRITE] %s %s => %s %s\n", r.Question.Name, dns.Type(r.Question.Qtype).String(), q.Name, dns.Type(q.Qtype).String())
+		}
+		answers := next.ServeDNSQuestion(&r2)
+
+		for i := range answers {
+			answers[i] = dns.Copy(answers[i]) // answers may be shared with the cache
+			for _, rule := range rules {
+				if rule.kind == "answer" {
+					answers[i] = rule.rewriteAnswer(answers[i])
+				}
+			}
+			if answers[i].Header().Name == q.Name {
+				answers[i].Header().Name = r.Question.Name
+			}
+		}
+		return answers
+	}), nil
+}
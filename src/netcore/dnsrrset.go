@@ -0,0 +1,317 @@
+package netcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Each RRset, the values of a name and type, is stored as one JSON value at
+// dns/<name reversed, a label per key>/@<type>: its TTL, metadata and labels
+// are shared by its values, which keep their order and their own attributes
+// and expiration. RRsets used to be stored as a directory there, with a key
+// per value under val/, a key per setting and etcd TTLs for expiration; that
+// layout is still read, and each RRset is rewritten as one value the first
+// time it changes, or by MigrateDNSRRSets. etcd cannot replace a directory
+// with a value in one step, and removing the directory first would have the
+// name answer NXDOMAIN until the value is written, so that value is written
+// in the directory, at @rrset, before the keys of the old layout are
+// removed. The directory is kept, holding only that value.
+
+// dnsRRSetRetries is how many times an RRset is read again and its change
+// made again when another writer changed it first
+const dnsRRSetRetries = 10
+
+// dnsRRSetMigrated is the key, in the directory of an RRset of the key per
+// value layout, where the RRset is stored as one value once rewritten
+const dnsRRSetMigrated = "@rrset"
+
+// dnsRRSetKey is where the RRset of name and rrType is stored
+func dnsRRSetKey(name, rrType string) string {
+	return strings.TrimPrefix(etcdDNSKeyFromFQDN(name), "/") + "/@" + strings.ToLower(rrType)
+}
+
+// empty reports whether entry holds nothing worth storing: a TTL alone does
+// not keep an RRset
+func (entry *DNSEntry) empty() bool {
+	return len(entry.Values) == 0 && len(entry.Meta) == 0 && len(entry.Labels) == 0
+}
+
+// value returns the value of entry stored under id, or nil
+func (entry *DNSEntry) value(id string) *DNSValue {
+	for i := range entry.Values {
+		if entry.Values[i].ID == id {
+			return &entry.Values[i]
+		}
+	}
+	return nil
+}
+
+// setValue replaces the value of entry with the same ID, or adds v after the
+// others
+func (entry *DNSEntry) setValue(v DNSValue) {
+	if old := entry.value(v.ID); old != nil {
+		*old = v
+		return
+	}
+	entry.Values = append(entry.Values, v)
+}
+
+// removeValue removes the value stored under id, and reports whether there
+// was one
+func (entry *DNSEntry) removeValue(id string) bool {
+	for i := range entry.Values {
+		if entry.Values[i].ID == id {
+			entry.Values = append(entry.Values[:i], entry.Values[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// overlay adds to entry the values and settings of legacy, read from the
+// keys of the old layout next to entry, replacing those it already has:
+// they are what an older instance wrote, or a rewrite did not remove yet
+func (entry *DNSEntry) overlay(legacy *DNSEntry) {
+	for _, v := range legacy.Values {
+		entry.setValue(v)
+	}
+	if legacy.TTL != 0 {
+		entry.TTL = legacy.TTL
+	}
+	for k, v := range legacy.Meta {
+		if entry.Meta == nil {
+			entry.Meta = make(map[string]string)
+		}
+		entry.Meta[k] = v
+	}
+	for k, v := range legacy.Labels {
+		if entry.Labels == nil {
+			entry.Labels = make(map[string]string)
+		}
+		entry.Labels[k] = v
+	}
+}
+
+// dnsExpiration is the expiration of a value registered for ttl seconds, or
+// nil for a value that does not expire
+func dnsExpiration(ttl uint64, now time.Time) *time.Time {
+	if ttl == 0 {
+		return nil
+	}
+	expires := now.Add(time.Duration(ttl) * time.Second).UTC()
+	return &expires
+}
+
+// decodeDNSRRSet reads an RRset stored as one value, with the remaining
+// lifetime of its expiring values as their TTL
+func decodeDNSRRSet(node *etcd.Node) *DNSEntry {
+	entry := &DNSEntry{}
+	if err := json.Unmarshal([]byte(node.Value), entry); err != nil {
+		logger.Printf("[DNS] Skipping unreadable RRset %s: %s\n", node.Key, err)
+		return &DNSEntry{}
+	}
+	now := time.Now()
+	for i := range entry.Values {
+		if expires := entry.Values[i].Expiration; expires != nil && expires.After(now) {
+			entry.Values[i].TTL = uint32(expires.Sub(now).Seconds() + 0.5)
+		}
+	}
+	return entry
+}
+
+// updateDNSRRSet reads the RRset of name and rrType, in either layout, has
+// update change it, and stores it as one value, or removes it once it is
+// empty. The RRset is only written if it has not changed since it was read;
+// otherwise it is read again and update called again, so concurrent writers
+// never lose each other's values. It returns the RRset as it was before, or
+// nil if there was none.
+func updateDNSRRSet(etc etcdClient, name, rrType string, update func(entry *DNSEntry) error) (*DNSEntry, error) {
+	key := dnsRRSetKey(name, rrType)
+	var rewritten *DNSEntry // the RRset before a rewrite that was interrupted
+	for attempt := 0; attempt < dnsRRSetRetries; attempt++ {
+		var node *etcd.Node
+		response, err := etc.Get(key, false, true)
+		if err != nil && !etcdKeyNotFound(err) {
+			return nil, err
+		}
+		entry, before := &DNSEntry{}, (*DNSEntry)(nil)
+		if err == nil && response != nil && response.Node != nil {
+			node = response.Node
+			entry, before = etcdNodeToDNSEntry(node), etcdNodeToDNSEntry(node)
+		}
+		if err := update(entry); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case node != nil && node.Dir:
+			// The RRset is in the key per value layout, or was rewritten
+			// in its directory. A rewrite that another writer interrupts
+			// leaves the RRset readable, with this change made: the
+			// change is made again on what the RRset has become, but
+			// the RRset it was before is still the one returned.
+			var wrote bool
+			wrote, err = rewriteLegacyDNSRRSet(etc, key, node, entry, data)
+			if wrote && rewritten == nil {
+				rewritten = before
+			}
+			if err == nil && rewritten != nil {
+				return rewritten, nil
+			}
+		case node != nil && entry.empty():
+			_, err = etc.CompareAndDelete(key, "", node.ModifiedIndex)
+		case node != nil:
+			_, err = etc.CompareAndSwap(key, string(data), 0, "", node.ModifiedIndex)
+		case entry.empty():
+			return nil, nil
+		default:
+			_, err = etc.Create(key, string(data), 0)
+		}
+		if etcdCompareFailed(err) || etcdKeyExists(err) || etcdKeyNotFound(err) || etcdDirNotEmpty(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return before, nil
+	}
+	return nil, conflictError(fmt.Sprintf("Too many concurrent changes to %s %s; try again.", name, strings.ToUpper(rrType)))
+}
+
+// rewriteLegacyDNSRRSet stores entry, marshalled as data, in place of the
+// RRset that node holds in its directory. The value at dnsRRSetMigrated is
+// written first, so that the RRset stays readable, and the keys of the old
+// layout are then removed, each only if no other writer changed it since
+// node was read: a conflict error has the caller read the RRset again. It
+// reports whether the value was written.
+func rewriteLegacyDNSRRSet(etc etcdClient, key string, node *etcd.Node, entry *DNSEntry, data []byte) (bool, error) {
+	migrated := key + "/" + dnsRRSetMigrated
+	var previous *etcd.Node
+	for _, child := range node.Nodes {
+		if path.Base(child.Key) == dnsRRSetMigrated {
+			previous = child
+		}
+	}
+	var err error
+	switch {
+	case !entry.empty() && previous == nil:
+		_, err = etc.Create(migrated, string(data), 0)
+	case !entry.empty():
+		_, err = etc.CompareAndSwap(migrated, string(data), 0, "", previous.ModifiedIndex)
+	case previous != nil:
+		_, err = etc.CompareAndDelete(migrated, "", previous.ModifiedIndex)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := deleteLegacyDNSKeys(etc, node); err != nil {
+		return true, err
+	}
+	if entry.empty() {
+		if _, err := etc.DeleteDir(key); err != nil && !etcdKeyNotFound(err) {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// deleteLegacyDNSKeys removes the keys of the old layout under node, the
+// directory of an RRset, unless they changed since node was read
+func deleteLegacyDNSKeys(etc etcdClient, node *etcd.Node) error {
+	for _, child := range node.Nodes {
+		switch {
+		case path.Base(child.Key) == dnsRRSetMigrated:
+		case child.Dir:
+			if err := deleteLegacyDNSKeys(etc, child); err != nil {
+				return err
+			}
+			if _, err := etc.DeleteDir(child.Key); err != nil && !etcdKeyNotFound(err) {
+				return err
+			}
+		default:
+			if _, err := etc.CompareAndDelete(child.Key, "", child.ModifiedIndex); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// legacyDNSRRSet reports whether node, an RRset, still has keys of the old
+// layout
+func legacyDNSRRSet(node *etcd.Node) bool {
+	if !node.Dir {
+		return false
+	}
+	for _, child := range node.Nodes {
+		if path.Base(child.Key) != dnsRRSetMigrated {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreDNSRRSet puts back an RRset as updateDNSRRSet returned it
+func restoreDNSRRSet(etc etcdClient, name, rrType string, before *DNSEntry) error {
+	_, err := updateDNSRRSet(etc, name, rrType, func(entry *DNSEntry) error {
+		*entry = DNSEntry{}
+		if before != nil {
+			*entry = *before
+		}
+		return nil
+	})
+	return err
+}
+
+// walkDNSRRSets calls visit with the name, type and node of each RRset under
+// root, in either layout
+func walkDNSRRSets(root *etcd.Node, visit func(name, rrType string, node *etcd.Node)) {
+	for _, child := range root.Nodes {
+		base := path.Base(child.Key)
+		if strings.HasPrefix(base, "@") {
+			visit(fqdnFromEtcdDNSKey(root.Key), strings.ToUpper(strings.TrimPrefix(base, "@")), child)
+		} else if child.Dir {
+			walkDNSRRSets(child, visit)
+		}
+	}
+}
+
+// MigrateDNSRRSets rewrites the RRsets still stored with a key per value as
+// one value each, and returns how many it rewrote. RRsets are rewritten as
+// any other change makes them, so it is safe to run while serving, and to
+// run again.
+func (db EtcdDB) MigrateDNSRRSets() (int, error) {
+	response, err := db.client.Get("dns", false, true)
+	if etcdKeyNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	type rrset struct{ name, rrType string }
+	var legacy []rrset
+	walkDNSRRSets(response.Node, func(name, rrType string, node *etcd.Node) {
+		if legacyDNSRRSet(node) {
+			legacy = append(legacy, rrset{name, rrType})
+		}
+	})
+	migrated := 0
+	for _, set := range legacy {
+		if _, err := updateDNSRRSet(db.client, set.name, set.rrType, func(*DNSEntry) error { return nil }); err != nil {
+			return migrated, fmt.Errorf("migrating %s %s: %s", set.name, set.rrType, err)
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		logger.Printf("[DNS] Migrated %d RRsets to one value each\n", migrated)
+	}
+	return migrated, nil
+}
@@ -0,0 +1,89 @@
+package netcore
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// interruptingKV runs interrupt before the first compare-and-delete, as
+// another writer would between a read and a write
+type interruptingKV struct {
+	etcdClient
+	interrupt func()
+}
+
+func (kv *interruptingKV) CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	if interrupt := kv.interrupt; interrupt != nil {
+		kv.interrupt = nil
+		interrupt()
+	}
+	return kv.etcdClient.CompareAndDelete(key, prevValue, prevIndex)
+}
+
+func rrsetValues(t *testing.T, db EtcdDB, name, rrType string) []string {
+	entry, err := db.GetDNS(name, rrType)
+	if err != nil {
+		t.Fatalf("GetDNS(%s, %s): %s", name, rrType, err)
+	}
+	var values []string
+	for _, v := range entry.Values {
+		values = append(values, v.Value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func TestMigrateDNSRRSets(t *testing.T) {
+	kv := newMemKV()
+	db := EtcdDB{&interruptingKV{etcdClient: kv}}
+	key := dnsRRSetKey("www.example.com", "A")
+	kv.Set(key+"/val/one", "192.0.2.1", 0)
+	kv.Set(key+"/val/two", "192.0.2.2", 0)
+	kv.Set(key+"/ttl", "300", 0)
+
+	db.client.(*interruptingKV).interrupt = func() {
+		// The RRset reads whole while it is rewritten
+		if values := rrsetValues(t, db, "www.example.com", "A"); len(values) != 2 {
+			t.Errorf("during the rewrite, values = %v, want both", values)
+		}
+		// An older instance changes a value of the old layout
+		kv.Set(key+"/val/one", "192.0.2.10", 0)
+		kv.Set(key+"/val/three", "192.0.2.3", 0)
+	}
+	migrated, err := db.MigrateDNSRRSets()
+	if err != nil || migrated != 1 {
+		t.Fatalf("MigrateDNSRRSets() = %d, %v; want 1, nil", migrated, err)
+	}
+
+	want := []string{"192.0.2.10", "192.0.2.2", "192.0.2.3"}
+	if values := rrsetValues(t, db, "www.example.com", "A"); len(values) != len(want) || values[0] != want[0] || values[1] != want[1] || values[2] != want[2] {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+	response, err := kv.Get(key, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacyDNSRRSet(response.Node) {
+		t.Errorf("keys of the old layout are left: %+v", response.Node.Nodes)
+	}
+	if entry, _ := db.GetDNS("www.example.com", "A"); entry.TTL != 300 {
+		t.Errorf("TTL = %d, want 300", entry.TTL)
+	}
+	if migrated, err := db.MigrateDNSRRSets(); err != nil || migrated != 0 {
+		t.Errorf("MigrateDNSRRSets() again = %d, %v; want 0, nil", migrated, err)
+	}
+
+	// A rewritten RRset that loses its last value is removed
+	_, err = updateDNSRRSet(db.client, "www.example.com", "A", func(entry *DNSEntry) error {
+		entry.Values = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get(key, false, false); !etcdKeyNotFound(err) {
+		t.Errorf("the RRset is left after removing every value: %v", err)
+	}
+}
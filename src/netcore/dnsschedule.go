@@ -0,0 +1,224 @@
+package netcore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A DNS value can be limited to a window of time with these attributes, so
+// that temporary records, such as a CNAME to a maintenance page, can be
+// staged in advance and disappear on their own:
+//
+//	notbefore  answered from this time on (RFC 3339)
+//	notafter   no longer answered from this time on (RFC 3339), after which
+//	           the expire duty removes the value
+//	schedule   days and a daily window in which the value is answered, such
+//	           as "sat,sun 02:00-04:00", "mon-fri 22:00-06:00" (a window
+//	           that ends before it starts runs past midnight) or
+//	           "* 12:00-13:00"
+//	timezone   the zone the schedule is in, such as "Europe/Paris"; UTC by
+//	           default
+//
+// While a name has values of a type with a window, answers are given a TTL
+// no longer than the time until the next change, so that caches follow.
+type dnsSchedule struct {
+	notBefore, notAfter time.Time
+	daily               bool
+	days                [7]bool // by time.Weekday
+	start, end          time.Duration
+	location            *time.Location
+}
+
+var dnsWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseDNSSchedule reads the window of a value from its attributes, or
+// returns nil if it has none
+func parseDNSSchedule(attr map[string]string) (*dnsSchedule, error) {
+	notBefore, hasNotBefore := attr["notbefore"]
+	notAfter, hasNotAfter := attr["notafter"]
+	schedule, hasSchedule := attr["schedule"]
+	if !hasNotBefore && !hasNotAfter && !hasSchedule {
+		return nil, nil
+	}
+	s := &dnsSchedule{location: time.UTC}
+	var err error
+	if hasNotBefore {
+		if s.notBefore, err = time.Parse(time.RFC3339, notBefore); err != nil {
+			return nil, fmt.Errorf("invalid notbefore %q: not an RFC 3339 time", notBefore)
+		}
+	}
+	if hasNotAfter {
+		if s.notAfter, err = time.Parse(time.RFC3339, notAfter); err != nil {
+			return nil, fmt.Errorf("invalid notafter %q: not an RFC 3339 time", notAfter)
+		}
+	}
+	if name, ok := attr["timezone"]; ok {
+		if s.location, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %s", name, err)
+		}
+	}
+	if hasSchedule {
+		if err := s.parseDaily(schedule); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", schedule, err)
+		}
+	}
+	return s, nil
+}
+
+// parseDaily reads a schedule such as "mon-fri 22:00-06:00"
+func (s *dnsSchedule) parseDaily(schedule string) error {
+	fields := strings.Fields(strings.ToLower(schedule))
+	if len(fields) != 2 {
+		return fmt.Errorf("expected days and a window, such as \"mon-fri 08:00-18:00\"")
+	}
+	for _, days := range strings.Split(fields[0], ",") {
+		if days == "*" {
+			s.days = [7]bool{true, true, true, true, true, true, true}
+			continue
+		}
+		bounds := strings.SplitN(days, "-", 2)
+		first, ok := dnsWeekdays[bounds[0]]
+		last := first
+		if ok && len(bounds) == 2 {
+			last, ok = dnsWeekdays[bounds[1]]
+		}
+		if !ok {
+			return fmt.Errorf("unknown days %q", days)
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			s.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	window := strings.SplitN(fields[1], "-", 2)
+	if len(window) != 2 {
+		return fmt.Errorf("expected a window such as 08:00-18:00")
+	}
+	var err error
+	if s.start, err = parseTimeOfDay(window[0]); err != nil {
+		return err
+	}
+	if s.end, err = parseTimeOfDay(window[1]); err != nil {
+		return err
+	}
+	if s.end <= s.start {
+		s.end += 24 * time.Hour
+	}
+	s.daily = true
+	return nil
+}
+
+// parseTimeOfDay reads HH:MM as the time since midnight
+func parseTimeOfDay(clock string) (time.Duration, error) {
+	parts := strings.SplitN(clock, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", clock)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", clock)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || hours == 24 && minutes > 0 {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", clock)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// windows calls f with the daily windows that may contain or follow now
+func (s *dnsSchedule) windows(now time.Time, f func(start, end time.Time)) {
+	local := now.In(s.location)
+	year, month, day := local.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, s.location)
+	for i := -1; i <= 7; i++ {
+		date := midnight.AddDate(0, 0, i)
+		if s.days[date.Weekday()] {
+			f(date.Add(s.start), date.Add(s.end))
+		}
+	}
+}
+
+// active reports whether the value is answered at now
+func (s *dnsSchedule) active(now time.Time) bool {
+	if !s.notBefore.IsZero() && now.Before(s.notBefore) {
+		return false
+	}
+	if !s.notAfter.IsZero() && !now.Before(s.notAfter) {
+		return false
+	}
+	if !s.daily {
+		return true
+	}
+	in := false
+	s.windows(now, func(start, end time.Time) {
+		if !now.Before(start) && now.Before(end) {
+			in = true
+		}
+	})
+	return in
+}
+
+// ended reports whether the value will never be answered again
+func (s *dnsSchedule) ended(now time.Time) bool {
+	return !s.notAfter.IsZero() && !now.Before(s.notAfter)
+}
+
+// nextChange returns when the value next starts or stops being answered, or
+// the zero time if it never will
+func (s *dnsSchedule) nextChange(now time.Time) time.Time {
+	var next time.Time
+	consider := func(t time.Time) {
+		if t.After(now) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	consider(s.notBefore)
+	consider(s.notAfter)
+	if s.daily {
+		s.windows(now, func(start, end time.Time) {
+			consider(start)
+			consider(end)
+		})
+	}
+	if !s.notAfter.IsZero() && next.After(s.notAfter) {
+		return time.Time{}
+	}
+	return next
+}
+
+// dnsValueActive reports whether value is answered at now, and caps ttl to
+// the time until that changes
+func dnsValueActive(value *DNSValue, now time.Time, ttl *uint32) bool {
+	s, err := parseDNSSchedule(value.Attr)
+	if s == nil || err != nil {
+		return err == nil
+	}
+	if next := s.nextChange(now); !next.IsZero() {
+		if remaining := uint32(next.Sub(now)/time.Second) + 1; remaining < *ttl {
+			*ttl = remaining
+		}
+	}
+	return s.active(now)
+}
+
+// dnsEntryActive reports whether entry has a value answered at now, or has
+// no values at all, such as an SOA entry
+func dnsEntryActive(entry *DNSEntry, now time.Time) bool {
+	if len(entry.Values) == 0 {
+		return true
+	}
+	for i := range entry.Values {
+		var ttl uint32
+		if dnsValueActive(&entry.Values[i], now, &ttl) {
+			return true
+		}
+	}
+	return false
+}
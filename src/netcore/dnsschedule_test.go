@@ -0,0 +1,82 @@
+package netcore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDNSSchedule(t *testing.T) {
+	s, err := parseDNSSchedule(map[string]string{"schedule": "mon-fri 22:00-06:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2015, time.June, day, c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+	// June 1st 2015 is a Monday
+	tests := []struct {
+		now    time.Time
+		active bool
+		next   time.Time
+	}{
+		{at(1, "21:59"), false, at(1, "22:00")},
+		{at(1, "22:00"), true, at(2, "06:00")},
+		{at(2, "05:59"), true, at(2, "06:00")},
+		{at(2, "06:00"), false, at(2, "22:00")},
+		{at(6, "03:00"), true, at(6, "06:00")}, // Friday night runs into Saturday
+		{at(6, "23:00"), false, at(8, "22:00")},
+	}
+	for _, test := range tests {
+		if active := s.active(test.now); active != test.active {
+			t.Errorf("active at %s = %v, want %v", test.now, active, test.active)
+		}
+		if next := s.nextChange(test.now); !next.Equal(test.next) {
+			t.Errorf("nextChange at %s = %s, want %s", test.now, next, test.next)
+		}
+	}
+
+	s, err = parseDNSSchedule(map[string]string{"notbefore": "2015-06-01T00:00:00Z", "notafter": "2015-06-02T00:00:00Z", "schedule": "* 12:00-13:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.active(at(1, "11:00")) || !s.active(at(1, "12:30")) || s.active(at(2, "12:30")) {
+		t.Errorf("a window is not limited to notbefore and notafter")
+	}
+	if next := s.nextChange(at(1, "13:00")); !next.Equal(at(2, "00:00")) {
+		t.Errorf("nextChange after the last window = %s, want notafter", next)
+	}
+	if next := s.nextChange(at(2, "00:00")); !next.IsZero() {
+		t.Errorf("nextChange after notafter = %s, want never", next)
+	}
+	if !s.ended(at(2, "00:00")) || s.ended(at(1, "23:59")) {
+		t.Errorf("ended is not notafter")
+	}
+
+	for _, invalid := range []map[string]string{
+		{"notbefore": "tomorrow"},
+		{"schedule": "weekends 10:00-12:00"},
+		{"schedule": "mon 25:00-26:00"},
+		{"schedule": "mon 10:00"},
+		{"schedule": "* 10:00-12:00", "timezone": "Mars/Olympus_Mons"},
+	} {
+		if _, err := parseDNSSchedule(invalid); err == nil {
+			t.Errorf("parseDNSSchedule(%v) succeeded, want an error", invalid)
+		}
+	}
+}
+
+func TestDNSValueActiveCapsTTL(t *testing.T) {
+	now := time.Date(2015, time.June, 1, 11, 59, 0, 0, time.UTC)
+	value := &DNSValue{Value: "maintenance.example.com.", Attr: map[string]string{"schedule": "* 12:00-13:00"}}
+	ttl := uint32(3600)
+	if dnsValueActive(value, now, &ttl) {
+		t.Errorf("value active before its window")
+	}
+	if ttl != 61 {
+		t.Errorf("TTL capped to %d, want 61", ttl)
+	}
+	if !dnsValueActive(&DNSValue{Value: "www.example.com."}, now, &ttl) {
+		t.Errorf("a value without a window is not active")
+	}
+}
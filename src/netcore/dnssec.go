@@ -0,0 +1,427 @@
+package netcore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	dnssecKeyCheck    = Flags.Duration("dnsseckeycheck", time.Hour, "How often the leader moves DNSSEC keys through their lifecycle (0 to disable).")
+	dnssecZSKLifetime = Flags.Duration("dnsseczsklifetime", 90*24*time.Hour, "How long a zone signing key signs before the leader replaces it (0 to keep it).")
+	dnssecPublish     = Flags.Duration("dnssecpublish", 48*time.Hour, "How long a new DNSSEC key is published before it signs, and an old one after it stops; it must exceed the TTL of the DNSKEY and DS records.")
+	dnssecValidity    = Flags.Duration("dnssecvalidity", 14*24*time.Hour, "How long the signatures netcore makes are valid; they are made again when a quarter of that is left.")
+	dnssecKeyFile     = Flags.String("dnsseckeyfile", "", "File holding the 32 byte key, in hex, that DNSSEC private keys are encrypted with (AES-256-GCM) in the database; they are stored as they are without it or -dnsseckms.")
+	dnssecKMS         = Flags.String("dnsseckms", "", "URL of a key management service that DNSSEC private keys are wrapped by instead, which is POSTed {\"plaintext\"} at <URL>/wrap and {\"ciphertext\"} at <URL>/unwrap, both base64.")
+)
+
+// DNSSECKeyDB stores the keys our zones are signed with
+type DNSSECKeyDB interface {
+	ListDNSSECKeys(zone string) ([]DNSSECKey, error) // of every zone if zone is empty
+	SaveDNSSECKey(actor string, key DNSSECKey) error
+	DeleteDNSSECKey(actor string, zone string, keyTag uint16) error
+}
+
+// Roles of a DNSSEC key: key signing keys sign the DNSKEY set and are
+// what the parent's DS records point to, zone signing keys sign the rest
+const (
+	KeyKSK = "ksk"
+	KeyZSK = "zsk"
+)
+
+// States of a DNSSEC key. A new key is published first, so that caches
+// know it before anything is signed with it, and an old one stays
+// published after it is retired, until the signatures it made are gone.
+const (
+	KeyPublished = "published" // in the DNSKEY set, not signing yet
+	KeyActive    = "active"    // signing
+	KeyRetired   = "retired"   // in the DNSKEY set, no longer signing
+)
+
+// dnssecKeyTTL is the TTL of DNSKEY, CDS and CDNSKEY records
+const dnssecKeyTTL = 3600
+
+// DNSSECKey is a key of a zone we sign, with its private half sealed as
+// -dnsseckeyfile or -dnsseckms ask
+type DNSSECKey struct {
+	Zone      string    `json:"zone"`
+	Role      string    `json:"role"`
+	KeyTag    uint16    `json:"key_tag"`
+	Algorithm uint8     `json:"algorithm"`
+	State     string    `json:"state"`
+	DNSKEY    string    `json:"dnskey"`            // in zone file format
+	Private   string    `json:"private,omitempty"` // BIND private key format, "aes:" or "kms:" and the sealed key, or empty for keys held elsewhere
+	Created   time.Time `json:"created"`
+	Changed   time.Time `json:"changed"` // when it entered its state
+}
+
+// dnssecAlgorithms are the algorithms keys can be made with, and their sizes
+var dnssecAlgorithms = map[string]struct {
+	number uint8
+	bits   int
+}{
+	"RSASHA256":       {dns.RSASHA256, 2048},
+	"ECDSAP256SHA256": {dns.ECDSAP256SHA256, 256},
+	"ECDSAP384SHA384": {dns.ECDSAP384SHA384, 384},
+}
+
+// key returns the public key
+func (k *DNSSECKey) key() (*dns.DNSKEY, error) {
+	rr, err := dns.NewRR(k.DNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("key %d of %s is not a DNSKEY record", k.KeyTag, k.Zone)
+	}
+	return key, nil
+}
+
+// generateDNSSECKey makes a new key for zone, whose key tag is not one of
+// taken
+func generateDNSSECKey(zone, role, algorithm string, state string, taken map[uint16]bool, now time.Time) (*DNSSECKey, error) {
+	alg, ok := dnssecAlgorithms[strings.ToUpper(algorithm)]
+	if !ok {
+		return nil, invalid("algorithm", "unsupported algorithm %q; use RSASHA256, ECDSAP256SHA256 or ECDSAP384SHA384", algorithm)
+	}
+	for {
+		key := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: dnssecKeyTTL},
+			Flags:     dns.ZONE,
+			Protocol:  3,
+			Algorithm: alg.number,
+		}
+		if role == KeyKSK {
+			key.Flags |= dns.SEP
+		}
+		private, err := key.Generate(alg.bits)
+		if err != nil {
+			return nil, err
+		}
+		if taken[key.KeyTag()] {
+			continue
+		}
+		sealed, err := sealPrivateKey(key.PrivateKeyString(private))
+		if err != nil {
+			return nil, err
+		}
+		return &DNSSECKey{
+			Zone:      dns.Fqdn(zone),
+			Role:      role,
+			KeyTag:    key.KeyTag(),
+			Algorithm: alg.number,
+			State:     state,
+			DNSKEY:    key.String(),
+			Private:   sealed,
+			Created:   now.UTC(),
+			Changed:   now.UTC(),
+		}, nil
+	}
+}
+
+// sealPrivateKey encrypts a private key with -dnsseckeyfile, or has it
+// wrapped by -dnsseckms
+func sealPrivateKey(text string) (string, error) {
+	switch {
+	case *dnssecKMS != "":
+		ciphertext, err := callKMS("wrap", "plaintext", "ciphertext", []byte(text))
+		if err != nil {
+			return "", err
+		}
+		return "kms:" + base64.StdEncoding.EncodeToString(ciphertext), nil
+	case *dnssecKeyFile != "":
+		gcm, err := dnssecKeyCipher()
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		return "aes:" + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(text), nil)), nil
+	}
+	return text, nil
+}
+
+// openPrivateKey reverses sealPrivateKey, whichever way the key was sealed
+func openPrivateKey(sealed string) (string, error) {
+	switch {
+	case strings.HasPrefix(sealed, "kms:"):
+		if *dnssecKMS == "" {
+			return "", errors.New("the key was wrapped by a key management service, but -dnsseckms is not set")
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(sealed[4:])
+		if err != nil {
+			return "", err
+		}
+		text, err := callKMS("unwrap", "ciphertext", "plaintext", ciphertext)
+		return string(text), err
+	case strings.HasPrefix(sealed, "aes:"):
+		gcm, err := dnssecKeyCipher()
+		if err != nil {
+			return "", err
+		}
+		data, err := base64.StdEncoding.DecodeString(sealed[4:])
+		if err != nil {
+			return "", err
+		}
+		if len(data) < gcm.NonceSize() {
+			return "", errors.New("the encrypted key is truncated")
+		}
+		text, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+		return string(text), err
+	}
+	return sealed, nil
+}
+
+func dnssecKeyCipher() (cipher.AEAD, error) {
+	if *dnssecKeyFile == "" {
+		return nil, errors.New("the key was encrypted, but -dnsseckeyfile is not set")
+	}
+	data, err := ioutil.ReadFile(*dnssecKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(kek) != 32 {
+		return nil, fmt.Errorf("%s must hold 32 bytes in hex", *dnssecKeyFile)
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// callKMS posts data to the key management service as field, and returns
+// what it answers as result
+func callKMS(operation, field, result string, data []byte) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{field: base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(strings.TrimSuffix(*dnssecKMS, "/")+"/"+operation, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("key management service responded %s", response.Status)
+	}
+	var answer map[string]string
+	if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(answer[result])
+}
+
+// dsDigest returns the DS record the parent zone should publish for a key
+// signing key
+func (k *DNSSECKey) dsDigest() *dns.DS {
+	key, err := k.key()
+	if err != nil {
+		return nil
+	}
+	return key.ToDS(dns.SHA256)
+}
+
+// dnssecWaiting is the key signing keys the leader has announced are
+// waiting for their DS record, so that it only does so once
+var dnssecWaiting = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: make(map[string]bool)}
+
+// rollDNSSECKeys moves the keys of every signed zone through their
+// lifecycle. Zone signing keys are replaced every -dnsseczsklifetime on
+// their own. Key signing keys are replaced when asked through the API: the
+// new key is published, along with CDS and CDNSKEY records for it, and
+// becomes active once the parent zone has its DS record.
+func rollDNSSECKeys(cfg *Config) error {
+	keys, err := cfg.db.ListDNSSECKeys("")
+	if err != nil {
+		return err
+	}
+	// Keys imported without their private half are rolled over by
+	// whoever holds them
+	zones := make(map[string][]DNSSECKey)
+	for _, key := range keys {
+		if key.Private != "" {
+			zones[key.Zone] = append(zones[key.Zone], key)
+		}
+	}
+	now := time.Now()
+	for zone, keys := range zones {
+		save, remove, err := planDNSSECKeys(keys, now, func(key *DNSSECKey) bool {
+			return parentHasDS(cfg, key)
+		})
+		if err != nil {
+			logger.Printf("[DNSSEC] Keys of %s not rolled: %s\n", zone, err)
+			continue
+		}
+		for _, key := range save {
+			if err := cfg.db.SaveDNSSECKey("dnssec", key); err != nil {
+				return err
+			}
+			logger.Printf("[DNSSEC] %s key %d of %s is now %s\n", strings.ToUpper(key.Role), key.KeyTag, zone, key.State)
+		}
+		for _, key := range remove {
+			if err := cfg.db.DeleteDNSSECKey("dnssec", key.Zone, key.KeyTag); err != nil {
+				return err
+			}
+			logger.Printf("[DNSSEC] %s key %d of %s is removed\n", strings.ToUpper(key.Role), key.KeyTag, zone)
+		}
+	}
+	return nil
+}
+
+// planDNSSECKeys returns the keys of a zone whose state changes at now,
+// new keys included, and those to remove. dsPublished reports whether the
+// parent zone has the DS record of a key signing key.
+func planDNSSECKeys(keys []DNSSECKey, now time.Time, dsPublished func(key *DNSSECKey) bool) (save, remove []DNSSECKey, err error) {
+	taken := make(map[uint16]bool)
+	for _, key := range keys {
+		taken[key.KeyTag] = true
+	}
+	for _, role := range []string{KeyKSK, KeyZSK} {
+		var active, published *DNSSECKey
+		var retired []DNSSECKey
+		for i := range keys {
+			key := &keys[i]
+			if key.Role != role {
+				continue
+			}
+			switch key.State {
+			case KeyActive:
+				active = key
+			case KeyPublished:
+				published = key
+			case KeyRetired:
+				retired = append(retired, *key)
+			}
+		}
+
+		for _, key := range retired {
+			if now.Sub(key.Changed) >= *dnssecPublish {
+				remove = append(remove, key)
+			}
+		}
+
+		switch {
+		case published != nil && now.Sub(published.Changed) >= *dnssecPublish:
+			if role == KeyKSK && !dsPublished(published) {
+				announceDSWaiting(published)
+				continue
+			}
+			save = append(save, activateDNSSECKey(keys, *published, now)...)
+
+		case role == KeyZSK && published == nil && active == nil:
+			// A zone with no zone signing key gets one signing at once
+			key, err := generateDNSSECKey(keys[0].Zone, KeyZSK, keyAlgorithm(keys), KeyActive, taken, now)
+			if err != nil {
+				return nil, nil, err
+			}
+			save = append(save, *key)
+
+		case role == KeyZSK && published == nil && *dnssecZSKLifetime > 0 && now.Sub(active.Changed) >= *dnssecZSKLifetime-*dnssecPublish:
+			key, err := generateDNSSECKey(active.Zone, KeyZSK, keyAlgorithm(keys), KeyPublished, taken, now)
+			if err != nil {
+				return nil, nil, err
+			}
+			save = append(save, *key)
+		}
+	}
+	return save, remove, nil
+}
+
+// activateDNSSECKey returns the changes that make key sign in place of the
+// active key of its role, which is retired
+func activateDNSSECKey(keys []DNSSECKey, key DNSSECKey, now time.Time) []DNSSECKey {
+	var save []DNSSECKey
+	for _, other := range keys {
+		if other.Role == key.Role && other.State == KeyActive && other.KeyTag != key.KeyTag {
+			other.State, other.Changed = KeyRetired, now.UTC()
+			save = append(save, other)
+		}
+	}
+	key.State, key.Changed = KeyActive, now.UTC()
+	return append(save, key)
+}
+
+// keyAlgorithm returns the name of the algorithm of a zone's keys
+func keyAlgorithm(keys []DNSSECKey) string {
+	for name, alg := range dnssecAlgorithms {
+		if alg.number == keys[0].Algorithm {
+			return name
+		}
+	}
+	return "ECDSAP256SHA256"
+}
+
+// announceDSWaiting publishes a dnssec.ds event, once, saying which DS
+// record the parent zone must publish for a new key signing key to be used
+func announceDSWaiting(key *DNSSECKey) {
+	id := fmt.Sprintf("%s/%d", key.Zone, key.KeyTag)
+	dnssecWaiting.Lock()
+	announced := dnssecWaiting.keys[id]
+	dnssecWaiting.keys[id] = true
+	dnssecWaiting.Unlock()
+	if announced {
+		return
+	}
+	ds := ""
+	if digest := key.dsDigest(); digest != nil {
+		ds = digest.String()
+	}
+	events.Publish(Event{
+		Type:     "dnssec.ds",
+		Severity: "warning",
+		Message:  fmt.Sprintf("Key %d of %s waits for its DS record in the parent zone: %s", key.KeyTag, key.Zone, ds),
+		Data:     map[string]string{"zone": key.Zone, "key_tag": fmt.Sprint(key.KeyTag), "ds": ds},
+	})
+}
+
+// parentHasDS asks the forwarders whether the DS set of the key's zone has
+// the key's digest
+func parentHasDS(cfg *Config, key *DNSSECKey) bool {
+	public, err := key.key()
+	if err != nil {
+		return false
+	}
+	req := new(dns.Msg)
+	req.SetQuestion(key.Zone, dns.TypeDS)
+	req.RecursionDesired = true
+	client := &dns.Client{Net: "tcp", ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second}
+	for _, server := range cfg.DNSForwarders() {
+		response, _, err := client.Exchange(req, strings.TrimSpace(server))
+		if err != nil {
+			continue
+		}
+		for _, rr := range response.Answer {
+			ds, ok := rr.(*dns.DS)
+			if !ok || ds.KeyTag != key.KeyTag || ds.Algorithm != key.Algorithm {
+				continue
+			}
+			if mine := public.ToDS(ds.DigestType); mine != nil && strings.EqualFold(mine.Digest, ds.Digest) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package netcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNSSEC keys are kept under /dnssec/keys/<zone>/<key tag>, as JSON. The
+// audit log follows their states, never their private halves.
+
+func dnssecKeyKey(zone string, keyTag uint16) string {
+	return "dnssec/keys/" + strings.TrimSuffix(strings.ToLower(zone), ".") + "/" + strconv.Itoa(int(keyTag))
+}
+
+func (db EtcdDB) ListDNSSECKeys(zone string) ([]DNSSECKey, error) {
+	dir := "dnssec/keys"
+	if zone != "" {
+		dir += "/" + strings.TrimSuffix(strings.ToLower(zone), ".")
+	}
+	response, err := db.client.Get(dir, true, true)
+	if etcdKeyNotFound(err) {
+		return []DNSSECKey{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodes := response.Node.Nodes
+	if zone == "" {
+		nodes = nil
+		for _, zone := range response.Node.Nodes {
+			nodes = append(nodes, zone.Nodes...)
+		}
+	}
+	keys := []DNSSECKey{}
+	for _, node := range nodes {
+		var key DNSSECKey
+		if err := json.Unmarshal([]byte(node.Value), &key); err != nil {
+			logger.Printf("[DNSSEC] Skipping unreadable key %s: %s\n", node.Key, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SaveDNSSECKey stores the key, auditing changes of its state
+func (db EtcdDB) SaveDNSSECKey(actor string, key DNSSECKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	response, err := db.client.Set(dnssecKeyKey(key.Zone, key.KeyTag), string(data), 0)
+	if err != nil {
+		return err
+	}
+	var old DNSSECKey
+	if response.PrevNode != nil {
+		json.Unmarshal([]byte(response.PrevNode.Value), &old)
+	}
+	if old.State != key.State {
+		auditChange(db, actor, "dns", strings.TrimPrefix(dnssecKeyKey(key.Zone, key.KeyTag), "dnssec/"), "set", old.State, key.State)
+	}
+	return nil
+}
+
+func (db EtcdDB) DeleteDNSSECKey(actor string, zone string, keyTag uint16) error {
+	response, err := db.client.Delete(dnssecKeyKey(zone, keyTag), false)
+	if etcdKeyNotFound(err) {
+		return notFoundError("no DNSSEC key %d for %s", keyTag, zone)
+	}
+	if err != nil {
+		return err
+	}
+	var old DNSSECKey
+	if response.PrevNode != nil {
+		json.Unmarshal([]byte(response.PrevNode.Value), &old)
+	}
+	auditChange(db, actor, "dns", strings.TrimPrefix(dnssecKeyKey(zone, keyTag), "dnssec/"), "delete", old.State, "")
+	return nil
+}
+
+// Imported signatures are kept under /dnssec/signatures/<zone>/rrs/<owner>,
+// as zone file lines, with the time of the import at
+// /dnssec/signatures/<zone>/imported, which is written last.
+
+func dnssecSignaturesKey(zone string) string {
+	return "dnssec/signatures/" + strings.TrimSuffix(strings.ToLower(zone), ".")
+}
+
+func (db EtcdDB) DNSSECSignaturesImported(zone string) (time.Time, error) {
+	response, err := db.client.Get(dnssecSignaturesKey(zone)+"/imported", false, false)
+	if etcdKeyNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, response.Node.Value)
+}
+
+func (db EtcdDB) ListDNSSECSignatures(zone string) ([]dns.RR, error) {
+	response, err := db.client.Get(dnssecSignaturesKey(zone)+"/rrs", false, false)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dns.RR
+	for _, node := range response.Node.Nodes {
+		for _, line := range strings.Split(node.Value, "\n") {
+			rr, err := dns.NewRR(line)
+			if err != nil || rr == nil {
+				logger.Printf("[DNSSEC] Skipping unreadable signature under %s: %s\n", node.Key, err)
+				continue
+			}
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, nil
+}
+
+// ImportDNSSECSignatures replaces the signatures of zone with rrs
+func (db EtcdDB) ImportDNSSECSignatures(actor string, zone string, rrs []dns.RR) error {
+	owners := make(map[string][]string)
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		owners[owner] = append(owners[owner], rr.String())
+	}
+	key := dnssecSignaturesKey(zone)
+	if _, err := db.client.Delete(key, true); err != nil && !etcdKeyNotFound(err) {
+		return err
+	}
+	for owner, lines := range owners {
+		if _, err := db.client.Set(key+"/rrs/"+owner, strings.Join(lines, "\n"), 0); err != nil {
+			return err
+		}
+	}
+	if _, err := db.client.Set(key+"/imported", time.Now().UTC().Format(time.RFC3339Nano), 0); err != nil {
+		return err
+	}
+	auditChange(db, actor, "dns", strings.TrimPrefix(key, "dnssec/"), "import", "", fmt.Sprintf("%d records", len(rrs)))
+	return nil
+}
+
+func (db EtcdDB) DeleteDNSSECSignatures(actor string, zone string) error {
+	key := dnssecSignaturesKey(zone)
+	_, err := db.client.Delete(key, true)
+	if etcdKeyNotFound(err) {
+		return notFoundError("no signatures imported for %s", zone)
+	}
+	if err != nil {
+		return err
+	}
+	auditChange(db, actor, "dns", strings.TrimPrefix(key, "dnssec/"), "delete", "imported", "")
+	return nil
+}
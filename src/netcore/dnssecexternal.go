@@ -0,0 +1,365 @@
+package netcore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var dnssecSignerURL = Flags.String("dnssecsigner", "", "URL of a signing service, such as one in front of an HSM, for DNSSEC keys imported without their private half: it is POSTed {\"zone\", \"key_tag\", \"algorithm\", \"digest\"} and answers {\"signature\"}, both base64, the signature as Go's crypto.Signer makes it.")
+
+// DNSSECSignatureDB stores the RRSIG and NSEC records of zones signed
+// outside netcore, for organizations that keep their keys off DNS servers
+type DNSSECSignatureDB interface {
+	DNSSECSignaturesImported(zone string) (time.Time, error) // the zero time if none were
+	ListDNSSECSignatures(zone string) ([]dns.RR, error)
+	ImportDNSSECSignatures(actor string, zone string, rrs []dns.RR) error
+	DeleteDNSSECSignatures(actor string, zone string) error
+}
+
+// remoteSigner signs with a key that only the -dnssecsigner service holds
+type remoteSigner struct {
+	zone      string
+	keyTag    uint16
+	algorithm uint8
+}
+
+// Public is not known, nor needed to sign
+func (s remoteSigner) Public() crypto.PublicKey {
+	return nil
+}
+
+// Sign has the signing service sign the digest of an RRset
+func (s remoteSigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"zone":      s.zone,
+		"key_tag":   s.keyTag,
+		"algorithm": s.algorithm,
+		"digest":    base64.StdEncoding.EncodeToString(digest),
+	})
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	response, err := client.Post(*dnssecSignerURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("signing service responded %s", response.Status)
+	}
+	var answer struct {
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(answer.Signature)
+}
+
+// dnssecImported is the signatures and NSEC chain of a zone, as imported
+type dnssecImported struct {
+	at    time.Time
+	sigs  map[string][]*dns.RRSIG // by lowercase owner and covered type
+	nsecs []*dns.NSEC             // in canonical order
+
+	sync.Mutex
+	verified map[string]bool // by RRset and signature
+}
+
+func importedKey(owner string, rrType uint16) string {
+	return fmt.Sprintf("%s %d", strings.ToLower(dns.Fqdn(owner)), rrType)
+}
+
+func newDNSSECImported(at time.Time, rrs []dns.RR) *dnssecImported {
+	imported := &dnssecImported{at: at, sigs: make(map[string][]*dns.RRSIG), verified: make(map[string]bool)}
+	for _, rr := range rrs {
+		switch rr := rr.(type) {
+		case *dns.RRSIG:
+			key := importedKey(rr.Hdr.Name, rr.TypeCovered)
+			imported.sigs[key] = append(imported.sigs[key], rr)
+		case *dns.NSEC:
+			imported.nsecs = append(imported.nsecs, rr)
+		}
+	}
+	sort.Sort(byCanonicalOwner(imported.nsecs))
+	return imported
+}
+
+// signatures returns the imported signatures of rrset that are valid at
+// now and verify with one of keys, so that records changed since the zone
+// was exported are not served with signatures that no longer match
+func (m *dnssecImported) signatures(rrset []dns.RR, keys []dns.RR, now time.Time) []dns.RR {
+	hdr := rrset[0].Header()
+	ttl := hdr.Ttl
+	for _, rr := range rrset {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	var valid []dns.RR
+	for _, sig := range m.sigs[importedKey(hdr.Name, hdr.Rrtype)] {
+		if !sig.ValidityPeriod(now) {
+			continue
+		}
+		id := rrsetID(rrset) + "\n" + sig.Signature
+		m.Lock()
+		ok, known := m.verified[id]
+		m.Unlock()
+		if !known {
+			for _, rr := range keys {
+				if key := rr.(*dns.DNSKEY); key.KeyTag() == sig.KeyTag && sig.Verify(key, rrset) == nil {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				dnssecStats.Add("imported_stale", 1)
+				logger.Printf("[DNSSEC] Imported signature of %s %s by key %d does not match the records\n", hdr.Name, dns.Type(hdr.Rrtype).String(), sig.KeyTag)
+			}
+			m.Lock()
+			m.verified[id] = ok
+			m.Unlock()
+		}
+		if ok {
+			served := *sig
+			if ttl < served.OrigTtl {
+				served.Hdr.Ttl = ttl
+			}
+			valid = append(valid, &served)
+		}
+	}
+	return valid
+}
+
+// deny proves that q has no answers with the imported NSEC chain: the NSEC
+// record of q's name if it exists, or else those covering it and the
+// wildcard of its closest encloser, with NXDOMAIN
+func (m *dnssecImported) deny(q *dns.Question) (rrs []dns.RR, rcode int) {
+	name := strings.ToLower(dns.Fqdn(q.Name))
+	covering := func(name string) *dns.NSEC {
+		i := sort.Search(len(m.nsecs), func(i int) bool { return canonicalLess(name, m.nsecs[i].Hdr.Name) })
+		if i == 0 {
+			return m.nsecs[len(m.nsecs)-1] // the last record wraps around to the apex
+		}
+		return m.nsecs[i-1]
+	}
+	nsec := covering(name)
+	if strings.EqualFold(nsec.Hdr.Name, name) {
+		return []dns.RR{nsec}, dns.RcodeSuccess
+	}
+	rrs = []dns.RR{nsec}
+	// The closest encloser is the longest ancestor of the name that exists
+	labels := dns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		ancestor := dns.Fqdn(strings.Join(labels[i:], "."))
+		if owner := covering(ancestor); strings.EqualFold(owner.Hdr.Name, ancestor) {
+			if wildcard := covering("*." + ancestor); wildcard != nsec {
+				rrs = append(rrs, wildcard)
+			}
+			break
+		}
+	}
+	return rrs, dns.RcodeNameError
+}
+
+type byCanonicalOwner []*dns.NSEC
+
+func (s byCanonicalOwner) Len() int           { return len(s) }
+func (s byCanonicalOwner) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCanonicalOwner) Less(i, j int) bool { return canonicalLess(s[i].Hdr.Name, s[j].Hdr.Name) }
+
+// canonicalLess orders names as DNSSEC does, label by label from the right
+// (RFC 4034 section 6.1)
+func canonicalLess(a, b string) bool {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if x, y := la[len(la)-i], lb[len(lb)-i]; x != y {
+			return x < y
+		}
+	}
+	return len(la) < len(lb)
+}
+
+// unsignedZone returns the records of zone for an external signer, as the
+// authoritative handler serves them, with the DNSKEY set. Records that
+// expire, such as those DHCP registers, are left out: only a signing
+// service can sign them as they come.
+func unsignedZone(cfg *Config, zone string) ([]dns.RR, error) {
+	rrs, err := zoneRecords(cfg, zone)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := cfg.db.ListDNSSECKeys(zone)
+	if err != nil {
+		return nil, err
+	}
+	dnskeys, cds := dnssecKeySet(keys)
+	return append(append(rrs, dnskeys...), cds...), nil
+}
+
+// zoneRecords returns the static records of zone as the authoritative
+// handler serves them
+func zoneRecords(cfg *Config, zone string) ([]dns.RR, error) {
+	records, err := cfg.db.ListDNSZone(zone)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, notFoundError("no zone %s", zone)
+	}
+	var rrs []dns.RR
+	for _, record := range records {
+		rrType, ok := dns.StringToType[record.Type]
+		if !ok {
+			continue
+		}
+		q := &dns.Question{Name: dns.Fqdn(record.Name), Qtype: rrType, Qclass: dns.ClassINET}
+		var rr dns.RR
+		switch rrType {
+		case dns.TypeSOA:
+			entry, err := cfg.db.GetDNS(q.Name, "SOA")
+			if err != nil {
+				return nil, err
+			}
+			rr = answerSOA(q, entry)
+		case dns.TypeCNAME:
+			rr, _ = answerCNAME(q, &DNSValue{Value: record.Value, Attr: record.Attr})
+		case dns.TypeDNAME:
+			rr = answerDNAME(q, &DNSValue{Value: record.Value, Attr: record.Attr})
+		default:
+			rr = answerValue(q, rrType, &DNSValue{Value: record.Value, Attr: record.Attr})
+		}
+		if rr == nil {
+			continue
+		}
+		rr.Header().Ttl = 10800 // as the authoritative handler
+		if record.TTL > 0 {
+			rr.Header().Ttl = record.TTL
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+// parseDNSSECSignatures reads the RRSIG and NSEC records of zone from zone
+// file text
+func parseDNSSECSignatures(zone string, text io.Reader) ([]dns.RR, error) {
+	var rrs []dns.RR
+	scanner := bufio.NewScanner(text)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, ";") {
+			continue
+		}
+		rr, err := dns.NewRR(text)
+		if err != nil {
+			return nil, invalid("records", "line %d: %s", line, err)
+		}
+		if rr == nil {
+			continue
+		}
+		switch rr.(type) {
+		case *dns.RRSIG, *dns.NSEC:
+		default:
+			continue // the signed zone's other records are ours already
+		}
+		if !dns.IsSubDomain(zone, strings.ToLower(rr.Header().Name)) {
+			return nil, invalid("records", "line %d: %s is not in %s", line, rr.Header().Name, zone)
+		}
+		rrs = append(rrs, rr)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rrs) == 0 {
+		return nil, invalid("records", "no RRSIG or NSEC records to import")
+	}
+	return rrs, nil
+}
+
+// apiDNSSECZones feeds zones to a signer outside netcore, and takes back
+// the signatures it made. It requires the admin token.
+//
+//	GET    /api/dnssec/zones/<zone>/unsigned    the zone and its DNSKEY set, in zone file format
+//	PUT    /api/dnssec/zones/<zone>/signatures  import the signed zone's RRSIG and NSEC records
+//	GET    /api/dnssec/zones/<zone>/signatures  when signatures were imported, and how many
+//	DELETE /api/dnssec/zones/<zone>/signatures  forget the imported signatures
+func apiDNSSECZones(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may manage DNSSEC signatures"))
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/dnssec/zones/"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	zone := dns.Fqdn(strings.ToLower(parts[0]))
+
+	switch {
+	case parts[1] == "unsigned" && r.Method == "GET":
+		rrs, err := unsignedZone(cfg, zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/dns")
+		for _, rr := range rrs {
+			fmt.Fprintln(w, rr.String())
+		}
+
+	case parts[1] == "signatures" && r.Method == "PUT":
+		rrs, err := parseDNSSECSignatures(zone, r.Body)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if err := cfg.db.ImportDNSSECSignatures(id.String(), zone, rrs); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, map[string]int{"imported": len(rrs)})
+
+	case parts[1] == "signatures" && r.Method == "GET":
+		at, err := cfg.db.DNSSECSignaturesImported(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		if at.IsZero() {
+			apiWriteError(w, http.StatusNotFound, fmt.Errorf("no signatures imported for %s", zone))
+			return
+		}
+		rrs, err := cfg.db.ListDNSSECSignatures(zone)
+		if err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		apiWriteJSON(w, http.StatusOK, map[string]interface{}{"imported": at, "records": len(rrs)})
+
+	case parts[1] == "signatures" && r.Method == "DELETE":
+		if err := cfg.db.DeleteDNSSECSignatures(id.String(), zone); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+	}
+}
@@ -0,0 +1,479 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// secondaryZone is a zone that we serve from a copy transferred from its
+// primary name servers
+type secondaryZone struct {
+	sync.RWMutex
+	name      string   // fully qualified, lower case
+	primaries []string // host:port
+	records   map[string][]dns.RR
+	serial    uint32
+	loaded    bool
+	expires   time.Time
+	notify    chan struct{}
+	stop      chan struct{}
+	isCatalog bool   // this zone is a catalog of other zones to serve
+	catalog   string // the catalog that this zone is a member of, if any
+}
+
+// dnsSecondaryZones is the set of secondary zones served by this instance
+type dnsSecondaryZones struct {
+	sync.RWMutex
+	zones map[string]*secondaryZone
+}
+
+var secondaryZones = &dnsSecondaryZones{zones: make(map[string]*secondaryZone)}
+
+// newDNSSecondaryHandler answers questions for the zone's configured secondary
+// zones from their transferred copies, and starts keeping those copies fresh
+func newDNSSecondaryHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	configured, catalogs := cfg.DNSSecondaryZones(), cfg.DNSCatalogZones()
+	if len(configured) == 0 && len(catalogs) == 0 {
+		return next, nil
+	}
+	for name, primaries := range configured {
+		zone, err := newSecondaryZone(name, primaries)
+		if err != nil {
+			return nil, err
+		}
+		secondaryZones.add(zone)
+		go zone.maintain()
+	}
+	for name, primaries := range catalogs {
+		zone, err := newSecondaryZone(name, primaries)
+		if err != nil {
+			return nil, err
+		}
+		zone.isCatalog = true
+		secondaryZones.add(zone)
+		go zone.maintain()
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		zone := secondaryZones.find(r.Question.Name)
+		if zone == nil {
+			return next.ServeDNSQuestion(r)
+		}
+		r.Trace.Note("answered from the transferred copy of secondary zone %s", zone.name)
+		return zone.answer(r.Question)
+	}), nil
+}
+
+func newSecondaryZone(name string, primaries []string) (*secondaryZone, error) {
+	zone := &secondaryZone{
+		name:   dns.Fqdn(strings.ToLower(name)),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	for _, primary := range primaries {
+		primary = strings.TrimSpace(primary)
+		if _, _, err := net.SplitHostPort(primary); err != nil {
+			primary = net.JoinHostPort(primary, "53")
+		}
+		zone.primaries = append(zone.primaries, primary)
+	}
+	if len(zone.primaries) == 0 {
+		return nil, fmt.Errorf("secondary zone %s has no primaries", zone.name)
+	}
+	return zone, nil
+}
+
+func (s *dnsSecondaryZones) add(zone *secondaryZone) {
+	s.Lock()
+	defer s.Unlock()
+	s.zones[zone.name] = zone
+}
+
+func (s *dnsSecondaryZones) remove(zone *secondaryZone) {
+	s.Lock()
+	defer s.Unlock()
+	if s.zones[zone.name] == zone {
+		delete(s.zones, zone.name)
+		close(zone.stop)
+	}
+}
+
+// find returns the closest enclosing secondary zone for name, or nil
+func (s *dnsSecondaryZones) find(name string) *secondaryZone {
+	s.RLock()
+	defer s.RUnlock()
+	labels := strings.Split(strings.ToLower(dns.Fqdn(name)), ".")
+	for i := range labels {
+		if zone, ok := s.zones[strings.Join(labels[i:], ".")]; ok {
+			return zone
+		}
+	}
+	return nil
+}
+
+// answer returns the records matching q from the transferred copy of the
+// zone. Zones that have never loaded or have expired answer nothing.
+func (z *secondaryZone) answer(q *dns.Question) []dns.RR {
+	z.RLock()
+	defer z.RUnlock()
+	if !z.loaded || time.Now().After(z.expires) || z.isCatalog {
+		return nil // catalog zones are for provisioning, not resolution
+	}
+	var answers []dns.RR
+	for _, rr := range z.records[strings.ToLower(q.Name)] {
+		rrType := rr.Header().Rrtype
+		if q.Qtype == dns.TypeANY || rrType == q.Qtype || (rrType == dns.TypeCNAME && q.Qtype != dns.TypeCNAME) {
+			answer := dns.Copy(rr)
+			answer.Header().Name = q.Name
+			answers = append(answers, answer)
+		}
+	}
+	return answers
+}
+
+// maintain keeps the zone up to date, refreshing it on the schedule given by
+// its SOA record or whenever a primary sends us a NOTIFY
+func (z *secondaryZone) maintain() {
+	for {
+		wait := z.refresh()
+		select {
+		case <-time.After(wait):
+		case <-z.notify:
+			logger.Printf("DNS secondary %s: refreshing after NOTIFY\n", z.name)
+		case <-z.stop:
+			logger.Printf("DNS secondary %s: no longer served\n", z.name)
+			health.Set("secondary "+z.name, Healthy, "")
+			return
+		}
+	}
+}
+
+// refresh transfers the zone if a primary has a newer serial than ours,
+// incrementally when we already have a copy, and returns how long to wait
+// before the next refresh
+func (z *secondaryZone) refresh() time.Duration {
+	z.RLock()
+	serial, loaded := z.serial, z.loaded
+	retry := time.Duration(soaTimerDefaults["retry"]) * time.Second
+	if soa := z.soa(); soa != nil {
+		retry = time.Duration(soa.Retry) * time.Second
+	}
+	z.RUnlock()
+
+	for _, primary := range z.primaries {
+		primarySerial, err := querySOASerial(z.name, primary)
+		if err != nil {
+			logger.Printf("DNS secondary %s: SOA query to %s failed: %s\n", z.name, primary, err)
+			continue
+		}
+		if loaded && !serialNewer(primarySerial, serial) {
+			z.extend()
+			return z.refreshInterval()
+		}
+		how, err := z.transfer(primary, loaded)
+		if err != nil {
+			logger.Printf("DNS secondary %s: transfer from %s failed: %s\n", z.name, primary, err)
+			continue
+		}
+		logger.Printf("DNS secondary %s: transferred serial %d %s from %s\n", z.name, primarySerial, how, primary)
+		if z.isCatalog {
+			secondaryZones.syncCatalog(z)
+		}
+		health.Set("secondary "+z.name, Healthy, "")
+		return z.refreshInterval()
+	}
+
+	z.RLock()
+	expired := z.loaded && time.Now().After(z.expires)
+	z.RUnlock()
+	if expired {
+		health.Set("secondary "+z.name, Degraded, "zone expired; no primary reachable")
+	} else if !loaded {
+		health.Set("secondary "+z.name, Degraded, "zone has never been transferred")
+	}
+	return retry
+}
+
+// transfer brings the zone up to date from primary, incrementally if we
+// already have a copy and the primary can, and says how it did
+func (z *secondaryZone) transfer(primary string, loaded bool) (string, error) {
+	if loaded {
+		err := z.transferIncremental(primary)
+		if err == nil {
+			return "incrementally", nil
+		}
+		logger.Printf("DNS secondary %s: incremental transfer from %s failed, transferring it whole: %s\n", z.name, primary, err)
+	}
+	records, err := transferZone(z.name, primary)
+	if err != nil {
+		return "", err
+	}
+	if err := z.load(records); err != nil {
+		return "", fmt.Errorf("unusable: %s", err)
+	}
+	return "whole", nil
+}
+
+// load replaces the zone contents with the transferred records
+func (z *secondaryZone) load(rrs []dns.RR) error {
+	records := make(map[string][]dns.RR)
+	var soa *dns.SOA
+	for _, rr := range rrs {
+		if s, ok := rr.(*dns.SOA); ok && soa == nil {
+			soa = s
+		}
+		owner := strings.ToLower(rr.Header().Name)
+		records[owner] = append(records[owner], rr)
+	}
+	if soa == nil {
+		return fmt.Errorf("no SOA record in transfer")
+	}
+	// AXFR ends with a repeat of the SOA record
+	if apex := records[z.name]; len(apex) > 1 && apex[len(apex)-1].Header().Rrtype == dns.TypeSOA {
+		records[z.name] = apex[:len(apex)-1]
+	}
+	z.replace(records, soa)
+	return nil
+}
+
+func (z *secondaryZone) replace(records map[string][]dns.RR, soa *dns.SOA) {
+	z.Lock()
+	defer z.Unlock()
+	z.records = records
+	z.serial = soa.Serial
+	z.loaded = true
+	z.expires = time.Now().Add(time.Duration(soa.Expire) * time.Second)
+}
+
+// transferIncremental brings the zone up to date with an IXFR (RFC 1995),
+// applying the differences the primary sends to a copy of the records. A
+// primary may answer with the whole zone instead, which is loaded as is.
+func (z *secondaryZone) transferIncremental(primary string) error {
+	z.RLock()
+	current := z.soa()
+	z.RUnlock()
+	if current == nil {
+		return fmt.Errorf("no SOA record to start from")
+	}
+	m := new(dns.Msg)
+	m.SetIxfr(z.name, current.Serial, current.Ns, current.Mbox)
+	t := new(dns.Transfer)
+	envelopes, err := t.In(m, primary)
+	if err != nil {
+		return err
+	}
+	var rrs []dns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return envelope.Error
+		}
+		rrs = append(rrs, envelope.RR...)
+	}
+	if len(rrs) > 1 {
+		if _, ok := rrs[1].(*dns.SOA); !ok {
+			return z.load(rrs) // the whole zone, as in AXFR
+		}
+	}
+
+	z.RLock()
+	records := make(map[string][]dns.RR, len(z.records))
+	for owner, rrset := range z.records {
+		records[owner] = append([]dns.RR(nil), rrset...)
+	}
+	z.RUnlock()
+	soa, err := applyIXFR(records, current.Serial, rrs)
+	if err != nil {
+		return err
+	}
+	z.replace(records, soa)
+	return nil
+}
+
+// applyIXFR applies to records the sequences of differences of an IXFR
+// response, each made of the old SOA and the records it deleted, then the
+// new SOA and the records it added, and returns the final SOA
+func applyIXFR(records map[string][]dns.RR, serial uint32, rrs []dns.RR) (*dns.SOA, error) {
+	if len(rrs) < 2 {
+		return nil, fmt.Errorf("incomplete IXFR response")
+	}
+	final, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, fmt.Errorf("IXFR response does not start with an SOA record")
+	}
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != final.Serial {
+		return nil, fmt.Errorf("IXFR response does not end with its SOA record")
+	}
+	adding, started := false, false
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if adding || !started {
+				// the old SOA, followed by the records deleted from it
+				if soa.Serial != serial {
+					return nil, fmt.Errorf("IXFR differences start from serial %d, not %d", soa.Serial, serial)
+				}
+				adding, started = false, true
+			} else {
+				// the new SOA, followed by the records added to it
+				adding, serial = true, soa.Serial
+			}
+			continue
+		}
+		if !started {
+			return nil, fmt.Errorf("IXFR differences do not start with an SOA record")
+		}
+		owner := strings.ToLower(rr.Header().Name)
+		if adding {
+			records[owner] = append(records[owner], rr)
+			continue
+		}
+		kept := records[owner][:0]
+		for _, existing := range records[owner] {
+			if !sameRecord(existing, rr) {
+				kept = append(kept, existing)
+			}
+		}
+		if len(kept) == 0 {
+			delete(records, owner)
+		} else {
+			records[owner] = kept
+		}
+	}
+	if (started && !adding) || serial != final.Serial {
+		return nil, fmt.Errorf("IXFR differences end at serial %d, not %d", serial, final.Serial)
+	}
+	apex := strings.ToLower(final.Header().Name)
+	for i, rr := range records[apex] {
+		if _, ok := rr.(*dns.SOA); ok {
+			records[apex][i] = final
+			return final, nil
+		}
+	}
+	records[apex] = append(records[apex], final)
+	return final, nil
+}
+
+// sameRecord reports whether a and b are the same record, whatever their
+// TTLs and the case of their owner names
+func sameRecord(a, b dns.RR) bool {
+	if a.Header().Rrtype != b.Header().Rrtype {
+		return false
+	}
+	fa, fb := strings.Fields(a.String()), strings.Fields(b.String())
+	if len(fa) != len(fb) || len(fa) < 2 || !strings.EqualFold(fa[0], fb[0]) {
+		return false
+	}
+	for i := 2; i < len(fa); i++ {
+		if fa[i] != fb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// serialNewer reports whether serial a is newer than b, in the serial number
+// arithmetic of RFC 1982, which lets serials wrap around
+func serialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// extend pushes back the expiry of a zone that a primary confirmed is current
+func (z *secondaryZone) extend() {
+	z.Lock()
+	defer z.Unlock()
+	if soa := z.soa(); soa != nil {
+		z.expires = time.Now().Add(time.Duration(soa.Expire) * time.Second)
+	}
+}
+
+func (z *secondaryZone) refreshInterval() time.Duration {
+	z.RLock()
+	defer z.RUnlock()
+	if soa := z.soa(); soa != nil {
+		return time.Duration(soa.Refresh) * time.Second
+	}
+	return time.Duration(soaTimerDefaults["refresh"]) * time.Second
+}
+
+// soa returns the zone's SOA record; the caller must hold the lock
+func (z *secondaryZone) soa() *dns.SOA {
+	for _, rr := range z.records[z.name] {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa
+		}
+	}
+	return nil
+}
+
+// isPrimary reports whether addr is one of the zone's primaries
+func (z *secondaryZone) isPrimary(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, primary := range z.primaries {
+		if primaryHost, _, err := net.SplitHostPort(primary); err == nil && net.ParseIP(primaryHost).Equal(net.ParseIP(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+func querySOASerial(zone, primary string) (uint32, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeSOA)
+	c := new(dns.Client)
+	response, _, err := c.Exchange(m, primary)
+	if err != nil {
+		return 0, err
+	}
+	for _, rr := range response.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("no SOA in response")
+}
+
+func transferZone(zone, primary string) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(zone)
+	t := new(dns.Transfer)
+	envelopes, err := t.In(m, primary)
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, envelope.Error
+		}
+		rrs = append(rrs, envelope.RR...)
+	}
+	return rrs, nil
+}
+
+// dnsNotifyServe handles NOTIFY messages from the primaries of our secondary
+// zones by scheduling an immediate refresh
+func dnsNotifyServe(w dns.ResponseWriter, req *dns.Msg) {
+	reply := new(dns.Msg)
+	reply.SetReply(req)
+	for _, q := range req.Question {
+		zone := secondaryZones.find(q.Name)
+		if zone == nil || zone.name != strings.ToLower(dns.Fqdn(q.Name)) || !zone.isPrimary(w.RemoteAddr()) {
+			logger.Printf("DNS NOTIFY for %s from %s refused\n", q.Name, w.RemoteAddr())
+			reply.Rcode = dns.RcodeRefused
+			continue
+		}
+		logger.Printf("DNS NOTIFY for %s from %s\n", q.Name, w.RemoteAddr())
+		select {
+		case zone.notify <- struct{}{}:
+		default: // a refresh is already pending
+		}
+	}
+	reply.Authoritative = reply.Rcode == dns.RcodeSuccess
+	w.WriteMsg(reply)
+}
@@ -0,0 +1,53 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSerialNewer(t *testing.T) {
+	tests := []struct {
+		a, b  uint32
+		newer bool
+	}{
+		{2, 1, true},
+		{1, 2, false},
+		{1, 1, false},
+		{0, 0xffffffff, true}, // wrapped around
+		{0xffffffff, 0, false},
+		{0x7fffffff, 0, true},
+	}
+	for _, test := range tests {
+		if newer := serialNewer(test.a, test.b); newer != test.newer {
+			t.Errorf("serialNewer(%d, %d) = %v, want %v", test.a, test.b, newer, test.newer)
+		}
+	}
+}
+
+func TestApplyIXFR(t *testing.T) {
+	soa := func(serial uint32) *dns.SOA {
+		return &dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600}, Serial: serial}
+	}
+	www := &dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.ParseIP("192.0.2.80")}
+
+	records := map[string][]dns.RR{"example.com.": {soa(1)}}
+	final, err := applyIXFR(records, 1, []dns.RR{soa(3), soa(1), soa(2), www, soa(2), soa(3), soa(3)})
+	if err != nil {
+		t.Fatalf("applyIXFR: %s", err)
+	}
+	if final.Serial != 3 || records["example.com."][0].(*dns.SOA).Serial != 3 {
+		t.Errorf("zone left at serial %d, want 3", records["example.com."][0].(*dns.SOA).Serial)
+	}
+	if len(records["www.example.com."]) != 1 {
+		t.Errorf("added record missing: %v", records)
+	}
+
+	if _, err := applyIXFR(map[string][]dns.RR{}, 5, []dns.RR{soa(3), soa(1), soa(3), soa(3)}); err == nil {
+		t.Errorf("applyIXFR accepted differences from a serial other than ours")
+	}
+	if _, err := applyIXFR(map[string][]dns.RR{}, 1, []dns.RR{soa(3), soa(1), soa(2), soa(3)}); err == nil {
+		t.Errorf("applyIXFR accepted differences that do not reach the final serial")
+	}
+}
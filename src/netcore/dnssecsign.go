@@ -0,0 +1,428 @@
+package netcore
+
+import (
+	"crypto"
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnssecStats counts the signatures made and reused, and the keys that
+// could not be opened
+var dnssecStats = expvar.NewMap("dnssec")
+
+// dnssecKeyringRefresh is how often the signing handler reads keys again
+const dnssecKeyringRefresh = 30 * time.Second
+
+// dnssecSigner is a key that signs, with its private half opened
+type dnssecSigner struct {
+	key     *dns.DNSKEY
+	private crypto.PrivateKey
+}
+
+// dnssecZone is what the signing handler needs to know about a signed zone
+type dnssecZone struct {
+	name     string
+	keys     []dns.RR // the DNSKEY set
+	cds      []dns.RR // CDS and CDNSKEY records for the parent
+	ksks     []dnssecSigner
+	zsk      *dnssecSigner
+	imported *dnssecImported // signatures made outside netcore, if any
+}
+
+// dnssecKeyring is the signed zones, read from the database every
+// dnssecKeyringRefresh rather than for each question
+type dnssecKeyring struct {
+	sync.Mutex
+	db interface {
+		DNSSECKeyDB
+		DNSSECSignatureDB
+	}
+	loaded time.Time
+	zones  map[string]*dnssecZone
+	opened map[string]crypto.PrivateKey // by the sealed key, so that the KMS is not asked again
+}
+
+// zone returns the signed zone that name is in, or nil
+func (k *dnssecKeyring) zone(name string, now time.Time) *dnssecZone {
+	k.Lock()
+	defer k.Unlock()
+	if now.Sub(k.loaded) >= dnssecKeyringRefresh {
+		k.loaded = now
+		if keys, err := k.db.ListDNSSECKeys(""); err != nil {
+			logger.Printf("[DNSSEC] Keys could not be read, keeping those read before: %s\n", err)
+		} else {
+			k.load(keys)
+		}
+	}
+	name = strings.ToLower(dns.Fqdn(name))
+	var best *dnssecZone
+	for _, zone := range k.zones {
+		if dns.IsSubDomain(zone.name, name) && (best == nil || len(zone.name) > len(best.name)) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// load replaces the zones with those keys make; the caller must hold the
+// lock
+func (k *dnssecKeyring) load(keys []DNSSECKey) {
+	byZone := make(map[string][]DNSSECKey)
+	for _, key := range keys {
+		name := strings.ToLower(dns.Fqdn(key.Zone))
+		byZone[name] = append(byZone[name], key)
+	}
+	opened := make(map[string]crypto.PrivateKey)
+	zones := make(map[string]*dnssecZone)
+	for name, keys := range byZone {
+		zone := &dnssecZone{name: name}
+		zone.keys, zone.cds = dnssecKeySet(keys)
+		zones[name] = zone
+
+		for _, stored := range keys {
+			if stored.Role == KeyZSK && stored.State != KeyActive {
+				continue
+			}
+			public, err := stored.key()
+			if err != nil {
+				continue
+			}
+			var private crypto.PrivateKey
+			switch {
+			case stored.Private == "" && *dnssecSignerURL != "":
+				private = remoteSigner{name, stored.KeyTag, stored.Algorithm}
+			case stored.Private == "":
+				continue // signed outside netcore
+			default:
+				var ok bool
+				if private, ok = k.opened[stored.Private]; !ok {
+					text, err := openPrivateKey(stored.Private)
+					if err == nil {
+						private, err = public.NewPrivateKey(text)
+					}
+					if err != nil {
+						dnssecStats.Add("key_failed", 1)
+						logger.Printf("[DNSSEC] Key %d of %s cannot sign: %s\n", stored.KeyTag, name, err)
+						continue
+					}
+				}
+				opened[stored.Private] = private
+			}
+			signer := dnssecSigner{public, private}
+			if stored.Role == KeyKSK {
+				// Every key signing key signs the DNSKEY set, so that
+				// it validates whichever DS record the parent has
+				zone.ksks = append(zone.ksks, signer)
+			} else {
+				zone.zsk = &signer
+			}
+		}
+
+		previous := k.zones[name]
+		at, err := k.db.DNSSECSignaturesImported(name)
+		switch {
+		case err != nil:
+			logger.Printf("[DNSSEC] Imported signatures of %s could not be read: %s\n", name, err)
+			if previous != nil {
+				zone.imported = previous.imported
+			}
+		case at.IsZero():
+		case previous != nil && previous.imported != nil && previous.imported.at.Equal(at):
+			zone.imported = previous.imported
+		default:
+			rrs, err := k.db.ListDNSSECSignatures(name)
+			if err != nil {
+				logger.Printf("[DNSSEC] Imported signatures of %s could not be read: %s\n", name, err)
+				continue
+			}
+			zone.imported = newDNSSECImported(at, rrs)
+		}
+	}
+	k.zones, k.opened = zones, opened
+}
+
+// dnssecKeySet returns the DNSKEY set of a zone's keys, and the CDS and
+// CDNSKEY records for its newest key signing key still in use
+func dnssecKeySet(keys []DNSSECKey) (dnskeys, cds []dns.RR) {
+	var newest *DNSSECKey
+	for i := range keys {
+		stored := &keys[i]
+		public, err := stored.key()
+		if err != nil {
+			logger.Printf("[DNSSEC] Key %d of %s is ignored: %s\n", stored.KeyTag, stored.Zone, err)
+			continue
+		}
+		public.Hdr.Ttl = dnssecKeyTTL
+		dnskeys = append(dnskeys, public)
+		if stored.Role == KeyKSK && stored.State != KeyRetired && (newest == nil || stored.Created.After(newest.Created)) {
+			newest = stored
+		}
+	}
+	if newest == nil {
+		return dnskeys, nil
+	}
+	name := strings.ToLower(dns.Fqdn(newest.Zone))
+	if ds := newest.dsDigest(); ds != nil {
+		ds.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeCDS, Class: dns.ClassINET, Ttl: dnssecKeyTTL}
+		cds = append(cds, &dns.CDS{DS: *ds})
+	}
+	if public, err := newest.key(); err == nil {
+		public.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeCDNSKEY, Class: dns.ClassINET, Ttl: dnssecKeyTTL}
+		cds = append(cds, &dns.CDNSKEY{DNSKEY: *public})
+	}
+	return dnskeys, cds
+}
+
+// apex answers the DNSKEY, CDS and CDNSKEY questions at the zone's apex
+func (z *dnssecZone) apex(q *dns.Question) []dns.RR {
+	var answers []dns.RR
+	for _, rr := range append(z.keys, z.cds...) {
+		if rr.Header().Rrtype == q.Qtype || q.Qtype == dns.TypeANY {
+			answers = append(answers, rr)
+		}
+	}
+	return answers
+}
+
+// dnssecSignatures caches the signatures made, by signing key and RRset
+var dnssecSignatures = struct {
+	sync.Mutex
+	sigs map[string]*dns.RRSIG
+}{sigs: make(map[string]*dns.RRSIG)}
+
+// dnssecSignatureCacheSize bounds the signature cache, which is emptied
+// when full
+const dnssecSignatureCacheSize = 100000
+
+// sign returns the signature of rrset by signer, reusing the one made
+// before while more than a quarter of its validity is left
+func (s *dnssecSigner) sign(zone string, rrset []dns.RR, now time.Time) *dns.RRSIG {
+	hdr := rrset[0].Header()
+	ttl := hdr.Ttl
+	for _, rr := range rrset {
+		if rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	id := fmt.Sprintf("%d %s", s.key.KeyTag(), rrsetID(rrset))
+
+	dnssecSignatures.Lock()
+	cached := dnssecSignatures.sigs[id]
+	dnssecSignatures.Unlock()
+	if cached != nil && ttl <= cached.OrigTtl && int64(cached.Expiration)-now.Unix() > int64(*dnssecValidity/time.Second/4) {
+		dnssecStats.Add("reused", 1)
+		sig := *cached
+		sig.Hdr.Ttl = ttl
+		return &sig
+	}
+
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: ttl},
+		TypeCovered: hdr.Rrtype,
+		Algorithm:   s.key.Algorithm,
+		Labels:      uint8(dns.CountLabel(hdr.Name)),
+		OrigTtl:     ttl,
+		Inception:   uint32(now.Add(-time.Hour).Unix()), // for clocks behind ours
+		Expiration:  uint32(now.Add(*dnssecValidity).Unix()),
+		KeyTag:      s.key.KeyTag(),
+		SignerName:  zone,
+	}
+	signer, ok := s.private.(crypto.Signer)
+	if !ok {
+		dnssecStats.Add("sign_failed", 1)
+		logger.Printf("[DNSSEC] %s %s not signed: key %d cannot sign\n", hdr.Name, dns.Type(hdr.Rrtype).String(), s.key.KeyTag())
+		return nil
+	}
+	if err := sig.Sign(signer, rrset); err != nil {
+		dnssecStats.Add("sign_failed", 1)
+		logger.Printf("[DNSSEC] %s %s not signed: %s\n", hdr.Name, dns.Type(hdr.Rrtype).String(), err)
+		return nil
+	}
+	dnssecStats.Add("signed", 1)
+	dnssecSignatures.Lock()
+	if len(dnssecSignatures.sigs) >= dnssecSignatureCacheSize {
+		dnssecSignatures.sigs = make(map[string]*dns.RRSIG)
+	}
+	dnssecSignatures.sigs[id] = sig
+	dnssecSignatures.Unlock()
+	made := *sig
+	return &made
+}
+
+// rrsetID identifies an RRset by its owner, type and data, leaving TTLs
+// out so that they still match as they count down
+func rrsetID(rrset []dns.RR) string {
+	hdr := rrset[0].Header()
+	data := make([]string, 0, len(rrset))
+	for _, rr := range rrset {
+		fields := strings.SplitN(rr.String(), "\t", 5)
+		data = append(data, fields[len(fields)-1])
+	}
+	sort.Strings(data)
+	return fmt.Sprintf("%s %d %s", strings.ToLower(hdr.Name), hdr.Rrtype, strings.Join(data, "\n"))
+}
+
+// signRRsets appends to each RRset of rrs in a signed zone its signatures:
+// those imported that still match, or else those of every key signing key
+// for the DNSKEY, CDS and CDNSKEY sets, of the zone signing key for others
+func (k *dnssecKeyring) signRRsets(rrs []dns.RR, now time.Time) []dns.RR {
+	var signed []dns.RR
+	for len(rrs) > 0 {
+		hdr := rrs[0].Header()
+		var rrset, rest []dns.RR
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == hdr.Rrtype && strings.EqualFold(rr.Header().Name, hdr.Name) {
+				rrset = append(rrset, rr)
+			} else {
+				rest = append(rest, rr)
+			}
+		}
+		rrs = rest
+		signed = append(signed, rrset...)
+
+		zone := k.zone(hdr.Name, now)
+		if zone == nil || hdr.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		if zone.imported != nil {
+			if sigs := zone.imported.signatures(rrset, zone.keys, now); len(sigs) > 0 {
+				signed = append(signed, sigs...)
+				continue
+			}
+		}
+		switch hdr.Rrtype {
+		case dns.TypeDNSKEY, dns.TypeCDS, dns.TypeCDNSKEY:
+			for i := range zone.ksks {
+				if sig := zone.ksks[i].sign(zone.name, rrset, now); sig != nil {
+					signed = append(signed, sig)
+				}
+			}
+		default:
+			if zone.zsk != nil {
+				if sig := zone.zsk.sign(zone.name, rrset, now); sig != nil {
+					signed = append(signed, sig)
+				}
+			}
+		}
+	}
+	return signed
+}
+
+// dnssecDenialTypes are the types looked for in the database when denying
+// a type at a name, so that the NSEC record does not deny those too
+var dnssecDenialTypes = []uint16{dns.TypeA, dns.TypeNS, dns.TypeCNAME, dns.TypeSOA, dns.TypePTR, dns.TypeMX, dns.TypeTXT, dns.TypeAAAA, dns.TypeSRV}
+
+// deny proves that q has no answers with the zone's SOA and an NSEC record
+// that only covers q's name, so that nothing about the rest of the zone is
+// given away (RFC 4470). The name is then told to exist with no data of
+// q's type, a NODATA answer rather than NXDOMAIN, as RFC 4470 does.
+//
+// Zones signed outside netcore are denied with the NSEC chain imported
+// with their signatures instead, which may come with NXDOMAIN.
+func (z *dnssecZone) deny(cfg *Config, q *dns.Question) (rrs []dns.RR, rcode int) {
+	entry, err := cfg.db.GetDNS(z.name, "SOA")
+	if err != nil {
+		return nil, dns.RcodeSuccess
+	}
+	soa := negativeSOA(z.name, entry)
+	if z.imported != nil && len(z.imported.nsecs) > 0 {
+		rrs, rcode = z.imported.deny(q)
+		return append([]dns.RR{soa}, rrs...), rcode
+	}
+
+	types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+	for _, t := range dnssecDenialTypes {
+		if t == q.Qtype {
+			continue
+		}
+		if found, err := cfg.db.HasDNS(q.Name, dns.Type(t).String()); err == nil && found {
+			types = append(types, t)
+		}
+	}
+	if strings.EqualFold(dns.Fqdn(q.Name), z.name) {
+		types = append(types, dns.TypeDNSKEY)
+		if len(z.cds) > 0 {
+			types = append(types, dns.TypeCDS, dns.TypeCDNSKEY)
+		}
+	}
+	sort.Sort(uint16s(types))
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: q.Name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: soa.Hdr.Ttl},
+		NextDomain: "\\000." + dns.Fqdn(q.Name),
+		TypeBitMap: types,
+	}
+	return []dns.RR{soa, nsec}, dns.RcodeSuccess
+}
+
+// unanswered reports whether rrs hold no answers to q: nothing, or only
+// the SOA of a NODATA answer, which deny proves
+func unanswered(q *dns.Question, rrs []dns.RR) bool {
+	answers, authority, _ := splitReferral(q, rrs)
+	if len(answers) > 0 {
+		return false
+	}
+	for _, rr := range authority {
+		if _, isSOA := rr.(*dns.SOA); !isSOA {
+			return false
+		}
+	}
+	return true
+}
+
+type uint16s []uint16
+
+func (s uint16s) Len() int           { return len(s) }
+func (s uint16s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s uint16s) Less(i, j int) bool { return s[i] < s[j] }
+
+// newDNSSECHandler signs the answers from zones that have DNSSEC keys, for
+// clients that set the DO bit, answers the DNSKEY, CDS and CDNSKEY
+// questions of those zones, and proves that names and types do not exist.
+// It must come before the cache, which keeps answers unsigned.
+func newDNSSECHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	if cfg.db == nil {
+		return next, nil
+	}
+	keyring := &dnssecKeyring{db: cfg.db}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		zone := keyring.zone(r.Question.Name, r.Start)
+		if zone == nil {
+			return next.ServeDNSQuestion(r)
+		}
+		if r.DNSSEC {
+			r.Trace.Note("zone %s is signed, and the client set the DO bit", zone.name)
+		} else {
+			r.Trace.Note("zone %s is signed, but answers are only signed for clients setting the DO bit", zone.name)
+		}
+		var answers []dns.RR
+		if strings.EqualFold(dns.Fqdn(r.Question.Name), zone.name) && (r.Question.Qtype == dns.TypeDNSKEY || r.Question.Qtype == dns.TypeCDS || r.Question.Qtype == dns.TypeCDNSKEY) {
+			answers = zone.apex(r.Question)
+		} else {
+			answers = next.ServeDNSQuestion(r)
+		}
+		if !r.DNSSEC {
+			return answers
+		}
+		if r.Rcode == dns.RcodeSuccess && unanswered(r.Question, answers) {
+			answers, r.Rcode = zone.deny(cfg, r.Question)
+		}
+		// Referrals and their glue are not signed by the parent; the
+		// records denying the question are
+		answers, authority, extra := splitReferral(r.Question, answers)
+		var referral []dns.RR
+		for _, rr := range authority {
+			if _, isNS := rr.(*dns.NS); isNS {
+				referral = append(referral, rr)
+			} else {
+				answers = append(answers, rr)
+			}
+		}
+		return append(append(keyring.signRRsets(answers, r.Start), referral...), extra...)
+	}), nil
+}
@@ -0,0 +1,95 @@
+package netcore
+
+import (
+	"runtime"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	dnsShards       = Flags.Int("dnsshards", runtime.NumCPU(), "Shards of the DNS cache, by hash of the question's name, each with its own lock and its own workers for the queries read from UDP in batches, which answer them (1 for a single shard).")
+	dnsShardWorkers = Flags.Int("dnsshardworkers", 16, "Workers answering the queries of each DNS shard; queries past what they and their queue take are dropped.")
+)
+
+// dnsShardQueue is how many queries wait for the workers of a shard before
+// more are dropped
+const dnsShardQueue = 256
+
+// dnsShardOf returns the shard of name, among n, by an FNV-1a hash of its
+// lowercase form, so that all questions about a name meet in one shard
+func dnsShardOf(name string, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		hash ^= uint32(c)
+		hash *= 16777619
+	}
+	return int(hash % uint32(n))
+}
+
+// dnsShardWork is a query for the workers of a shard to answer
+type dnsShardWork struct {
+	w   dns.ResponseWriter
+	req *dns.Msg
+}
+
+// dnsShardPool answers queries with the workers of the shard of their
+// first question's name, so that the queries about a name are served by
+// the same goroutines, which contend less for the locks of their shard.
+// A worker answers the questions of its query itself. Only the cache is
+// sharded: the authoritative data is read from the database for each
+// question, with no lock of ours to contend for, and secondary zones are
+// only read under a read lock.
+type dnsShardPool struct {
+	handler dns.Handler
+	queues  []chan dnsShardWork
+}
+
+// newDNSShardPool starts workers per shard serving handler, until stop is
+// closed
+func newDNSShardPool(handler dns.Handler, shards, workers int, stop <-chan struct{}) *dnsShardPool {
+	if shards < 1 {
+		shards = 1
+	}
+	p := &dnsShardPool{handler: handler, queues: make([]chan dnsShardWork, shards)}
+	for i := range p.queues {
+		p.queues[i] = make(chan dnsShardWork, dnsShardQueue)
+		for j := 0; j < workers; j++ {
+			go p.work(p.queues[i], stop)
+		}
+	}
+	return p
+}
+
+func (p *dnsShardPool) work(queue <-chan dnsShardWork, stop <-chan struct{}) {
+	for {
+		select {
+		case work := <-queue:
+			p.handler.ServeDNS(work.w, work.req)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Serve queues req for the workers of its shard, or drops it when they
+// have too much to do, as dns.Server drops what it cannot read in time: the
+// client asks again, while a goroutine per query would lose the affinity
+// of shards and let a flood take all the memory
+func (p *dnsShardPool) Serve(w dns.ResponseWriter, req *dns.Msg) {
+	shard := 0
+	if len(req.Question) > 0 {
+		shard = dnsShardOf(req.Question[0].Name, len(p.queues))
+	}
+	select {
+	case p.queues[shard] <- dnsShardWork{w, req}:
+	default:
+		dnsBatchStats.Add("dropped", 1)
+	}
+}
@@ -0,0 +1,40 @@
+package netcore
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSShardPoolDrops(t *testing.T) {
+	stop, release := make(chan struct{}), make(chan struct{})
+	defer close(stop)
+	var served int32
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&served, 1)
+		<-release
+	})
+	p := newDNSShardPool(handler, 1, 1, stop)
+	req := new(dns.Msg)
+	req.SetQuestion("www.example.com.", dns.TypeA)
+
+	// The worker blocks on the first query, the queue takes as many more,
+	// and the rest are dropped rather than answered in goroutines
+	p.Serve(nil, req)
+	for atomic.LoadInt32(&served) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < dnsShardQueue+10; i++ {
+		p.Serve(nil, req)
+	}
+	close(release)
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&served) < dnsShardQueue+1 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&served); n != dnsShardQueue+1 {
+		t.Errorf("served %d queries, want %d", n, dnsShardQueue+1)
+	}
+}
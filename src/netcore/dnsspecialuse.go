@@ -0,0 +1,165 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dnsSpecialUse is how the names of a special-use domain (RFC 6761) are
+// answered, which is never by asking upstream, as they mean nothing or
+// something else there:
+//
+//	localhost  the loopback address for A and AAAA, and no records otherwise
+//	nxdomain   the name does not exist
+//	refuse     REFUSED
+//	local      from our own records, such as our zones and patterns, and
+//	           NXDOMAIN without them
+//	forward    as any other name, upstream included
+//
+// defaultDNSSpecialUse lists the domains and how they are answered, which
+// the dnsspecialuse config directory changes, one domain per key:
+//
+//	local = forward
+//	onion = refuse
+//	lab.test = local
+//
+// The policy of the longest domain applies.
+type dnsSpecialUse struct {
+	domain string // lowercase and fully qualified
+	policy string
+}
+
+// defaultDNSSpecialUse are the special-use domains that resolvers should
+// not send upstream
+var defaultDNSSpecialUse = map[string]string{
+	"localhost": "localhost", // RFC 6761
+	"invalid":   "nxdomain",  // RFC 6761
+	"onion":     "nxdomain",  // RFC 7686
+	"local":     "local",     // RFC 6762, for multicast DNS
+	"home.arpa": "local",     // RFC 8375
+
+	// Reverse zones of private, loopback and link-local addresses (RFC
+	// 6761, RFC 6303)
+	"10.in-addr.arpa":      "local",
+	"168.192.in-addr.arpa": "local",
+	"254.169.in-addr.arpa": "local",
+	"127.in-addr.arpa":     "local",
+	"d.f.ip6.arpa":         "local",
+	"8.e.f.ip6.arpa":       "local",
+	"9.e.f.ip6.arpa":       "local",
+	"a.e.f.ip6.arpa":       "local",
+	"b.e.f.ip6.arpa":       "local",
+}
+
+func init() {
+	for i := 16; i <= 31; i++ {
+		defaultDNSSpecialUse[fmt.Sprintf("%d.172.in-addr.arpa", i)] = "local"
+	}
+}
+
+// localhostTTL is the TTL of the answers for localhost
+const localhostTTL = 86400
+
+func checkDNSSpecialUse(policy string) error {
+	switch policy {
+	case "localhost", "nxdomain", "refuse", "local", "forward":
+		return nil
+	}
+	return fmt.Errorf("unknown special-use policy %q; use localhost, nxdomain, refuse, local or forward", policy)
+}
+
+// dnsSpecialUses are the special-use domains of a zone
+type dnsSpecialUses []*dnsSpecialUse
+
+// loadDNSSpecialUse reads the zone's special-use domains: the defaults, as
+// the dnsspecialuse settings change them
+func loadDNSSpecialUse(cfg *Config) (dnsSpecialUses, error) {
+	policies := make(map[string]string)
+	for domain, policy := range defaultDNSSpecialUse {
+		policies[dns.Fqdn(domain)] = policy
+	}
+	for domain, policy := range cfg.DNSSpecialUse() {
+		policy = strings.TrimSpace(policy)
+		if err := checkDNSSpecialUse(policy); err != nil {
+			return nil, fmt.Errorf("special-use domain %s: %s", domain, err)
+		}
+		policies[dns.Fqdn(cleanFQDN(domain))] = policy
+	}
+	var special dnsSpecialUses
+	for domain, policy := range policies {
+		special = append(special, &dnsSpecialUse{domain: domain, policy: policy})
+	}
+	return special, nil
+}
+
+// find returns the special-use domain that name is in, the longest if
+// several are, or nil
+func (special dnsSpecialUses) find(name string) *dnsSpecialUse {
+	name = strings.ToLower(name)
+	var found *dnsSpecialUse
+	for _, s := range special {
+		if dns.IsSubDomain(s.domain, name) && (found == nil || len(s.domain) > len(found.domain)) {
+			found = s
+		}
+	}
+	return found
+}
+
+// upstream returns false for names that must not be asked upstream, and
+// notes why in a dry run
+func (special dnsSpecialUses) upstream(r *DNSRequest) bool {
+	s := special.find(r.Question.Name)
+	if s == nil || s.policy == "forward" {
+		return true
+	}
+	r.Trace.Note("%s is not asked upstream: %s is a special-use domain", r.Question.Name, s.domain)
+	return false
+}
+
+// newDNSSpecialUseHandler answers the names of special-use domains as their
+// policy says, or has the rest of the chain answer them without asking
+// upstream
+func newDNSSpecialUseHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	special, err := loadDNSSpecialUse(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		s := special.find(r.Question.Name)
+		if s == nil || s.policy == "forward" {
+			return next.ServeDNSQuestion(r)
+		}
+		r.Span.SetAttr("dns.specialuse", s.domain)
+		switch s.policy {
+		case "localhost":
+			r.Trace.Note("%s is in %s, which is always this host", r.Question.Name, s.domain)
+			return localhostAnswers(r.Question)
+		case "nxdomain":
+			r.Trace.Note("%s is in %s, a special-use domain whose names do not exist", r.Question.Name, s.domain)
+			return nil
+		case "refuse":
+			r.Trace.Note("%s is in %s, a special-use domain whose questions are refused", r.Question.Name, s.domain)
+			r.Rcode = dns.RcodeRefused
+			return nil
+		}
+		r.Trace.Note("%s is in %s, a special-use domain answered from our own records only", r.Question.Name, s.domain)
+		return next.ServeDNSQuestion(r)
+	}), nil
+}
+
+// localhostAnswers answers a question for localhost or a name below it with
+// the loopback address, or with the SOA of localhost for other types, so
+// that they get no records rather than NXDOMAIN
+func localhostAnswers(q *dns.Question) []dns.RR {
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: localhostTTL}
+	switch q.Qtype {
+	case dns.TypeA:
+		return []dns.RR{&dns.A{Hdr: hdr, A: net.IPv4(127, 0, 0, 1)}}
+	case dns.TypeAAAA:
+		return []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: net.IPv6loopback}}
+	}
+	return []dns.RR{negativeSOA("localhost", &DNSEntry{Meta: map[string]string{"ns": "localhost", "mbox": "nobody.invalid"}})}
+}
@@ -0,0 +1,39 @@
+package netcore
+
+import (
+	"testing"
+)
+
+func TestDNSSpecialUse(t *testing.T) {
+	cfg := &Config{dnsSpecialUse: map[string]string{"local": "forward", "corp.local": "local", "onion": "refuse"}}
+	special, err := loadDNSSpecialUse(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{"localhost.", "localhost"},
+		{"db.localhost.", "localhost"},
+		{"nothing.invalid.", "nxdomain"},
+		{"facebookcorewwwi.onion.", "refuse"},
+		{"printer.local.", "forward"},
+		{"dc.corp.local.", "local"},
+		{"4.3.20.172.in-addr.arpa.", "local"},
+		{"4.3.32.172.in-addr.arpa.", ""},
+		{"www.example.com.", ""},
+	}
+	for _, test := range tests {
+		policy := ""
+		if s := special.find(test.name); s != nil {
+			policy = s.policy
+		}
+		if policy != test.policy {
+			t.Errorf("special-use policy of %s = %q, want %q", test.name, policy, test.policy)
+		}
+	}
+	if _, err := loadDNSSpecialUse(&Config{dnsSpecialUse: map[string]string{"onion": "drop"}}); err == nil {
+		t.Errorf("loadDNSSpecialUse accepted an unknown policy")
+	}
+}
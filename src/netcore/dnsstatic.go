@@ -0,0 +1,92 @@
+package netcore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const dnsStaticTTL = 300
+
+// dnsStaticDeclStart matches the start of a static record declaration,
+// name=TYPE:
+var dnsStaticDeclStart = regexp.MustCompile(`^\s*[^=\s]+=\s*[A-Za-z][A-Za-z0-9]*:`)
+
+// splitDNSStaticRecords splits a comma-separated list of static record
+// declarations. A comma only starts a new declaration when name=TYPE:
+// follows it, so that values with commas in them, such as TXT records,
+// are kept whole.
+func splitDNSStaticRecords(list string) []string {
+	var decls []string
+	for _, part := range strings.Split(list, ",") {
+		if len(decls) > 0 && !dnsStaticDeclStart.MatchString(part) {
+			decls[len(decls)-1] += "," + part
+			continue
+		}
+		decls = append(decls, part)
+	}
+	return decls
+}
+
+// parseDNSStaticRecord parses a static record declaration of the form
+// name=TYPE:value, such as router.lan=A:192.168.1.1
+func parseDNSStaticRecord(decl string) (dns.RR, error) {
+	nameAndData := strings.SplitN(decl, "=", 2)
+	if len(nameAndData) != 2 {
+		return nil, fmt.Errorf("static record %q: expected name=TYPE:value", decl)
+	}
+	typeAndValue := strings.SplitN(nameAndData[1], ":", 2)
+	if len(typeAndValue) != 2 {
+		return nil, fmt.Errorf("static record %q: expected name=TYPE:value", decl)
+	}
+	name := dns.Fqdn(strings.ToLower(strings.TrimSpace(nameAndData[0])))
+	rrType, ok := dns.StringToType[strings.ToUpper(strings.TrimSpace(typeAndValue[0]))]
+	if !ok {
+		return nil, fmt.Errorf("static record %q: unknown type %s", decl, typeAndValue[0])
+	}
+	value := strings.TrimSpace(typeAndValue[1])
+	if err := validateDNSValue(rrType, &DNSValue{Value: value}); err != nil {
+		return nil, fmt.Errorf("static record %q: %s", decl, err)
+	}
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, dnsStaticTTL, dns.Type(rrType).String(), value))
+	if err != nil {
+		return nil, fmt.Errorf("static record %q: %s", decl, err)
+	}
+	return rr, nil
+}
+
+// newDNSStaticHandler answers questions from the static records declared in
+// the instance configuration. Names with static records are owned entirely by
+// this handler: a question for a type we don't have is answered with nothing
+// rather than being passed along.
+func newDNSStaticHandler(cfg *Config, next DNSHandler) (DNSHandler, error) {
+	records := make(map[string][]dns.RR)
+	for _, decl := range cfg.DNSStaticRecords() {
+		rr, err := parseDNSStaticRecord(decl)
+		if err != nil {
+			return nil, err
+		}
+		records[rr.Header().Name] = append(records[rr.Header().Name], rr)
+	}
+	if len(records) == 0 {
+		return next, nil
+	}
+	return DNSHandlerFunc(func(r *DNSRequest) []dns.RR {
+		rrs, ok := records[strings.ToLower(r.Question.Name)]
+		if !ok {
+			return next.ServeDNSQuestion(r)
+		}
+		r.Trace.Note("%s has static records in the configuration, which answer for it alone", r.Question.Name)
+		var answers []dns.RR
+		for _, rr := range rrs {
+			if r.Question.Qtype == dns.TypeANY || r.Question.Qtype == rr.Header().Rrtype {
+				answer := dns.Copy(rr)
+				answer.Header().Name = r.Question.Name
+				answers = append(answers, answer)
+			}
+		}
+		return answers
+	}), nil
+}
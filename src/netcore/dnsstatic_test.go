@@ -0,0 +1,23 @@
+package netcore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitDNSStaticRecords(t *testing.T) {
+	tests := []struct {
+		list  string
+		decls []string
+	}{
+		{"router.lan=A:192.168.1.1", []string{"router.lan=A:192.168.1.1"}},
+		{"router.lan=A:192.168.1.1,nas.lan=A:192.168.1.2", []string{"router.lan=A:192.168.1.1", "nas.lan=A:192.168.1.2"}},
+		{`lan=TXT:"v=spf1 a, mx",router.lan=A:192.168.1.1`, []string{`lan=TXT:"v=spf1 a, mx"`, "router.lan=A:192.168.1.1"}},
+		{"a.lan=TXT:x,y,z", []string{"a.lan=TXT:x,y,z"}},
+	}
+	for _, test := range tests {
+		if decls := splitDNSStaticRecords(test.list); !reflect.DeepEqual(decls, test.decls) {
+			t.Errorf("splitDNSStaticRecords(%q) = %q, want %q", test.list, decls, test.decls)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package netcore
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	drainGrace  = Flags.Duration("draingrace", 30*time.Second, "How long a draining instance keeps serving, while load balancers and anycast routes move away from it, before it exits.")
+	drainLease  = Flags.Duration("drainlease", 2*time.Minute, "Longest lease a draining instance hands out, so that its clients soon renew elsewhere.")
+	drainRefuse = Flags.Bool("drainrefuse", false, "Answer DNS queries with REFUSED while draining, so that resolvers retry another server at once.")
+)
+
+// drainWait is the longest we wait for in-flight requests once the grace
+// period is over
+const drainWait = 10 * time.Second
+
+// drainState tracks whether this instance is draining for maintenance, and
+// the requests it is still serving
+type drainState struct {
+	sync.Mutex
+	since    time.Time // zero unless draining
+	exit     bool      // exit once drained
+	inFlight int64     // requests being served, updated atomically
+}
+
+var drain = &drainState{}
+
+// Active reports whether this instance is draining
+func (d *drainState) Active() bool {
+	d.Lock()
+	defer d.Unlock()
+	return !d.since.IsZero()
+}
+
+// Status describes the drain for the admin API
+func (d *drainState) Status() map[string]interface{} {
+	d.Lock()
+	defer d.Unlock()
+	status := map[string]interface{}{"draining": !d.since.IsZero(), "exiting": d.exit}
+	if !d.since.IsZero() {
+		status["since"] = d.since
+	}
+	return status
+}
+
+// Begin counts a request in flight; call the returned function when it is
+// done
+func (d *drainState) Begin() func() {
+	atomic.AddInt64(&d.inFlight, 1)
+	return func() { atomic.AddInt64(&d.inFlight, -1) }
+}
+
+// Start drains this instance: health checks fail so that traffic moves away,
+// no new DHCP leases are offered, and leases are kept short. With exit, the
+// instance exits once the grace period is over and in-flight requests are
+// done.
+func (d *drainState) Start(cfg *Config, exit bool) {
+	d.Lock()
+	defer d.Unlock()
+	if d.since.IsZero() {
+		d.since = time.Now()
+		logger.Printf("Draining for maintenance\n")
+		health.Set("drain", Unhealthy, "draining for maintenance")
+		events.Publish(Event{Type: "maintenance.drain", Severity: "warning", Message: cfg.Hostname() + " is draining for maintenance"})
+	}
+	if exit && !d.exit {
+		d.exit = true
+		go d.exitWhenDrained(cfg)
+	}
+}
+
+// Stop returns to normal service, unless the instance is already exiting
+func (d *drainState) Stop() error {
+	d.Lock()
+	defer d.Unlock()
+	if d.exit {
+		return errors.New("this instance is already exiting")
+	}
+	if !d.since.IsZero() {
+		d.since = time.Time{}
+		logger.Printf("No longer draining\n")
+		health.Set("drain", Healthy, "")
+	}
+	return nil
+}
+
+// waitInFlight waits until no request is in flight, or until deadline, and
+// returns how many still are
+func waitInFlight(deadline time.Time) int64 {
+	for atomic.LoadInt64(&drain.inFlight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return atomic.LoadInt64(&drain.inFlight)
+}
+
+func (d *drainState) exitWhenDrained(cfg *Config) {
+	time.Sleep(*drainGrace)
+	if n := waitInFlight(time.Now().Add(drainWait)); n > 0 {
+		logger.Printf("%d requests still in flight after %s; exiting anyway\n", n, drainWait)
+	} else {
+		logger.Printf("Drained; exiting\n")
+	}
+	anycastWithdraw(cfg)
+	requestStop(nil)
+}
+
+// drainSetup makes SIGUSR1 drain this instance and exit
+func drainSetup(cfg *Config) {
+	if len(drainSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, drainSignals...)
+	go func() {
+		for range signals {
+			drain.Start(cfg, true)
+		}
+	}()
+}
+
+// drainRefused answers req with REFUSED if we are draining and told to
+func drainRefused(w dns.ResponseWriter, req *dns.Msg) bool {
+	if !*drainRefuse || !drain.Active() {
+		return false
+	}
+	refused := new(dns.Msg)
+	refused.SetRcode(req, dns.RcodeRefused)
+	w.WriteMsg(refused)
+	return true
+}
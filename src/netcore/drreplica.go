@@ -0,0 +1,366 @@
+package netcore
+
+import (
+	"expvar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+var drTarget = Flags.String("drtarget", "", "Copy every write asynchronously to a second backend for disaster recovery: the URLs of an etcd cluster in another site, or a file kept as a backup (empty to disable).")
+var drResyncInterval = Flags.Duration("drresyncinterval", time.Hour, "How often all data is copied again to -drtarget, besides each write as it is made.")
+
+// drStats counts the writes copied to the DR target, those dropped for a
+// full copy instead, the failures and the full copies, and holds the Unix
+// time of the last successful copy under "last"
+var drStats = expvar.NewMap("dr")
+
+const (
+	drQueueSize   = 4096
+	drRetry       = 30 * time.Second
+	drPromotedKey = "dr/promoted"
+)
+
+// drSkipped are the trees that are not copied to the DR target: those of
+// running instances, and the promotion marker of the target itself
+var drSkipped = map[string]bool{"locks": true, "leader": true, "fleet": true, "dr": true}
+
+// drBackend is where the DR copy is kept
+type drBackend interface {
+	etcdClient
+	flush() error // makes the writes so far durable
+}
+
+// drEtcdBackend is an etcd cluster, which writes are durable on
+type drEtcdBackend struct {
+	etcdClient
+}
+
+func (b drEtcdBackend) flush() error { return nil }
+
+// drFileBackend keeps the copy in memory, and writes it to a file in the
+// format of a backup, which netcorectl promote and restore read
+type drFileBackend struct {
+	*memKV
+	path string
+}
+
+func (b drFileBackend) flush() error {
+	backup, err := EtcdDB{b.memKV}.Backup()
+	if err != nil {
+		return err
+	}
+	backup.Hostname, _ = os.Hostname()
+	data, err := encodeBackup(backup)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(b.path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(b.path+".tmp", b.path)
+}
+
+// drWrite is a write made to the primary backend, to make to the target
+type drWrite struct {
+	key     string
+	value   string
+	ttl     uint64
+	dir     bool
+	deleted bool
+}
+
+// drKV is the primary backend, whose writes are also copied to a DR target
+// in the background. Writes are never held up by the target: when it is
+// unreachable, or falls too far behind, the writes in between are dropped
+// and all data is copied again once it is back. Copying stops for good once
+// the target has been promoted, so that a lost site coming back cannot
+// overwrite the one that replaced it.
+type drKV struct {
+	etcdClient
+	target  drBackend
+	queue   chan drWrite
+	resync  chan struct{}
+	stopped int32
+}
+
+// newDRKV returns primary, copying its writes to target
+func newDRKV(primary etcdClient, target string) (*drKV, error) {
+	var backend drBackend
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		client := etcd.NewClient(strings.Split(target, ","))
+		client.SetConsistency("WEAK_CONSISTENCY")
+		backend = drEtcdBackend{client}
+	} else {
+		backend = drFileBackend{memKV: newMemKV(), path: target}
+	}
+	d := &drKV{
+		etcdClient: primary,
+		target:     backend,
+		queue:      make(chan drWrite, drQueueSize),
+		resync:     make(chan struct{}, 1),
+	}
+	go d.run()
+	return d, nil
+}
+
+// enqueue queues a write to copy, unless it is to a tree that is not
+// copied. A full queue is dropped for a full copy.
+func (d *drKV) enqueue(w drWrite) {
+	if atomic.LoadInt32(&d.stopped) != 0 || drSkipped[strings.SplitN(strings.TrimPrefix(w.key, "/"), "/", 2)[0]] {
+		return
+	}
+	select {
+	case d.queue <- w:
+	default:
+		drStats.Add("dropped", 1)
+		select {
+		case d.resync <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run copies the queued writes to the target as they come, and all data
+// every -drresyncinterval or after a failure
+func (d *drKV) run() {
+	behind := true // nothing is copied yet
+	ticker := time.NewTicker(*drResyncInterval)
+	defer ticker.Stop()
+	for {
+		if behind {
+			behind = !d.copyAll()
+		}
+		if atomic.LoadInt32(&d.stopped) != 0 {
+			return
+		}
+		var retry <-chan time.Time
+		if behind {
+			retry = time.After(drRetry)
+		}
+		select {
+		case w := <-d.queue:
+			if behind {
+				continue // the next full copy has it
+			}
+			behind = !d.copyQueued(w)
+		case <-d.resync:
+			behind = true
+		case <-ticker.C:
+			behind = true
+		case <-retry:
+		}
+	}
+}
+
+// copyQueued writes w and any others queued after it to the target
+func (d *drKV) copyQueued(w drWrite) bool {
+	if d.promoted() {
+		return true
+	}
+	writes := []drWrite{w}
+drain:
+	for len(writes) < drQueueSize {
+		select {
+		case w := <-d.queue:
+			writes = append(writes, w)
+		default:
+			break drain
+		}
+	}
+	for _, w := range writes {
+		var err error
+		switch {
+		case w.deleted:
+			_, err = d.target.Delete(w.key, true)
+			if etcdKeyNotFound(err) {
+				err = nil
+			}
+		case w.dir:
+			_, err = d.target.CreateDir(w.key, w.ttl)
+			if etcdKeyExists(err) {
+				err = nil
+			}
+		default:
+			_, err = d.target.Set(w.key, w.value, w.ttl)
+		}
+		if err != nil {
+			return d.failed(err)
+		}
+	}
+	if err := d.target.flush(); err != nil {
+		return d.failed(err)
+	}
+	drStats.Add("copied", int64(len(writes)))
+	d.succeeded()
+	return true
+}
+
+// copyAll copies every tree of the primary to the target, then deletes what
+// the target has that the primary does not
+func (d *drKV) copyAll() bool {
+	if d.promoted() {
+		return true
+	}
+	response, err := d.etcdClient.Get("/", true, true)
+	if err != nil {
+		logger.Printf("[DR] Unable to read the data to copy: %s\n", err)
+		health.Set("dr", Degraded, "unable to read the data to copy: "+err.Error())
+		drStats.Add("failed", 1)
+		return false
+	}
+	keys := make(map[string]bool)
+	var collect func(node *etcd.Node)
+	collect = func(node *etcd.Node) {
+		keys[strings.TrimPrefix(node.Key, "/")] = true
+		for _, child := range node.Nodes {
+			collect(child)
+		}
+	}
+	for _, tree := range response.Node.Nodes {
+		if drSkipped[strings.TrimPrefix(tree.Key, "/")] {
+			continue
+		}
+		collect(tree)
+		if err := copyNode(d.target, tree); err != nil {
+			return d.failed(err)
+		}
+	}
+
+	existing, err := d.target.Get("/", true, true)
+	if err != nil {
+		return d.failed(err)
+	}
+	var prune func(node *etcd.Node) error
+	prune = func(node *etcd.Node) error {
+		key := strings.TrimPrefix(node.Key, "/")
+		if !keys[key] {
+			_, err := d.target.Delete(key, true)
+			if etcdKeyNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		for _, child := range node.Nodes {
+			if err := prune(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, tree := range existing.Node.Nodes {
+		if drSkipped[strings.TrimPrefix(tree.Key, "/")] {
+			continue
+		}
+		if err := prune(tree); err != nil {
+			return d.failed(err)
+		}
+	}
+	if err := d.target.flush(); err != nil {
+		return d.failed(err)
+	}
+	drStats.Add("resyncs", 1)
+	logger.Printf("[DR] Copied all data to %s\n", *drTarget)
+	d.succeeded()
+	return true
+}
+
+// promoted stops copying, and returns true, once the target has been made
+// the source of truth by netcorectl promote
+func (d *drKV) promoted() bool {
+	response, err := d.target.Get(drPromotedKey, false, false)
+	if err != nil {
+		return false
+	}
+	atomic.StoreInt32(&d.stopped, 1)
+	logger.Printf("[DR] %s was promoted (%s); writes are no longer copied to it\n", *drTarget, response.Node.Value)
+	health.Set("dr", Degraded, "the DR target was promoted; writes are no longer copied to it")
+	return true
+}
+
+func (d *drKV) failed(err error) bool {
+	logger.Printf("[DR] Copy to %s failed: %s\n", *drTarget, err)
+	health.Set("dr", Degraded, "the DR copy is behind: "+err.Error())
+	drStats.Add("failed", 1)
+	return false
+}
+
+func (d *drKV) succeeded() {
+	last := new(expvar.Int)
+	last.Set(time.Now().Unix())
+	drStats.Set("last", last)
+	health.Set("dr", Healthy, "")
+}
+
+func (d *drKV) Set(key string, value string, ttl uint64) (*etcd.Response, error) {
+	response, err := d.etcdClient.Set(key, value, ttl)
+	if err == nil {
+		d.enqueue(drWrite{key: key, value: value, ttl: ttl})
+	}
+	return response, err
+}
+
+func (d *drKV) CreateDir(key string, ttl uint64) (*etcd.Response, error) {
+	response, err := d.etcdClient.CreateDir(key, ttl)
+	if err == nil {
+		d.enqueue(drWrite{key: key, ttl: ttl, dir: true})
+	}
+	return response, err
+}
+
+func (d *drKV) Create(key string, value string, ttl uint64) (*etcd.Response, error) {
+	response, err := d.etcdClient.Create(key, value, ttl)
+	if err == nil {
+		d.enqueue(drWrite{key: key, value: value, ttl: ttl})
+	}
+	return response, err
+}
+
+// CreateInOrder copies the key that the primary picked
+func (d *drKV) CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error) {
+	response, err := d.etcdClient.CreateInOrder(dir, value, ttl)
+	if err == nil && response != nil && response.Node != nil {
+		d.enqueue(drWrite{key: response.Node.Key, value: value, ttl: ttl})
+	}
+	return response, err
+}
+
+func (d *drKV) Delete(key string, recursive bool) (*etcd.Response, error) {
+	response, err := d.etcdClient.Delete(key, recursive)
+	if err == nil {
+		d.enqueue(drWrite{key: key, deleted: true})
+	}
+	return response, err
+}
+
+func (d *drKV) DeleteDir(key string) (*etcd.Response, error) {
+	response, err := d.etcdClient.DeleteDir(key)
+	if err == nil {
+		d.enqueue(drWrite{key: key, deleted: true})
+	}
+	return response, err
+}
+
+func (d *drKV) CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	response, err := d.etcdClient.CompareAndSwap(key, value, ttl, prevValue, prevIndex)
+	if err == nil {
+		d.enqueue(drWrite{key: key, value: value, ttl: ttl})
+	}
+	return response, err
+}
+
+func (d *drKV) CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	response, err := d.etcdClient.CompareAndDelete(key, prevValue, prevIndex)
+	if err == nil {
+		d.enqueue(drWrite{key: key, deleted: true})
+	}
+	return response, err
+}
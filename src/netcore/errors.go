@@ -0,0 +1,88 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors from the database come in a few kinds, which callers tell apart
+// with ErrorKind rather than by their messages, and which the API answers
+// with their own HTTP status (see apiStatus). Errors of the etcd client are
+// classified too, so that providers may return them as they are.
+var (
+	// ErrNotFound is returned when what was asked for does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a change clashes with the current state,
+	// such as another change in progress; trying again may succeed
+	ErrConflict = errors.New("conflict")
+	// ErrBackendUnavailable is returned when the database cannot be reached
+	// or cannot take the request, such as a write to a read-only replica
+	ErrBackendUnavailable = errors.New("backend unavailable")
+)
+
+// kindError is an error of one of the kinds above with a message of its own
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e kindError) Error() string {
+	return e.message
+}
+
+// notFoundError is an ErrNotFound with its own message
+func notFoundError(format string, args ...interface{}) error {
+	return kindError{ErrNotFound, fmt.Sprintf(format, args...)}
+}
+
+// conflictError is an ErrConflict with its own message
+func conflictError(message string) error {
+	return kindError{ErrConflict, message}
+}
+
+// unavailableError is an ErrBackendUnavailable with its own message
+func unavailableError(message string) error {
+	return kindError{ErrBackendUnavailable, message}
+}
+
+// ValidationError is returned when a request is malformed, and names the
+// field at fault when there is one
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Reason
+	}
+	return e.Field + ": " + e.Reason
+}
+
+// invalid is a ValidationError of field
+func invalid(field, format string, args ...interface{}) error {
+	return &ValidationError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// ErrorKind returns ErrNotFound, ErrConflict or ErrBackendUnavailable for
+// errors of those kinds, or nil for any other error, including a
+// *ValidationError
+func ErrorKind(err error) error {
+	switch e := err.(type) {
+	case nil, *ValidationError:
+		return nil
+	case kindError:
+		return e.kind
+	}
+	switch {
+	case err == ErrNotFound || err == ErrConflict || err == ErrBackendUnavailable:
+		return err
+	case etcdKeyNotFound(err):
+		return ErrNotFound
+	case etcdKeyExists(err) || etcdCompareFailed(err):
+		return ErrConflict
+	case etcdUnreachable(err):
+		return ErrBackendUnavailable
+	}
+	return nil
+}
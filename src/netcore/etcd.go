@@ -1,4 +1,4 @@
-package main
+package netcore
 
 import (
 	"strings"
@@ -7,10 +7,30 @@ import (
 )
 
 type EtcdDB struct {
-	client *etcd.Client
+	client etcdClient
 }
 
-func NewEtcdDB(serverList string) DB {
+// etcdKV is the subset of the etcd client used to read and write keys, which
+// allows a snapshot to stand in for the live cluster
+type etcdKV interface {
+	Get(key string, sort, recursive bool) (*etcd.Response, error)
+	Set(key string, value string, ttl uint64) (*etcd.Response, error)
+	CreateDir(key string, ttl uint64) (*etcd.Response, error)
+}
+
+// etcdClient is everything EtcdDB uses of the etcd client, which allows an
+// in-memory store to stand in for a cluster
+type etcdClient interface {
+	etcdKV
+	Create(key string, value string, ttl uint64) (*etcd.Response, error)
+	CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error)
+	Delete(key string, recursive bool) (*etcd.Response, error)
+	DeleteDir(key string) (*etcd.Response, error)
+	CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error)
+	CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error)
+}
+
+func NewEtcdDB(serverList string) EtcdDB {
 	var servers []string
 	if serverList != "" {
 		servers = strings.Split(serverList, ",")
@@ -27,3 +47,31 @@ func etcdKeyNotFound(err error) bool {
 	}
 	return strings.Contains(err.Error(), "Key not found")
 }
+
+func etcdUnreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), "not reachable")
+}
+
+func etcdKeyExists(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), "Key already exists")
+}
+
+func etcdDirNotEmpty(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), "Directory not empty")
+}
+
+func etcdCompareFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), "Compare failed")
+}
@@ -0,0 +1,204 @@
+package netcore
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+var (
+	etcdBreakerFailures = Flags.Int("etcdbreaker", 5, "Consecutive failures to reach etcd after which calls fail fast, falling back to the snapshot, while etcd is probed in the background (0 to disable).")
+	etcdRetries         = Flags.Int("etcdretries", 2, "Times a read is retried, with backoff, when etcd cannot be reached.")
+)
+
+const (
+	// etcdRetryBackoff is how long a read waits before its first retry,
+	// doubling for each one after
+	etcdRetryBackoff = 50 * time.Millisecond
+	// etcdProbeInterval is how long an open breaker waits before probing
+	// etcd, doubling up to etcdMaxProbeInterval while the probes fail
+	etcdProbeInterval    = time.Second
+	etcdMaxProbeInterval = 30 * time.Second
+)
+
+// etcdBreakerStats counts the breaker's retries, openings, short-circuited
+// calls and probes, and holds its state, published with expvar
+var etcdBreakerStats = expvar.NewMap("etcd_breaker")
+
+// Circuit breaker states
+const (
+	breakerClosed = "closed"
+	breakerOpen   = "open"
+)
+
+// breakerKV is an etcd client whose reads are retried with backoff when
+// etcd cannot be reached, and that stops calling etcd once too many calls
+// in a row failed: calls then fail at once with an unreachable error, which
+// has SnapshotDB answer from its snapshot, until a background probe finds
+// etcd back. Writes are never retried, since some are not idempotent.
+type breakerKV struct {
+	etcdClient
+	sync.Mutex
+	threshold int
+	failures  int
+	open      bool
+	since     time.Time
+}
+
+// etcdBreaker is the breaker in front of etcd, if any, for /readyz
+var etcdBreaker *breakerKV
+
+// newBreakerKV returns client behind a breaker that opens after threshold
+// consecutive failures
+func newBreakerKV(client etcdClient, threshold int) *breakerKV {
+	b := &breakerKV{etcdClient: client, threshold: threshold, since: time.Now()}
+	b.publish()
+	etcdBreaker = b
+	return b
+}
+
+// State describes the breaker for /readyz
+func (b *breakerKV) State() map[string]interface{} {
+	b.Lock()
+	defer b.Unlock()
+	state := breakerClosed
+	if b.open {
+		state = breakerOpen
+	}
+	return map[string]interface{}{"state": state, "since": b.since, "failures": b.failures}
+}
+
+// publish sets the breaker's state in its stats and in the health registry;
+// callers hold the lock, or own b
+func (b *breakerKV) publish() {
+	state := new(expvar.String)
+	if b.open {
+		state.Set(breakerOpen)
+		health.Set("etcd_breaker", Degraded, fmt.Sprintf("etcd unreachable since %s, serving stale data", b.since.Format(time.RFC3339)))
+	} else {
+		state.Set(breakerClosed)
+		health.Set("etcd_breaker", Healthy, "")
+	}
+	etcdBreakerStats.Set("state", state)
+}
+
+// isOpen reports whether calls are short-circuited
+func (b *breakerKV) isOpen() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.open
+}
+
+// call runs f unless the breaker is open, and counts its outcome
+func (b *breakerKV) call(f func() (*etcd.Response, error)) (*etcd.Response, error) {
+	b.Lock()
+	open, since := b.open, b.since
+	b.Unlock()
+	if open {
+		etcdBreakerStats.Add("short_circuited", 1)
+		return nil, unavailableError("etcd is not reachable: circuit breaker open since " + since.Format(time.RFC3339))
+	}
+	response, err := f()
+	b.report(err)
+	return response, err
+}
+
+// read runs f as call does, retrying it with backoff while etcd cannot be
+// reached
+func (b *breakerKV) read(f func() (*etcd.Response, error)) (*etcd.Response, error) {
+	backoff := etcdRetryBackoff
+	for retry := 0; ; retry++ {
+		response, err := b.call(f)
+		if !etcdUnreachable(err) || retry >= *etcdRetries || b.isOpen() {
+			return response, err
+		}
+		etcdBreakerStats.Add("retries", 1)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// report counts a failure to reach etcd, opening the breaker after too many
+// in a row, or resets the count on any answer from etcd, errors included
+func (b *breakerKV) report(err error) {
+	b.Lock()
+	defer b.Unlock()
+	if !etcdUnreachable(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.open || b.failures < b.threshold {
+		return
+	}
+	b.open, b.since = true, time.Now()
+	b.publish()
+	etcdBreakerStats.Add("opened", 1)
+	logger.Printf("[ETCD] Circuit breaker open after %d failures: %s\n", b.failures, err)
+	events.Publish(Event{Type: "etcd.breaker", Severity: "critical", Message: fmt.Sprintf("etcd unreachable after %d attempts, serving stale data", b.failures)})
+	go b.probe()
+}
+
+// probe checks whether etcd is back, more and more slowly, and closes the
+// breaker once it answers
+func (b *breakerKV) probe() {
+	interval := etcdProbeInterval
+	for {
+		time.Sleep(interval)
+		etcdBreakerStats.Add("probes", 1)
+		_, err := b.etcdClient.Get("config", false, false)
+		if !etcdUnreachable(err) {
+			break
+		}
+		if interval *= 2; interval > etcdMaxProbeInterval {
+			interval = etcdMaxProbeInterval
+		}
+	}
+	b.Lock()
+	defer b.Unlock()
+	logger.Printf("[ETCD] Circuit breaker closed: etcd is back after %s\n", time.Since(b.since))
+	events.Publish(Event{Type: "etcd.breaker", Severity: "info", Message: "etcd is reachable again"})
+	b.open, b.failures, b.since = false, 0, time.Now()
+	b.publish()
+}
+
+func (b *breakerKV) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	return b.read(func() (*etcd.Response, error) { return b.etcdClient.Get(key, sort, recursive) })
+}
+
+func (b *breakerKV) Set(key string, value string, ttl uint64) (*etcd.Response, error) {
+	return b.call(func() (*etcd.Response, error) { return b.etcdClient.Set(key, value, ttl) })
+}
+
+func (b *breakerKV) CreateDir(key string, ttl uint64) (*etcd.Response, error) {
+	return b.call(func() (*etcd.Response, error) { return b.etcdClient.CreateDir(key, ttl) })
+}
+
+func (b *breakerKV) Create(key string, value string, ttl uint64) (*etcd.Response, error) {
+	return b.call(func() (*etcd.Response, error) { return b.etcdClient.Create(key, value, ttl) })
+}
+
+func (b *breakerKV) CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error) {
+	return b.call(func() (*etcd.Response, error) { return b.etcdClient.CreateInOrder(dir, value, ttl) })
+}
+
+func (b *breakerKV) Delete(key string, recursive bool) (*etcd.Response, error) {
+	return b.call(func() (*etcd.Response, error) { return b.etcdClient.Delete(key, recursive) })
+}
+
+func (b *breakerKV) DeleteDir(key string) (*etcd.Response, error) {
+	return b.call(func() (*etcd.Response, error) { return b.etcdClient.DeleteDir(key) })
+}
+
+func (b *breakerKV) CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	return b.call(func() (*etcd.Response, error) {
+		return b.etcdClient.CompareAndSwap(key, value, ttl, prevValue, prevIndex)
+	})
+}
+
+func (b *breakerKV) CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	return b.call(func() (*etcd.Response, error) { return b.etcdClient.CompareAndDelete(key, prevValue, prevIndex) })
+}
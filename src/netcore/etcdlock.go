@@ -0,0 +1,93 @@
+package netcore
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// etcdLock is a lock held as a key with a TTL, so that it frees itself if
+// its holder dies. The holder renews it in the background for as long as it
+// holds it; should renewing fail for longer than the TTL, or the key be
+// taken over, the lock is lost and Lost says why, so that the holder stops
+// before it makes changes that are no longer serialized.
+type etcdLock struct {
+	sync.Mutex
+	client  etcdClient
+	key, id string
+	ttl     time.Duration
+	renewed time.Time
+	lost    error
+	stop    chan struct{}
+}
+
+// lockEtcd takes the lock held at key, failing with the error of Create,
+// such as one that etcdKeyExists recognizes, if another holds it
+func lockEtcd(client etcdClient, key string, ttl uint64) (*etcdLock, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	l := &etcdLock{
+		client:  client,
+		key:     key,
+		id:      fmt.Sprintf("%x", b),
+		ttl:     time.Duration(ttl) * time.Second,
+		renewed: time.Now(),
+		stop:    make(chan struct{}),
+	}
+	if _, err := client.Create(key, l.id, ttl); err != nil {
+		return nil, err
+	}
+	go l.renew()
+	return l, nil
+}
+
+// renew extends the lock's TTL three times per TTL until it is released
+func (l *etcdLock) renew() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		_, err := l.client.CompareAndSwap(l.key, l.id, uint64(l.ttl/time.Second), l.id, 0)
+		l.Lock()
+		switch {
+		case err == nil:
+			l.renewed = now
+		case etcdCompareFailed(err) || etcdKeyNotFound(err):
+			l.lost = fmt.Errorf("lock %s was taken over", l.key)
+		default:
+			logger.Printf("Unable to renew the lock %s: %s\n", l.key, err)
+		}
+		lost := l.lost != nil
+		l.Unlock()
+		if lost {
+			return
+		}
+	}
+}
+
+// Lost returns why the lock is no longer held, or nil while it is
+func (l *etcdLock) Lost() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.lost == nil && time.Since(l.renewed) >= l.ttl {
+		l.lost = fmt.Errorf("lock %s expired: it could not be renewed for %s", l.key, l.ttl)
+	}
+	return l.lost
+}
+
+// Release stops renewing the lock and frees it, unless it was lost
+func (l *etcdLock) Release() {
+	close(l.stop)
+	if l.Lost() != nil {
+		return
+	}
+	if _, err := l.client.CompareAndDelete(l.key, l.id, 0); err != nil {
+		logger.Printf("Unable to release the lock %s: %s\n", l.key, err)
+	}
+}
@@ -0,0 +1,33 @@
+package netcore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEtcdLock(t *testing.T) {
+	kv := newMemKV()
+	lock, err := lockEtcd(kv, "locks/test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockEtcd(kv, "locks/test", 1); !etcdKeyExists(err) {
+		t.Errorf("a held lock was taken again: %v", err)
+	}
+
+	// Renewals keep the lock past its TTL
+	time.Sleep(1500 * time.Millisecond)
+	if err := lock.Lost(); err != nil {
+		t.Fatalf("lock lost while renewed: %s", err)
+	}
+
+	kv.Set("locks/test", "someone else", 0)
+	time.Sleep(500 * time.Millisecond)
+	if lock.Lost() == nil {
+		t.Errorf("lock still held after it was taken over")
+	}
+	lock.Release()
+	if response, err := kv.Get("locks/test", false, false); err != nil || response.Node.Value != "someone else" {
+		t.Errorf("releasing a lost lock freed the new holder's")
+	}
+}
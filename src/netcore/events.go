@@ -0,0 +1,101 @@
+package netcore
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var webhooks = Flags.String("webhook", "", "Comma-separated list of URLs that events, such as security alerts, are POSTed to as JSON.")
+
+// Event is something that happened which other systems may want to know
+// about, such as a security alert
+type Event struct {
+	Time     time.Time         `json:"time"`
+	Type     string            `json:"type"`     // dotted, such as dns.anomaly.tunneling
+	Severity string            `json:"severity"` // info, warning or critical
+	Message  string            `json:"message"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+// eventBus delivers events to every subscriber. Publishing never blocks;
+// subscribers that fall behind miss events rather than holding up DNS and
+// DHCP.
+type eventBus struct {
+	sync.RWMutex
+	subscribers []chan Event
+}
+
+var (
+	events     = &eventBus{}
+	eventStats = expvar.NewMap("events")
+)
+
+// Subscribe returns a channel that receives every event published from now on
+func (b *eventBus) Subscribe(buffer int) <-chan Event {
+	ch := make(chan Event, buffer)
+	b.Lock()
+	defer b.Unlock()
+	b.subscribers = append(b.subscribers, ch)
+	return ch
+}
+
+// Publish sends the event to all subscribers
+func (b *eventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	logger.Printf("[EVENT] %s %s: %s\n", e.Severity, e.Type, e.Message)
+	eventStats.Add("published", 1)
+	b.RLock()
+	defer b.RUnlock()
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			eventStats.Add("dropped", 1)
+		}
+	}
+}
+
+// webhookSetup starts delivering events to the configured webhooks
+func webhookSetup() {
+	for _, url := range strings.Split(*webhooks, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		go deliverWebhook(url, events.Subscribe(256))
+	}
+}
+
+func deliverWebhook(url string, ch <-chan Event) {
+	client := http.Client{Timeout: 10 * time.Second}
+	for e := range ch {
+		body, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		if err := postWebhook(&client, url, body); err != nil {
+			logger.Printf("[EVENT] Webhook %s failed: %s\n", url, err)
+			eventStats.Add("webhook_failed", 1)
+		}
+	}
+}
+
+func postWebhook(client *http.Client, url string, body []byte) error {
+	response, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("responded %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package netcore
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+var heartbeatInterval = Flags.Duration("heartbeat", 30*time.Second, "How often this instance refreshes its entry in the fleet inventory.")
+var fleetRetention = Flags.Duration("fleetretention", 7*24*time.Hour, "How long an instance that stopped sending heartbeats stays in the fleet inventory, reported as down, before its entry expires.")
+
+// version is set at build time with -ldflags "-X netcore.version=..."
+var version = "dev"
+
+// fleetMissedHeartbeats is how many heartbeats a member may miss before it
+// is reported as down
+const fleetMissedHeartbeats = 3
+
+// FleetDB keeps the inventory of running instances, each refreshed by the
+// instance itself
+type FleetDB interface {
+	RegisterMember(member FleetMember) error
+	GetFleet() ([]FleetMember, error)
+}
+
+// FleetMember is a netcore instance as it last described itself
+type FleetMember struct {
+	Hostname  string            `json:"hostname"`
+	ID        string            `json:"id"` // of the host, see identity.go
+	Version   string            `json:"version"`
+	PID       int               `json:"pid"`
+	Roles     []string          `json:"roles"`
+	Listen    map[string]string `json:"listen"` // by role
+	Zones     []string          `json:"zones"`
+	Subnets   []string          `json:"subnets"`
+	Started   time.Time         `json:"started"`
+	Heartbeat time.Time         `json:"heartbeat"`
+	Leads     []string          `json:"leads"`           // duties this instance performs for the cluster
+	Interval  time.Duration     `json:"interval"`        // between heartbeats
+	Alive     bool              `json:"alive,omitempty"` // worked out when read
+}
+
+// alive reports whether the member has sent a heartbeat recently enough
+func (m FleetMember) alive(now time.Time) bool {
+	return now.Sub(m.Heartbeat) <= time.Duration(fleetMissedHeartbeats)*m.Interval
+}
+
+// ttl is how long the member's entry is kept after its last heartbeat: as
+// long as it is alive, then retention while it is reported as down
+func (m FleetMember) ttl(retention time.Duration) uint64 {
+	return uint64((time.Duration(fleetMissedHeartbeats)*m.Interval + retention).Seconds() + 0.5)
+}
+
+// fleetMembers sorts members by hostname
+type fleetMembers []FleetMember
+
+func (m fleetMembers) Len() int           { return len(m) }
+func (m fleetMembers) Less(i, j int) bool { return m[i].Hostname < m[j].Hostname }
+func (m fleetMembers) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// fleetSetup registers this instance in the fleet inventory, with the
+// services it runs, and keeps its heartbeat going
+func fleetSetup(cfg *Config, dhcp, dns, api bool) {
+	member := FleetMember{
+		Hostname: cfg.Hostname(),
+		ID:       cfg.Identity().ID,
+		Version:  version,
+		PID:      os.Getpid(),
+		Listen:   make(map[string]string),
+		Zones:    []string{cfg.Zone()},
+		Subnets:  []string{cfg.Subnet().String()},
+		Started:  time.Now(),
+		Interval: *heartbeatInterval,
+	}
+	if dhcp {
+		member.Roles = append(member.Roles, "dhcp")
+		for _, instance := range cfg.DHCPInstances() {
+			listen := instance.NIC
+			if instance.IP != nil {
+				listen += " " + instance.IP.String()
+			}
+			if member.Listen["dhcp"] != "" {
+				listen = member.Listen["dhcp"] + ", " + listen
+			}
+			member.Listen["dhcp"] = listen
+			if !containsString(member.Zones, instance.Zone) {
+				member.Zones = append(member.Zones, instance.Zone)
+			}
+			if instance.Pool != nil && !containsString(member.Subnets, instance.Pool.String()) {
+				member.Subnets = append(member.Subnets, instance.Pool.String())
+			}
+		}
+	}
+	if dns {
+		member.Roles = append(member.Roles, "dns")
+		member.Listen["dns"] = *dnslisten
+	}
+	if api {
+		member.Roles = append(member.Roles, "api")
+		member.Listen["api"] = *apilisten
+	}
+	if *statuslisten != "" {
+		member.Listen["status"] = *statuslisten
+	}
+	sort.Strings(member.Zones)
+
+	go func() {
+		for {
+			member.Heartbeat = time.Now()
+			member.Leads = leading.List()
+			if err := cfg.db.RegisterMember(member); err != nil {
+				logger.Printf("Fleet heartbeat failed: %s\n", err)
+			}
+			time.Sleep(*heartbeatInterval)
+		}
+	}()
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
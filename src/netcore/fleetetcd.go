@@ -0,0 +1,45 @@
+package netcore
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Each instance keeps its own entry under /fleet/<hostname>. Entries are
+// kept for -fleetretention after an instance stops, so that it shows up as
+// down rather than disappearing, and then expire so that the inventory
+// does not keep retired hosts forever.
+
+func (db EtcdDB) RegisterMember(member FleetMember) error {
+	member.Alive = false
+	data, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.Set("fleet/"+member.Hostname, string(data), member.ttl(*fleetRetention))
+	return err
+}
+
+func (db EtcdDB) GetFleet() ([]FleetMember, error) {
+	response, err := db.client.Get("fleet", false, false)
+	if etcdKeyNotFound(err) {
+		return []FleetMember{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	members := fleetMembers{}
+	for _, node := range response.Node.Nodes {
+		var member FleetMember
+		if err := json.Unmarshal([]byte(node.Value), &member); err != nil {
+			logger.Printf("[FLEET] Skipping unreadable entry %s: %s\n", node.Key, err)
+			continue
+		}
+		member.Alive = member.alive(now)
+		members = append(members, member)
+	}
+	sort.Sort(members)
+	return members, nil
+}
@@ -0,0 +1,24 @@
+package netcore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFleetEntriesExpire(t *testing.T) {
+	db := NewMemoryDB()
+	member := FleetMember{Hostname: "ns1.example.com", Interval: 30 * time.Second, Heartbeat: time.Now()}
+	if err := db.RegisterMember(member); err != nil {
+		t.Fatal(err)
+	}
+	response, err := db.client.Get("fleet/ns1.example.com", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(member.ttl(*fleetRetention)); response.Node.Expiration == nil || response.Node.TTL != want {
+		t.Errorf("fleet entry TTL = %d, want %d", response.Node.TTL, want)
+	}
+	if member.ttl(time.Hour) != 3690 {
+		t.Errorf("ttl = %d, want three heartbeats and the retention", member.ttl(time.Hour))
+	}
+}
@@ -0,0 +1,269 @@
+package netcore
+
+import (
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsForwarderPoolStats counts, for each forwarder pool, the questions
+// forwarded to it under "<pool>", the exchanges that failed under
+// "<pool> failures" and the servers marked down under "<pool> down"
+var dnsForwarderPoolStats = expvar.NewMap("dns_forwarder_pools")
+
+// defaultForwarderPool is the pool of the zone's dnsforwarders, which
+// questions no forward rule matches go to
+const defaultForwarderPool = "default"
+
+// iteratePool is the pool that forward rules pick to have questions resolved
+// iteratively rather than forwarded
+const iteratePool = "iterate"
+
+// Health settings of forwarder pools that do not set their own
+const (
+	defaultForwarderTimeout  = 2 * time.Second
+	defaultForwarderMaxFails = 3
+	defaultForwarderDowntime = 30 * time.Second
+)
+
+// dnsForwarderPool is a named group of upstream resolvers. Pools are stored
+// in the dnsforwarderpool config directory, one per key named after the
+// pool, as the comma-separated servers followed by optional settings:
+//
+//	corp-ad = 10.1.0.10:53,10.1.0.11:53 transport tcp timeout 1s
+//	public-filtered = 9.9.9.9:53,149.112.112.112:53 maxfails 5 downtime 1m
+//	public-unfiltered = 8.8.8.8:53,8.8.4.4:53
+//
+// The transport is udp, which retries truncated answers over tcp, or tcp.
+// A server that fails maxfails exchanges in a row is skipped for downtime,
+// unless all of the pool's servers are down.
+type dnsForwarderPool struct {
+	name      string
+	servers   []string
+	transport string
+	timeout   time.Duration
+	maxFails  int
+	downtime  time.Duration
+}
+
+func parseDNSForwarderPool(name, text string) (*dnsForwarderPool, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields)%2 != 1 {
+		return nil, fmt.Errorf("malformed forwarder pool %s %q", name, text)
+	}
+	if err := checkHostPorts(fields[0]); err != nil {
+		return nil, fmt.Errorf("forwarder pool %s: %s", name, err)
+	}
+	p := &dnsForwarderPool{
+		name:      name,
+		servers:   splitDHCPList(fields[0]),
+		transport: "udp",
+		timeout:   defaultForwarderTimeout,
+		maxFails:  defaultForwarderMaxFails,
+		downtime:  defaultForwarderDowntime,
+	}
+	for i := 1; i < len(fields); i += 2 {
+		value := fields[i+1]
+		var err error
+		switch fields[i] {
+		case "transport":
+			if value != "udp" && value != "tcp" {
+				return nil, fmt.Errorf("forwarder pool %s: transport must be udp or tcp", name)
+			}
+			p.transport = value
+		case "timeout":
+			if p.timeout, err = time.ParseDuration(value); err != nil || p.timeout <= 0 {
+				return nil, fmt.Errorf("forwarder pool %s: %q is not a timeout, such as 2s", name, value)
+			}
+		case "maxfails":
+			if p.maxFails, err = strconv.Atoi(value); err != nil || p.maxFails < 0 {
+				return nil, fmt.Errorf("forwarder pool %s: %q is not a number of failures", name, value)
+			}
+		case "downtime":
+			if p.downtime, err = time.ParseDuration(value); err != nil || p.downtime < 0 {
+				return nil, fmt.Errorf("forwarder pool %s: %q is not a duration, such as 30s", name, value)
+			}
+		default:
+			return nil, fmt.Errorf("forwarder pool %s: unknown setting %q; use transport, timeout, maxfails or downtime", name, fields[i])
+		}
+	}
+	return p, nil
+}
+
+func checkDNSForwarderPool(value string) error {
+	_, err := parseDNSForwarderPool("pool", value)
+	return err
+}
+
+// defaultDNSForwarderPool makes the pool of a zone's dnsforwarders, which
+// sends nothing when they are empty or "!"
+func defaultDNSForwarderPool(forwarders []string) *dnsForwarderPool {
+	p := &dnsForwarderPool{
+		name:      defaultForwarderPool,
+		transport: "udp",
+		timeout:   defaultForwarderTimeout,
+		maxFails:  defaultForwarderMaxFails,
+		downtime:  defaultForwarderDowntime,
+	}
+	if len(forwarders) > 0 && strings.TrimSpace(forwarders[0]) != "!" {
+		for _, server := range forwarders {
+			p.servers = append(p.servers, strings.TrimSpace(server))
+		}
+	}
+	return p
+}
+
+// forwarderHealth is the state of each pool's servers, kept across config
+// reloads
+var forwarderHealth = &forwarderHealthSet{servers: make(map[string]*forwarderState)}
+
+type forwarderHealthSet struct {
+	sync.Mutex
+	servers map[string]*forwarderState // by pool and server
+}
+
+type forwarderState struct {
+	fails     int
+	downUntil time.Time
+}
+
+// candidates returns the servers of p to try, in order: those that are up,
+// or all of them when none is
+func (p *dnsForwarderPool) candidates(now time.Time) []string {
+	forwarderHealth.Lock()
+	defer forwarderHealth.Unlock()
+	var up []string
+	for _, server := range p.servers {
+		if state := forwarderHealth.servers[p.name+" "+server]; state == nil || !now.Before(state.downUntil) {
+			up = append(up, server)
+		}
+	}
+	if len(up) == 0 {
+		return p.servers
+	}
+	return up
+}
+
+// report records how an exchange with server went, and marks it down when
+// it has failed maxfails times in a row
+func (p *dnsForwarderPool) report(server string, err error) {
+	forwarderHealth.Lock()
+	defer forwarderHealth.Unlock()
+	key := p.name + " " + server
+	if err == nil {
+		delete(forwarderHealth.servers, key)
+		return
+	}
+	dnsForwarderPoolStats.Add(p.name+" failures", 1)
+	state := forwarderHealth.servers[key]
+	if state == nil {
+		state = &forwarderState{}
+		forwarderHealth.servers[key] = state
+	}
+	state.fails++
+	if p.maxFails > 0 && state.fails >= p.maxFails {
+		state.fails = 0
+		state.downUntil = time.Now().Add(p.downtime)
+		dnsForwarderPoolStats.Add(p.name+" down", 1)
+		logger.Printf("Forwarder %s of pool %s is down for %s: %s\n", server, p.name, p.downtime, err)
+	}
+}
+
+// dnsForwardRule sends some questions to a forwarder pool. Rules are stored
+// as text in the dnsforward config directory, one rule per key, as the pool
+// followed by the conditions of policy rules, and the first rule that
+// matches a question, in key order, decides:
+//
+//	corp-ad name corp.example,_msdcs.corp.example
+//	public-filtered class student-laptop
+//	public-filtered client 10.20.0.0/16
+//	public-unfiltered
+//
+// Questions no rule matches go to the default pool, the zone's
+// dnsforwarders. Rules may also pick the iterate pool, which is not a pool
+// of forwarders: the questions they match are resolved from the root name
+// servers, as if the zone's dnsresolver were iterate. Together with the
+// client and class conditions, this selects the resolver mode per view.
+type dnsForwardRule struct {
+	text       string
+	pool       string
+	conditions dnsPolicyRule
+}
+
+func parseDNSForwardRule(rule string) (*dnsForwardRule, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 || len(fields)%2 != 1 {
+		return nil, fmt.Errorf("malformed forward rule %q", rule)
+	}
+	r := &dnsForwardRule{text: strings.Join(fields, " "), pool: fields[0]}
+	if err := r.conditions.parseConditions(fmt.Sprintf("forward rule %q", rule), fields[1:]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func checkDNSForwardRule(value string) error {
+	_, err := parseDNSForwardRule(value)
+	return err
+}
+
+// dnsForwarding picks the pool each question is forwarded to
+type dnsForwarding struct {
+	pools map[string]*dnsForwarderPool
+	rules []*dnsForwardRule
+}
+
+// newDNSForwarding reads the zone's forwarder pools and forward rules, and
+// fails if a rule names a pool that does not exist
+func newDNSForwarding(cfg *Config) (*dnsForwarding, error) {
+	f := &dnsForwarding{pools: map[string]*dnsForwarderPool{defaultForwarderPool: defaultDNSForwarderPool(cfg.DNSForwarders())}}
+	for name, text := range cfg.DNSForwarderPools() {
+		if name == iteratePool {
+			return nil, fmt.Errorf("forwarder pool %s: the name is reserved for iterative resolution", name)
+		}
+		pool, err := parseDNSForwarderPool(name, text)
+		if err != nil {
+			return nil, err
+		}
+		f.pools[name] = pool
+	}
+	for _, text := range cfg.DNSForwardRules() {
+		rule, err := parseDNSForwardRule(text)
+		if err != nil {
+			return nil, err
+		}
+		if f.pools[rule.pool] == nil && rule.pool != iteratePool {
+			return nil, fmt.Errorf("forward rule %q: no forwarder pool %s", text, rule.pool)
+		}
+		f.rules = append(f.rules, rule)
+	}
+	return f, nil
+}
+
+// iterates reports whether some rule sends questions to the iterate pool
+func (f *dnsForwarding) iterates() bool {
+	for _, rule := range f.rules {
+		if rule.pool == iteratePool {
+			return true
+		}
+	}
+	return false
+}
+
+// pool returns the pool that r's question goes to, and the rule that chose
+// it, if any
+func (f *dnsForwarding) pool(r *DNSRequest) (*dnsForwarderPool, *dnsForwardRule) {
+	if len(f.rules) == 0 {
+		return f.pools[defaultForwarderPool], nil
+	}
+	device := clientDevices.Lookup(r.Client)
+	for _, rule := range f.rules {
+		if rule.conditions.matches(r.Client, device, r.Question) {
+			return f.pools[rule.pool], rule
+		}
+	}
+	return f.pools[defaultForwarderPool], nil
+}
@@ -0,0 +1,37 @@
+package netcore
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSForwardingIteratePool(t *testing.T) {
+	cfg := &Config{
+		dnsForwarders:   []string{"192.0.2.53:53"},
+		dnsForwardRules: []string{"iterate client 10.9.0.0/16"},
+	}
+	forwarding, err := newDNSForwarding(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !forwarding.iterates() {
+		t.Errorf("a rule picks the iterate pool, but iterates() is false")
+	}
+	q := &dns.Question{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	lab := &DNSRequest{Config: cfg, Question: q, Start: time.Now(), Client: net.ParseIP("10.9.1.2")}
+	if _, rule := forwarding.pool(lab); rule == nil || rule.pool != iteratePool {
+		t.Errorf("a client of 10.9.0.0/16 is not sent to the iterate pool")
+	}
+	office := &DNSRequest{Config: cfg, Question: q, Start: time.Now(), Client: net.ParseIP("10.1.1.2")}
+	if pool, rule := forwarding.pool(office); rule != nil || pool.name != defaultForwarderPool {
+		t.Errorf("other clients are not sent to the default pool")
+	}
+
+	cfg.dnsForwarderPools = map[string]string{iteratePool: "192.0.2.54:53"}
+	if _, err := newDNSForwarding(cfg); err == nil {
+		t.Errorf("a forwarder pool may be named %s", iteratePool)
+	}
+}
@@ -0,0 +1,138 @@
+package netcore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dnsForwardOverride changes what forwarders answer for a domain and its
+// subdomains. Overrides are stored in the dnsforwardoverride config
+// directory, one per key named after the domain, as a list of settings:
+//
+//	cdn.example = minttl 60 maxttl 300
+//	broken-v6.example = noaaaa
+//	flappy.example = maxttl 5 nocache
+//
+// minttl and maxttl clamp the TTLs of answers, noaaaa answers AAAA
+// questions with no records, without asking, for domains that publish
+// addresses the network cannot reach, and nocache sends every question to
+// the forwarders instead of the cache. The override of the longest domain
+// applies.
+type dnsForwardOverride struct {
+	domain  string // lowercase and fully qualified
+	minTTL  uint32
+	maxTTL  uint32
+	noAAAA  bool
+	noCache bool
+}
+
+// noAAAATTL is the TTL of the answers that noaaaa makes
+const noAAAATTL = 300
+
+func parseDNSForwardOverride(domain, text string) (*dnsForwardOverride, error) {
+	o := &dnsForwardOverride{domain: dns.Fqdn(strings.ToLower(domain))}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("forward override of %s changes nothing", domain)
+	}
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "noaaaa":
+			o.noAAAA = true
+		case "nocache":
+			o.noCache = true
+		case "minttl", "maxttl":
+			if i+1 == len(fields) {
+				return nil, fmt.Errorf("forward override of %s: %s needs a number of seconds", domain, fields[i])
+			}
+			ttl, err := strconv.ParseUint(fields[i+1], 10, 31)
+			if err != nil {
+				return nil, fmt.Errorf("forward override of %s: %q is not a number of seconds", domain, fields[i+1])
+			}
+			if fields[i] == "minttl" {
+				o.minTTL = uint32(ttl)
+			} else {
+				o.maxTTL = uint32(ttl)
+			}
+			i++
+		default:
+			return nil, fmt.Errorf("forward override of %s: unknown setting %q; use minttl, maxttl, noaaaa or nocache", domain, fields[i])
+		}
+	}
+	if o.maxTTL > 0 && o.minTTL > o.maxTTL {
+		return nil, fmt.Errorf("forward override of %s: minttl is above maxttl", domain)
+	}
+	return o, nil
+}
+
+func checkDNSForwardOverride(value string) error {
+	_, err := parseDNSForwardOverride("example.com", value)
+	return err
+}
+
+// dnsForwardOverrides are the overrides of a zone
+type dnsForwardOverrides []*dnsForwardOverride
+
+// loadDNSForwardOverrides reads the zone's forward overrides
+func loadDNSForwardOverrides(cfg *Config) (dnsForwardOverrides, error) {
+	var overrides dnsForwardOverrides
+	for domain, text := range cfg.DNSForwardOverrides() {
+		o, err := parseDNSForwardOverride(domain, text)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, nil
+}
+
+// find returns the override of the longest domain that name is in, or nil
+func (overrides dnsForwardOverrides) find(name string) *dnsForwardOverride {
+	name = strings.ToLower(name)
+	var found *dnsForwardOverride
+	for _, o := range overrides {
+		if dns.IsSubDomain(o.domain, name) && (found == nil || len(o.domain) > len(found.domain)) {
+			found = o
+		}
+	}
+	return found
+}
+
+// apply clamps the TTLs of answers and strips their AAAA records, as the
+// override says
+func (o *dnsForwardOverride) apply(answers []dns.RR) []dns.RR {
+	kept := answers[:0]
+	for _, answer := range answers {
+		hdr := answer.Header()
+		if o.noAAAA && hdr.Rrtype == dns.TypeAAAA {
+			continue
+		}
+		if hdr.Ttl < o.minTTL {
+			hdr.Ttl = o.minTTL
+		}
+		if o.maxTTL > 0 && hdr.Ttl > o.maxTTL {
+			hdr.Ttl = o.maxTTL
+		}
+		kept = append(kept, answer)
+	}
+	return kept
+}
+
+// noData answers a question with no records: an SOA of the override's
+// domain, which makes the reply NOERROR rather than NXDOMAIN, so that
+// clients still ask for the name's other types
+func (o *dnsForwardOverride) noData() []dns.RR {
+	return []dns.RR{&dns.SOA{
+		Hdr:     dns.RR_Header{Name: o.domain, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: noAAAATTL},
+		Ns:      o.domain,
+		Mbox:    "hostmaster." + o.domain,
+		Serial:  1,
+		Refresh: noAAAATTL,
+		Retry:   noAAAATTL,
+		Expire:  noAAAATTL,
+		Minttl:  noAAAATTL,
+	}}
+}
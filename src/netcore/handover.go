@@ -0,0 +1,273 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// On SIGUSR2, netcore starts a new copy of its binary with the same flags,
+// handing it the sockets it listens on. Both processes read from the same
+// sockets until the new one is ready, then the old one stops reading and
+// exits once its requests are done, so no query is dropped during the
+// upgrade.
+//
+// The sockets are passed as extra files, named in $NETCORE_LISTENFDS as
+// name=fd pairs, and the new process reports that it is ready by writing to
+// the file descriptor in $NETCORE_READYFD.
+
+// handoverTimeout is how long the new process may take to become ready
+// before the handover is abandoned
+const handoverTimeout = time.Minute
+
+// handoverUnusedWait is how long a new process waits for its services to
+// take over the sockets it inherited before it reports that it is ready
+const handoverUnusedWait = 10 * time.Second
+
+// handoverListeners are the sockets this process listens on, by name
+type handoverListeners struct {
+	sync.Mutex
+	inherited map[string]*os.File
+	sockets   map[string]handoverSocket
+	closing   int32 // set once the sockets are handed over, atomically
+}
+
+// handoverSocket is a socket that can be handed to another process
+type handoverSocket interface {
+	File() (*os.File, error)
+	Close() error
+}
+
+var listeners = &handoverListeners{sockets: make(map[string]handoverSocket)}
+
+// inheritedFile returns the socket of that name handed over by the previous
+// process, if any
+func (h *handoverListeners) inheritedFile(name string) *os.File {
+	h.Lock()
+	defer h.Unlock()
+	h.loadInherited()
+	f := h.inherited[name]
+	delete(h.inherited, name)
+	return f
+}
+
+// pending returns how many inherited sockets have not been taken over yet
+func (h *handoverListeners) pending() int {
+	h.Lock()
+	defer h.Unlock()
+	h.loadInherited()
+	return len(h.inherited)
+}
+
+// loadInherited finds the sockets named in the environment; the caller must
+// hold the lock
+func (h *handoverListeners) loadInherited() {
+	if h.inherited != nil {
+		return
+	}
+	h.inherited = make(map[string]*os.File)
+	for _, pair := range strings.Split(os.Getenv("NETCORE_LISTENFDS"), ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if fd, err := strconv.Atoi(parts[1]); err == nil {
+			h.inherited[parts[0]] = os.NewFile(uintptr(fd), parts[0])
+		}
+	}
+}
+
+func (h *handoverListeners) add(name string, s handoverSocket) {
+	h.Lock()
+	defer h.Unlock()
+	h.sockets[name] = s
+}
+
+// ListenPacket listens like net.ListenPacket, or takes over the socket of
+// that name from the previous process
+func (h *handoverListeners) ListenPacket(name, network, address string) (net.PacketConn, error) {
+	var conn net.PacketConn
+	var err error
+	if f := h.inheritedFile(name); f != nil {
+		conn, err = net.FilePacketConn(f)
+		f.Close()
+		if err == nil {
+			logger.Printf("Took over the %s socket\n", name)
+		}
+	} else {
+		conn, err = net.ListenPacket(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, ok := conn.(handoverSocket)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("%s: cannot hand over a %T", name, conn)
+	}
+	h.add(name, s)
+	return conn, nil
+}
+
+// Listen listens like net.Listen, or takes over the socket of that name from
+// the previous process
+func (h *handoverListeners) Listen(name, network, address string) (net.Listener, error) {
+	var l net.Listener
+	var err error
+	if f := h.inheritedFile(name); f != nil {
+		l, err = net.FileListener(f)
+		f.Close()
+		if err == nil {
+			logger.Printf("Took over the %s socket\n", name)
+		}
+	} else {
+		l, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s, ok := l.(handoverSocket)
+	if !ok {
+		l.Close()
+		return nil, fmt.Errorf("%s: cannot hand over a %T", name, l)
+	}
+	h.add(name, s)
+	return l, nil
+}
+
+// release closes the socket of that name, which is not to be handed over
+// anymore
+func (h *handoverListeners) release(name string) {
+	h.Lock()
+	defer h.Unlock()
+	if s, ok := h.sockets[name]; ok {
+		s.Close()
+		delete(h.sockets, name)
+	}
+}
+
+// HandingOver reports whether this process has handed its sockets over and
+// is on its way out, so that servers stopping is expected
+func (h *handoverListeners) HandingOver() bool {
+	return atomic.LoadInt32(&h.closing) != 0
+}
+
+// handover starts the new process and, once it is ready, stops serving
+func (h *handoverListeners) handover() error {
+	h.Lock()
+	var names []string
+	var files []*os.File
+	for name, s := range h.sockets {
+		f, err := s.File()
+		if err != nil {
+			h.Unlock()
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		defer f.Close()
+		names = append(names, fmt.Sprintf("%s=%d", name, 3+len(files)))
+		files = append(files, f)
+	}
+	h.Unlock()
+
+	ready, readyWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	binary, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(binary, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(files, readyWrite)
+	cmd.Env = append(os.Environ(),
+		"NETCORE_LISTENFDS="+strings.Join(names, ","),
+		"NETCORE_READYFD="+strconv.Itoa(3+len(files)))
+	err = cmd.Start()
+	readyWrite.Close()
+	if err != nil {
+		return err
+	}
+	logger.Printf("Started process %d to take over\n", cmd.Process.Pid)
+
+	done := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := ready.Read(b)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(handoverTimeout):
+		err = errors.New("timed out")
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("process %d did not become ready: %s", cmd.Process.Pid, err)
+	}
+	go cmd.Wait() // the new process outlives us, but must not become a zombie meanwhile
+
+	logger.Printf("Process %d is ready; handing over\n", cmd.Process.Pid)
+	atomic.StoreInt32(&h.closing, 1)
+	h.Lock()
+	for _, s := range h.sockets {
+		s.Close()
+	}
+	h.Unlock()
+	return nil
+}
+
+// handoverReady tells the process that handed its sockets over that we are
+// serving, if there is one, once we have taken over its sockets. Sockets
+// that the new configuration no longer uses are not waited for long.
+func handoverReady() {
+	fd, err := strconv.Atoi(os.Getenv("NETCORE_READYFD"))
+	if err != nil {
+		return
+	}
+	for deadline := time.Now().Add(handoverUnusedWait); listeners.pending() > 0 && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+	}
+	os.Unsetenv("NETCORE_LISTENFDS")
+	os.Unsetenv("NETCORE_READYFD")
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
+
+// handoverSetup makes SIGUSR2 hand this process over to a new copy of its
+// binary, and reports readiness to the previous process
+func handoverSetup() {
+	go handoverReady()
+	if len(handoverSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, handoverSignals...)
+	go func() {
+		for range signals {
+			if err := listeners.handover(); err != nil {
+				logger.Printf("Handover failed: %s\n", err)
+				continue
+			}
+			finishHandover()
+		}
+	}()
+}
+
+// finishHandover waits for the requests in flight, then stops; the new
+// process is serving, so routes stay announced
+func finishHandover() {
+	waitInFlight(time.Now().Add(drainWait))
+	logger.Printf("Handed over; exiting\n")
+	requestStop(nil)
+}
@@ -0,0 +1,79 @@
+package netcore
+
+import "sync"
+
+// HealthState describes how well a component of netcore is working
+type HealthState int
+
+const (
+	// Healthy means the component is working normally
+	Healthy HealthState = iota
+	// Degraded means the component is still serving, but in a reduced way
+	Degraded
+	// Unhealthy means the component is not serving
+	Unhealthy
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case Healthy:
+		return "ok"
+	case Degraded:
+		return "degraded"
+	default:
+		return "unhealthy"
+	}
+}
+
+// HealthRegistry tracks the health of each component of netcore
+type HealthRegistry struct {
+	sync.Mutex
+	components map[string]componentHealth
+}
+
+type componentHealth struct {
+	State  HealthState
+	Reason string
+}
+
+var health = &HealthRegistry{components: make(map[string]componentHealth)}
+
+// Set records the health of the named component
+func (h *HealthRegistry) Set(component string, state HealthState, reason string) {
+	h.Lock()
+	defer h.Unlock()
+	h.components[component] = componentHealth{State: state, Reason: reason}
+}
+
+// State returns the worst state of any component along with the reasons given
+// for every component that is not healthy, keyed by component name
+func (h *HealthRegistry) State() (HealthState, map[string]string) {
+	h.Lock()
+	defer h.Unlock()
+	state := Healthy
+	reasons := make(map[string]string)
+	for name, c := range h.components {
+		if c.State > state {
+			state = c.State
+		}
+		if c.State != Healthy {
+			reasons[name] = c.State.String() + ": " + c.Reason
+		}
+	}
+	return state, reasons
+}
+
+// Components returns the state of every component, keyed by component name,
+// with the reason for those that are not healthy
+func (h *HealthRegistry) Components() map[string]string {
+	h.Lock()
+	defer h.Unlock()
+	components := make(map[string]string)
+	for name, c := range h.components {
+		components[name] = c.State.String()
+		if c.State != Healthy {
+			components[name] += ": " + c.Reason
+		}
+	}
+	return components
+}
@@ -0,0 +1,132 @@
+package netcore
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IdentityDB keeps the identities of the hosts that have run netcore, so
+// that a host is recognised after a reinstall
+type IdentityDB interface {
+	GetIdentities() ([]HostIdentity, error)
+	SaveIdentity(identity HostIdentity) error
+}
+
+// HostIdentity is what tells a host apart from the others. The ID is kept
+// for as long as the host can be recognised by its machine ID, one of its
+// MAC addresses or its hostname, in that order.
+type HostIdentity struct {
+	ID        string    `json:"id"`
+	Hostname  string    `json:"hostname"`
+	MachineID string    `json:"machineid,omitempty"`
+	MACs      []string  `json:"macs"`
+	Created   time.Time `json:"created"`
+	Seen      time.Time `json:"seen"`
+}
+
+// localIdentity describes this host, without an ID yet
+func localIdentity(hostname string) HostIdentity {
+	return HostIdentity{Hostname: hostname, MachineID: machineID(), MACs: interfaceMACs()}
+}
+
+// machineID reads the ID that the operating system gives this installation,
+// where there is one
+func machineID() string {
+	for _, path := range machineIDPaths {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(data)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// interfaceMACs lists the MAC addresses of the non-loopback interfaces, in
+// order. Locally administered MACs are left out: bridges, containers (such
+// as docker's 02:42:...) and VMs make them up, and any host may have the
+// same ones, so they cannot tell hosts apart.
+func interfaceMACs() []string {
+	macs := []string{}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return macs
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 || locallyAdministered(iface.HardwareAddr) {
+			continue
+		}
+		if mac := iface.HardwareAddr.String(); !containsString(macs, mac) {
+			macs = append(macs, mac)
+		}
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+// locallyAdministered reports whether mac was made up rather than assigned
+// by the maker of the interface
+func locallyAdministered(mac net.HardwareAddr) bool {
+	return len(mac) > 0 && mac[0]&0x02 != 0
+}
+
+// matchIdentity finds the known identity that local is, if any
+func matchIdentity(known []HostIdentity, local HostIdentity) (HostIdentity, bool) {
+	if local.MachineID != "" {
+		for _, identity := range known {
+			if identity.MachineID == local.MachineID {
+				return identity, true
+			}
+		}
+	}
+	for _, identity := range known {
+		for _, mac := range local.MACs {
+			if hw, err := net.ParseMAC(mac); err == nil && !locallyAdministered(hw) && containsString(identity.MACs, mac) {
+				return identity, true
+			}
+		}
+	}
+	for _, identity := range known {
+		if identity.Hostname == local.Hostname {
+			return identity, true
+		}
+	}
+	return HostIdentity{}, false
+}
+
+// loadIdentity recognises this host among the identities in db, or gives it
+// a new one, and records how it looks now. If db cannot be read or written,
+// the identity is only good until netcore restarts.
+func loadIdentity(db IdentityDB, hostname string) HostIdentity {
+	local := localIdentity(hostname)
+	known, err := db.GetIdentities()
+	if err != nil {
+		logger.Printf("Host identities unavailable: %s\n", err)
+	}
+	identity, ok := matchIdentity(known, local)
+	if !ok {
+		identity = HostIdentity{ID: newUUID(), Created: time.Now()}
+		logger.Printf("New host identity %s\n", identity.ID)
+	}
+	identity.Hostname, identity.MachineID, identity.MACs, identity.Seen = local.Hostname, local.MachineID, local.MACs, time.Now()
+	if err := db.SaveIdentity(identity); err != nil {
+		logger.Printf("Saving host identity %s failed: %s\n", identity.ID, err)
+	}
+	return identity
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // the system is in no state to run netcore
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,26 @@
+package netcore
+
+import "testing"
+
+func TestMatchIdentity(t *testing.T) {
+	known := []HostIdentity{
+		{ID: "a", Hostname: "ns1", MACs: []string{"02:42:ac:11:00:01", "00:1b:21:00:00:01"}},
+		{ID: "b", Hostname: "ns2", MACs: []string{"02:42:ac:11:00:02", "00:1b:21:00:00:02"}},
+	}
+	tests := []struct {
+		local HostIdentity
+		id    string
+	}{
+		{HostIdentity{Hostname: "reinstalled", MACs: []string{"00:1b:21:00:00:02"}}, "b"},
+		{HostIdentity{Hostname: "ns2"}, "b"},
+		// docker's bridge MAC is on every host with docker
+		{HostIdentity{Hostname: "new", MACs: []string{"02:42:ac:11:00:01"}}, ""},
+		{HostIdentity{Hostname: "ns2", MACs: []string{"02:42:ac:11:00:01"}}, "b"},
+	}
+	for _, test := range tests {
+		identity, ok := matchIdentity(known, test.local)
+		if ok != (test.id != "") || identity.ID != test.id {
+			t.Errorf("matchIdentity(%+v) = %q, %v, want %q", test.local, identity.ID, ok, test.id)
+		}
+	}
+}
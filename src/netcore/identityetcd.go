@@ -0,0 +1,34 @@
+package netcore
+
+import "encoding/json"
+
+// Each identity is kept under /identities/<id>.
+
+func (db EtcdDB) GetIdentities() ([]HostIdentity, error) {
+	response, err := db.client.Get("identities", true, false)
+	if etcdKeyNotFound(err) {
+		return []HostIdentity{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	identities := []HostIdentity{}
+	for _, node := range response.Node.Nodes {
+		var identity HostIdentity
+		if err := json.Unmarshal([]byte(node.Value), &identity); err != nil {
+			logger.Printf("[IDENTITY] Skipping unreadable entry %s: %s\n", node.Key, err)
+			continue
+		}
+		identities = append(identities, identity)
+	}
+	return identities, nil
+}
+
+func (db EtcdDB) SaveIdentity(identity HostIdentity) error {
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+	_, err = db.client.Set("identities/"+identity.ID, string(data), 0)
+	return err
+}
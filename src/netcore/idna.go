@@ -0,0 +1,339 @@
+package netcore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Internationalized names are stored and served in their ASCII form, with
+// each label that is not ASCII encoded as "xn--" and its Punycode (RFC
+// 3492), so that münchen.example and xn--mnchen-3ya.example are the same
+// name. Names are mapped to lower case, and their labels must be made of
+// letters, digits, combining marks and hyphens, as IDNA2008 has it. They are
+// not normalized to NFC, as the Unicode tables that would take are not
+// vendored: names typed or pasted are almost always in NFC already.
+
+const (
+	idnaPrefix      = "xn--"
+	punycodeBase    = 36
+	punycodeTMin    = 1
+	punycodeTMax    = 26
+	punycodeSkew    = 38
+	punycodeDamp    = 700
+	punycodeBias    = 72
+	punycodeInitial = 128
+	punycodeMax     = 1 << 30 // far beyond any label, to catch overflows
+)
+
+// idnaToASCII returns name with each of its labels that is not ASCII in its
+// ASCII form. ASCII labels, and a trailing dot, are left as they are.
+func idnaToASCII(name string) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		ascii, err := idnaLabelToASCII(label)
+		if err != nil {
+			return "", fmt.Errorf("invalid internationalized name %q: %s", name, err)
+		}
+		labels[i] = ascii
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// idnaLabelToASCII maps a label to lower case, checks that IDNA2008 allows
+// it, and encodes it
+func idnaLabelToASCII(label string) (string, error) {
+	if !utf8.ValidString(label) {
+		return "", fmt.Errorf("label is not UTF-8")
+	}
+	runes := []rune(strings.ToLower(label))
+	if unicode.IsMark(runes[0]) {
+		return "", fmt.Errorf("label %q starts with a combining mark", label)
+	}
+	if runes[0] == '-' || runes[len(runes)-1] == '-' {
+		return "", fmt.Errorf("label %q starts or ends with a hyphen", label)
+	}
+	if len(runes) >= 4 && runes[2] == '-' && runes[3] == '-' {
+		return "", fmt.Errorf("label %q has hyphens in its third and fourth positions", label)
+	}
+	for _, r := range runes {
+		if r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) {
+			return "", fmt.Errorf("label %q has %q, which is not a letter, digit or hyphen", label, r)
+		}
+	}
+	encoded, err := punycodeEncode(runes)
+	if err != nil {
+		return "", err
+	}
+	ascii := idnaPrefix + encoded
+	if len(ascii) > 63 {
+		return "", fmt.Errorf("label %q is longer than 63 bytes once encoded", label)
+	}
+	return ascii, nil
+}
+
+// idnaToUnicode returns name with its encoded labels decoded, for display.
+// Labels that do not decode to what they would be encoded from are left as
+// they are.
+func idnaToUnicode(name string) string {
+	if !strings.Contains(strings.ToLower(name), idnaPrefix) {
+		return name
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if len(label) <= len(idnaPrefix) || !strings.EqualFold(label[:len(idnaPrefix)], idnaPrefix) {
+			continue
+		}
+		runes, err := punycodeDecode(strings.ToLower(label[len(idnaPrefix):]))
+		if err != nil {
+			continue
+		}
+		if ascii, err := idnaLabelToASCII(string(runes)); err != nil || ascii != strings.ToLower(label) {
+			continue
+		}
+		labels[i] = string(runes)
+	}
+	return strings.Join(labels, ".")
+}
+
+// dnsQuestionName returns the ASCII form of a name queried in UTF-8, which
+// miekg/dns hands over with its bytes escaped as \DDD; other names, and
+// those that are not valid, are returned as they are
+func dnsQuestionName(name string) string {
+	if !strings.Contains(name, `\`) {
+		return name
+	}
+	var unescaped []byte
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) {
+			if b, err := strconv.ParseUint(name[i+1:i+4], 10, 8); err == nil && b >= 0x80 {
+				unescaped = append(unescaped, byte(b))
+				i += 3
+				continue
+			}
+		}
+		unescaped = append(unescaped, name[i])
+	}
+	if isASCII(string(unescaped)) || !utf8.Valid(unescaped) {
+		return name
+	}
+	ascii, err := idnaToASCII(string(unescaped))
+	if err != nil {
+		return name
+	}
+	return ascii
+}
+
+// mapTargets replaces the domain names in the value of r, such as the
+// target of a CNAME or SRV record, with what f returns for them
+func (r *DNSRecord) mapTargets(f func(string) string) {
+	switch strings.ToUpper(r.Type) {
+	case "NS", "CNAME", "DNAME", "PTR", "ALIAS":
+		r.Value = f(r.Value)
+	case "MX", "SRV":
+		if target, ok := r.Attr["target"]; ok {
+			attr := make(map[string]string, len(r.Attr))
+			for k, v := range r.Attr {
+				attr[k] = v
+			}
+			attr["target"] = f(target)
+			r.Attr = attr
+		} else if r.Value != "" {
+			parts := strings.SplitN(r.Value, ":", 2) // SRV values may be target:port
+			parts[0] = f(parts[0])
+			r.Value = strings.Join(parts, ":")
+		}
+	}
+}
+
+// asciiTarget returns the ASCII form of a domain name in a record value, or
+// the name itself if it has none, for validation to refuse
+func asciiTarget(name string) string {
+	if ascii, err := idnaToASCII(name); err == nil {
+		return ascii
+	}
+	return name
+}
+
+// asciiSOAMeta puts the names among the SOA settings of a zone in their
+// ASCII form
+func asciiSOAMeta(meta map[string]string) {
+	for _, key := range []string{"ns", "mbox"} {
+		if name, ok := meta[key]; ok {
+			meta[key] = asciiTarget(name)
+		}
+	}
+}
+
+// unicodeRecord returns r with its name and targets in their Unicode form
+func unicodeRecord(r DNSRecord) DNSRecord {
+	r.Name = idnaToUnicode(r.Name)
+	r.mapTargets(idnaToUnicode)
+	return r
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// punycodeEncode encodes a label as RFC 3492 has it, without the prefix
+func punycodeEncode(input []rune) (string, error) {
+	var output []byte
+	for _, r := range input {
+		if r < punycodeInitial {
+			output = append(output, byte(r))
+		}
+	}
+	basic := len(output)
+	if basic > 0 {
+		output = append(output, '-')
+	}
+	n, delta, bias := rune(punycodeInitial), 0, punycodeBias
+	for handled := basic; handled < len(input); {
+		m := rune(unicode.MaxRune + 1)
+		for _, r := range input {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (handled + 1)
+		if delta > punycodeMax {
+			return "", fmt.Errorf("label is too long to encode")
+		}
+		n = m
+		for _, r := range input {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := punycodeThreshold(k, bias)
+				if q < t {
+					break
+				}
+				output = append(output, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			output = append(output, punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(output), nil
+}
+
+// punycodeDecode decodes a label encoded as RFC 3492 has it, without the
+// prefix
+func punycodeDecode(input string) ([]rune, error) {
+	var output []rune
+	pos := 0
+	if basic := strings.LastIndex(input, "-"); basic > 0 {
+		for _, r := range input[:basic] {
+			if r >= punycodeInitial {
+				return nil, fmt.Errorf("%q is not ASCII", r)
+			}
+			output = append(output, r)
+		}
+		pos = basic + 1
+	}
+	n, i, bias := punycodeInitial, 0, punycodeBias
+	for pos < len(input) {
+		oldi, w := i, 1
+		for k := punycodeBase; ; k += punycodeBase {
+			if pos == len(input) {
+				return nil, fmt.Errorf("truncated label")
+			}
+			digit := punycodeDigitValue(input[pos])
+			pos++
+			if digit < 0 {
+				return nil, fmt.Errorf("%q is not a Punycode digit", input[pos-1])
+			}
+			i += digit * w
+			if i > punycodeMax {
+				return nil, fmt.Errorf("label is too long to decode")
+			}
+			t := punycodeThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			w *= punycodeBase - t
+			if w > punycodeMax {
+				return nil, fmt.Errorf("label is too long to decode")
+			}
+		}
+		bias = punycodeAdapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > unicode.MaxRune {
+			return nil, fmt.Errorf("label decodes beyond Unicode")
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return output, nil
+}
+
+func punycodeThreshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return punycodeTMin
+	case k >= bias+punycodeTMax:
+		return punycodeTMax
+	}
+	return k - bias
+}
+
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (punycodeBase-punycodeTMin)*punycodeTMax/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punycodeDigitValue(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A')
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26
+	}
+	return -1
+}
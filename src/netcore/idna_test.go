@@ -0,0 +1,37 @@
+package netcore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIDNA(t *testing.T) {
+	tests := []struct {
+		unicode string
+		ascii   string
+	}{
+		{"www.example.com", "www.example.com"},
+		{"münchen.example", "xn--mnchen-3ya.example"},
+		{"MÜNCHEN.example.", "xn--mnchen-3ya.example."},
+		{"bücher.de", "xn--bcher-kva.de"},
+		{"日本語.jp", "xn--wgv71a119e.jp"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+	}
+	for _, test := range tests {
+		ascii, err := idnaToASCII(test.unicode)
+		if err != nil || ascii != test.ascii {
+			t.Errorf("idnaToASCII(%q) = %q, %v, want %q", test.unicode, ascii, err, test.ascii)
+		}
+		if unicode := idnaToUnicode(test.ascii); unicode != strings.ToLower(test.unicode) {
+			t.Errorf("idnaToUnicode(%q) = %q, want %q", test.ascii, unicode, strings.ToLower(test.unicode))
+		}
+	}
+	for _, invalid := range []string{"-münchen.example", "mün chen.example", "\u0301ab.example"} {
+		if ascii, err := idnaToASCII(invalid); err == nil {
+			t.Errorf("idnaToASCII(%q) = %q, want an error", invalid, ascii)
+		}
+	}
+	if name := dnsQuestionName(`m\195\188nchen.example.`); name != "xn--mnchen-3ya.example." {
+		t.Errorf("dnsQuestionName of UTF-8 = %q, want xn--mnchen-3ya.example.", name)
+	}
+}
@@ -0,0 +1,155 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var incidentLogSize = Flags.Int("incidentlogsize", 10000, "How many client and name pairs the sinkhole incident log keeps; those seen least recently are forgotten first.")
+
+// Incident is a client asking for a name that a sinkhole policy rule
+// catches, which usually means malware on the client. Repeated questions
+// add to the same incident.
+type Incident struct {
+	Client string    `json:"client"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"` // of the latest question
+	Rule   string    `json:"rule"` // the rule that caught it
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Count  int       `json:"count"`
+}
+
+type byIncidentLast []Incident
+
+func (s byIncidentLast) Len() int           { return len(s) }
+func (s byIncidentLast) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byIncidentLast) Less(i, j int) bool { return s[i].Last.After(s[j].Last) }
+
+// incidentLog keeps the incidents of this instance in memory, keyed by
+// client and name
+type incidentLog struct {
+	sync.Mutex
+	incidents map[string]*Incident
+}
+
+var incidents = &incidentLog{incidents: make(map[string]*Incident)}
+
+// Record notes that client asked q, which rule sinkholed. The first time a
+// client asks for a name, a dns.sinkhole event is published.
+func (l *incidentLog) Record(client net.IP, q *dns.Question, rule string, now time.Time) {
+	ip := ""
+	if client != nil {
+		ip = client.String()
+	}
+	name := dns.Fqdn(q.Name)
+	key := ip + " " + name
+	l.Lock()
+	incident, seen := l.incidents[key]
+	if !seen {
+		if *incidentLogSize <= 0 {
+			l.Unlock()
+			return
+		}
+		for len(l.incidents) >= *incidentLogSize {
+			l.forgetOldest()
+		}
+		incident = &Incident{Client: ip, Name: name, First: now.UTC()}
+		l.incidents[key] = incident
+	}
+	incident.Type = dns.Type(q.Qtype).String()
+	incident.Rule = rule
+	incident.Last = now.UTC()
+	incident.Count++
+	l.Unlock()
+
+	if !seen {
+		events.Publish(Event{
+			Type:     "dns.sinkhole",
+			Severity: "warning",
+			Message:  fmt.Sprintf("%s asked for sinkholed name %s", ip, name),
+			Data:     map[string]string{"client": ip, "name": name, "rule": rule},
+		})
+	}
+}
+
+// forgetOldest removes the incident seen least recently; the caller must
+// hold the lock
+func (l *incidentLog) forgetOldest() {
+	var oldest string
+	for key, incident := range l.incidents {
+		if oldest == "" || incident.Last.Before(l.incidents[oldest].Last) {
+			oldest = key
+		}
+	}
+	delete(l.incidents, oldest)
+}
+
+// List returns the incidents of client, or of every client if it is empty,
+// last seen at or after since, most recent first
+func (l *incidentLog) List(client string, since time.Time) []Incident {
+	l.Lock()
+	defer l.Unlock()
+	list := []Incident{}
+	for _, incident := range l.incidents {
+		if (client == "" || incident.Client == client) && !incident.Last.Before(since) {
+			list = append(list, *incident)
+		}
+	}
+	sort.Sort(byIncidentLast(list))
+	return list
+}
+
+// Clear forgets the incidents of client, or all of them if it is empty, once
+// they have been dealt with, and returns how many were forgotten
+func (l *incidentLog) Clear(client string) int {
+	l.Lock()
+	defer l.Unlock()
+	cleared := 0
+	for key, incident := range l.incidents {
+		if client == "" || incident.Client == client {
+			delete(l.incidents, key)
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// apiIncidents returns the sinkhole incidents of this instance, or clears
+// them once the clients are cleaned up. It requires the admin token.
+//
+//	GET    /api/dns/incidents?client=<ip>&since=<RFC 3339 time>
+//	DELETE /api/dns/incidents?client=<ip>
+func apiIncidents(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may see incidents"))
+		return
+	}
+	client := r.URL.Query().Get("client")
+	switch r.Method {
+	case "GET":
+		var since time.Time
+		if value := r.URL.Query().Get("since"); value != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, value); err != nil {
+				apiWriteError(w, http.StatusBadRequest, errors.New("since must be an RFC 3339 time"))
+				return
+			}
+		}
+		apiWriteJSON(w, http.StatusOK, incidents.List(client, since))
+
+	case "DELETE":
+		apiWriteJSON(w, http.StatusOK, map[string]int{"cleared": incidents.Clear(client)})
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		apiWriteError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
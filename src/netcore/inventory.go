@@ -0,0 +1,180 @@
+package netcore
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// InventoryHost is a host seen on the network, through a static DNS record,
+// a reservation or an active lease
+type InventoryHost struct {
+	Name        string            `json:"name"` // the host's DNS name, or its address if it has none
+	IP          string            `json:"ip"`
+	MAC         string            `json:"mac,omitempty"`
+	Zone        string            `json:"zone,omitempty"`
+	Subnet      string            `json:"subnet,omitempty"`
+	Class       string            `json:"class,omitempty"`        // device class, from the vendor class
+	VendorClass string            `json:"vendor_class,omitempty"` // as the client sent it
+	Source      string            `json:"source"`                 // dns, reservation or lease
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+type byInventoryName []InventoryHost
+
+func (h byInventoryName) Len() int           { return len(h) }
+func (h byInventoryName) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h byInventoryName) Less(i, j int) bool { return h[i].Name < h[j].Name }
+
+// deviceClasses map prefixes of DHCP vendor classes (option 60) to the
+// device classes that inventories group hosts by; the first match wins
+var deviceClasses = []struct{ prefix, class string }{
+	{"MSFT", "windows"},
+	{"android-dhcp", "android"},
+	{"dhcpcd", "linux"},
+	{"udhcp", "embedded"},
+	{"PXEClient", "pxe"},
+	{"HTTPClient", "pxe"},
+	{"Cisco AP", "access-point"},
+	{"Aruba AP", "access-point"},
+	{"ubnt", "access-point"},
+	{"Cisco Systems, Inc. IP Phone", "phone"},
+	{"Polycom", "phone"},
+	{"Mitel", "phone"},
+	{"yealink", "phone"},
+	{"Hewlett-Packard JetDirect", "printer"},
+}
+
+// deviceClass returns the class of a device from its vendor class, or the
+// empty string if the vendor class is unknown
+func deviceClass(vendorClass string) string {
+	for _, c := range deviceClasses {
+		if strings.HasPrefix(strings.ToLower(vendorClass), strings.ToLower(c.prefix)) {
+			return c.class
+		}
+	}
+	return ""
+}
+
+// Inventory lists the hosts on the network: those with a static A record,
+// and the clients holding a reservation or an active lease. A client is
+// named by its reservation's host name, then by the PTR record of its
+// address, then by an A record pointing at it.
+func Inventory(db DB) ([]InventoryHost, error) {
+	subnets, err := db.ListSubnets()
+	if err != nil {
+		return nil, err
+	}
+	records, err := db.ListDNSZone("")
+	if err != nil {
+		return nil, err
+	}
+	leases, err := db.ListLeases()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string) // by address, from A records
+	var hosts []InventoryHost
+	byIP := make(map[string]bool)
+	for _, record := range records {
+		if record.Type != "A" {
+			continue
+		}
+		if _, seen := names[record.Value]; !seen {
+			names[record.Value] = record.Name
+		}
+	}
+	for _, lease := range leases {
+		host := InventoryHost{IP: lease.IP, MAC: lease.MAC, VendorClass: lease.VendorClass, Labels: lease.Labels, Source: "lease"}
+		if lease.Expires == nil {
+			host.Source = "reservation"
+		}
+		host.Name = lease.Hostname
+		if host.Name == "" {
+			if entry, err := db.GetDNS(arpaNameFromIP(net.ParseIP(lease.IP)), "PTR"); err == nil && len(entry.Values) > 0 {
+				host.Name = strings.TrimSuffix(entry.Values[0].Value, ".")
+			}
+		}
+		if host.Name == "" {
+			host.Name = names[lease.IP]
+		}
+		hosts = append(hosts, host)
+		byIP[lease.IP] = true
+	}
+	for _, record := range records {
+		if record.Type == "A" && !byIP[record.Value] {
+			hosts = append(hosts, InventoryHost{Name: record.Name, IP: record.Value, Labels: record.Labels, Source: "dns"})
+			byIP[record.Value] = true
+		}
+	}
+
+	for i := range hosts {
+		host := &hosts[i]
+		if host.Name == "" {
+			host.Name = host.IP
+		}
+		host.Class = deviceClass(host.VendorClass)
+		ip := net.ParseIP(host.IP)
+		for _, subnet := range subnets {
+			if _, network, err := net.ParseCIDR(subnet.Subnet); err == nil && ip != nil && network.Contains(ip) {
+				host.Zone, host.Subnet = subnet.Zone, subnet.Subnet
+				break
+			}
+		}
+	}
+	sort.Sort(byInventoryName(hosts))
+	return hosts, nil
+}
+
+var ansibleGroupUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ansibleGroup makes a group name that Ansible accepts, such as
+// subnet_10_0_0_0_24 for subnet and 10.0.0.0/24
+func ansibleGroup(kind, name string) string {
+	return kind + "_" + ansibleGroupUnsafe.ReplaceAllString(name, "_")
+}
+
+// AnsibleInventory is hosts in the JSON that Ansible expects from a dynamic
+// inventory script given --list: hosts grouped by zone_<zone>,
+// subnet_<subnet> and class_<class>, with what netcore knows of each host
+// in its variables
+func AnsibleInventory(hosts []InventoryHost) map[string]interface{} {
+	groups := make(map[string][]string)
+	hostvars := make(map[string]map[string]interface{})
+	for _, host := range hosts {
+		vars := map[string]interface{}{"ansible_host": host.IP, "netcore_source": host.Source}
+		for key, value := range map[string]string{"mac": host.MAC, "zone": host.Zone, "subnet": host.Subnet, "class": host.Class, "vendor_class": host.VendorClass} {
+			if value != "" {
+				vars["netcore_"+key] = value
+			}
+		}
+		if len(host.Labels) > 0 {
+			vars["netcore_labels"] = host.Labels
+		}
+		hostvars[host.Name] = vars
+
+		grouped := false
+		for kind, name := range map[string]string{"zone": host.Zone, "subnet": host.Subnet, "class": host.Class} {
+			if name != "" {
+				group := ansibleGroup(kind, name)
+				groups[group] = append(groups[group], host.Name)
+				grouped = true
+			}
+		}
+		if !grouped {
+			groups["ungrouped"] = append(groups["ungrouped"], host.Name)
+		}
+	}
+
+	inventory := map[string]interface{}{"_meta": map[string]interface{}{"hostvars": hostvars}}
+	children := []string{}
+	for group, members := range groups {
+		inventory[group] = map[string]interface{}{"hosts": members}
+		children = append(children, group)
+	}
+	sort.Strings(children)
+	inventory["all"] = map[string]interface{}{"children": children}
+	return inventory
+}
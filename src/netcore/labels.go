@@ -0,0 +1,120 @@
+package netcore
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Labels are free-form key/value pairs that operators put on DNS entries and
+// DHCP reservations, such as env=prod or team=net, to find them again with a
+// LabelSelector. netcore gives them no meaning of its own.
+
+var labelKeyRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]{0,61}[a-z0-9])?$`)
+var labelValueRegexp = regexp.MustCompile(`^[A-Za-z0-9._:/@+-]{0,63}$`)
+
+// validateLabels checks the keys and values of labels. An empty value is
+// allowed, as setting a label to the empty string removes it.
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if !labelKeyRegexp.MatchString(k) {
+			return invalid("labels", "invalid label key %q: up to 63 lowercase letters, digits and . _ / -", k)
+		}
+		if !labelValueRegexp.MatchString(v) {
+			return invalid("labels", "invalid value %q for label %s: up to 63 letters, digits and . _ : / @ + -", v, k)
+		}
+	}
+	return nil
+}
+
+// labelRequirement is one comma-separated term of a selector
+type labelRequirement struct {
+	key, value string
+	op         string // "=", "!=", "exists" or "!exists"
+}
+
+// LabelSelector picks the objects whose labels match all of its terms:
+//
+//	env=prod    env is prod ("env==prod" works too)
+//	env!=prod   env is not prod, or is not set
+//	env         env is set
+//	!env        env is not set
+//
+// The empty selector matches everything.
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector reads a selector such as "env=prod,team=net"
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var selector LabelSelector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var req labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			req = labelRequirement{key: parts[0], value: parts[1], op: "!="}
+		case strings.Contains(term, "=="):
+			parts := strings.SplitN(term, "==", 2)
+			req = labelRequirement{key: parts[0], value: parts[1], op: "="}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			req = labelRequirement{key: parts[0], value: parts[1], op: "="}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{key: term[1:], op: "!exists"}
+		default:
+			req = labelRequirement{key: term, op: "exists"}
+		}
+		req.key, req.value = strings.TrimSpace(req.key), strings.TrimSpace(req.value)
+		if !labelKeyRegexp.MatchString(req.key) {
+			return nil, invalid("selector", "invalid label key %q in %q", req.key, term)
+		}
+		if !labelValueRegexp.MatchString(req.value) {
+			return nil, invalid("selector", "invalid label value %q in %q", req.value, term)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// Matches reports whether labels satisfy every term of the selector
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || value != req.value {
+				return false
+			}
+		case "!=":
+			if ok && value == req.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// formatLabels describes labels for logs and the audit log, sorted by key
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	terms := make([]string, len(keys))
+	for i, k := range keys {
+		terms[i] = k + "=" + labels[k]
+	}
+	return strings.Join(terms, ",")
+}
@@ -0,0 +1,55 @@
+package netcore
+
+import "testing"
+
+func TestValidateLabels(t *testing.T) {
+	if err := validateLabels(map[string]string{"env": "prod", "team.net": "", "owner": "ops@example.com"}); err != nil {
+		t.Errorf("valid labels: %s", err)
+	}
+	for _, labels := range []map[string]string{
+		{"Env": "prod"},
+		{"-env": "prod"},
+		{"env": "prod net"},
+		{"": "prod"},
+	} {
+		if _, ok := validateLabels(labels).(*ValidationError); !ok {
+			t.Errorf("validateLabels(%v) accepted invalid labels", labels)
+		}
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "net"}
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env==prod, team=net", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"owner!=ops", true},
+		{"team", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!env", false},
+		{"env=prod,owner", false},
+	}
+	for _, test := range tests {
+		s, err := ParseLabelSelector(test.selector)
+		if err != nil {
+			t.Errorf("ParseLabelSelector(%q): %s", test.selector, err)
+			continue
+		}
+		if matches := s.Matches(labels); matches != test.matches {
+			t.Errorf("%q matches %v = %v, want %v", test.selector, labels, matches, test.matches)
+		}
+	}
+	for _, invalid := range []string{"Env=prod", "env=prod net", "=prod"} {
+		if _, err := ParseLabelSelector(invalid); err == nil {
+			t.Errorf("ParseLabelSelector(%q) succeeded, want an error", invalid)
+		}
+	}
+}
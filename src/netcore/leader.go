@@ -0,0 +1,101 @@
+package netcore
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var pruneInterval = Flags.Duration("pruneinterval", 10*time.Minute, "How often the leader removes the directories that expired leases and records leave behind in etcd (0 to disable).")
+
+// LeaderDB elects which instance performs each duty that must only run once
+// in a cluster
+type LeaderDB interface {
+	// Campaign makes id the leader for duty until ttl passes, unless another
+	// instance already is; the leader calls it again to stay leader
+	Campaign(duty, id string, ttl time.Duration) (bool, error)
+	// Prune removes empty directories left behind by expired keys and
+	// returns how many it removed
+	Prune() (int, error)
+}
+
+// leaderTermHeartbeats is how many rounds of a duty a leader may miss before
+// another instance takes over
+const leaderTermHeartbeats = 3
+
+// leaderships are the duties this instance currently leads
+type leaderships struct {
+	sync.Mutex
+	duties map[string]bool
+}
+
+var leading = &leaderships{duties: make(map[string]bool)}
+
+func (l *leaderships) set(duty string, leader bool) {
+	l.Lock()
+	defer l.Unlock()
+	if l.duties[duty] != leader {
+		if leader {
+			logger.Printf("Leading %s\n", duty)
+		} else {
+			logger.Printf("No longer leading %s\n", duty)
+		}
+	}
+	l.duties[duty] = leader
+}
+
+// List returns the duties this instance leads, in order
+func (l *leaderships) List() []string {
+	l.Lock()
+	defer l.Unlock()
+	duties := []string{}
+	for duty, leader := range l.duties {
+		if leader {
+			duties = append(duties, duty)
+		}
+	}
+	sort.Strings(duties)
+	return duties
+}
+
+// singleton runs duty every interval on whichever instance wins the election
+// for it, while the others stand by and take over if the leader stops
+// renewing its term
+func singleton(cfg *Config, duty string, interval time.Duration, run func(cfg *Config) error) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for {
+			leader, err := cfg.db.Campaign(duty, cfg.Hostname(), leaderTermHeartbeats*interval)
+			if err != nil {
+				logger.Printf("Election for %s failed: %s\n", duty, err)
+			}
+			leading.set(duty, leader)
+			if leader {
+				if err := run(cfg); err != nil {
+					logger.Printf("%s failed: %s\n", duty, err)
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// leaderSetup starts the duties that only one instance performs
+func leaderSetup(cfg *Config) {
+	singleton(cfg, "prune", *pruneInterval, func(cfg *Config) error {
+		removed, err := cfg.db.Prune()
+		if removed > 0 {
+			debugf("Pruned %d empty directories\n", removed)
+		}
+		return err
+	})
+	singleton(cfg, "expire", *expireInterval, sweepExpiredDNS)
+	singleton(cfg, "ddnsgc", *ddnsGCInterval, collectOrphanedDDNS)
+	singleton(cfg, "trustanchors", *trustAnchorRefresh, refreshTrustAnchors)
+	singleton(cfg, "dnsseckeys", *dnssecKeyCheck, rollDNSSECKeys)
+	if *backupDest != "" {
+		singleton(cfg, "backup", *backupInterval, runBackup)
+	}
+}
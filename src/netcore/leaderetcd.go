@@ -0,0 +1,69 @@
+package netcore
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// The leader of each duty holds /leader/<duty>, with its hostname as the
+// value, for the length of its term. It renews its term by swapping the key
+// for itself before it expires; anyone else can only create it once it has.
+
+func (db EtcdDB) Campaign(duty, id string, ttl time.Duration) (bool, error) {
+	key, seconds := "leader/"+duty, uint64(ttl.Seconds()+0.5)
+	_, err := db.client.Create(key, id, seconds)
+	if etcdKeyExists(err) {
+		_, err = db.client.CompareAndSwap(key, id, seconds, id, 0)
+	}
+	if etcdCompareFailed(err) || etcdKeyNotFound(err) {
+		return false, nil // someone else leads, or just stopped
+	}
+	return err == nil, err
+}
+
+// prunedTrees are the trees where keys expire and leave their directories
+var prunedTrees = []string{"dhcp", "dns"}
+
+func (db EtcdDB) Prune() (int, error) {
+	removed := 0
+	for _, tree := range prunedTrees {
+		response, err := db.client.Get(tree, false, true)
+		if etcdKeyNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		for _, node := range response.Node.Nodes {
+			n, _ := db.pruneNode(node)
+			removed += n
+		}
+	}
+	return removed, nil
+}
+
+// pruneNode removes the empty directories under and including node, deepest
+// first, and tells whether node itself is gone. Removing a directory only
+// succeeds while it is empty, so keys written meanwhile are never lost.
+func (db EtcdDB) pruneNode(node *etcd.Node) (removed int, gone bool) {
+	if !node.Dir {
+		return 0, false
+	}
+	left := len(node.Nodes)
+	for _, child := range node.Nodes {
+		n, childGone := db.pruneNode(child)
+		removed += n
+		if childGone {
+			left--
+		}
+	}
+	if left > 0 {
+		return removed, false
+	}
+	if _, err := db.client.DeleteDir(strings.TrimPrefix(node.Key, "/")); err != nil {
+		return removed, false
+	}
+	return removed + 1, true
+}
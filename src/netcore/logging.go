@@ -0,0 +1,33 @@
+package netcore
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// logger is where netcore logs, which programs that embed it may change
+var logger = log.New(os.Stderr, "", log.LstdFlags)
+
+// SetLogger makes netcore log to l
+func SetLogger(l *log.Logger) {
+	logger = l
+}
+
+var logLevel = Flags.String("loglevel", "info", "How much to log: info, or debug to add the loaded configuration and every DHCP option sent.")
+
+// checkLogLevel reports a -loglevel we don't know
+func checkLogLevel() error {
+	switch *logLevel {
+	case "info", "debug":
+		return nil
+	}
+	return fmt.Errorf("-loglevel must be info or debug, not %q", *logLevel)
+}
+
+// debugf logs only at the debug level
+func debugf(format string, v ...interface{}) {
+	if *logLevel == "debug" {
+		logger.Printf(format, v...)
+	}
+}
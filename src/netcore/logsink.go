@@ -0,0 +1,286 @@
+package netcore
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	logSinkURLs      = Flags.String("logsink", "", "Comma-separated list of places the operational log is also shipped to: syslog at udp://, tcp:// or tls://host:port (RFC 5424), or Loki at its http(s)://host:3100/loki/api/v1/push URL.")
+	queryLogSinkURLs = Flags.String("querylogsink", "", "Comma-separated list of places every DNS query is shipped to as a JSON line, like -logsink.")
+	logSinkBuffer    = Flags.Int("logsinkbuffer", 10000, "How many lines each log sink buffers while its destination is slow or down; lines beyond are dropped and counted in the log_sinks metrics.")
+)
+
+// logSinkStats counts lines shipped, dropped because a sink's buffer was
+// full, and failed deliveries
+var logSinkStats = expvar.NewMap("log_sinks")
+
+// logLine is a line of one of the logs
+type logLine struct {
+	Time time.Time
+	Text string
+}
+
+// logSinks ships a log to several places
+type logSinks []*logSink
+
+var (
+	operationalLogSinks logSinks
+	queryLogSinks       logSinks
+)
+
+// Send queues line for every sink, without ever waiting on them
+func (s logSinks) Send(line logLine) {
+	for _, sink := range s {
+		sink.Send(line)
+	}
+}
+
+// logSink buffers lines for a destination and delivers them in batches from
+// a goroutine of its own, retrying with backoff while the destination is
+// down. Serving DNS and DHCP never waits on it: once the buffer is full,
+// lines are dropped.
+type logSink struct {
+	name    string // the URL, without credentials
+	lines   chan logLine
+	deliver func([]logLine) error
+}
+
+// logSinkBatch is the most lines delivered at once
+const logSinkBatch = 500
+
+// Send queues line, or drops it if the buffer is full
+func (s *logSink) Send(line logLine) {
+	select {
+	case s.lines <- line:
+	default:
+		logSinkStats.Add("dropped", 1)
+	}
+}
+
+func (s *logSink) run() {
+	backoff := time.Second
+	for line := range s.lines {
+		batch := []logLine{line}
+	collect:
+		for len(batch) < logSinkBatch {
+			select {
+			case line := <-s.lines:
+				batch = append(batch, line)
+			default:
+				break collect
+			}
+		}
+		for {
+			err := s.deliver(batch)
+			if err == nil {
+				logSinkStats.Add("shipped", int64(len(batch)))
+				backoff = time.Second
+				break
+			}
+			// Logging the failure to the operational log would feed a sink
+			// that is down, so it goes to stderr only
+			fmt.Fprintf(os.Stderr, "Log sink %s failed, retrying in %s: %s\n", s.name, backoff, err)
+			logSinkStats.Add("failed", 1)
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}
+}
+
+// newLogSink makes a sink for a -logsink URL, labeling what it ships with
+// category and host
+func newLogSink(rawurl, category, host string) (*logSink, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q has no host", rawurl)
+	}
+	sink := &logSink{name: u.Scheme + "://" + u.Host + u.Path}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return nil, fmt.Errorf("%q needs a port: %s", rawurl, err)
+		}
+		w := &syslogWriter{network: u.Scheme, addr: u.Host, host: host, msgid: category}
+		sink.deliver = w.deliver
+	case "http", "https":
+		sink.deliver = (&lokiWriter{url: rawurl, labels: map[string]string{"job": "netcore", "host": host, "category": category}}).deliver
+	default:
+		return nil, fmt.Errorf("%q must be udp://, tcp:// or tls:// for syslog, or http(s):// for Loki", rawurl)
+	}
+	return sink, nil
+}
+
+// parseLogSinks makes the sinks of a comma-separated list of URLs
+func parseLogSinks(urls, category, host string) (logSinks, error) {
+	var sinks logSinks
+	for _, rawurl := range strings.Split(urls, ",") {
+		rawurl = strings.TrimSpace(rawurl)
+		if rawurl == "" {
+			continue
+		}
+		sink, err := newLogSink(rawurl, category, host)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// checkLogSinks reports -logsink, -querylogsink and -securitylog URLs we
+// cannot ship to
+func checkLogSinks() error {
+	if _, err := parseLogSinks(*logSinkURLs, "operational", ""); err != nil {
+		return fmt.Errorf("-logsink: %s", err)
+	}
+	if _, err := parseLogSinks(*queryLogSinkURLs, "query", ""); err != nil {
+		return fmt.Errorf("-querylogsink: %s", err)
+	}
+	if _, err := parseSecurityLog(*securityLog, ""); err != nil {
+		return fmt.Errorf("-securitylog: %s", err)
+	}
+	return nil
+}
+
+// logSinkSetup starts shipping the operational log and the query log to
+// their sinks
+func logSinkSetup(cfg *Config) {
+	var err error
+	if operationalLogSinks, err = parseLogSinks(*logSinkURLs, "operational", cfg.Hostname()); err != nil {
+		logger.Printf("Log sinks are disabled: -logsink: %s\n", err)
+	}
+	if queryLogSinks, err = parseLogSinks(*queryLogSinkURLs, "query", cfg.Hostname()); err != nil {
+		logger.Printf("Query log sinks are disabled: -querylogsink: %s\n", err)
+	}
+	for _, sinks := range []logSinks{operationalLogSinks, queryLogSinks} {
+		for _, sink := range sinks {
+			sink.lines = make(chan logLine, *logSinkBuffer)
+			go sink.run()
+		}
+	}
+	if len(operationalLogSinks) > 0 {
+		logger = log.New(&teeLogWriter{next: logger, sinks: operationalLogSinks}, "", 0)
+	}
+}
+
+// teeLogWriter is the output of a logger that logs through next as well as
+// shipping each message to sinks
+type teeLogWriter struct {
+	next  *log.Logger
+	sinks logSinks
+}
+
+func (w *teeLogWriter) Write(p []byte) (int, error) {
+	text := strings.TrimRight(string(p), "\n")
+	w.next.Output(2, text)
+	for _, line := range strings.Split(text, "\n") {
+		w.sinks.Send(logLine{Time: time.Now(), Text: line})
+	}
+	return len(p), nil
+}
+
+// syslogWriter delivers lines to a syslog server in the RFC 5424 format,
+// one datagram each over UDP, or framed by octet counting (RFC 6587) over
+// TCP and TLS
+type syslogWriter struct {
+	network, addr string
+	host, msgid   string
+	conn          net.Conn
+}
+
+// syslogPriority is facility daemon (3), severity informational (6)
+const syslogPriority = 3*8 + 6
+
+func (w *syslogWriter) deliver(lines []logLine) error {
+	if w.conn == nil {
+		var err error
+		switch w.network {
+		case "tls":
+			w.conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", w.addr, nil)
+		default:
+			w.conn, err = net.DialTimeout(w.network, w.addr, 10*time.Second)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	host := w.host
+	if host == "" {
+		host = "-"
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		msg := fmt.Sprintf("<%d>1 %s %s netcore %d %s - %s", syslogPriority, line.Time.UTC().Format(time.RFC3339Nano), host, os.Getpid(), w.msgid, line.Text)
+		if w.network == "udp" {
+			buf.WriteString(msg)
+			if _, err := w.conn.Write(buf.Bytes()); err != nil {
+				return w.fail(err)
+			}
+			buf.Reset()
+			continue
+		}
+		buf.WriteString(strconv.Itoa(len(msg)) + " " + msg)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := w.conn.Write(buf.Bytes()); err != nil {
+		return w.fail(err)
+	}
+	return nil
+}
+
+// fail drops the connection so that the next delivery dials again
+func (w *syslogWriter) fail(err error) error {
+	w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// lokiWriter pushes lines to Loki as a single stream with labels
+type lokiWriter struct {
+	url    string
+	labels map[string]string
+	client http.Client
+}
+
+func (w *lokiWriter) deliver(lines []logLine) error {
+	values := make([][]string, 0, len(lines))
+	for _, line := range lines {
+		values = append(values, []string{strconv.FormatInt(line.Time.UnixNano(), 10), line.Text})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"streams": []interface{}{map[string]interface{}{"stream": w.labels, "values": values}},
+	})
+	if err != nil {
+		return err
+	}
+	w.client.Timeout = 10 * time.Second
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("responded %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package netcore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Error codes of the etcd v2 API, which the rest of netcore recognizes by
+// their messages
+const (
+	memKVKeyNotFound   = 100
+	memKVCompareFailed = 101
+	memKVNotFile       = 102
+	memKVKeyExists     = 105
+	memKVDirNotEmpty   = 108
+)
+
+// memKV is an in-memory key/value tree that behaves like etcd v2, with TTLs,
+// so that netcore can run without an etcd cluster. Nothing survives a
+// restart. Reads, the bulk of the work, share the lock.
+type memKV struct {
+	sync.RWMutex
+	root  *memNode
+	index uint64
+}
+
+type memNode struct {
+	value    string
+	dir      bool
+	expires  *time.Time
+	children map[string]*memNode
+	created  uint64
+	modified uint64
+}
+
+func newMemKV() *memKV {
+	return &memKV{root: &memNode{dir: true, children: make(map[string]*memNode)}}
+}
+
+// NewMemoryDB is a database that keeps everything in memory, for running
+// without etcd
+func NewMemoryDB() EtcdDB {
+	return EtcdDB{newMemKV()}
+}
+
+func memKVPath(key string) []string {
+	key = strings.Trim(key, "/")
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, "/")
+}
+
+func (kv *memKV) fail(code int, message, key string) error {
+	return &etcd.EtcdError{ErrorCode: code, Message: message, Cause: "/" + strings.Trim(key, "/"), Index: kv.index}
+}
+
+// lookup returns the node at key, or nil, forgetting expired nodes on the
+// way; the caller must hold the lock
+func (kv *memKV) lookup(key string) (parent, node *memNode, name string) {
+	now := time.Now()
+	node = kv.root
+	for _, name = range memKVPath(key) {
+		if !node.dir {
+			return nil, nil, name
+		}
+		parent = node
+		node = parent.children[name]
+		if node == nil {
+			return parent, nil, name
+		}
+		if node.expires != nil && now.After(*node.expires) {
+			delete(parent.children, name)
+			return parent, nil, name
+		}
+	}
+	return parent, node, name
+}
+
+// find returns the node at key, or nil, as lookup does but leaving expired
+// nodes for writers to forget; the caller must hold the lock for reading
+func (kv *memKV) find(key string) *memNode {
+	now := time.Now()
+	node := kv.root
+	for _, name := range memKVPath(key) {
+		if !node.dir {
+			return nil
+		}
+		node = node.children[name]
+		if node == nil || node.expires != nil && now.After(*node.expires) {
+			return nil
+		}
+	}
+	return node
+}
+
+// mkdirs returns the directory that holds key, creating directories as
+// needed; the caller must hold the lock
+func (kv *memKV) mkdirs(key string) (*memNode, string, error) {
+	path := memKVPath(key)
+	if len(path) == 0 {
+		return nil, "", kv.fail(memKVNotFile, "Not a file", key)
+	}
+	dir := kv.root
+	for i, name := range path[:len(path)-1] {
+		_, child, _ := kv.lookup(strings.Join(path[:i+1], "/"))
+		if child == nil {
+			kv.index++
+			child = &memNode{dir: true, children: make(map[string]*memNode), created: kv.index, modified: kv.index}
+			dir.children[name] = child
+		}
+		if !child.dir {
+			return nil, "", kv.fail(memKVNotFile, "Not a directory", strings.Join(path[:i+1], "/"))
+		}
+		dir = child
+	}
+	return dir, path[len(path)-1], nil
+}
+
+func memKVExpiry(ttl uint64) *time.Time {
+	if ttl == 0 {
+		return nil
+	}
+	expires := time.Now().Add(time.Duration(ttl) * time.Second)
+	return &expires
+}
+
+// export copies node as etcd returns it: directories list their children,
+// and their children's children only when recursive
+func (kv *memKV) export(key string, node *memNode, recursive, children bool) *etcd.Node {
+	n := &etcd.Node{
+		Key:           "/" + strings.Trim(key, "/"),
+		Value:         node.value,
+		Dir:           node.dir,
+		Expiration:    node.expires,
+		CreatedIndex:  node.created,
+		ModifiedIndex: node.modified,
+	}
+	if node.expires != nil {
+		n.TTL = int64(node.expires.Sub(time.Now()).Seconds() + 0.5)
+	}
+	if node.dir && children {
+		names := make([]string, 0, len(node.children))
+		for name, child := range node.children {
+			if child.expires == nil || time.Now().Before(*child.expires) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			n.Nodes = append(n.Nodes, kv.export(n.Key+"/"+name, node.children[name], recursive, recursive))
+		}
+	}
+	return n
+}
+
+func (kv *memKV) respond(action, key string, node, prev *memNode) *etcd.Response {
+	response := &etcd.Response{Action: action, EtcdIndex: kv.index}
+	if node != nil {
+		response.Node = kv.export(key, node, false, false)
+	}
+	if prev != nil {
+		response.PrevNode = kv.export(key, prev, false, false)
+	}
+	return response
+}
+
+func (kv *memKV) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	kv.RLock()
+	defer kv.RUnlock()
+	node := kv.find(key)
+	if node == nil {
+		return nil, kv.fail(memKVKeyNotFound, "Key not found", key)
+	}
+	return &etcd.Response{Action: "get", Node: kv.export(key, node, recursive, true), EtcdIndex: kv.index}, nil
+}
+
+func (kv *memKV) Set(key string, value string, ttl uint64) (*etcd.Response, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	return kv.put("set", key, value, ttl, false)
+}
+
+func (kv *memKV) Create(key string, value string, ttl uint64) (*etcd.Response, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	if _, node, _ := kv.lookup(key); node != nil {
+		return nil, kv.fail(memKVKeyExists, "Key already exists", key)
+	}
+	return kv.put("create", key, value, ttl, false)
+}
+
+func (kv *memKV) CreateDir(key string, ttl uint64) (*etcd.Response, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	if _, node, _ := kv.lookup(key); node != nil {
+		return nil, kv.fail(memKVKeyExists, "Key already exists", key)
+	}
+	return kv.put("create", key, "", ttl, true)
+}
+
+func (kv *memKV) CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	return kv.put("create", fmt.Sprintf("%s/%020d", strings.TrimRight(dir, "/"), kv.index+1), value, ttl, false)
+}
+
+// put writes a node; the caller must hold the lock
+func (kv *memKV) put(action, key, value string, ttl uint64, dir bool) (*etcd.Response, error) {
+	parent, name, err := kv.mkdirs(key)
+	if err != nil {
+		return nil, err
+	}
+	_, prev, _ := kv.lookup(key)
+	if prev != nil && prev.dir {
+		return nil, kv.fail(memKVNotFile, "Not a file", key)
+	}
+	kv.index++
+	node := &memNode{value: value, dir: dir, expires: memKVExpiry(ttl), created: kv.index, modified: kv.index}
+	if dir {
+		node.children = make(map[string]*memNode)
+	}
+	if prev != nil {
+		node.created = prev.created
+	}
+	parent.children[name] = node
+	return kv.respond(action, key, node, prev), nil
+}
+
+func (kv *memKV) Delete(key string, recursive bool) (*etcd.Response, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	parent, node, name := kv.lookup(key)
+	if node == nil || parent == nil {
+		return nil, kv.fail(memKVKeyNotFound, "Key not found", key)
+	}
+	if node.dir && !recursive {
+		return nil, kv.fail(memKVNotFile, "Not a file", key)
+	}
+	kv.index++
+	delete(parent.children, name)
+	return kv.respond("delete", key, nil, node), nil
+}
+
+// DeleteDir removes a directory only if it is empty
+func (kv *memKV) DeleteDir(key string) (*etcd.Response, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	parent, node, name := kv.lookup(key)
+	if node == nil || parent == nil {
+		return nil, kv.fail(memKVKeyNotFound, "Key not found", key)
+	}
+	if !node.dir {
+		return nil, kv.fail(memKVNotFile, "Not a directory", key)
+	}
+	for _, child := range node.children {
+		if child.expires == nil || time.Now().Before(*child.expires) {
+			return nil, kv.fail(memKVDirNotEmpty, "Directory not empty", key)
+		}
+	}
+	kv.index++
+	delete(parent.children, name)
+	return kv.respond("delete", key, nil, node), nil
+}
+
+// compare checks a node against the previous value and index that a
+// compare-and-swap or -delete expects; the caller must hold the lock
+func (kv *memKV) compare(key, prevValue string, prevIndex uint64) (parent, node *memNode, name string, err error) {
+	parent, node, name = kv.lookup(key)
+	if node == nil || parent == nil {
+		return nil, nil, "", kv.fail(memKVKeyNotFound, "Key not found", key)
+	}
+	if node.dir {
+		return nil, nil, "", kv.fail(memKVNotFile, "Not a file", key)
+	}
+	if (prevValue != "" && node.value != prevValue) || (prevIndex != 0 && node.modified != prevIndex) {
+		return nil, nil, "", kv.fail(memKVCompareFailed, "Compare failed", key)
+	}
+	return parent, node, name, nil
+}
+
+func (kv *memKV) CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	if _, _, _, err := kv.compare(key, prevValue, prevIndex); err != nil {
+		return nil, err
+	}
+	return kv.put("compareAndSwap", key, value, ttl, false)
+}
+
+func (kv *memKV) CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	kv.Lock()
+	defer kv.Unlock()
+	parent, node, name, err := kv.compare(key, prevValue, prevIndex)
+	if err != nil {
+		return nil, err
+	}
+	kv.index++
+	delete(parent.children, name)
+	return kv.respond("compareAndDelete", key, nil, node), nil
+}
@@ -0,0 +1,93 @@
+package netcore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemKV(t *testing.T) {
+	kv := newMemKV()
+	if _, err := kv.Get("dns/com/example", false, false); !etcdKeyNotFound(err) {
+		t.Errorf("Get of a missing key: %v", err)
+	}
+	if _, err := kv.Set("dns/com/example/www/a/1", "192.0.2.1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Create("dns/com/example/www/a/1", "192.0.2.2", 0); !etcdKeyExists(err) {
+		t.Errorf("Create of an existing key: %v", err)
+	}
+	if _, err := kv.Set("dns/com/example", "file", 0); err == nil {
+		t.Errorf("Set replaced a directory")
+	}
+
+	response, err := kv.Get("dns/com", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := response.Node; !n.Dir || len(n.Nodes) != 1 || n.Nodes[0].Key != "/dns/com/example" || len(n.Nodes[0].Nodes) != 1 {
+		t.Errorf("recursive Get = %+v", n)
+	}
+	if response, _ := kv.Get("dns/com", false, false); len(response.Node.Nodes[0].Nodes) != 0 {
+		t.Errorf("Get without recursion returned grandchildren")
+	}
+
+	// Compare-and-swap and -delete on value and index
+	response, _ = kv.Get("dns/com/example/www/a/1", false, false)
+	index := response.Node.ModifiedIndex
+	if _, err := kv.CompareAndSwap("dns/com/example/www/a/1", "192.0.2.3", 0, "192.0.2.9", 0); !etcdCompareFailed(err) {
+		t.Errorf("CompareAndSwap on the wrong value: %v", err)
+	}
+	if _, err := kv.CompareAndSwap("dns/com/example/www/a/1", "192.0.2.3", 0, "", index); err != nil {
+		t.Errorf("CompareAndSwap on the index: %s", err)
+	}
+	if _, err := kv.CompareAndDelete("dns/com/example/www/a/1", "", index); !etcdCompareFailed(err) {
+		t.Errorf("CompareAndDelete on a stale index: %v", err)
+	}
+	if _, err := kv.CompareAndDelete("dns/com/example/www/a/1", "192.0.2.3", 0); err != nil {
+		t.Errorf("CompareAndDelete on the value: %s", err)
+	}
+
+	// Directories
+	if _, err := kv.Delete("dns/com", false); err == nil {
+		t.Errorf("Delete removed a directory without recursion")
+	}
+	kv.Set("dns/com/example/www/a/2", "192.0.2.2", 0)
+	if _, err := kv.DeleteDir("dns/com/example/www/a"); err == nil {
+		t.Errorf("DeleteDir removed a directory that is not empty")
+	}
+	if _, err := kv.Delete("dns/com", true); err != nil {
+		t.Errorf("recursive Delete: %s", err)
+	}
+	if _, err := kv.Get("dns/com/example/www/a/2", false, false); !etcdKeyNotFound(err) {
+		t.Errorf("a key survived the recursive Delete of its directory")
+	}
+
+	// In-order keys sort by creation
+	first, _ := kv.CreateInOrder("queue", "first", 0)
+	second, _ := kv.CreateInOrder("queue", "second", 0)
+	if first.Node.Key >= second.Node.Key {
+		t.Errorf("in-order keys %s and %s are out of order", first.Node.Key, second.Node.Key)
+	}
+}
+
+func TestMemKVExpiry(t *testing.T) {
+	kv := newMemKV()
+	kv.Set("dhcp/192.0.2.10", "02:00:00:00:00:01", 1)
+	kv.Set("dhcp/192.0.2.11", "02:00:00:00:00:02", 0)
+	response, err := kv.Get("dhcp/192.0.2.10", false, false)
+	if err != nil || response.Node.Expiration == nil || response.Node.TTL != 1 {
+		t.Fatalf("Get of a key with a TTL = %+v, %v", response, err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := kv.Get("dhcp/192.0.2.10", false, false); !etcdKeyNotFound(err) {
+		t.Errorf("an expired key is still there: %v", err)
+	}
+	if _, err := kv.Create("dhcp/192.0.2.10", "02:00:00:00:00:03", 0); err != nil {
+		t.Errorf("Create over an expired key: %s", err)
+	}
+	response, _ = kv.Get("dhcp", false, false)
+	if len(response.Node.Nodes) != 2 {
+		t.Errorf("listing = %+v", response.Node.Nodes)
+	}
+}
@@ -1,58 +1,180 @@
-package main
+// Package netcore serves DHCP and DNS with all config and data stored in
+// etcd. The netcore command runs it as a daemon, and the netdns and netdhcp
+// packages run its services inside other programs.
+package netcore
 
 import (
+	"errors"
 	"flag"
-	"log"
+	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
-var etcdServers = flag.String("etcd", "http://127.0.0.1:2379", "Comma-separated list of etcd servers.")
+// Flags are the settings of the netcore daemon, which the netcore command
+// parses from its command line
+var Flags = flag.NewFlagSet("netcore", flag.ExitOnError)
 
-func init() {
-	flag.Parse()
+var etcdServers = Flags.String("etcd", "http://127.0.0.1:2379", "Comma-separated list of etcd servers.")
+
+// stopRequests asks Run to return, with the error it should return
+var stopRequests = make(chan error, 1)
+
+// requestStop makes Run return err, unless it is already returning
+func requestStop(err error) {
+	select {
+	case stopRequests <- err:
+	default:
+	}
+}
+
+// ParseFlags parses args into Flags, then sets the flags that were not given
+// from the environment
+func ParseFlags(args []string) error {
+	if err := Flags.Parse(args); err != nil {
+		return err
+	}
+	if err := applyEnvFlags(); err != nil {
+		return err
+	}
+	if err := checkLogLevel(); err != nil {
+		return err
+	}
+	if err := checkQueryLogPolicy(); err != nil {
+		return err
+	}
+	if err := checkZoneStatsReport(); err != nil {
+		return err
+	}
+	if err := checkDNSMultiQuestion(); err != nil {
+		return err
+	}
+	if err := checkSelfAddresses(); err != nil {
+		return err
+	}
+	return checkLogSinks()
 }
 
-func main() {
-	if len(*etcdServers) == 0 {
-		if len(os.Getenv("ETCD_PORT")) > 0 {
-			*etcdServers = strings.Replace(os.Getenv("ETCD_PORT"), "tcp://", "http://", 1)
-		} else {
-			*etcdServers = "etcd" // just some default hostname that Docker or otherwise might use
+// Run runs the netcore daemon as Flags configure it, until it is stopped by
+// draining, by handing over to a new process or by a signal
+func Run() error {
+	var etcdDB EtcdDB
+	switch backend := *dbBackend; {
+	case backend == "memory" || backend == "" && *configFile != "":
+		etcdDB = NewMemoryDB()
+	case backend == "etcd" || backend == "":
+		if len(*etcdServers) == 0 {
+			if len(os.Getenv("ETCD_PORT")) > 0 {
+				*etcdServers = strings.Replace(os.Getenv("ETCD_PORT"), "tcp://", "http://", 1)
+			} else {
+				*etcdServers = "etcd" // just some default hostname that Docker or otherwise might use
+			}
+		}
+		etcdDB = NewEtcdDB(*etcdServers)
+		if *etcdBreakerFailures > 0 {
+			etcdDB = EtcdDB{newBreakerKV(etcdDB.client, *etcdBreakerFailures)}
+		}
+	default:
+		return fmt.Errorf("-backend must be etcd or memory, not %q", backend)
+	}
+	if *drTarget != "" {
+		if *replicaMode {
+			return errors.New("-drtarget copies the writes of a read-write instance, not those of a -replica")
+		}
+		client, err := newDRKV(etcdDB.client, *drTarget)
+		if err != nil {
+			return fmt.Errorf("DR replication failed: %s", err)
+		}
+		etcdDB = EtcdDB{client}
+	}
+	if err := checkSchema(etcdDB); err != nil {
+		return fmt.Errorf("Schema check failed: %s", err)
+	}
+	var db DB = etcdDB
+	switch {
+	case *replicaMode:
+		if _, ok := etcdDB.client.(*memKV); ok {
+			return errors.New("-replica needs an etcd cluster to copy, not -backend memory")
+		}
+		replica, err := NewReplicaDB(etcdDB)
+		if err != nil {
+			return fmt.Errorf("Replica failed: %s", err)
+		}
+		db = replica
+	case *snapshotPath != "":
+		db = NewSnapshotDB(etcdDB, *snapshotPath)
+	}
+	if *configFile != "" {
+		kv, zones, err := loadConfigFile(*configFile)
+		if err != nil {
+			return fmt.Errorf("Configuration failed: %s", err)
+		}
+		db = FileConfigDB{DB: db, kv: kv}
+		if err := applyConfigFileZones(db, zones); err != nil {
+			return fmt.Errorf("Configuration failed: %s: %s", *configFile, err)
 		}
 	}
-	db := NewEtcdDB(*etcdServers)
 
-	log.Println("PRECONFIG")
+	debugf("PRECONFIG\n")
 	cfg, err := db.GetConfig()
-	log.Println("POSTCONFIG")
+	debugf("POSTCONFIG\n")
 
 	if err != nil {
-		log.Printf("Configuration failed: %s\n", err)
-		os.Exit(1)
+		if err == ErrNoZone && *configFile == "" {
+			logger.Printf("Run \"netcorectl init\" to set up etcd for this host.\n")
+		}
+		return fmt.Errorf("Configuration failed: %s", err)
 	}
 
-	var dhcpExit chan error
-	if cfg.DHCPIP() == nil {
-		log.Println("DHCP service is disabled; this machine does not have a DHCP IP assigned.")
+	// The API starts first, to report on the others as they start. DHCP
+	// registers leases in DNS, so it starts once DNS is ready.
+	supervisor := NewSupervisor()
+	if *apilisten == "" {
+		logger.Println("HTTP API is disabled; no listen address is set.")
+	} else {
+		supervisor.Add(NewAPIService(cfg, *apilisten))
+	}
+	if *statuslisten != "" {
+		supervisor.Add(NewStatusService(cfg, *statuslisten))
+	}
+	supervisor.Add(NewDNSService(cfg, *dnslisten))
+	serveDHCP := false
+	if *replicaMode {
+		logger.Println("DHCP service is disabled; this instance is a read-only replica.")
+	} else if len(cfg.DHCPInstances()) > 0 {
+		supervisor.Add(NewDHCPServers(cfg), "dns")
+		serveDHCP = true
+	} else if cfg.DHCPIP() == nil {
+		logger.Println("DHCP service is disabled; this machine does not have a DHCP IP assigned.")
 	} else if cfg.DHCPSubnet() == nil {
-		log.Println("DHCP service is disabled; this machine's zone does not have a DHCP subnet assigned.")
-	} else if cfg.DHCPNIC() == "" {
-		log.Println("DHCP service is disabled; this machine does not have a DHCP NIC assigned.")
+		logger.Println("DHCP service is disabled; this machine's zone does not have a DHCP subnet assigned.")
 	} else {
-		dhcpExit = dhcpSetup(cfg)
+		logger.Println("DHCP service is disabled; this machine does not have a DHCP NIC assigned.")
 	}
 
-	dnsExit := dnsSetup(cfg)
+	logSinkSetup(cfg)
+	tracingSetup()
+	webhookSetup()
+	securityLogSetup(cfg)
+	snoopingSetup(cfg)
+	clientIdentSetup(cfg)
+	zoneStatsSetup(cfg)
+	if err := supervisor.Start(); err != nil {
+		return err
+	}
+	fleetSetup(cfg, serveDHCP, true, *apilisten != "")
+	selfRegisterSetup(cfg)
+	leaderSetup(cfg)
+	anycastSetup(cfg)
+	drainSetup(cfg)
+	handoverSetup()
 
-	log.Println("NETCORE Started.")
+	logger.Println("NETCORE Started.")
 
-	select {
-	case err := <-dhcpExit:
-		log.Printf("DHCP Exited: %s\n", err)
-		os.Exit(1)
-	case err := <-dnsExit:
-		log.Printf("DNS Exited: %s\n", err)
-		os.Exit(1)
-	}
+	// Services that stop are restarted by the supervisor, so only draining,
+	// a handover or a signal stops netcore
+	err = <-stopRequests
+	supervisor.Stop(time.Now().Add(drainWait))
+	return err
 }
@@ -0,0 +1,328 @@
+package netcore
+
+//go:generate go run ./cmd/netcore-clients -go ../netcoreclient/client.go -python ../../clients/python/netcore_client.py
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiOperation describes an endpoint of the admin API for its OpenAPI
+// document. Request and response bodies are given as values of the Go types
+// that the handlers decode and encode, so that their schemas follow the code.
+type apiOperation struct {
+	method, path string
+	summary      string
+	tenants      bool     // tenant tokens may call it too, for their own zones
+	params       []string // query parameters, as "name: description"
+	request      interface{}
+	response     interface{} // nil for 204 No Content
+	status       int
+}
+
+// apiAnyObject stands for JSON objects that have no Go type of their own
+type apiAnyObject map[string]interface{}
+
+// apiOperations lists every endpoint of the admin API; keep it next to the
+// handlers in apiMux
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/healthz", summary: "Report the health of this instance; 503 when unhealthy", response: apiAnyObject{}},
+	{method: "GET", path: "/readyz", summary: "Report whether this instance should take traffic, with the state of the circuit breaker in front of etcd; 503 when not ready", response: apiAnyObject{}},
+	{method: "GET", path: "/debug/pprof/{profile}", summary: "Profile this instance: profile?seconds=30 for the CPU, heap, goroutine, block, threadcreate or trace?seconds=5", params: []string{"seconds: how long to profile the CPU or trace for"}, response: ""},
+	{method: "GET", path: "/api/openapi.json", summary: "This document", response: apiAnyObject{}},
+
+	{method: "GET", path: "/api/dns/zones/", summary: "List the zones the caller may manage", tenants: true, response: []string{}},
+	{method: "GET", path: "/api/dns/soa/{zone}", summary: "Show the SOA settings of a zone", tenants: true, response: map[string]string{}},
+	{method: "PUT", path: "/api/dns/soa/{zone}", summary: "Change the SOA settings of a zone, creating it with ns and mbox", tenants: true, request: apiAnyObject{}, response: map[string]string{}},
+	{method: "GET", path: "/api/dns/zones/{zone}", summary: "Show a zone", tenants: true, response: map[string]string{}},
+	{method: "PUT", path: "/api/dns/zones/{zone}", summary: "Create a zone or change its SOA settings", tenants: true, request: apiAnyObject{}, response: map[string]string{}},
+	{method: "DELETE", path: "/api/dns/zones/{zone}", summary: "Delete a zone that has no records left", tenants: true},
+	{method: "GET", path: "/api/dns/zones/{zone}/records", summary: "List the static records of a zone", tenants: true, params: []string{"selector: only the records whose entry has matching labels, such as env=prod,team!=net", "unicode: true to show internationalized names in their Unicode form rather than as xn--"}, response: []DNSRecord{}},
+	{method: "POST", path: "/api/dns/zones/{zone}/records", summary: "Apply a list of changes to a zone, all or none, unless they add errors to it", tenants: true, params: []string{"force: true to apply changes that add errors to the zone"}, request: []DNSChange{}, response: apiAnyObject{}},
+	{method: "POST", path: "/api/dns/zones/{zone}/clone", summary: "Copy the records of a zone to a new zone", tenants: true, params: []string{"force: true to copy a zone that has errors"}, request: apiAnyObject{}, response: apiAnyObject{}},
+	{method: "GET", path: "/api/dns/zones/{zone}/history", summary: "List the earlier versions of a zone's records, oldest first", tenants: true, params: []string{"name: only the versions of this name", "type: only those of this type, with name", "unicode: true to show internationalized names in their Unicode form rather than as xn--"}, response: []DNSRecordVersion{}},
+	{method: "POST", path: "/api/dns/zones/{zone}/restore", summary: "Put a zone's records, or those of a name and type, back as they were at a time", tenants: true, params: []string{"force: true to restore records that add errors to the zone"}, request: apiAnyObject{}, response: apiAnyObject{}},
+	{method: "GET", path: "/api/dns/zones/{zone}/check", summary: "Check the records of a zone for errors and likely mistakes", tenants: true, params: []string{"unicode: true to show internationalized names in their Unicode form rather than as xn--"}, response: []ZoneProblem{}},
+	{method: "GET", path: "/api/dns/zones/{zone}/rrsets/{name}/{type}", summary: "Show the static values of a name and type", tenants: true, response: dnsRRSet{}},
+	{method: "PUT", path: "/api/dns/zones/{zone}/rrsets/{name}/{type}", summary: "Replace the static values and labels of a name and type", tenants: true, request: dnsRRSet{}, response: dnsRRSet{}},
+	{method: "DELETE", path: "/api/dns/zones/{zone}/rrsets/{name}/{type}", summary: "Delete every value of a name and type", tenants: true},
+
+	{method: "GET", path: "/api/dns/trace", summary: "Explain, stage by stage, how a question from a client would be answered, without sending packets", params: []string{"name: the name asked for", "type: the type asked for, A by default", "client: the address of the client asking", "dnssec: true if the client sets the DO bit"}, response: QueryTrace{}},
+	{method: "GET", path: "/api/dns/resolverconf", summary: "Render the stub zones that have BIND or Unbound ask this cluster about the zones it serves", params: []string{"format: bind or unbound", "server: the addresses to ask, separated by commas; by default those that instances of the fleet listen on"}, response: ""},
+	{method: "GET", path: "/api/dns/zonestats", summary: "Report each zone's queries, NXDOMAIN and SERVFAIL ratios and latency percentiles", params: []string{"window: duration from 1m to 24h, 1h by default", "format: json or csv"}, response: ""},
+	{method: "GET", path: "/api/dns/querylog", summary: "Follow the live query log", params: []string{"after: the last of a previous response, for the queries that followed it", "limit: most entries to return"}, response: apiAnyObject{}},
+	{method: "GET", path: "/api/dns/services", summary: "List the services of a zone, grouped by name, with the target, port, weight and health of each instance", params: []string{"zone: the zone", "domain: only the services under this domain of the zone"}, response: []ServiceGroup{}},
+	{method: "POST", path: "/api/dns/services", summary: "Register an instance of a service, or renew its registration; its records vanish when heartbeats stop for its TTL", request: ServiceRegistration{}, response: ServiceRegistration{}},
+	{method: "DELETE", path: "/api/dns/services", summary: "Remove the registration of an instance of a service", request: ServiceRegistration{}},
+	{method: "POST", path: "/api/dns/srv", summary: "Drain a target of an SRV name, shift traffic between its targets by percentage, or restore their weights", request: SRVWeightChange{}, response: []DNSRecord{}},
+	{method: "GET", path: "/api/dns/profiles/", summary: "List the scheduled filtering profiles", response: []DNSProfile{}},
+	{method: "GET", path: "/api/dns/profiles/{name}", summary: "Show a filtering profile", response: DNSProfile{}},
+	{method: "PUT", path: "/api/dns/profiles/{name}", summary: "Create or replace a filtering profile", request: DNSProfile{}, response: DNSProfile{}},
+	{method: "DELETE", path: "/api/dns/profiles/{name}", summary: "Delete a filtering profile"},
+	{method: "GET", path: "/api/dns/incidents", summary: "List the clients that asked for sinkholed names, most recent first", params: []string{"client: only this client's incidents", "since: RFC 3339 time"}, response: []Incident{}},
+	{method: "DELETE", path: "/api/dns/incidents", summary: "Clear incidents once dealt with", params: []string{"client: only this client's incidents"}, response: map[string]int{}},
+
+	{method: "GET", path: "/api/dnssec/anchors/", summary: "List the DNSSEC trust anchors and their RFC 5011 states", response: []TrustAnchor{}},
+	{method: "POST", path: "/api/dnssec/anchors/", summary: "Add a trust anchor from a DNSKEY or DS record", request: map[string]string{}, response: TrustAnchor{}, status: http.StatusCreated},
+	{method: "DELETE", path: "/api/dnssec/anchors/{zone}/{key_tag}", summary: "Remove a trust anchor"},
+	{method: "GET", path: "/api/dnssec/keys/", summary: "List the keys of every signed zone", response: []apiDNSSECKey{}},
+	{method: "GET", path: "/api/dnssec/keys/{zone}", summary: "List the keys of a zone, with the DS records its parent should have", response: []apiDNSSECKey{}},
+	{method: "POST", path: "/api/dnssec/keys/{zone}", summary: "Sign a zone with a new key signing key and zone signing key", request: map[string]string{}, response: []apiDNSSECKey{}, status: http.StatusCreated},
+	{method: "POST", path: "/api/dnssec/keys/{zone}/rollover", summary: "Publish a new key, which signs once caches know it and, for a key signing key, the parent has its DS record", request: map[string]string{}, response: []apiDNSSECKey{}, status: http.StatusCreated},
+	{method: "POST", path: "/api/dnssec/keys/{zone}/{key_tag}/activate", summary: "Sign with a published key now, retiring the key it replaces", response: []apiDNSSECKey{}},
+	{method: "POST", path: "/api/dnssec/keys/{zone}/import", summary: "Add a key held elsewhere, such as another signer's or an HSM's", request: map[string]string{}, response: []apiDNSSECKey{}, status: http.StatusCreated},
+	{method: "DELETE", path: "/api/dnssec/keys/{zone}/{key_tag}", summary: "Remove a key"},
+	{method: "DELETE", path: "/api/dnssec/keys/{zone}", summary: "Stop signing a zone; remove its DS records from the parent first"},
+	{method: "GET", path: "/api/dnssec/zones/{zone}/unsigned", summary: "Export a zone and its DNSKEY set for a signer outside netcore, in zone file format", response: ""},
+	{method: "PUT", path: "/api/dnssec/zones/{zone}/signatures", summary: "Import the RRSIG and NSEC records of a zone signed outside netcore, in zone file format", response: map[string]int{}},
+	{method: "GET", path: "/api/dnssec/zones/{zone}/signatures", summary: "Show when signatures were imported, and how many", response: apiAnyObject{}},
+	{method: "DELETE", path: "/api/dnssec/zones/{zone}/signatures", summary: "Forget the imported signatures of a zone"},
+
+	{method: "GET", path: "/api/tenants/{tenant}/zones", summary: "List the zones of a tenant", tenants: true, response: apiAnyObject{}},
+	{method: "PUT", path: "/api/tenants/{tenant}/zones/{zone}", summary: "Assign a zone to a tenant", response: map[string]string{}},
+	{method: "POST", path: "/api/tenants/{tenant}/tokens", summary: "Issue a new token for a tenant; it is only shown once", response: map[string]string{}, status: http.StatusCreated},
+
+	{method: "GET", path: "/api/audit", summary: "List audit log entries, newest last; tenants see their own", tenants: true, params: []string{"actor: who made the change", "kind: dns, dhcp, config or tenant", "key: prefix of what was changed", "since: RFC 3339 time", "until: RFC 3339 time", "limit: most entries to return"}, response: []AuditEntry{}},
+	{method: "GET", path: "/api/clients/top", summary: "List the busiest DNS clients", params: []string{"n: number of clients", "sort: queries, nxdomain or nxdomain_ratio"}, response: apiAnyObject{}},
+
+	{method: "GET", path: "/api/dhcp/leases", summary: "Export leases and reservations", params: []string{"format: json, csv or isc", "selector: only the reservations with matching labels"}, response: []DHCPLease{}},
+	{method: "POST", path: "/api/dhcp/leases", summary: "Import leases and reservations", request: []DHCPLease{}, response: apiAnyObject{}},
+	{method: "GET", path: "/api/dhcp/pools", summary: "Show how much of each dynamic pool is in use", response: []DHCPPoolUsage{}},
+	{method: "GET", path: "/api/dhcp/bindings", summary: "List the leases of clients behind relay agents that report their switch port", params: []string{"format: json or csv"}, response: []DHCPLease{}},
+	{method: "POST", path: "/api/dhcp/authorize", summary: "Let a client through the captive portal, by mac or ip", request: map[string]string{}, response: apiAnyObject{}},
+	{method: "DELETE", path: "/api/dhcp/authorize", summary: "Send a client back to the captive portal, by mac or ip", request: map[string]string{}, response: apiAnyObject{}},
+	{method: "GET", path: "/api/dhcp/subnets/{zone}", summary: "Show the network settings of a zone", response: subnetResource{}},
+	{method: "PUT", path: "/api/dhcp/subnets/{zone}", summary: "Replace the network settings of a zone", request: Subnet{}, response: subnetResource{}},
+	{method: "DELETE", path: "/api/dhcp/subnets/{zone}", summary: "Remove the network settings of a zone"},
+	{method: "GET", path: "/api/dhcp/reservations/{mac}", summary: "Show the reservation of a MAC", response: reservationResource{}},
+	{method: "PUT", path: "/api/dhcp/reservations/{mac}", summary: "Reserve an address for a MAC, replacing its reservation", request: DHCPLease{}, response: reservationResource{}},
+	{method: "DELETE", path: "/api/dhcp/reservations/{mac}", summary: "Remove the reservation of a MAC"},
+
+	{method: "GET", path: "/api/config", summary: "Describe every recognized setting and its value for this instance", response: []ConfigValue{}},
+	{method: "GET", path: "/api/fleet", summary: "List the registered instances", response: []FleetMember{}},
+	{method: "GET", path: "/api/inventory", summary: "List the hosts on the network", params: []string{"format: ansible (dynamic inventory JSON) or json"}, response: []InventoryHost{}},
+	{method: "GET", path: "/api/drain", summary: "Show whether this instance is draining", response: apiAnyObject{}},
+	{method: "POST", path: "/api/drain", summary: "Drain this instance for maintenance, exiting once drained with exit", request: apiAnyObject{}, response: apiAnyObject{}},
+	{method: "DELETE", path: "/api/drain", summary: "Stop draining", response: apiAnyObject{}},
+	{method: "GET", path: "/api/backups/", summary: "List the backups at the backup destination, oldest first", response: []string{}},
+	{method: "POST", path: "/api/backups/", summary: "Write a backup of all data to the backup destination now", response: map[string]string{}, status: http.StatusCreated},
+	{method: "GET", path: "/api/backups/{name}", summary: "Download a backup, as gzipped JSON for netcorectl restore", response: ""},
+	{method: "GET", path: "/api/schema", summary: "Show the schema version of the data and the migrations this release would make to it", response: SchemaStatus{}},
+	{method: "POST", path: "/api/schema", summary: "Make the pending migrations of the data, in order", response: []SchemaMigration{}},
+}
+
+// apiSchemaNames names the schemas of unexported types
+var apiSchemaNames = map[string]string{
+	"apiDNSSECKey":        "DNSSECKey",
+	"dnsRRSet":            "RRSet",
+	"dnsRRSetValue":       "RRSetValue",
+	"subnetResource":      "SubnetResource",
+	"reservationResource": "Reservation",
+}
+
+// apiOpenAPI serves the OpenAPI 3 document of the admin API, which needs no
+// token.
+//
+//	GET /api/openapi.json
+func apiOpenAPI(w http.ResponseWriter, r *http.Request) {
+	apiWriteJSON(w, http.StatusOK, openAPIDocument())
+}
+
+// WriteOpenAPI writes the OpenAPI 3 document of the admin API to w, for
+// the generator of client packages
+func WriteOpenAPI(w io.Writer) error {
+	document, err := json.MarshalIndent(openAPIDocument(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(document)
+	return err
+}
+
+// openAPIDocument builds the OpenAPI 3 document from apiOperations
+func openAPIDocument() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"error"},
+			"properties": map[string]interface{}{
+				"error": map[string]interface{}{"type": "string"},
+				"field": map[string]interface{}{"type": "string", "description": "the field at fault in an invalid request"},
+			},
+		},
+	}
+	paths := make(map[string]map[string]interface{})
+	for _, op := range apiOperations {
+		operation := map[string]interface{}{
+			"summary":     op.summary,
+			"operationId": openAPIOperationID(op.method, op.path),
+			"tags":        []string{strings.SplitN(strings.TrimPrefix(strings.TrimPrefix(op.path, "/"), "api/"), "/", 2)[0]},
+		}
+		var parameters []interface{}
+		for _, segment := range strings.Split(op.path, "/") {
+			if strings.HasPrefix(segment, "{") {
+				parameters = append(parameters, map[string]interface{}{
+					"name": strings.Trim(segment, "{}"), "in": "path", "required": true,
+					"schema": map[string]string{"type": "string"},
+				})
+			}
+		}
+		for _, param := range op.params {
+			parts := strings.SplitN(param, ": ", 2)
+			parameters = append(parameters, map[string]interface{}{
+				"name": parts[0], "in": "query", "description": parts[1],
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(op.request), schemas)}},
+			}
+		}
+		responses := map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "an error: 400 for invalid requests, 401, 403, 404, 409 for conflicts, 503 when the database is unavailable",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/Error"}}},
+			},
+		}
+		switch {
+		case op.response == nil:
+			responses["204"] = map[string]interface{}{"description": "done"}
+		default:
+			status := op.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(op.response), schemas)}},
+			}
+		}
+		operation["responses"] = responses
+		if op.path == "/healthz" || op.path == "/readyz" || op.path == "/api/openapi.json" {
+			operation["security"] = []interface{}{}
+		} else if !op.tenants {
+			operation["description"] = "Requires the admin token."
+		}
+		if paths[op.path] == nil {
+			paths[op.path] = make(map[string]interface{})
+		}
+		paths[op.path][strings.ToLower(op.method)] = operation
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "netcore admin API",
+			"version":     version,
+			"description": "Manages the DNS and DHCP data of a netcore cluster. Requests carry the admin token or a tenant token as a bearer token.",
+		},
+		"paths":      paths,
+		"security":   []interface{}{map[string][]string{"bearer": {}}},
+		"components": map[string]interface{}{"schemas": schemas, "securitySchemes": map[string]interface{}{"bearer": map[string]string{"type": "http", "scheme": "bearer"}}},
+	}
+}
+
+// openAPIOperationID names an operation for generated clients, such as
+// getApiDnsZonesZoneRecords
+func openAPIOperationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool { return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') }) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// openAPISchema describes t as encoding/json encodes it. Named structs are
+// added to schemas and referred to.
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return map[string]interface{}{"type": "integer", "format": "int64", "description": "nanoseconds"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return openAPISchema(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]interface{}{"type": "object"}
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := t.Name()
+		if renamed, ok := apiSchemaNames[name]; ok {
+			name = renamed
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, done := schemas[name]; !done {
+			schemas[name] = nil // being described, for types that refer to themselves
+			properties := make(map[string]interface{})
+			var required []string
+			openAPIProperties(t, properties, &required, schemas)
+			schema := map[string]interface{}{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				sort.Strings(required)
+				schema["required"] = required
+			}
+			schemas[name] = schema
+		}
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+// openAPIProperties adds the fields of struct t to properties, including
+// those of embedded structs as encoding/json does
+func openAPIProperties(t reflect.Type, properties map[string]interface{}, required *[]string, schemas map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			openAPIProperties(embedded, properties, required, schemas)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = openAPISchema(field.Type, schemas)
+		if !strings.Contains(tag, ",omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package netcore
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// drainSignals make the instance drain and exit, see drain.go
+var drainSignals = []os.Signal{syscall.SIGUSR1}
+
+// handoverSignals make the instance hand its sockets over to a new process,
+// see handover.go
+var handoverSignals = []os.Signal{syscall.SIGUSR2}
+
+// defaultSnapshotPath is where the local snapshot is kept unless -snapshot
+// says otherwise
+const defaultSnapshotPath = "/var/lib/netcore/snapshot.json"
+
+// machineIDPaths are where the operating system may keep the ID of this
+// installation
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// shellCommand runs command with the system shell
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", command)
+}
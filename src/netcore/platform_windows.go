@@ -0,0 +1,25 @@
+package netcore
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Windows has no SIGUSR1 or SIGUSR2: drain through the API or netcorectl,
+// and restart instead of handing over
+var drainSignals, handoverSignals []os.Signal
+
+// defaultSnapshotPath is where the local snapshot is kept unless -snapshot
+// says otherwise
+var defaultSnapshotPath = filepath.Join(os.Getenv("ProgramData"), "netcore", "snapshot.json")
+
+// machineIDPaths are where the operating system may keep the ID of this
+// installation; Windows keeps it in the registry, so hosts are recognised by
+// their MAC addresses and hostname
+var machineIDPaths []string
+
+// shellCommand runs command with the system shell
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
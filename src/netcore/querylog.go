@@ -0,0 +1,235 @@
+package netcore
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	queryLogSize    = Flags.Int("querylogsize", 1000, "How many recent DNS queries are kept in memory for the live query log of the admin API and web UI (0 to keep none).")
+	queryLogSample  = Flags.Int("querylogsample", 1, "Log only 1 in this many DNS queries, in the query log and in the per-query lines of the operational log.")
+	queryLogClients = Flags.String("querylogclients", "full", "How clients appear in logs of DNS queries: full, truncate (to their /24 or /48), hash (keyed with a secret that changes at each start, so a client can be followed without its address showing) or none.")
+	queryLogExclude = Flags.String("querylogexclude", "", "Comma-separated list of domains whose queries, with those for their subdomains, are never logged.")
+)
+
+// checkQueryLogPolicy reports query log settings we don't know
+func checkQueryLogPolicy() error {
+	if *queryLogSample < 1 {
+		return fmt.Errorf("-querylogsample must be 1 or more, not %d", *queryLogSample)
+	}
+	switch *queryLogClients {
+	case "full", "truncate", "hash", "none":
+		return nil
+	}
+	return fmt.Errorf("-querylogclients must be full, truncate, hash or none, not %q", *queryLogClients)
+}
+
+var (
+	queryLogSeen     uint64 // queries considered for logging, for sampling
+	queryLogExcluded struct {
+		sync.Once
+		domains []string
+	}
+	queryLogHashKey struct {
+		sync.Once
+		key []byte
+	}
+)
+
+// queryLogged decides whether a query is logged: it must be picked by
+// -querylogsample, and none of its questions may be for an excluded domain
+func queryLogged(req *dns.Msg) bool {
+	queryLogExcluded.Do(func() {
+		for _, domain := range strings.Split(*queryLogExclude, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				queryLogExcluded.domains = append(queryLogExcluded.domains, dns.Fqdn(strings.ToLower(domain)))
+			}
+		}
+	})
+	for _, q := range req.Question {
+		for _, domain := range queryLogExcluded.domains {
+			if dns.IsSubDomain(domain, strings.ToLower(q.Name)) {
+				return false
+			}
+		}
+	}
+	if n := uint64(*queryLogSample); n > 1 {
+		return atomic.AddUint64(&queryLogSeen, 1)%n == 0
+	}
+	return true
+}
+
+// queryLogClient is how a client appears in logs of DNS queries, as
+// -querylogclients says
+func queryLogClient(addr net.Addr) string {
+	ip := addrIP(addr)
+	if ip == nil {
+		return ""
+	}
+	switch *queryLogClients {
+	case "truncate":
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	case "hash":
+		queryLogHashKey.Do(func() {
+			queryLogHashKey.key = make([]byte, 32)
+			rand.Read(queryLogHashKey.key)
+		})
+		mac := hmac.New(sha256.New, queryLogHashKey.key)
+		mac.Write(ip.To16())
+		return hex.EncodeToString(mac.Sum(nil)[:8])
+	case "none":
+		return ""
+	}
+	return ip.String()
+}
+
+// QueryLogEntry is one question of a DNS query and how it was answered
+type QueryLogEntry struct {
+	Seq      uint64    `json:"seq"` // from 1, increasing by one with each entry
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Rcode    string    `json:"rcode"`
+	Answers  int       `json:"answers"`
+	Duration float64   `json:"duration_ms"`
+	// What DHCP knows of the client, only logged with -querylogclients full
+	Hostname string `json:"hostname,omitempty"`
+	MAC      string `json:"mac,omitempty"`
+	Class    string `json:"class,omitempty"`
+}
+
+// queryLogRing keeps the latest queries, for clients that poll for those
+// that follow the last one they saw
+type queryLogRing struct {
+	sync.Mutex
+	entries []QueryLogEntry // a ring of up to -querylogsize entries
+	count   uint64          // entries ever recorded, the sequence number of the last
+}
+
+var queryLog = &queryLogRing{}
+
+// Record logs each question of a query that queryLogged picked, answered
+// with rcode and answers, and ships it to the -querylogsink sinks
+func (l *queryLogRing) Record(client net.Addr, req *dns.Msg, rcode int, answers int, start time.Time) {
+	size := *queryLogSize
+	if size <= 0 && len(queryLogSinks) == 0 {
+		return
+	}
+	clientName := queryLogClient(client)
+	var device *ClientDevice
+	if *queryLogClients == "full" {
+		device = clientDevices.Lookup(addrIP(client))
+	}
+	duration := msElapsed(start, time.Now())
+	l.Lock()
+	defer l.Unlock()
+	for _, q := range req.Question {
+		entry := QueryLogEntry{
+			Seq:      l.count + 1,
+			Time:     start.UTC(),
+			Client:   clientName,
+			Name:     q.Name,
+			Type:     dns.Type(q.Qtype).String(),
+			Rcode:    dns.RcodeToString[rcode],
+			Answers:  answers,
+			Duration: duration,
+		}
+		if device != nil {
+			entry.Hostname, entry.MAC, entry.Class = device.Hostname, device.MAC, device.Class
+		}
+		if len(queryLogSinks) > 0 {
+			if line, err := json.Marshal(entry); err == nil {
+				queryLogSinks.Send(logLine{Time: start, Text: string(line)})
+			}
+		}
+		if size <= 0 {
+			continue
+		}
+		if len(l.entries) < size {
+			l.entries = append(l.entries, entry)
+		} else {
+			l.entries[l.count%uint64(len(l.entries))] = entry
+		}
+		l.count++
+	}
+}
+
+// After returns up to limit entries that follow seq, oldest first, and the
+// sequence number of the last of them, or of the last entry seen before
+func (l *queryLogRing) After(seq uint64, limit int) ([]QueryLogEntry, uint64) {
+	l.Lock()
+	defer l.Unlock()
+	if seq > l.count {
+		seq = l.count // the log started over with a restart
+	}
+	n := uint64(len(l.entries))
+	from := seq + 1
+	if oldest := l.count - n + 1; from < oldest {
+		from = oldest
+	}
+	entries := []QueryLogEntry{}
+	for s := from; s <= l.count && len(entries) < limit; s++ {
+		entries = append(entries, l.entries[(s-1)%n])
+	}
+	if len(entries) > 0 {
+		seq = entries[len(entries)-1].Seq
+	}
+	return entries, seq
+}
+
+// apiQueryLog returns the DNS queries that follow the "last" of a previous
+// response, or the latest ones without it. Only the admin may see other
+// people's traffic.
+//
+//	GET /api/dns/querylog?after=<seq>&limit=100
+func apiQueryLog(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may view the query log"))
+		return
+	}
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			apiWriteError(w, http.StatusBadRequest, errors.New("limit must be a non-negative number"))
+			return
+		}
+	}
+	var after uint64
+	if value := r.URL.Query().Get("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			apiWriteError(w, http.StatusBadRequest, errors.New("after must be a sequence number"))
+			return
+		}
+	} else {
+		queryLog.Lock()
+		if queryLog.count > uint64(limit) {
+			after = queryLog.count - uint64(limit)
+		}
+		queryLog.Unlock()
+	}
+	entries, last := queryLog.After(after, limit)
+	apiWriteJSON(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"last":    last,
+	})
+}
@@ -0,0 +1,140 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dustywilson/dnscache"
+	"github.com/miekg/dns"
+)
+
+// QueryTrace explains how the DNS chain would answer a question, stage by
+// stage. A request carrying one is a dry run: handlers note their decisions
+// in it, and must neither change state, such as counters, the cache or the
+// incident log, nor send packets. A nil *QueryTrace ignores notes, as for
+// every real query.
+type QueryTrace struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Client     string           `json:"client,omitempty"`
+	DNSSEC     bool             `json:"dnssec"`
+	Steps      []QueryTraceStep `json:"steps"`
+	Rcode      string           `json:"rcode"`
+	Answer     []string         `json:"answer"`
+	Authority  []string         `json:"authority,omitempty"`
+	Additional []string         `json:"additional,omitempty"`
+
+	active []int // indexes of the steps being served, innermost last
+}
+
+// QueryTraceStep is a handler of the chain seeing a question
+type QueryTraceStep struct {
+	Handler  string   `json:"handler"`
+	Question string   `json:"question"`
+	Notes    []string `json:"notes,omitempty"`
+	Passed   bool     `json:"passed"`  // the handler consulted the rest of the chain
+	Records  int      `json:"records"` // how many records it returned
+}
+
+// Note explains a decision of the handler now serving the question
+func (t *QueryTrace) Note(format string, args ...interface{}) {
+	if t == nil || len(t.active) == 0 {
+		return
+	}
+	step := &t.Steps[t.active[len(t.active)-1]]
+	step.Notes = append(step.Notes, fmt.Sprintf(format, args...))
+}
+
+// tracedDNSHandler records the steps of dry runs through a handler of the
+// chain, and otherwise stays out of the way
+type tracedDNSHandler struct {
+	name string
+	next DNSHandler
+}
+
+func (h *tracedDNSHandler) ServeDNSQuestion(r *DNSRequest) []dns.RR {
+	t := r.Trace
+	if t == nil {
+		return h.next.ServeDNSQuestion(r)
+	}
+	i := len(t.Steps)
+	t.Steps = append(t.Steps, QueryTraceStep{Handler: h.name, Question: r.Question.Name + " " + dns.Type(r.Question.Qtype).String()})
+	t.active = append(t.active, i)
+	answers := h.next.ServeDNSQuestion(r)
+	t.active = t.active[:len(t.active)-1]
+	t.Steps[i].Passed = len(t.Steps) > i+1
+	t.Steps[i].Records = len(answers)
+	return answers
+}
+
+// traceDNSQuestion runs a dry run of q from client through chain, and
+// assembles the response as dnsQueryServe would
+func traceDNSQuestion(cfg *Config, chain DNSHandler, q dns.Question, client net.IP, dnssecOK bool) *QueryTrace {
+	t := &QueryTrace{Name: q.Name, Type: dns.Type(q.Qtype).String(), DNSSEC: dnssecOK, Steps: []QueryTraceStep{}, Answer: []string{}}
+	if client != nil {
+		t.Client = client.String()
+	}
+	r := &DNSRequest{
+		Config:   cfg,
+		Question: &q,
+		Start:    time.Now(),
+		Event:    dnscache.Lookup,
+		Client:   client,
+		DNSSEC:   dnssecOK,
+		Trace:    t,
+	}
+	answers, ns, extra := splitReferral(&q, chain.ServeDNSQuestion(r))
+	t.Rcode = dns.RcodeToString[questionRcode(r, answers, ns)]
+	for _, rr := range answers {
+		t.Answer = append(t.Answer, rr.String())
+	}
+	for _, rr := range ns {
+		t.Authority = append(t.Authority, rr.String())
+	}
+	for _, rr := range extra {
+		t.Additional = append(t.Additional, rr.String())
+	}
+	return t
+}
+
+// apiDNSTrace explains how this instance would answer a question from a
+// client, without sending any packets
+func apiDNSTrace(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may trace queries"))
+		return
+	}
+	query := r.URL.Query()
+	name := query.Get("name")
+	if !validDomainName(name) {
+		apiWriteError(w, http.StatusBadRequest, invalid("name", "invalid name %q", name))
+		return
+	}
+	qtype := strings.ToUpper(query.Get("type"))
+	if qtype == "" {
+		qtype = "A"
+	}
+	rrType, ok := dns.StringToType[qtype]
+	if !ok {
+		apiWriteError(w, http.StatusBadRequest, invalid("type", "unknown type %q", qtype))
+		return
+	}
+	var client net.IP
+	if value := query.Get("client"); value != "" {
+		if client = net.ParseIP(value); client == nil {
+			apiWriteError(w, http.StatusBadRequest, invalid("client", "invalid address %q", value))
+			return
+		}
+	}
+	chain := cfg.DNSHandler()
+	if chain == nil {
+		apiWriteError(w, http.StatusServiceUnavailable, errors.New("this instance does not serve DNS"))
+		return
+	}
+	q := dns.Question{Name: dns.Fqdn(name), Qtype: rrType, Qclass: dns.ClassINET}
+	apiWriteJSON(w, http.StatusOK, traceDNSQuestion(cfg, chain, q, client, query.Get("dnssec") == "true"))
+}
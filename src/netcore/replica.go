@@ -0,0 +1,169 @@
+package netcore
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+var replicaMode = Flags.Bool("replica", false, "Serve DNS from an in-memory copy of etcd and refuse every change, for edge resolvers in front of a central read-write cluster; DHCP is disabled.")
+var replicaInterval = Flags.Duration("replicainterval", 30*time.Second, "How often a -replica copies the data it serves from etcd.")
+
+// ErrReplicaReadOnly is returned when attempting to change a replica
+var ErrReplicaReadOnly = unavailableError("This instance is a read-only replica; make changes on the read-write cluster.")
+
+// replicatedTrees are the parts of etcd that a replica needs to answer DNS
+// queries and serve the read-only API
+var replicatedTrees = []string{"config", "dns", "schema", "tenants", "tenanttokens", "tenantzones"}
+
+// ReplicaDB is an EtcdDB whose reads are served from a copy of etcd held in
+// memory, and which refuses every write. Only its own fleet entry is written
+// to the cluster it replicates, along with its host identity.
+type ReplicaDB struct {
+	EtcdDB
+	upstream EtcdDB
+}
+
+// NewReplicaDB copies upstream, and keeps copying it every interval
+func NewReplicaDB(upstream EtcdDB) (*ReplicaDB, error) {
+	replica := &replicaKV{}
+	if err := replica.refresh(upstream.client); err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			time.Sleep(*replicaInterval)
+			if err := replica.refresh(upstream.client); err != nil {
+				logger.Printf("Replica refresh failed: %s\n", err)
+				health.Set("replica", Degraded, "serving a copy from "+replica.age().String()+" ago: "+err.Error())
+				continue
+			}
+			health.Set("replica", Healthy, "")
+		}
+	}()
+	return &ReplicaDB{EtcdDB: EtcdDB{replica}, upstream: upstream}, nil
+}
+
+func (r *ReplicaDB) GetConfig() (*Config, error) {
+	return loadConfig(r, r.client)
+}
+
+func (r *ReplicaDB) RegisterMember(member FleetMember) error {
+	return r.upstream.RegisterMember(member)
+}
+
+func (r *ReplicaDB) GetIdentities() ([]HostIdentity, error) {
+	return r.upstream.GetIdentities()
+}
+
+func (r *ReplicaDB) SaveIdentity(identity HostIdentity) error {
+	return r.upstream.SaveIdentity(identity)
+}
+
+// Campaign never makes a replica the leader, since every duty writes
+func (r *ReplicaDB) Campaign(duty, id string, ttl time.Duration) (bool, error) {
+	return false, nil
+}
+
+// replicaKV serves reads from the latest copy and refuses all writes
+type replicaKV struct {
+	mu      sync.RWMutex
+	current *memKV
+	copied  time.Time
+}
+
+// refresh copies the replicated trees from upstream into a new store, and
+// switches to it once it is complete
+func (r *replicaKV) refresh(upstream etcdClient) error {
+	kv := newMemKV()
+	for _, tree := range replicatedTrees {
+		response, err := upstream.Get(tree, true, true)
+		if etcdKeyNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := copyNode(kv, response.Node); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	r.current, r.copied = kv, time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// copyNode writes node and everything under it to kv, keeping what remains
+// of their TTLs
+func copyNode(kv etcdKV, node *etcd.Node) error {
+	var ttl uint64
+	if node.Expiration != nil {
+		remaining := node.Expiration.Sub(time.Now())
+		if remaining <= 0 {
+			return nil
+		}
+		ttl = uint64(remaining.Seconds() + 0.5)
+	}
+	key := strings.TrimPrefix(node.Key, "/")
+	if !node.Dir {
+		_, err := kv.Set(key, node.Value, ttl)
+		return err
+	}
+	if _, err := kv.CreateDir(key, ttl); err != nil && !etcdKeyExists(err) {
+		return err
+	}
+	for _, child := range node.Nodes {
+		if err := copyNode(kv, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *replicaKV) age() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return time.Since(r.copied)
+}
+
+func (r *replicaKV) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	r.mu.RLock()
+	kv := r.current
+	r.mu.RUnlock()
+	return kv.Get(key, sort, recursive)
+}
+
+func (r *replicaKV) Set(key string, value string, ttl uint64) (*etcd.Response, error) {
+	return nil, ErrReplicaReadOnly
+}
+
+func (r *replicaKV) CreateDir(key string, ttl uint64) (*etcd.Response, error) {
+	return nil, ErrReplicaReadOnly
+}
+
+func (r *replicaKV) Create(key string, value string, ttl uint64) (*etcd.Response, error) {
+	return nil, ErrReplicaReadOnly
+}
+
+func (r *replicaKV) CreateInOrder(dir string, value string, ttl uint64) (*etcd.Response, error) {
+	return nil, ErrReplicaReadOnly
+}
+
+func (r *replicaKV) Delete(key string, recursive bool) (*etcd.Response, error) {
+	return nil, ErrReplicaReadOnly
+}
+
+func (r *replicaKV) DeleteDir(key string) (*etcd.Response, error) {
+	return nil, ErrReplicaReadOnly
+}
+
+func (r *replicaKV) CompareAndSwap(key string, value string, ttl uint64, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	return nil, ErrReplicaReadOnly
+}
+
+func (r *replicaKV) CompareAndDelete(key string, prevValue string, prevIndex uint64) (*etcd.Response, error) {
+	return nil, ErrReplicaReadOnly
+}
@@ -0,0 +1,212 @@
+package netcore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// resolverZone is a zone that a recursive resolver is told to ask netcore
+// about
+type resolverZone struct {
+	Name   string
+	Signed bool
+}
+
+// resolverServer is where a recursive resolver reaches netcore
+type resolverServer struct {
+	IP   net.IP
+	Port string
+}
+
+// parseResolverServer reads an address, with or without a port
+func parseResolverServer(s string) (resolverServer, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		host, port = strings.Trim(s, "[]"), "53"
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		return resolverServer{}, fmt.Errorf("%q is not the address of a server", s)
+	}
+	return resolverServer{IP: ip, Port: port}, nil
+}
+
+// renderResolverConf writes the configuration that has BIND or Unbound
+// send the questions for zones to servers. It only depends on its
+// arguments, so that it stays the same until a zone is added or removed.
+func renderResolverConf(format string, zones []resolverZone, servers []resolverServer) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "bind":
+		fmt.Fprintf(&buf, "// Zones served by netcore; generated, do not edit.\n")
+		// static-stub only reaches servers on port 53
+		stub := true
+		for _, s := range servers {
+			stub = stub && s.Port == "53"
+		}
+		var unsigned []string
+		for _, zone := range zones {
+			fmt.Fprintf(&buf, "zone %q {\n", dns.Fqdn(zone.Name))
+			if stub {
+				fmt.Fprintf(&buf, "\ttype static-stub;\n\tserver-addresses {")
+				for _, s := range servers {
+					fmt.Fprintf(&buf, " %s;", s.IP)
+				}
+			} else {
+				fmt.Fprintf(&buf, "\ttype forward;\n\tforward only;\n\tforwarders {")
+				for _, s := range servers {
+					fmt.Fprintf(&buf, " %s port %s;", s.IP, s.Port)
+				}
+			}
+			fmt.Fprintf(&buf, " };\n};\n")
+			if !zone.Signed {
+				unsigned = append(unsigned, fmt.Sprintf("%q;", dns.Fqdn(zone.Name)))
+			}
+		}
+		if len(unsigned) > 0 {
+			fmt.Fprintf(&buf, "// Unsigned zones; if a parent zone is signed, add to options:\n//\tvalidate-except { %s };\n", strings.Join(unsigned, " "))
+		}
+	case "unbound":
+		fmt.Fprintf(&buf, "# Zones served by netcore; generated, do not edit.\nserver:\n")
+		for _, zone := range zones {
+			name := dns.Fqdn(zone.Name)
+			fmt.Fprintf(&buf, "\tprivate-domain: %q\n", name)
+			if !zone.Signed {
+				fmt.Fprintf(&buf, "\tdomain-insecure: %q\n", name)
+			}
+			if strings.HasSuffix(name, ".in-addr.arpa.") || strings.HasSuffix(name, ".ip6.arpa.") {
+				// Unbound answers private reverse zones itself by default
+				fmt.Fprintf(&buf, "\tlocal-zone: %q transparent\n", name)
+			}
+		}
+		for _, zone := range zones {
+			fmt.Fprintf(&buf, "stub-zone:\n\tname: %q\n", dns.Fqdn(zone.Name))
+			for _, s := range servers {
+				fmt.Fprintf(&buf, "\tstub-addr: %s@%s\n", s.IP, s.Port)
+			}
+		}
+	default:
+		return nil, invalid("format", "must be bind or unbound, not %q", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// resolverZones returns the zones with an SOA, and whether each is signed
+func resolverZones(cfg *Config) ([]resolverZone, error) {
+	records, err := cfg.db.ListDNSZone("")
+	if err != nil {
+		return nil, err
+	}
+	keys, err := cfg.db.ListDNSSECKeys("")
+	if err != nil {
+		return nil, err
+	}
+	signed := make(map[string]bool)
+	for _, key := range keys {
+		signed[cleanFQDN(key.Zone)] = true
+	}
+	var zones []resolverZone
+	for _, record := range records {
+		if record.Type != "SOA" {
+			continue
+		}
+		zone := resolverZone{Name: record.Name, Signed: signed[cleanFQDN(record.Name)]}
+		if !zone.Signed {
+			at, err := cfg.db.DNSSECSignaturesImported(record.Name)
+			if err != nil {
+				return nil, err
+			}
+			zone.Signed = !at.IsZero()
+		}
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+// fleetResolverServers returns the DNS addresses of the live instances
+// that listen on a specific address, in order
+func fleetResolverServers(cfg *Config) ([]resolverServer, error) {
+	members, err := cfg.db.GetFleet()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var addresses []string
+	for _, member := range members {
+		if address := member.Listen["dns"]; member.Alive && address != "" && !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	var servers []resolverServer
+	for _, address := range addresses {
+		if server, err := parseResolverServer(address); err == nil {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// apiResolverConf renders the stub zones that have BIND or Unbound ask this
+// cluster about the zones it serves, with the format query parameter, and
+// the servers to ask in server, or the instances of the fleet that listen
+// on a specific address without it.
+//
+//	GET /api/dns/resolverconf?format=unbound&server=10.0.0.53,10.0.1.53
+func apiResolverConf(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may render resolver configuration"))
+		return
+	}
+	if r.Method != "GET" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	var servers []resolverServer
+	var err error
+	if list := r.URL.Query().Get("server"); list != "" {
+		for _, s := range strings.Split(list, ",") {
+			server, err := parseResolverServer(strings.TrimSpace(s))
+			if err != nil {
+				apiWriteError(w, http.StatusBadRequest, invalid("server", "%s", err))
+				return
+			}
+			servers = append(servers, server)
+		}
+	} else if servers, err = fleetResolverServers(cfg); err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if len(servers) == 0 {
+		apiWriteError(w, http.StatusBadRequest, invalid("server", "no instance listens on a specific address; give the addresses to ask"))
+		return
+	}
+	zones, err := resolverZones(cfg)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	sort.Sort(byResolverZone(zones))
+	conf, err := renderResolverConf(r.URL.Query().Get("format"), zones, servers)
+	if err != nil {
+		apiWriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(conf)
+}
+
+// byResolverZone orders zones by name
+type byResolverZone []resolverZone
+
+func (z byResolverZone) Len() int           { return len(z) }
+func (z byResolverZone) Swap(i, j int)      { z[i], z[j] = z[j], z[i] }
+func (z byResolverZone) Less(i, j int) bool { return z[i].Name < z[j].Name }
@@ -0,0 +1,76 @@
+package netcore
+
+import "fmt"
+
+// SchemaDB tracks the version of the layout of the data, and brings data
+// written by earlier releases up to the layout of this one
+type SchemaDB interface {
+	// SchemaStatus returns the version of the data, and the migrations
+	// this release would make to it
+	SchemaStatus() (*SchemaStatus, error)
+	// MigrateSchema makes the pending migrations in order, recording the
+	// version after each, and returns those it made
+	MigrateSchema(actor string) ([]SchemaMigration, error)
+}
+
+// SchemaMigration changes the layout of the data to that of Version. A
+// migration must be safe to run while instances serve the data, and to run
+// again if it was interrupted.
+type SchemaMigration struct {
+	Version int    `json:"version"`
+	Summary string `json:"summary"`
+	migrate func(db EtcdDB) error
+}
+
+// SchemaStatus is the version of the data, the latest that this release
+// knows, and the migrations between them
+type SchemaStatus struct {
+	Version   int               `json:"version"`
+	Supported int               `json:"supported"`
+	Pending   []SchemaMigration `json:"pending,omitempty"`
+}
+
+// schemaMigrations are the changes to the layout of the data, in order; the
+// data of a release before versions were recorded is at version 0, and data
+// written from scratch is at the version of the last migration
+var schemaMigrations = []SchemaMigration{
+	{Version: 1, Summary: "store each DNS RRset as one value rather than a key per value", migrate: func(db EtcdDB) error {
+		_, err := db.MigrateDNSRRSets()
+		return err
+	}},
+}
+
+// schemaVersion is the version of the layout this release writes
+func schemaVersion() int {
+	return schemaMigrations[len(schemaMigrations)-1].Version
+}
+
+// errSchemaTooNew refuses data whose layout this release does not know
+func errSchemaTooNew(version int) error {
+	return fmt.Errorf("the data is at schema version %d, newer than version %d that this release knows; run a newer netcore, or restore a backup taken before the upgrade", version, schemaVersion())
+}
+
+// checkSchema refuses to run against data at a newer version than this
+// release knows, and reports data that is waiting for `netcorectl migrate`
+func checkSchema(db SchemaDB) error {
+	status, err := db.SchemaStatus()
+	if ErrorKind(err) == ErrBackendUnavailable {
+		// The data may still be served from a snapshot
+		logger.Printf("Unable to check the schema version of the data: %s\n", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status.Version > status.Supported {
+		return errSchemaTooNew(status.Version)
+	}
+	if len(status.Pending) > 0 {
+		reason := fmt.Sprintf("the data is at schema version %d, %d migrations behind this release; run netcorectl migrate", status.Version, len(status.Pending))
+		logger.Printf("%s\n", reason)
+		health.Set("schema", Degraded, reason)
+	} else {
+		health.Set("schema", Healthy, "")
+	}
+	return nil
+}
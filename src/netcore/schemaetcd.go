@@ -0,0 +1,94 @@
+package netcore
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+)
+
+const (
+	schemaKey     = "schema"
+	schemaLockKey = "locks/schema"
+	schemaLockTTL = 3600 // seconds, in case the migrating instance dies
+)
+
+// SchemaStatus reads the version of the data from etcd. Data without a
+// version is that of a release from before versions were recorded, unless
+// there are no DNS records yet: then it is new, and recorded at the version
+// of this release.
+func (db EtcdDB) SchemaStatus() (*SchemaStatus, error) {
+	version := 0
+	response, err := db.client.Get(schemaKey, false, false)
+	switch {
+	case err == nil:
+		if version, err = strconv.Atoi(response.Node.Value); err != nil {
+			return nil, fmt.Errorf("unreadable schema version %q", response.Node.Value)
+		}
+	case !etcdKeyNotFound(err):
+		return nil, err
+	default:
+		if _, err := db.client.Get("dns", false, false); etcdKeyNotFound(err) {
+			version = schemaVersion()
+			if _, err := db.client.Create(schemaKey, strconv.Itoa(version), 0); err != nil && !etcdKeyExists(err) {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	status := &SchemaStatus{Version: version, Supported: schemaVersion()}
+	for _, m := range schemaMigrations {
+		if m.Version > version {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+	return status, nil
+}
+
+// MigrateSchema makes the pending migrations, one instance at a time. Each
+// is recorded as it completes, so that an interrupted run resumes with the
+// migration it was making.
+func (db EtcdDB) MigrateSchema(actor string) ([]SchemaMigration, error) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	id := fmt.Sprintf("%x", b)
+	if _, err := db.client.Create(schemaLockKey, id, schemaLockTTL); etcdKeyExists(err) {
+		return nil, conflictError("Another migration is in progress; try again once it is done.")
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, err := db.client.CompareAndDelete(schemaLockKey, id, 0); err != nil {
+			logger.Printf("[SCHEMA] Unable to release the lock: %s\n", err)
+		}
+	}()
+
+	status, err := db.SchemaStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status.Version > status.Supported {
+		return nil, errSchemaTooNew(status.Version)
+	}
+	var made []SchemaMigration
+	for _, m := range status.Pending {
+		logger.Printf("[SCHEMA] Migrating to version %d: %s\n", m.Version, m.Summary)
+		if err := m.migrate(db); err != nil {
+			return made, fmt.Errorf("migrating to schema version %d: %s", m.Version, err)
+		}
+		if _, err := db.client.Set(schemaKey, strconv.Itoa(m.Version), 0); err != nil {
+			return made, err
+		}
+		auditChange(db, actor, "schema", "version", "migrate", strconv.Itoa(status.Version), strconv.Itoa(m.Version))
+		events.Publish(Event{
+			Type:     "schema.migrated",
+			Severity: "info",
+			Message:  fmt.Sprintf("the data is now at schema version %d: %s", m.Version, m.Summary),
+			Data:     map[string]string{"version": strconv.Itoa(m.Version)},
+		})
+		status.Version = m.Version
+		made = append(made, m)
+	}
+	health.Set("schema", Healthy, "")
+	return made, nil
+}
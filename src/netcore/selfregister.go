@@ -0,0 +1,148 @@
+package netcore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	selfRegister  = Flags.Bool("selfregister", true, "Publish A, AAAA and PTR records for this instance's addresses under its own names, so that a new network resolves its servers from the start.")
+	selfNames     = Flags.String("selfnames", "", "Comma-separated names, besides <hostname>.<domain> and the NS names of our zones whose first label is this host's, that -selfregister publishes, such as ns1.example.com.")
+	selfAddresses = Flags.String("selfaddresses", "", "Comma-separated addresses that -selfregister publishes; defaults to the -dnslisten address, or else to those of the network interfaces.")
+)
+
+// checkSelfAddresses validates -selfaddresses
+func checkSelfAddresses() error {
+	_, err := parseSelfAddresses()
+	return err
+}
+
+func parseSelfAddresses() ([]net.IP, error) {
+	var ips []net.IP
+	for _, text := range strings.Split(*selfAddresses, ",") {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return nil, fmt.Errorf("-selfaddresses must be a list of addresses, not %q", *selfAddresses)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// selfRegisterSetup publishes the addresses of this instance under its own
+// names, and renews them every heartbeat. They expire with the fleet entry
+// once the instance stops, and replicas, which cannot write, publish none.
+func selfRegisterSetup(cfg *Config) {
+	if !*selfRegister || *replicaMode {
+		return
+	}
+	go func() {
+		for {
+			registerSelf(cfg)
+			time.Sleep(*heartbeatInterval)
+		}
+	}()
+}
+
+// registerSelf publishes the addresses of this instance once. The PTR
+// records of the addresses point back at the first name.
+func registerSelf(cfg *Config) {
+	addresses, err := ownAddresses()
+	if err != nil {
+		logger.Printf("[SELF] Unable to list the addresses of this instance: %s\n", err)
+		return
+	}
+	records, err := cfg.db.ListDNSZone("")
+	if err != nil {
+		logger.Printf("[SELF] Unable to list our zones: %s\n", err)
+		return
+	}
+	expiration := uint64(fleetMissedHeartbeats*heartbeatInterval.Seconds() + 0.5)
+	for i, name := range ownNames(cfg, records) {
+		for _, ip := range addresses {
+			if err := cfg.db.RegisterAddress("self", name, ip, i == 0, expiration); err != nil {
+				logger.Printf("[SELF] Unable to register %s at %s: %s\n", name, ip, err)
+			}
+		}
+	}
+}
+
+// ownNames returns the names of this instance that are in our zones, given
+// their records: its hostname in the zone's domain, -selfnames, and the
+// name servers of our zones whose first label is the hostname's
+func ownNames(cfg *Config, records []DNSRecord) []string {
+	var zones []string
+	for _, r := range records {
+		if r.Type == "SOA" {
+			zones = append(zones, dns.Fqdn(r.Name))
+		}
+	}
+	host := cleanFQDN(cfg.Hostname())
+	short := strings.SplitN(host, ".", 2)[0]
+	var candidates []string
+	switch {
+	case strings.Contains(host, "."):
+		candidates = append(candidates, host)
+	case cfg.Domain() != "":
+		candidates = append(candidates, host+"."+cleanFQDN(cfg.Domain()))
+	}
+	candidates = append(candidates, strings.Split(*selfNames, ",")...)
+	for _, r := range records {
+		var ns string
+		switch r.Type {
+		case "NS":
+			ns = r.Value
+		case "SOA":
+			ns = r.Attr["ns"]
+		}
+		if ns != "" && strings.SplitN(cleanFQDN(ns), ".", 2)[0] == short {
+			candidates = append(candidates, ns)
+		}
+	}
+
+	var names []string
+	for _, name := range candidates {
+		name = cleanFQDN(strings.TrimSpace(name))
+		if name == "" || containsString(names, name) {
+			continue
+		}
+		for _, zone := range zones {
+			if dns.IsSubDomain(zone, dns.Fqdn(name)) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// ownAddresses returns the addresses of this instance: -selfaddresses, the
+// address DNS listens on, or else the global addresses of its interfaces
+func ownAddresses() ([]net.IP, error) {
+	if *selfAddresses != "" {
+		return parseSelfAddresses()
+	}
+	if host, _, err := net.SplitHostPort(*dnslisten); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+			return []net.IP{ip}, nil
+		}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips, nil
+}
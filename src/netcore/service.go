@@ -0,0 +1,227 @@
+package netcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Bounds of the TTL of service registrations, in seconds
+const (
+	serviceDefaultTTL = 30
+	serviceMinTTL     = 5
+	serviceMaxTTL     = 3600
+)
+
+// serviceValuePrefix starts the keys of the values that service
+// registrations store, which tells them apart from those DHCP registers
+const serviceValuePrefix = "svc-"
+
+// ServiceRegistration is an instance of a service that registered itself:
+// Name gets an A record for Address, and Service, if set, an SRV record for
+// Name and Port. Both expire after TTL seconds unless the instance sends a
+// heartbeat, which is the same registration again.
+type ServiceRegistration struct {
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Service string `json:"service,omitempty"` // such as _http._tcp.web.example.com
+	Port    uint16 `json:"port,omitempty"`
+	TTL     uint32 `json:"ttl,omitempty"` // seconds, serviceDefaultTTL if 0
+}
+
+// validate checks that the registration is well formed and within its zone
+func (s *ServiceRegistration) validate() error {
+	s.Zone, s.Name, s.Service = cleanFQDN(s.Zone), cleanFQDN(s.Name), cleanFQDN(s.Service)
+	if !validDomainName(s.Zone) {
+		return invalid("zone", "invalid zone %q", s.Zone)
+	}
+	if !validDomainName(s.Name) || !dns.IsSubDomain(dns.Fqdn(s.Zone), dns.Fqdn(s.Name)) {
+		return invalid("name", "%q is not a name in zone %s", s.Name, s.Zone)
+	}
+	if ip := net.ParseIP(s.Address); ip == nil || ip.To4() == nil {
+		return invalid("address", "%q is not an IPv4 address", s.Address)
+	}
+	if s.Service != "" {
+		if !validDomainName(s.Service) || !dns.IsSubDomain(dns.Fqdn(s.Zone), dns.Fqdn(s.Service)) {
+			return invalid("service", "%q is not a name in zone %s", s.Service, s.Zone)
+		}
+		if s.Port == 0 {
+			return invalid("port", "a service needs a port")
+		}
+	}
+	if s.TTL == 0 {
+		s.TTL = serviceDefaultTTL
+	}
+	if s.TTL < serviceMinTTL || s.TTL > serviceMaxTTL {
+		return invalid("ttl", "ttl must be between %d and %d seconds", serviceMinTTL, serviceMaxTTL)
+	}
+	return nil
+}
+
+// records returns the records of the registration
+func (s *ServiceRegistration) records() []DNSRecord {
+	records := []DNSRecord{{Name: s.Name, Type: "A", Value: s.Address}}
+	if s.Service != "" {
+		records = append(records, DNSRecord{Name: s.Service, Type: "SRV", Value: fmt.Sprintf("%s:%d", s.Name, s.Port)})
+	}
+	return records
+}
+
+// ServiceInstance is an SRV record of a service, with how healthy it is
+// known to be: healthy while it renews its registration, unresolved when its
+// target is in the zone but has no address, and unknown otherwise
+type ServiceInstance struct {
+	Target   string     `json:"target"`
+	Port     uint16     `json:"port"`
+	Priority uint16     `json:"priority"`
+	Weight   uint16     `json:"weight"`
+	Status   string     `json:"status"`
+	Expires  *time.Time `json:"expires,omitempty"` // for registered instances
+}
+
+// ServiceGroup is the instances of a service under a name such as
+// _http._tcp.web.example.com, whose Service is _http._tcp
+type ServiceGroup struct {
+	Service   string            `json:"service"`
+	Name      string            `json:"name"`
+	Instances []ServiceInstance `json:"instances"`
+}
+
+// Health of service instances
+const (
+	serviceHealthy    = "healthy"
+	serviceUnresolved = "unresolved"
+	serviceUnknown    = "unknown"
+)
+
+// serviceOf returns the _service._proto labels that start name, or name
+// itself if it does not start with two underscored labels
+func serviceOf(name string) string {
+	labels := strings.SplitN(name, ".", 3)
+	if len(labels) >= 2 && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_") {
+		return labels[0] + "." + labels[1]
+	}
+	return name
+}
+
+// serviceCatalog returns the services under domain, in zone, with the
+// health of their instances
+func serviceCatalog(db DB, zone, domain string) ([]ServiceGroup, error) {
+	groups, err := db.ListDNSServices(domain)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		for j := range groups[i].Instances {
+			instance := &groups[i].Instances[j]
+			switch {
+			case instance.Expires != nil:
+				instance.Status = serviceHealthy
+			case dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(instance.Target)) && !hasAddress(db, instance.Target):
+				instance.Status = serviceUnresolved
+			default:
+				instance.Status = serviceUnknown
+			}
+		}
+	}
+	return groups, nil
+}
+
+// hasAddress reports whether name has A or AAAA records, or a CNAME or
+// ALIAS that may lead to some
+func hasAddress(db DB, name string) bool {
+	for _, rrType := range []string{"A", "AAAA", "CNAME", "ALIAS"} {
+		if found, err := db.HasDNS(name, rrType); err != nil || found {
+			return true
+		}
+	}
+	return false
+}
+
+// apiServices lists the services of a zone, or of a domain within it, and
+// registers instances of services, renews their registrations on heartbeats
+// and removes them. Callers must be able to manage the zone.
+//
+//	GET    /api/dns/services?zone=example.com[&domain=svc.example.com]
+//	POST   /api/dns/services  {"zone": "...", "name": "...", "address": "...", "service": "...", "port": 8080, "ttl": 30}
+//	DELETE /api/dns/services  the same registration
+func apiServices(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		apiServiceCatalog(cfg, id, w, r)
+		return
+	}
+	if r.Method != "POST" && r.Method != "DELETE" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	var s ServiceRegistration
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		apiWriteError(w, http.StatusBadRequest, fmt.Errorf("bad registration: %s", err))
+		return
+	}
+	if err := s.validate(); err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if !id.canManageZone(cfg, s.Zone) {
+		apiWriteError(w, http.StatusForbidden, fmt.Errorf("zone %s does not belong to tenant %q", s.Zone, id.Tenant))
+		return
+	}
+	for _, name := range []string{s.Name, s.Service} {
+		if name != "" && !id.canManageName(cfg, s.Zone, name) {
+			apiWriteError(w, http.StatusForbidden, fmt.Errorf("%s belongs to a zone below %s that is not of tenant %q", name, s.Zone, id.Tenant))
+			return
+		}
+	}
+	if r.Method == "DELETE" {
+		if err := cfg.db.DeregisterService(id.String(), s); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	created, err := cfg.db.RegisterService(id.String(), s)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	apiWriteJSON(w, status, s)
+}
+
+// apiServiceCatalog lists the services of a zone or a domain within it
+func apiServiceCatalog(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	zone := cleanFQDN(r.URL.Query().Get("zone"))
+	if !validDomainName(zone) {
+		apiWriteError(w, http.StatusBadRequest, invalid("zone", "invalid zone %q", zone))
+		return
+	}
+	domain := zone
+	if d := r.URL.Query().Get("domain"); d != "" {
+		domain = cleanFQDN(d)
+		if !validDomainName(domain) || !dns.IsSubDomain(dns.Fqdn(zone), dns.Fqdn(domain)) {
+			apiWriteError(w, http.StatusBadRequest, invalid("domain", "%q is not a domain in zone %s", d, zone))
+			return
+		}
+	}
+	if !id.canManageName(cfg, zone, domain) {
+		apiWriteError(w, http.StatusForbidden, fmt.Errorf("%s does not belong to tenant %q", domain, id.Tenant))
+		return
+	}
+	groups, err := serviceCatalog(cfg.db, zone, domain)
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	apiWriteJSON(w, http.StatusOK, groups)
+}
@@ -0,0 +1,123 @@
+package netcore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/miekg/dns"
+)
+
+// serviceValueID is the ID of the value of a registration's record in its
+// RRset
+func serviceValueID(record DNSRecord) string {
+	return serviceValuePrefix + record.valueID()
+}
+
+// RegisterService stores the records of s, expiring after its TTL, and
+// reports whether they are new. Heartbeats only push the expiration back,
+// so they are not audited, unlike the first registration.
+func (db EtcdDB) RegisterService(actor string, s ServiceRegistration) (bool, error) {
+	created := false
+	now := time.Now()
+	for _, record := range s.records() {
+		id := serviceValueID(record)
+		previous, err := updateDNSRRSet(db.client, record.Name, record.Type, func(entry *DNSEntry) error {
+			entry.setValue(DNSValue{ID: id, Value: record.Value, Expiration: dnsExpiration(uint64(s.TTL), now)})
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		var old *DNSValue
+		if previous != nil {
+			old = previous.value(id)
+		}
+		if old == nil || dnsValueExpired(old, now) {
+			created = true
+			auditChange(db, actor, "dns", record.Name+" "+record.Type, "register", "", record.String())
+		}
+	}
+	if created {
+		if zone, found := findDNSZone(db.client, s.Name); found {
+			if err := db.bumpDNSSerial(zone); err != nil {
+				logger.Printf("[SERVICE] Unable to bump the SOA serial for %s: %s\n", zone, err)
+			}
+		}
+	}
+	return created, nil
+}
+
+// DeregisterService removes the records of s before they expire
+func (db EtcdDB) DeregisterService(actor string, s ServiceRegistration) error {
+	found := false
+	for _, record := range s.records() {
+		removed := false
+		_, err := updateDNSRRSet(db.client, record.Name, record.Type, func(entry *DNSEntry) error {
+			removed = entry.removeValue(serviceValueID(record))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if !removed {
+			continue
+		}
+		found = true
+		auditChange(db, actor, "dns", record.Name+" "+record.Type, "deregister", record.String(), "")
+	}
+	if !found {
+		return notFoundError("%s is not registered", s.Name)
+	}
+	if zone, ok := findDNSZone(db.client, s.Name); ok {
+		if err := db.bumpDNSSerial(zone); err != nil {
+			logger.Printf("[SERVICE] Unable to bump the SOA serial for %s: %s\n", zone, err)
+		}
+	}
+	return nil
+}
+
+// ListDNSServices returns the SRV records of domain and the names below it,
+// grouped by name in name order, leaving out registrations that expired
+func (db EtcdDB) ListDNSServices(domain string) ([]ServiceGroup, error) {
+	response, err := db.client.Get(etcdDNSKeyFromFQDN(domain), true, true)
+	if etcdKeyNotFound(err) {
+		return []ServiceGroup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	groups := []ServiceGroup{}
+	now := time.Now()
+	walkDNSRRSets(response.Node, func(name, rrType string, node *etcd.Node) {
+		if rrType != "SRV" {
+			return
+		}
+		q := &dns.Question{Name: dns.Fqdn(name), Qtype: dns.TypeSRV, Qclass: dns.ClassINET}
+		group := ServiceGroup{Service: serviceOf(name), Name: name}
+		for _, value := range etcdNodeToDNSEntry(node).Values {
+			if value.Expiration != nil && value.Expiration.Before(now) {
+				continue
+			}
+			srv := answerSRV(q, &value).(*dns.SRV)
+			group.Instances = append(group.Instances, ServiceInstance{
+				Target:   cleanFQDN(srv.Target),
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+				Expires:  value.Expiration,
+			})
+		}
+		if len(group.Instances) > 0 {
+			groups = append(groups, group)
+		}
+	})
+	sort.Sort(byServiceName(groups))
+	return groups, nil
+}
+
+type byServiceName []ServiceGroup
+
+func (s byServiceName) Len() int           { return len(s) }
+func (s byServiceName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byServiceName) Less(i, j int) bool { return s[i].Name < s[j].Name }
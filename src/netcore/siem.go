@@ -0,0 +1,168 @@
+package netcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var securityLog = Flags.String("securitylog", "", "Comma-separated list of files that security events are appended to as JSON lines, in the field names of the Elastic Common Schema, for Splunk or Elastic to ingest, or -logsink URLs to ship them to.")
+
+// securityEventTypes are the prefixes of the events that go to -securitylog:
+// policy rules refusing or sinkholing questions, anomalies, rogue DHCP
+// servers, names taken between devices and failed API authentication
+var securityEventTypes = []string{"dns.policy.", "dns.sinkhole", "dns.anomaly.", "dns.rrl.", "dhcp.rogue", "dhcp.hostname.", "api.auth."}
+
+// isSecurityEvent reports whether events of type t go to -securitylog
+func isSecurityEvent(t string) bool {
+	for _, prefix := range securityEventTypes {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// securityEventJSON renders e as one line of JSON with Elastic Common Schema
+// field names, which Splunk reads as they are. The event's data goes under
+// netcore., and its client or source address is source.ip as well.
+func securityEventJSON(e Event, host string) ([]byte, error) {
+	kind := "event"
+	if e.Severity != "info" {
+		kind = "alert"
+	}
+	fields := map[string]string{
+		"@timestamp":    e.Time.UTC().Format(time.RFC3339Nano),
+		"event.kind":    kind,
+		"event.module":  "netcore",
+		"event.dataset": "netcore.security",
+		"event.action":  e.Type,
+		"log.level":     e.Severity,
+		"message":       e.Message,
+		"host.name":     host,
+	}
+	for k, v := range e.Data {
+		fields["netcore."+k] = v
+	}
+	if ip := e.Data["client"]; ip != "" {
+		fields["source.ip"] = ip
+	} else if ip := e.Data["source"]; ip != "" {
+		fields["source.ip"] = ip
+	}
+	return json.Marshal(fields)
+}
+
+// securityLogSinks are where security events go
+var securityLogSinks logSinks
+
+// parseSecurityLog makes the sinks of -securitylog: files for paths, and
+// log sinks for URLs
+func parseSecurityLog(list, host string) (logSinks, error) {
+	var sinks logSinks
+	for _, dest := range strings.Split(list, ",") {
+		dest = strings.TrimSpace(dest)
+		switch {
+		case dest == "":
+			continue
+		case strings.Contains(dest, "://"):
+			sink, err := newLogSink(dest, "security", host)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		default:
+			sinks = append(sinks, &logSink{name: dest, deliver: (&fileLogWriter{path: dest}).deliver})
+		}
+	}
+	return sinks, nil
+}
+
+// securityLogSetup starts writing security events to -securitylog
+func securityLogSetup(cfg *Config) {
+	var err error
+	if securityLogSinks, err = parseSecurityLog(*securityLog, cfg.Hostname()); err != nil {
+		logger.Printf("Security log is disabled: -securitylog: %s\n", err)
+		return
+	}
+	if len(securityLogSinks) == 0 {
+		return
+	}
+	for _, sink := range securityLogSinks {
+		sink.lines = make(chan logLine, *logSinkBuffer)
+		go sink.run()
+	}
+	ch := events.Subscribe(1000)
+	go func() {
+		for e := range ch {
+			if !isSecurityEvent(e.Type) {
+				continue
+			}
+			line, err := securityEventJSON(e, cfg.Hostname())
+			if err != nil {
+				continue
+			}
+			securityLogSinks.Send(logLine{Time: e.Time, Text: string(line)})
+		}
+	}()
+}
+
+// fileLogWriter appends lines to a file, which it opens for each batch so
+// that the file can be rotated from under it
+type fileLogWriter struct {
+	path string
+}
+
+func (w *fileLogWriter) deliver(lines []logLine) error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(f, line.Text); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// eventThrottle lets an event about the same thing through at most once per
+// window, so that a client hammering a refused name, or guessing tokens,
+// makes one event rather than thousands
+type eventThrottle struct {
+	sync.Mutex
+	window time.Duration
+	last   map[string]time.Time
+}
+
+// eventThrottleSize bounds the keys a throttle remembers
+const eventThrottleSize = 10000
+
+func newEventThrottle(window time.Duration) *eventThrottle {
+	return &eventThrottle{window: window, last: make(map[string]time.Time)}
+}
+
+// Allow reports whether an event about key may be published now
+func (t *eventThrottle) Allow(key string) bool {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.window {
+		return false
+	}
+	if len(t.last) >= eventThrottleSize {
+		for k, last := range t.last {
+			if now.Sub(last) >= t.window {
+				delete(t.last, k)
+			}
+		}
+		if len(t.last) >= eventThrottleSize {
+			return false
+		}
+	}
+	t.last[key] = now
+	return true
+}
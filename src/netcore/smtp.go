@@ -1,4 +1,4 @@
-package main
+package netcore
 
 // import (
 // 	"bitbucket.org/chrj/smtpd"
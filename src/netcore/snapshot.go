@@ -0,0 +1,224 @@
+package netcore
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+var snapshotPath = Flags.String("snapshot", defaultSnapshotPath, "Path of the local snapshot of config and DNS data used when etcd is unreachable (empty to disable).")
+
+const snapshotInterval = time.Minute
+
+// ErrSnapshotReadOnly is returned when attempting to write to a snapshot
+var ErrSnapshotReadOnly = unavailableError("The etcd snapshot is read-only.")
+
+// dbSnapshot is the persisted copy of the config and dns trees in etcd
+type dbSnapshot struct {
+	Saved  time.Time  `json:"saved"`
+	Config *etcd.Node `json:"config"`
+	DNS    *etcd.Node `json:"dns"`
+}
+
+// SnapshotDB is an EtcdDB that keeps a local snapshot of the authoritative
+// DNS data and configuration, and answers from that snapshot whenever etcd is
+// unreachable
+type SnapshotDB struct {
+	EtcdDB
+	path     string
+	mu       sync.Mutex
+	snapshot *dbSnapshot
+}
+
+// NewSnapshotDB loads the snapshot at path, if there is one, and starts
+// refreshing it from etcd periodically
+func NewSnapshotDB(db EtcdDB, path string) *SnapshotDB {
+	s := &SnapshotDB{EtcdDB: db, path: path}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		snapshot := &dbSnapshot{}
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			logger.Printf("Ignoring unreadable snapshot %s: %s\n", path, err)
+		} else {
+			s.snapshot = snapshot
+		}
+	}
+	go func() {
+		for {
+			if err := s.refresh(); err != nil {
+				logger.Printf("Snapshot refresh failed: %s\n", err)
+			}
+			time.Sleep(snapshotInterval)
+		}
+	}()
+	return s
+}
+
+// refresh copies the config and dns trees from etcd and persists them
+func (s *SnapshotDB) refresh() error {
+	config, err := s.client.Get("config", true, true)
+	if err != nil {
+		s.failed(err)
+		return err
+	}
+	dns, err := s.client.Get("dns", true, true)
+	if err != nil {
+		s.failed(err)
+		return err
+	}
+	s.succeeded()
+
+	snapshot := &dbSnapshot{
+		Saved:  time.Now(),
+		Config: config.Node,
+		DNS:    dns.Node,
+	}
+	s.mu.Lock()
+	s.snapshot = snapshot
+	s.mu.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// kv returns the snapshot as a read-only key/value store, or nil if there is
+// no snapshot
+func (s *SnapshotDB) kv() etcdKV {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshot == nil {
+		return nil
+	}
+	return snapshotKV{s.snapshot}
+}
+
+// failed flags the backend as degraded if err means etcd is unreachable, and
+// reports whether the snapshot should be used instead
+func (s *SnapshotDB) failed(err error) bool {
+	if !etcdUnreachable(err) {
+		return false
+	}
+	s.mu.Lock()
+	snapshot := s.snapshot
+	s.mu.Unlock()
+	if snapshot == nil {
+		health.Set("etcd", Unhealthy, err.Error())
+		return false
+	}
+	health.Set("etcd", Degraded, "serving from snapshot saved "+snapshot.Saved.Format(time.RFC3339)+": "+err.Error())
+	return true
+}
+
+func (s *SnapshotDB) succeeded() {
+	health.Set("etcd", Healthy, "")
+}
+
+// GetConfig loads the configuration from etcd, or from the snapshot if etcd
+// is unreachable
+func (s *SnapshotDB) GetConfig() (*Config, error) {
+	cfg, err := loadConfig(s, s.client)
+	if s.failed(err) {
+		logger.Printf("etcd is unreachable, loading configuration from snapshot: %s\n", err)
+		return loadConfig(s, s.kv())
+	}
+	if err == nil {
+		s.succeeded()
+	}
+	return cfg, err
+}
+
+// GetDNS looks up a DNS entry in etcd, or in the snapshot if etcd is
+// unreachable
+func (s *SnapshotDB) GetDNS(name string, rrType string) (*DNSEntry, error) {
+	entry, err := s.EtcdDB.GetDNS(name, rrType)
+	if s.failed(err) {
+		return getDNS(s.kv(), name, rrType)
+	}
+	if err == nil || ErrorKind(err) == ErrNotFound {
+		s.succeeded()
+	}
+	return entry, err
+}
+
+// HasDNS checks for a DNS entry in etcd, or in the snapshot if etcd is
+// unreachable
+func (s *SnapshotDB) HasDNS(name string, rrType string) (bool, error) {
+	found, err := s.EtcdDB.HasDNS(name, rrType)
+	if s.failed(err) {
+		return hasDNS(s.kv(), name, rrType)
+	}
+	if err == nil || etcdKeyNotFound(err) {
+		s.succeeded()
+	}
+	return found, err
+}
+
+// HasDNSName checks for a DNS name in etcd, or in the snapshot if etcd is
+// unreachable
+func (s *SnapshotDB) HasDNSName(name string) (bool, error) {
+	found, err := s.EtcdDB.HasDNSName(name)
+	if s.failed(err) {
+		return hasDNSName(s.kv(), strings.TrimPrefix(etcdDNSKeyFromFQDN(name), "/"))
+	}
+	if err == nil {
+		s.succeeded()
+	}
+	return found, err
+}
+
+// snapshotKV serves reads from a snapshot and refuses all writes
+type snapshotKV struct {
+	snapshot *dbSnapshot
+}
+
+func (kv snapshotKV) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	key = "/" + strings.Trim(key, "/")
+	for _, root := range []*etcd.Node{kv.snapshot.Config, kv.snapshot.DNS} {
+		if node := findSnapshotNode(root, key); node != nil {
+			return &etcd.Response{Action: "get", Node: node}, nil
+		}
+	}
+	return nil, errors.New("Key not found in snapshot: " + key)
+}
+
+func (kv snapshotKV) Set(key string, value string, ttl uint64) (*etcd.Response, error) {
+	return nil, ErrSnapshotReadOnly
+}
+
+func (kv snapshotKV) CreateDir(key string, ttl uint64) (*etcd.Response, error) {
+	return nil, ErrSnapshotReadOnly
+}
+
+func findSnapshotNode(node *etcd.Node, key string) *etcd.Node {
+	if node == nil {
+		return nil
+	}
+	if node.Key == key {
+		return node
+	}
+	if !strings.HasPrefix(key, node.Key+"/") {
+		return nil
+	}
+	for _, child := range node.Nodes {
+		if found := findSnapshotNode(child, key); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package netcore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// srvRestoreWeight is the attribute that keeps the weight an SRV target had
+// before it was drained or shifted, until it is restored
+const srvRestoreWeight = "restore-weight"
+
+// SRVWeightChange moves traffic between the targets of an SRV name during
+// maintenance: drain sets Target's weight to 0, shift sets the weights of
+// the targets in Weights, as percentages of their priority's traffic adding
+// up to 100, and restore puts back the weights they had before, of Target
+// or of every target. Only records stored in zones can change, not those
+// that services registered.
+type SRVWeightChange struct {
+	Zone    string            `json:"zone"`
+	Name    string            `json:"name"`
+	Op      string            `json:"op"` // drain, shift or restore
+	Target  string            `json:"target,omitempty"`
+	Weights map[string]uint16 `json:"weights,omitempty"`
+}
+
+// validate checks that the change is well formed and its name within zone
+func (c *SRVWeightChange) validate() error {
+	c.Zone, c.Name, c.Target = cleanFQDN(c.Zone), cleanFQDN(c.Name), cleanFQDN(c.Target)
+	if !validDomainName(c.Zone) {
+		return invalid("zone", "invalid zone %q", c.Zone)
+	}
+	if !validDomainName(c.Name) || !dns.IsSubDomain(dns.Fqdn(c.Zone), dns.Fqdn(c.Name)) {
+		return invalid("name", "%q is not a name in zone %s", c.Name, c.Zone)
+	}
+	switch c.Op {
+	case "drain":
+		if c.Target == "" {
+			return invalid("target", "drain needs the target to drain")
+		}
+	case "shift":
+		weights := make(map[string]uint16)
+		total := 0
+		for target, percent := range c.Weights {
+			if percent > 100 {
+				return invalid("weights", "%s: %d is not a percentage", target, percent)
+			}
+			weights[cleanFQDN(target)] = percent
+			total += int(percent)
+		}
+		if total != 100 {
+			return invalid("weights", "the percentages add up to %d, not 100", total)
+		}
+		c.Weights = weights
+	case "restore":
+	default:
+		return invalid("op", "op must be drain, shift or restore, not %q", c.Op)
+	}
+	return nil
+}
+
+// srvRecords returns the SRV values of name that are stored in its zone,
+// leaving out those that services registered
+func srvRecords(entry *DNSEntry, name string) []DNSRecord {
+	var records []DNSRecord
+	for _, value := range entry.Values {
+		if value.Expiration != nil {
+			continue
+		}
+		records = append(records, DNSRecord{Name: name, Type: "SRV", Value: value.Value, Attr: value.Attr})
+	}
+	return records
+}
+
+// srvAttr returns the attributes of record with its target, port, priority
+// and weight spelled out, whether they were given as attributes or as a
+// target:port value
+func srvAttr(record DNSRecord) map[string]string {
+	q := &dns.Question{Name: dns.Fqdn(record.Name), Qtype: dns.TypeSRV, Qclass: dns.ClassINET}
+	srv := answerSRV(q, &DNSValue{Value: record.Value, Attr: record.Attr}).(*dns.SRV)
+	attr := map[string]string{
+		"target":   cleanFQDN(srv.Target),
+		"port":     strconv.Itoa(int(srv.Port)),
+		"priority": strconv.Itoa(int(srv.Priority)),
+		"weight":   strconv.Itoa(int(srv.Weight)),
+	}
+	for k, v := range record.Attr {
+		if _, ok := attr[k]; !ok {
+			attr[k] = v
+		}
+	}
+	return attr
+}
+
+// planSRVWeights returns the changes that apply c to current, the records
+// of srvRecords, and the records as they will be
+func planSRVWeights(c *SRVWeightChange, current []DNSRecord) ([]DNSChange, []DNSRecord, error) {
+	found := make(map[string]bool)
+	priorities := make(map[string]bool)
+	var changes []DNSChange
+	updated := make([]DNSRecord, 0, len(current))
+	live := 0
+	for _, record := range current {
+		attr := srvAttr(record)
+		target := attr["target"]
+		found[target] = true
+		save := func() {
+			if _, ok := attr[srvRestoreWeight]; !ok {
+				attr[srvRestoreWeight] = attr["weight"]
+			}
+		}
+		switch c.Op {
+		case "drain":
+			if target == c.Target {
+				save()
+				attr["weight"] = "0"
+			}
+		case "shift":
+			if percent, ok := c.Weights[target]; ok {
+				priorities[attr["priority"]] = true
+				save()
+				attr["weight"] = strconv.Itoa(int(percent))
+			}
+		case "restore":
+			if weight, ok := attr[srvRestoreWeight]; ok && (c.Target == "" || target == c.Target) {
+				attr["weight"] = weight
+				delete(attr, srvRestoreWeight)
+			}
+		}
+		if attr["weight"] != "0" {
+			live++
+		}
+		next := DNSRecord{Name: record.Name, Type: "SRV", Attr: attr}
+		updated = append(updated, next)
+		if record.Value != "" || !sameAttr(record.Attr, attr) {
+			changes = append(changes,
+				DNSChange{Op: "delete", Record: record},
+				DNSChange{Op: "add", Record: next})
+		}
+	}
+	if len(current) == 0 {
+		return nil, nil, notFoundError("%s has no SRV records stored in its zone", c.Name)
+	}
+	switch {
+	case c.Target != "" && !found[c.Target]:
+		return nil, nil, invalid("target", "%s is not a target of %s", c.Target, c.Name)
+	case c.Op == "drain" && live == 0:
+		return nil, nil, invalid("target", "draining %s would leave %s without a target with weight", c.Target, c.Name)
+	case c.Op == "shift" && len(priorities) > 1:
+		return nil, nil, invalid("weights", "traffic can only shift between targets of the same priority")
+	}
+	for target := range c.Weights {
+		if !found[target] {
+			return nil, nil, invalid("weights", "%s is not a target of %s", target, c.Name)
+		}
+	}
+	return changes, updated, nil
+}
+
+// sameAttr reports whether a and b hold the same attributes
+func sameAttr(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// apiSRVWeights drains, shifts traffic between and restores the targets of
+// an SRV name, and returns its records as they are then. Callers must be
+// able to manage the zone.
+//
+//	POST /api/dns/srv  {"zone": "...", "name": "...", "op": "drain", "target": "..."}
+//	POST /api/dns/srv  {"zone": "...", "name": "...", "op": "shift", "weights": {"a...": 70, "b...": 30}}
+//	POST /api/dns/srv  {"zone": "...", "name": "...", "op": "restore"[, "target": "..."]}
+func apiSRVWeights(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	var c SRVWeightChange
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		apiWriteError(w, http.StatusBadRequest, fmt.Errorf("bad change: %s", err))
+		return
+	}
+	if err := c.validate(); err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if !id.canManageName(cfg, c.Zone, c.Name) {
+		apiWriteError(w, http.StatusForbidden, fmt.Errorf("%s does not belong to tenant %q", c.Name, id.Tenant))
+		return
+	}
+	entry, err := cfg.db.GetDNS(c.Name, "SRV")
+	if err != nil && ErrorKind(err) == ErrNotFound {
+		entry, err = &DNSEntry{}, nil
+	}
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	changes, updated, err := planSRVWeights(&c, srvRecords(entry, c.Name))
+	if err != nil {
+		apiWriteError(w, apiStatus(err), err)
+		return
+	}
+	if len(changes) > 0 {
+		if err := cfg.db.ApplyDNSChanges(id.String(), changes); err != nil {
+			apiWriteError(w, apiStatus(err), err)
+			return
+		}
+	}
+	apiWriteJSON(w, http.StatusOK, updated)
+}
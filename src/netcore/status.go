@@ -0,0 +1,192 @@
+package netcore
+
+import (
+	"expvar"
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var statuslisten = Flags.String("statuslisten", "", "Listen address for the read-only status page, which needs no token, for wallboards (empty to disable).")
+
+// processStarted is when this instance started, for its uptime
+var processStarted = time.Now()
+
+// rateWindow is how many seconds query rates are averaged over
+const rateWindow = 60
+
+// rateCounter counts events per second over the last rateWindow seconds
+type rateCounter struct {
+	sync.Mutex
+	counts  [rateWindow]uint64
+	seconds [rateWindow]int64 // the second each count is for
+}
+
+var dnsQueryRate = &rateCounter{}
+
+// Add counts an event at t
+func (c *rateCounter) Add(t time.Time) {
+	second := t.Unix()
+	i := second % rateWindow
+	c.Lock()
+	defer c.Unlock()
+	if c.seconds[i] != second {
+		c.seconds[i], c.counts[i] = second, 0
+	}
+	c.counts[i]++
+}
+
+// Rate is the events per second over the complete seconds of the window
+// that ends at now
+func (c *rateCounter) Rate(now time.Time) float64 {
+	last := now.Unix() - 1
+	c.Lock()
+	defer c.Unlock()
+	var total uint64
+	for i, second := range c.seconds {
+		if second <= last && second > last-rateWindow {
+			total += c.counts[i]
+		}
+	}
+	window := last - processStarted.Unix()
+	if window > rateWindow {
+		window = rateWindow
+	}
+	if window < 1 {
+		window = 1
+	}
+	return float64(total) / float64(window)
+}
+
+// expvarInt reads a counter of an expvar map, which Go 1.5 only offers as a
+// string
+func expvarInt(m *expvar.Map, key string) int64 {
+	v := m.Get(key)
+	if v == nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(v.String(), 10, 64)
+	return n
+}
+
+// Status is what the status page shows: nothing that needs a token to see
+type Status struct {
+	Hostname     string            `json:"hostname"`
+	Status       string            `json:"status"`
+	Started      time.Time         `json:"started"`
+	Uptime       string            `json:"uptime"`
+	QPS          float64           `json:"qps"` // over the last minute
+	CacheHitRate float64           `json:"cache_hit_rate"`
+	Components   map[string]string `json:"components"`
+}
+
+// currentStatus reports on this instance
+func currentStatus(cfg *Config) Status {
+	now := time.Now()
+	state, _ := health.State()
+	status := Status{
+		Hostname:   cfg.Hostname(),
+		Status:     state.String(),
+		Started:    processStarted.UTC(),
+		Uptime:     (now.Sub(processStarted) / time.Second * time.Second).String(),
+		QPS:        dnsQueryRate.Rate(now),
+		Components: health.Components(),
+	}
+	if lookups := expvarInt(dnsCacheCounts, "lookups"); lookups > 0 {
+		status.CacheHitRate = 1 - float64(expvarInt(dnsCacheCounts, "misses"))/float64(lookups)
+	}
+	return status
+}
+
+// statusService serves the status page on a listener of its own, so that it
+// can be offered more widely than the admin API
+type statusService struct {
+	serviceStatus
+	addr string
+	mux  *http.ServeMux
+}
+
+// NewStatusService serves the status page on addr
+func NewStatusService(cfg *Config, addr string) Service {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { statusPage(cfg, w, r) })
+	return &statusService{addr: addr, mux: mux}
+}
+
+func (s *statusService) Name() string { return "status" }
+
+func (s *statusService) Start() (<-chan error, error) {
+	l, err := listeners.Listen("status", "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	exit := make(chan error, 1)
+	go func() {
+		exit <- http.Serve(l, s.mux)
+	}()
+	return s.serving(exit, s.close), nil
+}
+
+func (s *statusService) Stop(deadline time.Time) error {
+	s.stop()
+	s.close()
+	return nil
+}
+
+func (s *statusService) close() {
+	listeners.release("status")
+}
+
+// statusPage shows the status of this instance, refreshing itself, or as
+// JSON with ?format=json.
+//
+//	GET /
+func statusPage(cfg *Config, w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	status := currentStatus(cfg)
+	if r.URL.Query().Get("format") == "json" {
+		apiWriteJSON(w, http.StatusOK, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, status); err != nil {
+		logger.Printf("Status page rendering failed: %s\n", err)
+	}
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return strconv.FormatFloat(f*100, 'f', 1, 64) + "%" },
+	"decimal": func(f float64) string { return strconv.FormatFloat(f, 'f', 1, 64) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>{{.Hostname}}: {{.Status}}</title>
+<style>
+body { font: 20px sans-serif; margin: 24px; background: #111; color: #eee; }
+h1 { margin: 0 0 16px; }
+.ok { color: #4c4; } .degraded { color: #ec4; } .unhealthy { color: #e44; }
+.tiles { display: flex; gap: 32px; margin-bottom: 24px; }
+.tile b { display: block; font-size: 48px; }
+td { padding: 4px 16px 4px 0; }
+</style>
+</head>
+<body>
+<h1>{{.Hostname}} <span class="{{.Status}}">{{.Status}}</span></h1>
+<div class="tiles">
+<div class="tile">uptime<b>{{.Uptime}}</b></div>
+<div class="tile">queries/s<b>{{decimal .QPS}}</b></div>
+<div class="tile">cache hits<b>{{percent .CacheHitRate}}</b></div>
+</div>
+<table>
+{{range $name, $state := .Components}}<tr><td>{{$name}}</td><td>{{$state}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,81 @@
+package netcore
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// SubnetDB manages the network settings of zones, which loadConfig reads at
+// startup, as whole objects for the admin API
+type SubnetDB interface {
+	GetSubnet(zone string) (*Subnet, error)
+	ListSubnets() ([]Subnet, error)
+	SaveSubnet(actor string, subnet Subnet) error
+	DeleteSubnet(actor string, zone string) error
+}
+
+// Subnet is the network of a zone: its addresses, its gateway and the pool
+// that DHCP leases from. Durations are in minutes, as in etcd; empty ones
+// take their defaults.
+type Subnet struct {
+	Zone              string `json:"zone"`
+	Domain            string `json:"domain,omitempty"`
+	Subnet            string `json:"subnet"`
+	Gateway           string `json:"gateway"`
+	DHCPSubnet        string `json:"dhcpsubnet,omitempty"`
+	DHCPLeaseDuration string `json:"dhcpleaseduration,omitempty"`
+	DHCPRetention     string `json:"dhcpretention,omitempty"`
+}
+
+// subnetKeys are the settings of a zone that make up its Subnet
+var subnetKeys = []string{"domain", "subnet", "gateway", "dhcpsubnet", "dhcpleaseduration", "dhcpretention"}
+
+// configZoneMatcher matches the names that a zone can have as a key of the
+// config tree
+var configZoneMatcher = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// settings returns the subnet keyed as in the config tree of its zone
+func (s *Subnet) settings() map[string]string {
+	return map[string]string{
+		"domain":            s.Domain,
+		"subnet":            s.Subnet,
+		"gateway":           s.Gateway,
+		"dhcpsubnet":        s.DHCPSubnet,
+		"dhcpleaseduration": s.DHCPLeaseDuration,
+		"dhcpretention":     s.DHCPRetention,
+	}
+}
+
+// validate checks the subnet, putting its addresses in canonical form
+func (s *Subnet) validate() error {
+	if !configZoneMatcher.MatchString(s.Zone) {
+		return invalid("zone", "invalid zone name %q", s.Zone)
+	}
+	if s.Domain != "" && !validDomainName(cleanFQDN(s.Domain)) {
+		return invalid("domain", "invalid domain %q", s.Domain)
+	}
+	ip, network, err := net.ParseCIDR(s.Subnet)
+	if err != nil || ip.To4() == nil {
+		return invalid("subnet", "%q is not an IPv4 network such as 10.0.0.0/24", s.Subnet)
+	}
+	s.Subnet = network.String()
+	gateway := net.ParseIP(s.Gateway).To4()
+	if gateway == nil || !network.Contains(gateway) {
+		return invalid("gateway", "%q is not an IPv4 address in %s", s.Gateway, s.Subnet)
+	}
+	s.Gateway = gateway.String()
+	if s.DHCPSubnet != "" {
+		ip, pool, err := net.ParseCIDR(s.DHCPSubnet)
+		if err != nil || ip.To4() == nil || !network.Contains(pool.IP) {
+			return invalid("dhcpsubnet", "%q is not an IPv4 network within %s", s.DHCPSubnet, s.Subnet)
+		}
+		s.DHCPSubnet = pool.String()
+	}
+	for field, value := range map[string]string{"dhcpleaseduration": s.DHCPLeaseDuration, "dhcpretention": s.DHCPRetention} {
+		if minutes, err := strconv.Atoi(value); value != "" && (err != nil || minutes <= 0) {
+			return invalid(field, "%q is not a positive number of minutes", value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package netcore
+
+import "path"
+
+// GetSubnet reads the network settings of zone from config/<zone>/, and
+// fails with a not-found error if the zone has no subnet
+func (db EtcdDB) GetSubnet(zone string) (*Subnet, error) {
+	settings := make(map[string]string)
+	for _, key := range subnetKeys {
+		response, err := db.client.Get("config/"+zone+"/"+key, false, false)
+		if etcdKeyNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		settings[key] = response.Node.Value
+	}
+	if settings["subnet"] == "" {
+		return nil, notFoundError("zone %s has no subnet", zone)
+	}
+	return &Subnet{
+		Zone:              zone,
+		Domain:            settings["domain"],
+		Subnet:            settings["subnet"],
+		Gateway:           settings["gateway"],
+		DHCPSubnet:        settings["dhcpsubnet"],
+		DHCPLeaseDuration: settings["dhcpleaseduration"],
+		DHCPRetention:     settings["dhcpretention"],
+	}, nil
+}
+
+// ListSubnets returns the subnet of every zone that has one
+func (db EtcdDB) ListSubnets() ([]Subnet, error) {
+	response, err := db.client.Get("config", true, false)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var subnets []Subnet
+	for _, node := range response.Node.Nodes {
+		if !node.Dir {
+			continue
+		}
+		subnet, err := db.GetSubnet(path.Base(node.Key))
+		if ErrorKind(err) == ErrNotFound {
+			continue // a host, or a zone without a subnet
+		}
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, *subnet)
+	}
+	return subnets, nil
+}
+
+// SaveSubnet replaces the network settings of the subnet's zone, removing
+// those it leaves empty. Instances read them when they start.
+func (db EtcdDB) SaveSubnet(actor string, subnet Subnet) error {
+	if err := subnet.validate(); err != nil {
+		return err
+	}
+	for key, value := range subnet.settings() {
+		etcdKey := "config/" + subnet.Zone + "/" + key
+		var old string
+		if value == "" {
+			response, err := db.client.Delete(etcdKey, false)
+			if etcdKeyNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if response.PrevNode != nil {
+				old = response.PrevNode.Value
+			}
+		} else {
+			response, err := db.client.Set(etcdKey, value, 0)
+			if err != nil {
+				return err
+			}
+			if response.PrevNode != nil {
+				old = response.PrevNode.Value
+			}
+		}
+		auditChange(db, actor, "config", subnet.Zone+"/"+key, "set", old, value)
+	}
+	return nil
+}
+
+// DeleteSubnet removes the network settings of zone, leaving its other
+// settings, such as its DNS forwarders, in place
+func (db EtcdDB) DeleteSubnet(actor string, zone string) error {
+	subnet, err := db.GetSubnet(zone)
+	if err != nil {
+		return err
+	}
+	for key, old := range subnet.settings() {
+		if old == "" {
+			continue
+		}
+		if _, err := db.client.Delete("config/"+zone+"/"+key, false); err != nil && !etcdKeyNotFound(err) {
+			return err
+		}
+		auditChange(db, actor, "config", zone+"/"+key, "delete", old, "")
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service is one of the servers that netcore runs, such as DNS, DHCP or the
+// admin API. The deadline given to Stop stands in for a context, which Go
+// 1.5 does not have.
+type Service interface {
+	// Name identifies the service in logs, health reasons and dependencies
+	Name() string
+	// Start begins serving and returns once the service is listening. The
+	// channel receives why the service stopped serving, or is closed once
+	// Stop stops it.
+	Start() (<-chan error, error)
+	// Stop stops serving, giving up on requests in flight at deadline
+	Stop(deadline time.Time) error
+	// Healthy is nil while the service is serving, or why it is not
+	Healthy() error
+}
+
+var readyTimeout = Flags.Duration("readytimeout", 30*time.Second, "How long a service waits at startup for the services it depends on to be ready, such as DHCP for DNS, before netcore gives up.")
+
+// Readier is a Service that can tell when it is ready for the services that
+// depend on it, which may be some time after it is listening
+type Readier interface {
+	// Ready is nil once the service is ready, or why it is not yet
+	Ready() error
+}
+
+const (
+	// supervisorBackoff is how long the supervisor waits before restarting a
+	// service that stopped, doubled after each failed restart
+	supervisorBackoff = time.Second
+	// supervisorMaxBackoff is the longest it waits between restarts
+	supervisorMaxBackoff = time.Minute
+	// supervisorStable is how long a restarted service must keep serving for
+	// its backoff to start over
+	supervisorStable = time.Minute
+)
+
+// Supervisor starts services in the order of their dependencies, restarts
+// the ones that stop with a growing backoff, and stops them in reverse order
+type Supervisor struct {
+	sync.Mutex
+	// ReadyTimeout is how long Start waits for the dependencies of a service
+	// to be ready
+	ReadyTimeout time.Duration
+	services     []*supervised
+	stopping     bool
+}
+
+// supervised is a service and the names of the services it needs running
+// first
+type supervised struct {
+	Service
+	after   []string
+	started bool
+}
+
+// NewSupervisor returns a supervisor with no services, which waits for
+// dependencies as long as -readytimeout says
+func NewSupervisor() *Supervisor {
+	return &Supervisor{ReadyTimeout: *readyTimeout}
+}
+
+// Add supervises service, which is started after the named services and
+// stopped before them
+func (s *Supervisor) Add(service Service, after ...string) {
+	s.Lock()
+	defer s.Unlock()
+	s.services = append(s.services, &supervised{Service: service, after: after})
+}
+
+// order sorts the services so that each comes after those it needs; the
+// caller must hold the lock
+func (s *Supervisor) order() ([]*supervised, error) {
+	byName := make(map[string]*supervised)
+	for _, service := range s.services {
+		byName[service.Name()] = service
+	}
+	var ordered []*supervised
+	state := make(map[string]int) // 1 while visiting, 2 once ordered
+	var visit func(service *supervised) error
+	visit = func(service *supervised) error {
+		switch state[service.Name()] {
+		case 1:
+			return fmt.Errorf("%s depends on itself", service.Name())
+		case 2:
+			return nil
+		}
+		state[service.Name()] = 1
+		for _, name := range service.after {
+			dependency, ok := byName[name]
+			if !ok {
+				continue // not run on this instance
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[service.Name()] = 2
+		ordered = append(ordered, service)
+		return nil
+	}
+	for _, service := range s.services {
+		if err := visit(service); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Start starts every service in order, each once the services it depends on
+// are ready. Services that are still to start are reported as degraded
+// meanwhile. If one fails to start, those already started are stopped
+// again, and the error says which had started.
+func (s *Supervisor) Start() error {
+	s.Lock()
+	defer s.Unlock()
+	ordered, err := s.order()
+	if err != nil {
+		return err
+	}
+	s.services = ordered
+	for _, service := range s.services {
+		health.Set(service.Name(), Degraded, "not started yet")
+	}
+	for _, service := range s.services {
+		err := s.awaitDependencies(service)
+		var exit <-chan error
+		if err == nil {
+			exit, err = service.Start()
+		}
+		if err != nil {
+			health.Set(service.Name(), Unhealthy, err.Error())
+			started := "none"
+			if names := s.startedNames(); len(names) > 0 {
+				started = strings.Join(names, ", ")
+			}
+			err = fmt.Errorf("%s failed to start: %s (started: %s)", service.Name(), err, started)
+			s.stopping = true
+			s.stopStarted(time.Now().Add(drainWait))
+			return err
+		}
+		service.started = true
+		logger.Printf("%s started\n", service.Name())
+		health.Set(service.Name(), Healthy, "")
+		go s.watch(service, exit)
+	}
+	return nil
+}
+
+// awaitDependencies waits until the services that service depends on are
+// ready, or until ReadyTimeout passes; the caller must hold the lock
+func (s *Supervisor) awaitDependencies(service *supervised) error {
+	deadline := time.Now().Add(s.ReadyTimeout)
+	for _, dependency := range s.services {
+		readier, ok := dependency.Service.(Readier)
+		if !ok || !dependency.started || !containsString(service.after, dependency.Name()) {
+			continue
+		}
+		waiting := time.Now()
+		err := readier.Ready()
+		if err != nil {
+			logger.Printf("%s waiting for %s to be ready: %s\n", service.Name(), dependency.Name(), err)
+			health.Set(service.Name(), Degraded, "not started yet: waiting for "+dependency.Name())
+		}
+		for err != nil && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+			err = readier.Ready()
+		}
+		if err != nil {
+			return fmt.Errorf("%s not ready after %s: %s", dependency.Name(), s.ReadyTimeout, err)
+		}
+		logger.Printf("%s ready after %s\n", dependency.Name(), time.Since(waiting)/time.Millisecond*time.Millisecond)
+	}
+	return nil
+}
+
+// startedNames lists the services that were started; the caller must hold
+// the lock
+func (s *Supervisor) startedNames() []string {
+	names := []string{}
+	for _, service := range s.services {
+		if service.started {
+			names = append(names, service.Name())
+		}
+	}
+	return names
+}
+
+// watch restarts service whenever it stops serving, until the supervisor is
+// stopped or the process hands its sockets over
+func (s *Supervisor) watch(service *supervised, exit <-chan error) {
+	backoff := supervisorBackoff
+	for {
+		started := time.Now()
+		err, ok := <-exit
+		if !ok || s.isStopping() || listeners.HandingOver() {
+			return
+		}
+		if time.Since(started) >= supervisorStable {
+			backoff = supervisorBackoff
+		}
+		logger.Printf("%s stopped: %s; restarting in %s\n", service.Name(), err, backoff)
+		health.Set(service.Name(), Unhealthy, err.Error())
+		events.Publish(Event{Type: "service.restart", Severity: "critical", Message: fmt.Sprintf("%s stopped: %s", service.Name(), err)})
+		for {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > supervisorMaxBackoff {
+				backoff = supervisorMaxBackoff
+			}
+			if s.isStopping() {
+				return
+			}
+			if exit, err = service.Start(); err == nil {
+				break
+			}
+			logger.Printf("%s failed to restart: %s; retrying in %s\n", service.Name(), err, backoff)
+			health.Set(service.Name(), Unhealthy, err.Error())
+		}
+		if s.isStopping() {
+			service.Stop(time.Now().Add(drainWait))
+			return
+		}
+		logger.Printf("%s restarted\n", service.Name())
+		health.Set(service.Name(), Healthy, "")
+	}
+}
+
+func (s *Supervisor) isStopping() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.stopping
+}
+
+// Stop stops every service in reverse order, giving up on requests in
+// flight at deadline
+func (s *Supervisor) Stop(deadline time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	s.stopping = true
+	return s.stopStarted(deadline)
+}
+
+// stopStarted stops the services that were started, last first, and
+// returns the first error; the caller must hold the lock
+func (s *Supervisor) stopStarted(deadline time.Time) error {
+	var first error
+	for i := len(s.services) - 1; i >= 0; i-- {
+		service := s.services[i]
+		if !service.started {
+			continue
+		}
+		service.started = false
+		if err := service.Stop(deadline); err != nil && first == nil {
+			first = fmt.Errorf("%s: %s", service.Name(), err)
+		}
+	}
+	return first
+}
+
+// Healthy is nil while every service is serving, or why the first that is
+// not
+func (s *Supervisor) Healthy() error {
+	s.Lock()
+	defer s.Unlock()
+	for _, service := range s.services {
+		if err := service.Healthy(); err != nil {
+			return fmt.Errorf("%s: %s", service.Name(), err)
+		}
+	}
+	return nil
+}
+
+// errServiceStopped is why a service that was stopped is not serving
+var errServiceStopped = errors.New("stopped")
+
+// serviceStatus tracks whether a service is serving, for Healthy
+type serviceStatus struct {
+	sync.Mutex
+	running bool
+	stopped bool  // by Stop, so that its servers returning is expected
+	err     error // why it is not running
+}
+
+// serving records that a service started, and returns the channel that
+// Start returns: it receives the first error from exit, or is closed if the
+// service was stopped. Once a server of the service fails, cleanup stops the others,
+// so that the service can be started again.
+func (st *serviceStatus) serving(exit <-chan error, cleanup func()) <-chan error {
+	st.Lock()
+	st.running, st.stopped, st.err = true, false, nil
+	st.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		err := <-exit
+		st.Lock()
+		stopped := st.stopped
+		st.running = false
+		if !stopped {
+			st.err = err
+		}
+		st.Unlock()
+		if stopped {
+			close(done)
+			return
+		}
+		cleanup()
+		done <- err
+	}()
+	return done
+}
+
+// stop records that the service is being stopped on purpose
+func (st *serviceStatus) stop() {
+	st.Lock()
+	defer st.Unlock()
+	st.running, st.stopped, st.err = false, true, errServiceStopped
+}
+
+func (st *serviceStatus) Healthy() error {
+	st.Lock()
+	defer st.Unlock()
+	if st.running {
+		return nil
+	}
+	if st.err == nil {
+		return errors.New("not started")
+	}
+	return st.err
+}
@@ -0,0 +1,149 @@
+package netcore
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeService records what the supervisor does with it in a shared log
+type fakeService struct {
+	name     string
+	log      *serviceLog
+	startErr error
+	ready    error
+	exit     chan error
+}
+
+type serviceLog struct {
+	sync.Mutex
+	entries []string
+}
+
+func (l *serviceLog) add(entry string) {
+	l.Lock()
+	l.entries = append(l.entries, entry)
+	l.Unlock()
+}
+
+func (l *serviceLog) get() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string(nil), l.entries...)
+}
+
+func (f *fakeService) Name() string { return f.name }
+
+func (f *fakeService) Start() (<-chan error, error) {
+	if f.startErr != nil {
+		return nil, f.startErr
+	}
+	f.log.add("start " + f.name)
+	f.exit = make(chan error, 1)
+	return f.exit, nil
+}
+
+func (f *fakeService) Stop(deadline time.Time) error {
+	f.log.add("stop " + f.name)
+	close(f.exit)
+	return nil
+}
+
+func (f *fakeService) Healthy() error { return nil }
+
+// readyService is a fakeService that is only ready once its ready is nil
+type readyService struct {
+	*fakeService
+	sync.Mutex
+}
+
+func (r *readyService) Ready() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.ready
+}
+
+func TestSupervisorOrder(t *testing.T) {
+	log := &serviceLog{}
+	s := &Supervisor{ReadyTimeout: time.Second}
+	s.Add(&fakeService{name: "api", log: log}, "dns", "dhcp")
+	s.Add(&fakeService{name: "dns", log: log}, "dhcp", "replica")
+	s.Add(&fakeService{name: "dhcp", log: log})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop(time.Now())
+	want := []string{"start dhcp", "start dns", "start api", "stop api", "stop dns", "stop dhcp"}
+	if got := log.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("supervisor did %v, want %v", got, want)
+	}
+
+	s = &Supervisor{}
+	s.Add(&fakeService{name: "a", log: log}, "b")
+	s.Add(&fakeService{name: "b", log: log}, "a")
+	if err := s.Start(); err == nil {
+		t.Errorf("services that depend on each other were started")
+	}
+}
+
+func TestSupervisorStartFailure(t *testing.T) {
+	log := &serviceLog{}
+	s := &Supervisor{ReadyTimeout: time.Second}
+	s.Add(&fakeService{name: "dhcp", log: log})
+	s.Add(&fakeService{name: "dns", log: log, startErr: errors.New("address in use")}, "dhcp")
+	s.Add(&fakeService{name: "api", log: log}, "dns")
+	err := s.Start()
+	if err == nil || err.Error() != "dns failed to start: address in use (started: dhcp)" {
+		t.Errorf("Start = %v", err)
+	}
+	if got, want := log.get(), []string{"start dhcp", "stop dhcp"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("supervisor did %v, want %v", got, want)
+	}
+}
+
+func TestSupervisorAwaitsReadiness(t *testing.T) {
+	log := &serviceLog{}
+	dhcp := &readyService{fakeService: &fakeService{name: "dhcp", log: log, ready: errors.New("loading leases")}}
+	s := &Supervisor{ReadyTimeout: 2 * time.Second}
+	s.Add(dhcp)
+	s.Add(&fakeService{name: "dns", log: log}, "dhcp")
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		dhcp.Lock()
+		dhcp.ready = nil
+		dhcp.Unlock()
+		log.add("dhcp ready")
+	}()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop(time.Now())
+	if got, want := log.get(), []string{"start dhcp", "dhcp ready", "start dns"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("supervisor did %v, want %v", got, want)
+	}
+
+	s = &Supervisor{ReadyTimeout: 200 * time.Millisecond}
+	s.Add(&readyService{fakeService: &fakeService{name: "dhcp", log: log, ready: errors.New("loading leases")}})
+	s.Add(&fakeService{name: "dns", log: log}, "dhcp")
+	if err := s.Start(); err == nil {
+		t.Errorf("dns started although dhcp never got ready")
+	}
+}
+
+func TestSupervisorRestarts(t *testing.T) {
+	log := &serviceLog{}
+	dns := &fakeService{name: "dns", log: log}
+	s := &Supervisor{}
+	s.Add(dns)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	dns.exit <- errors.New("socket closed")
+	time.Sleep(supervisorBackoff + 200*time.Millisecond)
+	s.Stop(time.Now())
+	if got, want := log.get(), []string{"start dns", "start dns", "stop dns"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("supervisor did %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+package netcore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// TenantDB stores tenants, the zones they own and the API tokens that act on
+// their behalf
+type TenantDB interface {
+	TenantForToken(token string) (tenant string, err error)
+	TenantForZone(zone string) (tenant string, err error)
+	AssignZone(tenant string, zone string) error
+	AddTenantToken(tenant string, token string) error
+	ListTenantZones(tenant string) ([]string, error)
+}
+
+// ErrZoneOwned is returned when assigning a zone that already belongs to a
+// different tenant
+var ErrZoneOwned = conflictError("This zone is already owned by another tenant.")
+
+// ErrBadTenantName is returned for tenant names that cannot be used as keys
+var ErrBadTenantName = invalid("tenant", "Tenant names may only contain letters, digits, dashes and underscores.")
+
+var tenantNameMatcher = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func validTenantName(tenant string) bool {
+	return tenantNameMatcher.MatchString(tenant)
+}
+
+// hashToken returns the form in which tokens are stored, so that reading the
+// database does not reveal usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random API token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// zoneAncestors returns name and each of its parent domains, closest first
+func zoneAncestors(name string) []string {
+	parts := strings.Split(cleanFQDN(name), ".")
+	names := make([]string, 0, len(parts))
+	for i := range parts {
+		names = append(names, strings.Join(parts[i:], "."))
+	}
+	return names
+}
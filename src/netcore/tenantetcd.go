@@ -0,0 +1,78 @@
+package netcore
+
+import "path"
+
+// Tenants are stored under their own namespace:
+//
+//	/tenants/<tenant>/tokens/<sha256 of token>
+//	/tenants/<tenant>/zones/<zone>
+//
+// with indexes /tenanttokens/<sha256 of token> = <tenant> for authentication
+// and /tenantzones/<zone> = <tenant> for ownership. The zone index is created
+// atomically so that a zone can only ever belong to one tenant.
+
+func (db EtcdDB) TenantForToken(token string) (string, error) {
+	response, err := db.client.Get("tenanttokens/"+hashToken(token), false, false)
+	if etcdKeyNotFound(err) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return response.Node.Value, nil
+}
+
+func (db EtcdDB) TenantForZone(zone string) (string, error) {
+	for _, name := range zoneAncestors(zone) {
+		response, err := db.client.Get("tenantzones/"+name, false, false)
+		if err == nil && response != nil && response.Node != nil {
+			return response.Node.Value, nil
+		}
+		if err != nil && !etcdKeyNotFound(err) {
+			return "", err
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (db EtcdDB) AssignZone(tenant string, zone string) error {
+	if !validTenantName(tenant) {
+		return ErrBadTenantName
+	}
+	zone = cleanFQDN(zone)
+	if _, err := db.client.Create("tenantzones/"+zone, tenant, 0); err != nil {
+		response, getErr := db.client.Get("tenantzones/"+zone, false, false)
+		if getErr != nil || response.Node.Value != tenant {
+			return ErrZoneOwned
+		}
+	}
+	_, err := db.client.Set("tenants/"+tenant+"/zones/"+zone, "", 0)
+	return err
+}
+
+func (db EtcdDB) AddTenantToken(tenant string, token string) error {
+	if !validTenantName(tenant) {
+		return ErrBadTenantName
+	}
+	hash := hashToken(token)
+	if _, err := db.client.Set("tenants/"+tenant+"/tokens/"+hash, "", 0); err != nil {
+		return err
+	}
+	_, err := db.client.Set("tenanttokens/"+hash, tenant, 0)
+	return err
+}
+
+func (db EtcdDB) ListTenantZones(tenant string) ([]string, error) {
+	response, err := db.client.Get("tenants/"+tenant+"/zones", true, false)
+	if etcdKeyNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]string, 0, len(response.Node.Nodes))
+	for _, node := range response.Node.Nodes {
+		zones = append(zones, path.Base(node.Key))
+	}
+	return zones, nil
+}
@@ -0,0 +1,207 @@
+package netcore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var otlpEndpoint = Flags.String("otlpendpoint", "", "OTLP/HTTP collector to export traces to, such as http://localhost:4318 (empty to disable); defaults to $OTEL_EXPORTER_OTLP_ENDPOINT.")
+var traceSample = Flags.Float64("tracesample", 1, "Fraction of DNS queries to trace when an OTLP endpoint is set.")
+
+// Span kinds, as numbered by OTLP
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+// Span is a timed operation within a trace. A nil *Span is valid and does
+// nothing, which is what callers get when tracing is off or the trace was
+// not sampled, so that instrumented code needs no checks of its own.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      string
+}
+
+var (
+	spanExports     = make(chan *Span, 4096)
+	spanExportStats = expvar.NewMap("trace_spans")
+	tracingEnabled  bool
+)
+
+// tracingSetup starts exporting spans if a collector has been configured
+func tracingSetup() {
+	if *otlpEndpoint == "" {
+		*otlpEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if *otlpEndpoint == "" {
+		return
+	}
+	tracingEnabled = true
+	logger.Printf("Exporting traces of %g of DNS queries to %s\n", *traceSample, *otlpEndpoint)
+	go exportSpans(strings.TrimSuffix(*otlpEndpoint, "/") + "/v1/traces")
+}
+
+// startTrace begins a new trace, subject to sampling
+func startTrace(name string, kind int) *Span {
+	if !tracingEnabled || mathrand.Float64() >= *traceSample {
+		return nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now()}
+	rand.Read(s.traceID[:])
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// Child begins a span within the same trace as s
+func (s *Span) Child(name string, kind int) *Span {
+	if s == nil {
+		return nil
+	}
+	c := &Span{traceID: s.traceID, parentID: s.spanID, name: name, kind: kind, start: time.Now()}
+	rand.Read(c.spanID[:])
+	return c
+}
+
+// SetAttr records an attribute of the operation
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(map[string]string)
+	}
+	s.attrs[key] = value
+}
+
+// SetError marks the operation as failed, if err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err.Error()
+}
+
+// End finishes the span and queues it for export. Spans are dropped rather
+// than slowing down queries when the collector cannot keep up.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	select {
+	case spanExports <- s:
+	default:
+		spanExportStats.Add("dropped", 1)
+	}
+}
+
+// exportSpans sends finished spans to the collector in batches
+func exportSpans(url string) {
+	const batchSize = 256
+	ticker := time.NewTicker(5 * time.Second)
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case s := <-spanExports:
+			batch = append(batch, s)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := postSpans(url, batch); err != nil {
+			logger.Printf("Trace export to %s failed: %s\n", url, err)
+			spanExportStats.Add("failed", int64(len(batch)))
+		} else {
+			spanExportStats.Add("exported", int64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+}
+
+// postSpans sends spans using the JSON encoding of OTLP/HTTP
+func postSpans(url string, spans []*Span) error {
+	hostname, _ := os.Hostname()
+	encoded := make([]interface{}, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.otlp())
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{
+					"service.name": "netcore",
+					"host.name":    hostname,
+				}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "netcore"},
+				"spans": encoded,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded %s", response.Status)
+	}
+	return nil
+}
+
+func (s *Span) otlp() map[string]interface{} {
+	span := map[string]interface{}{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+	}
+	if s.parentID != [8]byte{} {
+		span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != "" {
+		span["status"] = map[string]interface{}{"code": 2, "message": s.err}
+	}
+	return span
+}
+
+func otlpAttributes(attrs map[string]string) []interface{} {
+	encoded := make([]interface{}, 0, len(attrs))
+	for k, v := range attrs {
+		encoded = append(encoded, map[string]interface{}{
+			"key":   k,
+			"value": map[string]string{"stringValue": v},
+		})
+	}
+	return encoded
+}
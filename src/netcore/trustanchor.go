@@ -0,0 +1,330 @@
+package netcore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var trustAnchorRefresh = Flags.Duration("trustanchorrefresh", time.Hour, "How often the leader checks the DNSKEY sets of zones with trust anchors for RFC 5011 key rollovers (0 to disable); zones are queried no more often than their TTL allows.")
+
+// TrustAnchorDB stores DNSSEC trust anchors, which the leader keeps up to
+// date as zones roll their keys over (RFC 5011)
+type TrustAnchorDB interface {
+	ListTrustAnchors() ([]TrustAnchor, error)
+	SaveTrustAnchor(actor string, anchor TrustAnchor) error
+	DeleteTrustAnchor(actor string, zone string, keyTag uint16) error
+}
+
+// States of a trust anchor, as RFC 5011 section 4 names them
+const (
+	AnchorAddPend = "addpend" // seen in a validated key set, waiting for the hold-down to pass
+	AnchorValid   = "valid"   // trusted
+	AnchorMissing = "missing" // trusted, but gone from the key set
+	AnchorRevoked = "revoked" // revoked by its owner, kept until the removal hold-down passes
+)
+
+const (
+	// anchorAddHoldDown is how long a new key must be seen before it is
+	// trusted, and anchorRemoveHoldDown how long a revoked key is remembered
+	anchorAddHoldDown    = 30 * 24 * time.Hour
+	anchorRemoveHoldDown = 30 * 24 * time.Hour
+
+	// dnskeyRevoke is the REVOKE flag of a DNSKEY (RFC 5011 section 7)
+	dnskeyRevoke = 0x0080
+)
+
+// TrustAnchor is a key that DNSKEY sets of its zone are validated with. An
+// anchor can be added as a DS record; it gets its key once a key set shows
+// a key with that digest.
+type TrustAnchor struct {
+	Zone     string    `json:"zone"`
+	KeyTag   uint16    `json:"key_tag"`
+	State    string    `json:"state"`
+	DNSKEY   string    `json:"dnskey,omitempty"` // in zone file format
+	DS       string    `json:"ds,omitempty"`     // in zone file format, for anchors added as a digest
+	Changed  time.Time `json:"changed"`          // when it entered its state
+	LastSeen time.Time `json:"last_seen"`        // in a validated key set
+}
+
+// key returns the anchor's DNSKEY, or nil if only its digest is known
+func (a *TrustAnchor) key() *dns.DNSKEY {
+	if a.DNSKEY == "" {
+		return nil
+	}
+	rr, err := dns.NewRR(a.DNSKEY)
+	if err != nil {
+		return nil
+	}
+	key, _ := rr.(*dns.DNSKEY)
+	return key
+}
+
+// trusted reports whether the anchor may validate key sets
+func (a *TrustAnchor) trusted() bool {
+	return a.State == AnchorValid || a.State == AnchorMissing
+}
+
+// ParseTrustAnchor makes a valid anchor from a DNSKEY or DS record in zone
+// file format, such as one of the root zone's published in root-anchors.xml
+func ParseTrustAnchor(text string) (*TrustAnchor, error) {
+	rr, err := dns.NewRR(strings.TrimSpace(text))
+	if err != nil || rr == nil {
+		return nil, invalid("record", "a trust anchor must be a DNSKEY or DS record in zone file format")
+	}
+	anchor := &TrustAnchor{Zone: dns.Fqdn(strings.ToLower(rr.Header().Name)), State: AnchorValid, Changed: time.Now().UTC()}
+	switch rr := rr.(type) {
+	case *dns.DNSKEY:
+		if rr.Flags&dns.SEP == 0 {
+			return nil, invalid("record", "a trust anchor must be a key signing key, with the SEP flag (257)")
+		}
+		anchor.KeyTag = rr.KeyTag()
+		anchor.DNSKEY = rr.String()
+	case *dns.DS:
+		anchor.KeyTag = rr.KeyTag
+		anchor.DS = rr.String()
+	default:
+		return nil, invalid("record", "a trust anchor must be a DNSKEY or DS record, not %s", dns.Type(rr.Header().Rrtype).String())
+	}
+	return anchor, nil
+}
+
+// matchesKey reports whether key is the anchor's key, or has its digest
+func (a *TrustAnchor) matchesKey(key *dns.DNSKEY) bool {
+	if key.KeyTag() != a.KeyTag {
+		return false
+	}
+	if k := a.key(); k != nil {
+		return k.PublicKey == key.PublicKey && k.Algorithm == key.Algorithm
+	}
+	rr, err := dns.NewRR(a.DS)
+	if err != nil {
+		return false
+	}
+	ds, ok := rr.(*dns.DS)
+	if !ok {
+		return false
+	}
+	computed := key.ToDS(ds.DigestType)
+	return computed != nil && strings.EqualFold(computed.Digest, ds.Digest)
+}
+
+// validateKeySet checks that a trusted anchor signed the key set
+func validateKeySet(anchors []TrustAnchor, keys []*dns.DNSKEY, sigs []*dns.RRSIG, now time.Time) error {
+	rrset := make([]dns.RR, 0, len(keys))
+	for _, key := range keys {
+		rrset = append(rrset, key)
+	}
+	for _, sig := range sigs {
+		if sig.TypeCovered != dns.TypeDNSKEY || !sig.ValidityPeriod(now) {
+			continue
+		}
+		for _, anchor := range anchors {
+			if !anchor.trusted() || anchor.KeyTag != sig.KeyTag {
+				continue
+			}
+			for _, key := range keys {
+				if key.KeyTag() == sig.KeyTag && anchor.matchesKey(key) && sig.Verify(key, rrset) == nil {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("no trusted anchor signed the key set")
+}
+
+// updateTrustAnchors applies RFC 5011 to the anchors of a zone, given its
+// validated key set: new key signing keys are added pending, and trusted
+// after the add hold-down; revoked keys that signed the set stop being
+// trusted; trusted keys that are gone become missing until they return. It
+// returns the anchors to save, and those to delete.
+func updateTrustAnchors(zone string, anchors []TrustAnchor, keys []*dns.DNSKEY, sigs []*dns.RRSIG, now time.Time) (save []TrustAnchor, remove []TrustAnchor) {
+	now = now.UTC()
+	seen := make(map[int]bool) // indexes of anchors
+	set := func(a *TrustAnchor, state string) {
+		if a.State != state {
+			logger.Printf("[DNSSEC] Trust anchor %s %d is now %s, was %s\n", zone, a.KeyTag, state, a.State)
+			a.State, a.Changed = state, now
+		}
+	}
+	signedBy := func(key *dns.DNSKEY) bool {
+		rrset := make([]dns.RR, 0, len(keys))
+		for _, k := range keys {
+			rrset = append(rrset, k)
+		}
+		for _, sig := range sigs {
+			if sig.TypeCovered == dns.TypeDNSKEY && sig.KeyTag == key.KeyTag() && sig.ValidityPeriod(now) && sig.Verify(key, rrset) == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, key := range keys {
+		if key.Flags&dns.SEP == 0 {
+			continue
+		}
+		revoked := key.Flags&dnskeyRevoke != 0
+		// A revoked key's tag changes with the flag, so the anchor is found
+		// by the key without it
+		unrevoked := *key
+		unrevoked.Flags &^= dnskeyRevoke
+		found := -1
+		for i := range anchors {
+			if anchors[i].Zone == zone && (anchors[i].matchesKey(key) || revoked && anchors[i].matchesKey(&unrevoked)) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			if revoked {
+				continue
+			}
+			anchors = append(anchors, TrustAnchor{Zone: zone, KeyTag: key.KeyTag(), DNSKEY: key.String(), State: AnchorAddPend, Changed: now})
+			logger.Printf("[DNSSEC] New key %s %d seen, trusted after the hold-down if it stays\n", zone, key.KeyTag())
+			found = len(anchors) - 1
+		}
+		seen[found] = true
+		a := &anchors[found]
+		a.LastSeen = now
+		if a.DNSKEY == "" && !revoked {
+			a.DNSKEY, a.DS = key.String(), ""
+		}
+		switch {
+		case revoked && signedBy(key):
+			set(a, AnchorRevoked)
+		case a.State == AnchorAddPend && now.Sub(a.Changed) >= anchorAddHoldDown:
+			set(a, AnchorValid)
+		case a.State == AnchorMissing:
+			set(a, AnchorValid)
+		}
+	}
+
+	for i := range anchors {
+		a := &anchors[i]
+		if a.Zone != zone {
+			continue
+		}
+		switch {
+		case a.State == AnchorRevoked && now.Sub(a.Changed) >= anchorRemoveHoldDown:
+			logger.Printf("[DNSSEC] Revoked trust anchor %s %d removed\n", zone, a.KeyTag)
+			remove = append(remove, *a)
+			continue
+		case seen[i]:
+		case a.State == AnchorAddPend:
+			// Pending keys that leave the set start over if they return
+			logger.Printf("[DNSSEC] Pending key %s %d is gone\n", zone, a.KeyTag)
+			remove = append(remove, *a)
+			continue
+		case a.State == AnchorValid:
+			set(a, AnchorMissing)
+		}
+		save = append(save, *a)
+	}
+	return save, remove
+}
+
+// fetchKeySet queries servers for the DNSKEY set of zone with its
+// signatures, and returns them with the set's TTL
+func fetchKeySet(zone string, servers []string) ([]*dns.DNSKEY, []*dns.RRSIG, uint32, error) {
+	req := new(dns.Msg)
+	req.SetQuestion(zone, dns.TypeDNSKEY)
+	req.SetEdns0(4096, true)
+	req.RecursionDesired = true
+	client := &dns.Client{Net: "tcp", ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second}
+	var lastErr error = errors.New("no servers to ask")
+	for _, server := range servers {
+		response, _, err := client.Exchange(req, server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if response.Rcode != dns.RcodeSuccess {
+			lastErr = fmt.Errorf("%s answered %s", server, dns.RcodeToString[response.Rcode])
+			continue
+		}
+		var keys []*dns.DNSKEY
+		var sigs []*dns.RRSIG
+		ttl := uint32(0)
+		for _, rr := range response.Answer {
+			switch rr := rr.(type) {
+			case *dns.DNSKEY:
+				keys = append(keys, rr)
+				ttl = rr.Hdr.Ttl
+			case *dns.RRSIG:
+				sigs = append(sigs, rr)
+			}
+		}
+		if len(keys) == 0 {
+			lastErr = fmt.Errorf("%s has no DNSKEY records for %s", server, zone)
+			continue
+		}
+		return keys, sigs, ttl, nil
+	}
+	return nil, nil, 0, lastErr
+}
+
+// trustAnchorChecked is when each zone's key set was last checked, and
+// when it may be checked next, by the leader
+var trustAnchorChecked = make(map[string]time.Time)
+
+// refreshTrustAnchors checks the key sets of the zones with trust anchors
+// that are due, and saves what changed. The root zone is asked of the root
+// servers themselves when resolving iteratively; others of the forwarders.
+func refreshTrustAnchors(cfg *Config) error {
+	anchors, err := cfg.db.ListTrustAnchors()
+	if err != nil {
+		return err
+	}
+	zones := make(map[string]bool)
+	for _, anchor := range anchors {
+		zones[anchor.Zone] = true
+	}
+	now := time.Now()
+	for zone := range zones {
+		if now.Before(trustAnchorChecked[zone]) {
+			continue
+		}
+		servers := cfg.DNSForwarders()
+		if zone == "." && (cfg.DNSResolver() == "iterate" || len(servers) == 0) {
+			if servers, err = loadRootHints(*rootHintsFile); err != nil {
+				return err
+			}
+		}
+		keys, sigs, ttl, err := fetchKeySet(zone, servers)
+		if err == nil {
+			err = validateKeySet(anchors, keys, sigs, now)
+		}
+		if err != nil {
+			// Retry sooner than the TTL, as RFC 5011 section 2.3 asks
+			trustAnchorChecked[zone] = now.Add(time.Hour)
+			logger.Printf("[DNSSEC] Key set of %s not checked: %s\n", zone, err)
+			continue
+		}
+		// RFC 5011 section 2.3: half the TTL, from an hour to 15 days
+		interval := time.Duration(ttl) * time.Second / 2
+		if interval < time.Hour {
+			interval = time.Hour
+		}
+		if interval > 15*24*time.Hour {
+			interval = 15 * 24 * time.Hour
+		}
+		trustAnchorChecked[zone] = now.Add(interval)
+
+		save, remove := updateTrustAnchors(zone, anchors, keys, sigs, now)
+		for _, anchor := range save {
+			if err := cfg.db.SaveTrustAnchor("rfc5011", anchor); err != nil {
+				return err
+			}
+		}
+		for _, anchor := range remove {
+			if err := cfg.db.DeleteTrustAnchor("rfc5011", anchor.Zone, anchor.KeyTag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package netcore
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Trust anchors are kept under /trustanchors/<zone>/<key tag>, the root
+// zone's under /trustanchors/root/. The audit log follows their states.
+
+func trustAnchorKey(zone string, keyTag uint16) string {
+	name := strings.TrimSuffix(strings.ToLower(zone), ".")
+	if name == "" {
+		name = "root"
+	}
+	return "trustanchors/" + name + "/" + strconv.Itoa(int(keyTag))
+}
+
+func (db EtcdDB) ListTrustAnchors() ([]TrustAnchor, error) {
+	response, err := db.client.Get("trustanchors", true, true)
+	if etcdKeyNotFound(err) {
+		return []TrustAnchor{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	anchors := []TrustAnchor{}
+	for _, zone := range response.Node.Nodes {
+		for _, node := range zone.Nodes {
+			var anchor TrustAnchor
+			if err := json.Unmarshal([]byte(node.Value), &anchor); err != nil {
+				logger.Printf("[DNSSEC] Skipping unreadable trust anchor %s: %s\n", node.Key, err)
+				continue
+			}
+			anchors = append(anchors, anchor)
+		}
+	}
+	return anchors, nil
+}
+
+// SaveTrustAnchor stores the anchor, auditing changes of its state
+func (db EtcdDB) SaveTrustAnchor(actor string, anchor TrustAnchor) error {
+	data, err := json.Marshal(anchor)
+	if err != nil {
+		return err
+	}
+	response, err := db.client.Set(trustAnchorKey(anchor.Zone, anchor.KeyTag), string(data), 0)
+	if err != nil {
+		return err
+	}
+	var old TrustAnchor
+	if response.PrevNode != nil {
+		json.Unmarshal([]byte(response.PrevNode.Value), &old)
+	}
+	auditChange(db, actor, "dns", strings.TrimPrefix(trustAnchorKey(anchor.Zone, anchor.KeyTag), "trustanchors/"), "set", old.State, anchor.State)
+	return nil
+}
+
+func (db EtcdDB) DeleteTrustAnchor(actor string, zone string, keyTag uint16) error {
+	response, err := db.client.Delete(trustAnchorKey(zone, keyTag), false)
+	if etcdKeyNotFound(err) {
+		return notFoundError("no trust anchor %d for %s", keyTag, zone)
+	}
+	if err != nil {
+		return err
+	}
+	var old TrustAnchor
+	if response.PrevNode != nil {
+		json.Unmarshal([]byte(response.PrevNode.Value), &old)
+	}
+	auditChange(db, actor, "dns", strings.TrimPrefix(trustAnchorKey(zone, keyTag), "trustanchors/"), "delete", old.State, "")
+	return nil
+}
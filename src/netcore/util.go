@@ -1,18 +1,43 @@
-package main
+package netcore
 
 import (
-	"os/exec"
+	"net"
+	"os"
+	"regexp"
 	"strings"
-
-	"code.google.com/p/go-uuid/uuid"
 )
 
+// InstanceName is the name this instance's settings are stored under in
+// config/<name>: $NETCORE_NAME, else the first element of $ETCD_NAME's path,
+// else the host's fully qualified name
+func InstanceName() (string, error) {
+	if name := os.Getenv("NETCORE_NAME"); name != "" {
+		return name, nil
+	}
+	if name := os.Getenv("ETCD_NAME"); name != "" {
+		if parts := regexp.MustCompile(`^/([^/]+)/`).FindStringSubmatch(name); len(parts) > 1 {
+			return parts[1], nil
+		}
+		return "", nil
+	}
+	return getHostname()
+}
+
+// getHostname returns the fully qualified name of this host, like hostname
+// -f but without running it, so that it works wherever Go does. A short name
+// that the resolver cannot qualify is returned as it is.
 func getHostname() (string, error) {
-	fqdn, err := exec.Command("hostname", "-f").Output()
+	hostname, err := os.Hostname()
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(fqdn)), nil
+	if strings.Contains(hostname, ".") {
+		return hostname, nil
+	}
+	if cname, err := net.LookupCNAME(hostname); err == nil && strings.Contains(strings.TrimSuffix(cname, "."), ".") {
+		return strings.TrimSuffix(cname, "."), nil
+	}
+	return hostname, nil
 }
 
 func reverseSlice(in []string) []string {
@@ -22,7 +47,3 @@ func reverseSlice(in []string) []string {
 	}
 	return out
 }
-
-func getUUID() string {
-	return uuid.New()
-}
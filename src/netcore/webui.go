@@ -0,0 +1,247 @@
+package netcore
+
+import (
+	"net/http"
+)
+
+// webUI serves the web UI, a single page that works through the admin API
+// with a token the user types in, which the browser keeps for the session.
+// The page itself holds no data, so it needs no token.
+//
+//	GET /ui/
+func webUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/ui/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Write([]byte(webUIPage))
+}
+
+const webUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>netcore</title>
+<style>
+body { font: 14px sans-serif; margin: 0; color: #222; }
+header { background: #234; color: #fff; padding: 8px 16px; display: flex; gap: 16px; align-items: center; }
+header a { color: #cde; cursor: pointer; text-decoration: none; }
+header a.active { color: #fff; font-weight: bold; }
+header input { margin-left: auto; }
+main { padding: 16px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 4px 8px; border-bottom: 1px solid #ddd; font-family: monospace; }
+th { font-family: sans-serif; background: #f4f4f4; }
+.error { color: #b00; margin: 8px 0; }
+.bar { background: #eee; width: 200px; height: 12px; display: inline-block; }
+.bar div { background: #48a; height: 12px; }
+.bar div.full { background: #c44; }
+form { margin: 8px 0; display: flex; gap: 4px; }
+section { display: none; }
+section.active { display: block; }
+</style>
+</head>
+<body>
+<header>
+<strong>netcore</strong>
+<a data-tab="records">Records</a>
+<a data-tab="leases">Leases</a>
+<a data-tab="pools">Pools</a>
+<a data-tab="querylog">Query log</a>
+<input id="token" type="password" placeholder="API token" size="24">
+</header>
+<main>
+<div id="error" class="error"></div>
+
+<section id="records">
+<form id="zone-form">
+<select id="zone"></select>
+<input id="selector" placeholder="label selector, such as env=prod" size="30">
+<button>Show</button>
+</form>
+<form id="add-form">
+<input id="add-name" placeholder="name" size="24">
+<input id="add-type" placeholder="type" size="6" value="A">
+<input id="add-ttl" placeholder="TTL" size="6">
+<input id="add-value" placeholder="value" size="30">
+<button>Add record</button>
+</form>
+<table><thead><tr><th>Name</th><th>Type</th><th>TTL</th><th>Value</th><th>Attributes</th><th>Labels</th><th></th></tr></thead><tbody id="record-rows"></tbody></table>
+</section>
+
+<section id="leases">
+<form id="lease-form">
+<input id="lease-filter" placeholder="filter by MAC, address or name" size="30">
+</form>
+<table><thead><tr><th>MAC</th><th>Address</th><th>Name</th><th>Expires</th><th>Vendor class</th><th>Labels</th></tr></thead><tbody id="lease-rows"></tbody></table>
+</section>
+
+<section id="pools">
+<table><thead><tr><th>Zone</th><th>Pool</th><th>Size</th><th>Leased</th><th>Reserved</th><th>Free</th><th>Used</th></tr></thead><tbody id="pool-rows"></tbody></table>
+</section>
+
+<section id="querylog">
+<form><label><input id="follow" type="checkbox" checked> Follow</label></form>
+<table><thead><tr><th>Time</th><th>Client</th><th>Name</th><th>Type</th><th>Result</th><th>Answers</th><th>ms</th></tr></thead><tbody id="query-rows"></tbody></table>
+</section>
+</main>
+
+<script>
+"use strict";
+var $ = function (id) { return document.getElementById(id); };
+var tokenInput = $("token");
+tokenInput.value = sessionStorage.getItem("netcore-token") || "";
+tokenInput.onchange = function () { sessionStorage.setItem("netcore-token", tokenInput.value); show(current); };
+
+function api(method, path, body) {
+	var options = { method: method, headers: { "Authorization": "Bearer " + tokenInput.value } };
+	if (body !== undefined) {
+		options.headers["Content-Type"] = "application/json";
+		options.body = JSON.stringify(body);
+	}
+	return fetch(path, options).then(function (response) {
+		return response.json().then(function (data) {
+			if (!response.ok) { throw new Error(data.error || response.statusText); }
+			$("error").textContent = "";
+			return data;
+		});
+	}).catch(function (err) { $("error").textContent = err.message; throw err; });
+}
+
+function cell(row, text) {
+	var td = document.createElement("td");
+	td.textContent = text === undefined || text === null ? "" : text;
+	row.appendChild(td);
+	return td;
+}
+
+function pairs(map) {
+	return Object.keys(map || {}).sort().map(function (k) { return k + "=" + map[k]; }).join(" ");
+}
+
+function fill(tbody, items, render) {
+	tbody.innerHTML = "";
+	items.forEach(function (item) {
+		var row = document.createElement("tr");
+		render(row, item);
+		tbody.appendChild(row);
+	});
+}
+
+function loadZones() {
+	return api("GET", "/api/dns/zones/").then(function (zones) {
+		var select = $("zone"), selected = select.value;
+		select.innerHTML = "";
+		zones.forEach(function (zone) {
+			var option = document.createElement("option");
+			option.textContent = zone;
+			select.appendChild(option);
+		});
+		if (selected) { select.value = selected; }
+		return loadRecords();
+	});
+}
+
+function loadRecords() {
+	var zone = $("zone").value;
+	if (!zone) { return; }
+	var path = "/api/dns/zones/" + encodeURIComponent(zone) + "/records";
+	if ($("selector").value) { path += "?selector=" + encodeURIComponent($("selector").value); }
+	return api("GET", path).then(function (records) {
+		fill($("record-rows"), records, function (row, r) {
+			[r.name, r.type, r.ttl, r.value, pairs(r.attr), pairs(r.labels)].forEach(function (v) { cell(row, v); });
+			if (r.type === "SOA") { cell(row, ""); return; }
+			var button = document.createElement("button");
+			button.textContent = "Delete";
+			button.onclick = function () {
+				if (!confirm("Delete " + r.name + " " + r.type + " " + (r.value || "") + "?")) { return; }
+				changeRecords([{ op: "delete", record: { name: r.name, type: r.type, value: r.value, attr: r.attr } }]);
+			};
+			cell(row, "").appendChild(button);
+		});
+	});
+}
+
+function changeRecords(changes) {
+	api("POST", "/api/dns/zones/" + encodeURIComponent($("zone").value) + "/records", changes).then(loadRecords);
+}
+
+$("zone-form").onsubmit = function (e) { e.preventDefault(); loadRecords(); };
+$("zone").onchange = loadRecords;
+$("add-form").onsubmit = function (e) {
+	e.preventDefault();
+	var record = { name: $("add-name").value, type: $("add-type").value, value: $("add-value").value };
+	if ($("add-ttl").value) { record.ttl = parseInt($("add-ttl").value, 10); }
+	changeRecords([{ op: "add", record: record }]);
+};
+
+var leases = [];
+function loadLeases() {
+	return api("GET", "/api/dhcp/leases").then(function (data) { leases = data; showLeases(); });
+}
+function showLeases() {
+	var filter = $("lease-filter").value.toLowerCase();
+	fill($("lease-rows"), leases.filter(function (l) {
+		return !filter || (l.mac + " " + l.ip + " " + (l.hostname || "")).toLowerCase().indexOf(filter) >= 0;
+	}), function (row, l) {
+		[l.mac, l.ip, l.hostname, l.expires || "reserved", l.vendor_class, pairs(l.labels)].forEach(function (v) { cell(row, v); });
+	});
+}
+$("lease-filter").oninput = showLeases;
+$("lease-form").onsubmit = function (e) { e.preventDefault(); };
+
+function loadPools() {
+	return api("GET", "/api/dhcp/pools").then(function (pools) {
+		fill($("pool-rows"), pools, function (row, p) {
+			[p.zone, p.pool, p.size, p.leased, p.reserved, p.free].forEach(function (v) { cell(row, v); });
+			var bar = document.createElement("div"), used = document.createElement("div");
+			bar.className = "bar";
+			used.style.width = Math.min(100, Math.round(p.used * 100)) + "%";
+			used.className = p.used >= 0.9 ? "full" : "";
+			bar.appendChild(used);
+			var td = cell(row, "");
+			td.appendChild(bar);
+			td.appendChild(document.createTextNode(" " + Math.round(p.used * 100) + "%"));
+		});
+	});
+}
+
+var lastQuery = null, queryTimer = null;
+function pollQueries() {
+	var path = "/api/dns/querylog?limit=200" + (lastQuery === null ? "" : "&after=" + lastQuery);
+	api("GET", path).then(function (data) {
+		lastQuery = data.last;
+		var tbody = $("query-rows");
+		data.entries.forEach(function (q) {
+			var row = document.createElement("tr");
+			[q.time.replace("T", " ").replace("Z", ""), q.client, q.name, q.type, q.rcode, q.answers, q.duration_ms.toFixed(1)].forEach(function (v) { cell(row, v); });
+			tbody.insertBefore(row, tbody.firstChild);
+		});
+		while (tbody.childNodes.length > 500) { tbody.removeChild(tbody.lastChild); }
+	}).then(schedule, schedule);
+	function schedule() {
+		if (current === "querylog" && $("follow").checked) { queryTimer = setTimeout(pollQueries, 1000); }
+	}
+}
+$("follow").onchange = function () { clearTimeout(queryTimer); if ($("follow").checked) { pollQueries(); } };
+
+var current = "records";
+var loaders = { records: loadZones, leases: loadLeases, pools: loadPools, querylog: pollQueries };
+function show(tab) {
+	current = tab;
+	clearTimeout(queryTimer);
+	Array.prototype.forEach.call(document.querySelectorAll("section"), function (s) { s.className = s.id === tab ? "active" : ""; });
+	Array.prototype.forEach.call(document.querySelectorAll("header a"), function (a) { a.className = a.getAttribute("data-tab") === tab ? "active" : ""; });
+	if (tokenInput.value) { loaders[tab](); }
+}
+Array.prototype.forEach.call(document.querySelectorAll("header a"), function (a) {
+	a.onclick = function () { show(a.getAttribute("data-tab")); };
+});
+show(location.hash.slice(1) in loaders ? location.hash.slice(1) : "records");
+</script>
+</body>
+</html>
+`
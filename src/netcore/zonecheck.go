@@ -0,0 +1,349 @@
+package netcore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Zone check severities: errors break resolution of the names involved,
+// warnings are legal but likely mistakes
+const (
+	ProblemError   = "error"
+	ProblemWarning = "warning"
+)
+
+// TTLs outside these bounds are legal but are rarely what was meant: a TTL
+// under a minute defeats caches, and one over a week makes mistakes last
+const (
+	zoneCheckMinTTL    = 30
+	zoneCheckMaxTTL    = 604800
+	zoneCheckMaxMinTTL = 86400 // RFC 2308 suggests one to three hours
+)
+
+// ZoneProblem is something wrong with the data of a zone
+type ZoneProblem struct {
+	Severity string `json:"severity"`
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (p ZoneProblem) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s: %s", p.Name, p.Type, p.Message))
+}
+
+// byZoneProblem orders problems by name, type and message
+type byZoneProblem []ZoneProblem
+
+func (p byZoneProblem) Len() int      { return len(p) }
+func (p byZoneProblem) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byZoneProblem) Less(i, j int) bool {
+	if p[i].Name != p[j].Name {
+		return p[i].Name < p[j].Name
+	}
+	if p[i].Type != p[j].Type {
+		return p[i].Type < p[j].Type
+	}
+	return p[i].Message < p[j].Message
+}
+
+// zoneChecker holds the records of a zone by name and type
+type zoneChecker struct {
+	zone     string
+	records  map[string]map[string][]DNSRecord
+	children []string // zones below zone with an SOA of their own
+	problems []ZoneProblem
+}
+
+// checkZone looks for mistakes in the records of zone: illegal names, CNAMEs
+// with other data or pointing nowhere, MX and SRV targets that are aliases
+// or have no address, delegations without the glue they need, and unusual
+// TTLs. Records of child zones with an SOA of their own are left out; they
+// are checked with their zone.
+func checkZone(zone string, records []DNSRecord) []ZoneProblem {
+	c := &zoneChecker{zone: cleanFQDN(zone), records: make(map[string]map[string][]DNSRecord)}
+	for _, record := range records {
+		if record.Type == "SOA" && record.Name != c.zone && c.inZone(record.Name) {
+			c.children = append(c.children, record.Name)
+		}
+	}
+	for _, record := range records {
+		if !c.inZone(record.Name) {
+			continue
+		}
+		if c.records[record.Name] == nil {
+			c.records[record.Name] = make(map[string][]DNSRecord)
+		}
+		c.records[record.Name][record.Type] = append(c.records[record.Name][record.Type], record)
+	}
+
+	names := make([]string, 0, len(c.records))
+	for name := range c.records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.checkName(name)
+		types := c.records[name]
+		if _, ok := types["CNAME"]; ok && len(types) > 1 {
+			others := make([]string, 0, len(types))
+			for rrType := range types {
+				if rrType != "CNAME" {
+					others = append(others, rrType)
+				}
+			}
+			sort.Strings(others)
+			c.problem(ProblemError, name, "CNAME", "a CNAME cannot have other data, but %s also has %s", name, strings.Join(others, ", "))
+		}
+		if _, ok := types["ALIAS"]; ok && c.has(name, "A", "AAAA") {
+			c.problem(ProblemError, name, "ALIAS", "%s has A or AAAA records, which answer instead of its ALIAS", name)
+		}
+		for rrType, rrset := range types {
+			c.checkTTL(name, rrType, rrset)
+			for _, record := range rrset {
+				c.checkRecord(record)
+			}
+		}
+	}
+	sort.Sort(byZoneProblem(c.problems))
+	return c.problems
+}
+
+func (c *zoneChecker) problem(severity, name, rrType, format string, args ...interface{}) {
+	c.problems = append(c.problems, ZoneProblem{Severity: severity, Name: name, Type: rrType, Message: fmt.Sprintf(format, args...)})
+}
+
+// inZone returns true if name is zone or below it, and not in a child zone
+func (c *zoneChecker) inZone(name string) bool {
+	if !dns.IsSubDomain(dns.Fqdn(c.zone), dns.Fqdn(name)) {
+		return false
+	}
+	for _, child := range c.children {
+		if dns.IsSubDomain(dns.Fqdn(child), dns.Fqdn(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// delegation returns the delegated name at or above name, if any
+func (c *zoneChecker) delegation(name string) string {
+	for cut := name; cut != c.zone && cut != ""; {
+		if _, ok := c.records[cut]["NS"]; ok {
+			return cut
+		}
+		i := strings.Index(cut, ".")
+		if i < 0 {
+			break
+		}
+		cut = cut[i+1:]
+	}
+	return ""
+}
+
+// has returns true if name has records of any of types
+func (c *zoneChecker) has(name string, types ...string) bool {
+	for _, rrType := range types {
+		if len(c.records[name][rrType]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkName reports names that are not domain names, wildcards that are not
+// the leftmost label, and host names that break the letters, digits and
+// hyphen rule of RFC 952 and RFC 1123
+func (c *zoneChecker) checkName(name string) {
+	if !validDomainName(name) {
+		c.problem(ProblemError, name, "", "%q is not a valid domain name", name)
+		return
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if strings.Contains(label, "*") && (i > 0 || label != "*") {
+			c.problem(ProblemError, name, "", "a wildcard must be the whole leftmost label")
+			return
+		}
+	}
+	if !c.has(name, "A", "AAAA", "MX") {
+		return
+	}
+	for i, label := range labels {
+		if i == 0 && label == "*" {
+			continue
+		}
+		if !validHostLabel(label) {
+			c.problem(ProblemWarning, name, "", "%q is not a valid host name label: use letters, digits and hyphens, not at either end", label)
+			return
+		}
+	}
+}
+
+// validHostLabel returns true if label is made of letters, digits and
+// hyphens and neither starts nor ends with a hyphen
+func validHostLabel(label string) bool {
+	if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return false
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTTL reports unusual TTLs, and RRsets whose records disagree on their
+// TTL, which RFC 2181 deprecates
+func (c *zoneChecker) checkTTL(name, rrType string, rrset []DNSRecord) {
+	if rrType == "SOA" {
+		if minttl, err := strconv.ParseUint(rrset[0].Attr["minttl"], 10, 32); err == nil && minttl > zoneCheckMaxMinTTL {
+			c.problem(ProblemWarning, name, rrType, "negative answers are cached for %d seconds (minttl); more than %d keeps new names from resolving", minttl, zoneCheckMaxMinTTL)
+		}
+		return
+	}
+	ttls := make(map[uint32]bool)
+	for _, record := range rrset {
+		ttls[record.TTL] = true
+	}
+	if len(ttls) > 1 {
+		c.problem(ProblemWarning, name, rrType, "the records have different TTLs; resolvers will use the lowest")
+	}
+	for ttl := range ttls {
+		switch {
+		case ttl == 0: // the server's default
+		case ttl < zoneCheckMinTTL:
+			c.problem(ProblemWarning, name, rrType, "TTL %d is under %d seconds, so caches barely help", ttl, zoneCheckMinTTL)
+		case ttl > zoneCheckMaxTTL:
+			c.problem(ProblemWarning, name, rrType, "TTL %d is over a week, so changes will take that long to be seen", ttl)
+		}
+	}
+}
+
+// checkRecord checks where the record points
+func (c *zoneChecker) checkRecord(record DNSRecord) {
+	switch record.Type {
+	case "CNAME":
+		target := cleanFQDN(record.Value)
+		if target == record.Name {
+			c.problem(ProblemError, record.Name, record.Type, "the CNAME points to itself")
+		} else if c.inZone(target) && c.delegation(target) == "" && len(c.records[target]) == 0 && !c.wildcardCovers(target) {
+			c.problem(ProblemError, record.Name, record.Type, "the CNAME points to %s, which does not exist", target)
+		}
+	case "ALIAS":
+		target := cleanFQDN(record.Value)
+		if target == record.Name {
+			c.problem(ProblemError, record.Name, record.Type, "the ALIAS points to itself")
+		} else if c.inZone(target) && c.delegation(target) == "" && !c.has(target, "A", "AAAA", "CNAME", "ALIAS") && !c.wildcardCovers(target) {
+			c.problem(ProblemError, record.Name, record.Type, "the ALIAS points to %s, which has no address", target)
+		}
+	case "MX", "SRV":
+		c.checkTarget(record, recordTarget(record))
+	case "NS":
+		c.checkNameServer(record, cleanFQDN(record.Value))
+	}
+}
+
+// recordTarget returns the target of an MX or SRV record, which is either
+// in its target attribute or, for SRV records, before the port in its value
+func recordTarget(record DNSRecord) string {
+	if target, ok := record.Attr["target"]; ok {
+		return cleanFQDN(target)
+	}
+	if record.Type == "SRV" {
+		return cleanFQDN(strings.Split(record.Value, ":")[0])
+	}
+	return cleanFQDN(record.Value)
+}
+
+// checkTarget reports MX and SRV targets that are aliases, which RFC 2181
+// and RFC 2782 forbid, or that are in the zone but have no address
+func (c *zoneChecker) checkTarget(record DNSRecord, target string) {
+	if !c.inZone(target) || c.delegation(target) != "" {
+		return
+	}
+	if c.has(target, "CNAME") {
+		c.problem(ProblemError, record.Name, record.Type, "the target %s is a CNAME; it must be the name that has the address", target)
+	} else if !c.has(target, "A", "AAAA") && !c.wildcardCovers(target) {
+		c.problem(ProblemError, record.Name, record.Type, "the target %s has no A or AAAA record", target)
+	}
+}
+
+// checkNameServer reports name servers in the zone without an address. For
+// a delegation, the address of a name server below the delegated name is
+// glue, without which the delegation cannot be followed.
+func (c *zoneChecker) checkNameServer(record DNSRecord, target string) {
+	if !c.inZone(target) {
+		return
+	}
+	if c.has(target, "CNAME") {
+		c.problem(ProblemError, record.Name, record.Type, "the name server %s is a CNAME; it must be the name that has the address", target)
+		return
+	}
+	if c.has(target, "A", "AAAA") {
+		return
+	}
+	if record.Name != c.zone && dns.IsSubDomain(dns.Fqdn(record.Name), dns.Fqdn(target)) {
+		c.problem(ProblemError, record.Name, record.Type, "missing glue: the name server %s is inside the delegation but has no A or AAAA record", target)
+	} else if cut := c.delegation(target); cut == "" || cut == record.Name {
+		c.problem(ProblemError, record.Name, record.Type, "the name server %s has no A or AAAA record", target)
+	}
+}
+
+// wildcardCovers returns true if a wildcard in the zone answers for name
+func (c *zoneChecker) wildcardCovers(name string) bool {
+	for i := strings.Index(name, "."); i >= 0; i = strings.Index(name, ".") {
+		name = name[i+1:]
+		if len(c.records["*."+name]) > 0 {
+			return true
+		}
+		if _, exists := c.records[name]; exists || name == c.zone {
+			return false // the closest encloser has no wildcard
+		}
+	}
+	return false
+}
+
+// zoneAfter returns the records of a zone once changes are applied, as
+// ApplyDNSChanges would leave them
+func zoneAfter(records []DNSRecord, changes []DNSChange) []DNSRecord {
+	after := make([]DNSRecord, len(records))
+	copy(after, records)
+	for _, change := range changes {
+		r := change.Record
+		kept := after[:0]
+		for _, record := range after {
+			same := record.Name == r.Name && record.Type == r.Type
+			if same && (r.Type == "SOA" || change.Op == "delete" && r.Value == "" && len(r.Attr) == 0 || record.valueID() == r.valueID()) {
+				continue
+			}
+			kept = append(kept, record)
+		}
+		after = kept
+		if change.Op == "add" {
+			after = append(after, r)
+		}
+	}
+	return after
+}
+
+// newZoneErrors returns the errors in the zone once changes are applied
+// that were not there before
+func newZoneErrors(zone string, records []DNSRecord, changes []DNSChange) []ZoneProblem {
+	before := make(map[ZoneProblem]bool)
+	for _, p := range checkZone(zone, records) {
+		before[p] = true
+	}
+	var added []ZoneProblem
+	for _, p := range checkZone(zone, zoneAfter(records, changes)) {
+		if p.Severity == ProblemError && !before[p] {
+			added = append(added, p)
+		}
+	}
+	return added
+}
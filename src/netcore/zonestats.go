@@ -0,0 +1,381 @@
+package netcore
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	zoneStatsReport       = Flags.String("zonestatsreport", "", "Directory that a summary of each zone's DNS traffic over the day is written to every midnight UTC, for capacity and SLA reviews (empty to disable).")
+	zoneStatsReportFormat = Flags.String("zonestatsreportformat", "json", "Format of the daily zone statistics report: json or csv.")
+)
+
+// zoneStatsRetention is how long per-zone statistics are kept, which is the
+// longest window they can be asked for
+const zoneStatsRetention = 24 * time.Hour
+
+// zoneStatsOther is the zone that questions outside of our zones, which are
+// forwarded or resolved, are counted under
+const zoneStatsOther = "."
+
+// zoneLatencyBuckets are the upper bounds of the answer time histograms
+// that percentiles are estimated from
+var zoneLatencyBuckets = []time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// ZoneStats are the DNS questions about one zone over a window. Latency
+// percentiles are in milliseconds, and are the upper bounds of histogram
+// buckets, so they err on the slow side.
+type ZoneStats struct {
+	Zone          string  `json:"zone"`
+	Queries       int64   `json:"queries"`
+	NXDomain      int64   `json:"nxdomain"`
+	NXDomainRatio float64 `json:"nxdomain_ratio"`
+	ServFail      int64   `json:"servfail"`
+	ServFailRatio float64 `json:"servfail_ratio"`
+	P50           float64 `json:"p50_ms"`
+	P90           float64 `json:"p90_ms"`
+	P99           float64 `json:"p99_ms"`
+}
+
+// zoneCounts are the counters of a zone for one minute
+type zoneCounts struct {
+	queries, nxdomain, servfail int64
+	latency                     []int64 // by zoneLatencyBuckets, then slower
+}
+
+// zoneStatsTracker counts questions per zone in one-minute buckets, like
+// clientStatsTracker. The zones are refreshed by zoneStatsSetup; names
+// outside of them are counted under zoneStatsOther.
+type zoneStatsTracker struct {
+	sync.Mutex
+	zones   []string // longest first, so that subzones win
+	buckets []zoneStatsBucket
+}
+
+type zoneStatsBucket struct {
+	minute int64
+	zones  map[string]*zoneCounts
+}
+
+var zoneStats = &zoneStatsTracker{}
+
+// zoneOf returns the zone that name is counted under; the caller must hold
+// the lock
+func (t *zoneStatsTracker) zoneOf(name string) string {
+	name = strings.ToLower(dns.Fqdn(name))
+	for _, zone := range t.zones {
+		if dns.IsSubDomain(zone, name) {
+			return zone
+		}
+	}
+	return zoneStatsOther
+}
+
+// Record counts the questions of a query that was answered with rcode,
+// elapsed after it arrived
+func (t *zoneStatsTracker) Record(questions []dns.Question, rcode int, elapsed time.Duration) {
+	bucket := len(zoneLatencyBuckets)
+	for i, bound := range zoneLatencyBuckets {
+		if elapsed <= bound {
+			bucket = i
+			break
+		}
+	}
+	minute := time.Now().Unix() / 60
+
+	t.Lock()
+	defer t.Unlock()
+	if n := len(t.buckets); n == 0 || t.buckets[n-1].minute != minute {
+		t.buckets = append(t.buckets, zoneStatsBucket{minute: minute, zones: make(map[string]*zoneCounts)})
+		t.expire(minute)
+	}
+	current := t.buckets[len(t.buckets)-1]
+	for _, q := range questions {
+		zone := t.zoneOf(q.Name)
+		counts, ok := current.zones[zone]
+		if !ok {
+			counts = &zoneCounts{latency: make([]int64, len(zoneLatencyBuckets)+1)}
+			current.zones[zone] = counts
+		}
+		counts.queries++
+		switch rcode {
+		case dns.RcodeNameError:
+			counts.nxdomain++
+		case dns.RcodeServerFailure:
+			counts.servfail++
+		}
+		counts.latency[bucket]++
+	}
+}
+
+// expire drops buckets older than zoneStatsRetention; the caller must hold
+// the lock
+func (t *zoneStatsTracker) expire(minute int64) {
+	oldest := minute - int64(zoneStatsRetention.Minutes()) + 1
+	i := 0
+	for i < len(t.buckets) && t.buckets[i].minute < oldest {
+		i++
+	}
+	t.buckets = t.buckets[i:]
+}
+
+// setZones replaces the zones that questions are counted under
+func (t *zoneStatsTracker) setZones(zones []string) {
+	sorted := make([]string, len(zones))
+	for i, zone := range zones {
+		sorted[i] = strings.ToLower(dns.Fqdn(zone))
+	}
+	sort.Sort(byLabelCount(sorted))
+	t.Lock()
+	t.zones = sorted
+	t.Unlock()
+}
+
+// byLabelCount orders names from the most labels to the fewest
+type byLabelCount []string
+
+func (n byLabelCount) Len() int      { return len(n) }
+func (n byLabelCount) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n byLabelCount) Less(i, j int) bool {
+	a, b := dns.CountLabel(n[i]), dns.CountLabel(n[j])
+	if a != b {
+		return a > b
+	}
+	return n[i] < n[j]
+}
+
+// Summary returns the statistics of every zone over the window ending now,
+// in order of zone
+func (t *zoneStatsTracker) Summary(window time.Duration) []ZoneStats {
+	now := time.Now().Unix() / 60
+	oldest := now - int64(window.Minutes()) + 1
+	t.Lock()
+	t.expire(now)
+	totals := make(map[string]*zoneCounts)
+	for _, bucket := range t.buckets {
+		if bucket.minute < oldest {
+			continue
+		}
+		for zone, counts := range bucket.zones {
+			total, ok := totals[zone]
+			if !ok {
+				total = &zoneCounts{latency: make([]int64, len(zoneLatencyBuckets)+1)}
+				totals[zone] = total
+			}
+			total.queries += counts.queries
+			total.nxdomain += counts.nxdomain
+			total.servfail += counts.servfail
+			for i, n := range counts.latency {
+				total.latency[i] += n
+			}
+		}
+	}
+	t.Unlock()
+
+	summary := make([]ZoneStats, 0, len(totals))
+	for zone, total := range totals {
+		summary = append(summary, ZoneStats{
+			Zone:          zone,
+			Queries:       total.queries,
+			NXDomain:      total.nxdomain,
+			NXDomainRatio: float64(total.nxdomain) / float64(total.queries),
+			ServFail:      total.servfail,
+			ServFailRatio: float64(total.servfail) / float64(total.queries),
+			P50:           latencyPercentile(total.latency, 50),
+			P90:           latencyPercentile(total.latency, 90),
+			P99:           latencyPercentile(total.latency, 99),
+		})
+	}
+	sort.Sort(zoneStatsByZone(summary))
+	return summary
+}
+
+// latencyPercentile returns the upper bound, in milliseconds, of the bucket
+// that the pth percentile of a histogram falls in; the slowest bucket has
+// no bound, and is reported as twice the last one
+func latencyPercentile(histogram []int64, p int64) float64 {
+	var total int64
+	for _, n := range histogram {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := (total*p + 99) / 100
+	var seen int64
+	for i, n := range histogram {
+		if seen += n; seen >= rank && i < len(zoneLatencyBuckets) {
+			return zoneLatencyBuckets[i].Seconds() * 1000
+		}
+	}
+	return 2 * zoneLatencyBuckets[len(zoneLatencyBuckets)-1].Seconds() * 1000
+}
+
+// zoneStatsByZone sorts statistics by zone
+type zoneStatsByZone []ZoneStats
+
+func (s zoneStatsByZone) Len() int           { return len(s) }
+func (s zoneStatsByZone) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s zoneStatsByZone) Less(i, j int) bool { return s[i].Zone < s[j].Zone }
+
+// writeZoneStats writes statistics over window in format, json or csv
+func writeZoneStats(w io.Writer, format string, window time.Duration, end time.Time, stats []ZoneStats) error {
+	switch format {
+	case "", "json":
+		return json.NewEncoder(w).Encode(map[string]interface{}{
+			"window": window.String(),
+			"end":    end.UTC().Format(time.RFC3339),
+			"zones":  stats,
+		})
+	case "csv":
+		out := csv.NewWriter(w)
+		out.Write([]string{"zone", "queries", "nxdomain", "nxdomain_ratio", "servfail", "servfail_ratio", "p50_ms", "p90_ms", "p99_ms"})
+		for _, s := range stats {
+			out.Write([]string{
+				s.Zone,
+				strconv.FormatInt(s.Queries, 10),
+				strconv.FormatInt(s.NXDomain, 10),
+				strconv.FormatFloat(s.NXDomainRatio, 'f', 4, 64),
+				strconv.FormatInt(s.ServFail, 10),
+				strconv.FormatFloat(s.ServFailRatio, 'f', 4, 64),
+				strconv.FormatFloat(s.P50, 'f', -1, 64),
+				strconv.FormatFloat(s.P90, 'f', -1, 64),
+				strconv.FormatFloat(s.P99, 'f', -1, 64),
+			})
+		}
+		out.Flush()
+		return out.Error()
+	}
+	return invalid("format", "must be json or csv, not %q", format)
+}
+
+// zoneStatsSetup keeps the zones that questions are counted under current,
+// and writes the daily report
+func zoneStatsSetup(cfg *Config) {
+	go func() {
+		for {
+			if err := refreshZoneStatsZones(cfg); err != nil {
+				logger.Printf("Zone statistics could not list the zones: %s\n", err)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+	if *zoneStatsReport == "" {
+		return
+	}
+	go func() {
+		for {
+			now := time.Now().UTC()
+			midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+			time.Sleep(midnight.Sub(now))
+			if err := writeZoneStatsReport(*zoneStatsReport, *zoneStatsReportFormat, midnight); err != nil {
+				logger.Printf("Zone statistics report failed: %s\n", err)
+			}
+		}
+	}()
+}
+
+// refreshZoneStatsZones counts questions under the zones with an SOA and
+// the secondary zones
+func refreshZoneStatsZones(cfg *Config) error {
+	records, err := cfg.db.ListDNSZone("")
+	if err != nil {
+		return err
+	}
+	var zones []string
+	for _, record := range records {
+		if record.Type == "SOA" {
+			zones = append(zones, record.Name)
+		}
+	}
+	for zone := range cfg.DNSSecondaryZones() {
+		zones = append(zones, zone)
+	}
+	zoneStats.setZones(zones)
+	return nil
+}
+
+// writeZoneStatsReport writes the statistics of the day that ended at end
+// to zonestats-<day>.<format> in dir
+func writeZoneStatsReport(dir, format string, end time.Time) error {
+	day := end.Add(-zoneStatsRetention).Format("2006-01-02")
+	path := filepath.Join(dir, "zonestats-"+day+"."+format)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeZoneStats(f, format, zoneStatsRetention, end, zoneStats.Summary(zoneStatsRetention)); err != nil {
+		f.Close()
+		return err
+	}
+	logger.Printf("Wrote the zone statistics of %s to %s\n", day, path)
+	return f.Close()
+}
+
+func checkZoneStatsReport() error {
+	if *zoneStatsReportFormat != "json" && *zoneStatsReportFormat != "csv" {
+		return fmt.Errorf("-zonestatsreportformat must be json or csv, not %q", *zoneStatsReportFormat)
+	}
+	return nil
+}
+
+// apiZoneStats reports per-zone DNS statistics over a window of up to a
+// day, as JSON or CSV. Only the admin may see them.
+//
+//	GET /api/dns/zonestats?window=1h&format=csv
+func apiZoneStats(cfg *Config, id apiIdentity, w http.ResponseWriter, r *http.Request) {
+	if !id.Admin {
+		apiWriteError(w, http.StatusForbidden, errors.New("only the admin token may view zone statistics"))
+		return
+	}
+	if r.Method != "GET" {
+		apiWriteError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s %s", r.Method, r.URL.Path))
+		return
+	}
+	window := time.Hour
+	if value := r.URL.Query().Get("window"); value != "" {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil || window < time.Minute || window > zoneStatsRetention {
+			apiWriteError(w, http.StatusBadRequest, invalid("window", "must be a duration from 1m to %s", zoneStatsRetention))
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		apiWriteError(w, http.StatusBadRequest, invalid("format", "must be json or csv, not %q", format))
+		return
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	writeZoneStats(w, format, window, time.Now(), zoneStats.Summary(window))
+}
@@ -0,0 +1,1263 @@
+// Code generated by netcore-clients from the OpenAPI document of netcore; DO NOT EDIT.
+
+// Package netcoreclient calls the admin API of a netcore cluster.
+package netcoreclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the admin API of a netcore cluster
+type Client struct {
+	BaseURL    string       // such as http://127.0.0.1:8053
+	Token      string       // the admin token or a tenant token
+	HTTPClient *http.Client // http.DefaultClient if nil
+}
+
+// New returns a client of the admin API at baseURL that authenticates with
+// token
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: baseURL, Token: token}
+}
+
+// Error is an error that the admin API answered with
+type Error struct {
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+	Field   string `json:"field,omitempty"` // the field at fault in an invalid request
+}
+
+func (e *Error) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("netcore: %d %s (%s)", e.Status, e.Message, e.Field)
+	}
+	return fmt.Sprintf("netcore: %d %s", e.Status, e.Message)
+}
+
+// pathEscape escapes s to be a segment of a path
+func pathEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// do sends a request with in as its body, JSON encoded unless it is an
+// io.Reader, and decodes the response into out, unless it is nil. A *string
+// out takes a response that is not JSON as it is.
+func (c *Client) do(method, path string, query url.Values, in, out interface{}) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body io.Reader
+	contentType := ""
+	switch in := in.(type) {
+	case nil:
+	case io.Reader:
+		body, contentType = in, "text/plain"
+	default:
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(encoded), "application/json"
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	payload, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if response.StatusCode >= 300 {
+		e := &Error{Status: response.StatusCode}
+		if mediaType != "application/json" || json.Unmarshal(payload, e) != nil || e.Message == "" {
+			e.Message = http.StatusText(response.StatusCode)
+		}
+		return e
+	}
+	if text, ok := out.(*string); ok && mediaType != "application/json" {
+		*text = string(payload)
+		return nil
+	}
+	if out == nil || len(payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(payload, out)
+}
+
+// AuditEntry is a schema of the admin API
+type AuditEntry struct {
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	Key    string    `json:"key"`
+	Kind   string    `json:"kind"`
+	New    string    `json:"new,omitempty"`
+	Old    string    `json:"old,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// ConfigValue is a schema of the admin API
+type ConfigValue struct {
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description"`
+	Error       string `json:"error,omitempty"`
+	Key         string `json:"key"`
+	Scope       string `json:"scope"`
+	Source      string `json:"source"`
+	Value       string `json:"value"`
+}
+
+// DHCPLease is a schema of the admin API
+type DHCPLease struct {
+	CircuitID   string            `json:"circuit_id,omitempty"`
+	Expires     time.Time         `json:"expires,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	IP          string            `json:"ip"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	MAC         string            `json:"mac"`
+	RemoteID    string            `json:"remote_id,omitempty"`
+	VendorClass string            `json:"vendor_class,omitempty"`
+}
+
+// DHCPPoolUsage is a schema of the admin API
+type DHCPPoolUsage struct {
+	Free     int64   `json:"free"`
+	Leased   int64   `json:"leased"`
+	Pool     string  `json:"pool"`
+	Reserved int64   `json:"reserved"`
+	Size     int64   `json:"size"`
+	Used     float64 `json:"used"`
+	Zone     string  `json:"zone"`
+}
+
+// DNSChange is a schema of the admin API
+type DNSChange struct {
+	Op     string     `json:"op"`
+	Record *DNSRecord `json:"record"`
+}
+
+// DNSProfile is a schema of the admin API
+type DNSProfile struct {
+	Name     string   `json:"name"`
+	Rules    []string `json:"rules"`
+	Schedule string   `json:"schedule"`
+	Timezone string   `json:"timezone,omitempty"`
+}
+
+// DNSRecord is a schema of the admin API
+type DNSRecord struct {
+	Attr   map[string]string `json:"attr,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Name   string            `json:"name"`
+	TTL    int64             `json:"ttl,omitempty"`
+	Type   string            `json:"type"`
+	Value  string            `json:"value,omitempty"`
+}
+
+// DNSRecordVersion is a schema of the admin API
+type DNSRecordVersion struct {
+	Actor string      `json:"actor"`
+	Name  string      `json:"name"`
+	New   []DNSRecord `json:"new"`
+	Old   []DNSRecord `json:"old"`
+	Time  time.Time   `json:"time"`
+	Type  string      `json:"type"`
+}
+
+// DNSSECKey is a schema of the admin API
+type DNSSECKey struct {
+	Algorithm int64     `json:"algorithm"`
+	Changed   time.Time `json:"changed"`
+	Created   time.Time `json:"created"`
+	Dnskey    string    `json:"dnskey"`
+	DS        string    `json:"ds,omitempty"`
+	KeyTag    int64     `json:"key_tag"`
+	Private   string    `json:"private,omitempty"`
+	Role      string    `json:"role"`
+	State     string    `json:"state"`
+	Zone      string    `json:"zone"`
+}
+
+// FleetMember is a schema of the admin API
+type FleetMember struct {
+	Alive     bool      `json:"alive,omitempty"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Hostname  string    `json:"hostname"`
+	ID        string    `json:"id"`
+	// nanoseconds
+	Interval int64             `json:"interval"`
+	Leads    []string          `json:"leads"`
+	Listen   map[string]string `json:"listen"`
+	Pid      int64             `json:"pid"`
+	Roles    []string          `json:"roles"`
+	Started  time.Time         `json:"started"`
+	Subnets  []string          `json:"subnets"`
+	Version  string            `json:"version"`
+	Zones    []string          `json:"zones"`
+}
+
+// Incident is a schema of the admin API
+type Incident struct {
+	Client string    `json:"client"`
+	Count  int64     `json:"count"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+	Name   string    `json:"name"`
+	Rule   string    `json:"rule"`
+	Type   string    `json:"type"`
+}
+
+// InventoryHost is a schema of the admin API
+type InventoryHost struct {
+	Class       string            `json:"class,omitempty"`
+	IP          string            `json:"ip"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	MAC         string            `json:"mac,omitempty"`
+	Name        string            `json:"name"`
+	Source      string            `json:"source"`
+	Subnet      string            `json:"subnet,omitempty"`
+	VendorClass string            `json:"vendor_class,omitempty"`
+	Zone        string            `json:"zone,omitempty"`
+}
+
+// QueryTrace is a schema of the admin API
+type QueryTrace struct {
+	Additional []string         `json:"additional,omitempty"`
+	Answer     []string         `json:"answer"`
+	Authority  []string         `json:"authority,omitempty"`
+	Client     string           `json:"client,omitempty"`
+	DNSSEC     bool             `json:"dnssec"`
+	Name       string           `json:"name"`
+	Rcode      string           `json:"rcode"`
+	Steps      []QueryTraceStep `json:"steps"`
+	Type       string           `json:"type"`
+}
+
+// QueryTraceStep is a schema of the admin API
+type QueryTraceStep struct {
+	Handler  string   `json:"handler"`
+	Notes    []string `json:"notes,omitempty"`
+	Passed   bool     `json:"passed"`
+	Question string   `json:"question"`
+	Records  int64    `json:"records"`
+}
+
+// RRSet is a schema of the admin API
+type RRSet struct {
+	ID     string            `json:"id"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Name   string            `json:"name"`
+	TTL    int64             `json:"ttl,omitempty"`
+	Type   string            `json:"type"`
+	Values []RRSetValue      `json:"values"`
+}
+
+// RRSetValue is a schema of the admin API
+type RRSetValue struct {
+	Attr  map[string]string `json:"attr,omitempty"`
+	Value string            `json:"value,omitempty"`
+}
+
+// Reservation is a schema of the admin API
+type Reservation struct {
+	CircuitID   string            `json:"circuit_id,omitempty"`
+	Expires     time.Time         `json:"expires,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	ID          string            `json:"id"`
+	IP          string            `json:"ip"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	MAC         string            `json:"mac"`
+	RemoteID    string            `json:"remote_id,omitempty"`
+	VendorClass string            `json:"vendor_class,omitempty"`
+}
+
+// SRVWeightChange is a schema of the admin API
+type SRVWeightChange struct {
+	Name    string           `json:"name"`
+	Op      string           `json:"op"`
+	Target  string           `json:"target,omitempty"`
+	Weights map[string]int64 `json:"weights,omitempty"`
+	Zone    string           `json:"zone"`
+}
+
+// SchemaMigration is a schema of the admin API
+type SchemaMigration struct {
+	Summary string `json:"summary"`
+	Version int64  `json:"version"`
+}
+
+// SchemaStatus is a schema of the admin API
+type SchemaStatus struct {
+	Pending   []SchemaMigration `json:"pending,omitempty"`
+	Supported int64             `json:"supported"`
+	Version   int64             `json:"version"`
+}
+
+// ServiceGroup is a schema of the admin API
+type ServiceGroup struct {
+	Instances []ServiceInstance `json:"instances"`
+	Name      string            `json:"name"`
+	Service   string            `json:"service"`
+}
+
+// ServiceInstance is a schema of the admin API
+type ServiceInstance struct {
+	Expires  time.Time `json:"expires,omitempty"`
+	Port     int64     `json:"port"`
+	Priority int64     `json:"priority"`
+	Status   string    `json:"status"`
+	Target   string    `json:"target"`
+	Weight   int64     `json:"weight"`
+}
+
+// ServiceRegistration is a schema of the admin API
+type ServiceRegistration struct {
+	Address string `json:"address"`
+	Name    string `json:"name"`
+	Port    int64  `json:"port,omitempty"`
+	Service string `json:"service,omitempty"`
+	TTL     int64  `json:"ttl,omitempty"`
+	Zone    string `json:"zone"`
+}
+
+// Subnet is a schema of the admin API
+type Subnet struct {
+	Dhcpleaseduration string `json:"dhcpleaseduration,omitempty"`
+	Dhcpretention     string `json:"dhcpretention,omitempty"`
+	Dhcpsubnet        string `json:"dhcpsubnet,omitempty"`
+	Domain            string `json:"domain,omitempty"`
+	Gateway           string `json:"gateway"`
+	Subnet            string `json:"subnet"`
+	Zone              string `json:"zone"`
+}
+
+// SubnetResource is a schema of the admin API
+type SubnetResource struct {
+	Dhcpleaseduration string `json:"dhcpleaseduration,omitempty"`
+	Dhcpretention     string `json:"dhcpretention,omitempty"`
+	Dhcpsubnet        string `json:"dhcpsubnet,omitempty"`
+	Domain            string `json:"domain,omitempty"`
+	Gateway           string `json:"gateway"`
+	ID                string `json:"id"`
+	Subnet            string `json:"subnet"`
+	Zone              string `json:"zone"`
+}
+
+// TrustAnchor is a schema of the admin API
+type TrustAnchor struct {
+	Changed  time.Time `json:"changed"`
+	Dnskey   string    `json:"dnskey,omitempty"`
+	DS       string    `json:"ds,omitempty"`
+	KeyTag   int64     `json:"key_tag"`
+	LastSeen time.Time `json:"last_seen"`
+	State    string    `json:"state"`
+	Zone     string    `json:"zone"`
+}
+
+// ZoneProblem is a schema of the admin API
+type ZoneProblem struct {
+	Message  string `json:"message"`
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	Type     string `json:"type,omitempty"`
+}
+
+// DeleteAPIDHCPAuthorize: Send a client back to the captive portal, by mac or ip
+//
+// DELETE /api/dhcp/authorize
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDHCPAuthorize(body map[string]string) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("DELETE", "/api/dhcp/authorize", nil, body, &out)
+	return out, err
+}
+
+// DeleteAPIDHCPReservationsMAC: Remove the reservation of a MAC
+//
+// DELETE /api/dhcp/reservations/{mac}
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDHCPReservationsMAC(mac string) error {
+	return c.do("DELETE", "/api/dhcp/reservations/"+pathEscape(mac), nil, nil, nil)
+}
+
+// DeleteAPIDHCPSubnetsZone: Remove the network settings of a zone
+//
+// DELETE /api/dhcp/subnets/{zone}
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDHCPSubnetsZone(zone string) error {
+	return c.do("DELETE", "/api/dhcp/subnets/"+pathEscape(zone), nil, nil, nil)
+}
+
+// DeleteAPIDNSIncidents: Clear incidents once dealt with
+//
+// DELETE /api/dns/incidents
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - client: only this client's incidents
+func (c *Client) DeleteAPIDNSIncidents(query url.Values) (map[string]int64, error) {
+	var out map[string]int64
+	err := c.do("DELETE", "/api/dns/incidents", query, nil, &out)
+	return out, err
+}
+
+// DeleteAPIDNSProfilesName: Delete a filtering profile
+//
+// DELETE /api/dns/profiles/{name}
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDNSProfilesName(name string) error {
+	return c.do("DELETE", "/api/dns/profiles/"+pathEscape(name), nil, nil, nil)
+}
+
+// DeleteAPIDNSServices: Remove the registration of an instance of a service
+//
+// DELETE /api/dns/services
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDNSServices(body ServiceRegistration) error {
+	return c.do("DELETE", "/api/dns/services", nil, body, nil)
+}
+
+// DeleteAPIDNSZonesZone: Delete a zone that has no records left
+//
+// DELETE /api/dns/zones/{zone}
+func (c *Client) DeleteAPIDNSZonesZone(zone string) error {
+	return c.do("DELETE", "/api/dns/zones/"+pathEscape(zone), nil, nil, nil)
+}
+
+// DeleteAPIDNSZonesZoneRRSetsNameType: Delete every value of a name and type
+//
+// DELETE /api/dns/zones/{zone}/rrsets/{name}/{type}
+func (c *Client) DeleteAPIDNSZonesZoneRRSetsNameType(zone string, name string, typeName string) error {
+	return c.do("DELETE", "/api/dns/zones/"+pathEscape(zone)+"/rrsets/"+pathEscape(name)+"/"+pathEscape(typeName), nil, nil, nil)
+}
+
+// DeleteAPIDNSSECAnchorsZoneKeyTag: Remove a trust anchor
+//
+// DELETE /api/dnssec/anchors/{zone}/{key_tag}
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDNSSECAnchorsZoneKeyTag(zone string, keyTag string) error {
+	return c.do("DELETE", "/api/dnssec/anchors/"+pathEscape(zone)+"/"+pathEscape(keyTag), nil, nil, nil)
+}
+
+// DeleteAPIDNSSECKeysZone: Stop signing a zone; remove its DS records from the parent first
+//
+// DELETE /api/dnssec/keys/{zone}
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDNSSECKeysZone(zone string) error {
+	return c.do("DELETE", "/api/dnssec/keys/"+pathEscape(zone), nil, nil, nil)
+}
+
+// DeleteAPIDNSSECKeysZoneKeyTag: Remove a key
+//
+// DELETE /api/dnssec/keys/{zone}/{key_tag}
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDNSSECKeysZoneKeyTag(zone string, keyTag string) error {
+	return c.do("DELETE", "/api/dnssec/keys/"+pathEscape(zone)+"/"+pathEscape(keyTag), nil, nil, nil)
+}
+
+// DeleteAPIDNSSECZonesZoneSignatures: Forget the imported signatures of a zone
+//
+// DELETE /api/dnssec/zones/{zone}/signatures
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDNSSECZonesZoneSignatures(zone string) error {
+	return c.do("DELETE", "/api/dnssec/zones/"+pathEscape(zone)+"/signatures", nil, nil, nil)
+}
+
+// DeleteAPIDrain: Stop draining
+//
+// DELETE /api/drain
+//
+// Requires the admin token.
+func (c *Client) DeleteAPIDrain() (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("DELETE", "/api/drain", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIAudit: List audit log entries, newest last; tenants see their own
+//
+// GET /api/audit
+//
+// Its query parameters are:
+//   - actor: who made the change
+//   - kind: dns, dhcp, config or tenant
+//   - key: prefix of what was changed
+//   - since: RFC 3339 time
+//   - until: RFC 3339 time
+//   - limit: most entries to return
+func (c *Client) GetAPIAudit(query url.Values) ([]AuditEntry, error) {
+	var out []AuditEntry
+	err := c.do("GET", "/api/audit", query, nil, &out)
+	return out, err
+}
+
+// GetAPIBackups: List the backups at the backup destination, oldest first
+//
+// GET /api/backups/
+//
+// Requires the admin token.
+func (c *Client) GetAPIBackups() ([]string, error) {
+	var out []string
+	err := c.do("GET", "/api/backups/", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIBackupsName: Download a backup, as gzipped JSON for netcorectl restore
+//
+// GET /api/backups/{name}
+//
+// Requires the admin token.
+func (c *Client) GetAPIBackupsName(name string) (string, error) {
+	var out string
+	err := c.do("GET", "/api/backups/"+pathEscape(name), nil, nil, &out)
+	return out, err
+}
+
+// GetAPIClientsTop: List the busiest DNS clients
+//
+// GET /api/clients/top
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - n: number of clients
+//   - sort: queries, nxdomain or nxdomain_ratio
+func (c *Client) GetAPIClientsTop(query url.Values) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("GET", "/api/clients/top", query, nil, &out)
+	return out, err
+}
+
+// GetAPIConfig: Describe every recognized setting and its value for this instance
+//
+// GET /api/config
+//
+// Requires the admin token.
+func (c *Client) GetAPIConfig() ([]ConfigValue, error) {
+	var out []ConfigValue
+	err := c.do("GET", "/api/config", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDHCPBindings: List the leases of clients behind relay agents that report their switch port
+//
+// GET /api/dhcp/bindings
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - format: json or csv
+func (c *Client) GetAPIDHCPBindings(query url.Values) ([]DHCPLease, error) {
+	var out []DHCPLease
+	err := c.do("GET", "/api/dhcp/bindings", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDHCPLeases: Export leases and reservations
+//
+// GET /api/dhcp/leases
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - format: json, csv or isc
+//   - selector: only the reservations with matching labels
+func (c *Client) GetAPIDHCPLeases(query url.Values) ([]DHCPLease, error) {
+	var out []DHCPLease
+	err := c.do("GET", "/api/dhcp/leases", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDHCPPools: Show how much of each dynamic pool is in use
+//
+// GET /api/dhcp/pools
+//
+// Requires the admin token.
+func (c *Client) GetAPIDHCPPools() ([]DHCPPoolUsage, error) {
+	var out []DHCPPoolUsage
+	err := c.do("GET", "/api/dhcp/pools", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDHCPReservationsMAC: Show the reservation of a MAC
+//
+// GET /api/dhcp/reservations/{mac}
+//
+// Requires the admin token.
+func (c *Client) GetAPIDHCPReservationsMAC(mac string) (*Reservation, error) {
+	var out Reservation
+	if err := c.do("GET", "/api/dhcp/reservations/"+pathEscape(mac), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAPIDHCPSubnetsZone: Show the network settings of a zone
+//
+// GET /api/dhcp/subnets/{zone}
+//
+// Requires the admin token.
+func (c *Client) GetAPIDHCPSubnetsZone(zone string) (*SubnetResource, error) {
+	var out SubnetResource
+	if err := c.do("GET", "/api/dhcp/subnets/"+pathEscape(zone), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAPIDNSIncidents: List the clients that asked for sinkholed names, most recent first
+//
+// GET /api/dns/incidents
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - client: only this client's incidents
+//   - since: RFC 3339 time
+func (c *Client) GetAPIDNSIncidents(query url.Values) ([]Incident, error) {
+	var out []Incident
+	err := c.do("GET", "/api/dns/incidents", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSProfiles: List the scheduled filtering profiles
+//
+// GET /api/dns/profiles/
+//
+// Requires the admin token.
+func (c *Client) GetAPIDNSProfiles() ([]DNSProfile, error) {
+	var out []DNSProfile
+	err := c.do("GET", "/api/dns/profiles/", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSProfilesName: Show a filtering profile
+//
+// GET /api/dns/profiles/{name}
+//
+// Requires the admin token.
+func (c *Client) GetAPIDNSProfilesName(name string) (*DNSProfile, error) {
+	var out DNSProfile
+	if err := c.do("GET", "/api/dns/profiles/"+pathEscape(name), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAPIDNSQuerylog: Follow the live query log
+//
+// GET /api/dns/querylog
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - after: the last of a previous response, for the queries that followed it
+//   - limit: most entries to return
+func (c *Client) GetAPIDNSQuerylog(query url.Values) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("GET", "/api/dns/querylog", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSResolverconf: Render the stub zones that have BIND or Unbound ask this cluster about the zones it serves
+//
+// GET /api/dns/resolverconf
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - format: bind or unbound
+//   - server: the addresses to ask, separated by commas; by default those that instances of the fleet listen on
+func (c *Client) GetAPIDNSResolverconf(query url.Values) (string, error) {
+	var out string
+	err := c.do("GET", "/api/dns/resolverconf", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSServices: List the services of a zone, grouped by name, with the target, port, weight and health of each instance
+//
+// GET /api/dns/services
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - zone: the zone
+//   - domain: only the services under this domain of the zone
+func (c *Client) GetAPIDNSServices(query url.Values) ([]ServiceGroup, error) {
+	var out []ServiceGroup
+	err := c.do("GET", "/api/dns/services", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSSOAZone: Show the SOA settings of a zone
+//
+// GET /api/dns/soa/{zone}
+func (c *Client) GetAPIDNSSOAZone(zone string) (map[string]string, error) {
+	var out map[string]string
+	err := c.do("GET", "/api/dns/soa/"+pathEscape(zone), nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSTrace: Explain, stage by stage, how a question from a client would be answered, without sending packets
+//
+// GET /api/dns/trace
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - name: the name asked for
+//   - type: the type asked for, A by default
+//   - client: the address of the client asking
+//   - dnssec: true if the client sets the DO bit
+func (c *Client) GetAPIDNSTrace(query url.Values) (*QueryTrace, error) {
+	var out QueryTrace
+	if err := c.do("GET", "/api/dns/trace", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAPIDNSZones: List the zones the caller may manage
+//
+// GET /api/dns/zones/
+func (c *Client) GetAPIDNSZones() ([]string, error) {
+	var out []string
+	err := c.do("GET", "/api/dns/zones/", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSZonesZone: Show a zone
+//
+// GET /api/dns/zones/{zone}
+func (c *Client) GetAPIDNSZonesZone(zone string) (map[string]string, error) {
+	var out map[string]string
+	err := c.do("GET", "/api/dns/zones/"+pathEscape(zone), nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSZonesZoneCheck: Check the records of a zone for errors and likely mistakes
+//
+// GET /api/dns/zones/{zone}/check
+//
+// Its query parameters are:
+//   - unicode: true to show internationalized names in their Unicode form rather than as xn--
+func (c *Client) GetAPIDNSZonesZoneCheck(zone string, query url.Values) ([]ZoneProblem, error) {
+	var out []ZoneProblem
+	err := c.do("GET", "/api/dns/zones/"+pathEscape(zone)+"/check", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSZonesZoneHistory: List the earlier versions of a zone's records, oldest first
+//
+// GET /api/dns/zones/{zone}/history
+//
+// Its query parameters are:
+//   - name: only the versions of this name
+//   - type: only those of this type, with name
+//   - unicode: true to show internationalized names in their Unicode form rather than as xn--
+func (c *Client) GetAPIDNSZonesZoneHistory(zone string, query url.Values) ([]DNSRecordVersion, error) {
+	var out []DNSRecordVersion
+	err := c.do("GET", "/api/dns/zones/"+pathEscape(zone)+"/history", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSZonesZoneRecords: List the static records of a zone
+//
+// GET /api/dns/zones/{zone}/records
+//
+// Its query parameters are:
+//   - selector: only the records whose entry has matching labels, such as env=prod,team!=net
+//   - unicode: true to show internationalized names in their Unicode form rather than as xn--
+func (c *Client) GetAPIDNSZonesZoneRecords(zone string, query url.Values) ([]DNSRecord, error) {
+	var out []DNSRecord
+	err := c.do("GET", "/api/dns/zones/"+pathEscape(zone)+"/records", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSZonesZoneRRSetsNameType: Show the static values of a name and type
+//
+// GET /api/dns/zones/{zone}/rrsets/{name}/{type}
+func (c *Client) GetAPIDNSZonesZoneRRSetsNameType(zone string, name string, typeName string) (*RRSet, error) {
+	var out RRSet
+	if err := c.do("GET", "/api/dns/zones/"+pathEscape(zone)+"/rrsets/"+pathEscape(name)+"/"+pathEscape(typeName), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAPIDNSZonestats: Report each zone's queries, NXDOMAIN and SERVFAIL ratios and latency percentiles
+//
+// GET /api/dns/zonestats
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - window: duration from 1m to 24h, 1h by default
+//   - format: json or csv
+func (c *Client) GetAPIDNSZonestats(query url.Values) (string, error) {
+	var out string
+	err := c.do("GET", "/api/dns/zonestats", query, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSSECAnchors: List the DNSSEC trust anchors and their RFC 5011 states
+//
+// GET /api/dnssec/anchors/
+//
+// Requires the admin token.
+func (c *Client) GetAPIDNSSECAnchors() ([]TrustAnchor, error) {
+	var out []TrustAnchor
+	err := c.do("GET", "/api/dnssec/anchors/", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSSECKeys: List the keys of every signed zone
+//
+// GET /api/dnssec/keys/
+//
+// Requires the admin token.
+func (c *Client) GetAPIDNSSECKeys() ([]DNSSECKey, error) {
+	var out []DNSSECKey
+	err := c.do("GET", "/api/dnssec/keys/", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSSECKeysZone: List the keys of a zone, with the DS records its parent should have
+//
+// GET /api/dnssec/keys/{zone}
+//
+// Requires the admin token.
+func (c *Client) GetAPIDNSSECKeysZone(zone string) ([]DNSSECKey, error) {
+	var out []DNSSECKey
+	err := c.do("GET", "/api/dnssec/keys/"+pathEscape(zone), nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSSECZonesZoneSignatures: Show when signatures were imported, and how many
+//
+// GET /api/dnssec/zones/{zone}/signatures
+//
+// Requires the admin token.
+func (c *Client) GetAPIDNSSECZonesZoneSignatures(zone string) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("GET", "/api/dnssec/zones/"+pathEscape(zone)+"/signatures", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDNSSECZonesZoneUnsigned: Export a zone and its DNSKEY set for a signer outside netcore, in zone file format
+//
+// GET /api/dnssec/zones/{zone}/unsigned
+//
+// Requires the admin token.
+func (c *Client) GetAPIDNSSECZonesZoneUnsigned(zone string) (string, error) {
+	var out string
+	err := c.do("GET", "/api/dnssec/zones/"+pathEscape(zone)+"/unsigned", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIDrain: Show whether this instance is draining
+//
+// GET /api/drain
+//
+// Requires the admin token.
+func (c *Client) GetAPIDrain() (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("GET", "/api/drain", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIFleet: List the registered instances
+//
+// GET /api/fleet
+//
+// Requires the admin token.
+func (c *Client) GetAPIFleet() ([]FleetMember, error) {
+	var out []FleetMember
+	err := c.do("GET", "/api/fleet", nil, nil, &out)
+	return out, err
+}
+
+// GetAPIInventory: List the hosts on the network
+//
+// GET /api/inventory
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - format: ansible (dynamic inventory JSON) or json
+func (c *Client) GetAPIInventory(query url.Values) ([]InventoryHost, error) {
+	var out []InventoryHost
+	err := c.do("GET", "/api/inventory", query, nil, &out)
+	return out, err
+}
+
+// GetAPIOpenapiJSON: This document
+//
+// GET /api/openapi.json
+func (c *Client) GetAPIOpenapiJSON() (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("GET", "/api/openapi.json", nil, nil, &out)
+	return out, err
+}
+
+// GetAPISchema: Show the schema version of the data and the migrations this release would make to it
+//
+// GET /api/schema
+//
+// Requires the admin token.
+func (c *Client) GetAPISchema() (*SchemaStatus, error) {
+	var out SchemaStatus
+	if err := c.do("GET", "/api/schema", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetAPITenantsTenantZones: List the zones of a tenant
+//
+// GET /api/tenants/{tenant}/zones
+func (c *Client) GetAPITenantsTenantZones(tenant string) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("GET", "/api/tenants/"+pathEscape(tenant)+"/zones", nil, nil, &out)
+	return out, err
+}
+
+// GetDebugPprofProfile: Profile this instance: profile?seconds=30 for the CPU, heap, goroutine, block, threadcreate or trace?seconds=5
+//
+// GET /debug/pprof/{profile}
+//
+// Requires the admin token.
+//
+// Its query parameters are:
+//   - seconds: how long to profile the CPU or trace for
+func (c *Client) GetDebugPprofProfile(profile string, query url.Values) (string, error) {
+	var out string
+	err := c.do("GET", "/debug/pprof/"+pathEscape(profile), query, nil, &out)
+	return out, err
+}
+
+// GetHealthz: Report the health of this instance; 503 when unhealthy
+//
+// GET /healthz
+func (c *Client) GetHealthz() (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("GET", "/healthz", nil, nil, &out)
+	return out, err
+}
+
+// GetReadyz: Report whether this instance should take traffic, with the state of the circuit breaker in front of etcd; 503 when not ready
+//
+// GET /readyz
+func (c *Client) GetReadyz() (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("GET", "/readyz", nil, nil, &out)
+	return out, err
+}
+
+// PostAPIBackups: Write a backup of all data to the backup destination now
+//
+// POST /api/backups/
+//
+// Requires the admin token.
+func (c *Client) PostAPIBackups() (map[string]string, error) {
+	var out map[string]string
+	err := c.do("POST", "/api/backups/", nil, nil, &out)
+	return out, err
+}
+
+// PostAPIDHCPAuthorize: Let a client through the captive portal, by mac or ip
+//
+// POST /api/dhcp/authorize
+//
+// Requires the admin token.
+func (c *Client) PostAPIDHCPAuthorize(body map[string]string) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("POST", "/api/dhcp/authorize", nil, body, &out)
+	return out, err
+}
+
+// PostAPIDHCPLeases: Import leases and reservations
+//
+// POST /api/dhcp/leases
+//
+// Requires the admin token.
+func (c *Client) PostAPIDHCPLeases(body []DHCPLease) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("POST", "/api/dhcp/leases", nil, body, &out)
+	return out, err
+}
+
+// PostAPIDNSServices: Register an instance of a service, or renew its registration; its records vanish when heartbeats stop for its TTL
+//
+// POST /api/dns/services
+//
+// Requires the admin token.
+func (c *Client) PostAPIDNSServices(body ServiceRegistration) (*ServiceRegistration, error) {
+	var out ServiceRegistration
+	if err := c.do("POST", "/api/dns/services", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PostAPIDNSSRV: Drain a target of an SRV name, shift traffic between its targets by percentage, or restore their weights
+//
+// POST /api/dns/srv
+//
+// Requires the admin token.
+func (c *Client) PostAPIDNSSRV(body SRVWeightChange) ([]DNSRecord, error) {
+	var out []DNSRecord
+	err := c.do("POST", "/api/dns/srv", nil, body, &out)
+	return out, err
+}
+
+// PostAPIDNSZonesZoneClone: Copy the records of a zone to a new zone
+//
+// POST /api/dns/zones/{zone}/clone
+//
+// Its query parameters are:
+//   - force: true to copy a zone that has errors
+func (c *Client) PostAPIDNSZonesZoneClone(zone string, query url.Values, body map[string]interface{}) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("POST", "/api/dns/zones/"+pathEscape(zone)+"/clone", query, body, &out)
+	return out, err
+}
+
+// PostAPIDNSZonesZoneRecords: Apply a list of changes to a zone, all or none, unless they add errors to it
+//
+// POST /api/dns/zones/{zone}/records
+//
+// Its query parameters are:
+//   - force: true to apply changes that add errors to the zone
+func (c *Client) PostAPIDNSZonesZoneRecords(zone string, query url.Values, body []DNSChange) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("POST", "/api/dns/zones/"+pathEscape(zone)+"/records", query, body, &out)
+	return out, err
+}
+
+// PostAPIDNSZonesZoneRestore: Put a zone's records, or those of a name and type, back as they were at a time
+//
+// POST /api/dns/zones/{zone}/restore
+//
+// Its query parameters are:
+//   - force: true to restore records that add errors to the zone
+func (c *Client) PostAPIDNSZonesZoneRestore(zone string, query url.Values, body map[string]interface{}) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("POST", "/api/dns/zones/"+pathEscape(zone)+"/restore", query, body, &out)
+	return out, err
+}
+
+// PostAPIDNSSECAnchors: Add a trust anchor from a DNSKEY or DS record
+//
+// POST /api/dnssec/anchors/
+//
+// Requires the admin token.
+func (c *Client) PostAPIDNSSECAnchors(body map[string]string) (*TrustAnchor, error) {
+	var out TrustAnchor
+	if err := c.do("POST", "/api/dnssec/anchors/", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PostAPIDNSSECKeysZone: Sign a zone with a new key signing key and zone signing key
+//
+// POST /api/dnssec/keys/{zone}
+//
+// Requires the admin token.
+func (c *Client) PostAPIDNSSECKeysZone(zone string, body map[string]string) ([]DNSSECKey, error) {
+	var out []DNSSECKey
+	err := c.do("POST", "/api/dnssec/keys/"+pathEscape(zone), nil, body, &out)
+	return out, err
+}
+
+// PostAPIDNSSECKeysZoneImport: Add a key held elsewhere, such as another signer's or an HSM's
+//
+// POST /api/dnssec/keys/{zone}/import
+//
+// Requires the admin token.
+func (c *Client) PostAPIDNSSECKeysZoneImport(zone string, body map[string]string) ([]DNSSECKey, error) {
+	var out []DNSSECKey
+	err := c.do("POST", "/api/dnssec/keys/"+pathEscape(zone)+"/import", nil, body, &out)
+	return out, err
+}
+
+// PostAPIDNSSECKeysZoneKeyTagActivate: Sign with a published key now, retiring the key it replaces
+//
+// POST /api/dnssec/keys/{zone}/{key_tag}/activate
+//
+// Requires the admin token.
+func (c *Client) PostAPIDNSSECKeysZoneKeyTagActivate(zone string, keyTag string) ([]DNSSECKey, error) {
+	var out []DNSSECKey
+	err := c.do("POST", "/api/dnssec/keys/"+pathEscape(zone)+"/"+pathEscape(keyTag)+"/activate", nil, nil, &out)
+	return out, err
+}
+
+// PostAPIDNSSECKeysZoneRollover: Publish a new key, which signs once caches know it and, for a key signing key, the parent has its DS record
+//
+// POST /api/dnssec/keys/{zone}/rollover
+//
+// Requires the admin token.
+func (c *Client) PostAPIDNSSECKeysZoneRollover(zone string, body map[string]string) ([]DNSSECKey, error) {
+	var out []DNSSECKey
+	err := c.do("POST", "/api/dnssec/keys/"+pathEscape(zone)+"/rollover", nil, body, &out)
+	return out, err
+}
+
+// PostAPIDrain: Drain this instance for maintenance, exiting once drained with exit
+//
+// POST /api/drain
+//
+// Requires the admin token.
+func (c *Client) PostAPIDrain(body map[string]interface{}) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	err := c.do("POST", "/api/drain", nil, body, &out)
+	return out, err
+}
+
+// PostAPISchema: Make the pending migrations of the data, in order
+//
+// POST /api/schema
+//
+// Requires the admin token.
+func (c *Client) PostAPISchema() ([]SchemaMigration, error) {
+	var out []SchemaMigration
+	err := c.do("POST", "/api/schema", nil, nil, &out)
+	return out, err
+}
+
+// PostAPITenantsTenantTokens: Issue a new token for a tenant; it is only shown once
+//
+// POST /api/tenants/{tenant}/tokens
+//
+// Requires the admin token.
+func (c *Client) PostAPITenantsTenantTokens(tenant string) (map[string]string, error) {
+	var out map[string]string
+	err := c.do("POST", "/api/tenants/"+pathEscape(tenant)+"/tokens", nil, nil, &out)
+	return out, err
+}
+
+// PutAPIDHCPReservationsMAC: Reserve an address for a MAC, replacing its reservation
+//
+// PUT /api/dhcp/reservations/{mac}
+//
+// Requires the admin token.
+func (c *Client) PutAPIDHCPReservationsMAC(mac string, body DHCPLease) (*Reservation, error) {
+	var out Reservation
+	if err := c.do("PUT", "/api/dhcp/reservations/"+pathEscape(mac), nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PutAPIDHCPSubnetsZone: Replace the network settings of a zone
+//
+// PUT /api/dhcp/subnets/{zone}
+//
+// Requires the admin token.
+func (c *Client) PutAPIDHCPSubnetsZone(zone string, body Subnet) (*SubnetResource, error) {
+	var out SubnetResource
+	if err := c.do("PUT", "/api/dhcp/subnets/"+pathEscape(zone), nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PutAPIDNSProfilesName: Create or replace a filtering profile
+//
+// PUT /api/dns/profiles/{name}
+//
+// Requires the admin token.
+func (c *Client) PutAPIDNSProfilesName(name string, body DNSProfile) (*DNSProfile, error) {
+	var out DNSProfile
+	if err := c.do("PUT", "/api/dns/profiles/"+pathEscape(name), nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PutAPIDNSSOAZone: Change the SOA settings of a zone, creating it with ns and mbox
+//
+// PUT /api/dns/soa/{zone}
+func (c *Client) PutAPIDNSSOAZone(zone string, body map[string]interface{}) (map[string]string, error) {
+	var out map[string]string
+	err := c.do("PUT", "/api/dns/soa/"+pathEscape(zone), nil, body, &out)
+	return out, err
+}
+
+// PutAPIDNSZonesZone: Create a zone or change its SOA settings
+//
+// PUT /api/dns/zones/{zone}
+func (c *Client) PutAPIDNSZonesZone(zone string, body map[string]interface{}) (map[string]string, error) {
+	var out map[string]string
+	err := c.do("PUT", "/api/dns/zones/"+pathEscape(zone), nil, body, &out)
+	return out, err
+}
+
+// PutAPIDNSZonesZoneRRSetsNameType: Replace the static values and labels of a name and type
+//
+// PUT /api/dns/zones/{zone}/rrsets/{name}/{type}
+func (c *Client) PutAPIDNSZonesZoneRRSetsNameType(zone string, name string, typeName string, body RRSet) (*RRSet, error) {
+	var out RRSet
+	if err := c.do("PUT", "/api/dns/zones/"+pathEscape(zone)+"/rrsets/"+pathEscape(name)+"/"+pathEscape(typeName), nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// PutAPIDNSSECZonesZoneSignatures: Import the RRSIG and NSEC records of a zone signed outside netcore, in zone file format
+//
+// PUT /api/dnssec/zones/{zone}/signatures
+//
+// Requires the admin token.
+func (c *Client) PutAPIDNSSECZonesZoneSignatures(zone string, body io.Reader) (map[string]int64, error) {
+	var out map[string]int64
+	err := c.do("PUT", "/api/dnssec/zones/"+pathEscape(zone)+"/signatures", nil, body, &out)
+	return out, err
+}
+
+// PutAPITenantsTenantZonesZone: Assign a zone to a tenant
+//
+// PUT /api/tenants/{tenant}/zones/{zone}
+//
+// Requires the admin token.
+func (c *Client) PutAPITenantsTenantZonesZone(tenant string, zone string, body io.Reader) (map[string]string, error) {
+	var out map[string]string
+	err := c.do("PUT", "/api/tenants/"+pathEscape(tenant)+"/zones/"+pathEscape(zone), nil, body, &out)
+	return out, err
+}
@@ -0,0 +1,55 @@
+package netcoreclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"a token is required"}`))
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/api/dns/zones/example.com/rrsets/a%2Fb.example.com/A":
+			var set RRSet
+			if err := json.NewDecoder(r.Body).Decode(&set); err != nil || r.Method != "PUT" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(set)
+		case "/api/dns/resolverconf":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("stub-zone: " + r.URL.Query().Get("format")))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"no such zone","field":"zone"}`))
+		}
+	}))
+	defer server.Close()
+	client := New(server.URL, "secret")
+
+	set, err := client.PutAPIDNSZonesZoneRRSetsNameType("example.com", "a/b.example.com", "A", RRSet{TTL: 300})
+	if err != nil || set.TTL != 300 {
+		t.Errorf("PutAPIDNSZonesZoneRRSetsNameType() = %+v, %v; want the set back", set, err)
+	}
+	if text, err := client.GetAPIDNSResolverconf(url.Values{"format": {"unbound"}}); err != nil || text != "stub-zone: unbound" {
+		t.Errorf("GetAPIDNSResolverconf() = %q, %v; want the text", text, err)
+	}
+	_, err = client.GetAPIDNSZonesZoneRecords("example.net", nil)
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusNotFound || e.Message != "no such zone" || e.Field != "zone" {
+		t.Errorf("GetAPIDNSZonesZoneRecords() error = %#v, want the 404", err)
+	}
+	client.Token = ""
+	if _, err := client.GetAPIDNSZones(); err == nil || err.Error() != "netcore: 401 a token is required" {
+		t.Errorf("GetAPIDNSZones() without a token error = %v", err)
+	}
+}
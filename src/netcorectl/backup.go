@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// backupNode matches an etcd node as backups hold them
+type backupNode struct {
+	Key   string        `json:"key"`
+	Value string        `json:"value"`
+	Dir   bool          `json:"dir"`
+	TTL   int64         `json:"ttl"`
+	Nodes []*backupNode `json:"nodes"`
+}
+
+// backup matches a backup written by netcore
+type backup struct {
+	Version  int           `json:"version"`
+	Created  time.Time     `json:"created"`
+	Hostname string        `json:"hostname"`
+	Trees    []*backupNode `json:"trees"`
+}
+
+// cmdBackup writes a backup to the configured destination now, and
+// downloads it with -o, or lists the backups there
+func cmdBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	list := flags.Bool("list", false, "List the backups at the destination instead.")
+	output := flags.String("o", "", "Also download the new backup to this file.")
+	flags.Parse(args)
+
+	if *list {
+		var names []string
+		if err := apiGet("/api/backups/", nil, &names); err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+	var created struct {
+		Name string `json:"name"`
+	}
+	if err := apiPost("/api/backups/", nil, &created); err != nil {
+		return err
+	}
+	fmt.Println(created.Name)
+	if *output == "" {
+		return nil
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := apiGet("/api/backups/"+created.Name, nil, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// cmdRestore writes a backup back to etcd, talking to etcd directly so that
+// a new cluster can be rebuilt before netcore runs on it. Values that expire,
+// such as leases, keep what was left of their time.
+func cmdRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	etcdServer := flags.String("etcd", "http://127.0.0.1:2379", "etcd server to restore to.")
+	overwrite := flags.Bool("overwrite", false, "Write over data already in etcd; keys that are not in the backup are left alone.")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected a backup file, or the name of a backup at the destination")
+	}
+
+	b, err := readBackup(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return restoreBackup(etcdClient{base: strings.TrimSuffix(*etcdServer, "/") + "/v2/keys/"}, b, *overwrite)
+}
+
+// readBackup reads a backup file, or fetches the backup of that name from
+// the destination if there is no such file
+func readBackup(name string) (*backup, error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) && !strings.ContainsAny(name, "/\\") {
+		var buf bytes.Buffer
+		if err := apiGet("/api/backups/"+name, nil, &buf); err != nil {
+			return nil, err
+		}
+		data, err = buf.Bytes(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	z, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var b backup
+	if err := json.NewDecoder(z).Decode(&b); err != nil {
+		return nil, fmt.Errorf("not a netcore backup: %s", err)
+	}
+	if b.Version != 1 {
+		return nil, fmt.Errorf("backup version %d is not supported", b.Version)
+	}
+	return &b, nil
+}
+
+// restoreBackup writes b to etcd, refusing to write over the trees it
+// already has unless overwrite is set
+func restoreBackup(e etcdClient, b *backup, overwrite bool) error {
+	if !overwrite {
+		for _, tree := range b.Trees {
+			found, err := e.exists(tree.Key)
+			if err != nil {
+				return err
+			}
+			if found {
+				return fmt.Errorf("etcd already has %s; give -overwrite to write over it", tree.Key)
+			}
+		}
+	}
+	elapsed := int64(time.Since(b.Created).Seconds())
+	written, expired := 0, 0
+	var restore func(node *backupNode) error
+	restore = func(node *backupNode) error {
+		key := strings.TrimPrefix(node.Key, "/")
+		switch {
+		case node.Dir && len(node.Nodes) == 0:
+			_, err := e.create(key, "", true)
+			return err
+		case node.Dir:
+			for _, child := range node.Nodes {
+				if err := restore(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		case node.TTL > 0 && node.TTL <= elapsed:
+			expired++
+			return nil
+		}
+		ttl := int64(0)
+		if node.TTL > 0 {
+			ttl = node.TTL - elapsed
+		}
+		written++
+		return e.set(key, node.Value, ttl)
+	}
+	for _, tree := range b.Trees {
+		if err := restore(tree); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Restored %d keys from the backup of %s made %s", written, b.Hostname, b.Created.Local().Format(time.RFC3339))
+	if expired > 0 {
+		fmt.Printf("; %d had expired since", expired)
+	}
+	fmt.Println()
+	return nil
+}
+
+// exists tells whether key is in etcd
+func (e etcdClient) exists(key string) (bool, error) {
+	response, err := http.Get(e.base + strings.TrimPrefix(key, "/"))
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, errors.New(response.Status)
+}
+
+// set sets key to value, expiring after ttl seconds unless it is 0
+func (e etcdClient) set(key, value string, ttl int64) error {
+	form := url.Values{"value": {value}}
+	if ttl > 0 {
+		form.Set("ttl", fmt.Sprint(ttl))
+	}
+	req, err := http.NewRequest("PUT", e.base+key, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		var etcdErr struct {
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(response.Body).Decode(&etcdErr) == nil && etcdErr.Message != "" {
+			return fmt.Errorf("%s: %s", key, etcdErr.Message)
+		}
+		return fmt.Errorf("%s: %s", key, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// configValue matches a setting as described by the admin API
+type configValue struct {
+	Key         string `json:"key"`
+	Scope       string `json:"scope"`
+	Value       string `json:"value"`
+	Source      string `json:"source"`
+	Default     string `json:"default"`
+	Description string `json:"description"`
+	Error       string `json:"error"`
+}
+
+func cmdConfig(args []string) error {
+	if len(args) == 0 || args[0] != "explain" {
+		return fmt.Errorf("expected a subcommand: explain")
+	}
+	return cmdConfigExplain(args[1:])
+}
+
+func cmdConfigExplain(args []string) error {
+	flags := flag.NewFlagSet("config explain", flag.ExitOnError)
+	markdown := flags.Bool("markdown", false, "Write a Markdown table of every setting and its default, for documentation.")
+	flags.Parse(args)
+
+	var settings []configValue
+	if err := apiGet("/api/config", nil, &settings); err != nil {
+		return err
+	}
+
+	if *markdown {
+		fmt.Println("| Key | Scope | Default | Description |")
+		fmt.Println("| --- | --- | --- | --- |")
+		seen := make(map[string]bool)
+		for _, s := range settings {
+			if seen[s.Key] {
+				continue // another entry of a directory
+			}
+			seen[s.Key] = true
+			fmt.Printf("| %s | %s | %s | %s |\n", s.Key, s.Scope, markdownCode(s.Default), strings.Replace(s.Description, "|", "\\|", -1))
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE\tDESCRIPTION")
+	bad := 0
+	for _, s := range settings {
+		description := s.Description
+		if s.Error != "" {
+			description = "ERROR: " + s.Error
+			bad++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Key, s.Value, s.Source, description)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d setting(s) are invalid", bad)
+	}
+	return nil
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + s + "`"
+}
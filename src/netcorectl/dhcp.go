@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// dhcpLease matches the lease form of the admin API; reservations have no
+// expiration
+type dhcpLease struct {
+	MAC      string     `json:"mac"`
+	IP       string     `json:"ip"`
+	Hostname string     `json:"hostname,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// leaseImporters read the lease files of other DHCP servers
+var leaseImporters = map[string]func(io.Reader) ([]dhcpLease, error){
+	"isc":      readISCLeases,
+	"kea-csv":  readKeaCSVLeases,
+	"kea-json": readKeaJSONLeases,
+}
+
+func cmdDHCP(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected a subcommand: import or export")
+	}
+	switch args[0] {
+	case "import":
+		return cmdDHCPImport(args[1:])
+	case "export":
+		return cmdDHCPExport(args[1:])
+	}
+	return fmt.Errorf("unknown subcommand %q", args[0])
+}
+
+func cmdDHCPImport(args []string) error {
+	flags := flag.NewFlagSet("dhcp import", flag.ExitOnError)
+	format := flags.String("format", "isc", "Format of the file: isc (dhcpd.leases or dhcpd.conf host declarations), kea-csv (memfile) or kea-json (configuration reservations or lease4-get-all output).")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one file to import")
+	}
+	read, ok := leaseImporters[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	leases, err := read(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", flags.Arg(0), err)
+	}
+
+	var result struct {
+		Imported int      `json:"imported"`
+		Expired  int      `json:"expired"`
+		Errors   []string `json:"errors"`
+	}
+	if err := apiPost("/api/dhcp/leases", leases, &result); err != nil {
+		return err
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	fmt.Printf("Imported %d of %d leases and reservations (%d already expired, %d failed)\n", result.Imported, len(leases), result.Expired, len(result.Errors))
+	return nil
+}
+
+func cmdDHCPExport(args []string) error {
+	flags := flag.NewFlagSet("dhcp export", flag.ExitOnError)
+	format := flags.String("format", "json", "Format to write: json, csv, or isc (dhcpd.leases).")
+	flags.Parse(args)
+	return apiGet("/api/dhcp/leases", url.Values{"format": {*format}}, os.Stdout)
+}
+
+// readISCLeases reads the lease and host declarations of ISC dhcpd, from
+// dhcpd.leases or dhcpd.conf. Leases that are not active are skipped, and
+// later declarations of an address replace earlier ones, as they do for
+// dhcpd.
+func readISCLeases(r io.Reader) ([]dhcpLease, error) {
+	tokens, err := iscTokens(r)
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	byIP := make(map[string]*dhcpLease)
+	add := func(lease *dhcpLease) {
+		if _, ok := byIP[lease.IP]; !ok {
+			order = append(order, lease.IP)
+		}
+		byIP[lease.IP] = lease
+	}
+
+	for len(tokens) > 0 {
+		kind := tokens[0]
+		if kind != "lease" && kind != "host" {
+			// Step over other statements, and into blocks such as subnet and
+			// group, which may hold host declarations
+			i := 0
+			for i < len(tokens) && tokens[i] != ";" && tokens[i] != "{" && tokens[i] != "}" {
+				i++
+			}
+			if i < len(tokens) {
+				i++
+			}
+			tokens = tokens[i:]
+			continue
+		}
+		statements, rest, isBlock := iscDeclaration(tokens)
+		tokens = rest
+		if !isBlock {
+			continue
+		}
+		header := statements[0]
+		if len(header) < 2 {
+			continue
+		}
+		lease := &dhcpLease{}
+		active, deleted := true, false
+		if kind == "lease" {
+			lease.IP = header[1]
+		} else {
+			lease.Hostname = header[1]
+		}
+		for _, s := range statements[1:] {
+			switch {
+			case len(s) == 3 && s[0] == "hardware":
+				lease.MAC = s[2]
+			case len(s) == 2 && s[0] == "fixed-address":
+				lease.IP = s[1]
+			case len(s) == 2 && s[0] == "client-hostname":
+				lease.Hostname = s[1]
+			case len(s) == 3 && s[0] == "binding" && s[1] == "state":
+				active = s[2] == "active"
+			case len(s) == 1 && s[0] == "deleted":
+				deleted = true
+			case len(s) >= 2 && s[0] == "ends":
+				expires, err := iscTime(s[1:])
+				if err != nil {
+					return nil, fmt.Errorf("lease %s: %s", lease.IP, err)
+				}
+				lease.Expires = expires
+			}
+		}
+		if kind == "lease" && lease.Expires == nil {
+			// "ends never" is as good as a reservation, but not named
+			lease.Hostname = ""
+		}
+		if lease.MAC == "" || net.ParseIP(lease.IP) == nil {
+			continue
+		}
+		if deleted || !active {
+			if existing, ok := byIP[lease.IP]; ok && existing.MAC == lease.MAC {
+				delete(byIP, lease.IP)
+			}
+			continue
+		}
+		add(lease)
+	}
+
+	var leases []dhcpLease
+	for _, ip := range order {
+		if lease, ok := byIP[ip]; ok {
+			leases = append(leases, *lease)
+			delete(byIP, ip)
+		}
+	}
+	return leases, nil
+}
+
+// iscTokens splits dhcpd's configuration syntax into words, quoted strings
+// (without their quotes) and the punctuation { } and ;
+func iscTokens(r io.Reader) ([]string, error) {
+	var tokens []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for i := 0; i < len(line); {
+			c := line[i]
+			switch {
+			case c == '#':
+				i = len(line)
+			case unicode.IsSpace(rune(c)):
+				i++
+			case c == '{' || c == '}' || c == ';':
+				tokens = append(tokens, string(c))
+				i++
+			case c == '"':
+				end := strings.IndexByte(line[i+1:], '"')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated string: %s", line)
+				}
+				tokens = append(tokens, line[i+1:i+1+end])
+				i += end + 2
+			default:
+				start := i
+				for i < len(line) && !unicode.IsSpace(rune(line[i])) && !strings.ContainsRune("{};#\"", rune(line[i])) {
+					i++
+				}
+				tokens = append(tokens, line[start:i])
+			}
+		}
+	}
+	return tokens, scanner.Err()
+}
+
+// iscDeclaration takes the declaration at the start of tokens, returning its
+// header and the statements directly within its block (nested blocks are
+// skipped), and the tokens that follow it
+func iscDeclaration(tokens []string) (statements [][]string, rest []string, isBlock bool) {
+	var current []string
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case ";":
+			if !isBlock {
+				return [][]string{current}, tokens[i+1:], false
+			}
+			if len(current) > 0 {
+				statements = append(statements, current)
+			}
+			current = nil
+		case "{":
+			if isBlock {
+				// Skip a nested block
+				depth := 1
+				for i++; i < len(tokens) && depth > 0; i++ {
+					if tokens[i] == "{" {
+						depth++
+					} else if tokens[i] == "}" {
+						depth--
+					}
+				}
+				i--
+				current = nil
+				continue
+			}
+			isBlock = true
+			statements = append(statements, current)
+			current = nil
+		case "}":
+			return statements, tokens[i+1:], isBlock
+		default:
+			current = append(current, tokens[i])
+		}
+	}
+	return statements, nil, isBlock
+}
+
+// iscTime parses the time of an "ends" statement: never, epoch <seconds>, or
+// <weekday> <yyyy/mm/dd> <hh:mm:ss> in UTC. Never is returned as nil.
+func iscTime(words []string) (*time.Time, error) {
+	switch {
+	case words[0] == "never":
+		return nil, nil
+	case words[0] == "epoch" && len(words) >= 2:
+		seconds, err := strconv.ParseInt(words[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		t := time.Unix(seconds, 0).UTC()
+		return &t, nil
+	case len(words) >= 3:
+		t, err := time.Parse("2006/01/02 15:04:05", words[1]+" "+words[2])
+		if err != nil {
+			return nil, err
+		}
+		return &t, nil
+	}
+	return nil, fmt.Errorf("bad time %q", strings.Join(words, " "))
+}
+
+// readKeaCSVLeases reads a Kea memfile lease4.csv. Kea appends a row for each
+// change, so later rows for an address replace earlier ones; rows in a state
+// other than default (0) are dropped.
+func readKeaCSVLeases(r io.Reader) ([]dhcpLease, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	column := make(map[string]int)
+	for i, name := range header {
+		column[name] = i
+	}
+	for _, name := range []string{"address", "hwaddr", "expire"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("no %s column", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := column[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var order []string
+	byIP := make(map[string]*dhcpLease)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ip := field(row, "address")
+		if state := field(row, "state"); state != "" && state != "0" {
+			delete(byIP, ip)
+			continue
+		}
+		expire, err := strconv.ParseInt(field(row, "expire"), 10, 64)
+		if err != nil || field(row, "hwaddr") == "" {
+			continue
+		}
+		expires := time.Unix(expire, 0).UTC()
+		if _, ok := byIP[ip]; !ok {
+			order = append(order, ip)
+		}
+		byIP[ip] = &dhcpLease{MAC: field(row, "hwaddr"), IP: ip, Hostname: field(row, "hostname"), Expires: &expires}
+	}
+
+	var leases []dhcpLease
+	for _, ip := range order {
+		if lease, ok := byIP[ip]; ok {
+			leases = append(leases, *lease)
+			delete(byIP, ip)
+		}
+	}
+	return leases, nil
+}
+
+// readKeaJSONLeases reads the host reservations anywhere in a Kea
+// configuration, and the leases in the output of the lease4-get-all command
+func readKeaJSONLeases(r io.Reader) ([]dhcpLease, error) {
+	var doc interface{}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	var leases []dhcpLease
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			for key, value := range v {
+				list, ok := value.([]interface{})
+				if !ok || (key != "reservations" && key != "leases") {
+					walk(value)
+					continue
+				}
+				for _, item := range list {
+					entry, ok := item.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					lease := dhcpLease{}
+					lease.MAC, _ = entry["hw-address"].(string)
+					lease.IP, _ = entry["ip-address"].(string)
+					lease.Hostname, _ = entry["hostname"].(string)
+					if key == "leases" {
+						if state, _ := entry["state"].(float64); state != 0 {
+							continue
+						}
+						cltt, _ := entry["cltt"].(float64)
+						lifetime, _ := entry["valid-lft"].(float64)
+						expires := time.Unix(int64(cltt+lifetime), 0).UTC()
+						lease.Expires = &expires
+					}
+					if lease.MAC != "" && lease.IP != "" {
+						leases = append(leases, lease)
+					}
+				}
+			}
+		}
+	}
+	walk(doc)
+	return leases, nil
+}
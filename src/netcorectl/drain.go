@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// drainStatus matches the drain state reported by the admin API
+type drainStatus struct {
+	Draining bool      `json:"draining"`
+	Exiting  bool      `json:"exiting"`
+	Since    time.Time `json:"since"`
+}
+
+func cmdDrain(args []string) error {
+	flags := flag.NewFlagSet("drain", flag.ExitOnError)
+	exit := flags.Bool("exit", false, "Exit once drained, as for an upgrade.")
+	cancel := flags.Bool("cancel", false, "Stop draining and return to normal service.")
+	status := flags.Bool("status", false, "Only show whether the instance is draining.")
+	flags.Parse(args)
+
+	var s drainStatus
+	var err error
+	switch {
+	case *status:
+		err = apiGet("/api/drain", nil, &s)
+	case *cancel:
+		err = apiDo("DELETE", "/api/drain", nil, nil, &s)
+	default:
+		err = apiPost("/api/drain", map[string]bool{"exit": *exit}, &s)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case s.Exiting:
+		fmt.Printf("draining since %s, exiting once drained\n", s.Since.Format(time.RFC3339))
+	case s.Draining:
+		fmt.Printf("draining since %s\n", s.Since.Format(time.RFC3339))
+	default:
+		fmt.Println("serving")
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// fleetMember matches an instance as listed by the admin API
+type fleetMember struct {
+	Hostname  string            `json:"hostname"`
+	Version   string            `json:"version"`
+	Roles     []string          `json:"roles"`
+	Listen    map[string]string `json:"listen"`
+	Zones     []string          `json:"zones"`
+	Subnets   []string          `json:"subnets"`
+	Leads     []string          `json:"leads"`
+	Heartbeat time.Time         `json:"heartbeat"`
+	Alive     bool              `json:"alive"`
+}
+
+func cmdFleet(args []string) error {
+	var members []fleetMember
+	if err := apiGet("/api/fleet", nil, &members); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tLAST SEEN\tVERSION\tROLES\tZONES\tSUBNETS\tLEADS\tLISTEN")
+	for _, m := range members {
+		status := "down"
+		if m.Alive {
+			status = "up"
+		}
+		roles := make([]string, 0, len(m.Listen))
+		for role := range m.Listen {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		listen := make([]string, 0, len(roles))
+		for _, role := range roles {
+			listen = append(listen, role+"="+m.Listen[role])
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s ago\t%s\t%s\t%s\t%s\t%s\t%s\n", m.Hostname, status,
+			time.Since(m.Heartbeat)/time.Second*time.Second, m.Version,
+			strings.Join(m.Roles, ","), strings.Join(m.Zones, ","), strings.Join(m.Subnets, ","),
+			strings.Join(m.Leads, ","), strings.Join(listen, " "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// importRuleSize is how many names a policy rule made from a list holds
+const importRuleSize = 100
+
+// dnsProfile matches a filtering profile of the admin API
+type dnsProfile struct {
+	Name     string   `json:"name"`
+	Rules    []string `json:"rules"`
+	Schedule string   `json:"schedule"`
+}
+
+// subnetSettings matches the network settings of a zone in the admin API
+type subnetSettings struct {
+	Domain            string `json:"domain,omitempty"`
+	Subnet            string `json:"subnet"`
+	Gateway           string `json:"gateway"`
+	DHCPSubnet        string `json:"dhcpsubnet,omitempty"`
+	DHCPLeaseDuration string `json:"dhcpleaseduration,omitempty"`
+}
+
+// migration is what import found in the files of dnsmasq and Pi-hole, in
+// the form of the admin API
+type migration struct {
+	domain   string // of domain=, which bare host names are in
+	records  []zoneRecord
+	leases   []dhcpLease
+	ranges   []string // the dhcp-range options, as written
+	router   string   // of dhcp-option=3 or option:router
+	allow    []string // names of whitelists
+	refuse   []string // names that dnsmasq answers with NXDOMAIN
+	sinkhole []string // names of blocklists, or answered with 0.0.0.0
+	skipped  []string // what has no equivalent, and where it is
+	read     map[string]bool
+}
+
+// cmdImport reads the configuration of dnsmasq, or of Pi-hole, which runs
+// on it, and creates the records, reservations, network settings and
+// filtering profile that stand for it
+func cmdImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	site := flags.String("site", "", "Zone of the config tree to give the network settings of dhcp-range to, replacing those it has; they are skipped without it.")
+	gateway := flags.String("gateway", "", "Gateway of the network settings, when dnsmasq does not set the router option.")
+	profile := flags.String("profile", "imported", "Filtering profile, always active, to put blocked and whitelisted names in.")
+	dryRun := flags.Bool("dry-run", false, "Only show what would be imported.")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("expected dnsmasq configuration files, Pi-hole lists, or directories of them")
+	}
+
+	m := &migration{read: make(map[string]bool)}
+	for _, path := range flags.Args() {
+		if err := m.readPath(path, (*migration).readDnsmasq); err != nil {
+			return err
+		}
+	}
+	m.qualify()
+	if *gateway != "" {
+		m.router = *gateway
+	}
+	var subnet *subnetSettings
+	if len(m.ranges) > 0 {
+		if *site == "" {
+			m.skip("dhcp-range", "give -site to import it as the network settings of a zone")
+		} else {
+			subnet = m.subnet()
+		}
+	}
+	var p *dnsProfile
+	if rules := m.rules(); len(rules) > 0 {
+		p = &dnsProfile{Name: *profile, Rules: rules, Schedule: "* 00:00-00:00"}
+	}
+
+	if *dryRun {
+		m.show(subnet, p)
+		return nil
+	}
+	failed := 0
+	if subnet != nil {
+		if err := apiDo("PUT", "/api/dhcp/subnets/"+url.QueryEscape(*site), nil, subnet, new(interface{})); err != nil {
+			fmt.Fprintf(os.Stderr, "network settings of %s: %s\n", *site, err)
+			failed++
+		} else {
+			fmt.Printf("Set the network settings of %s\n", *site)
+		}
+	}
+	if len(m.records) > 0 {
+		failed += m.importRecords()
+	}
+	if len(m.leases) > 0 {
+		var result struct {
+			Imported int      `json:"imported"`
+			Errors   []string `json:"errors"`
+		}
+		if err := apiPost("/api/dhcp/leases", m.leases, &result); err != nil {
+			fmt.Fprintf(os.Stderr, "reservations: %s\n", err)
+			failed++
+		} else {
+			for _, e := range result.Errors {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			fmt.Printf("Imported %d of %d reservations\n", result.Imported, len(m.leases))
+			failed += len(result.Errors)
+		}
+	}
+	if p != nil {
+		if err := apiDo("PUT", "/api/dns/profiles/"+url.QueryEscape(p.Name), nil, p, new(interface{})); err != nil {
+			fmt.Fprintf(os.Stderr, "profile %s: %s\n", p.Name, err)
+			failed++
+		} else {
+			fmt.Printf("Put %d blocked and %d allowed names in profile %s\n", len(m.refuse)+len(m.sinkhole), len(m.allow), p.Name)
+		}
+	}
+	for _, s := range m.skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s\n", s)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d imports failed", failed)
+	}
+	return nil
+}
+
+// readPath reads a file, or the files of a directory, as its name says it
+// is, or with fallback for other names
+func (m *migration) readPath(path string, fallback func(*migration, string) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return m.readFile(path, fallback)
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || strings.HasSuffix(file.Name(), "~") {
+			continue
+		}
+		if err := m.readFile(filepath.Join(path, file.Name()), fallback); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFile reads a file by what its name says it holds: the lists of
+// Pi-hole, hosts files, or dnsmasq configuration
+func (m *migration) readFile(path string, fallback func(*migration, string) error) error {
+	if m.read[path] {
+		return nil
+	}
+	m.read[path] = true
+	base := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasPrefix(base, "whitelist"):
+		return m.readDomains(path, &m.allow)
+	case strings.HasPrefix(base, "regex"):
+		m.skip(path, "regular expressions have no equivalent in policy rules")
+		return nil
+	case strings.HasPrefix(base, "gravity") && !strings.HasSuffix(base, ".db"), strings.HasPrefix(base, "black"), strings.HasSuffix(base, ".domains"), base == "adlists.list":
+		return m.readDomains(path, &m.sinkhole)
+	case base == "custom.list" || base == "local.list" || strings.Contains(base, "hosts"):
+		return m.readHosts(path)
+	case strings.HasSuffix(base, ".conf"):
+		return m.readDnsmasq(path)
+	case strings.HasSuffix(base, ".list") || strings.HasSuffix(base, ".txt"):
+		return m.readDomains(path, &m.sinkhole)
+	case strings.HasSuffix(base, ".db"):
+		m.skip(path, "Pi-hole databases cannot be read; give the lists it was built from")
+		return nil
+	}
+	return fallback(m, path)
+}
+
+// lines calls f with each line of path that is not blank or a comment
+func lines(path string, f func(line string, n int)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f(line, n)
+	}
+	return scanner.Err()
+}
+
+// readDomains reads a list of names, one per line or in hosts format
+func (m *migration) readDomains(path string, names *[]string) error {
+	urls := 0
+	err := lines(path, func(line string, n int) {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if strings.Contains(line, "://") {
+			urls++
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		}
+		for _, name := range fields {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if strings.Contains(name, ".") && !strings.ContainsAny(name, "/*^$|\\") && name != "localhost.localdomain" {
+				*names = append(*names, name)
+			}
+		}
+	})
+	if urls > 0 {
+		m.skip(path, fmt.Sprintf("%d list URLs; download the lists and import those", urls))
+	}
+	return err
+}
+
+// readHosts reads a file in the format of /etc/hosts as records
+func (m *migration) readHosts(path string) error {
+	return lines(path, func(line string, n int) {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			m.skip(fmt.Sprintf("%s:%d", path, n), "not an address and names")
+			return
+		}
+		ip := net.ParseIP(fields[0])
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return
+		}
+		for _, name := range fields[1:] {
+			m.address(name, ip, 0)
+		}
+	})
+}
+
+// readDnsmasq reads the options of dnsmasq that have an equivalent in
+// netcore, and follows those naming other files
+func (m *migration) readDnsmasq(path string) error {
+	var follow []func() error
+	err := lines(path, func(line string, n int) {
+		where := fmt.Sprintf("%s:%d", path, n)
+		key, value := line, ""
+		if i := strings.Index(line, "="); i >= 0 {
+			key, value = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		}
+		fields := strings.Split(value, ",")
+		switch key {
+		case "address":
+			names, target := slashList(value)
+			switch ip := net.ParseIP(target); {
+			case target == "":
+				m.refuse = append(m.refuse, names...)
+			case target == "#" || ip != nil && ip.IsUnspecified():
+				m.sinkhole = append(m.sinkhole, names...)
+			case ip != nil:
+				for _, name := range names {
+					// dnsmasq answers for the subdomains too
+					m.address(name, ip, 0)
+					m.address("*."+name, ip, 0)
+				}
+			default:
+				m.skip(where, "not an address: "+line)
+			}
+		case "host-record":
+			ttl := uint32(0)
+			if t, err := strconv.ParseUint(fields[len(fields)-1], 10, 32); err == nil && len(fields) > 2 {
+				ttl, fields = uint32(t), fields[:len(fields)-1]
+			}
+			var names []string
+			var ips []net.IP
+			for _, field := range fields {
+				if ip := net.ParseIP(field); ip != nil {
+					ips = append(ips, ip)
+				} else {
+					names = append(names, field)
+				}
+			}
+			for _, name := range names {
+				for _, ip := range ips {
+					m.address(name, ip, ttl)
+				}
+			}
+		case "cname":
+			ttl := uint32(0)
+			if t, err := strconv.ParseUint(fields[len(fields)-1], 10, 32); err == nil && len(fields) > 2 {
+				ttl, fields = uint32(t), fields[:len(fields)-1]
+			}
+			if len(fields) < 2 {
+				m.skip(where, "no target: "+line)
+				return
+			}
+			target := fields[len(fields)-1]
+			for _, alias := range fields[:len(fields)-1] {
+				m.records = append(m.records, zoneRecord{Name: alias, Type: "CNAME", TTL: ttl, Value: target})
+			}
+		case "mx-host":
+			if len(fields) < 2 {
+				m.skip(where, "the target defaults to the host dnsmasq runs on: "+line)
+				return
+			}
+			r := zoneRecord{Name: fields[0], Type: "MX", Attr: map[string]string{"target": fields[1], "priority": "1"}}
+			if len(fields) > 2 {
+				r.Attr["priority"] = fields[2]
+			}
+			m.records = append(m.records, r)
+		case "srv-host":
+			if len(fields) < 2 {
+				m.skip(where, "no target: "+line)
+				return
+			}
+			attr := map[string]string{"target": fields[1]}
+			for i, name := range []string{"port", "priority", "weight"} {
+				if len(fields) > i+2 {
+					attr[name] = fields[i+2]
+				}
+			}
+			m.records = append(m.records, zoneRecord{Name: fields[0], Type: "SRV", Attr: attr})
+		case "txt-record":
+			for _, text := range fields[1:] {
+				m.records = append(m.records, zoneRecord{Name: fields[0], Type: "TXT", Value: strings.Trim(text, `"`)})
+			}
+		case "ptr-record":
+			if len(fields) == 2 {
+				m.records = append(m.records, zoneRecord{Name: fields[0], Type: "PTR", Value: fields[1]})
+			}
+		case "dhcp-host":
+			m.dhcpHost(where, fields)
+		case "dhcp-range":
+			m.ranges = append(m.ranges, value)
+		case "dhcp-option":
+			for len(fields) > 0 && (strings.HasPrefix(fields[0], "tag:") || strings.HasPrefix(fields[0], "set:")) {
+				fields = fields[1:]
+			}
+			if len(fields) == 2 && (fields[0] == "3" || fields[0] == "option:router") {
+				m.router = fields[1]
+			}
+		case "domain":
+			m.domain = strings.TrimSuffix(fields[0], ".")
+		case "server", "rev-server":
+			m.skip(where, "forwarders are set in the forwarders setting: "+line)
+		case "addn-hosts":
+			follow = append(follow, func() error { return m.readPath(value, (*migration).readHosts) })
+		case "conf-file":
+			follow = append(follow, func() error { return m.readPath(value, (*migration).readDnsmasq) })
+		case "conf-dir":
+			follow = append(follow, func() error { return m.readConfDir(fields) })
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range follow {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readConfDir reads the files of a conf-dir option: a directory, then
+// suffixes to leave out, or to only read when they start with *
+func (m *migration) readConfDir(fields []string) error {
+	files, err := ioutil.ReadDir(fields[0])
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "#") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		include, matched := false, false
+		for _, suffix := range fields[1:] {
+			if strings.HasPrefix(suffix, "*") {
+				include = true
+				matched = matched || strings.HasSuffix(name, suffix[1:])
+			} else if strings.HasSuffix(name, suffix) {
+				matched, include = false, false
+				break
+			}
+		}
+		if include && !matched {
+			continue
+		}
+		if err := m.readFile(filepath.Join(fields[0], name), (*migration).readDnsmasq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dhcpHost reads a dhcp-host option as a reservation, which needs a MAC
+// and an IPv4 address; its fields may come in any order
+func (m *migration) dhcpHost(where string, fields []string) {
+	var lease dhcpLease
+	for _, field := range fields {
+		switch {
+		case field == "ignore":
+			m.skip(where, "hosts that are ignored have no equivalent")
+			return
+		case strings.Contains(field, "*"):
+			m.skip(where, "reservations are for a single MAC: "+field)
+			return
+		case strings.HasPrefix(field, "id:"), strings.HasPrefix(field, "set:"), strings.HasPrefix(field, "tag:"), field == "infinite", leaseTime(field) > 0:
+		case net.ParseIP(field) != nil && net.ParseIP(field).To4() != nil:
+			lease.IP = field
+		default:
+			if mac, err := net.ParseMAC(field); err == nil {
+				lease.MAC = mac.String()
+			} else if !strings.HasPrefix(field, "[") {
+				lease.Hostname = field
+			}
+		}
+	}
+	if lease.MAC == "" || lease.IP == "" {
+		m.skip(where, "a reservation needs a MAC and an IPv4 address: dhcp-host="+strings.Join(fields, ","))
+		return
+	}
+	m.leases = append(m.leases, lease)
+}
+
+// leaseTime returns the seconds of a dnsmasq lease time such as 12h or 600,
+// or 0 if it is not one
+func leaseTime(s string) int {
+	if s == "infinite" {
+		return 365 * 24 * 3600
+	}
+	unit := 1
+	switch {
+	case strings.HasSuffix(s, "m"):
+		unit = 60
+	case strings.HasSuffix(s, "h"):
+		unit = 3600
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * 3600
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * 3600
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n * unit
+}
+
+// subnet converts the first dhcp-range to network settings. The pool is the
+// largest network that fits in the range, since netcore leases from a
+// network rather than a range.
+func (m *migration) subnet() *subnetSettings {
+	for _, extra := range m.ranges[1:] {
+		m.skip("dhcp-range="+extra, "netcore leases from one pool per zone")
+	}
+	var ips []net.IP
+	var lease int
+	for _, field := range strings.Split(m.ranges[0], ",") {
+		if ip := net.ParseIP(field).To4(); ip != nil {
+			ips = append(ips, ip)
+		} else if t := leaseTime(field); t > 0 {
+			lease = t
+		}
+	}
+	if len(ips) < 2 {
+		m.skip("dhcp-range="+m.ranges[0], "not an IPv4 range")
+		return nil
+	}
+	start, end := ipToInt(ips[0]), ipToInt(ips[1])
+	mask := net.CIDRMask(24, 32)
+	if len(ips) > 2 {
+		mask = net.IPMask(ips[2])
+	}
+	ones, _ := mask.Size()
+	network := &net.IPNet{IP: ips[0].Mask(mask), Mask: mask}
+	if m.router == "" {
+		m.skip("dhcp-range="+m.ranges[0], "no router option; give -gateway")
+		return nil
+	}
+	s := &subnetSettings{Domain: m.domain, Subnet: network.String(), Gateway: m.router}
+	for bits := uint(ones); bits <= 32; bits++ {
+		size := uint32(1) << (32 - bits)
+		first := (start + size - 1) / size * size
+		if first >= start && first+size-1 <= end && first+size-1 >= first {
+			s.DHCPSubnet = fmt.Sprintf("%s/%d", intToIP(first), bits)
+			if first != start || first+size-1 != end {
+				m.skip("dhcp-range="+m.ranges[0], "only "+s.DHCPSubnet+" of the range is leased from")
+			}
+			break
+		}
+	}
+	if lease > 0 {
+		s.DHCPLeaseDuration = strconv.Itoa((lease + 59) / 60)
+	}
+	return s
+}
+
+func ipToInt(ip net.IP) uint32 {
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func intToIP(n uint32) net.IP {
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// address adds the A or AAAA record of name
+func (m *migration) address(name string, ip net.IP, ttl uint32) {
+	rrType := "AAAA"
+	if ip.To4() != nil {
+		rrType = "A"
+	}
+	m.records = append(m.records, zoneRecord{Name: name, Type: rrType, TTL: ttl, Value: ip.String()})
+}
+
+// qualify puts bare names, and the targets of records, in the domain=
+// domain once all files are read, and drops the records found twice
+func (m *migration) qualify() {
+	full := func(name string) string {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if !strings.Contains(name, ".") && m.domain != "" && name != m.domain {
+			name += "." + m.domain
+		}
+		return name
+	}
+	seen := make(map[string]bool)
+	records := m.records[:0]
+	for _, r := range m.records {
+		r.Name = full(r.Name)
+		switch r.Type {
+		case "CNAME", "PTR":
+			r.Value = full(r.Value)
+		case "MX", "SRV":
+			r.Attr["target"] = full(r.Attr["target"])
+		}
+		key := fmt.Sprintf("%s %s %s %v", r.Name, r.Type, r.Value, r.Attr)
+		if !seen[key] {
+			seen[key] = true
+			records = append(records, r)
+		}
+	}
+	m.records = records
+}
+
+// rules returns the policy rules of the whitelisted and blocked names,
+// whitelists first
+func (m *migration) rules() []string {
+	var rules []string
+	for _, list := range []struct {
+		action string
+		names  []string
+	}{{"allow", m.allow}, {"refuse", m.refuse}, {"sinkhole", m.sinkhole}} {
+		names := uniqueStrings(list.names)
+		for i := 0; i < len(names); i += importRuleSize {
+			end := i + importRuleSize
+			if end > len(names) {
+				end = len(names)
+			}
+			rules = append(rules, list.action+" name "+strings.Join(names[i:end], ","))
+		}
+	}
+	return rules
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// importRecords adds the records to the zones they are in, a zone at a
+// time, and returns how many zones failed
+func (m *migration) importRecords() int {
+	var zones []string
+	if err := apiGet("/api/dns/zones/", nil, &zones); err != nil {
+		fmt.Fprintf(os.Stderr, "records: %s\n", err)
+		return 1
+	}
+	changes := make(map[string][]map[string]interface{})
+	for _, r := range m.records {
+		zone := ""
+		for _, z := range zones {
+			z = strings.TrimSuffix(z, ".")
+			if (r.Name == z || strings.HasSuffix(r.Name, "."+z)) && len(z) > len(zone) {
+				zone = z
+			}
+		}
+		if zone == "" {
+			m.skip(r.Name+" "+r.Type, "no zone holds it; create the zone first")
+			continue
+		}
+		changes[zone] = append(changes[zone], map[string]interface{}{"op": "add", "record": r})
+	}
+	failed := 0
+	for zone, list := range changes {
+		if err := apiPost("/api/dns/zones/"+url.QueryEscape(zone)+"/records", list, new(interface{})); err != nil {
+			fmt.Fprintf(os.Stderr, "records of %s: %s\n", zone, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Added %d records to %s\n", len(list), zone)
+	}
+	return failed
+}
+
+// show lists what would be imported
+func (m *migration) show(subnet *subnetSettings, p *dnsProfile) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if subnet != nil {
+		fmt.Fprintf(w, "subnet\t%s\tgateway %s, pool %s, lease %s minutes, domain %s\n", subnet.Subnet, subnet.Gateway, subnet.DHCPSubnet, subnet.DHCPLeaseDuration, subnet.Domain)
+	}
+	for _, r := range m.records {
+		value := r.Value
+		if value == "" {
+			var attr []string
+			for k, v := range r.Attr {
+				attr = append(attr, k+"="+v)
+			}
+			sort.Strings(attr)
+			value = strings.Join(attr, " ")
+		}
+		fmt.Fprintf(w, "record\t%s\t%s %s\n", r.Name, r.Type, value)
+	}
+	for _, lease := range m.leases {
+		fmt.Fprintf(w, "reservation\t%s\t%s %s\n", lease.MAC, lease.IP, lease.Hostname)
+	}
+	if p != nil {
+		fmt.Fprintf(w, "profile\t%s\t%d rules: %d allowed, %d refused and %d sinkholed names\n", p.Name, len(p.Rules), len(uniqueStrings(m.allow)), len(uniqueStrings(m.refuse)), len(uniqueStrings(m.sinkhole)))
+	}
+	for _, s := range m.skipped {
+		fmt.Fprintf(w, "skipped\t%s\n", s)
+	}
+	w.Flush()
+}
+
+func (m *migration) skip(where, why string) {
+	m.skipped = append(m.skipped, where+": "+why)
+}
+
+// slashList splits the /name/name/value form of dnsmasq options
+func slashList(value string) ([]string, string) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 3 || parts[0] != "" {
+		return nil, value
+	}
+	var names []string
+	for _, name := range parts[1 : len(parts)-1] {
+		if name != "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return names, parts[len(parts)-1]
+}
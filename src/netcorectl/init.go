@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"netcore"
+)
+
+// etcdKeyExists is the etcd v2 error code for a create that found the key
+// already there
+const etcdKeyExists = 105
+
+// cmdInit sets up the etcd key layout that netcore expects, and a zone for
+// this host, talking to etcd directly since netcore cannot start without
+// them. Keys that already exist are left alone, so it is safe to run again.
+func cmdInit(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	etcdServer := flags.String("etcd", "http://127.0.0.1:2379", "etcd server to set up.")
+	hostname := flags.String("hostname", "", "Name of the netcore instance, as it finds its settings: defaults to $NETCORE_NAME, $ETCD_NAME or this host's fully qualified name, as netcore does.")
+	zone := flags.String("zone", "default", "Zone to assign the host to.")
+	domain := flags.String("domain", "", "Domain of the zone, for DHCP clients' names.")
+	subnet := flags.String("subnet", "", "Subnet of the zone; defaults to the subnet of this host's first IPv4 interface.")
+	gateway := flags.String("gateway", "", "Default gateway of the zone; defaults to the first address of the subnet.")
+	flags.Parse(args)
+
+	if *hostname == "" {
+		name, err := netcore.InstanceName()
+		if err != nil {
+			return err
+		}
+		*hostname = name
+	}
+	if *subnet == "" {
+		network, err := localSubnet()
+		if err != nil {
+			return fmt.Errorf("%s; give -subnet", err)
+		}
+		*subnet = network.String()
+	}
+	_, network, err := net.ParseCIDR(*subnet)
+	if err != nil {
+		return err
+	}
+	if *gateway == "" {
+		first := make(net.IP, len(network.IP))
+		copy(first, network.IP)
+		first[len(first)-1]++
+		*gateway = first.String()
+	}
+	if ip := net.ParseIP(*gateway); ip == nil || !network.Contains(ip) {
+		return fmt.Errorf("gateway %s is not in %s", *gateway, network)
+	}
+
+	e := etcdClient{base: strings.TrimSuffix(*etcdServer, "/") + "/v2/keys/"}
+	steps := []initStep{
+		{key: "config", dir: true},
+		{key: "dhcp", dir: true},
+		{key: "dns", dir: true},
+		{key: "config/" + *hostname + "/zone", value: *zone},
+		{key: "config/" + *zone + "/subnet", value: network.String()},
+		{key: "config/" + *zone + "/gateway", value: *gateway},
+	}
+	if *domain != "" {
+		steps = append(steps, initStep{key: "config/" + *zone + "/domain", value: *domain})
+	}
+	for _, step := range steps {
+		created, err := e.create(step.key, step.value, step.dir)
+		if err != nil {
+			return fmt.Errorf("%s: %s", step.key, err)
+		}
+		state := "kept"
+		if created {
+			state = "created"
+		}
+		if step.dir {
+			fmt.Printf("%-8s %s/\n", state, step.key)
+		} else {
+			fmt.Printf("%-8s %s = %s\n", state, step.key, step.value)
+		}
+	}
+	return nil
+}
+
+// initStep is a key that init creates, or a directory when dir is set
+type initStep struct {
+	key, value string
+	dir        bool
+}
+
+// etcdClient creates keys through the etcd v2 HTTP API
+type etcdClient struct {
+	base string
+}
+
+// create creates key, or a directory there, unless it already exists; it
+// tells whether it did
+func (e etcdClient) create(key, value string, dir bool) (bool, error) {
+	form := url.Values{"prevExist": {"false"}}
+	if dir {
+		form.Set("dir", "true")
+	} else {
+		form.Set("value", value)
+	}
+	req, err := http.NewRequest("PUT", e.base+key, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 == 2 {
+		return true, nil
+	}
+	var etcdErr struct {
+		ErrorCode int    `json:"errorCode"`
+		Message   string `json:"message"`
+	}
+	if json.NewDecoder(response.Body).Decode(&etcdErr) != nil {
+		return false, errors.New(response.Status)
+	}
+	if etcdErr.ErrorCode == etcdKeyExists {
+		return false, nil
+	}
+	return false, errors.New(etcdErr.Message)
+}
+
+// localSubnet is the subnet of the first IPv4 address of an interface that
+// is up and not a loopback
+func localSubnet() (*net.IPNet, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				return &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask).To4(), Mask: ipnet.Mask[len(ipnet.Mask)-4:]}, nil
+			}
+		}
+	}
+	return nil, errors.New("no IPv4 interface found")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// cmdInventory writes the hosts on the network for a configuration
+// management tool. Ansible runs inventory scripts with --list or --host, so
+// a script that runs "netcorectl inventory -format ansible" and passes its
+// arguments along can serve as an Ansible dynamic inventory.
+func cmdInventory(args []string) error {
+	flags := flag.NewFlagSet("inventory", flag.ExitOnError)
+	format := flags.String("format", "ansible", "Write ansible (dynamic inventory JSON) or json (a list of hosts).")
+	flags.Bool("list", true, "List every host, as Ansible asks; this is the default.")
+	host := flags.String("host", "", "Write the variables of one host, as Ansible asks; they are already in the list.")
+	flags.Parse(args)
+
+	if *host != "" {
+		fmt.Println("{}")
+		return nil
+	}
+	return apiGet("/api/inventory", url.Values{"format": {*format}}, os.Stdout)
+}
@@ -0,0 +1,183 @@
+// Command netcorectl manages a running netcore instance through its HTTP
+// admin API, and sets up etcd for a new one.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var apiURL = flag.String("api", "http://127.0.0.1:8053", "Base URL of the netcore admin API.")
+var apiToken = flag.String("token", "", "Bearer token for the admin API; defaults to $NETCORE_APITOKEN.")
+
+// command is a netcorectl subcommand, given the arguments that follow its name
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"backup":       {"backup [-list] [-o file]  write a backup of all data to the backup destination now, or list those there", cmdBackup},
+	"config":       {"config explain [-markdown]  show every recognized setting with its current value and where it comes from", cmdConfig},
+	"dhcp":         {"dhcp import [-format isc|kea-csv|kea-json] <file>  import leases and reservations from another DHCP server\n  dhcp export [-format json|csv|isc]  write the current leases and reservations", cmdDHCP},
+	"drain":        {"drain [-exit] [-cancel] [-status]  drain the instance for maintenance, stop draining, or show whether it is draining", cmdDrain},
+	"fleet":        {"fleet  list the registered netcore instances, whether they are up and what they serve", cmdFleet},
+	"import":       {"import [-site zone] [-gateway ip] [-profile name] [-dry-run] <file|dir>...  import the records, reservations, DHCP range and blocklists of dnsmasq or Pi-hole", cmdImport},
+	"init":         {"init [-etcd url] [-hostname h] [-zone z] [-domain d] [-subnet cidr] [-gateway ip]  set up etcd for a new host; safe to re-run", cmdInit},
+	"inventory":    {"inventory [-format ansible|json]  list the hosts on the network by zone, subnet and device class, as an Ansible dynamic inventory", cmdInventory},
+	"migrate":      {"migrate [-status]  bring the data written by earlier releases up to the schema version of the instance, or show what is pending", cmdMigrate},
+	"profile":      {"profile [-type cpu|heap|goroutine|block|threadcreate|trace] [-seconds 30] [-o file]  capture a profile of the instance to diagnose its performance", cmdProfile},
+	"promote":      {"promote [-etcd url] [-file copy] [-overwrite]  make the disaster-recovery copy in etcd, or in a file, the source of truth", cmdPromote},
+	"resolverconf": {"resolverconf [-format bind|unbound] [-server ips] [-o file] [-reload cmd] [-watch 1m]  write the stub zones that have BIND or Unbound ask netcore about its zones, and keep them up to date", cmdResolverConf},
+	"restore":      {"restore [-etcd url] [-overwrite] <file|name>  rebuild etcd from a backup file, or one at the backup destination", cmdRestore},
+	"srv":          {"srv drain -zone z <name> <target>  set a target's weight to 0\n  srv shift -zone z <name> <target=percent>...  split traffic between targets of the same priority\n  srv restore -zone z <name> [target]  put back the weights of drained or shifted targets", cmdSRV},
+	"top":          {"top [-n 20] [-sort queries|nxdomain|nxdomain_ratio]  show the busiest DNS clients", cmdTop},
+	"trace":        {"trace <name> [type] [-client ip] [-dnssec]  explain how the server would answer a question, without sending it", cmdTrace},
+	"zone":         {"zone check [-warnings=false] <zone>  list the errors and likely mistakes in a zone's records\n  zone history [-name n] [-type t] <zone>  list the changes to a zone's records\n  zone restore -time t [-name n] [-type t] [-force] <zone>  put a zone's records back as they were at a time", cmdZone},
+	"zonestats":    {"zonestats [-window 1h] [-format table|json|csv]  show each zone's queries, NXDOMAIN and SERVFAIL ratios and latency percentiles", cmdZoneStats},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *apiToken == "" {
+		*apiToken = os.Getenv("NETCORE_APITOKEN")
+	}
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "netcorectl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "netcorectl %s: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: netcorectl [flags] <command> [args]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// apiGet fetches path from the admin API and decodes the JSON response into
+// v, or copies it to v if it is an io.Writer
+func apiGet(path string, query url.Values, v interface{}) error {
+	return apiDo("GET", path, query, nil, v)
+}
+
+// apiPost sends body to path on the admin API as JSON and decodes the JSON
+// response into v
+func apiPost(path string, body, v interface{}) error {
+	return apiDo("POST", path, nil, body, v)
+}
+
+func apiDo(method, path string, query url.Values, body, v interface{}) error {
+	u := strings.TrimSuffix(*apiURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if *apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*apiToken)
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(response.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("%s %s", u, response.Status)
+	}
+	if w, ok := v.(io.Writer); ok {
+		_, err = io.Copy(w, response.Body)
+		return err
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+func cmdTop(args []string) error {
+	flags := flag.NewFlagSet("top", flag.ExitOnError)
+	n := flags.String("n", "20", "Number of clients to show.")
+	by := flags.String("sort", "queries", "Order by queries, nxdomain or nxdomain_ratio.")
+	flags.Parse(args)
+
+	var top struct {
+		Window  string `json:"window"`
+		Clients []struct {
+			Client        string           `json:"client"`
+			Queries       int64            `json:"queries"`
+			NXDomain      int64            `json:"nxdomain"`
+			NXDomainRatio float64          `json:"nxdomain_ratio"`
+			Types         map[string]int64 `json:"types"`
+			Hostname      string           `json:"hostname"`
+			Class         string           `json:"class"`
+		} `json:"clients"`
+	}
+	if err := apiGet("/api/clients/top", url.Values{"n": {*n}, "sort": {*by}}, &top); err != nil {
+		return err
+	}
+
+	fmt.Printf("Busiest DNS clients over the last %s\n\n", top.Window)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT\tDEVICE\tQUERIES\tNXDOMAIN\tNX%\tTYPES")
+	for _, c := range top.Clients {
+		types := make([]string, 0, len(c.Types))
+		for qtype, count := range c.Types {
+			types = append(types, fmt.Sprintf("%s:%d", qtype, count))
+		}
+		sort.Strings(types)
+		device := c.Hostname
+		if c.Class != "" {
+			device = strings.TrimPrefix(device+" ("+c.Class+")", " ")
+		}
+		if device == "" {
+			device = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.1f\t%s\n", c.Client, device, c.Queries, c.NXDomain, c.NXDomainRatio*100, strings.Join(types, " "))
+	}
+	return w.Flush()
+}
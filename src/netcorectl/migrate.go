@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// schemaMigration matches a migration as the admin API reports it
+type schemaMigration struct {
+	Version int    `json:"version"`
+	Summary string `json:"summary"`
+}
+
+// cmdMigrate has the instance bring the data written by earlier releases up
+// to the layout of its own, or only shows what it would do
+func cmdMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	status := flags.Bool("status", false, "Only show the schema version of the data and the pending migrations.")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %s", strings.Join(flags.Args(), " "))
+	}
+
+	if *status {
+		var s struct {
+			Version   int               `json:"version"`
+			Supported int               `json:"supported"`
+			Pending   []schemaMigration `json:"pending"`
+		}
+		if err := apiGet("/api/schema", nil, &s); err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d, this release writes version %d\n", s.Version, s.Supported)
+		for _, m := range s.Pending {
+			fmt.Printf("pending: %d  %s\n", m.Version, m.Summary)
+		}
+		return nil
+	}
+	var made []schemaMigration
+	if err := apiPost("/api/schema", nil, &made); err != nil {
+		return err
+	}
+	if len(made) == 0 {
+		fmt.Println("the data is up to date")
+	}
+	for _, m := range made {
+		fmt.Printf("migrated to %d  %s\n", m.Version, m.Summary)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// profilePaths are the pprof endpoints of the admin API by profile type
+var profilePaths = map[string]string{
+	"cpu":          "/debug/pprof/profile",
+	"heap":         "/debug/pprof/heap",
+	"goroutine":    "/debug/pprof/goroutine",
+	"block":        "/debug/pprof/block",
+	"threadcreate": "/debug/pprof/threadcreate",
+	"trace":        "/debug/pprof/trace",
+}
+
+func cmdProfile(args []string) error {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	kind := flags.String("type", "cpu", "Profile to capture: cpu, heap, goroutine, block, threadcreate or trace.")
+	seconds := flags.Int("seconds", 30, "How long to profile the CPU or trace for.")
+	output := flags.String("o", "", "File to write the profile to; <type>.pprof by default.")
+	flags.Parse(args)
+	path, ok := profilePaths[*kind]
+	if !ok {
+		return fmt.Errorf("unknown profile type %q", *kind)
+	}
+	query := url.Values{}
+	if *kind == "cpu" || *kind == "trace" {
+		query.Set("seconds", strconv.Itoa(*seconds))
+		fmt.Fprintf(os.Stderr, "capturing a %s profile for %ds\n", *kind, *seconds)
+	}
+	if *output == "" {
+		*output = *kind + ".pprof"
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := apiGet(path, query, f); err != nil {
+		f.Close()
+		os.Remove(*output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if *kind == "trace" {
+		fmt.Printf("wrote %s; view it with go tool trace <netcore binary> %s\n", *output, *output)
+	} else {
+		fmt.Printf("wrote %s; view it with go tool pprof <netcore binary> %s\n", *output, *output)
+	}
+	return nil
+}
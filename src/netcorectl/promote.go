@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// cmdPromote makes a disaster-recovery copy the source of truth once the
+// primary backend is lost. A copy in etcd is marked as promoted, so that the
+// instances of the lost site stop copying to it if they ever come back; a
+// copy in a file is restored to a new etcd first. Like restore, it talks to
+// etcd directly, since no netcore is running against it yet.
+func cmdPromote(args []string) error {
+	flags := flag.NewFlagSet("promote", flag.ExitOnError)
+	etcdServer := flags.String("etcd", "http://127.0.0.1:2379", "etcd server holding the copy, or to restore -file to.")
+	file := flags.String("file", "", "The copy is this file, written by an instance with -drtarget set to it.")
+	overwrite := flags.Bool("overwrite", false, "With -file, write over data already in etcd.")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %s", strings.Join(flags.Args(), " "))
+	}
+
+	e := etcdClient{base: strings.TrimSuffix(*etcdServer, "/") + "/v2/keys/"}
+	if *file != "" {
+		b, err := readBackup(*file)
+		if err != nil {
+			return err
+		}
+		if err := restoreBackup(e, b, *overwrite); err != nil {
+			return err
+		}
+	}
+	found, err := e.exists("config")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("etcd has no configuration; it is not a copy of netcore's data")
+	}
+	promoted, err := e.exists("dr/promoted")
+	if err != nil {
+		return err
+	}
+	if promoted {
+		return errors.New("this copy was already promoted")
+	}
+	hostname, _ := os.Hostname()
+	marker, err := json.Marshal(map[string]string{"time": time.Now().UTC().Format(time.RFC3339), "by": hostname})
+	if err != nil {
+		return err
+	}
+	if err := e.set("dr/promoted", string(marker), 0); err != nil {
+		return err
+	}
+	fmt.Printf("Promoted %s. Start netcore with -etcd %s, and without -drtarget unless a new copy is wanted elsewhere.\n", *etcdServer, *etcdServer)
+	return nil
+}
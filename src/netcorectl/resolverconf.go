@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// cmdResolverConf writes the stub zones that have BIND or Unbound ask
+// netcore about the zones it serves. With -watch it keeps running, on the
+// resolver's host, and rewrites the file and reloads the resolver whenever
+// a zone is added or removed.
+func cmdResolverConf(args []string) error {
+	flags := flag.NewFlagSet("resolverconf", flag.ExitOnError)
+	format := flags.String("format", "unbound", "Configuration to write: bind or unbound.")
+	servers := flags.String("server", "", "Addresses of netcore for the resolver to ask, separated by commas; by default those that instances of the fleet listen on.")
+	output := flags.String("o", "", "File to write, such as /etc/unbound/unbound.conf.d/netcore.conf, instead of standard output.")
+	reload := flags.String("reload", "", "Command run after the file changes, such as \"unbound-control reload\" or \"rndc reconfig\".")
+	watch := flags.Duration("watch", 0, "Check for changes this often, and keep running (0 to write once).")
+	flags.Parse(args)
+	if *watch > 0 && *output == "" {
+		return fmt.Errorf("-watch needs a file to write with -o")
+	}
+
+	query := url.Values{"format": {*format}}
+	if *servers != "" {
+		query.Set("server", *servers)
+	}
+	for {
+		var buf bytes.Buffer
+		err := apiGet("/api/dns/resolverconf", query, &buf)
+		if err == nil {
+			err = writeResolverConf(*output, buf.Bytes(), *reload)
+		}
+		if *watch == 0 {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "netcorectl resolverconf: %s\n", err)
+		}
+		time.Sleep(*watch)
+	}
+}
+
+// writeResolverConf writes conf to path, or to standard output without one.
+// An unchanged file is left alone; a changed one is replaced as a whole,
+// then reload is run.
+func writeResolverConf(path string, conf []byte, reload string) error {
+	if path == "" {
+		_, err := os.Stdout.Write(conf)
+		return err
+	}
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, conf) {
+		return nil
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := ioutil.WriteFile(tmp, conf, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", path)
+	if reload == "" {
+		return nil
+	}
+	cmd := exec.Command("/bin/sh", "-c", reload)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %s", reload, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// srvWeightChange matches a change to the weights of an SRV name's targets,
+// as the admin API takes it
+type srvWeightChange struct {
+	Zone    string            `json:"zone"`
+	Name    string            `json:"name"`
+	Op      string            `json:"op"`
+	Target  string            `json:"target,omitempty"`
+	Weights map[string]uint16 `json:"weights,omitempty"`
+}
+
+func cmdSRV(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected a subcommand: drain, shift or restore")
+	}
+	op := args[0]
+	if op != "drain" && op != "shift" && op != "restore" {
+		return fmt.Errorf("unknown subcommand %q", op)
+	}
+	flags := flag.NewFlagSet("srv "+op, flag.ExitOnError)
+	zone := flags.String("zone", "", "Zone of the SRV name.")
+	flags.Parse(args[1:])
+	if *zone == "" {
+		return fmt.Errorf("expected the zone of the SRV name with -zone")
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("expected the SRV name, such as _http._tcp.web.example.com")
+	}
+	change := srvWeightChange{Zone: *zone, Name: flags.Arg(0), Op: op}
+	targets := flags.Args()[1:]
+	switch op {
+	case "drain":
+		if len(targets) != 1 {
+			return fmt.Errorf("expected the target to drain")
+		}
+		change.Target = targets[0]
+	case "shift":
+		if len(targets) == 0 {
+			return fmt.Errorf("expected target=percent for each target, adding up to 100")
+		}
+		change.Weights = make(map[string]uint16)
+		for _, arg := range targets {
+			i := strings.LastIndex(arg, "=")
+			if i < 0 {
+				return fmt.Errorf("expected target=percent, not %q", arg)
+			}
+			percent, err := strconv.ParseUint(strings.TrimSuffix(arg[i+1:], "%"), 10, 16)
+			if err != nil {
+				return fmt.Errorf("bad percentage in %q", arg)
+			}
+			change.Weights[arg[:i]] = uint16(percent)
+		}
+	case "restore":
+		if len(targets) > 1 {
+			return fmt.Errorf("expected at most one target to restore")
+		}
+		if len(targets) == 1 {
+			change.Target = targets[0]
+		}
+	}
+
+	var records []zoneRecord
+	if err := apiPost("/api/dns/srv", change, &records); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tPORT\tPRIORITY\tWEIGHT\tRESTORES TO")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Attr["target"], r.Attr["port"], r.Attr["priority"], r.Attr["weight"], r.Attr["restore-weight"])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// queryTrace matches the dry run of a question by the admin API
+type queryTrace struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Client string `json:"client"`
+	Steps  []struct {
+		Handler  string   `json:"handler"`
+		Question string   `json:"question"`
+		Notes    []string `json:"notes"`
+		Passed   bool     `json:"passed"`
+		Records  int      `json:"records"`
+	} `json:"steps"`
+	Rcode      string   `json:"rcode"`
+	Answer     []string `json:"answer"`
+	Authority  []string `json:"authority"`
+	Additional []string `json:"additional"`
+}
+
+// cmdTrace explains how the server would answer a question, handler by
+// handler, without the question being sent anywhere
+func cmdTrace(args []string) error {
+	flags := flag.NewFlagSet("trace", flag.ExitOnError)
+	client := flags.String("client", "", "Address of the client asking, for policies, profiles and the captive portal.")
+	dnssec := flags.Bool("dnssec", false, "Ask as a client setting the DO bit.")
+	// Flags may follow the name and type
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		return fmt.Errorf("expected a name and optionally a type")
+	}
+	query := url.Values{"name": {positional[0]}, "client": {*client}, "dnssec": {fmt.Sprint(*dnssec)}}
+	if len(positional) == 2 {
+		query.Set("type", positional[1])
+	}
+
+	var trace queryTrace
+	if err := apiGet("/api/dns/trace", query, &trace); err != nil {
+		return err
+	}
+	from := ""
+	if trace.Client != "" {
+		from = " from " + trace.Client
+	}
+	fmt.Printf("%s %s%s\n\n", trace.Name, trace.Type, from)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "HANDLER\tQUESTION\tRESULT\tWHY")
+	for _, step := range trace.Steps {
+		result := "passed on"
+		switch {
+		case step.Passed && step.Records > 0:
+			result = fmt.Sprintf("passed on, %d records back", step.Records)
+		case !step.Passed:
+			result = fmt.Sprintf("answered, %d records", step.Records)
+		}
+		notes := step.Notes
+		if len(notes) == 0 {
+			notes = []string{""}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", step.Handler, step.Question, result, notes[0])
+		for _, note := range notes[1:] {
+			fmt.Fprintf(w, "\t\t\t%s\n", note)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nResponse: %s\n", trace.Rcode)
+	for _, section := range []struct {
+		name string
+		rrs  []string
+	}{{"Answer", trace.Answer}, {"Authority", trace.Authority}, {"Additional", trace.Additional}} {
+		if len(section.rrs) > 0 {
+			fmt.Printf("\n%s:\n  %s\n", section.name, strings.Join(section.rrs, "\n  "))
+		}
+	}
+	return nil
+}
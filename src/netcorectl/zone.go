@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// zoneProblem matches a problem found by the zone check of the admin API
+type zoneProblem struct {
+	Severity string `json:"severity"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Message  string `json:"message"`
+}
+
+// zoneRecord matches a record of the admin API
+type zoneRecord struct {
+	Name  string            `json:"name"`
+	Type  string            `json:"type"`
+	TTL   uint32            `json:"ttl,omitempty"`
+	Value string            `json:"value,omitempty"`
+	Attr  map[string]string `json:"attr,omitempty"`
+}
+
+// recordVersion matches a change to the records of a name and type, as the
+// admin API lists them
+type recordVersion struct {
+	Time  time.Time    `json:"time"`
+	Actor string       `json:"actor"`
+	Name  string       `json:"name"`
+	Type  string       `json:"type"`
+	Old   []zoneRecord `json:"old"`
+	New   []zoneRecord `json:"new"`
+}
+
+func cmdZone(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected a subcommand: check, history or restore")
+	}
+	switch args[0] {
+	case "check":
+		return cmdZoneCheck(args[1:])
+	case "history":
+		return cmdZoneHistory(args[1:])
+	case "restore":
+		return cmdZoneRestore(args[1:])
+	}
+	return fmt.Errorf("unknown subcommand %q", args[0])
+}
+
+// cmdZoneCheck lists the problems of a zone, and fails if any is an error
+func cmdZoneCheck(args []string) error {
+	flags := flag.NewFlagSet("zone check", flag.ExitOnError)
+	warnings := flags.Bool("warnings", true, "Show warnings as well as errors.")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one zone to check")
+	}
+	zone := strings.TrimSuffix(flags.Arg(0), ".")
+
+	var problems []zoneProblem
+	if err := apiGet("/api/dns/zones/"+zone+"/check", url.Values{"unicode": {"true"}}, &problems); err != nil {
+		return err
+	}
+	errors := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SEVERITY\tNAME\tTYPE\tPROBLEM")
+	for _, p := range problems {
+		if p.Severity == "error" {
+			errors++
+		} else if !*warnings {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Severity, p.Name, p.Type, p.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if errors > 0 {
+		return fmt.Errorf("zone %s has %d errors", zone, errors)
+	}
+	return nil
+}
+
+// cmdZoneHistory lists the changes to the records of a zone, or of one name
+// and type, oldest first
+func cmdZoneHistory(args []string) error {
+	flags := flag.NewFlagSet("zone history", flag.ExitOnError)
+	name := flags.String("name", "", "Only the changes to this name.")
+	rrType := flags.String("type", "", "Only the changes to this type, with -name.")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one zone")
+	}
+	zone := strings.TrimSuffix(flags.Arg(0), ".")
+
+	var versions []recordVersion
+	if err := apiGet("/api/dns/zones/"+zone+"/history", url.Values{"name": {*name}, "type": {*rrType}, "unicode": {"true"}}, &versions); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTOR\tNAME\tTYPE\tBEFORE\tAFTER")
+	for _, v := range versions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Time.Local().Format(time.RFC3339), v.Actor, v.Name, v.Type, describeRecords(v.Old), describeRecords(v.New))
+	}
+	return w.Flush()
+}
+
+// describeRecords summarizes the values of records on one line
+func describeRecords(records []zoneRecord) string {
+	if len(records) == 0 {
+		return "-"
+	}
+	values := make([]string, 0, len(records))
+	for _, r := range records {
+		value := r.Value
+		keys := make([]string, 0, len(r.Attr))
+		for k := range r.Attr {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			value += " " + k + "=" + r.Attr[k]
+		}
+		if r.TTL > 0 {
+			value += fmt.Sprintf(" ttl=%d", r.TTL)
+		}
+		values = append(values, strings.TrimSpace(value))
+	}
+	return strings.Join(values, ", ")
+}
+
+// cmdZoneRestore puts the records of a zone, or of one name and type, back
+// as they were at a time
+func cmdZoneRestore(args []string) error {
+	flags := flag.NewFlagSet("zone restore", flag.ExitOnError)
+	at := flags.String("time", "", "When to restore to: an RFC 3339 time, or a duration such as 2h for that long ago.")
+	name := flags.String("name", "", "Restore only this name.")
+	rrType := flags.String("type", "", "Restore only this type, with -name.")
+	force := flags.Bool("force", false, "Restore even records that the zone check finds errors in.")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected one zone")
+	}
+	zone := strings.TrimSuffix(flags.Arg(0), ".")
+	t, err := time.Parse(time.RFC3339, *at)
+	if err != nil {
+		ago, durationErr := time.ParseDuration(*at)
+		if durationErr != nil {
+			return fmt.Errorf("-time must be an RFC 3339 time or a duration, not %q", *at)
+		}
+		t = time.Now().Add(-ago)
+	}
+
+	body := map[string]interface{}{"time": t, "name": *name, "type": *rrType}
+	var query url.Values
+	if *force {
+		query = url.Values{"force": {"true"}}
+	}
+	var result struct {
+		Applied int `json:"applied"`
+	}
+	if err := apiDo("POST", "/api/dns/zones/"+zone+"/restore", query, body, &result); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s as of %s: %d changes\n", zone, t.Local().Format(time.RFC3339), result.Applied)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+)
+
+// cmdZoneStats shows each zone's queries, error ratios and latency over a
+// window, as a table, or as the API's JSON or CSV for reports
+func cmdZoneStats(args []string) error {
+	flags := flag.NewFlagSet("zonestats", flag.ExitOnError)
+	window := flags.String("window", "1h", "Window to report on, up to 24h.")
+	format := flags.String("format", "table", "Output as a table, json or csv.")
+	flags.Parse(args)
+
+	query := url.Values{"window": {*window}}
+	if *format != "table" {
+		query.Set("format", *format)
+		return apiGet("/api/dns/zonestats", query, os.Stdout)
+	}
+	var stats struct {
+		Window string `json:"window"`
+		Zones  []struct {
+			Zone          string  `json:"zone"`
+			Queries       int64   `json:"queries"`
+			NXDomainRatio float64 `json:"nxdomain_ratio"`
+			ServFailRatio float64 `json:"servfail_ratio"`
+			P50           float64 `json:"p50_ms"`
+			P90           float64 `json:"p90_ms"`
+			P99           float64 `json:"p99_ms"`
+		} `json:"zones"`
+	}
+	if err := apiGet("/api/dns/zonestats", query, &stats); err != nil {
+		return err
+	}
+
+	fmt.Printf("DNS traffic by zone over the last %s (\".\" is everything else)\n\n", stats.Window)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ZONE\tQUERIES\tNX%\tSERVFAIL%\tP50 MS\tP90 MS\tP99 MS")
+	for _, z := range stats.Zones {
+		fmt.Fprintf(w, "%s\t%d\t%.1f\t%.2f\t%g\t%g\t%g\n", z.Zone, z.Queries, z.NXDomainRatio*100, z.ServFailRatio*100, z.P50, z.P90, z.P99)
+	}
+	return w.Flush()
+}
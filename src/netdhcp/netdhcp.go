@@ -0,0 +1,87 @@
+// Package netdhcp runs the netcore DHCP service inside another program, with
+// that program's database and logger.
+//
+//	server, err := netdhcp.New(db, nil)
+//	if err != nil {
+//		return err
+//	}
+//	return server.ListenAndServe()
+package netdhcp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/krolaw/dhcp4"
+	"netcore"
+)
+
+// ErrNoInstances is returned when the configuration serves DHCP nowhere
+var ErrNoInstances = errors.New("DHCP is not configured on any interface of this host.")
+
+// Server serves DHCP from a netcore database
+type Server struct {
+	cfg *netcore.Config
+}
+
+// New prepares to serve DHCP as db's configuration for this host says. A nil
+// logger leaves netcore logging where it does.
+func New(db netcore.DB, logger *log.Logger) (*Server, error) {
+	if logger != nil {
+		netcore.SetLogger(logger)
+	}
+	cfg, err := db.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &Server{cfg: cfg}, nil
+}
+
+// Service serves DHCP on every configured interface, for a
+// netcore.Supervisor to start, restart and stop alongside the program's
+// other services; add it after the "dns" service, which it registers leases
+// in
+func (s *Server) Service() netcore.Service {
+	return netcore.NewDHCPServers(s.cfg)
+}
+
+// Interfaces are the network interfaces that the configuration serves DHCP
+// on
+func (s *Server) Interfaces() []string {
+	var nics []string
+	for _, instance := range s.cfg.DHCPInstances() {
+		nics = append(nics, instance.NIC)
+	}
+	return nics
+}
+
+// Handler serves DHCP for the named interface, for programs that read and
+// write the packets themselves with dhcp4.Serve
+func (s *Server) Handler(nic string) (dhcp4.Handler, error) {
+	for _, instance := range s.cfg.DHCPInstances() {
+		if instance.NIC == nic {
+			return netcore.NewDHCPHandler(s.cfg, instance)
+		}
+	}
+	return nil, fmt.Errorf("DHCP is not configured on %s", nic)
+}
+
+// ListenAndServe serves DHCP on every configured interface until one fails
+func (s *Server) ListenAndServe() error {
+	nics := s.Interfaces()
+	if len(nics) == 0 {
+		return ErrNoInstances
+	}
+	errs := make(chan error, len(nics))
+	for _, nic := range nics {
+		handler, err := s.Handler(nic)
+		if err != nil {
+			return err
+		}
+		go func(nic string, handler dhcp4.Handler) {
+			errs <- fmt.Errorf("%s: %s", nic, netcore.ServeDHCPIf(nic, handler))
+		}(nic, handler)
+	}
+	return <-errs
+}
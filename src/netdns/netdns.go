@@ -0,0 +1,67 @@
+// Package netdns runs the netcore DNS service inside another program, with
+// that program's database and logger.
+//
+//	db := netcore.NewEtcdDB("http://127.0.0.1:2379")
+//	server, err := netdns.New(db, log.New(os.Stderr, "dns: ", log.LstdFlags))
+//	if err != nil {
+//		return err
+//	}
+//	return server.ListenAndServe(":53")
+//
+// Any netcore.DB will do, such as netcore.NewMemoryDB or one of the
+// program's own whose GetConfig uses netcore.LoadConfig.
+package netdns
+
+import (
+	"log"
+
+	"github.com/miekg/dns"
+	"netcore"
+)
+
+// Server answers DNS queries from a netcore database
+type Server struct {
+	cfg     *netcore.Config
+	handler dns.Handler
+}
+
+// New prepares to answer DNS queries as db's configuration for this host
+// says. A nil logger leaves netcore logging where it does.
+func New(db netcore.DB, logger *log.Logger) (*Server, error) {
+	if logger != nil {
+		netcore.SetLogger(logger)
+	}
+	cfg, err := db.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	handler, err := netcore.NewDNSHandler(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{cfg: cfg, handler: handler}, nil
+}
+
+// Service serves DNS on addr, over UDP and TCP, for a netcore.Supervisor to
+// start, restart and stop alongside the program's other services
+func (s *Server) Service(addr string) netcore.Service {
+	return netcore.NewDNSService(s.cfg, addr)
+}
+
+// ServeDNS answers a query, so that the server can be mounted on a program's
+// own dns.ServeMux or dns.Server
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	s.handler.ServeDNS(w, req)
+}
+
+// ListenAndServe answers queries over UDP and TCP on addr until either
+// fails
+func (s *Server) ListenAndServe(addr string) error {
+	errs := make(chan error, 2)
+	for _, network := range []string{"udp", "tcp"} {
+		go func(network string) {
+			errs <- (&dns.Server{Addr: addr, Net: network, Handler: s}).ListenAndServe()
+		}(network)
+	}
+	return <-errs
+}